@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/cli"
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/logging"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+)
+
+// runChat implements `openkh chat [flags] [prompt]`: a terminal client
+// sharing the bot's configuration and store. With a prompt it answers it
+// and exits; otherwise each line of stdin is a prompt.
+func runChat(args []string) {
+	fs := flag.NewFlagSet("openkh chat", flag.ExitOnError)
+	var flags config.Flags
+	fs.StringVar(&flags.OpenCodeURL, "opencode-url", "", "OpenCode server URL (overrides OPENCODE_URL)")
+	fs.StringVar(&flags.DBPath, "db", "", "SQLite database path (overrides DB_PATH/DATA_DIR)")
+	logLevel := fs.String("log-level", "error", "debug, info, warn or error; logs go to stderr")
+	chatID := fs.Int64("chat", 0, "store key of the session; a Telegram chat ID continues that chat's session")
+	dir := fs.String("dir", "", "working directory of a new session (default WORK_DIR)")
+	agent := fs.String("agent", "", "agent to use (default: the session's, then DEFAULT_AGENT)")
+	model := fs.String("model", "", "provider/model to use (default: the session's, then DEFAULT_MODEL)")
+	approve := fs.Bool("yes", false, "approve tool permission requests instead of rejecting them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: openkh chat [flags] [prompt]\n\n"+
+			"Without a prompt, each line of stdin is sent; /new starts a new session, /quit exits.\n"+
+			"Configuration is read from the same environment as the bot (see openkh -help).\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *model != "" {
+		if _, _, ok := config.SplitModel(*model); !ok {
+			log.Fatalf("Invalid -model %q: expected provider/model", *model)
+		}
+	}
+
+	cfg := config.LoadChatConfig()
+	cfg.ApplyFlags(&flags)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	db, err := store.Open(store.Options{
+		Driver:   cfg.DBDriver,
+		Path:     cfg.DBPath,
+		RedisURL: cfg.RedisURL,
+	})
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	if cfg.PrivacyMode {
+		db = store.PrivateMessages(db)
+	}
+	defer db.Close()
+
+	tlsConfig, err := cfg.OpenCodeTLS.Config()
+	if err != nil {
+		log.Fatalf("Invalid OpenCode TLS settings: %v", err)
+	}
+	client := opencode.NewClient(cfg.OpenCodeURL, opencode.ClientOptions{
+		Timeout:         cfg.HTTPTimeout,
+		LongTimeout:     cfg.HTTPLongTimeout,
+		IdleConnTimeout: cfg.HTTPIdleTimeout,
+		MaxIdleConns:    cfg.HTTPMaxIdle,
+		Debug:           level == logging.LevelDebug,
+		Private:         cfg.PrivacyMode,
+		APIKey:          cfg.OpenCodeKey,
+		Proxy:           cfg.OpenCodeProxy,
+		TLS:             tlsConfig,
+	})
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := client.Health(ctx); err != nil {
+		log.Fatalf("OpenCode server not healthy: %v", err)
+	}
+	// Startup failures above are always shown; from here on only lines
+	// at -log-level reach the terminal.
+	logging.SetLevel(level)
+
+	stdin, _ := os.Stdin.Stat()
+	chat := cli.New(cfg, client, db, cli.Options{
+		ChatID:      *chatID,
+		Dir:         *dir,
+		Agent:       *agent,
+		Model:       *model,
+		Approve:     *approve,
+		Interactive: stdin != nil && stdin.Mode()&os.ModeCharDevice != 0,
+		Out:         os.Stdout,
+		Status:      os.Stderr,
+	})
+	// A terminal has no edit rate limit or message size cap, and no
+	// other replica to share the stream with.
+	stream := opencode.NewStreamManager(cfg.OpenCodeURL, chat, opencode.StreamOptions{
+		IdleTimeout:     cfg.SSEIdleTimeout,
+		APIKey:          cfg.OpenCodeKey,
+		Proxy:           cfg.OpenCodeProxy,
+		TLS:             tlsConfig,
+		EditThrottle:    50 * time.Millisecond,
+		DisableProgress: true,
+		MaxMessageLen:   1 << 20,
+		EventLogSize:    cfg.EventLogSize,
+		Archive:         db,
+		Notifier:        chat,
+		Guard:           chat,
+		Private:         cfg.PrivacyMode,
+	})
+	chat.Stream = stream
+	go func() {
+		if err := stream.Start(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("StreamManager stopped: %v", err)
+		}
+	}()
+
+	if err := chat.Run(ctx, strings.Join(fs.Args(), " "), os.Stdin); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "openkh chat: %v\n", err)
+		db.Close()
+		os.Exit(1)
+	}
+}
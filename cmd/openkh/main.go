@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // zone names for /tz in images without zoneinfo
+
+	"github.com/go-telegram/bot"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/forge"
+	"github.com/Khaledxab/Openkh/internal/gist"
+	"github.com/Khaledxab/Openkh/internal/logging"
+	"github.com/Khaledxab/Openkh/internal/mailer"
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/Khaledxab/Openkh/internal/ocserver"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/scheduler"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/Khaledxab/Openkh/internal/teams"
+	"github.com/Khaledxab/Openkh/internal/telegram"
+	"github.com/Khaledxab/Openkh/internal/tracker"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "chat" {
+		runChat(os.Args[2:])
+		return
+	}
+
+	flags := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg := config.LoadConfig()
+	cfg.ApplyFlags(flags)
+	if err := cfg.Validate(); err != nil {
+		if flags.CheckConfig {
+			cfg.WriteSummary(os.Stdout)
+			fmt.Fprintf(os.Stderr, "\nConfiguration invalid:\n%v\n", err)
+			os.Exit(1)
+		}
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if flags.CheckConfig {
+		cfg.WriteSummary(os.Stdout)
+		fmt.Println("\nConfiguration OK")
+		return
+	}
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.SetLevel(level)
+	if level == logging.LevelDebug {
+		cfg.HTTPDebug = true
+	}
+	telegram.LogConfig(cfg)
+
+	if err := errreport.Setup(errreport.Options{
+		SentryDSN:   cfg.SentryDSN,
+		WebhookURL:  cfg.ErrorWebhook,
+		Environment: cfg.ErrorEnv,
+	}); err != nil {
+		log.Fatalf("Invalid error reporting settings: %v", err)
+	}
+
+	db, err := store.Open(store.Options{
+		Driver:   cfg.DBDriver,
+		Path:     cfg.DBPath,
+		RedisURL: cfg.RedisURL,
+	})
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	db = store.Instrument(db, cfg.SlowQuery)
+	if cfg.PrivacyMode {
+		db = store.PrivateMessages(db)
+	}
+	defer db.Close()
+
+	if cfg.MetricsAddr != "" {
+		go serveMetrics(cfg.MetricsAddr)
+	}
+
+	tlsConfig, err := cfg.OpenCodeTLS.Config()
+	if err != nil {
+		log.Fatalf("Invalid OpenCode TLS settings: %v", err)
+	}
+
+	client := newClient(cfg, tlsConfig)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// With OPENCODE_SERVICE=exec or serve the server is the bot's child,
+	// so it is started and given time to come up before anything talks
+	// to it.
+	server, err := cfg.OpenCodeServer()
+	if err != nil {
+		log.Fatalf("Invalid OpenCode service settings: %v", err)
+	}
+	if server != nil {
+		if err := server.Start(ctx); err != nil {
+			log.Fatalf("Failed to start OpenCode: %v", err)
+		}
+		defer server.Stop()
+	}
+
+	healthErr := client.Health(ctx)
+	if healthErr != nil && server != nil {
+		healthErr = ocserver.WaitHealthy(ctx, client.Health, 30*time.Second)
+	}
+	if healthErr != nil {
+		log.Printf("Warning: OpenCode server not healthy: %v", healthErr)
+	}
+
+	// Phase 1: handlers are registered before the Telegram bot exists,
+	// so the stream manager is injected afterwards.
+	tgHandler := telegram.New(cfg, client, db, nil)
+	tgHandler.Server = server
+	if cfg.SMTPURL != "" {
+		m, err := mailer.New(cfg.SMTPURL, cfg.SMTPFrom)
+		if err != nil {
+			log.Fatalf("Invalid SMTP settings: %v", err)
+		}
+		tgHandler.Mailer = m
+	}
+	if cfg.ForgeType != "" {
+		f, err := forge.New(cfg.ForgeType, cfg.ForgeURL, cfg.ForgeToken)
+		if err != nil {
+			log.Fatalf("Invalid forge settings: %v", err)
+		}
+		tgHandler.Forge = f
+	}
+	if cfg.IssueTracker != "" {
+		t, err := tracker.New(cfg.IssueTracker, cfg.IssueTrackerURL, cfg.IssueTrackerToken)
+		if err != nil {
+			log.Fatalf("Invalid issue tracker settings: %v", err)
+		}
+		tgHandler.Tracker = t
+	}
+	if cfg.GistToken != "" {
+		g, err := gist.New(cfg.GistAPIURL, cfg.GistToken)
+		if err != nil {
+			log.Fatalf("Invalid gist settings: %v", err)
+		}
+		tgHandler.Gists = g
+	}
+
+	tgHTTP := telegram.NewHTTPClient(cfg.TelegramProxy)
+	opts := append(tgHandler.RegisterHandlers(), telegram.HTTPClientOption(tgHTTP))
+	if cfg.WebhookSecret != "" {
+		opts = append(opts, bot.WithWebhookSecretToken(cfg.WebhookSecret))
+	}
+	tgBot, err := bot.New(cfg.TelegramToken, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create Telegram bot: %v", err)
+	}
+
+	// Phase 2: wire the stream manager back into the handlers.
+	// Streamed output goes through one rate-limited queue shared by all chats.
+	sender := telegram.NewSendQueue(&telegram.TelegramSender{Bot: tgBot, Silent: tgHandler.Muted, Buttons: tgHandler.StreamButtons}, cfg.SendRate)
+	go sender.Run(ctx)
+	// Leases make sure only one replica streams a given session.
+	leases := store.NewLeaseManager(db, cfg.InstanceID, cfg.LeaseTTL)
+	var out opencode.MessageSender = tgHandler.RouteAPI(sender)
+	streamOpts := streamOptions(cfg, tlsConfig, tgHandler, tgBot, leases, db)
+	// Teams conversations share the stream; their output has its own
+	// queue, as the Bot Connector is slower than Telegram.
+	var teamsBot *teams.Bot
+	if cfg.TeamsAppID != "" {
+		teamsBot = teams.New(cfg, client, db)
+		teamsBot.Locked = tgHandler.SessionLocked
+		teamsQueue := telegram.NewSendQueue(teamsBot, cfg.SendRate)
+		go teamsQueue.Run(ctx)
+		out = teams.Route(teamsQueue, out)
+		streamOpts.Notifier, streamOpts.Guard = teamsBot.Notifier(streamOpts.Notifier), teamsBot.Guard(streamOpts.Guard)
+		streamOpts.Questions = teamsBot.Questions(streamOpts.Questions)
+	}
+	stream := opencode.NewStreamManager(cfg.OpenCodeURL, out, streamOpts)
+	tgHandler.Stream = stream
+	sender.SetFeedback(tgHandler.Feedback())
+	tgHandler.ResumeFollows()
+	if teamsBot != nil {
+		teamsBot.Stream = stream
+		go serveTeams(ctx, cfg.TeamsListen, teamsBot.Handler())
+	}
+
+	tgHandler.RegisterBotCommands(tgHTTP, cfg.TelegramToken)
+
+	go reloadOnHangup(ctx, tgHandler)
+
+	jobs := scheduler.New()
+	for _, job := range tgHandler.MaintenanceJobs(tgBot) {
+		jobs.Register(job)
+	}
+	tgHandler.Jobs = jobs
+
+	// Chats of a tenant are served by a bot of their own on the tenant's
+	// OpenCode instance; tgHandler hands their updates over.
+	for _, name := range cfg.TenantNames() {
+		t := startTenant(ctx, cfg, name, tlsConfig, db, tgHTTP, sender, leases)
+		defer t.stop()
+		t.handler.Mailer, t.handler.Forge = tgHandler.Mailer, tgHandler.Forge
+		t.handler.Tracker, t.handler.Gists = tgHandler.Tracker, tgHandler.Gists
+		t.handler.Jobs = jobs
+		for _, job := range t.handler.MaintenanceJobs(t.tgBot) {
+			jobs.Register(job)
+		}
+		tgHandler.AddTenant(name, t.handler, t.tgBot)
+	}
+	jobs.Start(ctx)
+
+	go runStream(ctx, stream)
+
+	if cfg.APIListen != "" {
+		go serveAPI(ctx, cfg.APIListen, tgHandler.APIHandler())
+	}
+
+	if cfg.BootSelfTest {
+		go tgHandler.BootSelfTest(ctx, tgBot)
+	}
+
+	if cfg.WebhookURL != "" {
+		runWebhook(ctx, tgBot, cfg)
+	} else {
+		// A webhook left over from an earlier run would make polling fail.
+		if _, err := tgBot.DeleteWebhook(ctx, &bot.DeleteWebhookParams{}); err != nil {
+			log.Printf("Warning: could not delete webhook: %v", err)
+		}
+		log.Println("Bot started (long polling)")
+		tgBot.Start(ctx)
+	}
+	leases.Handoff()
+	jobs.Wait()
+	log.Println("Bot stopped")
+}
+
+// newClient returns the client of the OpenCode server at cfg.OpenCodeURL.
+func newClient(cfg *config.Config, tlsConfig *tls.Config) *opencode.Client {
+	return opencode.NewClient(cfg.OpenCodeURL, opencode.ClientOptions{
+		Timeout:         cfg.HTTPTimeout,
+		LongTimeout:     cfg.HTTPLongTimeout,
+		IdleConnTimeout: cfg.HTTPIdleTimeout,
+		MaxIdleConns:    cfg.HTTPMaxIdle,
+		Debug:           cfg.HTTPDebug,
+		Private:         cfg.PrivacyMode,
+		CacheTTL:        cfg.ListCacheTTL,
+		APIKey:          cfg.OpenCodeKey,
+		Proxy:           cfg.OpenCodeProxy,
+		TLS:             tlsConfig,
+	})
+}
+
+// streamOptions returns the settings of a stream manager whose chats h
+// serves, with h's hooks sending through tgBot.
+func streamOptions(cfg *config.Config, tlsConfig *tls.Config, h *telegram.Bot, tgBot *bot.Bot, leases *store.LeaseManager, db store.Store) opencode.StreamOptions {
+	return opencode.StreamOptions{
+		IdleTimeout:     cfg.SSEIdleTimeout,
+		APIKey:          cfg.OpenCodeKey,
+		Proxy:           cfg.OpenCodeProxy,
+		TLS:             tlsConfig,
+		EditThrottle:    cfg.EditThrottle,
+		DisableProgress: !cfg.StreamProgress,
+		StallWarning:    cfg.StallWarning,
+		MaxMessageLen:   cfg.MaxMessageLen,
+		EventLogSize:    cfg.EventLogSize,
+		Ownership:       leases,
+		Archive:         db,
+		Notifier:        h.Notifier(tgBot),
+		Guard:           h.Guard(tgBot),
+		Questions:       h.Questions(tgBot),
+		Usage:           h.Usage(),
+		Files:           h.Files(tgBot),
+		Reasoning:       h.Reasoning(),
+		Modes:           h.Modes(),
+		Postprocess:     h.Postprocessor(),
+		Private:         cfg.PrivacyMode,
+	}
+}
+
+// runStream runs a stream manager until ctx is cancelled.
+func runStream(ctx context.Context, stream *opencode.StreamManager) {
+	defer errreport.Recover(errreport.Fields{"goroutine": "stream"})
+	if err := stream.Start(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("StreamManager stopped: %v", err)
+	}
+}
+
+// tenantBot is the bot serving the chats of a tenant.
+type tenantBot struct {
+	handler *telegram.Bot
+	tgBot   *bot.Bot
+	server  ocserver.Server // nil unless the bot runs the tenant's OpenCode
+}
+
+func (t *tenantBot) stop() {
+	if t.server != nil {
+		t.server.Stop()
+	}
+}
+
+// startTenant starts the bot of tenant name: an OpenCode client and
+// stream of its own, its server process when the bot runs it, and a
+// Telegram client of the same bot with its handlers. Its replies share
+// sender, and its streams the leases, with the default bot.
+func startTenant(ctx context.Context, cfg *config.Config, name string, tlsConfig *tls.Config, db store.Store,
+	tgHTTP *http.Client, sender *telegram.SendQueue, leases *store.LeaseManager) *tenantBot {
+	tcfg := cfg.ForTenant(name)
+	client := newClient(tcfg, tlsConfig)
+	t := &tenantBot{server: cfg.TenantServer(name)}
+	if t.server != nil {
+		if err := t.server.Start(ctx); err != nil {
+			log.Fatalf("Failed to start OpenCode for tenant %s: %v", name, err)
+		}
+		if err := ocserver.WaitHealthy(ctx, client.Health, 30*time.Second); err != nil {
+			log.Printf("Warning: OpenCode of tenant %s not healthy: %v", name, err)
+		}
+	} else if err := client.Health(ctx); err != nil {
+		log.Printf("Warning: OpenCode of tenant %s not healthy: %v", name, err)
+	}
+
+	t.handler = telegram.New(tcfg, client, db, nil)
+	t.handler.Server = t.server
+	opts := append(t.handler.RegisterHandlers(), telegram.HTTPClientOption(tgHTTP),
+		bot.WithSkipGetMe(), bot.WithNotAsyncHandlers())
+	tgBot, err := bot.New(cfg.TelegramToken, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create Telegram bot of tenant %s: %v", name, err)
+	}
+	t.tgBot = tgBot
+
+	stream := opencode.NewStreamManager(tcfg.OpenCodeURL, t.handler.RouteAPI(sender), streamOptions(tcfg, tlsConfig, t.handler, tgBot, leases, db))
+	t.handler.Stream = stream
+	t.handler.ResumeFollows()
+	go runStream(ctx, stream)
+	log.Printf("Tenant %s: OpenCode at %s, %d chat(s)", name, tcfg.OpenCodeURL, countChats(cfg, name))
+	return t
+}
+
+// countChats returns how many chats TENANT_CHATS gives to tenant name.
+func countChats(cfg *config.Config, name string) int {
+	n := 0
+	for _, tenant := range cfg.TenantChats {
+		if tenant == name {
+			n++
+		}
+	}
+	return n
+}
+
+// reloadOnHangup reloads the allowlists and agents on every SIGHUP.
+func reloadOnHangup(ctx context.Context, tgHandler *telegram.Bot) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := tgHandler.Reload(); err != nil {
+				log.Printf("Reload failed, keeping previous settings: %v", err)
+			}
+		}
+	}
+}
+
+// runWebhook registers the webhook with Telegram and serves updates on
+// cfg.WebhookListen until ctx is cancelled.
+func runWebhook(ctx context.Context, tgBot *bot.Bot, cfg *config.Config) {
+	if _, err := tgBot.SetWebhook(ctx, &bot.SetWebhookParams{
+		URL:         cfg.WebhookURL,
+		SecretToken: cfg.WebhookSecret,
+	}); err != nil {
+		log.Fatalf("Failed to set webhook: %v", err)
+	}
+
+	var path string
+	if u, err := url.Parse(cfg.WebhookURL); err == nil && u.Path != "" {
+		path = u.Path
+	}
+	handler := telegram.GuardWebhook(tgBot.WebhookHandler(), telegram.WebhookOptions{
+		Path:           path,
+		Secret:         cfg.WebhookSecret,
+		AllowedIPs:     cfg.WebhookAllowedIPs,
+		TrustedProxies: cfg.WebhookTrustedProxies,
+		MaxBody:        cfg.WebhookMaxBody,
+	})
+	srv := &http.Server{
+		Addr:              cfg.WebhookListen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    16 << 10,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	go tgBot.StartWebhook(ctx)
+
+	log.Printf("Bot started (webhook %s, listening on %s)", cfg.WebhookURL, cfg.WebhookListen)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Webhook server failed: %v", err)
+	}
+}
+
+// serveAPI serves the chat API until ctx is cancelled. Replies stream for
+// as long as they take, so there is no write timeout.
+func serveAPI(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    16 << 10,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	log.Printf("Serving chat API on %s/v1/chat", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Chat API server stopped: %v", err)
+	}
+}
+
+// serveTeams serves the Bot Framework messaging endpoint until ctx is
+// cancelled.
+func serveTeams(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    16 << 10,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	log.Printf("Serving Microsoft Teams messaging endpoint on %s/api/messages", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Teams endpoint stopped: %v", err)
+	}
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	log.Printf("Serving metrics on %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Metrics server stopped: %v", err)
+	}
+}
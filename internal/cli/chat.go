@@ -0,0 +1,240 @@
+// Package cli talks to OpenCode from a terminal. It drives the same
+// opencode.Client and StreamManager as the Telegram bot and keeps its
+// session in the same store, so `openkh chat` works for local testing and
+// scripting without a bot token.
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+)
+
+// replyMessageID stands in for the Telegram message a reply streams into.
+const replyMessageID = 1
+
+// connectTimeout is how long Run waits for the SSE stream before the
+// first prompt, whose events would otherwise be missed.
+const connectTimeout = 10 * time.Second
+
+// Options configures a terminal chat.
+type Options struct {
+	// ChatID keys the session in the store. A Telegram chat ID continues
+	// that chat's session.
+	ChatID int64
+	// Dir is the working directory of new sessions; empty uses WORK_DIR.
+	Dir string
+	// Agent and Model ("provider/model") override the session's stored
+	// choice; empty keeps it, falling back to DEFAULT_AGENT/DEFAULT_MODEL.
+	Agent string
+	Model string
+	// Approve answers tool permission requests with "once" instead of
+	// rejecting them.
+	Approve bool
+	// Interactive prints a prompt marker before each line is read.
+	Interactive bool
+	// Out receives the replies; Status receives status lines, permission
+	// decisions and the prompt marker.
+	Out    io.Writer
+	Status io.Writer
+}
+
+// Chat sends prompts to one OpenCode session and prints the streamed
+// replies. It is the StreamManager's MessageSender, CompletionNotifier and
+// ToolGuard.
+type Chat struct {
+	Client *opencode.Client
+	Stream *opencode.StreamManager
+	DB     store.Store
+
+	opts            Options
+	defaultAgent    string
+	defaultProvider string
+	defaultModel    string
+
+	mu      sync.Mutex
+	printed string        // reply text already written to Out
+	status  string        // status line last written to Status
+	done    chan struct{} // closed when the current reply completes
+}
+
+// New creates a Chat. The stream manager is set afterwards, as it needs
+// the Chat as its sender.
+func New(cfg *config.Config, client *opencode.Client, db store.Store, opts Options) *Chat {
+	if opts.Dir == "" {
+		opts.Dir = cfg.WorkDir
+	}
+	c := &Chat{Client: client, DB: db, opts: opts, defaultAgent: cfg.DefaultAgent}
+	c.defaultProvider, c.defaultModel, _ = config.SplitModel(cfg.DefaultModel)
+	return c
+}
+
+// Run sends prompt if it is set, otherwise every line read from in, and
+// returns at the end of input. "/new" starts a fresh session and "/quit"
+// ends the chat.
+func (c *Chat) Run(ctx context.Context, prompt string, in io.Reader) error {
+	if err := c.waitConnected(ctx); err != nil {
+		return err
+	}
+	if prompt != "" {
+		return c.Prompt(ctx, prompt)
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for {
+		if c.opts.Interactive {
+			fmt.Fprint(c.opts.Status, "> ")
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "/quit", "/exit":
+			return nil
+		case "/new":
+			if err := c.DB.DeleteSession(c.opts.ChatID); err != nil {
+				return fmt.Errorf("reset session: %w", err)
+			}
+			fmt.Fprintln(c.opts.Status, "Started a new session.")
+			continue
+		}
+		if err := c.Prompt(ctx, line); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Chat) waitConnected(ctx context.Context) error {
+	deadline := time.Now().Add(connectTimeout)
+	for !c.Stream.Connected() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no event stream from OpenCode after %s", connectTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// Prompt sends text to the chat's session, creating one if needed, and
+// waits for the reply to finish streaming. Cancelling ctx aborts it.
+func (c *Chat) Prompt(ctx context.Context, text string) error {
+	sess, err := c.session(ctx)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.printed, c.status, c.done = "", "", done
+	c.mu.Unlock()
+	c.Stream.RegisterSession(sess.SessionID, c.opts.ChatID, replyMessageID)
+
+	agent, providerID, modelID := c.choices(sess)
+	opts := opencode.PromptOptions{Agent: agent, ProviderID: providerID, ModelID: modelID}
+	if err := c.Client.PromptAsync(ctx, sess.SessionID, text, opts); err != nil {
+		c.Stream.UnregisterSession(sess.SessionID)
+		return fmt.Errorf("send prompt: %w", err)
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if err := c.Client.Abort(context.Background(), sess.SessionID); err != nil {
+			log.Printf("[cli] Error aborting session %s: %v", sess.SessionID, err)
+		}
+		c.Stream.UnregisterSession(sess.SessionID)
+		return ctx.Err()
+	}
+}
+
+// session returns the chat's stored session, creating a new OpenCode
+// session when there is none.
+func (c *Chat) session(ctx context.Context) (store.Session, error) {
+	sess, err := c.DB.GetSession(c.opts.ChatID)
+	if err == nil {
+		if err := c.DB.IncrementCount(c.opts.ChatID); err != nil {
+			log.Printf("[cli] Error counting message: %v", err)
+		}
+		return sess, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return store.Session{}, fmt.Errorf("load session: %w", err)
+	}
+
+	oc, err := c.Client.CreateOCSession(ctx, fmt.Sprintf("Terminal Chat %d", c.opts.ChatID), c.opts.Dir)
+	if err != nil {
+		return store.Session{}, fmt.Errorf("create session: %w", err)
+	}
+	now := time.Now()
+	sess = store.Session{
+		ChatID:       c.opts.ChatID,
+		SessionID:    oc.ID,
+		Title:        oc.Title,
+		MessageCount: 1,
+		CreatedAt:    now,
+		LastUsed:     now,
+	}
+	if err := c.DB.SetSession(sess); err != nil {
+		log.Printf("[cli] Error saving session: %v", err)
+	}
+	fmt.Fprintf(c.opts.Status, "New session %s in %s\n", oc.ID, oc.Directory)
+	return sess, nil
+}
+
+// choices resolves the agent and model for a prompt: flags, then the
+// session's stored choice, then the configured defaults.
+func (c *Chat) choices(sess store.Session) (agent, providerID, modelID string) {
+	agent, providerID, modelID = sess.Agent, sess.ModelProvider, sess.ModelID
+	if c.opts.Agent != "" {
+		agent = c.opts.Agent
+	}
+	if p, m, ok := config.SplitModel(c.opts.Model); ok {
+		providerID, modelID = p, m
+	}
+	if agent == "" {
+		agent = c.defaultAgent
+	}
+	if providerID == "" || modelID == "" {
+		providerID, modelID = c.defaultProvider, c.defaultModel
+	}
+	return agent, providerID, modelID
+}
+
+// PermissionAsked answers a tool's permission request: once with
+// Options.Approve, otherwise reject. There is no one to ask while a
+// scripted prompt runs.
+func (c *Chat) PermissionAsked(chatID int64, p opencode.Permission) {
+	response, verb := opencode.PermissionReject, "Rejected"
+	if c.opts.Approve {
+		response, verb = opencode.PermissionOnce, "Approved"
+	}
+	c.statusLine(fmt.Sprintf("%s %s: %s", verb, p.Type, p.Title))
+	go func() {
+		if err := c.Client.RespondPermission(context.Background(), p.SessionID, p.ID, response); err != nil {
+			log.Printf("[cli] Error answering permission %s: %v", p.ID, err)
+		}
+	}()
+}
+
+// ToolStarted is part of opencode.ToolGuard; the terminal applies no tool
+// policy of its own.
+func (c *Chat) ToolStarted(chatID int64, sessionID, callID, tool string) {}
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The StreamManager sends the whole reply on every edit; the terminal
+// prints only what was added since the last one.
+
+// SendText prints text. The stream only sends (rather than edits) a
+// reply it adopted without a registered message.
+func (c *Chat) SendText(chatID int64, text string) (int, error) {
+	return replyMessageID, c.EditText(chatID, replyMessageID, text)
+}
+
+// EditText prints the part of text not printed yet.
+func (c *Chat) EditText(chatID int64, messageID int, text string) error {
+	if chatID != c.opts.ChatID {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.render(text)
+	return nil
+}
+
+// EditStatus prints the new part of text, and status when it changed.
+func (c *Chat) EditStatus(chatID int64, messageID int, text, status string) error {
+	if chatID != c.opts.ChatID {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.render(text)
+	if status != "" && status != c.status {
+		c.writeStatus(status)
+	}
+	c.status = status
+	return nil
+}
+
+// EditFinalText prints the rest of the reply.
+func (c *Chat) EditFinalText(chatID int64, messageID int, text string) error {
+	return c.EditText(chatID, messageID, text)
+}
+
+// ReplyComplete ends the reply's line and the prompt Chat.Prompt is
+// waiting on.
+func (c *Chat) ReplyComplete(chatID int64, messageID int) {
+	if chatID != c.opts.ChatID {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.printed != "" && !strings.HasSuffix(c.printed, "\n") {
+		io.WriteString(c.opts.Out, "\n")
+	}
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+}
+
+// render writes the suffix of text beyond what is printed. A text that
+// doesn't extend it is a new text part (after a tool call, say) and
+// starts a new paragraph. Callers hold c.mu.
+func (c *Chat) render(text string) {
+	if text == "" || text == c.printed {
+		return
+	}
+	if strings.HasPrefix(text, c.printed) {
+		io.WriteString(c.opts.Out, text[len(c.printed):])
+	} else {
+		if !strings.HasSuffix(c.printed, "\n") {
+			io.WriteString(c.opts.Out, "\n")
+		}
+		io.WriteString(c.opts.Out, "\n"+text)
+	}
+	c.printed = text
+}
+
+func (c *Chat) statusLine(status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeStatus(status)
+}
+
+// writeStatus prints status on its own line. Callers hold c.mu.
+func (c *Chat) writeStatus(status string) {
+	if c.printed != "" && !strings.HasSuffix(c.printed, "\n") {
+		// Out and Status usually share the terminal; don't split a line.
+		fmt.Fprintf(c.opts.Status, "\n[%s]\n", status)
+		return
+	}
+	fmt.Fprintf(c.opts.Status, "[%s]\n", status)
+}
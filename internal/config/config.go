@@ -1,44 +1,423 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/ocserver"
+	"github.com/Khaledxab/Openkh/internal/opencode"
 )
 
 // Config holds all configuration settings for the bot.
 type Config struct {
 	TelegramToken string
 	OpenCodeURL   string
+	OpenCodeKey   string   // bearer token for the OpenCode server (optional)
+	TelegramProxy *url.URL // proxy for api.telegram.org (nil = HTTP(S)_PROXY env)
+	OpenCodeProxy *url.URL // proxy for the OpenCode server (nil = HTTP(S)_PROXY env)
+	OpenCodeTLS   opencode.TLSOptions
 	AllowedUsers  map[int64]bool
 	AdminUsers    map[int64]bool
 	WorkDir       string
+	AllowedDirs   []string                   // absolute roots session directories must stay inside (empty = unrestricted)
+	Repos         map[string]string          // repository name -> absolute directory, for /repo
+	ChatRepos     map[int64]string           // chat ID -> name of the repository its sessions start in
+	Templates     map[string]SessionTemplate // session templates for /newfrom, by name
+	RunCommands   map[string]string          // /run target -> shell command run in the session's directory
+	Plugins       map[string]PluginSpec      // extra commands served by programs or HTTP hooks, by name
+	DBDriver      string                     // "sqlite" (default), "memory" or "redis"
 	DBPath        string
-	Agents        string // comma-separated "name:description" pairs
+	RedisURL      string
+	SlowQuery     time.Duration // log store calls slower than this
+	InstanceID    string        // replica name used for stream leases (empty = hostname-pid)
+	LeaseTTL      time.Duration // how long a replica's claim on a streaming session lasts
+	MetricsAddr   string        // listen address for /metrics (empty = disabled)
+	LogLevel      string        // debug, info, warn or error
+	EnvFile       string        // .env file re-read on SIGHUP for allowlists and agents
+	BootSelfTest  bool          // run the self-test at startup and send the report to admins
+	SentryDSN     string        // report panics and errors to Sentry (optional)
+	ErrorWebhook  string        // POST error reports as JSON to this URL (optional)
+	ErrorEnv      string        // environment name attached to error reports
+	WebhookURL    string        // public HTTPS URL for webhook mode (empty = long polling)
+	WebhookListen string        // local listen address for the webhook server
+	WebhookSecret string        // secret token Telegram sends with each webhook request
+	Agents        string        // comma-separated "name:description" pairs
+	Aliases       string        // comma-separated "alias=command" pairs
+	DefaultAgent  string        // agent used when a chat has no stored preference
+	DefaultModel  string        // "provider/model" used when a chat has no stored preference
+	SummaryModel  string        // "provider/model" for /diff summaries (empty = the chat's model)
+	AutoCompact   int           // compact sessions past this percent of the context window after a reply (0 = off)
+	ArchiveAfter  time.Duration // archive sessions idle this long (0 = off)
+	Leaderboard   string        // who may use /leaderboard: LeaderboardAdmin, LeaderboardAll or LeaderboardOff
+
+	// HTTP tuning for the OpenCode connection
+	HTTPTimeout     time.Duration // default per-request timeout
+	HTTPLongTimeout time.Duration // timeout for large fetches (diff, message history)
+	HTTPIdleTimeout time.Duration // how long idle keep-alive connections are kept
+	HTTPMaxIdle     int           // max idle connections to the OpenCode host
+	SSEIdleTimeout  time.Duration // reconnect SSE if no data arrives for this long
+	HTTPDebug       bool          // log OpenCode requests/responses at startup
+	ListCacheTTL    time.Duration // how long session/provider lists are served from memory
+
+	// Streaming and display limits
+	EditThrottle     time.Duration // initial interval between streaming message edits, adapted per chat
+	StreamProgress   bool          // animate the status line while a stream is quiet
+	StallWarning     time.Duration // quiet time before the status line warns about it
+	MaxMessageLen    int           // truncate outgoing text to this many UTF-16 units (Telegram max is 4096)
+	HistoryLimit     int           // messages shown by /history
+	SessionListLimit int           // sessions shown by /sessions
+	EventLogSize     int           // recent SSE events kept for /events
+	Workers          int           // chats whose updates are processed in parallel
+	ChatQueueLimit   int           // updates queued per chat before new ones are dropped
+	SendRate         int           // streamed sends/edits per second across all chats
+	Postprocess      []string      // processors replies go through before they are shown, in order
+
+	// Fair use
+	MaxRunningPrompts int // prompts one user may have running at once across chats (0 = no limit)
+	MaxConcurrentRuns int // prompts running at once across all users; more wait their turn (0 = no limit)
+
+	// Tool policy, by the role of the chat a session streams to
+	DeniedTools      map[string]bool   // OpenCode tools blocked in non-admin chats
+	AdminDeniedTools map[string]bool   // OpenCode tools blocked in admin chats
+	ToolPolicy       map[string]string // tool -> ToolAllow/ToolSession/ToolAsk in non-admin chats
+	AdminToolPolicy  map[string]string // the same for admin chats
+
+	// Privacy
+	PrivacyMode bool // keep prompt and reply text out of logs, /events, error reports and the database
+
+	// Commands turned off for the deployment
+	DisabledCommands map[string]bool // command names without the slash, lowercased
+
+	// Webhook listener hardening
+	WebhookAllowedIPs     []*net.IPNet // source networks accepted by the webhook listener (empty = any)
+	WebhookTrustedProxies []*net.IPNet // reverse proxies whose X-Forwarded-For is believed
+	WebhookMaxBody        int64        // larger webhook request bodies are refused
+
+	// HTTP chat API
+	APIListen string           // listen address for POST /v1/chat (empty = disabled)
+	APITokens map[string]int64 // bearer token -> the user ID the caller acts as
+
+	// Email notifications (/notify email)
+	SMTPURL    string        // smtp:// or smtps:// server, with credentials (empty = disabled)
+	SMTPFrom   string        // sender address
+	EmailAfter time.Duration // runs longer than this are emailed to chats with an address
+
+	// Microsoft Teams (Bot Framework)
+	TeamsAppID        string          // app (client) ID of the bot registration (empty = disabled)
+	TeamsAppPassword  string          // client secret of the app
+	TeamsTenantID     string          // tenant of a single-tenant app; only its users are served
+	TeamsListen       string          // listen address of the messaging endpoint, /api/messages
+	TeamsAllowedUsers map[string]bool // Azure AD object IDs, lowercased (empty = the whole tenant)
+
+	// Git forge (/pr, /mr, CI status in /status)
+	ForgeType  string            // "gitea", "forgejo" or "gitlab" (empty = disabled)
+	ForgeURL   string            // base URL of the forge; empty for gitlab.com
+	ForgeToken string            // access token allowed to open pull requests
+	ForgeRepos map[string]string // absolute session directory -> "owner/repo" on the forge
+
+	// Issue tracker (/issue)
+	IssueTracker      string // "github", "gitlab", "linear", "gitea" or "forgejo" (empty = disabled)
+	IssueTrackerURL   string // API base URL (empty = the hosted GitHub, GitLab or Linear)
+	IssueTrackerToken string // token allowed to file issues
+	IssueProject      string // "owner/repo", GitLab project path or Linear team ID
+
+	// GitHub Gists ("Share as Gist" under /diff)
+	GistToken  string // GitHub token with the gist scope (empty = disabled)
+	GistAPIURL string // GitHub API base URL, for GitHub Enterprise
+
+	// OpenCode server process (/oc)
+	OpenCodeService       string   // "systemd", "docker", "exec" or "serve" (empty = disabled)
+	OpenCodeServiceTarget string   // systemd unit, Docker container, or the command exec runs
+	OpenCodeBin           string   // binary "serve" runs as `<bin> serve`
+	OpenCodeArgs          []string // further arguments to `opencode serve`
+	OpenCodePort          int      // port `opencode serve` listens on (0 = OPENCODE_URL's)
+
+	// Tenants: chats served by OpenCode instances of their own
+	Tenants     map[string]Tenant // tenant name -> its instance
+	TenantChats map[int64]string  // chat ID -> tenant name (unlisted chats use OPENCODE_URL)
+	Tenant      string            // the tenant these settings are for (empty = the default instance)
+
+	// Transcript of every prompt and final reply, for a team's shared log
+	TranscriptChat  int64 // channel or group the transcript is posted to (0 = off)
+	TranscriptTopic int   // forum topic of TranscriptChat to post in (0 = none)
+
+	chatOnly bool // loaded for `openkh chat`, which never talks to Telegram
 }
 
+// Who may use /leaderboard, in LEADERBOARD.
+const (
+	LeaderboardAdmin = "admin" // admins only
+	LeaderboardAll   = "all"   // every allowed user
+	LeaderboardOff   = "off"   // nobody; usage is then recorded only for /digest
+)
+
+// Tool approval modes in TOOL_POLICY. Tools without an entry use ToolAsk.
+const (
+	ToolAllow   = "allow"   // approve without asking
+	ToolSession = "session" // ask once, then approve for the rest of the session
+	ToolAsk     = "ask"     // ask every time
+)
+
+// DefaultToolPolicy approves read-only tools, asks once per session before
+// changing files and every time before running a shell command.
+const DefaultToolPolicy = "read=allow,list=allow,grep=allow,glob=allow,edit=session,write=session,patch=session,bash=ask"
+
 // LoadConfig loads configuration from environment variables with portable defaults.
 func LoadConfig() *Config {
-	token := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if token == "" {
-		log.Fatal("TELEGRAM_BOT_TOKEN environment variable is required")
+	cfg := load()
+	if cfg.TelegramToken == "" {
+		log.Fatal("TELEGRAM_BOT_TOKEN (or TELEGRAM_BOT_TOKEN_FILE) is required")
 	}
+	return cfg
+}
 
+// LoadChatConfig is LoadConfig for `openkh chat`: the terminal client
+// shares every setting with the bot but doesn't need a Telegram token.
+func LoadChatConfig() *Config {
+	cfg := load()
+	cfg.chatOnly = true
+	return cfg
+}
+
+func load() *Config {
+	token := envSecret("TELEGRAM_BOT_TOKEN")
 	opencodeURL := envOr("OPENCODE_URL", "http://localhost:4096")
+	if port := envInt("OPENCODE_PORT", 0); port > 0 && os.Getenv("OPENCODE_URL") == "" {
+		// The bot runs the server itself; talk to it where it listens.
+		opencodeURL = fmt.Sprintf("http://localhost:%d", port)
+	}
 	workDir := envOr("WORK_DIR", ".")
 	dbPath := resolveDBPath()
 	agents := os.Getenv("AGENTS")
 
+	redisURL := envSecret("REDIS_URL")
+	if redisURL == "" {
+		redisURL = "redis://localhost:6379/0"
+	}
+
+	defaultModel := strings.TrimSpace(os.Getenv("DEFAULT_MODEL"))
+	if defaultModel != "" {
+		if _, _, ok := SplitModel(defaultModel); !ok {
+			log.Fatalf("Invalid DEFAULT_MODEL %q: expected provider/model", defaultModel)
+		}
+	}
+
+	summaryModel := strings.TrimSpace(os.Getenv("SUMMARY_MODEL"))
+	if summaryModel != "" {
+		if _, _, ok := SplitModel(summaryModel); !ok {
+			log.Fatalf("Invalid SUMMARY_MODEL %q: expected provider/model", summaryModel)
+		}
+	}
+
+	autoCompact, err := ParseAutoCompact(os.Getenv("AUTO_COMPACT"))
+	if err != nil {
+		log.Fatalf("Invalid AUTO_COMPACT: %v", err)
+	}
+
+	leaderboard := strings.ToLower(strings.TrimSpace(envOr("LEADERBOARD", LeaderboardAdmin)))
+	switch leaderboard {
+	case LeaderboardAdmin, LeaderboardAll, LeaderboardOff:
+	default:
+		log.Fatalf("Invalid LEADERBOARD %q: expected %s, %s or %s", leaderboard, LeaderboardAdmin, LeaderboardAll, LeaderboardOff)
+	}
+
+	toolPolicy, err := ParseToolPolicy(DefaultToolPolicy+","+os.Getenv("TOOL_POLICY"), nil)
+	if err != nil {
+		log.Fatalf("Invalid TOOL_POLICY: %v", err)
+	}
+	adminToolPolicy, err := ParseToolPolicy(os.Getenv("ADMIN_TOOL_POLICY"), toolPolicy)
+	if err != nil {
+		log.Fatalf("Invalid ADMIN_TOOL_POLICY: %v", err)
+	}
+
+	webhookAllowedIPs, err := ParseIPRanges(os.Getenv("WEBHOOK_ALLOWED_IPS"))
+	if err != nil {
+		log.Fatalf("Invalid WEBHOOK_ALLOWED_IPS: %v", err)
+	}
+	webhookTrustedProxies, err := ParseIPRanges(os.Getenv("WEBHOOK_TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid WEBHOOK_TRUSTED_PROXIES: %v", err)
+	}
+
+	repos, err := ParseRepos(os.Getenv("REPOS"))
+	if err != nil {
+		log.Fatalf("Invalid REPOS: %v", err)
+	}
+	chatRepos, err := ParseChatRepos(os.Getenv("CHAT_REPOS"))
+	if err != nil {
+		log.Fatalf("Invalid CHAT_REPOS: %v", err)
+	}
+	runCommands, err := ParseRunCommands(os.Getenv("RUN_COMMANDS"))
+	if err != nil {
+		log.Fatalf("Invalid RUN_COMMANDS: %v", err)
+	}
+	templates, err := LoadTemplates(os.Getenv("SESSION_TEMPLATES_FILE"))
+	if err != nil {
+		log.Fatalf("Invalid SESSION_TEMPLATES_FILE: %v", err)
+	}
+	plugins, err := LoadPlugins(os.Getenv("PLUGINS_FILE"))
+	if err != nil {
+		log.Fatalf("Invalid PLUGINS_FILE: %v", err)
+	}
+
+	tenants, err := ParseTenants(os.Getenv("TENANTS"))
+	if err != nil {
+		log.Fatalf("Invalid TENANTS: %v", err)
+	}
+	tenantChats, err := ParseChatRepos(os.Getenv("TENANT_CHATS"))
+	if err != nil {
+		log.Fatalf("Invalid TENANT_CHATS: %v", err)
+	}
+	transcriptChat, transcriptTopic, err := ParseChatTopic(os.Getenv("TRANSCRIPT_CHAT"))
+	if err != nil {
+		log.Fatalf("Invalid TRANSCRIPT_CHAT: %v", err)
+	}
+
+	forgeRepos, err := ParseForgeRepos(os.Getenv("FORGE_REPOS"))
+	if err != nil {
+		log.Fatalf("Invalid FORGE_REPOS: %v", err)
+	}
+	apiTokens, err := ParseAPITokens(envSecret("API_TOKENS"))
+	if err != nil {
+		log.Fatalf("Invalid API_TOKENS: %v", err)
+	}
+
+	telegramProxy, err := ParseProxy(envSecret("TELEGRAM_PROXY"))
+	if err != nil {
+		log.Fatalf("Invalid TELEGRAM_PROXY: %v", err)
+	}
+	opencodeProxy, err := ParseProxy(envSecret("OPENCODE_PROXY"))
+	if err != nil {
+		log.Fatalf("Invalid OPENCODE_PROXY: %v", err)
+	}
+
 	return &Config{
 		TelegramToken: token,
 		OpenCodeURL:   opencodeURL,
+		OpenCodeKey:   envSecret("OPENCODE_API_KEY"),
+		TelegramProxy: telegramProxy,
+		OpenCodeProxy: opencodeProxy,
+		OpenCodeTLS: opencode.TLSOptions{
+			CAFile:             os.Getenv("OPENCODE_CA_FILE"),
+			CertFile:           os.Getenv("OPENCODE_CLIENT_CERT"),
+			KeyFile:            os.Getenv("OPENCODE_CLIENT_KEY"),
+			InsecureSkipVerify: envBool("OPENCODE_TLS_INSECURE_SKIP_VERIFY", false),
+		},
 		AllowedUsers:  parseUserList(os.Getenv("ALLOWED_USERS")),
 		AdminUsers:    parseUserList(os.Getenv("ADMIN_USERS")),
 		WorkDir:       workDir,
+		AllowedDirs:   parseDirList(os.Getenv("ALLOWED_DIRS")),
+		Repos:         repos,
+		ChatRepos:     chatRepos,
+		Templates:     templates,
+		RunCommands:   runCommands,
+		Plugins:       plugins,
+		DBDriver:      envOr("DB_DRIVER", "sqlite"),
 		DBPath:        dbPath,
+		RedisURL:      redisURL,
+		SlowQuery:     envDuration("STORE_SLOW_QUERY", 100*time.Millisecond),
+		InstanceID:    strings.TrimSpace(os.Getenv("INSTANCE_ID")),
+		LeaseTTL:      envDurationRange("LEASE_TTL", 30*time.Second, 5*time.Second, 5*time.Minute),
+		MetricsAddr:   os.Getenv("METRICS_ADDR"),
+		LogLevel:      envOr("LOG_LEVEL", "info"),
+		WebhookURL:    os.Getenv("WEBHOOK_URL"),
+		WebhookListen: envOr("WEBHOOK_LISTEN", ":8080"),
+		WebhookSecret: envSecret("WEBHOOK_SECRET"),
+		SentryDSN:     envSecret("SENTRY_DSN"),
+		ErrorWebhook:  envSecret("ERROR_WEBHOOK_URL"),
+		ErrorEnv:      envOr("ERROR_REPORT_ENV", "production"),
 		Agents:        agents,
+		Aliases:       os.Getenv("COMMAND_ALIASES"),
+		DefaultAgent:  strings.TrimSpace(os.Getenv("DEFAULT_AGENT")),
+		DefaultModel:  defaultModel,
+		SummaryModel:  summaryModel,
+		AutoCompact:   autoCompact,
+		ArchiveAfter:  envDuration("ARCHIVE_AFTER", 0),
+		Leaderboard:   leaderboard,
+
+		HTTPTimeout:     envDuration("OPENCODE_TIMEOUT", 30*time.Second),
+		HTTPLongTimeout: envDuration("OPENCODE_LONG_TIMEOUT", 5*time.Minute),
+		HTTPIdleTimeout: envDuration("OPENCODE_IDLE_CONN_TIMEOUT", 90*time.Second),
+		HTTPMaxIdle:     envInt("OPENCODE_MAX_IDLE_CONNS", 16),
+		SSEIdleTimeout:  envDuration("OPENCODE_SSE_IDLE_TIMEOUT", 90*time.Second),
+		HTTPDebug:       envBool("OPENCODE_DEBUG", false),
+		ListCacheTTL:    envDuration("OPENCODE_LIST_CACHE_TTL", 5*time.Second),
+
+		EditThrottle:     envDurationRange("STREAM_EDIT_THROTTLE", time.Second, 250*time.Millisecond, time.Minute),
+		StreamProgress:   envBool("STREAM_PROGRESS", true),
+		StallWarning:     envDurationRange("STREAM_STALL_WARNING", 45*time.Second, 10*time.Second, time.Hour),
+		MaxMessageLen:    envIntRange("MAX_MESSAGE_LENGTH", 4000, 500, 4000),
+		HistoryLimit:     envIntRange("HISTORY_LIMIT", 10, 1, 50),
+		SessionListLimit: envIntRange("SESSION_LIST_LIMIT", 20, 1, 50),
+		EventLogSize:     envIntRange("EVENT_LOG_SIZE", 200, 10, 10000),
+		Workers:          envIntRange("WORKERS", 8, 1, 256),
+		ChatQueueLimit:   envIntRange("CHAT_QUEUE_LIMIT", 20, 1, 1000),
+		SendRate:         envIntRange("TELEGRAM_SEND_RATE", 25, 1, 30),
+		Postprocess:      parseNameList(os.Getenv("POSTPROCESS")),
+
+		MaxRunningPrompts: envIntRange("MAX_RUNNING_PROMPTS", 0, 0, 100),
+		MaxConcurrentRuns: envIntRange("MAX_CONCURRENT_RUNS", 0, 0, 1000),
+
+		DeniedTools:      parseToolList(os.Getenv("DENIED_TOOLS")),
+		AdminDeniedTools: parseToolList(os.Getenv("ADMIN_DENIED_TOOLS")),
+		ToolPolicy:       toolPolicy,
+		AdminToolPolicy:  adminToolPolicy,
+
+		PrivacyMode: envBool("PRIVACY_MODE", false),
+
+		DisabledCommands: parseToolList(strings.ReplaceAll(os.Getenv("COMMANDS_DISABLED"), "/", "")),
+
+		WebhookAllowedIPs:     webhookAllowedIPs,
+		WebhookTrustedProxies: webhookTrustedProxies,
+		WebhookMaxBody:        int64(envIntRange("WEBHOOK_MAX_BODY", 1<<20, 1<<10, 50<<20)),
+
+		APIListen: os.Getenv("API_LISTEN"),
+		APITokens: apiTokens,
+
+		SMTPURL:    envSecret("SMTP_URL"),
+		SMTPFrom:   os.Getenv("SMTP_FROM"),
+		EmailAfter: envDurationRange("EMAIL_AFTER", 15*time.Minute, time.Minute, 24*time.Hour),
+
+		TeamsAppID:        os.Getenv("TEAMS_APP_ID"),
+		TeamsAppPassword:  envSecret("TEAMS_APP_PASSWORD"),
+		TeamsTenantID:     os.Getenv("TEAMS_TENANT_ID"),
+		TeamsListen:       envOr("TEAMS_LISTEN", ":3978"),
+		TeamsAllowedUsers: parseToolList(os.Getenv("TEAMS_ALLOWED_USERS")),
+
+		ForgeType:  os.Getenv("FORGE_TYPE"),
+		ForgeURL:   os.Getenv("FORGE_URL"),
+		ForgeToken: envSecret("FORGE_TOKEN"),
+		ForgeRepos: forgeRepos,
+
+		IssueTracker:      os.Getenv("ISSUE_TRACKER"),
+		IssueTrackerURL:   os.Getenv("ISSUE_TRACKER_URL"),
+		IssueTrackerToken: envSecret("ISSUE_TRACKER_TOKEN"),
+		IssueProject:      os.Getenv("ISSUE_PROJECT"),
+
+		GistToken:  envSecret("GIST_TOKEN"),
+		GistAPIURL: envOr("GIST_API_URL", "https://api.github.com"),
+
+		OpenCodeService:       os.Getenv("OPENCODE_SERVICE"),
+		OpenCodeServiceTarget: os.Getenv("OPENCODE_SERVICE_TARGET"),
+		OpenCodeBin:           envOr("OPENCODE_BIN", "opencode"),
+		OpenCodeArgs:          strings.Fields(os.Getenv("OPENCODE_ARGS")),
+		OpenCodePort:          envInt("OPENCODE_PORT", 0),
+
+		Tenants:     tenants,
+		TenantChats: tenantChats,
+
+		TranscriptChat:  transcriptChat,
+		TranscriptTopic: transcriptTopic,
 	}
 }
 
@@ -74,6 +453,102 @@ func envOr(key, fallback string) string {
 	return fallback
 }
 
+// SplitModel splits a "provider/model" reference. The model ID may itself
+// contain slashes (e.g. "openrouter/anthropic/claude").
+func SplitModel(ref string) (providerID, modelID string, ok bool) {
+	providerID, modelID, ok = strings.Cut(ref, "/")
+	if !ok || providerID == "" || modelID == "" {
+		return "", "", false
+	}
+	return providerID, modelID, true
+}
+
+// ParseProxy validates a proxy URL (http, https or socks5). An empty
+// string returns nil, meaning "use the environment".
+func ParseProxy(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https or socks5)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	return u, nil
+}
+
+// envDuration parses a Go duration (e.g. "45s", "2m") from the environment.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: invalid duration %s=%q, using %s", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+// envInt parses a positive integer from the environment.
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("Warning: invalid integer %s=%q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+// envIntRange is envInt clamped to [lo, hi], warning when clamped.
+func envIntRange(key string, fallback, lo, hi int) int {
+	n := envInt(key, fallback)
+	if n < lo || n > hi {
+		clamped := min(max(n, lo), hi)
+		log.Printf("Warning: %s=%d out of range [%d, %d], using %d", key, n, lo, hi, clamped)
+		return clamped
+	}
+	return n
+}
+
+// envDurationRange is envDuration clamped to [lo, hi], warning when clamped.
+func envDurationRange(key string, fallback, lo, hi time.Duration) time.Duration {
+	d := envDuration(key, fallback)
+	if d < lo || d > hi {
+		clamped := min(max(d, lo), hi)
+		log.Printf("Warning: %s=%s out of range [%s, %s], using %s", key, d, lo, hi, clamped)
+		return clamped
+	}
+	return d
+}
+
+// envBool parses a boolean ("1", "true", "yes", "on") from the environment.
+func envBool(key string, fallback bool) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+	case "":
+		return fallback
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	default:
+		log.Printf("Warning: invalid boolean %s=%q, using %t", key, os.Getenv(key), fallback)
+		return fallback
+	}
+}
+
 func parseUserList(envValue string) map[int64]bool {
 	users := make(map[int64]bool)
 	if envValue == "" {
@@ -93,3 +568,468 @@ func parseUserList(envValue string) map[int64]bool {
 	}
 	return users
 }
+
+// parseToolList parses a comma-separated list of OpenCode tool names.
+func parseToolList(envValue string) map[string]bool {
+	tools := make(map[string]bool)
+	for _, part := range strings.Split(envValue, ",") {
+		if name := strings.ToLower(strings.TrimSpace(part)); name != "" {
+			tools[name] = true
+		}
+	}
+	return tools
+}
+
+// parseNameList parses a comma-separated list of names, lowercased, in
+// order.
+func parseNameList(envValue string) []string {
+	var names []string
+	for _, part := range strings.Split(envValue, ",") {
+		if name := strings.ToLower(strings.TrimSpace(part)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ParseToolPolicy parses "tool=mode" pairs on top of a copy of base;
+// later pairs win.
+func ParseToolPolicy(raw string, base map[string]string) (map[string]string, error) {
+	policy := make(map[string]string, len(base))
+	for tool, mode := range base {
+		policy[tool] = mode
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		tool, mode, ok := strings.Cut(pair, "=")
+		tool, mode = strings.ToLower(strings.TrimSpace(tool)), strings.ToLower(strings.TrimSpace(mode))
+		if !ok || tool == "" {
+			return nil, fmt.Errorf("%q: expected tool=mode", pair)
+		}
+		switch mode {
+		case ToolAllow, ToolSession, ToolAsk:
+			policy[tool] = mode
+		default:
+			return nil, fmt.Errorf("%q: mode must be %s, %s or %s", pair, ToolAllow, ToolSession, ToolAsk)
+		}
+	}
+	return policy, nil
+}
+
+// parseDirList parses ALLOWED_DIRS into cleaned absolute paths.
+func parseDirList(envValue string) []string {
+	var dirs []string
+	for _, part := range strings.Split(envValue, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		abs, err := filepath.Abs(part)
+		if err != nil {
+			log.Printf("Warning: invalid directory %q: %v", part, err)
+			continue
+		}
+		dirs = append(dirs, abs)
+	}
+	return dirs
+}
+
+// TelegramWebhookRanges are the networks Telegram sends webhook requests
+// from (https://core.telegram.org/bots/webhooks).
+var TelegramWebhookRanges = []string{"149.154.160.0/20", "91.108.4.0/22"}
+
+// minAPITokenLen keeps API tokens long enough not to be guessed.
+const minAPITokenLen = 24
+
+// ParseAPITokens parses comma-separated "token=userID" pairs. Each token
+// lets its holder use the chat API as that Telegram user.
+func ParseAPITokens(raw string) (map[string]int64, error) {
+	tokens := make(map[string]int64)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// Split at the last '=' so base64 padding can stay in the token.
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("expected token=user_id, got a value without '='")
+		}
+		token, id := pair[:i], pair[i+1:]
+		userID, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID %q", id)
+		}
+		token = strings.TrimSpace(token)
+		if len(token) < minAPITokenLen {
+			return nil, fmt.Errorf("token for %d is shorter than %d characters", userID, minAPITokenLen)
+		}
+		if _, dup := tokens[token]; dup {
+			return nil, fmt.Errorf("token for %d is listed twice", userID)
+		}
+		tokens[token] = userID
+	}
+	return tokens, nil
+}
+
+// Bounds of the AUTO_COMPACT threshold: lower wastes summaries, higher
+// risks overflowing the window before the run ends.
+const (
+	MinAutoCompact = 50
+	MaxAutoCompact = 95
+)
+
+// ParseAutoCompact parses an auto-compaction threshold in percent of the
+// context window; "", "0" and "off" turn it off.
+func ParseAutoCompact(raw string) (int, error) {
+	raw = strings.TrimSuffix(strings.TrimSpace(raw), "%")
+	if raw == "" || raw == "0" || raw == "off" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < MinAutoCompact || n > MaxAutoCompact {
+		return 0, fmt.Errorf("%q: expected a percentage from %d to %d, or off", raw, MinAutoCompact, MaxAutoCompact)
+	}
+	return n, nil
+}
+
+// ParseRepos parses comma-separated "name=/absolute/dir" pairs naming the
+// repositories chats can be routed to.
+func ParseRepos(raw string) (map[string]string, error) {
+	repos := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, dir, ok := strings.Cut(pair, "=")
+		name, dir = strings.TrimSpace(name), strings.TrimSpace(dir)
+		if !ok || name == "" || strings.ContainsAny(name, " /") || !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("%q: expected name=/absolute/dir", pair)
+		}
+		if _, dup := repos[name]; dup {
+			return nil, fmt.Errorf("repository %q is listed twice", name)
+		}
+		repos[name] = filepath.Clean(dir)
+	}
+	return repos, nil
+}
+
+// RepoNames returns the REPOS names in order.
+func (c *Config) RepoNames() []string {
+	names := make([]string, 0, len(c.Repos))
+	for name := range c.Repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseChatRepos parses comma-separated "chatID=name" pairs routing chats
+// to REPOS entries.
+func ParseChatRepos(raw string) (map[int64]string, error) {
+	chats := make(map[int64]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, name, ok := strings.Cut(pair, "=")
+		chatID, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		name = strings.TrimSpace(name)
+		if !ok || err != nil || name == "" {
+			return nil, fmt.Errorf("%q: expected chatID=name", pair)
+		}
+		chats[chatID] = name
+	}
+	return chats, nil
+}
+
+// ParseChatTopic parses a chat ID optionally followed by "/" and the ID of
+// a forum topic in it, e.g. "-1001234567890/42". Empty is chat 0.
+func ParseChatTopic(raw string) (chatID int64, topic int, err error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, 0, nil
+	}
+	id, thread, hasTopic := strings.Cut(raw, "/")
+	if chatID, err = strconv.ParseInt(strings.TrimSpace(id), 10, 64); err != nil || chatID == 0 {
+		return 0, 0, fmt.Errorf("%q: expected a chat ID, optionally followed by /topic", raw)
+	}
+	if hasTopic {
+		if topic, err = strconv.Atoi(strings.TrimSpace(thread)); err != nil || topic <= 0 {
+			return 0, 0, fmt.Errorf("%q: invalid topic ID %q", raw, thread)
+		}
+	}
+	return chatID, topic, nil
+}
+
+// Tenant is an OpenCode instance of its own for some chats, e.g. a team
+// on an unrelated project sharing the bot.
+type Tenant struct {
+	Name    string
+	URL     string // base URL of its OpenCode server
+	Port    int    // port the bot runs `opencode serve` on for it (0 = it runs elsewhere, at URL)
+	WorkDir string // directory its sessions start in (empty = WORK_DIR)
+}
+
+// ParseTenants parses semicolon-separated "name=instance[,/workdir]"
+// entries, where instance is the base URL of an OpenCode server or
+// "serve:<port>" to have the bot run one on that port, e.g.
+// "acme=http://10.0.0.5:4096;globex=serve:4201,/srv/globex".
+func ParseTenants(raw string) (map[string]Tenant, error) {
+	tenants := make(map[string]Tenant)
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " /") {
+			return nil, fmt.Errorf("%q: expected name=url or name=serve:port", entry)
+		}
+		if _, dup := tenants[name]; dup {
+			return nil, fmt.Errorf("tenant %q is listed twice", name)
+		}
+		spec, dir, _ := strings.Cut(spec, ",")
+		spec = strings.TrimSpace(spec)
+		t := Tenant{Name: name, WorkDir: strings.TrimSpace(dir)}
+		if t.WorkDir != "" {
+			if !filepath.IsAbs(t.WorkDir) {
+				return nil, fmt.Errorf("tenant %s: %q is not an absolute directory", name, t.WorkDir)
+			}
+			t.WorkDir = filepath.Clean(t.WorkDir)
+		}
+		if port, ok := strings.CutPrefix(spec, "serve:"); ok {
+			n, err := strconv.Atoi(port)
+			if err != nil || n <= 0 || n > 65535 {
+				return nil, fmt.Errorf("tenant %s: invalid port %q", name, port)
+			}
+			t.Port, t.URL = n, fmt.Sprintf("http://127.0.0.1:%d", n)
+		} else if u, err := url.Parse(spec); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("tenant %s: %q is neither an http(s) URL nor serve:<port>", name, spec)
+		} else {
+			t.URL = strings.TrimRight(spec, "/")
+		}
+		tenants[name] = t
+	}
+	return tenants, nil
+}
+
+// TenantNames returns the TENANTS names in order.
+func (c *Config) TenantNames() []string {
+	names := make([]string, 0, len(c.Tenants))
+	for name := range c.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForTenant returns the settings of the bot serving the chats of tenant
+// name: these, with the tenant's OpenCode URL and work directory. The
+// tenant's server process, if the bot runs it, comes from TenantServer.
+func (c *Config) ForTenant(name string) *Config {
+	t := c.Tenants[name]
+	tc := *c
+	tc.Tenant = name
+	tc.OpenCodeURL = t.URL
+	if t.WorkDir != "" {
+		tc.WorkDir = t.WorkDir
+	}
+	tc.OpenCodeService, tc.OpenCodeServiceTarget = "", ""
+	return &tc
+}
+
+// TenantServer returns the `opencode serve` child the bot runs for tenant
+// name, in the tenant's work directory, or nil if its server runs
+// elsewhere.
+func (c *Config) TenantServer(name string) ocserver.Server {
+	t := c.Tenants[name]
+	if t.Port == 0 {
+		return nil
+	}
+	return ocserver.Serve(c.OpenCodeBin, c.OpenCodeArgs, "127.0.0.1", t.Port, t.WorkDir)
+}
+
+// ParseRunCommands parses semicolon-separated "target=command" pairs, e.g.
+// "build=go build ./...;lint=go vet ./...". Commands may contain commas
+// and '=' but not ';'; chain them with &&.
+func ParseRunCommands(raw string) (map[string]string, error) {
+	commands := make(map[string]string)
+	for _, pair := range strings.Split(raw, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		target, command, ok := strings.Cut(pair, "=")
+		target, command = strings.TrimSpace(target), strings.TrimSpace(command)
+		if !ok || !TemplateName.MatchString(target) || command == "" {
+			return nil, fmt.Errorf("%q: expected target=command", pair)
+		}
+		if _, dup := commands[target]; dup {
+			return nil, fmt.Errorf("target %q is listed twice", target)
+		}
+		commands[target] = command
+	}
+	return commands, nil
+}
+
+// RunTargets returns the RUN_COMMANDS targets in order.
+func (c *Config) RunTargets() []string {
+	targets := make([]string, 0, len(c.RunCommands))
+	for target := range c.RunCommands {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// ParseForgeRepos parses comma-separated "dir=owner/repo" pairs mapping
+// the checkouts OpenCode sessions run in to their forge repositories. On
+// GitLab the repository may be in a subgroup: "group/subgroup/project".
+func ParseForgeRepos(raw string) (map[string]string, error) {
+	repos := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		dir, repo, ok := strings.Cut(pair, "=")
+		dir, repo = strings.TrimSpace(dir), strings.TrimSpace(repo)
+		owner, name, _ := strings.Cut(repo, "/")
+		if !ok || !filepath.IsAbs(dir) || owner == "" || name == "" || slices.Contains(strings.Split(name, "/"), "") {
+			return nil, fmt.Errorf("%q: expected /absolute/dir=owner/repo", pair)
+		}
+		repos[filepath.Clean(dir)] = repo
+	}
+	return repos, nil
+}
+
+// ForgeRepo returns the forge repository of a session running in dir:
+// that of the deepest FORGE_REPOS directory containing it, or "".
+func (c *Config) ForgeRepo(dir string) string {
+	if dir == "" || !filepath.IsAbs(dir) {
+		return ""
+	}
+	dir = filepath.Clean(dir)
+	best, repo := "", ""
+	for root, r := range c.ForgeRepos {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(root) > len(best) {
+			best, repo = root, r
+		}
+	}
+	return repo
+}
+
+// ParseIPRanges parses comma-separated CIDRs or single addresses. The word
+// "telegram" stands for TelegramWebhookRanges.
+func ParseIPRanges(raw string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+			continue
+		case strings.EqualFold(part, "telegram"):
+			for _, cidr := range TelegramWebhookRanges {
+				_, n, _ := net.ParseCIDR(cidr)
+				nets = append(nets, n)
+			}
+			continue
+		case !strings.Contains(part, "/"):
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", part)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 128
+			}
+			part = fmt.Sprintf("%s/%d", part, bits)
+		}
+		_, n, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// OpenCodeServer returns the OpenCode server process OPENCODE_SERVICE
+// names, or nil if it is unset. "serve" runs `opencode serve` on the host
+// and port of OPENCODE_URL, or on OPENCODE_PORT.
+func (c *Config) OpenCodeServer() (ocserver.Server, error) {
+	switch kind := strings.ToLower(c.OpenCodeService); kind {
+	case "":
+		return nil, nil
+	case "serve":
+		u, err := url.Parse(c.OpenCodeURL)
+		if err != nil {
+			return nil, fmt.Errorf("OPENCODE_URL: %w", err)
+		}
+		port := c.OpenCodePort
+		switch {
+		case port == 0 && u.Port() == "":
+			return nil, errors.New("OPENCODE_URL has no port; set OPENCODE_PORT or add one")
+		case port == 0:
+			port, _ = strconv.Atoi(u.Port())
+		case u.Port() != "" && u.Port() != strconv.Itoa(port):
+			return nil, fmt.Errorf("OPENCODE_PORT %d doesn't match OPENCODE_URL %s", port, c.OpenCodeURL)
+		}
+		return ocserver.Serve(c.OpenCodeBin, c.OpenCodeArgs, u.Hostname(), port, ""), nil
+	default:
+		return ocserver.New(kind, c.OpenCodeServiceTarget)
+	}
+}
+
+// DefaultDir is where new sessions start when nothing else picks a
+// directory: WORK_DIR, made absolute, when ALLOWED_DIRS is set, otherwise
+// "" for the OpenCode server's directory.
+func (c *Config) DefaultDir() string {
+	if len(c.AllowedDirs) == 0 {
+		return ""
+	}
+	dir, err := filepath.Abs(c.WorkDir)
+	if err != nil {
+		log.Printf("Warning: WORK_DIR %q: %v", c.WorkDir, err)
+		return ""
+	}
+	return dir
+}
+
+// DirAllowed reports whether dir is inside one of AllowedDirs. Relative
+// and empty paths are refused when the list is set; symlinks are resolved
+// where the path exists on this host.
+func (c *Config) DirAllowed(dir string) bool {
+	if len(c.AllowedDirs) == 0 {
+		return true
+	}
+	if dir == "" || !filepath.IsAbs(dir) {
+		return false
+	}
+	dir = resolveDir(dir)
+	for _, root := range c.AllowedDirs {
+		rel, err := filepath.Rel(resolveDir(root), dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveDir(dir string) string {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		return real
+	}
+	return filepath.Clean(dir)
+}
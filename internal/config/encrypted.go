@@ -0,0 +1,181 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SECRETS_FILE names an age- or GPG-encrypted .env file holding secrets
+// (TELEGRAM_BOT_TOKEN, OPENCODE_API_KEY, ...), so unit files and the
+// plain .env don't carry them. It is decrypted once at startup with the
+// age or gpg binary; the plaintext never touches the disk.
+
+const (
+	maxEncryptedFileSize = 1 << 20
+	decryptTimeout       = 30 * time.Second
+	// defaultKeyringAccount is the account SECRETS_KEYRING looks up when
+	// it names only a service.
+	defaultKeyringAccount = "openkh"
+)
+
+var (
+	encryptedOnce sync.Once
+	encryptedVars map[string]string
+	encryptedErr  error
+)
+
+// encryptedSecrets returns the variables in SECRETS_FILE, decrypting it on
+// first use. Without SECRETS_FILE it returns nil.
+func encryptedSecrets() (map[string]string, error) {
+	encryptedOnce.Do(func() {
+		path := os.Getenv("SECRETS_FILE")
+		if path == "" {
+			return
+		}
+		encryptedVars, encryptedErr = loadEncryptedSecrets(path)
+		if encryptedErr != nil {
+			encryptedErr = fmt.Errorf("SECRETS_FILE %s: %w", path, encryptedErr)
+		}
+	})
+	return encryptedVars, encryptedErr
+}
+
+func loadEncryptedSecrets(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxEncryptedFileSize {
+		return nil, fmt.Errorf("too large (%d bytes)", info.Size())
+	}
+	ciphertext, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var plaintext []byte
+	if isAge(ciphertext) {
+		if key == "" {
+			return nil, fmt.Errorf("age-encrypted, but none of SECRETS_KEY, SECRETS_KEY_FILE or SECRETS_KEYRING is set")
+		}
+		// The identity goes through a pipe on fd 3, never a file or argv.
+		plaintext, err = runDecrypt("age", []string{"--decrypt", "--identity", "/dev/fd/3"}, ciphertext, key)
+	} else {
+		// Without a passphrase gpg uses the private keys in its keyring,
+		// unlocked through gpg-agent.
+		args := []string{"--batch", "--quiet", "--decrypt"}
+		if key != "" {
+			args = append(args, "--pinentry-mode", "loopback", "--passphrase-fd", "3")
+		}
+		plaintext, err = runDecrypt("gpg", args, ciphertext, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseEnv(bytes.NewReader(plaintext), path)
+}
+
+// isAge recognises both binary and armored age files.
+func isAge(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("age-encryption.org/")) ||
+		bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN AGE ENCRYPTED FILE-----"))
+}
+
+// decryptionKey returns the age identity or GPG passphrase: SECRETS_KEY
+// (or SECRETS_KEY_FILE), else the OS keyring entry named by
+// SECRETS_KEYRING, else "".
+func decryptionKey() (string, error) {
+	key, err := readPlainSecret("SECRETS_KEY")
+	if err != nil || key != "" {
+		return key, err
+	}
+	entry := strings.TrimSpace(os.Getenv("SECRETS_KEYRING"))
+	if entry == "" {
+		return "", nil
+	}
+	service, account, ok := strings.Cut(entry, ":")
+	if !ok {
+		account = defaultKeyringAccount
+	}
+	return keyringLookup(service, account)
+}
+
+// keyringLookup reads a password from the OS keyring: the Secret Service
+// (GNOME Keyring, KWallet) through secret-tool on Linux, the login
+// keychain through security on macOS.
+func keyringLookup(service, account string) (string, error) {
+	var name string
+	var args []string
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		name, args = "secret-tool", []string{"lookup", "service", service, "account", account}
+	case "darwin":
+		name, args = "security", []string{"find-generic-password", "-s", service, "-a", account, "-w"}
+	default:
+		return "", fmt.Errorf("SECRETS_KEYRING is not supported on %s", runtime.GOOS)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), decryptTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("keyring lookup of %s/%s: %v: %s", service, account, err, strings.TrimSpace(stderr.String()))
+	}
+	key := strings.TrimSpace(string(out))
+	if key == "" {
+		return "", fmt.Errorf("keyring has no entry for %s/%s", service, account)
+	}
+	return key, nil
+}
+
+// runDecrypt runs name with ciphertext on stdin and, when key is set, the
+// key on fd 3, and returns its stdout.
+func runDecrypt(name string, args []string, ciphertext []byte, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), decryptTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(ciphertext)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	var keyWriter *os.File
+	if key != "" {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		cmd.ExtraFiles = []*os.File{r}
+		keyWriter = w
+	}
+	if err := cmd.Start(); err != nil {
+		if keyWriter != nil {
+			keyWriter.Close()
+		}
+		return nil, fmt.Errorf("run %s: %w", name, err)
+	}
+	if keyWriter != nil {
+		go func() {
+			keyWriter.WriteString(key + "\n")
+			keyWriter.Close()
+		}()
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Flags are command-line overrides layered over the environment. Empty
+// values leave the environment setting in place.
+type Flags struct {
+	CheckConfig bool
+	OpenCodeURL string
+	DBPath      string
+	LogLevel    string
+	Webhook     string
+	MetricsAddr string
+}
+
+// RegisterFlags defines the command-line flags on fs and installs a usage
+// function that also documents the environment variables.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.BoolVar(&f.CheckConfig, "check-config", false, "validate the configuration, print it (secrets masked) and exit")
+	fs.StringVar(&f.OpenCodeURL, "opencode-url", "", "OpenCode server URL (overrides OPENCODE_URL)")
+	fs.StringVar(&f.DBPath, "db", "", "SQLite database path (overrides DB_PATH/DATA_DIR)")
+	fs.StringVar(&f.LogLevel, "log-level", "", "debug, info, warn or error (overrides LOG_LEVEL)")
+	fs.StringVar(&f.Webhook, "webhook", "", "public HTTPS URL to receive updates by webhook instead of polling (overrides WEBHOOK_URL)")
+	fs.StringVar(&f.MetricsAddr, "metrics-addr", "", "listen address for /metrics, e.g. :9090 (overrides METRICS_ADDR)")
+	fs.Usage = func() { usage(fs) }
+	return f
+}
+
+// ApplyFlags overrides the loaded configuration with any flags that were set.
+func (c *Config) ApplyFlags(f *Flags) {
+	if f.OpenCodeURL != "" {
+		c.OpenCodeURL = f.OpenCodeURL
+	}
+	if f.DBPath != "" {
+		c.DBPath = f.DBPath
+	}
+	if f.LogLevel != "" {
+		c.LogLevel = f.LogLevel
+	}
+	if f.Webhook != "" {
+		c.WebhookURL = f.Webhook
+	}
+	if f.MetricsAddr != "" {
+		c.MetricsAddr = f.MetricsAddr
+	}
+}
+
+// envDocs lists every environment variable for --help.
+var envDocs = [][3]string{
+	{"TELEGRAM_BOT_TOKEN", "(required)", "Telegram bot token from BotFather"},
+	{"OPENCODE_URL", "http://localhost:4096", "OpenCode server URL"},
+	{"OPENCODE_API_KEY", "", "bearer token for the OpenCode server"},
+	{"SECRETS_FILE", "", "age/GPG-encrypted KEY=value file holding the secrets"},
+	{"SECRETS_KEY", "", "age identity or GPG passphrase for SECRETS_FILE"},
+	{"SECRETS_KEYRING", "", "service[:account] in the OS keyring holding SECRETS_KEY"},
+	{"ALLOWED_USERS", "(allow all)", "comma-separated Telegram user IDs"},
+	{"ADMIN_USERS", "(all are admin)", "comma-separated admin user IDs"},
+	{"WORK_DIR", ".", "working directory; new sessions start here when ALLOWED_DIRS is set"},
+	{"DENIED_TOOLS", "", "comma-separated OpenCode tools blocked in non-admin chats, e.g. bash,webfetch"},
+	{"ADMIN_DENIED_TOOLS", "", "comma-separated OpenCode tools blocked in admin chats"},
+	{"TOOL_POLICY", "(see README)", "tool=allow|session|ask pairs for non-admin chats, over the defaults"},
+	{"ADMIN_TOOL_POLICY", "(TOOL_POLICY)", "tool=allow|session|ask pairs for admin chats"},
+	{"ALLOWED_DIRS", "(unrestricted)", "comma-separated roots sessions and /cd must stay inside"},
+	{"REPOS", "", "named repositories for /repo: name=/dir,..."},
+	{"CHAT_REPOS", "", "repository each chat's sessions start in: chatID=name,..."},
+	{"SESSION_TEMPLATES_FILE", "", "JSON file of /newfrom templates: name -> agent, model, dir, system"},
+	{"RUN_COMMANDS", "", "project commands for /run: target=command;..."},
+	{"PLUGINS_FILE", "", "JSON file of extra commands: name -> exec or url, help, args, admin, timeout"},
+	{"DB_DRIVER", "sqlite", "sqlite, memory or redis"},
+	{"DB_PATH", "~/.local/share/openkh/openkh.db", "SQLite database path"},
+	{"DATA_DIR", "", "data directory (DB at $DATA_DIR/openkh.db)"},
+	{"REDIS_URL", "redis://localhost:6379/0", "Redis URL for DB_DRIVER=redis"},
+	{"STORE_SLOW_QUERY", "100ms", "log store calls slower than this"},
+	{"INSTANCE_ID", "(hostname-pid)", "replica name for stream leases"},
+	{"LEASE_TTL", "30s", "how long a replica owns a streaming session without renewing"},
+	{"METRICS_ADDR", "", "listen address for /metrics"},
+	{"LOG_LEVEL", "info", "debug, info, warn or error"},
+	{"ENV_FILE", ".env", "file re-read on SIGHUP for ALLOWED_USERS, ADMIN_USERS, AGENTS"},
+	{"BOOT_SELFTEST", "true", "run /selftest at startup and message admins"},
+	{"SENTRY_DSN", "", "report panics and errors to Sentry"},
+	{"ERROR_WEBHOOK_URL", "", "POST error reports as JSON to this URL"},
+	{"ERROR_REPORT_ENV", "production", "environment name on error reports"},
+	{"WEBHOOK_URL", "", "public HTTPS URL for webhook mode"},
+	{"WEBHOOK_LISTEN", ":8080", "local listen address for the webhook server"},
+	{"WEBHOOK_SECRET", "", "secret token checked on webhook requests"},
+	{"WEBHOOK_ALLOWED_IPS", "(any)", "CIDRs webhook requests may come from; \"telegram\" = Telegram's ranges"},
+	{"WEBHOOK_TRUSTED_PROXIES", "", "CIDRs of reverse proxies whose X-Forwarded-For is trusted"},
+	{"WEBHOOK_MAX_BODY", "1048576", "largest webhook request body in bytes"},
+	{"API_LISTEN", "(disabled)", "listen address for the HTTP chat API, e.g. 127.0.0.1:8090"},
+	{"API_TOKENS", "", "comma-separated token=user_id pairs for the chat API"},
+	{"SMTP_URL", "(disabled)", "smtp[s]://user:pass@host:port for /notify email"},
+	{"SMTP_FROM", "", "sender address of notification emails"},
+	{"EMAIL_AFTER", "15m", "email replies of runs longer than this to chats with an address"},
+	{"TEAMS_APP_ID", "(disabled)", "Bot Framework app ID of the Microsoft Teams bot"},
+	{"TEAMS_APP_PASSWORD", "", "client secret of the Teams bot's app"},
+	{"TEAMS_TENANT_ID", "", "tenant of a single-tenant app; other tenants are refused"},
+	{"TEAMS_LISTEN", ":3978", "listen address of the Teams messaging endpoint (/api/messages)"},
+	{"TEAMS_ALLOWED_USERS", "(whole tenant)", "Azure AD object IDs allowed to use the Teams bot"},
+	{"FORGE_TYPE", "(disabled)", "gitea, forgejo or gitlab, for /pr, /mr and CI status in /status"},
+	{"FORGE_URL", "(gitlab.com for gitlab)", "base URL of the forge, e.g. https://git.example.com"},
+	{"FORGE_TOKEN", "", "forge access token allowed to open pull requests"},
+	{"FORGE_REPOS", "", "session directories and their repos: /dir=owner/repo,..."},
+	{"ISSUE_TRACKER", "(disabled)", "github, gitlab, linear, gitea or forgejo, for /issue"},
+	{"ISSUE_TRACKER_URL", "(hosted service)", "API base URL; required for gitea and forgejo"},
+	{"ISSUE_TRACKER_TOKEN", "", "token allowed to file issues"},
+	{"ISSUE_PROJECT", "", "owner/repo, GitLab project path or Linear team ID"},
+	{"GIST_TOKEN", "(disabled)", "GitHub token with the gist scope, for Share as Gist under /diff"},
+	{"GIST_API_URL", "https://api.github.com", "GitHub API base URL, e.g. https://github.example.com/api/v3"},
+	{"OPENCODE_SERVICE", "(disabled)", "systemd, docker, exec or serve: how /oc restarts the OpenCode server"},
+	{"OPENCODE_SERVICE_TARGET", "", "the systemd unit, Docker container, or command line exec runs"},
+	{"OPENCODE_BIN", "opencode", "binary OPENCODE_SERVICE=serve runs"},
+	{"OPENCODE_ARGS", "", "further arguments to opencode serve"},
+	{"OPENCODE_PORT", "(OPENCODE_URL's)", "port opencode serve listens on"},
+	{"TENANTS", "", "OpenCode instances of their own: name=url or name=serve:port, then ,/workdir;..."},
+	{"TENANT_CHATS", "", "tenant each chat belongs to: chatID=name,..."},
+	{"TRANSCRIPT_CHAT", "(disabled)", "channel or group every prompt and reply is copied to: chatID[/topic]"},
+	{"AGENTS", "", "agent list: name:desc,name:desc"},
+	{"COMMAND_ALIASES", "", "command shortcuts: alias=command,alias=command"},
+	{"COMMANDS_DISABLED", "", "commands turned off for everyone: purge,run,delete,..."},
+	{"DEFAULT_AGENT", "", "agent for chats without a stored choice"},
+	{"DEFAULT_MODEL", "", "provider/model for chats without a stored choice"},
+	{"SUMMARY_MODEL", "(chat's model)", "cheap provider/model for the Summarize button under /diff"},
+	{"AUTO_COMPACT", "off", "compact sessions past this % of the context window after a reply, e.g. 85"},
+	{"ARCHIVE_AFTER", "off", "archive sessions idle this long, hiding them from /sessions, e.g. 336h"},
+	{"LEADERBOARD", "admin", "who may see the /leaderboard of usage: admin, all or off"},
+	{"TELEGRAM_PROXY", "(HTTPS_PROXY)", "proxy for the Telegram API"},
+	{"OPENCODE_PROXY", "(HTTP(S)_PROXY)", "proxy for the OpenCode server"},
+	{"OPENCODE_CA_FILE", "", "extra CA bundle for the OpenCode URL"},
+	{"OPENCODE_CLIENT_CERT", "", "client certificate for the OpenCode URL"},
+	{"OPENCODE_CLIENT_KEY", "", "client key for the OpenCode URL"},
+	{"OPENCODE_TLS_INSECURE_SKIP_VERIFY", "false", "disable TLS verification (unsafe)"},
+	{"OPENCODE_TIMEOUT", "30s", "per-request timeout"},
+	{"OPENCODE_LONG_TIMEOUT", "5m", "timeout for diff and history fetches"},
+	{"OPENCODE_IDLE_CONN_TIMEOUT", "90s", "idle keep-alive connection lifetime"},
+	{"OPENCODE_MAX_IDLE_CONNS", "16", "max idle connections"},
+	{"OPENCODE_SSE_IDLE_TIMEOUT", "90s", "reconnect SSE after this much silence"},
+	{"OPENCODE_DEBUG", "false", "log OpenCode requests and responses"},
+	{"PRIVACY_MODE", "false", "never log or store prompt and reply text, only lengths and hashes"},
+	{"OPENCODE_LIST_CACHE_TTL", "5s", "cache session/provider lists this long"},
+	{"STREAM_EDIT_THROTTLE", "1s", "initial interval between streaming edits; adapts per chat"},
+	{"STREAM_PROGRESS", "true", "show a spinner while a reply is quiet"},
+	{"STREAM_STALL_WARNING", "45s", "quiet time before showing \"no output\""},
+	{"MAX_MESSAGE_LENGTH", "4000", "truncate long replies"},
+	{"HISTORY_LIMIT", "10", "messages shown by /history"},
+	{"SESSION_LIST_LIMIT", "20", "sessions shown by /sessions"},
+	{"EVENT_LOG_SIZE", "200", "recent SSE events kept for /events"},
+	{"WORKERS", "8", "chats whose updates are handled in parallel"},
+	{"CHAT_QUEUE_LIMIT", "20", "updates queued per chat before dropping"},
+	{"TELEGRAM_SEND_RATE", "25", "streamed sends/edits per second, all chats"},
+	{"POSTPROCESS", "", "reply processors, in order: trim, blanklines, forgelinks"},
+	{"MAX_RUNNING_PROMPTS", "0", "prompts one user may run at once across chats (0 = no limit)"},
+	{"MAX_CONCURRENT_RUNS", "0", "prompts run at once across all users; more are queued (0 = no limit)"},
+}
+
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: %s [flags]\n       %s chat [flags] [prompt]\n\nFlags override the matching environment variables.\n\n", fs.Name(), fs.Name())
+	fs.PrintDefaults()
+	writeEnvDocs(w)
+}
+
+func writeEnvDocs(w io.Writer) {
+	fmt.Fprintln(w, "\nEnvironment (secrets may also be given as <NAME>_FILE):")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, d := range envDocs {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", d[0], d[1], d[2])
+	}
+	tw.Flush()
+}
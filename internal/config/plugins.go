@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// maxPluginsFileSize guards against pointing PLUGINS_FILE at something
+// that isn't a plugin list.
+const maxPluginsFileSize = 1 << 20
+
+// DefaultPluginTimeout bounds a plugin run without a timeout of its own.
+const DefaultPluginTimeout = 30 * time.Second
+
+// PluginName is what a plugin command may be called, as Telegram allows
+// for bot commands.
+var PluginName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// PluginSpec defines an extra command served outside the bot: a program
+// run with the chat's context as JSON on stdin, or an HTTP hook the JSON
+// is POSTed to. Either way its output is posted back to the chat.
+type PluginSpec struct {
+	Exec    []string `json:"exec,omitempty"`    // program and arguments, run without a shell
+	URL     string   `json:"url,omitempty"`     // http(s) hook
+	Token   string   `json:"token,omitempty"`   // bearer token sent to the hook
+	Args    string   `json:"args,omitempty"`    // usage shown in /help, e.g. "<ticket>"
+	Help    string   `json:"help,omitempty"`    // /help and command menu description
+	Admin   bool     `json:"admin,omitempty"`   // admins only
+	Timeout string   `json:"timeout,omitempty"` // e.g. "2m" (default 30s)
+}
+
+// Check reports what is wrong with p, if anything.
+func (p PluginSpec) Check() error {
+	switch {
+	case len(p.Exec) > 0 && p.URL != "":
+		return errors.New("set exec or url, not both")
+	case len(p.Exec) == 0 && p.URL == "":
+		return errors.New("set exec or url")
+	case p.URL != "":
+		if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url %q: expected http(s)://host/path", p.URL)
+		}
+	case p.Exec[0] == "":
+		return errors.New("exec: empty program")
+	}
+	if p.Timeout != "" {
+		if d, err := time.ParseDuration(p.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("timeout %q: expected a duration such as 30s", p.Timeout)
+		}
+	}
+	return nil
+}
+
+// RunTimeout is how long a run of p may take.
+func (p PluginSpec) RunTimeout() time.Duration {
+	if d, err := time.ParseDuration(p.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultPluginTimeout
+}
+
+// LoadPlugins reads the JSON object of command name -> plugin at path. An
+// empty path means no plugins.
+func LoadPlugins(path string) (map[string]PluginSpec, error) {
+	plugins := make(map[string]PluginSpec)
+	if path == "" {
+		return plugins, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxPluginsFileSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, maxPluginsFileSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &plugins); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, p := range plugins {
+		if !PluginName.MatchString(name) {
+			return nil, fmt.Errorf("plugin %q: names are lowercase letters, digits and _", name)
+		}
+		if err := p.Check(); err != nil {
+			return nil, fmt.Errorf("plugin %q: %w", name, err)
+		}
+	}
+	return plugins, nil
+}
+
+// PluginNames returns the PLUGINS_FILE names in order.
+func (c *Config) PluginNames() []string {
+	names := make([]string, 0, len(c.Plugins))
+	for name := range c.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Access is the subset of configuration that can be reloaded at runtime.
+type Access struct {
+	AllowedUsers map[int64]bool
+	AdminUsers   map[int64]bool
+	Agents       map[string]string
+}
+
+// LoadAccess re-reads ALLOWED_USERS, ADMIN_USERS and AGENTS. Values in
+// envFile (KEY=VALUE lines, as used by start.sh and systemd) take
+// precedence over the process environment, which can't change after
+// startup. A missing envFile is not an error.
+func LoadAccess(envFile string) (Access, error) {
+	vars := map[string]string{}
+	if envFile != "" {
+		fileVars, err := ReadEnvFile(envFile)
+		if err != nil && !os.IsNotExist(err) {
+			return Access{}, err
+		}
+		vars = fileVars
+	}
+	get := func(key string) string {
+		if v, ok := vars[key]; ok {
+			return v
+		}
+		return os.Getenv(key)
+	}
+
+	agents, err := ParseAgents(get("AGENTS"))
+	if err != nil {
+		return Access{}, fmt.Errorf("AGENTS: %w", err)
+	}
+	return Access{
+		AllowedUsers: parseUserList(get("ALLOWED_USERS")),
+		AdminUsers:   parseUserList(get("ADMIN_USERS")),
+		Agents:       agents,
+	}, nil
+}
+
+// ReadEnvFile parses a .env file of KEY=VALUE lines. Blank lines,
+// comments and an optional "export " prefix are ignored; values may be
+// wrapped in single or double quotes.
+func ReadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseEnv(f, path)
+}
+
+// parseEnv reads KEY=VALUE lines from r; name labels errors.
+func parseEnv(r io.Reader, name string) (map[string]string, error) {
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", name, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	return vars, nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// maxSecretFileSize guards against pointing a *_FILE variable at
+// something that clearly isn't a secret.
+const maxSecretFileSize = 64 * 1024
+
+// envSecret returns the value of key, the contents of the file named by
+// key+"_FILE" (Docker/Kubernetes secrets), or its entry in the encrypted
+// SECRETS_FILE. Surrounding whitespace and the trailing newline most
+// secret files carry are trimmed. Setting more than one source is an
+// error so it's never ambiguous which one is in effect.
+func envSecret(key string) string {
+	v, err := readSecret(key)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return v
+}
+
+func readSecret(key string) (string, error) {
+	v, err := readPlainSecret(key)
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := encryptedSecrets()
+	if err != nil {
+		return "", err
+	}
+	if ev, ok := encrypted[key]; ok {
+		if v != "" {
+			return "", fmt.Errorf("%s is set both directly and in SECRETS_FILE", key)
+		}
+		return strings.TrimSpace(ev), nil
+	}
+	return v, nil
+}
+
+// readPlainSecret reads key or key+"_FILE", ignoring SECRETS_FILE.
+func readPlainSecret(key string) (string, error) {
+	direct := os.Getenv(key)
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return strings.TrimSpace(direct), nil
+	}
+	if direct != "" {
+		return "", fmt.Errorf("both %s and %s_FILE are set", key, key)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("read %s_FILE: %w", key, err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s_FILE %s is a directory", key, path)
+	}
+	if info.Size() > maxSecretFileSize {
+		return "", fmt.Errorf("%s_FILE %s is too large (%d bytes)", key, path, info.Size())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read %s_FILE: %w", key, err)
+	}
+	v := strings.TrimSpace(string(data))
+	if v == "" {
+		return "", fmt.Errorf("%s_FILE %s is empty", key, path)
+	}
+	if strings.ContainsAny(v, "\r\n") {
+		return "", fmt.Errorf("%s_FILE %s contains more than one line", key, path)
+	}
+	return v, nil
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// maxTemplatesFileSize guards against pointing SESSION_TEMPLATES_FILE at
+// something that isn't a template list.
+const maxTemplatesFileSize = 1 << 20
+
+// TemplateName is what a session template may be called: one word, so
+// "/newfrom <name>" is unambiguous.
+var TemplateName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// SessionTemplate bundles what /newfrom sets up a session with. Empty
+// fields fall back to the chat's usual defaults.
+type SessionTemplate struct {
+	Agent  string `json:"agent,omitempty"`
+	Model  string `json:"model,omitempty"`  // "provider/model"
+	Dir    string `json:"dir,omitempty"`    // absolute directory the session starts in
+	System string `json:"system,omitempty"` // system prompt sent with each prompt
+}
+
+// Check reports what is wrong with t, if anything.
+func (t SessionTemplate) Check() error {
+	if t.Model != "" {
+		if _, _, ok := SplitModel(t.Model); !ok {
+			return fmt.Errorf("model %q: expected provider/model", t.Model)
+		}
+	}
+	if t.Dir != "" && !filepath.IsAbs(t.Dir) {
+		return fmt.Errorf("dir %q: expected an absolute path", t.Dir)
+	}
+	if t == (SessionTemplate{}) {
+		return fmt.Errorf("sets nothing")
+	}
+	return nil
+}
+
+// LoadTemplates reads the JSON object of name -> template at path. An
+// empty path means no templates.
+func LoadTemplates(path string) (map[string]SessionTemplate, error) {
+	templates := make(map[string]SessionTemplate)
+	if path == "" {
+		return templates, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxTemplatesFileSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, maxTemplatesFileSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, t := range templates {
+		if !TemplateName.MatchString(name) {
+			return nil, fmt.Errorf("template %q: names are letters, digits, - and _", name)
+		}
+		if err := t.Check(); err != nil {
+			return nil, fmt.Errorf("template %q: %w", name, err)
+		}
+		if t.Dir != "" {
+			t.Dir = filepath.Clean(t.Dir)
+			templates[name] = t
+		}
+	}
+	return templates, nil
+}
+
+// TemplateNames returns the SESSION_TEMPLATES_FILE names in order.
+func (c *Config) TemplateNames() []string {
+	names := make([]string, 0, len(c.Templates))
+	for name := range c.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,468 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/forge"
+	"github.com/Khaledxab/Openkh/internal/gist"
+	"github.com/Khaledxab/Openkh/internal/logging"
+	"github.com/Khaledxab/Openkh/internal/mailer"
+	"github.com/Khaledxab/Openkh/internal/postprocess"
+	"github.com/Khaledxab/Openkh/internal/tracker"
+)
+
+// tokenPattern matches a BotFather token: numeric bot ID, colon, secret.
+var tokenPattern = regexp.MustCompile(`^[0-9]{5,}:[A-Za-z0-9_-]{30,}$`)
+
+// webhookSecretPattern is what setWebhook accepts as secret_token.
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// Validate checks the loaded configuration for mistakes that would
+// otherwise only surface at runtime. All problems are reported at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if !c.chatOnly && !tokenPattern.MatchString(c.TelegramToken) {
+		errs = append(errs, errors.New("TELEGRAM_BOT_TOKEN does not look like a BotFather token (<id>:<secret>)"))
+	}
+	if err := validateHTTPURL(c.OpenCodeURL); err != nil {
+		errs = append(errs, fmt.Errorf("OPENCODE_URL: %w", err))
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	if c.WebhookURL != "" {
+		if u, err := url.Parse(c.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, errors.New("WEBHOOK_URL: Telegram requires a public https:// URL"))
+		}
+		if c.WebhookSecret != "" && !webhookSecretPattern.MatchString(c.WebhookSecret) {
+			errs = append(errs, errors.New("WEBHOOK_SECRET: Telegram accepts 1-256 characters of A-Z, a-z, 0-9, _ and -"))
+		}
+	}
+	if c.APIListen != "" && len(c.APITokens) == 0 {
+		errs = append(errs, errors.New("API_LISTEN: set API_TOKENS too, the chat API refuses requests without a token"))
+	}
+	if c.SMTPURL != "" {
+		if _, err := mailer.New(c.SMTPURL, c.SMTPFrom); err != nil {
+			errs = append(errs, fmt.Errorf("SMTP_URL/SMTP_FROM: %w", err))
+		}
+	}
+	if c.TeamsAppID != "" {
+		if c.TeamsAppPassword == "" {
+			errs = append(errs, errors.New("TEAMS_APP_ID: set TEAMS_APP_PASSWORD too, the bot can't reply without it"))
+		}
+		if c.TeamsTenantID == "" && len(c.TeamsAllowedUsers) == 0 {
+			errs = append(errs, errors.New("TEAMS_APP_ID: set TEAMS_TENANT_ID or TEAMS_ALLOWED_USERS, or anyone on Teams could use the bot"))
+		}
+	}
+	if c.ForgeType != "" {
+		if _, err := forge.New(c.ForgeType, c.ForgeURL, c.ForgeToken); err != nil {
+			errs = append(errs, fmt.Errorf("FORGE_TYPE/FORGE_URL/FORGE_TOKEN: %w", err))
+		}
+		if len(c.ForgeRepos) == 0 {
+			errs = append(errs, errors.New("FORGE_TYPE: set FORGE_REPOS too, so sessions can be matched to repositories"))
+		}
+	}
+	if len(c.Postprocess) > 0 {
+		if _, err := postprocess.New(c.Postprocess, postprocess.Options{ForgeType: c.ForgeType, ForgeURL: c.ForgeURL, ForgeRepos: c.ForgeRepos}); err != nil {
+			errs = append(errs, fmt.Errorf("POSTPROCESS: %w", err))
+		}
+		if slices.Contains(c.Postprocess, "forgelinks") && c.ForgeType == "" {
+			errs = append(errs, errors.New("POSTPROCESS: forgelinks needs FORGE_TYPE, to look up the branch links point at"))
+		}
+	}
+	if c.IssueTracker != "" {
+		if _, err := tracker.New(c.IssueTracker, c.IssueTrackerURL, c.IssueTrackerToken); err != nil {
+			errs = append(errs, fmt.Errorf("ISSUE_TRACKER/ISSUE_TRACKER_URL/ISSUE_TRACKER_TOKEN: %w", err))
+		}
+		if c.IssueProject == "" {
+			errs = append(errs, errors.New("ISSUE_TRACKER: set ISSUE_PROJECT too, so /issue knows where to file"))
+		}
+	}
+	if c.GistToken != "" {
+		if _, err := gist.New(c.GistAPIURL, c.GistToken); err != nil {
+			errs = append(errs, fmt.Errorf("GIST_API_URL: %w", err))
+		}
+	}
+	if _, err := c.OpenCodeServer(); err != nil {
+		errs = append(errs, fmt.Errorf("OPENCODE_SERVICE: %w", err))
+	}
+	if _, err := ParseAgents(c.Agents); err != nil {
+		errs = append(errs, fmt.Errorf("AGENTS: %w", err))
+	}
+	if _, err := ParseAliases(c.Aliases); err != nil {
+		errs = append(errs, fmt.Errorf("COMMAND_ALIASES: %w", err))
+	}
+
+	switch c.DBDriver {
+	case "sqlite":
+		if err := checkWritableDir(filepath.Dir(c.DBPath)); err != nil {
+			errs = append(errs, fmt.Errorf("DB_PATH: %w", err))
+		}
+	case "memory":
+	case "redis":
+		if u, err := url.Parse(c.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			errs = append(errs, fmt.Errorf("REDIS_URL: expected redis://host:port/db"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("DB_DRIVER: unknown driver %q (use sqlite, memory or redis)", c.DBDriver))
+	}
+
+	if info, err := os.Stat(c.WorkDir); err != nil {
+		errs = append(errs, fmt.Errorf("WORK_DIR: %w", err))
+	} else if !info.IsDir() {
+		errs = append(errs, fmt.Errorf("WORK_DIR: %s is not a directory", c.WorkDir))
+	}
+	if len(c.AllowedDirs) > 0 {
+		if abs, err := filepath.Abs(c.WorkDir); err != nil || !c.DirAllowed(abs) {
+			errs = append(errs, fmt.Errorf("WORK_DIR: %s is outside ALLOWED_DIRS", c.WorkDir))
+		}
+	}
+	for _, name := range c.RepoNames() {
+		if !c.DirAllowed(c.Repos[name]) {
+			errs = append(errs, fmt.Errorf("REPOS: %s (%s) is outside ALLOWED_DIRS", name, c.Repos[name]))
+		}
+	}
+	for chatID, name := range c.ChatRepos {
+		if _, ok := c.Repos[name]; !ok {
+			errs = append(errs, fmt.Errorf("CHAT_REPOS: chat %d uses %q, which REPOS doesn't define", chatID, name))
+		}
+	}
+	ports := make(map[int]string)
+	for _, name := range c.TenantNames() {
+		t := c.Tenants[name]
+		if t.WorkDir != "" && !c.DirAllowed(t.WorkDir) {
+			errs = append(errs, fmt.Errorf("TENANTS: %s (%s) is outside ALLOWED_DIRS", name, t.WorkDir))
+		}
+		if t.Port == 0 {
+			continue
+		}
+		if other, dup := ports[t.Port]; dup {
+			errs = append(errs, fmt.Errorf("TENANTS: %s and %s both serve on port %d", other, name, t.Port))
+		}
+		ports[t.Port] = name
+	}
+	for chatID, name := range c.TenantChats {
+		if _, ok := c.Tenants[name]; !ok {
+			errs = append(errs, fmt.Errorf("TENANT_CHATS: chat %d uses %q, which TENANTS doesn't define", chatID, name))
+		}
+	}
+	if c.TranscriptChat != 0 && c.PrivacyMode {
+		errs = append(errs, fmt.Errorf("TRANSCRIPT_CHAT: PRIVACY_MODE keeps prompts and replies out of everything but their own chat"))
+	}
+	for _, name := range c.TemplateNames() {
+		if dir := c.Templates[name].Dir; dir != "" && !c.DirAllowed(dir) {
+			errs = append(errs, fmt.Errorf("SESSION_TEMPLATES_FILE: template %s (%s) is outside ALLOWED_DIRS", name, dir))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParseAgents parses the AGENTS value ("name:description,name") into a
+// name -> description map, rejecting empty or duplicate names.
+func ParseAgents(raw string) (map[string]string, error) {
+	agents := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, desc, _ := strings.Cut(pair, ":")
+		name = strings.TrimSpace(name)
+		desc = strings.TrimSpace(desc)
+		if name == "" {
+			return nil, fmt.Errorf("entry %q has no agent name", pair)
+		}
+		if strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("agent name %q contains whitespace", name)
+		}
+		if _, dup := agents[name]; dup {
+			return nil, fmt.Errorf("agent %q listed twice", name)
+		}
+		if desc == "" {
+			desc = name
+		}
+		agents[name] = desc
+	}
+	return agents, nil
+}
+
+// commandName matches what Telegram accepts as a bot command.
+var commandName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// ParseAliases parses COMMAND_ALIASES ("d=diff,s=sessions") into an
+// alias -> command map. Leading slashes are optional on both sides.
+func ParseAliases(raw string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		alias, target, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("entry %q: expected alias=command", pair)
+		}
+		alias = strings.TrimPrefix(strings.TrimSpace(alias), "/")
+		target = strings.TrimPrefix(strings.TrimSpace(target), "/")
+		if !commandName.MatchString(alias) || !commandName.MatchString(target) {
+			return nil, fmt.Errorf("entry %q: names must be 1-32 lowercase letters, digits or underscores", pair)
+		}
+		if _, dup := aliases[alias]; dup {
+			return nil, fmt.Errorf("alias %q listed twice", alias)
+		}
+		aliases[alias] = target
+	}
+	return aliases, nil
+}
+
+func validateHTTPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	return nil
+}
+
+// checkWritableDir verifies dir exists (creating it if needed) and that a
+// file can be created in it.
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".openkh-check-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// WriteSummary prints the effective configuration with secrets masked,
+// one "KEY=value" line per setting.
+func (c *Config) WriteSummary(w io.Writer) {
+	settings := map[string]string{
+		"TELEGRAM_BOT_TOKEN":                maskToken(c.TelegramToken),
+		"OPENCODE_URL":                      c.OpenCodeURL,
+		"OPENCODE_API_KEY":                  maskSecret(c.OpenCodeKey),
+		"TELEGRAM_PROXY":                    redactURL(c.TelegramProxy),
+		"OPENCODE_PROXY":                    redactURL(c.OpenCodeProxy),
+		"OPENCODE_CA_FILE":                  c.OpenCodeTLS.CAFile,
+		"OPENCODE_CLIENT_CERT":              c.OpenCodeTLS.CertFile,
+		"OPENCODE_CLIENT_KEY":               c.OpenCodeTLS.KeyFile,
+		"OPENCODE_TLS_INSECURE_SKIP_VERIFY": strconv.FormatBool(c.OpenCodeTLS.InsecureSkipVerify),
+		"ALLOWED_USERS":                     userList(c.AllowedUsers),
+		"ADMIN_USERS":                       userList(c.AdminUsers),
+		"DENIED_TOOLS":                      toolList(c.DeniedTools),
+		"ADMIN_DENIED_TOOLS":                toolList(c.AdminDeniedTools),
+		"TOOL_POLICY":                       FormatToolPolicy(c.ToolPolicy),
+		"PRIVACY_MODE":                      strconv.FormatBool(c.PrivacyMode),
+		"ADMIN_TOOL_POLICY":                 FormatToolPolicy(c.AdminToolPolicy),
+		"WORK_DIR":                          c.WorkDir,
+		"ALLOWED_DIRS":                      strings.Join(c.AllowedDirs, ","),
+		"REPOS":                             strings.Join(c.RepoNames(), ","),
+		"CHAT_REPOS":                        fmt.Sprintf("%d chat(s)", len(c.ChatRepos)),
+		"SESSION_TEMPLATES_FILE":            strings.Join(c.TemplateNames(), ","),
+		"RUN_COMMANDS":                      strings.Join(c.RunTargets(), ","),
+		"PLUGINS_FILE":                      strings.Join(c.PluginNames(), ","),
+		"DB_DRIVER":                         c.DBDriver,
+		"DB_PATH":                           c.DBPath,
+		"REDIS_URL":                         redactRawURL(c.RedisURL),
+		"STORE_SLOW_QUERY":                  c.SlowQuery.String(),
+		"INSTANCE_ID":                       c.InstanceID,
+		"LEASE_TTL":                         c.LeaseTTL.String(),
+		"METRICS_ADDR":                      c.MetricsAddr,
+		"LOG_LEVEL":                         c.LogLevel,
+		"ENV_FILE":                          c.EnvFile,
+		"BOOT_SELFTEST":                     strconv.FormatBool(c.BootSelfTest),
+		"SENTRY_DSN":                        redactRawURL(c.SentryDSN),
+		"ERROR_WEBHOOK_URL":                 maskSecret(c.ErrorWebhook),
+		"ERROR_REPORT_ENV":                  c.ErrorEnv,
+		"WEBHOOK_URL":                       c.WebhookURL,
+		"WEBHOOK_LISTEN":                    c.WebhookListen,
+		"WEBHOOK_SECRET":                    maskSecret(c.WebhookSecret),
+		"WEBHOOK_ALLOWED_IPS":               ipRanges(c.WebhookAllowedIPs),
+		"WEBHOOK_TRUSTED_PROXIES":           ipRanges(c.WebhookTrustedProxies),
+		"WEBHOOK_MAX_BODY":                  strconv.FormatInt(c.WebhookMaxBody, 10),
+		"API_LISTEN":                        c.APIListen,
+		"API_TOKENS":                        fmt.Sprintf("%d token(s)", len(c.APITokens)),
+		"SMTP_URL":                          redactRawURL(c.SMTPURL),
+		"SMTP_FROM":                         c.SMTPFrom,
+		"EMAIL_AFTER":                       c.EmailAfter.String(),
+		"TEAMS_APP_ID":                      c.TeamsAppID,
+		"TEAMS_APP_PASSWORD":                maskSecret(c.TeamsAppPassword),
+		"TEAMS_TENANT_ID":                   c.TeamsTenantID,
+		"TEAMS_LISTEN":                      c.TeamsListen,
+		"TEAMS_ALLOWED_USERS":               fmt.Sprintf("%d user(s)", len(c.TeamsAllowedUsers)),
+		"FORGE_TYPE":                        c.ForgeType,
+		"FORGE_URL":                         c.ForgeURL,
+		"FORGE_TOKEN":                       maskSecret(c.ForgeToken),
+		"FORGE_REPOS":                       fmt.Sprintf("%d repo(s)", len(c.ForgeRepos)),
+		"ISSUE_TRACKER":                     c.IssueTracker,
+		"ISSUE_TRACKER_URL":                 c.IssueTrackerURL,
+		"ISSUE_TRACKER_TOKEN":               maskSecret(c.IssueTrackerToken),
+		"ISSUE_PROJECT":                     c.IssueProject,
+		"GIST_TOKEN":                        maskSecret(c.GistToken),
+		"GIST_API_URL":                      c.GistAPIURL,
+		"OPENCODE_SERVICE":                  c.OpenCodeService,
+		"OPENCODE_SERVICE_TARGET":           c.OpenCodeServiceTarget,
+		"OPENCODE_BIN":                      c.OpenCodeBin,
+		"OPENCODE_ARGS":                     strings.Join(c.OpenCodeArgs, " "),
+		"OPENCODE_PORT":                     strconv.Itoa(c.OpenCodePort),
+		"TENANTS":                           strings.Join(c.TenantNames(), ","),
+		"TENANT_CHATS":                      fmt.Sprintf("%d chat(s)", len(c.TenantChats)),
+		"TRANSCRIPT_CHAT":                   transcriptText(c.TranscriptChat, c.TranscriptTopic),
+		"SECRETS_FILE":                      os.Getenv("SECRETS_FILE"),
+		"AGENTS":                            c.Agents,
+		"COMMAND_ALIASES":                   c.Aliases,
+		"COMMANDS_DISABLED":                 toolList(c.DisabledCommands),
+		"DEFAULT_AGENT":                     c.DefaultAgent,
+		"DEFAULT_MODEL":                     c.DefaultModel,
+		"SUMMARY_MODEL":                     c.SummaryModel,
+		"AUTO_COMPACT":                      autoCompactText(c.AutoCompact),
+		"ARCHIVE_AFTER":                     archiveAfterText(c.ArchiveAfter),
+		"LEADERBOARD":                       c.Leaderboard,
+		"OPENCODE_TIMEOUT":                  c.HTTPTimeout.String(),
+		"OPENCODE_LONG_TIMEOUT":             c.HTTPLongTimeout.String(),
+		"OPENCODE_IDLE_CONN_TIMEOUT":        c.HTTPIdleTimeout.String(),
+		"OPENCODE_MAX_IDLE_CONNS":           strconv.Itoa(c.HTTPMaxIdle),
+		"OPENCODE_SSE_IDLE_TIMEOUT":         c.SSEIdleTimeout.String(),
+		"OPENCODE_DEBUG":                    strconv.FormatBool(c.HTTPDebug),
+		"OPENCODE_LIST_CACHE_TTL":           c.ListCacheTTL.String(),
+		"STREAM_EDIT_THROTTLE":              c.EditThrottle.String(),
+		"STREAM_PROGRESS":                   strconv.FormatBool(c.StreamProgress),
+		"STREAM_STALL_WARNING":              c.StallWarning.String(),
+		"MAX_MESSAGE_LENGTH":                strconv.Itoa(c.MaxMessageLen),
+		"HISTORY_LIMIT":                     strconv.Itoa(c.HistoryLimit),
+		"SESSION_LIST_LIMIT":                strconv.Itoa(c.SessionListLimit),
+		"EVENT_LOG_SIZE":                    strconv.Itoa(c.EventLogSize),
+		"WORKERS":                           strconv.Itoa(c.Workers),
+		"CHAT_QUEUE_LIMIT":                  strconv.Itoa(c.ChatQueueLimit),
+		"TELEGRAM_SEND_RATE":                strconv.Itoa(c.SendRate),
+		"POSTPROCESS":                       strings.Join(c.Postprocess, ","),
+		"MAX_RUNNING_PROMPTS":               strconv.Itoa(c.MaxRunningPrompts),
+		"MAX_CONCURRENT_RUNS":               strconv.Itoa(c.MaxConcurrentRuns),
+	}
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s=%s\n", k, settings[k])
+	}
+}
+
+// maskToken keeps the public bot ID and hides the secret half.
+func maskToken(token string) string {
+	if id, _, ok := strings.Cut(token, ":"); ok {
+		return id + ":****"
+	}
+	return maskSecret(token)
+}
+
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "****"
+}
+
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+func redactRawURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return maskSecret(raw)
+	}
+	return u.Redacted()
+}
+
+func userList(users map[int64]bool) string {
+	ids := make([]int64, 0, len(users))
+	for id := range users {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func toolList(tools map[string]bool) string {
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func ipRanges(nets []*net.IPNet) string {
+	parts := make([]string, len(nets))
+	for i, n := range nets {
+		parts[i] = n.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// FormatToolPolicy renders a policy as sorted "tool=mode" pairs.
+func FormatToolPolicy(policy map[string]string) string {
+	pairs := make([]string, 0, len(policy))
+	for tool, mode := range policy {
+		pairs = append(pairs, tool+"="+mode)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func autoCompactText(percent int) string {
+	if percent == 0 {
+		return "off"
+	}
+	return strconv.Itoa(percent) + "%"
+}
+
+func archiveAfterText(d time.Duration) string {
+	if d == 0 {
+		return "off"
+	}
+	return d.String()
+}
+
+func transcriptText(chatID int64, topic int) string {
+	switch {
+	case chatID == 0:
+		return "off"
+	case topic == 0:
+		return strconv.FormatInt(chatID, 10)
+	}
+	return fmt.Sprintf("%d/%d", chatID, topic)
+}
@@ -0,0 +1,279 @@
+// Package errreport forwards panics and notable errors to an external
+// collector: Sentry (via its store API) or a generic JSON webhook. It is a
+// no-op until Setup is called with a destination.
+package errreport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options selects where reports go. Either or both may be set.
+type Options struct {
+	SentryDSN   string
+	WebhookURL  string
+	Environment string
+	Release     string
+}
+
+// Fields is request context attached to a report (chat, session, ...).
+type Fields map[string]string
+
+// Event is one captured error.
+type Event struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"` // "error" or "fatal" (panic)
+	Message string    `json:"message"`
+	Fields  Fields    `json:"fields,omitempty"`
+	Stack   string    `json:"stack,omitempty"`
+}
+
+const (
+	queueSize     = 64
+	dedupeWindow  = time.Minute
+	failureReport = 5 // report every Nth consecutive failure per key
+)
+
+type reporter struct {
+	opts   Options
+	sentry *sentryTarget
+	client *http.Client
+	queue  chan Event
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	failures map[string]int
+}
+
+var (
+	mu      sync.RWMutex
+	current *reporter
+)
+
+// Setup enables reporting. It returns an error for a malformed DSN.
+func Setup(opts Options) error {
+	if opts.SentryDSN == "" && opts.WebhookURL == "" {
+		return nil
+	}
+	r := &reporter{
+		opts:     opts,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan Event, queueSize),
+		lastSent: make(map[string]time.Time),
+		failures: make(map[string]int),
+	}
+	if opts.SentryDSN != "" {
+		t, err := parseDSN(opts.SentryDSN)
+		if err != nil {
+			return fmt.Errorf("parse SENTRY_DSN: %w", err)
+		}
+		r.sentry = t
+	}
+	go r.run()
+
+	mu.Lock()
+	current = r
+	mu.Unlock()
+	log.Printf("[errreport] Error reporting enabled (sentry=%t, webhook=%t)", r.sentry != nil, opts.WebhookURL != "")
+	return nil
+}
+
+func get() *reporter {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Capture reports err with its context. Identical messages are reported
+// at most once a minute.
+func Capture(err error, fields Fields) {
+	if err == nil {
+		return
+	}
+	if r := get(); r != nil {
+		r.enqueue("error", err.Error(), fields, "")
+	}
+}
+
+// Recover reports a panic in progress and swallows it. Use as
+// "defer errreport.Recover(fields)" at the top of goroutines and handlers.
+func Recover(fields Fields) {
+	if p := recover(); p != nil {
+		CapturePanic(p, fields)
+	}
+}
+
+// CapturePanic reports a recovered panic value with the current stack.
+func CapturePanic(p interface{}, fields Fields) {
+	stack := string(debug.Stack())
+	log.Printf("[errreport] panic: %v\n%s", p, stack)
+	if r := get(); r != nil {
+		r.enqueue("fatal", fmt.Sprintf("panic: %v", p), fields, stack)
+	}
+}
+
+// Failure counts a consecutive failure for key and reports every
+// failureReport-th one, so a flapping dependency is visible without
+// reporting each individual error.
+func Failure(key string, err error, fields Fields) {
+	r := get()
+	if r == nil || err == nil {
+		return
+	}
+	r.mu.Lock()
+	r.failures[key]++
+	n := r.failures[key]
+	r.mu.Unlock()
+	if n%failureReport != 0 {
+		return
+	}
+	f := Fields{"failure_key": key, "consecutive_failures": fmt.Sprint(n)}
+	for k, v := range fields {
+		f[k] = v
+	}
+	r.enqueue("error", fmt.Sprintf("%s: %d consecutive failures: %v", key, n, err), f, "")
+}
+
+// Success resets the consecutive-failure count for key.
+func Success(key string) {
+	r := get()
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.failures, key)
+	r.mu.Unlock()
+}
+
+func (r *reporter) enqueue(level, message string, fields Fields, stack string) {
+	now := time.Now()
+	r.mu.Lock()
+	if last, ok := r.lastSent[message]; ok && now.Sub(last) < dedupeWindow {
+		r.mu.Unlock()
+		return
+	}
+	r.lastSent[message] = now
+	for msg, t := range r.lastSent {
+		if now.Sub(t) >= dedupeWindow {
+			delete(r.lastSent, msg)
+		}
+	}
+	r.mu.Unlock()
+
+	ev := Event{ID: newEventID(), Time: now.UTC(), Level: level, Message: message, Fields: fields, Stack: stack}
+	select {
+	case r.queue <- ev:
+	default:
+		log.Printf("[errreport] Queue full, dropping report: %s", message)
+	}
+}
+
+func (r *reporter) run() {
+	for ev := range r.queue {
+		if r.sentry != nil {
+			if err := r.sendSentry(ev); err != nil {
+				log.Printf("[errreport] Sentry delivery failed: %v", err)
+			}
+		}
+		if r.opts.WebhookURL != "" {
+			if err := r.sendWebhook(ev); err != nil {
+				log.Printf("[errreport] Webhook delivery failed: %v", err)
+			}
+		}
+	}
+}
+
+func (r *reporter) sendWebhook(ev Event) error {
+	payload := struct {
+		Event
+		Environment string `json:"environment,omitempty"`
+		Release     string `json:"release,omitempty"`
+	}{ev, r.opts.Environment, r.opts.Release}
+	return r.post(r.opts.WebhookURL, payload, nil)
+}
+
+func (r *reporter) post(target string, payload interface{}, headers map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sentryTarget is the parsed form of https://<key>@<host>/<project>.
+type sentryTarget struct {
+	storeURL string
+	key      string
+}
+
+func parseDSN(dsn string) (*sentryTarget, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("missing public key")
+	}
+	project := strings.TrimPrefix(u.Path, "/")
+	if project == "" || u.Host == "" {
+		return nil, fmt.Errorf("expected https://<key>@<host>/<project>")
+	}
+	return &sentryTarget{
+		storeURL: fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		key:      u.User.Username(),
+	}, nil
+}
+
+func (r *reporter) sendSentry(ev Event) error {
+	extra := map[string]string{}
+	if ev.Stack != "" {
+		extra["stack"] = ev.Stack
+	}
+	payload := map[string]interface{}{
+		"event_id":    ev.ID,
+		"timestamp":   ev.Time.Format(time.RFC3339),
+		"level":       ev.Level,
+		"platform":    "go",
+		"logger":      "openkh",
+		"message":     ev.Message,
+		"tags":        ev.Fields,
+		"extra":       extra,
+		"environment": r.opts.Environment,
+		"release":     r.opts.Release,
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=openkh/1.0, sentry_key=%s", r.sentry.key)
+	return r.post(r.sentry.storeURL, payload, map[string]string{"X-Sentry-Auth": auth})
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,165 @@
+// Package forge talks to the git forge a session's repository lives on: it
+// opens pull requests and looks up CI status. Gitea and Forgejo share one
+// implementation, as Forgejo keeps Gitea's API; GitLab has its own.
+package forge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Forge is a git hosting service.
+type Forge interface {
+	// DefaultBranch returns the branch pull requests target unless told
+	// otherwise.
+	DefaultBranch(ctx context.Context, repo string) (string, error)
+	// CreatePullRequest opens a pull request in repo ("owner/name", or
+	// the project path on GitLab).
+	CreatePullRequest(ctx context.Context, repo string, pr NewPullRequest) (PullRequest, error)
+	// CommitStatus returns the combined CI status of ref, a branch,
+	// tag or commit.
+	CommitStatus(ctx context.Context, repo, ref string) (Status, error)
+}
+
+// NewPullRequest describes a pull request to open.
+type NewPullRequest struct {
+	Head  string // branch with the changes
+	Base  string // branch to merge into
+	Title string
+	Body  string
+}
+
+// PullRequest is an opened pull request, or merge request on GitLab.
+type PullRequest struct {
+	Number int
+	Ref    string // how the forge refers to it, e.g. "#12" or "!12"
+	URL    string
+}
+
+// Combined CI states.
+const (
+	StatePending = "pending"
+	StateSuccess = "success"
+	StateFailure = "failure"
+	StateError   = "error"
+	StateWarning = "warning"
+)
+
+// Status is the combined CI status of a ref. State is empty when no
+// checks reported on it.
+type Status struct {
+	State  string
+	Checks []Check
+}
+
+// Check is one CI job's status.
+type Check struct {
+	Name  string
+	State string
+	URL   string
+}
+
+// New returns the forge of kind ("gitea", "forgejo" or "gitlab") at
+// baseURL, authenticated with token. baseURL may be empty for the hosted
+// GitLab.
+func New(kind, baseURL, token string) (Forge, error) {
+	kind = strings.ToLower(kind)
+	if baseURL == "" && kind == "gitlab" {
+		baseURL = "https://gitlab.com"
+	}
+	switch kind {
+	case "gitea", "forgejo":
+		api, err := newClient(baseURL, "/api/v1", token, http.Header{"Authorization": {"token " + token}})
+		if err != nil {
+			return nil, err
+		}
+		return &gitea{api}, nil
+	case "gitlab":
+		api, err := newClient(baseURL, "/api/v4", token, http.Header{"Private-Token": {token}})
+		if err != nil {
+			return nil, err
+		}
+		return &gitlab{api}, nil
+	default:
+		return nil, fmt.Errorf("unsupported forge %q (want gitea, forgejo or gitlab)", kind)
+	}
+}
+
+// client makes the JSON requests of a forge's REST API.
+type client struct {
+	baseURL string
+	header  http.Header
+	http    *http.Client
+}
+
+// newClient returns a client of the API at apiPath under baseURL, sending
+// header with every request.
+func newClient(baseURL, apiPath, token string, header http.Header) (*client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("forge URL must be http(s)://host[/path], got %q", baseURL)
+	}
+	if token == "" {
+		return nil, errors.New("missing access token")
+	}
+	return &client{
+		baseURL: strings.TrimSuffix(baseURL, "/") + apiPath,
+		header:  header,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (c *client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		// Gitea says what went wrong in "message", GitLab in "message"
+		// (a string, list or object) or "error".
+		var e struct {
+			Message any    `json:"message"`
+			Error   string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		msg := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &e) == nil {
+			switch {
+			case e.Message != nil && e.Message != "":
+				msg = fmt.Sprint(e.Message)
+			case e.Error != "":
+				msg = e.Error
+			}
+		}
+		return fmt.Errorf("%s %s: HTTP %d: %s", method, path, resp.StatusCode, msg)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
@@ -0,0 +1,244 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testForge returns a forge of kind served by handler.
+func testForge(t *testing.T, kind string, handler http.HandlerFunc) Forge {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	f, err := New(kind, srv.URL, "tok")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return f
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		kind, baseURL, token string
+		wantErr              bool
+	}{
+		{"gitlab", "", "tok", false},
+		{"GitLab", "https://gitlab.example.com/", "tok", false},
+		{"gitlab", "gitlab.example.com", "tok", true},
+		{"gitlab", "", "", true},
+		{"gitea", "https://git.example.com", "tok", false},
+		{"Forgejo", "https://git.example.com", "tok", false},
+		{"gitea", "", "tok", true},
+		{"bitbucket", "https://bitbucket.org", "tok", true},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.kind, tt.baseURL, tt.token)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("New(%q, %q, %q) error = %v, want error %v", tt.kind, tt.baseURL, tt.token, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGitLabDefaultBranch(t *testing.T) {
+	tests := []struct {
+		repo, wantPath string
+	}{
+		{"team/app", "/api/v4/projects/team%2Fapp"},
+		{"group/sub/app", "/api/v4/projects/group%2Fsub%2Fapp"},
+		{"team/my app", "/api/v4/projects/team%2Fmy%20app"},
+	}
+	for _, tt := range tests {
+		f := testForge(t, "gitlab", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.EscapedPath() != tt.wantPath {
+				t.Errorf("%s: path = %s, want %s", tt.repo, r.URL.EscapedPath(), tt.wantPath)
+			}
+			if got := r.Header.Get("Private-Token"); got != "tok" {
+				t.Errorf("%s: Private-Token = %q, want %q", tt.repo, got, "tok")
+			}
+			w.Write([]byte(`{"default_branch":"main"}`))
+		})
+		branch, err := f.DefaultBranch(context.Background(), tt.repo)
+		if err != nil || branch != "main" {
+			t.Errorf("DefaultBranch(%q) = %q, %v, want \"main\"", tt.repo, branch, err)
+		}
+	}
+}
+
+func TestGitLabCreatePullRequest(t *testing.T) {
+	f := testForge(t, "gitlab", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.EscapedPath() != "/api/v4/projects/group%2Fsub%2Fapp/merge_requests" {
+			t.Errorf("request = %s %s", r.Method, r.URL.EscapedPath())
+		}
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		want := map[string]string{"source_branch": "feat/x", "target_branch": "main", "title": "Add x", "description": "Body"}
+		for k, v := range want {
+			if req[k] != v {
+				t.Errorf("%s = %q, want %q", k, req[k], v)
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":901,"iid":7,"web_url":"https://gitlab.com/group/sub/app/-/merge_requests/7"}`))
+	})
+	pr, err := f.CreatePullRequest(context.Background(), "group/sub/app", NewPullRequest{Head: "feat/x", Base: "main", Title: "Add x", Body: "Body"})
+	if err != nil {
+		t.Fatalf("CreatePullRequest: %v", err)
+	}
+	want := PullRequest{Number: 7, Ref: "!7", URL: "https://gitlab.com/group/sub/app/-/merge_requests/7"}
+	if pr != want {
+		t.Errorf("CreatePullRequest = %+v, want %+v", pr, want)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"string", `{"message":"404 Project Not Found"}`, "HTTP 404: 404 Project Not Found"},
+		{"list", `{"message":["Another open merge request already exists"]}`, "HTTP 404: [Another open merge request already exists]"},
+		{"object", `{"message":{"source_branch":["is invalid"]}}`, "HTTP 404: map[source_branch:[is invalid]]"},
+		{"error", `{"error":"insufficient_scope"}`, "HTTP 404: insufficient_scope"},
+		{"empty message", `{"message":"","error":"invalid_token"}`, "HTTP 404: invalid_token"},
+		{"not JSON", "Bad Gateway\n", "HTTP 404: Bad Gateway"},
+	}
+	for _, tt := range tests {
+		f := testForge(t, "gitlab", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(tt.body))
+		})
+		_, err := f.DefaultBranch(context.Background(), "team/app")
+		if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want suffix %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestGitLabCommitStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline string // last_pipeline of the commit
+		jobs     string
+		want     Status
+	}{
+		{
+			name:     "no pipeline",
+			pipeline: `null`,
+			want:     Status{},
+		},
+		{
+			name:     "passed",
+			pipeline: `{"id":42,"status":"success"}`,
+			jobs:     `[{"name":"test","status":"success","web_url":"https://ci/1"},{"name":"lint","status":"failed","web_url":"https://ci/2","allow_failure":true}]`,
+			want: Status{State: StateSuccess, Checks: []Check{
+				{Name: "test", State: StateSuccess, URL: "https://ci/1"},
+				{Name: "lint", State: StateWarning, URL: "https://ci/2"},
+			}},
+		},
+		{
+			name:     "failed",
+			pipeline: `{"id":42,"status":"failed"}`,
+			jobs:     `[{"name":"test","status":"failed"},{"name":"deploy","status":"manual"},{"name":"e2e","status":"canceled"}]`,
+			want: Status{State: StateFailure, Checks: []Check{
+				{Name: "test", State: StateFailure},
+				{Name: "deploy", State: StateWarning},
+				{Name: "e2e", State: StateError},
+			}},
+		},
+		{
+			name:     "running",
+			pipeline: `{"id":42,"status":"running"}`,
+			jobs:     `[{"name":"test","status":"running"},{"name":"build","status":"created"}]`,
+			want: Status{State: StatePending, Checks: []Check{
+				{Name: "test", State: StatePending},
+				{Name: "build", State: StatePending},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		f := testForge(t, "gitlab", func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.EscapedPath() {
+			case "/api/v4/projects/group%2Fapp/repository/commits/feat%2Fx":
+				w.Write([]byte(`{"id":"abc","last_pipeline":` + tt.pipeline + `}`))
+			case "/api/v4/projects/group%2Fapp/pipelines/42/jobs":
+				w.Write([]byte(tt.jobs))
+			default:
+				t.Errorf("%s: unexpected request %s", tt.name, r.URL.EscapedPath())
+				http.NotFound(w, r)
+			}
+		})
+		got, err := f.CommitStatus(context.Background(), "group/app", "feat/x")
+		if err != nil {
+			t.Errorf("%s: CommitStatus: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: CommitStatus = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGitea(t *testing.T) {
+	f := testForge(t, "forgejo", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "token tok" {
+			t.Errorf("Authorization = %q, want %q", got, "token tok")
+		}
+		switch r.Method + " " + r.URL.EscapedPath() {
+		case "GET /api/v1/repos/team/app":
+			w.Write([]byte(`{"default_branch":"trunk"}`))
+		case "POST /api/v1/repos/team/app/pulls":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["head"] != "feat/x" || req["base"] != "trunk" || req["title"] != "Add x" || req["body"] != "Body" {
+				t.Errorf("pull request = %v", req)
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"number":12,"html_url":"https://git.example.com/team/app/pulls/12"}`))
+		case "GET /api/v1/repos/team/app/branches/feat/x":
+			w.Write([]byte(`{"commit":{"id":"abc123"}}`))
+		case "GET /api/v1/repos/team/app/commits/abc123/status":
+			w.Write([]byte(`{"state":"failure","statuses":[{"status":"success","context":"build","target_url":"https://ci/1"},{"status":"failure","context":"test","target_url":"https://ci/2"}]}`))
+		case "GET /api/v1/repos/team/app/branches/v1.0":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"branch does not exist"}`))
+		case "GET /api/v1/repos/team/app/commits/v1.0/status":
+			w.Write([]byte(`{"state":"pending","statuses":[]}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.EscapedPath())
+			http.NotFound(w, r)
+		}
+	})
+	ctx := context.Background()
+
+	if branch, err := f.DefaultBranch(ctx, "team/app"); err != nil || branch != "trunk" {
+		t.Errorf("DefaultBranch = %q, %v, want \"trunk\"", branch, err)
+	}
+
+	pr, err := f.CreatePullRequest(ctx, "team/app", NewPullRequest{Head: "feat/x", Base: "trunk", Title: "Add x", Body: "Body"})
+	if want := (PullRequest{Number: 12, Ref: "#12", URL: "https://git.example.com/team/app/pulls/12"}); err != nil || pr != want {
+		t.Errorf("CreatePullRequest = %+v, %v, want %+v", pr, err, want)
+	}
+
+	st, err := f.CommitStatus(ctx, "team/app", "feat/x")
+	want := Status{State: StateFailure, Checks: []Check{
+		{Name: "build", State: StateSuccess, URL: "https://ci/1"},
+		{Name: "test", State: StateFailure, URL: "https://ci/2"},
+	}}
+	if err != nil || !reflect.DeepEqual(st, want) {
+		t.Errorf("CommitStatus(feat/x) = %+v, %v, want %+v", st, err, want)
+	}
+
+	// A tag isn't a branch and has no statuses: Gitea's "pending" means
+	// no checks.
+	if st, err := f.CommitStatus(ctx, "team/app", "v1.0"); err != nil || !reflect.DeepEqual(st, Status{}) {
+		t.Errorf("CommitStatus(v1.0) = %+v, %v, want no checks", st, err)
+	}
+}
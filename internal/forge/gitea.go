@@ -0,0 +1,82 @@
+package forge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gitea implements Forge with the Gitea API (/api/v1), which Forgejo
+// serves unchanged.
+type gitea struct{ api *client }
+
+func (g *gitea) DefaultBranch(ctx context.Context, repo string) (string, error) {
+	var res struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := g.api.do(ctx, http.MethodGet, repoPath(repo), nil, &res); err != nil {
+		return "", err
+	}
+	return res.DefaultBranch, nil
+}
+
+func (g *gitea) CreatePullRequest(ctx context.Context, repo string, pr NewPullRequest) (PullRequest, error) {
+	req := map[string]string{"head": pr.Head, "base": pr.Base, "title": pr.Title, "body": pr.Body}
+	var res struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := g.api.do(ctx, http.MethodPost, repoPath(repo)+"/pulls", req, &res); err != nil {
+		return PullRequest{}, err
+	}
+	return PullRequest{Number: res.Number, Ref: fmt.Sprintf("#%d", res.Number), URL: res.HTMLURL}, nil
+}
+
+func (g *gitea) CommitStatus(ctx context.Context, repo, ref string) (Status, error) {
+	var res struct {
+		State    string `json:"state"`
+		Statuses []struct {
+			Status    string `json:"status"`
+			Context   string `json:"context"`
+			TargetURL string `json:"target_url"`
+		} `json:"statuses"`
+	}
+	if err := g.api.do(ctx, http.MethodGet, repoPath(repo)+"/commits/"+url.PathEscape(g.commit(ctx, repo, ref))+"/status", nil, &res); err != nil {
+		return Status{}, err
+	}
+	// Gitea reports "pending" for a ref without any status.
+	if len(res.Statuses) == 0 {
+		return Status{}, nil
+	}
+	st := Status{State: res.State}
+	for _, s := range res.Statuses {
+		st.Checks = append(st.Checks, Check{Name: s.Context, State: s.Status, URL: s.TargetURL})
+	}
+	return st, nil
+}
+
+// commit resolves a branch to its head commit. The status route takes a
+// single path segment, which a branch like "feat/x" isn't; the branch
+// route accepts slashes. Anything else (a tag or SHA) is returned as is.
+func (g *gitea) commit(ctx context.Context, repo, ref string) string {
+	segments := strings.Split(ref, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	var res struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+	if err := g.api.do(ctx, http.MethodGet, repoPath(repo)+"/branches/"+strings.Join(segments, "/"), nil, &res); err != nil || res.Commit.ID == "" {
+		return ref
+	}
+	return res.Commit.ID
+}
+
+func repoPath(repo string) string {
+	owner, name, _ := strings.Cut(repo, "/")
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name)
+}
@@ -0,0 +1,91 @@
+package forge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// gitlab implements Forge with the GitLab API (/api/v4). Pull requests
+// are merge requests, and a ref's CI status is that of its last pipeline.
+type gitlab struct{ api *client }
+
+func (g *gitlab) DefaultBranch(ctx context.Context, repo string) (string, error) {
+	var res struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := g.api.do(ctx, http.MethodGet, projectPath(repo), nil, &res); err != nil {
+		return "", err
+	}
+	return res.DefaultBranch, nil
+}
+
+func (g *gitlab) CreatePullRequest(ctx context.Context, repo string, pr NewPullRequest) (PullRequest, error) {
+	req := map[string]string{"source_branch": pr.Head, "target_branch": pr.Base, "title": pr.Title, "description": pr.Body}
+	var res struct {
+		IID    int    `json:"iid"`
+		WebURL string `json:"web_url"`
+	}
+	if err := g.api.do(ctx, http.MethodPost, projectPath(repo)+"/merge_requests", req, &res); err != nil {
+		return PullRequest{}, err
+	}
+	return PullRequest{Number: res.IID, Ref: fmt.Sprintf("!%d", res.IID), URL: res.WebURL}, nil
+}
+
+func (g *gitlab) CommitStatus(ctx context.Context, repo, ref string) (Status, error) {
+	var commit struct {
+		LastPipeline *struct {
+			ID     int    `json:"id"`
+			Status string `json:"status"`
+		} `json:"last_pipeline"`
+	}
+	// The commit route takes a branch or tag as well as a SHA.
+	if err := g.api.do(ctx, http.MethodGet, projectPath(repo)+"/repository/commits/"+url.PathEscape(ref), nil, &commit); err != nil {
+		return Status{}, err
+	}
+	if commit.LastPipeline == nil {
+		return Status{}, nil
+	}
+	var jobs []struct {
+		Name         string `json:"name"`
+		Status       string `json:"status"`
+		WebURL       string `json:"web_url"`
+		AllowFailure bool   `json:"allow_failure"`
+	}
+	path := fmt.Sprintf("%s/pipelines/%d/jobs?per_page=100", projectPath(repo), commit.LastPipeline.ID)
+	if err := g.api.do(ctx, http.MethodGet, path, nil, &jobs); err != nil {
+		return Status{}, err
+	}
+	st := Status{State: gitlabState(commit.LastPipeline.Status)}
+	for _, j := range jobs {
+		state := gitlabState(j.Status)
+		if state == StateFailure && j.AllowFailure {
+			state = StateWarning
+		}
+		st.Checks = append(st.Checks, Check{Name: j.Name, State: state, URL: j.WebURL})
+	}
+	return st, nil
+}
+
+// gitlabState maps a GitLab pipeline or job status to a combined CI state.
+func gitlabState(status string) string {
+	switch status {
+	case "success":
+		return StateSuccess
+	case "failed":
+		return StateFailure
+	case "canceled":
+		return StateError
+	case "skipped", "manual":
+		return StateWarning
+	default: // created, waiting_for_resource, preparing, pending, running, scheduled
+		return StatePending
+	}
+}
+
+// projectPath is the API path of a project. Its path, which may name
+// subgroups, is one URL-encoded segment: group%2Fproject.
+func projectPath(repo string) string {
+	return "/projects/" + url.PathEscape(repo)
+}
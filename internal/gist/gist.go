@@ -0,0 +1,92 @@
+// Package gist uploads text to GitHub Gists.
+package gist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAPIURL is the API of github.com; GitHub Enterprise serves it at
+// https://host/api/v3.
+const DefaultAPIURL = "https://api.github.com"
+
+// Client creates gists with one token, which needs the gist scope.
+type Client struct {
+	apiURL string
+	token  string
+	http   *http.Client
+}
+
+// New returns a client for the GitHub API at apiURL (empty for
+// github.com).
+func New(apiURL, token string) (*Client, error) {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	u, err := url.Parse(apiURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("API URL must be http(s)://host[/path], got %q", apiURL)
+	}
+	if token == "" {
+		return nil, errors.New("missing token")
+	}
+	return &Client{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Create uploads files (name -> content) as a secret gist and returns its
+// URL. Secret gists are unlisted, not private: anyone with the URL can
+// read them.
+func (c *Client) Create(ctx context.Context, description string, files map[string]string) (string, error) {
+	type file struct {
+		Content string `json:"content"`
+	}
+	req := struct {
+		Description string          `json:"description"`
+		Public      bool            `json:"public"`
+		Files       map[string]file `json:"files"`
+	}{Description: description, Files: make(map[string]file, len(files))}
+	for name, content := range files {
+		req.Files[name] = file{Content: content}
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL+"/gists", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	httpReq.Header.Set("Accept", "application/vnd.github+json")
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("create gist: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var res struct {
+		HTMLURL string `json:"html_url"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &res)
+	if resp.StatusCode != http.StatusCreated || res.HTMLURL == "" {
+		if res.Message == "" {
+			res.Message = strings.TrimSpace(string(body))
+		}
+		return "", fmt.Errorf("create gist: HTTP %d: %s", resp.StatusCode, res.Message)
+	}
+	return res.HTMLURL, nil
+}
@@ -0,0 +1,94 @@
+// Package logging adds level filtering on top of the standard log package.
+//
+// The codebase logs through log.Printf, so a line's level is inferred from
+// its text: lines mentioning a warning are warn, lines reporting an error
+// or failure are error, and everything else is info. Debug output (the
+// OpenCode HTTP trace) is switched on by its own flag rather than filtered.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is a log severity.
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[string]Level{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+func (l Level) String() string {
+	for name, lvl := range levelNames {
+		if lvl == l {
+			return name
+		}
+	}
+	return fmt.Sprintf("Level(%d)", int32(l))
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(s string) (Level, error) {
+	lvl, ok := levelNames[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return LevelInfo, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", s)
+	}
+	return lvl, nil
+}
+
+var filter = &levelWriter{out: os.Stderr}
+
+func init() {
+	filter.min.Store(int32(LevelInfo))
+}
+
+// SetLevel installs the filter on the standard logger and drops lines
+// below min.
+func SetLevel(min Level) {
+	filter.min.Store(int32(min))
+	log.SetOutput(filter)
+}
+
+// CurrentLevel returns the active minimum level.
+func CurrentLevel() Level {
+	return Level(filter.min.Load())
+}
+
+// levelWriter receives one formatted log line per Write call.
+type levelWriter struct {
+	out io.Writer
+	min atomic.Int32
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	if classify(string(p)) < Level(w.min.Load()) {
+		return len(p), nil
+	}
+	return w.out.Write(p)
+}
+
+func classify(line string) Level {
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(lower, "error") || strings.Contains(lower, "failed") || strings.Contains(lower, "panic"):
+		return LevelError
+	case strings.Contains(lower, "warning") || strings.Contains(lower, "warn:"):
+		return LevelWarn
+	default:
+		return LevelInfo
+	}
+}
@@ -0,0 +1,149 @@
+// Package mailer sends plain-text email through the SMTP server named by
+// SMTP_URL, with only the standard library.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"time"
+)
+
+const sendTimeout = 30 * time.Second
+
+// Mailer sends mail from one address through one server.
+type Mailer struct {
+	addr        string // host:port
+	host        string
+	implicitTLS bool // smtps://: TLS from the first byte instead of STARTTLS
+	auth        smtp.Auth
+	from        *mail.Address
+}
+
+// New parses rawURL, smtp://[user:pass@]host[:port] (STARTTLS when the
+// server offers it, port 587 by default) or smtps://... (TLS, port 465),
+// and the sender address.
+func New(rawURL, from string) (*Mailer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mailer{host: u.Hostname()}
+	port := u.Port()
+	switch u.Scheme {
+	case "smtp":
+		if port == "" {
+			port = "587"
+		}
+	case "smtps":
+		m.implicitTLS = true
+		if port == "" {
+			port = "465"
+		}
+	default:
+		return nil, fmt.Errorf("scheme must be smtp or smtps, got %q", u.Scheme)
+	}
+	if m.host == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	m.addr = net.JoinHostPort(m.host, port)
+	if u.User != nil {
+		password, _ := u.User.Password()
+		// PlainAuth refuses to send the password unencrypted, except to
+		// localhost.
+		m.auth = smtp.PlainAuth("", u.User.Username(), password, m.host)
+	}
+	if m.from, err = mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("sender address: %w", err)
+	}
+	return m, nil
+}
+
+// Send mails a plain-text message to one recipient.
+func (m *Mailer) Send(ctx context.Context, to, subject, body string) error {
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("recipient address: %w", err)
+	}
+	msg, err := m.message(rcpt, subject, body)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", m.addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if m.implicitTLS {
+		conn = tls.Client(conn, &tls.Config{ServerName: m.host})
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return fmt.Errorf("smtp greeting: %w", err)
+	}
+	defer c.Close()
+	if !m.implicitTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+				return fmt.Errorf("starttls: %w", err)
+			}
+		}
+	}
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(m.from.Address); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	if err := c.Rcpt(rcpt.Address); err != nil {
+		return fmt.Errorf("smtp RCPT TO: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	return c.Quit()
+}
+
+// message renders the headers and a quoted-printable UTF-8 body.
+func (m *Mailer) message(to *mail.Address, subject, body string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
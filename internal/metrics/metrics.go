@@ -0,0 +1,305 @@
+// Package metrics is a minimal Prometheus-compatible metrics registry
+// (counters, gauges, histograms with labels) exposed in the text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are latency buckets in seconds, matching the Prometheus defaults.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is anything the registry can render.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds registered metrics.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// Default is the process-wide registry served by Handler.
+var Default = &Registry{}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write renders all metrics in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	cs := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].name() < cs[j].name() })
+	for _, c := range cs {
+		c.write(w)
+	}
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+// desc is the shared name/help/label definition of a metric family.
+type desc struct {
+	fqName string
+	help   string
+	labels []string
+}
+
+func (d desc) name() string { return d.fqName }
+
+func (d desc) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.fqName, d.help, d.fqName, typ)
+}
+
+// key joins label values into a map key.
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func (d desc) labelPairs(values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range d.labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", l, v))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value per label set.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+// NewCounter registers a counter with the default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{fqName: name, help: help, labels: labels},
+		values: make(map[string]float64),
+		labels: make(map[string][]string),
+	}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one for the given label values.
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Add adds v (which must be non-negative) for the given label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	k := key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[k] += v
+	if _, ok := c.labels[k]; !ok {
+		c.labels[k] = append([]string(nil), labelValues...)
+	}
+}
+
+// Value returns the current value for the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key(labelValues)]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w, "counter")
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.fqName, c.labelPairs(c.labels[k]), formatFloat(c.values[k]))
+	}
+}
+
+// Gauge is a value that can go up and down per label set.
+type Gauge struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+// NewGauge registers a gauge with the default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{
+		desc:   desc{fqName: name, help: help, labels: labels},
+		values: make(map[string]float64),
+		labels: make(map[string][]string),
+	}
+	Default.register(g)
+	return g
+}
+
+// Set sets the value for the given label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	k := key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[k] = v
+	if _, ok := g.labels[k]; !ok {
+		g.labels[k] = append([]string(nil), labelValues...)
+	}
+}
+
+// Add adds v (possibly negative) for the given label values.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	k := key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[k] += v
+	if _, ok := g.labels[k]; !ok {
+		g.labels[k] = append([]string(nil), labelValues...)
+	}
+}
+
+// Value returns the current value for the given label values.
+func (g *Gauge) Value(labelValues ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[key(labelValues)]
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w, "gauge")
+	for _, k := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.fqName, g.labelPairs(g.labels[k]), formatFloat(g.values[k]))
+	}
+}
+
+// Histogram counts observations into cumulative buckets per label set.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histSeries
+}
+
+type histSeries struct {
+	labels []string
+	counts []uint64 // per bucket, non-cumulative; last is +Inf
+	count  uint64
+	sum    float64
+	max    float64
+}
+
+// HistogramSample summarises one label set of a histogram.
+type HistogramSample struct {
+	Labels []string
+	Count  uint64
+	Sum    float64
+	Max    float64
+}
+
+// NewHistogram registers a histogram with the default registry.
+// A nil buckets slice uses DefBuckets.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	h := &Histogram{
+		desc:    desc{fqName: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histSeries),
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe records v for the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	k := key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histSeries{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)+1),
+		}
+		h.series[k] = s
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	s.counts[i]++
+	s.count++
+	s.sum += v
+	if v > s.max {
+		s.max = v
+	}
+}
+
+// Snapshot returns per-label-set totals, ordered by label values.
+func (h *Histogram) Snapshot() []HistogramSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []HistogramSample
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		out = append(out, HistogramSample{Labels: s.labels, Count: s.count, Sum: s.sum, Max: s.max})
+	}
+	return out
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, "histogram")
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		var cum uint64
+		for i, ub := range h.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, h.labelPairs(s.labels, "le", formatFloat(ub)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, h.labelPairs(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fqName, h.labelPairs(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fqName, h.labelPairs(s.labels), s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,195 @@
+package ocserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// A child that exited on its own is started again after a delay that
+	// doubles with each crash, up to maxRespawnDelay, and starts over once
+	// it has stayed up for stableAfter.
+	minRespawnDelay = time.Second
+	maxRespawnDelay = time.Minute
+	stableAfter     = time.Minute
+	// stopTimeout is how long a child has to exit after SIGTERM before it
+	// is killed.
+	stopTimeout = 10 * time.Second
+)
+
+// child is an OpenCode server the bot runs itself. It is started again
+// when it exits, except when stopped. Its output goes to the bot's log.
+type child struct {
+	argv []string
+	dir  string
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd     // nil while not running
+	done     chan struct{} // closed when cmd has exited
+	started  time.Time
+	exitErr  error         // how the last process ended
+	backoff  time.Duration // delay before the next respawn
+	restarts int
+	stopped  bool
+}
+
+func (c *child) String() string { return "child process " + strings.Join(c.argv, " ") }
+
+func (c *child) Start(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.spawnLocked()
+}
+
+// spawnLocked starts the process. Callers hold c.mu.
+func (c *child) spawnLocked() error {
+	cmd := exec.Command(c.argv[0], c.argv[1:]...)
+	cmd.Dir = c.dir
+	cmd.Stdout, cmd.Stderr = &lineLogger{}, &lineLogger{}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", c.argv[0], err)
+	}
+	done := make(chan struct{})
+	c.cmd, c.done, c.started = cmd, done, time.Now()
+	log.Printf("[ocserver] Started %s, PID %d", c, cmd.Process.Pid)
+	go c.wait(cmd, done)
+	return nil
+}
+
+// wait reaps cmd and starts it again if it exited on its own.
+func (c *child) wait(cmd *exec.Cmd, done chan struct{}) {
+	err := cmd.Wait()
+	cmd.Stdout.(*lineLogger).flush()
+	cmd.Stderr.(*lineLogger).flush()
+	close(done)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cmd != cmd {
+		// Restart or Stop took it down.
+		return
+	}
+	c.cmd, c.exitErr = nil, err
+	if c.stopped {
+		return
+	}
+	if c.backoff == 0 || time.Since(c.started) >= stableAfter {
+		c.backoff = minRespawnDelay
+	}
+	delay := c.backoff
+	c.backoff = min(2*c.backoff, maxRespawnDelay)
+	log.Printf("[ocserver] Warning: OpenCode exited (%v), starting it again in %s", err, delay)
+	time.AfterFunc(delay, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.cmd != nil || c.stopped {
+			return
+		}
+		c.restarts++
+		if err := c.spawnLocked(); err != nil {
+			log.Printf("[ocserver] Error: %v", err)
+			c.exitErr = err
+		}
+	})
+}
+
+func (c *child) Restart(context.Context) error {
+	c.take()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return errors.New("the server was stopped")
+	}
+	if c.cmd != nil {
+		// Respawned after exiting on its own meanwhile.
+		return nil
+	}
+	c.restarts++
+	return c.spawnLocked()
+}
+
+func (c *child) Stop() {
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+	c.take()
+}
+
+// take stops the running process, if any, without it being started again.
+func (c *child) take() {
+	c.mu.Lock()
+	cmd, done := c.cmd, c.done
+	c.cmd = nil
+	c.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		log.Printf("[ocserver] PID %d ignored SIGTERM, killing it", cmd.Process.Pid)
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// lineLogger writes a child's output to the log a line at a time.
+type lineLogger struct {
+	buf []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.print(l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs what is left after the last newline.
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		l.print(l.buf)
+		l.buf = nil
+	}
+}
+
+func (l *lineLogger) print(line []byte) {
+	if text := strings.TrimRight(string(line), "\r "); text != "" {
+		log.Printf("[opencode] %s", text)
+	}
+}
+
+func (c *child) Status(context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var status string
+	switch {
+	case c.cmd != nil:
+		status = fmt.Sprintf("running, PID %d, up %s", c.cmd.Process.Pid, time.Since(c.started).Round(time.Second))
+	case c.exitErr != nil:
+		status = fmt.Sprintf("not running (%v)", c.exitErr)
+	default:
+		status = "not running"
+	}
+	switch {
+	case c.restarts == 1:
+		status += ", restarted once"
+	case c.restarts > 1:
+		status += fmt.Sprintf(", restarted %d times", c.restarts)
+	}
+	return status, nil
+}
@@ -0,0 +1,150 @@
+// Package ocserver restarts and reports on the OpenCode server process:
+// a systemd unit, a Docker container, or a child process the bot starts
+// and supervises itself, such as `opencode serve`.
+package ocserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commandTimeout bounds each systemctl or docker call.
+const commandTimeout = 2 * time.Minute
+
+// healthPoll is how often WaitHealthy checks the server.
+const healthPoll = time.Second
+
+// Server is the OpenCode server process.
+type Server interface {
+	// Start starts a child process server; the others are managed
+	// outside the bot and Start does nothing.
+	Start(ctx context.Context) error
+	// Restart restarts the server, returning once it was told to; it may
+	// not be serving yet.
+	Restart(ctx context.Context) error
+	// Status describes the process, e.g. "active (running), PID 42".
+	Status(ctx context.Context) (string, error)
+	// Stop stops a child process server.
+	Stop()
+	// String names the server, e.g. "systemd unit opencode.service".
+	String() string
+}
+
+// New returns the server of kind ("systemd", "docker" or "exec"). target
+// is the unit, the container, or the command line to run.
+func New(kind, target string) (Server, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return nil, errors.New("no unit, container or command given")
+	}
+	switch strings.ToLower(kind) {
+	case "systemd":
+		return systemdUnit(target), nil
+	case "docker":
+		return dockerContainer(target), nil
+	case "exec":
+		return &child{argv: strings.Fields(target)}, nil
+	}
+	return nil, fmt.Errorf("unsupported OpenCode service %q (want systemd, docker or exec)", kind)
+}
+
+// Serve returns a child process running `bin serve` on hostname and port,
+// with args after those, in dir ("" = the bot's working directory).
+func Serve(bin string, args []string, hostname string, port int, dir string) Server {
+	argv := []string{bin, "serve", "--hostname", hostname, "--port", strconv.Itoa(port)}
+	return &child{argv: append(argv, args...), dir: dir}
+}
+
+// WaitHealthy calls health until it succeeds or timeout passes, and
+// returns its last error.
+func WaitHealthy(ctx context.Context, health func(context.Context) error, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tick := time.NewTicker(healthPoll)
+	defer tick.Stop()
+	for {
+		err := health(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-tick.C:
+		}
+	}
+}
+
+type systemdUnit string
+
+func (u systemdUnit) Start(context.Context) error { return nil }
+func (u systemdUnit) Stop()                       {}
+func (u systemdUnit) String() string              { return "systemd unit " + string(u) }
+
+func (u systemdUnit) Restart(ctx context.Context) error {
+	_, err := run(ctx, "systemctl", "restart", string(u))
+	return err
+}
+
+func (u systemdUnit) Status(ctx context.Context) (string, error) {
+	out, err := run(ctx, "systemctl", "show", string(u), "--property=ActiveState,SubState,MainPID,ActiveEnterTimestamp")
+	if err != nil {
+		return "", err
+	}
+	props := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			props[k] = v
+		}
+	}
+	status := props["ActiveState"]
+	if sub := props["SubState"]; sub != "" {
+		status += " (" + sub + ")"
+	}
+	if pid := props["MainPID"]; pid != "" && pid != "0" {
+		status += ", PID " + pid
+	}
+	if since := props["ActiveEnterTimestamp"]; since != "" {
+		status += ", since " + since
+	}
+	return status, nil
+}
+
+type dockerContainer string
+
+func (c dockerContainer) Start(context.Context) error { return nil }
+func (c dockerContainer) Stop()                       {}
+func (c dockerContainer) String() string              { return "Docker container " + string(c) }
+
+func (c dockerContainer) Restart(ctx context.Context) error {
+	_, err := run(ctx, "docker", "restart", string(c))
+	return err
+}
+
+func (c dockerContainer) Status(ctx context.Context) (string, error) {
+	out, err := run(ctx, "docker", "inspect", "--format", "{{.State.Status}}, PID {{.State.Pid}}, since {{.State.StartedAt}}", string(c))
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// run runs a management command and returns its trimmed output.
+func run(ctx context.Context, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	text := strings.TrimSpace(string(out))
+	if err != nil {
+		if text != "" {
+			return "", fmt.Errorf("%s %s: %w: %s", name, args[0], err, text)
+		}
+		return "", fmt.Errorf("%s %s: %w", name, args[0], err)
+	}
+	return text, nil
+}
@@ -0,0 +1,116 @@
+package opencode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cacheEntry holds the last successful body for a GET endpoint along with
+// the validators needed for a conditional re-fetch.
+type cacheEntry struct {
+	etag         string
+	lastModified string
+	body         []byte
+	fetched      time.Time
+}
+
+// responseCache is a small in-memory cache for list endpoints. Entries
+// younger than ttl are served without a request; older ones are
+// revalidated with If-None-Match / If-Modified-Since.
+type responseCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+func (rc *responseCache) get(path string) (cacheEntry, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := rc.entries[path]
+	return e, ok
+}
+
+func (rc *responseCache) put(path string, e cacheEntry) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries[path] = e
+}
+
+func (rc *responseCache) touch(path string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if e, ok := rc.entries[path]; ok {
+		e.fetched = time.Now()
+		rc.entries[path] = e
+	}
+}
+
+// invalidate drops cached entries so the next call re-fetches.
+func (rc *responseCache) invalidate(paths ...string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, p := range paths {
+		delete(rc.entries, p)
+	}
+}
+
+// getCached performs a GET on path, using the cache for freshness and
+// conditional revalidation. It returns the raw response body.
+func (c *Client) getCached(ctx context.Context, path string) ([]byte, error) {
+	cached, haveCached := c.cache.get(path)
+	if haveCached && time.Since(cached.fetched) < c.cache.ttl {
+		return cached.body, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if haveCached {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && haveCached {
+		c.cache.touch(path)
+		return cached.body, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	c.cache.put(path, cacheEntry{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		body:         body,
+		fetched:      time.Now(),
+	})
+	return body, nil
+}
+
+// InvalidateCache forces the next list calls to hit the server.
+func (c *Client) InvalidateCache() {
+	c.cache.invalidate(pathSessions, pathProviders)
+}
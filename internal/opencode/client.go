@@ -3,31 +3,155 @@ package opencode
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+)
+
+// ClientOptions tunes timeouts and connection pooling. Zero values fall
+// back to the defaults below.
+type ClientOptions struct {
+	Timeout         time.Duration // per-request timeout for regular calls
+	LongTimeout     time.Duration // per-request timeout for diff and message fetches
+	IdleConnTimeout time.Duration
+	MaxIdleConns    int
+	Debug           bool          // log requests and responses (secrets redacted)
+	Private         bool          // log bodies only as length and hash, never their content
+	CacheTTL        time.Duration // serve session/provider lists from memory this long before revalidating
+	APIKey          string        // sent as "Authorization: Bearer <key>" when set
+	Proxy           *url.URL      // explicit proxy; nil uses HTTP(S)_PROXY from the environment
+	TLS             *tls.Config   // custom CA / client certificate; nil uses Go's defaults
+}
+
+const (
+	pathSessions  = "/session"
+	pathProviders = "/provider"
+	pathWorktrees = "/experimental/worktree"
+	pathFiles     = "/file"
+)
+
+const (
+	defaultTimeout         = 30 * time.Second
+	defaultLongTimeout     = 5 * time.Minute
+	defaultIdleConnTimeout = 90 * time.Second
+	defaultMaxIdleConns    = 16
 )
 
 // Client wraps the HTTP client for the OpenCode API.
 type Client struct {
-	BaseURL    string
-	httpClient *http.Client
+	BaseURL     string
+	httpClient  *http.Client
+	timeout     time.Duration
+	longTimeout time.Duration
+	debug       *debugTransport
+	cache       *responseCache
 }
 
 // NewClient creates a new OpenCode client.
-func NewClient(baseURL string) *Client {
+func NewClient(baseURL string, opts ClientOptions) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.LongTimeout <= 0 {
+		opts.LongTimeout = defaultLongTimeout
+	}
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = defaultMaxIdleConns
+	}
+	var base http.RoundTripper = newTransport(opts.IdleConnTimeout, opts.MaxIdleConns, opts.Proxy, opts.TLS)
+	if opts.APIKey != "" {
+		base = &authTransport{base: base, apiKey: opts.APIKey}
+	}
+	base = &failureTransport{base: base}
+	debug := &debugTransport{base: base, private: opts.Private}
+	debug.enabled.Store(opts.Debug)
 	return &Client{
 		BaseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		// No client-wide Timeout: each call applies its own deadline via
+		// the request context so long fetches aren't cut off at 30s.
+		httpClient:  &http.Client{Transport: debug},
+		timeout:     opts.Timeout,
+		longTimeout: opts.LongTimeout,
+		debug:       debug,
+		cache:       newResponseCache(opts.CacheTTL),
 	}
 }
 
+// newTransport builds a keep-alive tuned transport. All traffic goes to a
+// single host, so the per-host idle pool is as large as the global one.
+func newTransport(idleConnTimeout time.Duration, maxIdle int, proxy *url.URL, tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy:           proxyFunc(proxy),
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   maxIdle,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// proxyFunc returns a fixed proxy when one is configured, otherwise the
+// environment-based default. net/http handles http, https and socks5.
+func proxyFunc(proxy *url.URL) func(*http.Request) (*url.URL, error) {
+	if proxy != nil {
+		return http.ProxyURL(proxy)
+	}
+	return http.ProxyFromEnvironment
+}
+
+// authTransport adds the OpenCode bearer token to every request.
+type authTransport struct {
+	base   http.RoundTripper
+	apiKey string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	return t.base.RoundTrip(req)
+}
+
+// failureTransport reports runs of consecutive failed requests (network
+// errors and 5xx responses) to the error reporter.
+type failureTransport struct {
+	base http.RoundTripper
+}
+
+func (t *failureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() == nil:
+		errreport.Failure("opencode", err, errreport.Fields{"method": req.Method, "path": req.URL.Path})
+	case err == nil && resp.StatusCode >= 500:
+		errreport.Failure("opencode", fmt.Errorf("status %d", resp.StatusCode), errreport.Fields{"method": req.Method, "path": req.URL.Path})
+	case err == nil:
+		errreport.Success("opencode")
+	}
+	return resp, err
+}
+
 // Health checks the health of the OpenCode server.
 func (c *Client) Health(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/global/health", nil)
 	if err != nil {
 		return fmt.Errorf("create health request: %w", err)
@@ -57,26 +181,73 @@ func (c *Client) Health(ctx context.Context) error {
 
 // GetProviders fetches available model providers from the OpenCode server.
 func (c *Client) GetProviders(ctx context.Context) (ProviderResponse, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/provider", nil)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	body, err := c.getCached(ctx, pathProviders)
 	if err != nil {
-		return ProviderResponse{}, fmt.Errorf("create providers request: %w", err)
+		return ProviderResponse{}, fmt.Errorf("get providers: %w", err)
 	}
-	resp, err := c.httpClient.Do(req)
+	return decodeJSON[ProviderResponse](bytes.NewReader(body))
+}
+
+// ContextLimit returns the size of a model's context window in tokens, or
+// 0 if the server doesn't know it.
+func (c *Client) ContextLimit(ctx context.Context, providerID, modelID string) (int, error) {
+	providers, err := c.GetProviders(ctx)
 	if err != nil {
-		return ProviderResponse{}, fmt.Errorf("get providers: %w", err)
+		return 0, err
 	}
-	defer resp.Body.Close()
+	for _, p := range providers.All {
+		if p.ID != providerID {
+			continue
+		}
+		if m, ok := p.Models[modelID]; ok {
+			return m.Limit.Context, nil
+		}
+	}
+	return 0, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return ProviderResponse{}, fmt.Errorf("get providers status: %d", resp.StatusCode)
+// BusyError is returned when OpenCode turns a request away with 429 Too
+// Many Requests or 503 Service Unavailable.
+type BusyError struct {
+	Op         string        // what was asked, e.g. "prompt"
+	Status     int           // the response status code
+	RetryAfter time.Duration // from the Retry-After header; 0 if it had none
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%s status: %d (server busy)", e.Op, e.Status)
+}
+
+// busyError returns a *BusyError for a 429 or 503 response, and nil for
+// any other.
+func busyError(op string, resp *http.Response) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
 	}
-	return decodeJSON[ProviderResponse](resp.Body)
+	e := &BusyError{Op: op, Status: resp.StatusCode}
+	if h := resp.Header.Get("Retry-After"); h != "" {
+		if secs, err := strconv.Atoi(h); err == nil && secs > 0 {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(h); err == nil {
+			e.RetryAfter = max(time.Until(t), 0)
+		}
+	}
+	return e
 }
 
-// CreateOCSession creates a new OpenCode session.
-func (c *Client) CreateOCSession(ctx context.Context, title string) (OCSession, error) {
+// CreateOCSession creates a new OpenCode session. A non-empty directory
+// starts it in that project directory instead of the server's own.
+func (c *Client) CreateOCSession(ctx context.Context, title, directory string) (OCSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	body, _ := json.Marshal(map[string]string{"title": title})
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/session", bytes.NewReader(body))
+	endpoint := c.BaseURL + pathSessions
+	if directory != "" {
+		endpoint += "?" + url.Values{"directory": {directory}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
 	if err != nil {
 		return OCSession{}, fmt.Errorf("create session request: %w", err)
 	}
@@ -88,32 +259,31 @@ func (c *Client) CreateOCSession(ctx context.Context, title string) (OCSession,
 	}
 	defer resp.Body.Close()
 
+	if err := busyError("create session", resp); err != nil {
+		return OCSession{}, err
+	}
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		return OCSession{}, fmt.Errorf("create session status: %d", resp.StatusCode)
 	}
+	c.cache.invalidate(pathSessions)
 	return decodeJSON[OCSession](resp.Body)
 }
 
 // ListOCSessions returns all OpenCode sessions.
 func (c *Client) ListOCSessions(ctx context.Context) ([]OCSession, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/session", nil)
-	if err != nil {
-		return nil, fmt.Errorf("list sessions request: %w", err)
-	}
-	resp, err := c.httpClient.Do(req)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	body, err := c.getCached(ctx, pathSessions)
 	if err != nil {
 		return nil, fmt.Errorf("list sessions: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("list sessions status: %d", resp.StatusCode)
-	}
-	return decodeJSON[[]OCSession](resp.Body)
+	return decodeJSON[[]OCSession](bytes.NewReader(body))
 }
 
 // GetOCSession returns a specific session by ID.
 func (c *Client) GetOCSession(ctx context.Context, id string) (OCSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/session/"+id, nil)
 	if err != nil {
 		return OCSession{}, fmt.Errorf("get session request: %w", err)
@@ -132,6 +302,8 @@ func (c *Client) GetOCSession(ctx context.Context, id string) (OCSession, error)
 
 // DeleteOCSession deletes a session by ID.
 func (c *Client) DeleteOCSession(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.BaseURL+"/session/"+id, nil)
 	if err != nil {
 		return fmt.Errorf("delete session request: %w", err)
@@ -145,11 +317,14 @@ func (c *Client) DeleteOCSession(ctx context.Context, id string) error {
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
 		return fmt.Errorf("delete session status: %d", resp.StatusCode)
 	}
+	c.cache.invalidate(pathSessions)
 	return nil
 }
 
 // RenameOCSession updates the title of an existing session.
 func (c *Client) RenameOCSession(ctx context.Context, id, newTitle string) (OCSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	body, _ := json.Marshal(map[string]string{"title": newTitle})
 	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, c.BaseURL+"/session/"+id, bytes.NewReader(body))
 	if err != nil {
@@ -166,11 +341,15 @@ func (c *Client) RenameOCSession(ctx context.Context, id, newTitle string) (OCSe
 	if resp.StatusCode != http.StatusOK {
 		return OCSession{}, fmt.Errorf("rename session status: %d", resp.StatusCode)
 	}
+	c.cache.invalidate(pathSessions)
 	return decodeJSON[OCSession](resp.Body)
 }
 
-// GetMessages returns all messages for a session.
+// GetMessages returns all messages for a session, without the ANSI
+// escapes tool output carries.
 func (c *Client) GetMessages(ctx context.Context, sessionID string) ([]Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.longTimeout)
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/session/"+sessionID+"/message", nil)
 	if err != nil {
 		return nil, fmt.Errorf("get messages request: %w", err)
@@ -193,42 +372,91 @@ func (c *Client) GetMessages(ctx context.Context, sessionID string) ([]Message,
 	var messages []Message
 	for _, am := range apiMsgs {
 		var content string
+		var tools []ToolCall
 		for _, p := range am.Parts {
-			if p.Type == "text" && p.Text != "" {
+			switch {
+			case p.Type == "text" && p.Text != "":
 				if content != "" {
 					content += "\n"
 				}
-				content += p.Text
+				content += tgtext.StripANSI(p.Text)
+			case p.Type == "tool":
+				tools = append(tools, ToolCall{
+					ID:       p.ID,
+					Tool:     p.Tool,
+					Status:   p.State.Status,
+					Title:    p.State.Title,
+					Input:    p.State.Input,
+					Output:   tgtext.StripANSI(p.State.Output),
+					Error:    tgtext.StripANSI(p.State.Error),
+					Progress: tgtext.StripANSI(p.State.Metadata.Output),
+				})
 			}
 		}
+		// Prompts after a summary start from it, not the history before.
+		used := am.Info.Tokens.Context()
+		if am.Info.Summary {
+			used = am.Info.Tokens.Output
+		}
+		var created time.Time
+		if am.Info.Time.Created > 0 {
+			created = time.UnixMilli(am.Info.Time.Created)
+		}
 		messages = append(messages, Message{
-			ID:      am.Info.ID,
-			Role:    am.Info.Role,
-			Content: content,
-			Tokens:  am.Info.Tokens.Total,
-			Cost:    am.Info.Cost,
+			ID:         am.Info.ID,
+			Role:       am.Info.Role,
+			Content:    content,
+			Tokens:     am.Info.Tokens.Total,
+			Context:    used,
+			Cost:       am.Info.Cost,
+			Created:    created,
+			Summary:    am.Info.Summary,
+			ProviderID: am.Info.ProviderID,
+			ModelID:    am.Info.ModelID,
+			Tools:      tools,
 		})
 	}
 	return messages, nil
 }
 
-// PromptAsync sends a prompt to a session asynchronously.
-func (c *Client) PromptAsync(ctx context.Context, sessionID, text, agent, providerID, modelID string) error {
+// PromptOptions are the optional settings of a prompt. Empty fields use
+// the server's defaults.
+type PromptOptions struct {
+	Agent      string
+	ProviderID string
+	ModelID    string
+	// System is an extra system instruction for this prompt.
+	System string
+}
+
+// promptBody is the JSON body of a prompt request.
+func promptBody(text string, opts PromptOptions) []byte {
 	payload := map[string]interface{}{
 		"parts": []map[string]string{
 			{"type": "text", "text": text},
 		},
 	}
-	if agent != "" {
-		payload["agent"] = agent
+	if opts.Agent != "" {
+		payload["agent"] = opts.Agent
 	}
-	if providerID != "" && modelID != "" {
+	if opts.ProviderID != "" && opts.ModelID != "" {
 		payload["model"] = map[string]string{
-			"providerID": providerID,
-			"modelID":   modelID,
+			"providerID": opts.ProviderID,
+			"modelID":    opts.ModelID,
 		}
 	}
+	if opts.System != "" {
+		payload["system"] = opts.System
+	}
 	body, _ := json.Marshal(payload)
+	return body
+}
+
+// PromptAsync sends a prompt to a session asynchronously.
+func (c *Client) PromptAsync(ctx context.Context, sessionID, text string, opts PromptOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	body := promptBody(text, opts)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/session/"+sessionID+"/prompt_async", bytes.NewReader(body))
 	if err != nil {
@@ -245,6 +473,9 @@ func (c *Client) PromptAsync(ctx context.Context, sessionID, text, agent, provid
 	if resp.StatusCode == http.StatusNoContent {
 		return nil
 	}
+	if err := busyError("prompt", resp); err != nil {
+		return err
+	}
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
 		return fmt.Errorf("prompt status: %d", resp.StatusCode)
 	}
@@ -263,8 +494,43 @@ func (c *Client) PromptAsync(ctx context.Context, sessionID, text, agent, provid
 	return nil
 }
 
+// Prompt sends a prompt to a session, waits for the reply and returns its
+// text.
+func (c *Client) Prompt(ctx context.Context, sessionID, text string, opts PromptOptions) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.longTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/session/"+sessionID+"/message", bytes.NewReader(promptBody(text, opts)))
+	if err != nil {
+		return "", fmt.Errorf("create prompt request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("send prompt: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("prompt status: %d", resp.StatusCode)
+	}
+	msg, err := decodeJSON[APIMessage](resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var out []string
+	for _, p := range msg.Parts {
+		if p.Type == "text" && p.Text != "" {
+			out = append(out, p.Text)
+		}
+	}
+	return strings.Join(out, "\n"), nil
+}
+
 // Abort aborts the current operation in a session.
 func (c *Client) Abort(ctx context.Context, sessionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/session/"+sessionID+"/abort", nil)
 	if err != nil {
 		return fmt.Errorf("create abort request: %w", err)
@@ -281,8 +547,180 @@ func (c *Client) Abort(ctx context.Context, sessionID string) error {
 	return nil
 }
 
+// Shell runs command with the user's shell in the session's directory,
+// as agent, and returns its output without ANSI escapes. The command and
+// its output are recorded in the session like a tool call.
+func (c *Client) Shell(ctx context.Context, sessionID, agent, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.longTimeout)
+	defer cancel()
+	body, _ := json.Marshal(map[string]string{"agent": agent, "command": command})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/session/"+sessionID+"/shell", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("shell request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("shell: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("shell status: %d", resp.StatusCode)
+	}
+	var msg struct {
+		Parts []struct {
+			Type  string `json:"type"`
+			State struct {
+				Output string `json:"output"`
+			} `json:"state"`
+		} `json:"parts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return "", fmt.Errorf("parse shell response: %w", err)
+	}
+	var out strings.Builder
+	for _, p := range msg.Parts {
+		if p.Type == "tool" {
+			out.WriteString(tgtext.StripANSI(p.State.Output))
+		}
+	}
+	return out.String(), nil
+}
+
+// Summarize has the model summarize the session so far and waits for it.
+// The summary is added to the session as an assistant message with
+// Summary set, and later prompts build on it instead of the full history.
+func (c *Client) Summarize(ctx context.Context, sessionID, providerID, modelID string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.longTimeout)
+	defer cancel()
+	body, _ := json.Marshal(map[string]string{"providerID": providerID, "modelID": modelID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/session/"+sessionID+"/summarize", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("summarize request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("summarize: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("summarize status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// AnswerQuestion answers a Question, with the picked labels or typed
+// answer for each of its questions in order.
+func (c *Client) AnswerQuestion(ctx context.Context, questionID string, answers [][]string) error {
+	body, _ := json.Marshal(map[string][][]string{"answers": answers})
+	return c.postQuestion(ctx, questionID, "reply", body)
+}
+
+// RejectQuestion dismisses a Question; the agent carries on without an
+// answer.
+func (c *Client) RejectQuestion(ctx context.Context, questionID string) error {
+	return c.postQuestion(ctx, questionID, "reject", nil)
+}
+
+func (c *Client) postQuestion(ctx context.Context, questionID, action string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/question/"+questionID+"/"+action, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("question request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("question %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("question %s status: %d", action, resp.StatusCode)
+	}
+	return nil
+}
+
+// RespondPermission answers a tool's permission request with
+// PermissionOnce, PermissionAlways or PermissionReject.
+func (c *Client) RespondPermission(ctx context.Context, sessionID, permissionID, response string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	body, _ := json.Marshal(map[string]string{"response": response})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/session/"+sessionID+"/permissions/"+permissionID, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("permission request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("permission: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("permission status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Revert rolls the session's messages and files back to before messageID.
+func (c *Client) Revert(ctx context.Context, sessionID, messageID string) (OCSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.longTimeout)
+	defer cancel()
+	body, _ := json.Marshal(map[string]string{"messageID": messageID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/session/"+sessionID+"/revert", bytes.NewReader(body))
+	if err != nil {
+		return OCSession{}, fmt.Errorf("revert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return OCSession{}, fmt.Errorf("revert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return OCSession{}, fmt.Errorf("revert status: %d", resp.StatusCode)
+	}
+	c.cache.invalidate(pathSessions)
+	return decodeJSON[OCSession](resp.Body)
+}
+
+// Unrevert undoes a pending Revert, restoring the reverted messages and files.
+func (c *Client) Unrevert(ctx context.Context, sessionID string) (OCSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.longTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/session/"+sessionID+"/unrevert", nil)
+	if err != nil {
+		return OCSession{}, fmt.Errorf("unrevert request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return OCSession{}, fmt.Errorf("unrevert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return OCSession{}, fmt.Errorf("unrevert status: %d", resp.StatusCode)
+	}
+	c.cache.invalidate(pathSessions)
+	return decodeJSON[OCSession](resp.Body)
+}
+
 // GetDiff returns the diff for a session.
 func (c *Client) GetDiff(ctx context.Context, sessionID string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.longTimeout)
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/session/"+sessionID+"/diff", nil)
 	if err != nil {
 		return "", fmt.Errorf("get diff request: %w", err)
@@ -303,6 +741,124 @@ func (c *Client) GetDiff(ctx context.Context, sessionID string) (string, error)
 	return string(body), nil
 }
 
+// GetFileDiffs returns the session's changes per file. It fails on
+// servers whose diff endpoint returns plain text; use GetDiff there.
+func (c *Client) GetFileDiffs(ctx context.Context, sessionID string) ([]FileDiff, error) {
+	raw, err := c.GetDiff(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []FileDiff
+	if err := json.Unmarshal([]byte(raw), &diffs); err != nil {
+		return nil, fmt.Errorf("parse diff response: %w", err)
+	}
+	return diffs, nil
+}
+
+// ListWorktrees returns the directories of the worktrees of the project
+// that directory belongs to.
+func (c *Client) ListWorktrees(ctx context.Context, directory string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, worktreesURL(c.BaseURL, directory), nil)
+	if err != nil {
+		return nil, fmt.Errorf("list worktrees request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list worktrees: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list worktrees status: %d", resp.StatusCode)
+	}
+	return decodeJSON[[]string](resp.Body)
+}
+
+// CreateWorktree checks out a new worktree named name, on a new branch,
+// for the project that directory belongs to.
+func (c *Client) CreateWorktree(ctx context.Context, directory, name string) (Worktree, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.longTimeout)
+	defer cancel()
+	body, _ := json.Marshal(map[string]string{"name": name})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, worktreesURL(c.BaseURL, directory), bytes.NewReader(body))
+	if err != nil {
+		return Worktree{}, fmt.Errorf("create worktree request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return Worktree{}, fmt.Errorf("create worktree: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Worktree{}, fmt.Errorf("create worktree status: %d", resp.StatusCode)
+	}
+	return decodeJSON[Worktree](resp.Body)
+}
+
+// ListFiles returns the entries of the directory at path, relative to the
+// project directory; "" lists the project directory itself.
+func (c *Client) ListFiles(ctx context.Context, directory, path string) ([]FileNode, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, filesURL(c.BaseURL, pathFiles, directory, path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("list files request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list files: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list files status: %d", resp.StatusCode)
+	}
+	return decodeJSON[[]FileNode](resp.Body)
+}
+
+// ReadFile returns the file at path, relative to the project directory.
+func (c *Client) ReadFile(ctx context.Context, directory, path string) (FileContent, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, filesURL(c.BaseURL, pathFiles+"/content", directory, path), nil)
+	if err != nil {
+		return FileContent{}, fmt.Errorf("read file request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return FileContent{}, fmt.Errorf("read file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return FileContent{}, fmt.Errorf("read file status: %d", resp.StatusCode)
+	}
+	return decodeJSON[FileContent](resp.Body)
+}
+
+// filesURL is route with path and, if set, the project directory as query.
+func filesURL(baseURL, route, directory, path string) string {
+	q := url.Values{"path": {path}}
+	if directory != "" {
+		q.Set("directory", directory)
+	}
+	return baseURL + route + "?" + q.Encode()
+}
+
+// worktreesURL scopes the worktree routes to directory's project, or to
+// the server's own without one.
+func worktreesURL(baseURL, directory string) string {
+	if directory == "" {
+		return baseURL + pathWorktrees
+	}
+	return baseURL + pathWorktrees + "?" + url.Values{"directory": {directory}}.Encode()
+}
+
 func decodeJSON[T any](r io.Reader) (T, error) {
 	body, err := io.ReadAll(r)
 	if err != nil {
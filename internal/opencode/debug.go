@@ -0,0 +1,126 @@
+package opencode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLoggedBody is how much of a request/response body the debug
+// transport writes to the log.
+const maxLoggedBody = 512
+
+// debugTransport logs every request made through it while enabled.
+// Secrets are redacted before anything is written; in private mode bodies
+// are logged only as a length and hash.
+type debugTransport struct {
+	base    http.RoundTripper
+	enabled atomic.Bool
+	private bool
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.enabled.Load() {
+		return t.base.RoundTrip(req)
+	}
+
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start).Round(time.Millisecond)
+
+	path := redact(req.URL.RequestURI())
+	if err != nil {
+		log.Printf("[HTTP] %s %s -> error after %s: %v req=%s",
+			req.Method, path, latency, err, t.body(reqBody))
+		return nil, err
+	}
+
+	respBody, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	if readErr != nil {
+		// Hand the error to the caller on its next read.
+		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(respBody), errReader{readErr}))
+	}
+
+	log.Printf("[HTTP] %s %s -> %d in %s req=%s resp=%s",
+		req.Method, path, resp.StatusCode, latency, t.body(reqBody), t.body(respBody))
+	return resp, nil
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+var redactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`),
+	regexp.MustCompile(`(?i)("(?:api_?key|access_?token|token|secret|password|authorization)"\s*:\s*")[^"]*`),
+	regexp.MustCompile(`(?i)((?:api_?key|access_?token|token|secret|password)=)[^&\s"]+`),
+}
+
+// redact masks bearer tokens, API keys and similar values in s.
+func redact(s string) string {
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllString(s, "${1}[REDACTED]")
+	}
+	return s
+}
+
+func (t *debugTransport) body(b []byte) string {
+	if t.private && len(b) > 0 {
+		return digest(b)
+	}
+	return truncateBody(b)
+}
+
+// digest stands in for content that must not be logged: its length and
+// the start of its SHA-256, enough to tell two payloads apart.
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("<%d bytes sha256:%x>", len(b), sum[:6])
+}
+
+func truncateBody(b []byte) string {
+	if len(b) == 0 {
+		return "-"
+	}
+	// Redact before cutting: a cut through a secret would leave its start
+	// unmatched by the patterns.
+	s := redact(string(b))
+	if len(s) > maxLoggedBody {
+		cut := maxLoggedBody
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut] + "...(truncated)"
+	}
+	return s
+}
+
+// SetDebug enables or disables request/response logging at runtime.
+func (c *Client) SetDebug(on bool) {
+	c.debug.enabled.Store(on)
+}
+
+// Debug reports whether request/response logging is enabled.
+func (c *Client) Debug() bool {
+	return c.debug.enabled.Load()
+}
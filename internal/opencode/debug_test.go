@@ -0,0 +1,41 @@
+package opencode
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateBody(t *testing.T) {
+	secret := "sk-" + strings.Repeat("x", 40)
+	tests := []struct {
+		name string
+		body string
+	}{
+		// The token starts before the cut and ends after it.
+		{"token across the cut", strings.Repeat("a", maxLoggedBody-20) + `{"token":"` + secret + `"}`},
+		{"bearer across the cut", strings.Repeat("a", maxLoggedBody-10) + "Bearer " + secret},
+		{"multi-byte rune at the cut", strings.Repeat("a", maxLoggedBody-1) + "é" + secret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateBody([]byte(tt.body))
+			if strings.Contains(got, "sk-") {
+				t.Errorf("logged %q, want the token redacted", got)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("logged invalid UTF-8 %q", got)
+			}
+			if !strings.HasSuffix(got, "...(truncated)") {
+				t.Errorf("logged %q, want it truncated", got)
+			}
+		})
+	}
+
+	if got := truncateBody([]byte(`{"password":"hunter2"}`)); got != `{"password":"[REDACTED]"}` {
+		t.Errorf("short body = %q", got)
+	}
+	if got := truncateBody(nil); got != "-" {
+		t.Errorf("empty body = %q, want \"-\"", got)
+	}
+}
@@ -0,0 +1,56 @@
+package opencode
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxEditedShown is how many of the files a reply edited the status line
+// names; the most recent ones are shown.
+const maxEditedShown = 3
+
+// editTools are the tools that edit the file in their filePath input.
+var editTools = map[string]bool{"edit": true, "write": true, "multiedit": true}
+
+// noteEdited records that the chat's reply edited files, most recent last.
+func (sm *StreamManager) noteEdited(chatID int64, files ...string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		edited := sm.edited[chatID]
+		for i, f := range edited {
+			if f == file {
+				edited = append(edited[:i:i], edited[i+1:]...)
+				break
+			}
+		}
+		sm.edited[chatID] = append(edited, file)
+	}
+}
+
+// editedLineLocked is the status line naming the files the chat's reply
+// has edited so far, e.g. "✏️ edited: server.go, handlers_test.go", or ""
+// if none. Callers hold sm.mu.
+func (sm *StreamManager) editedLineLocked(chatID int64) string {
+	edited := sm.edited[chatID]
+	if len(edited) == 0 {
+		return ""
+	}
+	shown := edited
+	if len(shown) > maxEditedShown {
+		shown = shown[len(shown)-maxEditedShown:]
+	}
+	names := make([]string, len(shown))
+	for i, f := range shown {
+		names[len(shown)-1-i] = filepath.Base(f)
+	}
+	line := "✏️ edited: " + strings.Join(names, ", ")
+	if more := len(edited) - len(shown); more > 0 {
+		line += fmt.Sprintf(" and %d more", more)
+	}
+	return line
+}
@@ -0,0 +1,130 @@
+package opencode
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEventLogSize = 200
+	eventPayloadLimit   = 300
+)
+
+// EventRecord is a summary of one SSE event kept for debugging.
+type EventRecord struct {
+	Time      time.Time
+	Type      string
+	SessionID string
+	Payload   string // raw properties, truncated
+}
+
+// eventLog is a fixed-size ring buffer of recent events.
+type eventLog struct {
+	mu   sync.Mutex
+	buf  []EventRecord
+	next int
+	full bool
+}
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = defaultEventLogSize
+	}
+	return &eventLog{buf: make([]EventRecord, size)}
+}
+
+func (l *eventLog) add(rec EventRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf[l.next] = rec
+	l.next = (l.next + 1) % len(l.buf)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// snapshot returns the buffered events, oldest first.
+func (l *eventLog) snapshot() []EventRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]EventRecord(nil), l.buf[:l.next]...)
+	}
+	out := make([]EventRecord, 0, len(l.buf))
+	out = append(out, l.buf[l.next:]...)
+	return append(out, l.buf[:l.next]...)
+}
+
+// RecentEvents returns up to n of the most recent SSE events, oldest first.
+// A non-empty sessionPrefix keeps only events whose session ID starts with it.
+func (sm *StreamManager) RecentEvents(n int, sessionPrefix string) []EventRecord {
+	all := sm.events.snapshot()
+	var out []EventRecord
+	for i := len(all) - 1; i >= 0 && len(out) < n; i-- {
+		if sessionPrefix != "" && !strings.HasPrefix(all[i].SessionID, sessionPrefix) {
+			continue
+		}
+		out = append(out, all[i])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// recordEvent adds an event to the log. Heartbeats are skipped so they
+// don't push out the events worth looking at.
+func (sm *StreamManager) recordEvent(eventType string, props json.RawMessage) {
+	if eventType == "server.heartbeat" {
+		return
+	}
+	sm.events.add(EventRecord{
+		Time:      time.Now(),
+		Type:      eventType,
+		SessionID: eventSessionID(props),
+		Payload:   sm.payload(string(props)),
+	})
+}
+
+// payload is how an event's data is recorded: truncated, or in private
+// mode reduced to its digest.
+func (sm *StreamManager) payload(data string) string {
+	if sm.private {
+		return digest([]byte(data))
+	}
+	return truncatePayload(data)
+}
+
+func truncatePayload(payload string) string {
+	if len(payload) > eventPayloadLimit {
+		return payload[:eventPayloadLimit] + "..."
+	}
+	return payload
+}
+
+// eventSessionID finds the session ID in the places OpenCode puts it:
+// properties.sessionID, properties.part.sessionID or properties.info.sessionID.
+func eventSessionID(props json.RawMessage) string {
+	var p struct {
+		SessionID string `json:"sessionID"`
+		Part      struct {
+			SessionID string `json:"sessionID"`
+		} `json:"part"`
+		Info struct {
+			SessionID string `json:"sessionID"`
+		} `json:"info"`
+	}
+	if json.Unmarshal(props, &p) != nil {
+		return ""
+	}
+	switch {
+	case p.SessionID != "":
+		return p.SessionID
+	case p.Part.SessionID != "":
+		return p.Part.SessionID
+	default:
+		return p.Info.SessionID
+	}
+}
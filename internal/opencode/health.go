@@ -0,0 +1,77 @@
+package opencode
+
+import (
+	"sync"
+	"time"
+)
+
+// StreamHealth is a snapshot of the SSE connection, for /status. A stream
+// that is connected but hasn't had an event for long is the usual cause of
+// a bot that stopped answering.
+type StreamHealth struct {
+	Connected   bool
+	Since       time.Time // when the current connection was made, or when it dropped
+	Reconnects  int       // connections made after the first
+	LastEvent   time.Time // zero until the first event
+	ParseErrors int
+	LastError   string // why the last connection failed, if one did
+}
+
+// streamHealth tracks StreamHealth as the SSE reader goes.
+type streamHealth struct {
+	mu          sync.Mutex
+	connections int
+	StreamHealth
+}
+
+// noteConnected records a new SSE connection.
+func (h *streamHealth) noteConnected() {
+	now := time.Now()
+	h.mu.Lock()
+	if h.connections > 0 {
+		h.Reconnects++
+		sseReconnects.Inc()
+	}
+	h.connections++
+	h.Connected, h.Since = true, now
+	h.mu.Unlock()
+	sseConnected.Set(1)
+	sseConnectedSince.Set(float64(now.Unix()))
+}
+
+// noteDisconnected records the end of the connection, with err if it
+// failed.
+func (h *streamHealth) noteDisconnected(err error) {
+	h.mu.Lock()
+	if h.Connected {
+		h.Connected, h.Since = false, time.Now()
+	}
+	if err != nil {
+		h.LastError = err.Error()
+	}
+	h.mu.Unlock()
+	sseConnected.Set(0)
+	sseConnectedSince.Set(0)
+}
+
+// noteEvent records an SSE event, and whether it failed to parse.
+func (h *streamHealth) noteEvent(parseErr bool) {
+	now := time.Now()
+	h.mu.Lock()
+	h.LastEvent = now
+	if parseErr {
+		h.ParseErrors++
+	}
+	h.mu.Unlock()
+	sseLastEvent.Set(float64(now.Unix()))
+	if parseErr {
+		sseParseErrors.Inc()
+	}
+}
+
+// Health returns the state of the SSE connection.
+func (sm *StreamManager) Health() StreamHealth {
+	sm.health.mu.Lock()
+	defer sm.health.mu.Unlock()
+	return sm.health.StreamHealth
+}
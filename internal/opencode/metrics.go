@@ -0,0 +1,35 @@
+package opencode
+
+import "github.com/Khaledxab/Openkh/internal/metrics"
+
+// streamEdits counts streaming message updates by outcome: "sent" (new
+// message), "edited", "throttled" (skipped by the edit throttle),
+// "unchanged" (skipped because the text is already shown), "not_modified",
+// "rate_limited" (429) and "rejected" (any other Telegram error).
+var streamEdits = metrics.NewCounter("openkh_stream_edits_total",
+	"Streaming message updates by outcome.", "result")
+
+// editResult classifies a send/edit error for streamEdits.
+func editResult(err error, ok string) string {
+	switch {
+	case err == nil:
+		return ok
+	case isNotModified(err):
+		return "not_modified"
+	default:
+		if _, ok := rateLimited(err); ok {
+			return "rate_limited"
+		}
+		return "rejected"
+	}
+}
+
+// SSE connection health, also shown in /status. Uptime of the current
+// connection is time() - openkh_sse_connected_since_seconds.
+var (
+	sseConnected      = metrics.NewGauge("openkh_sse_connected", "Whether the SSE stream is connected (1) or not (0).")
+	sseConnectedSince = metrics.NewGauge("openkh_sse_connected_since_seconds", "Unix time the current SSE connection was made, 0 while disconnected.")
+	sseLastEvent      = metrics.NewGauge("openkh_sse_last_event_timestamp_seconds", "Unix time of the last SSE event received.")
+	sseReconnects     = metrics.NewCounter("openkh_sse_reconnects_total", "SSE connections made after the first.")
+	sseParseErrors    = metrics.NewCounter("openkh_sse_parse_errors_total", "SSE events that failed to parse.")
+)
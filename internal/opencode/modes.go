@@ -0,0 +1,170 @@
+package opencode
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+)
+
+// Stream modes: how a chat sees a reply while it is written.
+const (
+	// ModeLive edits one message as the reply streams in.
+	ModeLive = "live"
+	// ModeChunked sends each finished paragraph in a new message and
+	// never edits one, except that the first replaces "Thinking...".
+	ModeChunked = "chunked"
+	// ModeFinal leaves the message alone until the reply is complete.
+	ModeFinal = "final"
+)
+
+// StreamModes picks each chat's stream mode. It is asked once per reply,
+// on the SSE reader; anything but ModeChunked or ModeFinal is ModeLive.
+type StreamModes interface {
+	StreamMode(chatID int64) string
+}
+
+// modeFor returns the chat's stream mode for its current reply.
+func (sm *StreamManager) modeFor(chatID int64) string {
+	if sm.modeHook == nil {
+		return ModeLive
+	}
+	sm.mu.RLock()
+	mode, decided := sm.modes[chatID]
+	sm.mu.RUnlock()
+	if decided {
+		return mode
+	}
+	switch mode = sm.modeHook.StreamMode(chatID); mode {
+	case ModeChunked, ModeFinal:
+	default:
+		mode = ModeLive
+	}
+	sm.mu.Lock()
+	sm.modes[chatID] = mode
+	sm.mu.Unlock()
+	return mode
+}
+
+// startChunkPart flushes what is left of the previous text part of a
+// chunked reply when the next one starts, e.g. after a tool call.
+func (sm *StreamManager) startChunkPart(chatID int64, partID string) {
+	sm.mu.RLock()
+	previous := sm.textPartIDs[chatID]
+	sm.mu.RUnlock()
+	if previous == "" || previous == partID {
+		return
+	}
+	sm.editMu.Lock()
+	sm.sendChunksLocked(chatID, true)
+	sm.editMu.Unlock()
+	sm.mu.Lock()
+	sm.chatToText[chatID] = ""
+	delete(sm.chunkSent, chatID)
+	sm.mu.Unlock()
+}
+
+// sendChunks sends the next chunk of a chunked reply, at most once per
+// edit throttle.
+func (sm *StreamManager) sendChunks(chatID int64) {
+	sm.editMu.Lock()
+	defer sm.editMu.Unlock()
+	if !sm.canEdit(chatID) {
+		streamEdits.Inc("throttled")
+		return
+	}
+	sm.sendChunksLocked(chatID, false)
+}
+
+// sendChunksLocked sends the chat's finished paragraphs as a new message,
+// or with final all that is left, in as many messages as it takes. In
+// chunked mode the chat's text holds only what hasn't been sent. Callers
+// hold sm.editMu.
+func (sm *StreamManager) sendChunksLocked(chatID int64, final bool) {
+	for {
+		sm.mu.RLock()
+		text := sm.chatToText[chatID]
+		messageID, hasMsg := sm.chatToMsgID[chatID]
+		first := sm.chunkMsgs[chatID] == 0
+		sm.mu.RUnlock()
+
+		chunk, n := nextChunk(text, sm.maxMessageLen, final)
+		if n == 0 {
+			return
+		}
+		if chunk != "" {
+			chunk = sm.truncate(sm.process(chatID, chunk, true))
+			var err error
+			if hasMsg && first {
+				err = sm.sender.EditText(chatID, messageID, chunk)
+			} else {
+				messageID, err = sm.sender.SendText(chatID, chunk)
+			}
+			streamEdits.Inc(editResult(err, "sent"))
+			if err != nil && !isNotModified(err) {
+				sm.backOff(chatID, err)
+				log.Printf("[StreamManager] Failed to send chunk to chat %d: %v", chatID, err)
+				return
+			}
+		}
+		sm.mu.Lock()
+		// Text only grows at the end, and setText drops what was sent.
+		if rest := sm.chatToText[chatID]; len(rest) >= n {
+			sm.chatToText[chatID] = rest[n:]
+		} else {
+			sm.chatToText[chatID] = ""
+		}
+		sm.chunkSent[chatID] += n
+		if chunk != "" {
+			sm.chatToMsgID[chatID] = messageID
+			sm.chunkMsgs[chatID]++
+			sm.noteEditLocked(chatID, time.Now())
+		}
+		sm.mu.Unlock()
+		if !final {
+			return
+		}
+	}
+}
+
+// nextChunk returns the next message of a chunked reply and how many bytes
+// of text it takes up: the finished paragraphs that fit in a message, a
+// message's worth of a paragraph too long for one, or with final whatever
+// is left. n is 0 when nothing is ready.
+func nextChunk(text string, limit int, final bool) (chunk string, n int) {
+	if strings.TrimSpace(text) == "" {
+		return "", 0
+	}
+	if final && tgtext.Len(text) <= limit {
+		return strings.TrimSpace(text), len(text)
+	}
+	cut := -1
+	for i := 0; ; {
+		j := strings.Index(text[i:], "\n\n")
+		if j < 0 {
+			break
+		}
+		end := i + j
+		if tgtext.Len(text[:end]) > limit {
+			break
+		}
+		// A code block isn't split across messages.
+		if strings.Count(text[:end], "```")%2 == 0 {
+			cut = end
+		}
+		i = end + 2
+	}
+	switch {
+	case cut >= 0:
+		return strings.TrimSpace(text[:cut]), cut + 2
+	case tgtext.Len(text) > limit:
+		width := 0
+		for i, r := range text {
+			if width += tgtext.Len(string(r)); width > limit {
+				return text[:i], i
+			}
+		}
+	}
+	return "", 0
+}
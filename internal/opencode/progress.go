@@ -0,0 +1,81 @@
+package opencode
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultStallWarning = 45 * time.Second
+	minProgressInterval = 3 * time.Second
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progressInterval is how often an idle stream's status line is
+// refreshed: never faster than two edit slots so real output always has
+// budget left.
+func (sm *StreamManager) progressInterval() time.Duration {
+	return max(minProgressInterval, 2*sm.editThrottle)
+}
+
+// runProgress animates the status line of streams that have gone quiet
+// until ctx is cancelled.
+func (sm *StreamManager) runProgress(ctx context.Context) {
+	ticker := time.NewTicker(sm.progressInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sm.tickProgress()
+		}
+	}
+}
+
+// tickProgress advances the spinner of every idle stream and re-renders it.
+func (sm *StreamManager) tickProgress() {
+	now := time.Now()
+	interval := sm.progressInterval()
+	var idle []int64
+	sm.mu.Lock()
+	for chatID, last := range sm.lastActivity {
+		if _, ok := sm.chatToMsgID[chatID]; !ok || now.Sub(last) < interval {
+			continue
+		}
+		sm.spinFrame[chatID]++
+		idle = append(idle, chatID)
+	}
+	sm.mu.Unlock()
+
+	for _, chatID := range idle {
+		sm.editMessage(chatID)
+	}
+}
+
+// touch records stream activity for chatID, which stops its spinner.
+func (sm *StreamManager) touch(chatID int64) {
+	sm.mu.Lock()
+	sm.lastActivity[chatID] = time.Now()
+	sm.mu.Unlock()
+}
+
+// progressLocked returns the spinner and, past the stall threshold, a
+// "no output" notice for an idle stream. Callers hold sm.mu.
+func (sm *StreamManager) progressLocked(chatID int64) string {
+	if !sm.progress {
+		return ""
+	}
+	last, ok := sm.lastActivity[chatID]
+	idle := time.Since(last)
+	if !ok || idle < sm.progressInterval() {
+		return ""
+	}
+	s := spinnerFrames[sm.spinFrame[chatID]%len(spinnerFrames)]
+	if idle >= sm.stallWarning {
+		s += fmt.Sprintf(" no output for %s", idle.Round(time.Second))
+	}
+	return s
+}
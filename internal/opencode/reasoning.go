@@ -0,0 +1,132 @@
+package opencode
+
+import (
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+// reasoningLabel heads the message a chat's reasoning streams into.
+const reasoningLabel = "💭 Reasoning"
+
+// ReasoningViewer decides which chats see the model's reasoning. It is
+// asked once per reply, on the SSE reader.
+type ReasoningViewer interface {
+	ShowReasoning(chatID int64) bool
+}
+
+// reasoningStream is the second message a reply's reasoning streams into,
+// apart from the reply itself.
+type reasoningStream struct {
+	messageID int    // 0 until the message is sent
+	partID    string // reasoning part being streamed
+	done      string // text of the reply's earlier reasoning parts
+	text      string // text of partID so far
+	lastEdit  time.Time
+	lastSent  uint64 // hash of the text last sent or edited
+}
+
+// reasoningFor returns the chat's reasoning stream, or nil if the chat
+// doesn't show reasoning.
+func (sm *StreamManager) reasoningFor(chatID int64) *reasoningStream {
+	if sm.viewer == nil {
+		return nil
+	}
+	sm.mu.RLock()
+	rs, decided := sm.reasoning[chatID]
+	sm.mu.RUnlock()
+	if decided {
+		return rs
+	}
+	if sm.viewer.ShowReasoning(chatID) {
+		rs = &reasoningStream{}
+	}
+	sm.mu.Lock()
+	sm.reasoning[chatID] = rs
+	sm.mu.Unlock()
+	return rs
+}
+
+// addReasoning adds to the chat's reasoning: a delta of part, or with
+// replace the part's full text so far.
+func (sm *StreamManager) addReasoning(chatID int64, partID, text string, replace bool) {
+	rs := sm.reasoningFor(chatID)
+	if rs == nil {
+		return
+	}
+	sm.mu.Lock()
+	if rs.partID != partID {
+		if rs.text != "" {
+			rs.done = sm.tailText(rs.done + rs.text + "\n\n")
+		}
+		rs.partID, rs.text = partID, ""
+	}
+	if replace {
+		if text != "" {
+			rs.text = sm.tailText(text)
+		}
+	} else {
+		rs.text = sm.tailText(rs.text + text)
+	}
+	sm.mu.Unlock()
+	sm.editReasoning(chatID, rs, false)
+}
+
+// editReasoning shows the chat's reasoning so far, at most once per edit
+// throttle unless final.
+func (sm *StreamManager) editReasoning(chatID int64, rs *reasoningStream, final bool) {
+	sm.editMu.Lock()
+	defer sm.editMu.Unlock()
+	sm.mu.RLock()
+	messageID, last := rs.messageID, rs.lastEdit
+	text := rs.done + rs.text
+	throttle := sm.throttleLocked(chatID)
+	sm.mu.RUnlock()
+	if text == "" || (!final && time.Since(last) < throttle) {
+		return
+	}
+	display := sm.truncate(reasoningLabel + "\n\n" + sm.tailText(text))
+	if rs.lastSent == hashText(display) {
+		return
+	}
+
+	var err error
+	if messageID == 0 {
+		messageID, err = sm.sender.SendText(chatID, display)
+	} else {
+		err = sm.sender.EditText(chatID, messageID, display)
+	}
+	if err != nil && !isNotModified(err) {
+		sm.backOff(chatID, err)
+		log.Printf("[StreamManager] Failed to stream reasoning to chat %d: %v", chatID, err)
+		return
+	}
+	sm.mu.Lock()
+	rs.messageID, rs.lastEdit, rs.lastSent = messageID, time.Now(), hashText(display)
+	sm.mu.Unlock()
+}
+
+// finishReasoning shows the last of the chat's reasoning once its reply is
+// complete, and forgets it.
+func (sm *StreamManager) finishReasoning(chatID int64) {
+	sm.mu.Lock()
+	rs := sm.reasoning[chatID]
+	delete(sm.reasoning, chatID)
+	sm.mu.Unlock()
+	if rs != nil {
+		sm.editReasoning(chatID, rs, true)
+	}
+}
+
+// tailText keeps the last maxMessageLen bytes of text, which is where
+// reasoning is headed.
+func (sm *StreamManager) tailText(text string) string {
+	if len(text) <= sm.maxMessageLen {
+		return text
+	}
+	cut := len(text) - sm.maxMessageLen + len("…")
+	for cut < len(text) && !utf8.RuneStart(text[cut]) {
+		cut++
+	}
+	return "…" + text[cut:]
+}
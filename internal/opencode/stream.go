@@ -3,13 +3,21 @@ package opencode
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
 )
 
 // MessageSender abstracts sending/editing messages so StreamManager
@@ -19,37 +27,299 @@ type MessageSender interface {
 	EditText(chatID int64, messageID int, text string) error
 }
 
+// FinalEditor is implemented by senders that prioritise the completion
+// edit of a stream over intermediate ones. markComplete uses it when
+// available.
+type FinalEditor interface {
+	EditFinalText(chatID int64, messageID int, text string) error
+}
+
+// FinalFormatter is implemented by senders whose final edit formats the
+// reply, so it changes the message even when its text is the same as the
+// last intermediate edit's. markComplete sends it regardless when
+// FormatsFinal reports text would be formatted.
+type FinalFormatter interface {
+	FormatsFinal(chatID int64, text string) bool
+}
+
+// StatusEditor is implemented by senders that show the status line apart
+// from the reply, such as a terminal. editMessage passes them both instead
+// of joining them into one message.
+type StatusEditor interface {
+	EditStatus(chatID int64, messageID int, text, status string) error
+}
+
+// MarkdownRenderer is implemented by senders that render reply text as
+// Markdown rather than show it as is. editMessage then closes the code
+// blocks and emphasis a half-written reply leaves open, so each
+// intermediate edit parses; the final edit is sent as written.
+type MarkdownRenderer interface {
+	RendersMarkdown() bool
+}
+
+// Ownership decides which replica streams a session when several bot
+// instances share one store and OpenCode server. store.LeaseManager
+// implements it.
+type Ownership interface {
+	// Claim takes or renews ownership; false means another replica has it.
+	Claim(sessionID string, chatID int64, messageID int) bool
+	// Adopt takes over a session abandoned by another replica.
+	Adopt(sessionID string) (chatID int64, messageID int, ok bool)
+	// Release gives up ownership once the reply is complete.
+	Release(sessionID string)
+}
+
+// TextArchive keeps the complete text of streamed replies: it receives the
+// text spilled from memory once a reply outgrows the in-memory cap, and
+// every finished reply. store.Store implements it.
+type TextArchive interface {
+	SaveMessageText(chatID int64, messageID int, sessionID, text string) error
+	AppendMessageText(chatID int64, messageID int, sessionID, chunk string) error
+}
+
+// CompletionNotifier is told once a chat's reply has finished streaming,
+// after the final edit. Edits don't notify on Telegram, so this is where
+// a "reply ready" ping can be sent.
+type CompletionNotifier interface {
+	ReplyComplete(chatID int64, messageID int)
+}
+
+// ToolGuard enforces tool policy for streamed sessions. Its methods run
+// on the SSE reader, so they must hand slow work to another goroutine.
+type ToolGuard interface {
+	// PermissionAsked is called when a tool in chatID's session waits
+	// for approval.
+	PermissionAsked(chatID int64, p Permission)
+	// ToolStarted is called once per tool call in chatID's session, as
+	// soon as the call appears.
+	ToolStarted(chatID int64, sessionID, callID, tool string)
+}
+
+// QuestionAsker puts the agent's questions to the user. It runs on the SSE
+// reader, so the asking belongs on another goroutine.
+type QuestionAsker interface {
+	QuestionAsked(chatID int64, q Question)
+}
+
+// UsageObserver is told how much of the context window a reply in
+// chatID's session has used so far. It runs on the SSE reader, so slow
+// work such as looking up the model's limit belongs on another goroutine.
+type UsageObserver interface {
+	ContextUsed(chatID int64, sessionID, providerID, modelID string, tokens int)
+}
+
+// FileObserver is told when OpenCode's watcher sees a file of the project
+// change on disk, whether the agent or someone else changed it. It runs on
+// the SSE reader.
+type FileObserver interface {
+	// FileChanged gets the file's path and "add", "change" or "unlink".
+	FileChanged(file, event string)
+}
+
+// TextProcessor rewrites reply text before the chat sees it: the text
+// streamed so far on each edit, and the whole reply, or each chunk, once
+// final. It runs on the SSE reader, so it must not block.
+type TextProcessor interface {
+	ProcessText(chatID int64, text string, final bool) string
+}
+
+// StreamOptions tunes the SSE connection.
+type StreamOptions struct {
+	// IdleTimeout forces a reconnect when no data (including server
+	// heartbeats) arrives for this long. Zero uses the default.
+	IdleTimeout time.Duration
+	// APIKey is sent as a bearer token on the SSE request when set.
+	APIKey string
+	// Proxy overrides HTTP(S)_PROXY for the SSE connection.
+	Proxy *url.URL
+	// TLS configures a custom CA or client certificate; nil uses Go's defaults.
+	TLS *tls.Config
+	// EditThrottle is the minimum interval between edits of a streaming
+	// message. Zero uses the default.
+	EditThrottle time.Duration
+	// MaxMessageLen truncates streamed text to this many characters, as
+	// Telegram counts them (see tgtext.Len). Zero uses the default.
+	MaxMessageLen int
+	// EventLogSize is how many recent SSE events are kept for /events.
+	// Zero uses the default.
+	EventLogSize int
+	// Ownership coordinates streaming across replicas. Nil means this
+	// process streams every session it registers.
+	Ownership Ownership
+	// Archive receives the full reply text. Nil keeps only the capped
+	// in-memory copy.
+	Archive TextArchive
+	// DisableProgress turns off the spinner shown while a stream is quiet.
+	DisableProgress bool
+	// StallWarning is how long a stream may stay quiet before the status
+	// line says so. Zero uses the default.
+	StallWarning time.Duration
+	// Notifier is called when a reply completes. Nil sends nothing extra.
+	Notifier CompletionNotifier
+	// Guard sees tool calls and permission requests. Nil leaves them to
+	// OpenCode's own configuration.
+	Guard ToolGuard
+	// Questions puts the agent's questions to the chat. Nil leaves them
+	// unanswered, and the run waits.
+	Questions QuestionAsker
+	// Usage sees the context usage of replies, e.g. to warn with
+	// SetHeader. Nil ignores it.
+	Usage UsageObserver
+	// Files sees file changes in the project. Nil ignores them.
+	Files FileObserver
+	// Reasoning picks the chats whose replies' reasoning streams into a
+	// message of its own. Nil drops reasoning, as do chats it turns down.
+	Reasoning ReasoningViewer
+	// Modes picks the chats that see replies chunked or only once final.
+	// Nil streams every reply live.
+	Modes StreamModes
+	// Postprocess rewrites replies before they are shown; the archive
+	// keeps them as OpenCode wrote them. Nil shows them unchanged.
+	Postprocess TextProcessor
+	// Private keeps event payloads, which carry prompt and reply text,
+	// out of /events and error reports; only their length and hash are
+	// recorded.
+	Private bool
+}
+
+const (
+	defaultSSEIdleTimeout = 90 * time.Second
+	defaultEditThrottle   = 1 * time.Second
+	defaultMaxMessageLen  = 4000
+	// markdownSlack is the room an intermediate edit keeps for the markers
+	// tgtext.CloseMarkdown adds.
+	markdownSlack = 32
+)
+
 // StreamManager handles SSE streaming from OpenCode and dispatches
 // updates through a MessageSender.
 type StreamManager struct {
 	baseURL        string
 	httpClient     *http.Client
+	transport      *http.Transport
+	idleTimeout    time.Duration
+	apiKey         string
 	sender         MessageSender
 	sessionToChat  map[string]int64
 	chatToMsgID    map[int64]int
-	chatToText     map[int64]string
+	chatToText     map[int64]string // capped to head+tail, see setText
+	spilled        map[int64]bool   // chats whose full text lives in the archive
+	ansiTail       map[int64]string // escape sequence cut off at the end of the last delta
 	chatToStatus   map[int64]string
-	reasoningParts map[string]bool
+	chatToHeader   map[int64]string // notice shown above the reply, see SetHeader
+	reasoningParts map[chatPart]bool
+	reasoning      map[int64]*reasoningStream
+	toolParts      map[chatPart]bool         // tool parts already seen, per chat
+	toolCalls      map[int64]int             // tool calls made in the chat's current reply
+	edited         map[int64][]string        // files the chat's current reply edited, most recent last
+	subscribers    map[string]map[int64]bool // session ID -> chats following it, see Subscribe
+	subscribed     map[int64]string          // chat ID -> session it follows
+	viewing        map[int64]bool            // chats whose current stream is a subscription
+	modes          map[int64]string          // stream mode of the chat's current reply
+	chunkSent      map[int64]int             // bytes of the current text part sent as chunks
+	chunkMsgs      map[int64]int             // chunk messages sent for the current reply
 	textPartIDs    map[int64]string
 	lastEdit       map[int64]time.Time
+	lastSentHash   map[int64]uint64 // hash of the text last sent/edited per chat
+	lastActivity   map[int64]time.Time
+	spinFrame      map[int64]int
+	progress       bool
+	stallWarning   time.Duration
 	editThrottle   time.Duration
+	throttle       map[int64]time.Duration // each chat's edit interval, once it adapted
+	maxMessageLen  int
+	events         *eventLog
+	ownership      Ownership
+	archive        TextArchive
+	notifier       CompletionNotifier
+	guard          ToolGuard
+	questions      QuestionAsker
+	usage          UsageObserver
+	files          FileObserver
+	viewer         ReasoningViewer
+	modeHook       StreamModes
+	postprocess    TextProcessor
+	private        bool
+	connected      atomic.Bool
+	health         streamHealth
 	mu             sync.RWMutex
+	editMu         sync.Mutex // serializes edits from the SSE reader and the progress ticker
 }
 
 // NewStreamManager creates a StreamManager backed by the given MessageSender.
-func NewStreamManager(baseURL string, sender MessageSender) *StreamManager {
+func NewStreamManager(baseURL string, sender MessageSender, opts StreamOptions) *StreamManager {
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaultSSEIdleTimeout
+	}
+	if opts.EditThrottle <= 0 {
+		opts.EditThrottle = defaultEditThrottle
+	}
+	if opts.MaxMessageLen <= 0 {
+		opts.MaxMessageLen = defaultMaxMessageLen
+	}
+	if opts.StallWarning <= 0 {
+		opts.StallWarning = defaultStallWarning
+	}
+	// The SSE connection is long-lived, so the client has no overall
+	// timeout; liveness is enforced by the idle watchdog instead.
+	transport := &http.Transport{
+		Proxy:           proxyFunc(opts.Proxy),
+		TLSClientConfig: opts.TLS,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ResponseHeaderTimeout: 30 * time.Second,
+		MaxIdleConns:          1,
+		IdleConnTimeout:       30 * time.Second,
+	}
 	return &StreamManager{
 		baseURL:        baseURL,
-		httpClient:     &http.Client{Timeout: 0},
+		httpClient:     &http.Client{Transport: transport, Timeout: 0},
+		transport:      transport,
+		idleTimeout:    opts.IdleTimeout,
+		apiKey:         opts.APIKey,
 		sender:         sender,
 		sessionToChat:  make(map[string]int64),
 		chatToMsgID:    make(map[int64]int),
 		chatToText:     make(map[int64]string),
+		spilled:        make(map[int64]bool),
+		ansiTail:       make(map[int64]string),
 		chatToStatus:   make(map[int64]string),
-		reasoningParts: make(map[string]bool),
+		chatToHeader:   make(map[int64]string),
+		reasoningParts: make(map[chatPart]bool),
+		reasoning:      make(map[int64]*reasoningStream),
+		toolParts:      make(map[chatPart]bool),
+		toolCalls:      make(map[int64]int),
+		edited:         make(map[int64][]string),
+		subscribers:    make(map[string]map[int64]bool),
+		subscribed:     make(map[int64]string),
+		viewing:        make(map[int64]bool),
+		modes:          make(map[int64]string),
+		chunkSent:      make(map[int64]int),
+		chunkMsgs:      make(map[int64]int),
 		textPartIDs:    make(map[int64]string),
 		lastEdit:       make(map[int64]time.Time),
-		editThrottle:   1 * time.Second,
+		lastSentHash:   make(map[int64]uint64),
+		lastActivity:   make(map[int64]time.Time),
+		spinFrame:      make(map[int64]int),
+		progress:       !opts.DisableProgress,
+		stallWarning:   opts.StallWarning,
+		editThrottle:   opts.EditThrottle,
+		throttle:       make(map[int64]time.Duration),
+		maxMessageLen:  opts.MaxMessageLen,
+		events:         newEventLog(opts.EventLogSize),
+		ownership:      opts.Ownership,
+		archive:        opts.Archive,
+		notifier:       opts.Notifier,
+		guard:          opts.Guard,
+		questions:      opts.Questions,
+		usage:          opts.Usage,
+		files:          opts.Files,
+		viewer:         opts.Reasoning,
+		modeHook:       opts.Modes,
+		postprocess:    opts.Postprocess,
+		private:        opts.Private,
 	}
 }
 
@@ -57,6 +327,9 @@ func NewStreamManager(baseURL string, sender MessageSender) *StreamManager {
 func (sm *StreamManager) Start(ctx context.Context) error {
 	url := sm.baseURL + "/event"
 	log.Printf("[StreamManager] Starting SSE connection to %s", url)
+	if sm.progress {
+		go sm.runProgress(ctx)
+	}
 
 	for {
 		select {
@@ -69,13 +342,26 @@ func (sm *StreamManager) Start(ctx context.Context) error {
 			if ctx.Err() != nil {
 				return ctx.Err()
 			}
+			sm.health.noteDisconnected(err)
+			// Drop any pooled connection left over from the failed stream
+			// so the reconnect always dials fresh.
+			sm.transport.CloseIdleConnections()
 			log.Printf("[StreamManager] Connection error: %v, retrying in 2s...", err)
-			time.Sleep(2 * time.Second)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(2 * time.Second):
+			}
 		}
 	}
 }
 
-func (sm *StreamManager) connectAndRead(ctx context.Context, url string) error {
+func (sm *StreamManager) connectAndRead(parent context.Context, url string) error {
+	// Per-connection context: cancelling it unblocks the scanner and
+	// closes the body when the idle watchdog fires.
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
@@ -83,6 +369,9 @@ func (sm *StreamManager) connectAndRead(ctx context.Context, url string) error {
 	req.Header.Set("Accept", "text/event-stream")
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("Connection", "keep-alive")
+	if sm.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+sm.apiKey)
+	}
 
 	resp, err := sm.httpClient.Do(req)
 	if err != nil {
@@ -90,30 +379,42 @@ func (sm *StreamManager) connectAndRead(ctx context.Context, url string) error {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("unauthorized (status %d): check OPENCODE_API_KEY", resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
 	}
 	log.Println("[StreamManager] Connected to SSE stream")
+	sm.connected.Store(true)
+	defer sm.connected.Store(false)
+	sm.health.noteConnected()
+
+	var idle atomic.Bool
+	watchdog := time.AfterFunc(sm.idleTimeout, func() {
+		idle.Store(true)
+		cancel()
+	})
+	defer watchdog.Stop()
 
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 
 	var eventData string
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
 		if !scanner.Scan() {
+			if idle.Load() {
+				return fmt.Errorf("no data for %s", sm.idleTimeout)
+			}
 			if err := scanner.Err(); err != nil {
-				if ctx.Err() != nil {
-					return ctx.Err()
+				if parent.Err() != nil {
+					return parent.Err()
 				}
 				return fmt.Errorf("scanner: %w", err)
 			}
 			return fmt.Errorf("SSE stream closed unexpectedly")
 		}
+		watchdog.Reset(sm.idleTimeout)
 		line := scanner.Text()
 		if strings.HasPrefix(line, "data: ") {
 			eventData = strings.TrimPrefix(line, "data: ")
@@ -127,40 +428,181 @@ func (sm *StreamManager) connectAndRead(ctx context.Context, url string) error {
 func (sm *StreamManager) processEventData(data string) {
 	var event SSEEvent
 	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		sm.health.noteEvent(true)
 		log.Printf("[StreamManager] Failed to parse event: %v", err)
+		sm.recordEvent("(unparseable)", json.RawMessage(data))
+		errreport.Capture(fmt.Errorf("parse SSE event: %w", err), errreport.Fields{"payload": sm.payload(data)})
 		return
 	}
+	sm.health.noteEvent(false)
+	sm.recordEvent(event.Type, event.Properties)
+
+	sessionID := eventSessionID(event.Properties)
+	fields := errreport.Fields{"event": event.Type, "session_id": sessionID}
+	sm.mu.RLock()
+	if chatID, ok := sm.sessionToChat[sessionID]; ok {
+		fields["chat_id"] = fmt.Sprint(chatID)
+	}
+	sm.mu.RUnlock()
+	// A bad event must not kill the SSE reader goroutine.
+	defer errreport.Recover(fields)
 	sm.handleEvent(event)
 }
 
 // RegisterSession maps an OpenCode session ID to a Telegram chat + message.
 func (sm *StreamManager) RegisterSession(sessionID string, chatID int64, messageID int) {
+	if sm.ownership != nil && !sm.ownership.Claim(sessionID, chatID, messageID) {
+		log.Printf("[StreamManager] Session %s is streamed by another replica; will take over when it finishes", sessionID)
+	}
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	sm.registerLocked(sessionID, chatID, messageID)
+	log.Printf("[StreamManager] Registered session %s -> chat %d, message %d", sessionID, chatID, messageID)
+}
 
+func (sm *StreamManager) registerLocked(sessionID string, chatID int64, messageID int) {
 	sm.sessionToChat[sessionID] = chatID
 	sm.chatToMsgID[chatID] = messageID
 	sm.chatToText[chatID] = ""
+	delete(sm.spilled, chatID)
+	delete(sm.ansiTail, chatID)
 	sm.chatToStatus[chatID] = ""
+	delete(sm.chatToHeader, chatID)
 	sm.textPartIDs[chatID] = ""
 	sm.lastEdit[chatID] = time.Time{}
-	log.Printf("[StreamManager] Registered session %s -> chat %d, message %d", sessionID, chatID, messageID)
+	delete(sm.lastSentHash, chatID)
+	sm.lastActivity[chatID] = time.Now()
+	delete(sm.spinFrame, chatID)
+	delete(sm.reasoning, chatID)
+	delete(sm.toolCalls, chatID)
+	delete(sm.edited, chatID)
+	delete(sm.viewing, chatID)
+	delete(sm.modes, chatID)
+	delete(sm.chunkSent, chatID)
+	delete(sm.chunkMsgs, chatID)
+}
+
+// chatFor returns the chat streaming sessionID if this replica owns it.
+// Sessions abandoned by another replica are adopted on their next event.
+func (sm *StreamManager) chatFor(sessionID string) (int64, bool) {
+	sm.mu.RLock()
+	chatID, ok := sm.sessionToChat[sessionID]
+	messageID := sm.chatToMsgID[chatID]
+	sm.mu.RUnlock()
+	if ok && (sm.ownership == nil || sm.ownership.Claim(sessionID, chatID, messageID)) {
+		sm.touch(chatID)
+		return chatID, true
+	}
+	if ok || sm.ownership == nil {
+		return 0, false
+	}
+
+	chatID, messageID, ok = sm.ownership.Adopt(sessionID)
+	if !ok {
+		return 0, false
+	}
+	sm.mu.Lock()
+	sm.registerLocked(sessionID, chatID, messageID)
+	sm.mu.Unlock()
+	log.Printf("[StreamManager] Adopted session %s -> chat %d, message %d", sessionID, chatID, messageID)
+	return chatID, true
+}
+
+// Complete finishes sessionID's reply now, as if it had ended, e.g. once
+// it is aborted: the text so far becomes the final message.
+func (sm *StreamManager) Complete(sessionID string) {
+	sm.mu.RLock()
+	chatID, ok := sm.sessionToChat[sessionID]
+	sm.mu.RUnlock()
+	if !ok {
+		return
+	}
+	for _, c := range sm.fanOut(chatID, sessionID) {
+		sm.markComplete(c, sessionID)
+	}
+}
+
+// LiveMessage returns the session and message of the reply the chat is
+// streaming, if it is running one of its own.
+func (sm *StreamManager) LiveMessage(chatID int64) (sessionID string, messageID int, ok bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if sm.viewing[chatID] {
+		return "", 0, false
+	}
+	if messageID, ok = sm.chatToMsgID[chatID]; !ok {
+		return "", 0, false
+	}
+	for s, c := range sm.sessionToChat {
+		if c == chatID {
+			return s, messageID, true
+		}
+	}
+	return "", 0, false
+}
+
+// RunningChats returns the chats streaming a reply of their own.
+func (sm *StreamManager) RunningChats() []int64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	chats := make([]int64, 0, len(sm.chatToMsgID))
+	for chatID := range sm.chatToMsgID {
+		if !sm.viewing[chatID] {
+			chats = append(chats, chatID)
+		}
+	}
+	return chats
 }
 
 // UnregisterSession removes a session mapping.
 func (sm *StreamManager) UnregisterSession(sessionID string) {
+	if sm.ownership != nil {
+		sm.ownership.Release(sessionID)
+	}
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 	if chatID, ok := sm.sessionToChat[sessionID]; ok {
 		delete(sm.sessionToChat, sessionID)
-		delete(sm.chatToMsgID, chatID)
-		delete(sm.chatToText, chatID)
-		delete(sm.chatToStatus, chatID)
-		delete(sm.textPartIDs, chatID)
-		delete(sm.lastEdit, chatID)
+		sm.clearStreamLocked(chatID)
 	}
 }
 
+// clearStreamLocked drops the chat's stream state. Callers hold sm.mu.
+func (sm *StreamManager) clearStreamLocked(chatID int64) {
+	delete(sm.chatToMsgID, chatID)
+	delete(sm.chatToText, chatID)
+	delete(sm.spilled, chatID)
+	delete(sm.ansiTail, chatID)
+	delete(sm.chatToStatus, chatID)
+	delete(sm.chatToHeader, chatID)
+	delete(sm.textPartIDs, chatID)
+	delete(sm.lastEdit, chatID)
+	delete(sm.lastSentHash, chatID)
+	delete(sm.lastActivity, chatID)
+	delete(sm.spinFrame, chatID)
+	delete(sm.reasoning, chatID)
+	delete(sm.toolCalls, chatID)
+	delete(sm.edited, chatID)
+	delete(sm.viewing, chatID)
+	delete(sm.modes, chatID)
+	delete(sm.chunkSent, chatID)
+	delete(sm.chunkMsgs, chatID)
+}
+
+// ForgetSession stops routing sessionID's events to the chat it was
+// registered for. Unlike UnregisterSession it leaves the chat's stream
+// alone, which may belong to another session by now.
+func (sm *StreamManager) ForgetSession(sessionID string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.sessionToChat, sessionID)
+}
+
+// Connected reports whether the SSE stream is currently connected.
+func (sm *StreamManager) Connected() bool {
+	return sm.connected.Load()
+}
+
 // GetActiveSessionCount returns the number of tracked sessions.
 func (sm *StreamManager) GetActiveSessionCount() int {
 	sm.mu.RLock()
@@ -176,8 +618,20 @@ func (sm *StreamManager) handleEvent(event SSEEvent) {
 		sm.handlePartDelta(event.Properties)
 	case "message.updated":
 		sm.handleMessageUpdated(event.Properties)
+	case "permission.updated":
+		sm.handlePermission(event.Properties)
 	case "session.idle":
 		// handled by message.updated finish detection
+	case "permission.replied":
+		// ignore
+	case "question.asked":
+		sm.handleQuestion(event.Properties)
+	case "question.replied", "question.rejected":
+		// ignore
+	case "file.watcher.updated":
+		sm.handleFileWatcher(event.Properties)
+	case "file.edited":
+		// the agent's edits, also reported by the watcher
 	case "server.connected", "server.heartbeat", "session.created", "session.updated", "session.status", "session.diff":
 		// ignore
 	default:
@@ -185,6 +639,20 @@ func (sm *StreamManager) handleEvent(event SSEEvent) {
 	}
 }
 
+func (sm *StreamManager) handleFileWatcher(raw json.RawMessage) {
+	if sm.files == nil {
+		return
+	}
+	var props FileWatcherProperties
+	if err := json.Unmarshal(raw, &props); err != nil {
+		log.Printf("[StreamManager] Failed to parse file.watcher.updated: %v", err)
+		return
+	}
+	if props.File != "" {
+		sm.files.FileChanged(props.File, props.Event)
+	}
+}
+
 func (sm *StreamManager) handlePartUpdated(raw json.RawMessage) {
 	var props PartProperties
 	if err := json.Unmarshal(raw, &props); err != nil {
@@ -196,34 +664,43 @@ func (sm *StreamManager) handlePartUpdated(raw json.RawMessage) {
 		return
 	}
 
-	sm.mu.RLock()
-	chatID, ok := sm.sessionToChat[sessionID]
-	sm.mu.RUnlock()
+	chatID, ok := sm.chatFor(sessionID)
 	if !ok {
 		return
 	}
+	for _, c := range sm.fanOut(chatID, sessionID) {
+		sm.applyPart(c, c == chatID, sessionID, props)
+	}
+}
 
+// applyPart shows a part of a reply in one of the chats streaming it; only
+// the chat that registered the session is its owner.
+func (sm *StreamManager) applyPart(chatID int64, owner bool, sessionID string, props PartProperties) {
 	switch props.Part.Type {
 	case "text":
+		if sm.modeFor(chatID) == ModeChunked {
+			sm.startChunkPart(chatID, props.Part.ID)
+		}
 		sm.mu.Lock()
 		sm.textPartIDs[chatID] = props.Part.ID
-		if props.Part.Text != "" {
-			sm.chatToText[chatID] = props.Part.Text
-		}
 		sm.chatToStatus[chatID] = ""
 		sm.mu.Unlock()
+		if props.Part.Text != "" {
+			sm.setText(chatID, sessionID, props.Part.Text)
+		}
 		if props.Part.Text != "" {
 			sm.editMessage(chatID)
 		}
 	case "reasoning":
 		sm.mu.Lock()
-		sm.reasoningParts[props.Part.ID] = true
+		sm.reasoningParts[chatPart{chatID: chatID, partID: props.Part.ID}] = true
 		if props.Part.Text == "" {
 			sm.chatToStatus[chatID] = "Thinking..."
 		} else {
 			sm.chatToStatus[chatID] = ""
 		}
 		sm.mu.Unlock()
+		sm.addReasoning(chatID, props.Part.ID, props.Part.Text, true)
 		sm.editMessage(chatID)
 	case "step-start":
 		sm.mu.Lock()
@@ -243,6 +720,77 @@ func (sm *StreamManager) handlePartUpdated(raw json.RawMessage) {
 		sm.mu.Lock()
 		sm.chatToStatus[chatID] = ""
 		sm.mu.Unlock()
+	case "tool":
+		sm.handleToolPart(chatID, owner, sessionID, props)
+	case "patch":
+		// A step's edits, listed as it finishes.
+		sm.noteEdited(chatID, props.Part.Files...)
+		sm.editMessage(chatID)
+	}
+}
+
+// chatPart is a part as streamed to one chat.
+type chatPart struct {
+	chatID int64
+	partID string
+}
+
+// handleToolPart shows the running tool, counts the reply's calls for its
+// footer and passes each new call in the owner's stream to the guard.
+func (sm *StreamManager) handleToolPart(chatID int64, owner bool, sessionID string, props PartProperties) {
+	part := props.Part
+	key := chatPart{chatID: chatID, partID: part.ID}
+	if editTools[part.Tool] {
+		sm.noteEdited(chatID, part.State.Input.FilePath)
+	}
+	switch part.State.Status {
+	case "pending", "running":
+	default:
+		sm.mu.Lock()
+		sm.chatToStatus[chatID] = ""
+		delete(sm.toolParts, key)
+		sm.mu.Unlock()
+		return
+	}
+	sm.mu.Lock()
+	sm.chatToStatus[chatID] = "Running " + part.Tool + "..."
+	seen := sm.toolParts[key]
+	sm.toolParts[key] = true
+	if !seen {
+		sm.toolCalls[chatID]++
+	}
+	sm.mu.Unlock()
+	if owner && !seen && sm.guard != nil {
+		sm.guard.ToolStarted(chatID, sessionID, part.CallID, part.Tool)
+	}
+	sm.editMessage(chatID)
+}
+
+func (sm *StreamManager) handlePermission(raw json.RawMessage) {
+	var p Permission
+	if err := json.Unmarshal(raw, &p); err != nil {
+		log.Printf("[StreamManager] Failed to parse permission.updated: %v", err)
+		return
+	}
+	if p.SessionID == "" || sm.guard == nil {
+		return
+	}
+	if chatID, ok := sm.chatFor(p.SessionID); ok {
+		sm.guard.PermissionAsked(chatID, p)
+	}
+}
+
+func (sm *StreamManager) handleQuestion(raw json.RawMessage) {
+	var q Question
+	if err := json.Unmarshal(raw, &q); err != nil {
+		log.Printf("[StreamManager] Failed to parse question.asked: %v", err)
+		return
+	}
+	if q.SessionID == "" || len(q.Questions) == 0 || sm.questions == nil {
+		return
+	}
+	if chatID, ok := sm.chatFor(q.SessionID); ok {
+		sm.questions.QuestionAsked(chatID, q)
 	}
 }
 
@@ -256,20 +804,25 @@ func (sm *StreamManager) handlePartDelta(raw json.RawMessage) {
 		return
 	}
 
-	sm.mu.RLock()
-	chatID, ok := sm.sessionToChat[props.SessionID]
-	isReasoning := sm.reasoningParts[props.PartID]
-	sm.mu.RUnlock()
-	if !ok || isReasoning {
+	chatID, ok := sm.chatFor(props.SessionID)
+	if !ok {
 		return
 	}
+	for _, c := range sm.fanOut(chatID, props.SessionID) {
+		sm.mu.RLock()
+		isReasoning := sm.reasoningParts[chatPart{chatID: c, partID: props.PartID}]
+		sm.mu.RUnlock()
+		if isReasoning {
+			sm.addReasoning(c, props.PartID, props.Delta, false)
+			continue
+		}
+		sm.appendText(c, props.SessionID, props.Delta)
+		sm.mu.Lock()
+		sm.chatToStatus[c] = ""
+		sm.mu.Unlock()
 
-	sm.mu.Lock()
-	sm.chatToText[chatID] += props.Delta
-	sm.chatToStatus[chatID] = ""
-	sm.mu.Unlock()
-
-	sm.editMessage(chatID)
+		sm.editMessage(c)
+	}
 }
 
 func (sm *StreamManager) handleMessageUpdated(raw json.RawMessage) {
@@ -281,18 +834,32 @@ func (sm *StreamManager) handleMessageUpdated(raw json.RawMessage) {
 	if sessionID == "" || props.Info.Role != "assistant" {
 		return
 	}
+	if tokens := props.Info.Tokens.Context(); tokens > 0 && sm.usage != nil {
+		if chatID, ok := sm.chatFor(sessionID); ok {
+			sm.usage.ContextUsed(chatID, sessionID, props.Info.ProviderID, props.Info.ModelID, tokens)
+		}
+	}
 	if props.Info.Finish != "" {
-		sm.mu.RLock()
-		chatID, ok := sm.sessionToChat[sessionID]
-		sm.mu.RUnlock()
-		if ok {
-			sm.markComplete(chatID, sessionID)
+		if chatID, ok := sm.chatFor(sessionID); ok {
+			for _, c := range sm.fanOut(chatID, sessionID) {
+				sm.markComplete(c, sessionID)
+			}
 		}
 	}
 }
 
 func (sm *StreamManager) editMessage(chatID int64) {
+	switch sm.modeFor(chatID) {
+	case ModeFinal:
+		return
+	case ModeChunked:
+		sm.sendChunks(chatID)
+		return
+	}
+	sm.editMu.Lock()
+	defer sm.editMu.Unlock()
 	if !sm.canEdit(chatID) {
+		streamEdits.Inc("throttled")
 		return
 	}
 
@@ -300,8 +867,30 @@ func (sm *StreamManager) editMessage(chatID int64) {
 	messageID, hasMsg := sm.chatToMsgID[chatID]
 	text := sm.chatToText[chatID]
 	status := sm.chatToStatus[chatID]
+	header := sm.chatToHeader[chatID]
+	progress := sm.progressLocked(chatID)
+	edited := sm.editedLineLocked(chatID)
 	sm.mu.RUnlock()
 
+	text = sm.process(chatID, text, false)
+	if header != "" {
+		text = strings.TrimSuffix(header+"\n\n"+text, "\n\n")
+	}
+	if progress != "" {
+		if status != "" {
+			status += " " + progress
+		} else {
+			status = progress
+		}
+	}
+	if edited != "" {
+		status = strings.TrimSuffix(edited+"\n"+status, "\n")
+	}
+	if mr, ok := sm.sender.(MarkdownRenderer); ok && mr.RendersMarkdown() && text != "" {
+		// Cut first, leaving room for the status line and the closing
+		// markers, so the cut can't undo the closing.
+		text = tgtext.CloseMarkdown(tgtext.Truncate(text, sm.maxMessageLen-tgtext.Len(status)-markdownSlack))
+	}
 	display := text
 	if status != "" {
 		if display != "" {
@@ -313,50 +902,149 @@ func (sm *StreamManager) editMessage(chatID int64) {
 	if display == "" {
 		return
 	}
-	if len(display) > 4000 {
-		display = display[:4000] + "\n\n... (truncated)"
+	display = sm.truncate(display)
+	if sm.unchanged(chatID, display) {
+		streamEdits.Inc("unchanged")
+		return
 	}
 
 	if !hasMsg {
 		msgID, err := sm.sender.SendText(chatID, display)
+		streamEdits.Inc(editResult(err, "sent"))
 		if err != nil {
+			sm.backOff(chatID, err)
 			log.Printf("[StreamManager] Failed to send: %v", err)
 			return
 		}
 		sm.mu.Lock()
 		sm.chatToMsgID[chatID] = msgID
 		sm.mu.Unlock()
+		sm.markSent(chatID, display)
 	} else {
-		if err := sm.sender.EditText(chatID, messageID, display); err != nil {
-			if !strings.Contains(err.Error(), "message is not modified") {
-				log.Printf("[StreamManager] Failed to edit: %v", err)
-			}
+		var err error
+		if se, ok := sm.sender.(StatusEditor); ok {
+			err = se.EditStatus(chatID, messageID, sm.truncate(text), status)
+		} else {
+			err = sm.sender.EditText(chatID, messageID, display)
+		}
+		streamEdits.Inc(editResult(err, "edited"))
+		if err == nil || isNotModified(err) {
+			sm.markSent(chatID, display)
+		} else {
+			sm.backOff(chatID, err)
+			log.Printf("[StreamManager] Failed to edit: %v", err)
 		}
 	}
 
 	sm.mu.Lock()
-	sm.lastEdit[chatID] = time.Now()
+	sm.noteEditLocked(chatID, time.Now())
+	sm.mu.Unlock()
+}
+
+// SetHeader shows notice above the reply chatID is streaming, until the
+// reply completes; it stays in the final message. An empty notice removes
+// it. Chats without a running reply are ignored.
+func (sm *StreamManager) SetHeader(chatID int64, notice string) {
+	sm.mu.Lock()
+	_, ok := sm.chatToMsgID[chatID]
+	changed := ok && sm.chatToHeader[chatID] != notice
+	if changed {
+		sm.chatToHeader[chatID] = notice
+	}
 	sm.mu.Unlock()
+	if changed {
+		sm.editMessage(chatID)
+	}
+}
+
+// ToolFooter is the line a final reply ends with when it made tool calls,
+// e.g. "🔧 3 tool calls", or "" if it made none.
+func ToolFooter(calls int) string {
+	switch calls {
+	case 0:
+		return ""
+	case 1:
+		return "\n\n🔧 1 tool call"
+	}
+	return fmt.Sprintf("\n\n🔧 %d tool calls", calls)
+}
+
+// truncate cuts text to the configured message length.
+func (sm *StreamManager) truncate(text string) string {
+	return tgtext.Truncate(text, sm.maxMessageLen)
+}
+
+// process runs text through the Postprocess hook, if any.
+func (sm *StreamManager) process(chatID int64, text string, final bool) string {
+	if sm.postprocess == nil || text == "" {
+		return text
+	}
+	return sm.postprocess.ProcessText(chatID, text, final)
 }
 
 func (sm *StreamManager) markComplete(chatID int64, sessionID string) {
+	sm.finishReasoning(chatID)
+	chunked := sm.modeFor(chatID) == ModeChunked
+	sm.editMu.Lock()
+	defer sm.editMu.Unlock()
+	if chunked {
+		// What is left goes out as the last chunk, with the footer. Only
+		// a reply that sent no chunk at all is edited below.
+		sm.mu.Lock()
+		if sm.chunkMsgs[chatID] > 0 || strings.TrimSpace(sm.chatToText[chatID]) != "" {
+			sm.chatToText[chatID] += ToolFooter(sm.toolCalls[chatID])
+		}
+		sm.mu.Unlock()
+		sm.sendChunksLocked(chatID, true)
+	}
 	sm.mu.RLock()
 	messageID, hasMsg := sm.chatToMsgID[chatID]
 	text := sm.chatToText[chatID]
+	spilled := sm.spilled[chatID]
+	header := sm.chatToHeader[chatID]
+	calls := sm.toolCalls[chatID]
+	viewing := sm.viewing[chatID]
+	sent := sm.chunkMsgs[chatID] > 0
 	sm.mu.RUnlock()
 
 	if !hasMsg {
+		if viewing {
+			sm.mu.Lock()
+			sm.clearStreamLocked(chatID)
+			sm.mu.Unlock()
+		}
 		return
 	}
+	if sm.archive != nil && text != "" && !spilled && !viewing && !chunked {
+		if err := sm.archive.SaveMessageText(chatID, messageID, sessionID, text); err != nil {
+			log.Printf("[StreamManager] Failed to cache reply for chat %d: %v", chatID, err)
+		}
+	}
+	text = sm.process(chatID, text, true)
 	if text == "" {
 		text = "Completed"
 	}
-	if len(text) > 4000 {
-		text = text[:4000] + "\n\n... (truncated)"
+	if header != "" {
+		text = header + "\n\n" + text
 	}
+	// The footer is kept when a long reply is cut.
+	footer := ToolFooter(calls)
+	text = tgtext.Truncate(text, sm.maxMessageLen-tgtext.Len(footer)) + footer
 
-	if err := sm.sender.EditText(chatID, messageID, text); err != nil {
-		if !strings.Contains(err.Error(), "message is not modified") {
+	switch {
+	case sent:
+		// The chunks are the reply.
+	case sm.unchanged(chatID, text) && !sm.formatsFinal(chatID, text):
+		streamEdits.Inc("unchanged")
+	default:
+		var err error
+		if fe, ok := sm.sender.(FinalEditor); ok {
+			err = fe.EditFinalText(chatID, messageID, text)
+		} else {
+			err = sm.sender.EditText(chatID, messageID, text)
+		}
+		streamEdits.Inc(editResult(err, "edited"))
+		if err != nil && !isNotModified(err) {
 			log.Printf("[StreamManager] Failed to mark complete: %v", err)
 		}
 	}
@@ -365,13 +1053,74 @@ func (sm *StreamManager) markComplete(chatID int64, sessionID string) {
 	sm.mu.Lock()
 	delete(sm.chatToMsgID, chatID)
 	delete(sm.chatToText, chatID)
+	delete(sm.spilled, chatID)
+	delete(sm.ansiTail, chatID)
 	delete(sm.chatToStatus, chatID)
+	delete(sm.chatToHeader, chatID)
 	delete(sm.textPartIDs, chatID)
 	delete(sm.lastEdit, chatID)
+	delete(sm.lastSentHash, chatID)
+	delete(sm.lastActivity, chatID)
+	delete(sm.spinFrame, chatID)
+	delete(sm.toolCalls, chatID)
+	delete(sm.edited, chatID)
+	delete(sm.viewing, chatID)
+	delete(sm.modes, chatID)
+	delete(sm.chunkSent, chatID)
+	delete(sm.chunkMsgs, chatID)
 	for k := range sm.reasoningParts {
-		delete(sm.reasoningParts, k)
+		if k.chatID == chatID {
+			delete(sm.reasoningParts, k)
+		}
+	}
+	for k := range sm.toolParts {
+		if k.chatID == chatID {
+			delete(sm.toolParts, k)
+		}
 	}
 	sm.mu.Unlock()
+
+	// A subscriber's copy of the reply is only shown.
+	if viewing {
+		return
+	}
+	if sm.ownership != nil {
+		sm.ownership.Release(sessionID)
+	}
+	if sm.notifier != nil {
+		sm.notifier.ReplyComplete(chatID, messageID)
+	}
+}
+
+// unchanged reports whether text is what the chat's message already shows,
+// so the edit can be skipped without calling Telegram.
+func (sm *StreamManager) unchanged(chatID int64, text string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	last, ok := sm.lastSentHash[chatID]
+	return ok && last == hashText(text)
+}
+
+func (sm *StreamManager) formatsFinal(chatID int64, text string) bool {
+	ff, ok := sm.sender.(FinalFormatter)
+	return ok && ff.FormatsFinal(chatID, text)
+}
+
+func (sm *StreamManager) markSent(chatID int64, text string) {
+	sm.mu.Lock()
+	sm.lastSentHash[chatID] = hashText(text)
+	sm.mu.Unlock()
+}
+
+func hashText(text string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	return h.Sum64()
+}
+
+// isNotModified reports Telegram's harmless "message is not modified" error.
+func isNotModified(err error) bool {
+	return strings.Contains(err.Error(), "message is not modified")
 }
 
 func (sm *StreamManager) canEdit(chatID int64) bool {
@@ -381,5 +1130,5 @@ func (sm *StreamManager) canEdit(chatID int64) bool {
 	if !ok {
 		return true
 	}
-	return time.Since(last) >= sm.editThrottle
+	return time.Since(last) >= sm.throttleLocked(chatID)
 }
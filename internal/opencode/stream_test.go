@@ -0,0 +1,120 @@
+package opencode
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type nopSender struct{}
+
+func (nopSender) SendText(chatID int64, text string) (int, error)         { return 1, nil }
+func (nopSender) EditText(chatID int64, messageID int, text string) error { return nil }
+
+// recordingGuard records the tool calls passed to it as "chatID:callID".
+type recordingGuard struct {
+	mu      sync.Mutex
+	started []string
+}
+
+func (g *recordingGuard) PermissionAsked(chatID int64, p Permission) {}
+
+func (g *recordingGuard) ToolStarted(chatID int64, sessionID, callID, tool string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.started = append(g.started, fmt.Sprintf("%d:%s", chatID, callID))
+}
+
+func toolEvent(t *testing.T, sessionID, partID, status string) SSEEvent {
+	t.Helper()
+	var props PartProperties
+	props.Part.ID = partID
+	props.Part.SessionID = sessionID
+	props.Part.Type = "tool"
+	props.Part.Tool = "bash"
+	props.Part.CallID = sessionID + "-call"
+	props.Part.State.Status = status
+	raw, err := json.Marshal(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SSEEvent{Type: "message.part.updated", Properties: raw}
+}
+
+func TestToolPartsPerChat(t *testing.T) {
+	guard := &recordingGuard{}
+	sm := NewStreamManager("http://localhost", nopSender{}, StreamOptions{Guard: guard})
+	sm.RegisterSession("ses_a", 1, 10)
+	sm.RegisterSession("ses_b", 2, 20)
+
+	want := []string{"1:ses_a-call", "2:ses_b-call"}
+	check := func(when string) {
+		t.Helper()
+		guard.mu.Lock()
+		defer guard.mu.Unlock()
+		if !slices.Equal(guard.started, want) {
+			t.Errorf("ToolStarted calls %s = %v, want %v", when, guard.started, want)
+		}
+	}
+
+	// Both chats stream a part with the same ID; each call is new to its chat.
+	sm.handleEvent(toolEvent(t, "ses_a", "prt_1", "running"))
+	sm.handleEvent(toolEvent(t, "ses_b", "prt_1", "running"))
+	sm.handleEvent(toolEvent(t, "ses_b", "prt_1", "running"))
+	check("while running")
+
+	// Completing chat 1 must not forget the part chat 2 already saw.
+	sm.markComplete(1, "ses_a")
+	sm.handleEvent(toolEvent(t, "ses_b", "prt_1", "running"))
+	check("after another chat completed")
+}
+
+// finalSender records the edits of a stream, and formats final edits of
+// text with backticks.
+type finalSender struct {
+	nopSender
+	mu    sync.Mutex
+	edits []string
+}
+
+func (s *finalSender) EditFinalText(chatID int64, messageID int, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.edits = append(s.edits, text)
+	return nil
+}
+
+func (s *finalSender) FormatsFinal(chatID int64, text string) bool {
+	return strings.Contains(text, "`")
+}
+
+func TestFinalEditOfUnchangedReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		wantEdit bool
+	}{
+		{"nothing to format", "All tests pass.", false},
+		{"formatted", "Run `go test`.", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &finalSender{}
+			sm := NewStreamManager("http://localhost", sender, StreamOptions{})
+			sm.RegisterSession("ses", 1, 10)
+			// The last intermediate edit showed the whole reply already.
+			sm.mu.Lock()
+			sm.chatToText[1] = tt.text
+			sm.mu.Unlock()
+			sm.markSent(1, tt.text)
+
+			sm.markComplete(1, "ses")
+			if gotEdit := len(sender.edits) > 0; gotEdit != tt.wantEdit {
+				t.Errorf("final edits = %q, want an edit: %v", sender.edits, tt.wantEdit)
+			}
+		})
+	}
+}
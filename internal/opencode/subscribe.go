@@ -0,0 +1,75 @@
+package opencode
+
+import (
+	"sort"
+	"time"
+)
+
+// Subscribe streams sessionID's replies to chatID as well as to the chat
+// that sent the prompt, e.g. for a shared viewer or an audit channel. Each
+// subscriber gets messages of its own with its own edit throttle, but no
+// completion, guard or usage hooks. A chat follows one session at a time,
+// and is skipped while it streams a reply of its own.
+func (sm *StreamManager) Subscribe(sessionID string, chatID int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.unsubscribeLocked(chatID)
+	if sm.subscribers[sessionID] == nil {
+		sm.subscribers[sessionID] = make(map[int64]bool)
+	}
+	sm.subscribers[sessionID][chatID] = true
+	sm.subscribed[chatID] = sessionID
+}
+
+// Unsubscribe stops streaming the session chatID follows to it; a reply
+// it is showing stops where it is.
+func (sm *StreamManager) Unsubscribe(chatID int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.unsubscribeLocked(chatID)
+}
+
+func (sm *StreamManager) unsubscribeLocked(chatID int64) {
+	sessionID, ok := sm.subscribed[chatID]
+	if !ok {
+		return
+	}
+	delete(sm.subscribed, chatID)
+	delete(sm.subscribers[sessionID], chatID)
+	if len(sm.subscribers[sessionID]) == 0 {
+		delete(sm.subscribers, sessionID)
+	}
+	if sm.viewing[chatID] {
+		sm.clearStreamLocked(chatID)
+	}
+}
+
+// Subscribers returns the chats following sessionID, in ID order.
+func (sm *StreamManager) Subscribers(sessionID string) []int64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	chats := make([]int64, 0, len(sm.subscribers[sessionID]))
+	for chatID := range sm.subscribers[sessionID] {
+		chats = append(chats, chatID)
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i] < chats[j] })
+	return chats
+}
+
+// fanOut returns the chats an event of sessionID goes to: chatID, which
+// registered the session, and then its subscribers that aren't streaming
+// a reply of their own.
+func (sm *StreamManager) fanOut(chatID int64, sessionID string) []int64 {
+	chats := []int64{chatID}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for c := range sm.subscribers[sessionID] {
+		if _, streaming := sm.chatToText[c]; c == chatID || (streaming && !sm.viewing[c]) {
+			continue
+		}
+		sm.viewing[c] = true
+		sm.lastActivity[c] = time.Now()
+		chats = append(chats, c)
+	}
+	return chats
+}
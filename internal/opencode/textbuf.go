@@ -0,0 +1,128 @@
+package opencode
+
+import (
+	"log"
+	"unicode/utf8"
+
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+)
+
+// omittedMarker joins the head and tail of a capped reply.
+const omittedMarker = "\n\n[...]\n\n"
+
+// textLimit is the most reply text kept in memory per chat: a head long
+// enough to fill the Telegram message plus an equally long tail.
+func (sm *StreamManager) textLimit() int {
+	return 2*sm.maxMessageLen + len(omittedMarker)
+}
+
+// capText keeps the first and last maxMessageLen bytes of text, cut on
+// rune boundaries.
+func (sm *StreamManager) capText(text string) string {
+	if len(text) <= sm.textLimit() {
+		return text
+	}
+	head := sm.maxMessageLen
+	for head > 0 && !utf8.RuneStart(text[head]) {
+		head--
+	}
+	tail := len(text) - sm.maxMessageLen
+	for tail < len(text) && !utf8.RuneStart(text[tail]) {
+		tail++
+	}
+	return text[:head] + omittedMarker + text[tail:]
+}
+
+// setText replaces the chat's reply with a full snapshot. A snapshot over
+// the in-memory cap goes to the archive and only head+tail are kept;
+// subscribers keep only head+tail. ANSI escapes, which tool output quoted
+// in the reply brings along, are stripped first.
+func (sm *StreamManager) setText(chatID int64, sessionID, text string) {
+	text = tgtext.StripANSI(text)
+	chunked := sm.modeFor(chatID) == ModeChunked
+	sm.mu.Lock()
+	delete(sm.ansiTail, chatID)
+	if chunked {
+		// Only what hasn't gone out as a chunk is kept; it goes out before
+		// it grows long.
+		sm.chatToText[chatID] = text[min(sm.chunkSent[chatID], len(text)):]
+		sm.mu.Unlock()
+		return
+	}
+	over := len(text) > sm.textLimit() && sm.archive != nil && !sm.viewing[chatID]
+	messageID := sm.chatToMsgID[chatID]
+	sm.chatToText[chatID] = sm.capText(text)
+	if over {
+		sm.spilled[chatID] = true
+	} else {
+		delete(sm.spilled, chatID)
+	}
+	sm.mu.Unlock()
+
+	if over {
+		sm.archiveText(chatID, messageID, sessionID, text, false)
+	}
+}
+
+// appendText adds a delta to the chat's reply. The first time the reply
+// outgrows the cap its full text is saved to the archive; later deltas
+// are appended there. ANSI escapes are stripped as in setText; one cut off
+// at the end of the delta waits for the rest in the next.
+func (sm *StreamManager) appendText(chatID int64, sessionID, delta string) {
+	chunked := sm.modeFor(chatID) == ModeChunked
+	sm.mu.Lock()
+	delta = sm.ansiTail[chatID] + delta
+	cut := tgtext.ANSITail(delta)
+	if cut < len(delta) {
+		sm.ansiTail[chatID] = delta[cut:]
+	} else {
+		delete(sm.ansiTail, chatID)
+	}
+	delta = tgtext.StripANSI(delta[:cut])
+	if chunked {
+		sm.chatToText[chatID] += delta
+		sm.mu.Unlock()
+		return
+	}
+	messageID := sm.chatToMsgID[chatID]
+	spilled := sm.spilled[chatID]
+	text := sm.chatToText[chatID] + delta
+	spill := !spilled && len(text) > sm.textLimit() && sm.archive != nil && !sm.viewing[chatID]
+	sm.chatToText[chatID] = sm.capText(text)
+	if spill {
+		sm.spilled[chatID] = true
+	}
+	sm.mu.Unlock()
+
+	switch {
+	case spilled:
+		sm.archiveText(chatID, messageID, sessionID, delta, true)
+	case spill:
+		sm.archiveText(chatID, messageID, sessionID, text, false)
+	}
+}
+
+func (sm *StreamManager) archiveText(chatID int64, messageID int, sessionID, text string, appendOnly bool) {
+	var err error
+	if appendOnly {
+		err = sm.archive.AppendMessageText(chatID, messageID, sessionID, text)
+	} else {
+		err = sm.archive.SaveMessageText(chatID, messageID, sessionID, text)
+	}
+	if err != nil {
+		log.Printf("[StreamManager] Failed to spill reply text for chat %d: %v", chatID, err)
+	}
+}
+
+// CurrentText returns the reply being streamed to chatID. complete is
+// false when the text was capped and the full version is in the archive.
+// A session the chat subscribed to doesn't count.
+func (sm *StreamManager) CurrentText(chatID int64) (text string, streaming, complete bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if sm.viewing[chatID] {
+		return "", false, true
+	}
+	text, streaming = sm.chatToText[chatID]
+	return text, streaming, !sm.spilled[chatID]
+}
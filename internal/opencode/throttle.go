@@ -0,0 +1,90 @@
+package opencode
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Bounds of a chat's edit interval, which adapts between them: it starts
+// at StreamOptions.EditThrottle, grows when Telegram pushes back and
+// shrinks again, to as little as half the start, while the chat's stream
+// is quiet.
+const (
+	minEditThrottle = 250 * time.Millisecond
+	maxEditThrottle = time.Minute
+)
+
+// EditFeedback is told how intermediate edits fared, by senders that
+// queue them and send them later (see telegram.SendQueue); errors of edits
+// sent at once are seen by the stream itself.
+type EditFeedback interface {
+	// EditRateLimited reports an edit refused with 429 Too Many
+	// Requests; retryAfter is how long Telegram asked to wait, or 0.
+	EditRateLimited(chatID int64, retryAfter time.Duration)
+	// EditsQueuedUp reports an edit replaced by a newer one before it
+	// was sent.
+	EditsQueuedUp(chatID int64)
+}
+
+// throttleLocked is the chat's current edit interval. Callers hold sm.mu.
+func (sm *StreamManager) throttleLocked(chatID int64) time.Duration {
+	if d, ok := sm.throttle[chatID]; ok {
+		return d
+	}
+	return sm.editThrottle
+}
+
+// EditRateLimited doubles the chat's edit interval, to at least what
+// Telegram asked for.
+func (sm *StreamManager) EditRateLimited(chatID int64, retryAfter time.Duration) {
+	sm.mu.Lock()
+	d := min(max(2*sm.throttleLocked(chatID), retryAfter), maxEditThrottle)
+	sm.throttle[chatID] = d
+	sm.mu.Unlock()
+	log.Printf("[StreamManager] Chat %d is rate limited, editing every %s", chatID, d)
+}
+
+// EditsQueuedUp lengthens the chat's edit interval by half.
+func (sm *StreamManager) EditsQueuedUp(chatID int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.throttle[chatID] = min(sm.throttleLocked(chatID)*3/2, maxEditThrottle)
+}
+
+// noteEditLocked shortens the chat's edit interval by a quarter when its
+// stream was quiet for two intervals before this edit, so short bursts of
+// text show up sooner. Callers hold sm.mu.
+func (sm *StreamManager) noteEditLocked(chatID int64, now time.Time) {
+	last := sm.lastEdit[chatID]
+	d := sm.throttleLocked(chatID)
+	if !last.IsZero() && now.Sub(last) >= 2*d {
+		sm.throttle[chatID] = max(d*3/4, sm.editThrottle/2, minEditThrottle)
+	}
+	sm.lastEdit[chatID] = now
+}
+
+// retryAfterRe finds the wait in a 429 error from the Telegram client.
+var retryAfterRe = regexp.MustCompile(`retry_after (\d+)`)
+
+// rateLimited reports whether err is a 429 Too Many Requests, and how long
+// it asks to wait if it says.
+func rateLimited(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	m := retryAfterRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	seconds, _ := strconv.Atoi(m[1])
+	return time.Duration(seconds) * time.Second, true
+}
+
+// backOff slows the chat's edits down if err is a 429.
+func (sm *StreamManager) backOff(chatID int64, err error) {
+	if retryAfter, ok := rateLimited(err); ok {
+		sm.EditRateLimited(chatID, retryAfter)
+	}
+}
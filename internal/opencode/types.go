@@ -1,6 +1,9 @@
 package opencode
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // OCSession represents an OpenCode session from the API.
 type OCSession struct {
@@ -15,39 +18,132 @@ type OCSession struct {
 		Deletions int `json:"deletions"`
 		Files     int `json:"files"`
 	} `json:"summary"`
+	// Revert is set while the session is rolled back to a message.
+	Revert *struct {
+		MessageID string `json:"messageID"`
+	} `json:"revert,omitempty"`
 	Time struct {
 		Created int64 `json:"created"`
 		Updated int64 `json:"updated"`
 	} `json:"time"`
 }
 
+// Worktree is a git worktree OpenCode checked out for a project, on a
+// branch of its own.
+type Worktree struct {
+	Name      string `json:"name"`
+	Branch    string `json:"branch"`
+	Directory string `json:"directory"`
+}
+
+// FileNode is an entry of a project directory, as returned by /file.
+type FileNode struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"` // relative to the project directory
+	Type    string `json:"type"` // "file" or "directory"
+	Ignored bool   `json:"ignored"`
+}
+
+// FileContent is a project file, as returned by /file/content.
+type FileContent struct {
+	Type    string `json:"type"` // "text" or "binary"
+	Content string `json:"content"`
+}
+
+// FileDiff is one file's change in a session, as returned by
+// /session/:id/diff.
+type FileDiff struct {
+	File      string `json:"file"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+}
+
 // APIMessage represents a message from the OpenCode API.
 type APIMessage struct {
 	Info struct {
 		ID        string `json:"id"`
 		SessionID string `json:"sessionID"`
 		Role      string `json:"role"`
-		Tokens    struct {
-			Total  int `json:"total"`
-			Input  int `json:"input"`
-			Output int `json:"output"`
-		} `json:"tokens"`
-		Cost   float64 `json:"cost"`
-		Finish string  `json:"finish"`
+		// ProviderID and ModelID are set on assistant messages.
+		ProviderID string  `json:"providerID"`
+		ModelID    string  `json:"modelID"`
+		Tokens     Tokens  `json:"tokens"`
+		Cost       float64 `json:"cost"`
+		Finish     string  `json:"finish"`
+		Summary    bool    `json:"summary"` // the assistant message Summarize wrote
+		Time       struct {
+			Created int64 `json:"created"` // Unix milliseconds
+		} `json:"time"`
 	} `json:"info"`
 	Parts []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+		ID    string    `json:"id"`
+		Type  string    `json:"type"`
+		Text  string    `json:"text"`
+		Tool  string    `json:"tool"` // tool parts: the tool's name
+		State ToolState `json:"state"`
 	} `json:"parts"`
 }
 
+// ToolState is the state of a tool part.
+type ToolState struct {
+	Status string          `json:"status"` // pending, running, completed or error
+	Title  string          `json:"title"`  // what the call did, e.g. the command or file
+	Input  json.RawMessage `json:"input"`
+	Output string          `json:"output"` // completed calls: what the tool returned
+	Error  string          `json:"error"`  // failed calls: why
+	// Metadata.Output is what a running bash call printed so far.
+	Metadata struct {
+		Output string `json:"output"`
+	} `json:"metadata"`
+}
+
+// Tokens is the token usage of an assistant message.
+type Tokens struct {
+	Total     int `json:"total"`
+	Input     int `json:"input"`
+	Output    int `json:"output"`
+	Reasoning int `json:"reasoning"`
+	Cache     struct {
+		Read  int `json:"read"`
+		Write int `json:"write"`
+	} `json:"cache"`
+}
+
+// Context is how much of the model's context window the message took up:
+// everything it read, cached or not, plus what it wrote.
+func (t Tokens) Context() int {
+	return t.Input + t.Output + t.Reasoning + t.Cache.Read + t.Cache.Write
+}
+
 // Message is a simplified message for display.
 type Message struct {
 	ID      string
 	Role    string
 	Content string
 	Tokens  int
+	Context int // context window the message took up, see Tokens.Context
 	Cost    float64
+	Created time.Time // zero if the server didn't say
+	Summary bool      // written by Summarize
+	// Provider and model that wrote an assistant message
+	ProviderID string
+	ModelID    string
+	Tools      []ToolCall // tool calls of an assistant message, in order
+}
+
+// ToolCall is a tool part of an assistant message, for display.
+type ToolCall struct {
+	ID     string // part ID
+	Tool   string
+	Status string
+	Title  string
+	Input  json.RawMessage
+	Output string
+	Error  string
+	// Progress is what a running bash call printed so far.
+	Progress string
 }
 
 // SSEEvent represents a Server-Sent Events message.
@@ -59,18 +155,73 @@ type SSEEvent struct {
 // PartProperties represents a message.part.updated event.
 type PartProperties struct {
 	Part struct {
-		ID        string `json:"id"`
-		SessionID string `json:"sessionID"`
-		MessageID string `json:"messageID"`
-		Type      string `json:"type"`
-		Text      string `json:"text"`
-		Time      struct {
+		ID        string   `json:"id"`
+		SessionID string   `json:"sessionID"`
+		MessageID string   `json:"messageID"`
+		Type      string   `json:"type"`
+		Text      string   `json:"text"`
+		Tool      string   `json:"tool"`   // tool parts: the tool's name
+		CallID    string   `json:"callID"` // tool parts: the call, shared with its Permission
+		Files     []string `json:"files"`  // patch parts: the files the step changed
+		State     struct {
+			Status string `json:"status"` // tool parts: pending, running, completed or error
+			Input  struct {
+				FilePath string `json:"filePath"` // edit and write tools: the file
+			} `json:"input"`
+		} `json:"state"`
+		Time struct {
 			Start int64 `json:"start"`
 			End   int64 `json:"end"`
 		} `json:"time"`
 	} `json:"part"`
 }
 
+// Permission is a tool call waiting for approval, from a
+// permission.updated event.
+type Permission struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"` // the tool asking, e.g. "bash", "edit", "webfetch"
+	SessionID string `json:"sessionID"`
+	MessageID string `json:"messageID"`
+	CallID    string `json:"callID"`
+	Title     string `json:"title"` // what the tool wants to do, e.g. the command
+}
+
+// Answers to a Permission.
+const (
+	PermissionOnce   = "once"
+	PermissionAlways = "always"
+	PermissionReject = "reject"
+)
+
+// Question is the agent asking the user something mid-run, from a
+// question.asked event. The run waits until it is answered or rejected.
+type Question struct {
+	ID        string         `json:"id"`
+	SessionID string         `json:"sessionID"`
+	Questions []QuestionItem `json:"questions"`
+}
+
+// QuestionItem is one of a Question's questions.
+type QuestionItem struct {
+	Question string           `json:"question"`
+	Header   string           `json:"header"` // a short label for it
+	Options  []QuestionOption `json:"options"`
+	Multiple bool             `json:"multiple"` // more than one option may be picked
+}
+
+// QuestionOption is a suggested answer to a QuestionItem.
+type QuestionOption struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+}
+
+// FileWatcherProperties represents a file.watcher.updated event.
+type FileWatcherProperties struct {
+	File  string `json:"file"`
+	Event string `json:"event"` // add, change or unlink
+}
+
 // DeltaProperties represents a message.part.delta event.
 type DeltaProperties struct {
 	SessionID string `json:"sessionID"`
@@ -87,7 +238,12 @@ type MessageProperties struct {
 		SessionID string `json:"sessionID"`
 		Role      string `json:"role"`
 		Finish    string `json:"finish"`
-		Time      struct {
+		// ProviderID, ModelID and Tokens are set on assistant messages;
+		// Tokens once a step of the reply has finished.
+		ProviderID string `json:"providerID"`
+		ModelID    string `json:"modelID"`
+		Tokens     Tokens `json:"tokens"`
+		Time       struct {
 			Created   int64 `json:"created"`
 			Completed int64 `json:"completed"`
 		} `json:"time"`
@@ -132,4 +288,8 @@ type Model struct {
 	ID         string `json:"id"`
 	ProviderID string `json:"providerID"`
 	Name       string `json:"name"`
+	Limit      struct {
+		Context int `json:"context"` // context window in tokens, 0 if unknown
+		Output  int `json:"output"`
+	} `json:"limit"`
 }
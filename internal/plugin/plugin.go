@@ -0,0 +1,145 @@
+// Package plugin runs the extra commands of PLUGINS_FILE: programs that
+// get the chat's context as JSON on stdin, or HTTP hooks it is POSTed to,
+// whose output the bot posts back to the chat.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// maxOutput is how much of a plugin's output is read; a chat message
+// holds far less anyway.
+const maxOutput = 64 << 10
+
+// Request is what a plugin is told about the command it serves.
+type Request struct {
+	Command   string `json:"command"`              // without the slash
+	Args      string `json:"args"`                 // the text after the command
+	ChatID    int64  `json:"chat_id"`              // the chat it was sent in
+	UserID    int64  `json:"user_id"`              // who sent it
+	Username  string `json:"username,omitempty"`   // their @username, without the @
+	Admin     bool   `json:"admin"`                // whether the chat is an admin's
+	SessionID string `json:"session_id,omitempty"` // the chat's OpenCode session
+	Directory string `json:"directory,omitempty"`  // the directory the session works in
+	Agent     string `json:"agent,omitempty"`
+	Model     string `json:"model,omitempty"` // "provider/model"
+}
+
+// Plugin serves a command.
+type Plugin interface {
+	// Run returns the text to post back for req.
+	Run(ctx context.Context, req Request) (string, error)
+}
+
+// Exec returns a plugin running argv, without a shell, with the request
+// as JSON on stdin. What it writes to stdout is the reply; a non-zero
+// exit fails with the last line it wrote to stderr.
+func Exec(argv []string, timeout time.Duration) Plugin {
+	return execPlugin{argv: argv, timeout: timeout}
+}
+
+// Hook returns a plugin POSTing the request as JSON to url, with token as
+// a bearer token if set. The response body is the reply, or its "text"
+// field when it is JSON.
+func Hook(url, token string, timeout time.Duration) Plugin {
+	return hookPlugin{url: url, token: token, http: &http.Client{Timeout: timeout}}
+}
+
+type execPlugin struct {
+	argv    []string
+	timeout time.Duration
+}
+
+func (p execPlugin) Run(ctx context.Context, req Request) (string, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.argv[0], p.argv[1:]...)
+	var stdout, stderr limitedBuffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(in), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("%s: timed out after %s", p.argv[0], p.timeout)
+		}
+		if line := lastLine(stderr.String()); line != "" {
+			return "", fmt.Errorf("%s: %w: %s", p.argv[0], err, line)
+		}
+		return "", fmt.Errorf("%s: %w", p.argv[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+type hookPlugin struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func (p hookPlugin) Run(ctx context.Context, req Request) (string, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.http.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("call hook: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutput))
+	if resp.StatusCode/100 != 2 {
+		if line := lastLine(string(body)); line != "" {
+			return "", fmt.Errorf("hook returned %s: %s", resp.Status, line)
+		}
+		return "", fmt.Errorf("hook returned %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var res struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return "", fmt.Errorf("decode hook response: %w", err)
+		}
+		return strings.TrimSpace(res.Text), nil
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// limitedBuffer keeps the first maxOutput bytes written to it and drops
+// the rest, so a chatty plugin can't fill the bot's memory.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxOutput - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// lastLine returns the last non-empty line of s, which is where programs
+// usually say what went wrong.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
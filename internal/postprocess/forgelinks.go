@@ -0,0 +1,80 @@
+package postprocess
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// localPath matches an absolute path standing on its own, after a space,
+// bracket or quote, with an optional :line. The path stops at characters
+// that rarely end up in file names but often around them.
+var localPath = regexp.MustCompile("(^|[\\s(\\[`'\"])(/[^\\s:`'\"()\\[\\]<>]+)(?::(\\d+))?")
+
+// forgeLinks turns paths of files in FORGE_REPOS checkouts into links to
+// them on the forge.
+type forgeLinks struct {
+	base   string
+	blob   string // path between the repository and the branch
+	repos  map[string]string
+	branch func(repo string) string
+}
+
+func newForgeLinks(opts Options) (Processor, error) {
+	f := forgeLinks{base: opts.ForgeURL, blob: "/src/branch/", repos: opts.ForgeRepos, branch: opts.Branch}
+	if strings.EqualFold(opts.ForgeType, "gitlab") {
+		f.blob = "/-/blob/"
+		if f.base == "" {
+			f.base = "https://gitlab.com"
+		}
+	}
+	if f.base == "" || len(f.repos) == 0 {
+		return nil, errors.New("set FORGE_URL and FORGE_REPOS, to know where files are on the forge")
+	}
+	f.base = strings.TrimRight(f.base, "/")
+	return f, nil
+}
+
+// Process links the paths of a final reply only: links make a streamed
+// reply jump around as they appear.
+func (f forgeLinks) Process(text string, final bool) string {
+	if !final {
+		return text
+	}
+	return localPath.ReplaceAllStringFunc(text, func(m string) string {
+		sub := localPath.FindStringSubmatch(m)
+		lead, path, line := sub[1], sub[2], sub[3]
+		// A sentence may end right after the path.
+		trimmed := strings.TrimRight(path, ".,;!?")
+		url := f.link(trimmed, line)
+		if url == "" {
+			return m
+		}
+		return lead + url + path[len(trimmed):]
+	})
+}
+
+// link returns the forge URL of the file at path, at line if set, or ""
+// if it is in none of the repositories or their branch isn't known.
+func (f forgeLinks) link(path, line string) string {
+	path = filepath.Clean(path)
+	best, repo := "", ""
+	for root, r := range f.repos {
+		if (path == root || strings.HasPrefix(path, root+"/")) && len(root) > len(best) {
+			best, repo = root, r
+		}
+	}
+	if repo == "" || path == best || f.branch == nil {
+		return ""
+	}
+	branch := f.branch(repo)
+	if branch == "" {
+		return ""
+	}
+	url := f.base + "/" + repo + f.blob + branch + "/" + strings.TrimPrefix(path, best+"/")
+	if line != "" {
+		url += "#L" + line
+	}
+	return url
+}
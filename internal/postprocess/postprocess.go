@@ -0,0 +1,136 @@
+// Package postprocess rewrites OpenCode's replies before a chat sees them.
+// A Pipeline chains processors picked by name from POSTPROCESS; the
+// built-in ones tidy whitespace and link local file paths to the forge,
+// and more can be added with Register.
+package postprocess
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Processor rewrites reply text. final is false for the text streamed so
+// far, which is processed again on every edit, so a processor that is
+// costly or only makes sense on a whole reply should leave it alone.
+type Processor interface {
+	Process(text string, final bool) string
+}
+
+// Func adapts a function to a Processor.
+type Func func(text string, final bool) string
+
+func (f Func) Process(text string, final bool) string {
+	return f(text, final)
+}
+
+// Options is what processors may be built from.
+type Options struct {
+	ForgeType  string            // "gitea", "forgejo" or "gitlab"
+	ForgeURL   string            // base URL of the forge
+	ForgeRepos map[string]string // absolute directory -> "owner/repo" on the forge
+	// Branch returns the branch links into repo point at, or "" if it
+	// isn't known yet. It must not block.
+	Branch func(repo string) string
+}
+
+// Builder builds a processor, or says why it can't with these options.
+type Builder func(opts Options) (Processor, error)
+
+var (
+	mu       sync.RWMutex
+	builders = map[string]Builder{
+		"trim":       func(Options) (Processor, error) { return Func(trim), nil },
+		"blanklines": func(Options) (Processor, error) { return Func(collapseBlankLines), nil },
+		"forgelinks": newForgeLinks,
+	}
+)
+
+// Register makes a processor available to POSTPROCESS under name. It
+// panics if the name is taken, like registering a flag twice.
+func Register(name string, build Builder) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := builders[name]; ok {
+		panic("postprocess: " + name + " is registered twice")
+	}
+	builders[name] = build
+}
+
+// Names returns the registered processors in order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return namesLocked()
+}
+
+// Pipeline runs processors one after the other.
+type Pipeline []Processor
+
+// New builds the pipeline of the named processors, in order.
+func New(names []string, opts Options) (Pipeline, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	var p Pipeline
+	for _, name := range names {
+		build, ok := builders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown processor %q (have %s)", name, strings.Join(namesLocked(), ", "))
+		}
+		proc, err := build(opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		p = append(p, proc)
+	}
+	return p, nil
+}
+
+func namesLocked() []string {
+	names := make([]string, 0, len(builders))
+	for name := range builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProcessText runs text through the pipeline. The chat ID is there to
+// satisfy opencode.TextProcessor; processors treat all chats alike.
+func (p Pipeline) ProcessText(chatID int64, text string, final bool) string {
+	for _, proc := range p {
+		text = proc.Process(text, final)
+	}
+	return text
+}
+
+// trim drops the whitespace OpenCode leaves at the end of lines and of the
+// reply.
+func trim(text string, final bool) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+// collapseBlankLines leaves at most one blank line between paragraphs,
+// except inside code blocks, whose spacing may matter.
+func collapseBlankLines(text string, final bool) string {
+	lines := strings.Split(text, "\n")
+	out := lines[:0]
+	inCode, blank := false, false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		isBlank := strings.TrimSpace(line) == ""
+		if isBlank && blank && !inCode {
+			continue
+		}
+		blank = isBlank
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,155 @@
+// Package scheduler runs named periodic maintenance jobs with jitter and
+// panic recovery.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/errreport"
+)
+
+// Job is a named task run every Interval. Each run is delayed by a random
+// amount up to Jitter so replicas and jobs don't fire in lockstep.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Jitter   time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// JobStatus reports the history of a registered job.
+type JobStatus struct {
+	Name     string
+	Interval time.Duration
+	Runs     int
+	Failures int
+	LastRun  time.Time
+	LastErr  string
+}
+
+// Scheduler owns the registered jobs and their goroutines.
+type Scheduler struct {
+	mu     sync.Mutex
+	jobs   []Job
+	status map[string]*JobStatus
+	wg     sync.WaitGroup
+}
+
+// New creates an empty scheduler.
+func New() *Scheduler {
+	return &Scheduler{status: make(map[string]*JobStatus)}
+}
+
+// Register adds a job. Jobs must be registered before Start.
+func (s *Scheduler) Register(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, job)
+	s.status[job.Name] = &JobStatus{Name: job.Name, Interval: job.Interval}
+}
+
+// Start launches one goroutine per job; they stop when ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	jobs := append([]Job(nil), s.jobs...)
+	s.mu.Unlock()
+
+	for _, job := range jobs {
+		job := job
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, job)
+		}()
+		log.Printf("[scheduler] Registered job %q every %s", job.Name, job.Interval)
+	}
+}
+
+// Wait blocks until all job goroutines have exited.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Status returns a snapshot of every job, sorted by name.
+func (s *Scheduler) Status() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]JobStatus, 0, len(s.status))
+	for _, st := range s.status {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if job.Jitter > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(rand.Int63n(int64(job.Jitter)))):
+			}
+		}
+		s.record(job.Name, s.runOnce(ctx, job))
+	}
+}
+
+// RunNow executes a registered job immediately on the caller's goroutine.
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	s.mu.Lock()
+	var job *Job
+	for i := range s.jobs {
+		if s.jobs[i].Name == name {
+			job = &s.jobs[i]
+			break
+		}
+	}
+	s.mu.Unlock()
+	if job == nil {
+		return fmt.Errorf("unknown job %q", name)
+	}
+	err := s.runOnce(ctx, *job)
+	s.record(name, err)
+	return err
+}
+
+// runOnce runs the job, converting a panic into an error so one bad run
+// doesn't take down the process or stop future runs.
+func (s *Scheduler) runOnce(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errreport.CapturePanic(r, errreport.Fields{"job": job.Name})
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+func (s *Scheduler) record(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status[name]
+	st.Runs++
+	st.LastRun = time.Now()
+	st.LastErr = ""
+	if err != nil {
+		st.Failures++
+		st.LastErr = err.Error()
+		log.Printf("[scheduler] Job %q failed: %v", name, err)
+	}
+}
@@ -0,0 +1,326 @@
+package store
+
+import (
+	"log"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/metrics"
+)
+
+var (
+	queryDuration = metrics.NewHistogram("openkh_store_query_duration_seconds",
+		"Duration of store calls by method.", []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}, "method")
+	slowQueries = metrics.NewCounter("openkh_store_slow_queries_total",
+		"Store calls slower than the slow-query threshold.", "method")
+)
+
+// QueryStat summarises the calls made to one store method.
+type QueryStat struct {
+	Method string
+	Calls  uint64
+	Avg    time.Duration
+	Max    time.Duration
+	Slow   uint64
+}
+
+// QueryStats returns per-method call statistics since startup.
+func QueryStats() []QueryStat {
+	var stats []QueryStat
+	for _, s := range queryDuration.Snapshot() {
+		method := s.Labels[0]
+		stat := QueryStat{
+			Method: method,
+			Calls:  s.Count,
+			Max:    time.Duration(s.Max * float64(time.Second)),
+			Slow:   uint64(slowQueries.Value(method)),
+		}
+		if s.Count > 0 {
+			stat.Avg = time.Duration(s.Sum / float64(s.Count) * float64(time.Second))
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// Instrument wraps s so every call is timed and calls slower than slow
+// are logged. Optional capabilities (RateLimiter) are preserved.
+func Instrument(s Store, slow time.Duration) Store {
+	i := &instrumented{next: s, slow: slow}
+	if rl, ok := s.(RateLimiter); ok {
+		return &instrumentedLimiter{instrumented: i, limiter: rl}
+	}
+	return i
+}
+
+type instrumented struct {
+	next Store
+	slow time.Duration
+}
+
+func (i *instrumented) observe(method string, start time.Time) {
+	d := time.Since(start)
+	queryDuration.Observe(d.Seconds(), method)
+	if i.slow > 0 && d >= i.slow {
+		slowQueries.Inc(method)
+		log.Printf("[store] Slow query: %s took %s", method, d.Round(time.Microsecond))
+	}
+}
+
+func (i *instrumented) GetSession(chatID int64) (Session, error) {
+	defer i.observe("GetSession", time.Now())
+	return i.next.GetSession(chatID)
+}
+
+func (i *instrumented) SetSession(s Session) error {
+	defer i.observe("SetSession", time.Now())
+	return i.next.SetSession(s)
+}
+
+func (i *instrumented) DeleteSession(chatID int64) error {
+	defer i.observe("DeleteSession", time.Now())
+	return i.next.DeleteSession(chatID)
+}
+
+func (i *instrumented) IncrementCount(chatID int64) error {
+	defer i.observe("IncrementCount", time.Now())
+	return i.next.IncrementCount(chatID)
+}
+
+func (i *instrumented) ListAll() ([]Session, error) {
+	defer i.observe("ListAll", time.Now())
+	return i.next.ListAll()
+}
+
+func (i *instrumented) DeleteAll() error {
+	defer i.observe("DeleteAll", time.Now())
+	return i.next.DeleteAll()
+}
+
+func (i *instrumented) SetPendingAction(a PendingAction) error {
+	defer i.observe("SetPendingAction", time.Now())
+	return i.next.SetPendingAction(a)
+}
+
+func (i *instrumented) GetPendingAction(chatID int64) (PendingAction, error) {
+	defer i.observe("GetPendingAction", time.Now())
+	return i.next.GetPendingAction(chatID)
+}
+
+func (i *instrumented) DeletePendingAction(chatID int64) error {
+	defer i.observe("DeletePendingAction", time.Now())
+	return i.next.DeletePendingAction(chatID)
+}
+
+func (i *instrumented) DeleteExpiredPendingActions() (int, error) {
+	defer i.observe("DeleteExpiredPendingActions", time.Now())
+	return i.next.DeleteExpiredPendingActions()
+}
+
+func (i *instrumented) AcquireLease(l Lease) (bool, error) {
+	defer i.observe("AcquireLease", time.Now())
+	return i.next.AcquireLease(l)
+}
+
+func (i *instrumented) GetLease(sessionID string) (Lease, error) {
+	defer i.observe("GetLease", time.Now())
+	return i.next.GetLease(sessionID)
+}
+
+func (i *instrumented) DeleteLease(sessionID, owner string) error {
+	defer i.observe("DeleteLease", time.Now())
+	return i.next.DeleteLease(sessionID, owner)
+}
+
+func (i *instrumented) DeleteExpiredLeases(before time.Time) (int, error) {
+	defer i.observe("DeleteExpiredLeases", time.Now())
+	return i.next.DeleteExpiredLeases(before)
+}
+
+func (i *instrumented) SaveMessageText(chatID int64, messageID int, sessionID, text string) error {
+	defer i.observe("SaveMessageText", time.Now())
+	return i.next.SaveMessageText(chatID, messageID, sessionID, text)
+}
+
+func (i *instrumented) AppendMessageText(chatID int64, messageID int, sessionID, chunk string) error {
+	defer i.observe("AppendMessageText", time.Now())
+	return i.next.AppendMessageText(chatID, messageID, sessionID, chunk)
+}
+
+func (i *instrumented) GetMessageText(chatID int64) (CachedMessage, error) {
+	defer i.observe("GetMessageText", time.Now())
+	return i.next.GetMessageText(chatID)
+}
+
+func (i *instrumented) GetChatSetting(chatID int64, key string) (string, error) {
+	defer i.observe("GetChatSetting", time.Now())
+	return i.next.GetChatSetting(chatID, key)
+}
+
+func (i *instrumented) SetChatSetting(chatID int64, key, value string) error {
+	defer i.observe("SetChatSetting", time.Now())
+	return i.next.SetChatSetting(chatID, key, value)
+}
+
+func (i *instrumented) SaveSnapshot(s Snapshot) error {
+	defer i.observe("SaveSnapshot", time.Now())
+	return i.next.SaveSnapshot(s)
+}
+
+func (i *instrumented) GetSnapshot(sessionID, name string) (Snapshot, error) {
+	defer i.observe("GetSnapshot", time.Now())
+	return i.next.GetSnapshot(sessionID, name)
+}
+
+func (i *instrumented) ListSnapshots(sessionID string) ([]Snapshot, error) {
+	defer i.observe("ListSnapshots", time.Now())
+	return i.next.ListSnapshots(sessionID)
+}
+
+func (i *instrumented) TrackMessage(chatID int64, messageID int) error {
+	defer i.observe("TrackMessage", time.Now())
+	return i.next.TrackMessage(chatID, messageID)
+}
+
+func (i *instrumented) ListTrackedMessages(chatID int64) ([]TrackedMessage, error) {
+	defer i.observe("ListTrackedMessages", time.Now())
+	return i.next.ListTrackedMessages(chatID)
+}
+
+func (i *instrumented) UntrackMessages(chatID int64, messageIDs []int) error {
+	defer i.observe("UntrackMessages", time.Now())
+	return i.next.UntrackMessages(chatID, messageIDs)
+}
+
+func (i *instrumented) DeleteTrackedMessagesBefore(before time.Time) (int, error) {
+	defer i.observe("DeleteTrackedMessagesBefore", time.Now())
+	return i.next.DeleteTrackedMessagesBefore(before)
+}
+
+func (i *instrumented) SaveBookmark(b Bookmark) error {
+	defer i.observe("SaveBookmark", time.Now())
+	return i.next.SaveBookmark(b)
+}
+
+func (i *instrumented) GetBookmark(chatID int64, messageID int) (Bookmark, error) {
+	defer i.observe("GetBookmark", time.Now())
+	return i.next.GetBookmark(chatID, messageID)
+}
+
+func (i *instrumented) ListBookmarks(chatID int64) ([]Bookmark, error) {
+	defer i.observe("ListBookmarks", time.Now())
+	return i.next.ListBookmarks(chatID)
+}
+
+func (i *instrumented) DeleteBookmark(chatID int64, messageID int) error {
+	defer i.observe("DeleteBookmark", time.Now())
+	return i.next.DeleteBookmark(chatID, messageID)
+}
+
+func (i *instrumented) SaveGrant(g AccessGrant) error {
+	defer i.observe("SaveGrant", time.Now())
+	return i.next.SaveGrant(g)
+}
+
+func (i *instrumented) GetGrant(chatID int64) (AccessGrant, error) {
+	defer i.observe("GetGrant", time.Now())
+	return i.next.GetGrant(chatID)
+}
+
+func (i *instrumented) ListGrants() ([]AccessGrant, error) {
+	defer i.observe("ListGrants", time.Now())
+	return i.next.ListGrants()
+}
+
+func (i *instrumented) DeleteGrant(chatID int64) error {
+	defer i.observe("DeleteGrant", time.Now())
+	return i.next.DeleteGrant(chatID)
+}
+
+func (i *instrumented) SaveTemplate(t SessionTemplate) error {
+	defer i.observe("SaveTemplate", time.Now())
+	return i.next.SaveTemplate(t)
+}
+
+func (i *instrumented) GetTemplate(name string) (SessionTemplate, error) {
+	defer i.observe("GetTemplate", time.Now())
+	return i.next.GetTemplate(name)
+}
+
+func (i *instrumented) ListTemplates() ([]SessionTemplate, error) {
+	defer i.observe("ListTemplates", time.Now())
+	return i.next.ListTemplates()
+}
+
+func (i *instrumented) DeleteTemplate(name string) error {
+	defer i.observe("DeleteTemplate", time.Now())
+	return i.next.DeleteTemplate(name)
+}
+
+func (i *instrumented) AddUsage(u Usage) error {
+	defer i.observe("AddUsage", time.Now())
+	return i.next.AddUsage(u)
+}
+
+func (i *instrumented) ListUsage(chatID int64, day string) ([]Usage, error) {
+	defer i.observe("ListUsage", time.Now())
+	return i.next.ListUsage(chatID, day)
+}
+
+func (i *instrumented) DeleteUsageBefore(day string) (int, error) {
+	defer i.observe("DeleteUsageBefore", time.Now())
+	return i.next.DeleteUsageBefore(day)
+}
+
+func (i *instrumented) ArchiveSession(a ArchivedSession) error {
+	defer i.observe("ArchiveSession", time.Now())
+	return i.next.ArchiveSession(a)
+}
+
+func (i *instrumented) ListArchivedSessions() ([]ArchivedSession, error) {
+	defer i.observe("ListArchivedSessions", time.Now())
+	return i.next.ListArchivedSessions()
+}
+
+func (i *instrumented) UnarchiveSession(sessionID string) error {
+	defer i.observe("UnarchiveSession", time.Now())
+	return i.next.UnarchiveSession(sessionID)
+}
+
+func (i *instrumented) MarkUpdate(updateID int64, expiresAt time.Time) (bool, error) {
+	defer i.observe("MarkUpdate", time.Now())
+	return i.next.MarkUpdate(updateID, expiresAt)
+}
+
+func (i *instrumented) DeleteExpiredUpdates(before time.Time) (int, error) {
+	defer i.observe("DeleteExpiredUpdates", time.Now())
+	return i.next.DeleteExpiredUpdates(before)
+}
+
+func (i *instrumented) SaveOutboxPrompt(p OutboxPrompt) error {
+	defer i.observe("SaveOutboxPrompt", time.Now())
+	return i.next.SaveOutboxPrompt(p)
+}
+
+func (i *instrumented) ListOutbox() ([]OutboxPrompt, error) {
+	defer i.observe("ListOutbox", time.Now())
+	return i.next.ListOutbox()
+}
+
+func (i *instrumented) DeleteOutboxPrompt(chatID int64, promptID int) (bool, error) {
+	defer i.observe("DeleteOutboxPrompt", time.Now())
+	return i.next.DeleteOutboxPrompt(chatID, promptID)
+}
+
+func (i *instrumented) Close() error {
+	return i.next.Close()
+}
+
+type instrumentedLimiter struct {
+	*instrumented
+	limiter RateLimiter
+}
+
+func (i *instrumentedLimiter) AllowRate(chatID int64, window time.Duration) (bool, error) {
+	defer i.observe("AllowRate", time.Now())
+	return i.limiter.AllowRate(chatID, window)
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultOwner identifies this process when INSTANCE_ID is not set.
+func DefaultOwner() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "openkh"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// LeaseManager claims stream ownership of sessions for one replica. Held
+// leases are cached and only written back once half the TTL has passed,
+// so checking ownership on every SSE event stays cheap.
+type LeaseManager struct {
+	store Store
+	owner string
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	held    map[string]Lease     // by session ID
+	skip    map[string]time.Time // sessions not worth re-checking before then
+	stopped bool
+}
+
+// NewLeaseManager creates a manager claiming leases as owner.
+func NewLeaseManager(s Store, owner string, ttl time.Duration) *LeaseManager {
+	if owner == "" {
+		owner = DefaultOwner()
+	}
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &LeaseManager{
+		store: s,
+		owner: owner,
+		ttl:   ttl,
+		held:  make(map[string]Lease),
+		skip:  make(map[string]time.Time),
+	}
+}
+
+// Owner returns the ID this replica claims leases under.
+func (m *LeaseManager) Owner() string {
+	return m.owner
+}
+
+// Claim takes or renews the lease on sessionID for the given chat message.
+// It returns false while another replica holds a live lease. Store errors
+// count as success so a store blip doesn't interrupt streaming.
+func (m *LeaseManager) Claim(sessionID string, chatID int64, messageID int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return false
+	}
+	now := time.Now()
+	cur, held := m.held[sessionID]
+	if held && cur.ChatID == chatID && cur.MessageID == messageID && cur.ExpiresAt.Sub(now) > m.ttl/2 {
+		return true
+	}
+
+	l := Lease{SessionID: sessionID, ChatID: chatID, MessageID: messageID, Owner: m.owner, ExpiresAt: now.Add(m.ttl)}
+	ok, err := m.store.AcquireLease(l)
+	if err != nil {
+		log.Printf("[leases] Failed to claim session %s: %v", sessionID, err)
+		return true
+	}
+	if !ok {
+		if held {
+			log.Printf("[leases] Lost session %s to another replica", sessionID)
+		}
+		delete(m.held, sessionID)
+		return false
+	}
+	m.held[sessionID] = l
+	delete(m.skip, sessionID)
+	return true
+}
+
+// Adopt takes over a session whose owner let its lease expire (crashed or
+// handed off during a deploy) and returns the chat message to continue.
+func (m *LeaseManager) Adopt(sessionID string) (chatID int64, messageID int, ok bool) {
+	m.mu.Lock()
+	retry, skipped := m.skip[sessionID]
+	stopped := m.stopped
+	m.mu.Unlock()
+	if stopped || (skipped && time.Now().Before(retry)) {
+		return 0, 0, false
+	}
+
+	l, err := m.store.GetLease(sessionID)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Printf("[leases] Failed to look up session %s: %v", sessionID, err)
+		}
+		m.skipUntil(sessionID, time.Now().Add(m.ttl))
+		return 0, 0, false
+	}
+	if l.Owner != m.owner && l.ExpiresAt.After(time.Now()) {
+		m.skipUntil(sessionID, l.ExpiresAt)
+		return 0, 0, false
+	}
+	if !m.Claim(sessionID, l.ChatID, l.MessageID) {
+		return 0, 0, false
+	}
+	log.Printf("[leases] Adopted session %s (chat %d) from %s", sessionID, l.ChatID, l.Owner)
+	return l.ChatID, l.MessageID, true
+}
+
+// Release drops the lease once the session's reply is complete.
+func (m *LeaseManager) Release(sessionID string) {
+	m.mu.Lock()
+	_, held := m.held[sessionID]
+	delete(m.held, sessionID)
+	m.mu.Unlock()
+	if !held {
+		return
+	}
+	if err := m.store.DeleteLease(sessionID, m.owner); err != nil {
+		log.Printf("[leases] Failed to release session %s: %v", sessionID, err)
+	}
+}
+
+// Handoff expires every held lease so another replica can adopt the
+// streams immediately, and stops claiming new ones. Call it on shutdown.
+func (m *LeaseManager) Handoff() {
+	m.mu.Lock()
+	m.stopped = true
+	held := m.held
+	m.held = make(map[string]Lease)
+	m.mu.Unlock()
+
+	now := time.Now()
+	for _, l := range held {
+		l.ExpiresAt = now
+		if _, err := m.store.AcquireLease(l); err != nil {
+			log.Printf("[leases] Failed to hand off session %s: %v", l.SessionID, err)
+		}
+	}
+	if len(held) > 0 {
+		log.Printf("[leases] Handed off %d session(s)", len(held))
+	}
+}
+
+func (m *LeaseManager) skipUntil(sessionID string, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, until := range m.skip {
+		if time.Now().After(until) {
+			delete(m.skip, id)
+		}
+	}
+	m.skip[sessionID] = t
+}
@@ -0,0 +1,612 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an ephemeral Store backed by a map. It mirrors the
+// SQLite semantics: upserts replace the whole row, updates of missing
+// rows are no-ops, and ListAll orders by LastUsed descending.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[int64]Session
+	pending  map[int64]PendingAction
+	leases   map[string]Lease
+	messages map[int64]CachedMessage
+	settings map[int64]map[string]string
+	snaps    map[string]map[string]Snapshot // by session ID, then name
+	tracked  map[int64]map[int]time.Time    // sent time by chat, then message ID
+	marks    map[int64]map[int]Bookmark     // by chat, then message ID
+	grants   map[int64]AccessGrant
+	tmpls    map[string]SessionTemplate
+	usage    map[usageID]Usage
+	archived map[string]ArchivedSession
+	updates  map[int64]time.Time            // expiry by update ID
+	outbox   map[int64]map[int]OutboxPrompt // by chat, then prompt ID
+}
+
+type usageID struct {
+	chatID    int64
+	day       string
+	sessionID string
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[int64]Session),
+		pending:  make(map[int64]PendingAction),
+		leases:   make(map[string]Lease),
+		messages: make(map[int64]CachedMessage),
+		settings: make(map[int64]map[string]string),
+		snaps:    make(map[string]map[string]Snapshot),
+		tracked:  make(map[int64]map[int]time.Time),
+		marks:    make(map[int64]map[int]Bookmark),
+		grants:   make(map[int64]AccessGrant),
+		tmpls:    make(map[string]SessionTemplate),
+		usage:    make(map[usageID]Usage),
+		archived: make(map[string]ArchivedSession),
+		updates:  make(map[int64]time.Time),
+		outbox:   make(map[int64]map[int]OutboxPrompt),
+	}
+}
+
+// GetSession retrieves the session for a chat ID.
+func (m *MemoryStore) GetSession(chatID int64) (Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[chatID]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+	return s, nil
+}
+
+// SetSession upserts a session mapping.
+func (m *MemoryStore) SetSession(s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ChatID] = s
+	return nil
+}
+
+// DeleteSession removes a session by chat ID.
+func (m *MemoryStore) DeleteSession(chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, chatID)
+	return nil
+}
+
+// IncrementCount increments the message count and updates last_used.
+func (m *MemoryStore) IncrementCount(chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[chatID]
+	if !ok {
+		return nil
+	}
+	s.MessageCount++
+	s.LastUsed = time.Now().UTC()
+	m.sessions[chatID] = s
+	return nil
+}
+
+// ListAll returns all sessions ordered by last_used descending.
+func (m *MemoryStore) ListAll() ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var sessions []Session
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsed.Equal(sessions[j].LastUsed) {
+			return sessions[i].LastUsed.After(sessions[j].LastUsed)
+		}
+		return sessions[i].ChatID < sessions[j].ChatID
+	})
+	return sessions, nil
+}
+
+// DeleteAll removes all sessions (for purge).
+func (m *MemoryStore) DeleteAll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions = make(map[int64]Session)
+	return nil
+}
+
+// SetPendingAction stores the chat's pending action, replacing any previous one.
+func (m *MemoryStore) SetPendingAction(a PendingAction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[a.ChatID] = a
+	return nil
+}
+
+// GetPendingAction returns the chat's unexpired pending action.
+func (m *MemoryStore) GetPendingAction(chatID int64) (PendingAction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.pending[chatID]
+	if !ok || !a.ExpiresAt.After(time.Now()) {
+		return PendingAction{}, ErrNotFound
+	}
+	return a, nil
+}
+
+// DeletePendingAction removes the chat's pending action.
+func (m *MemoryStore) DeletePendingAction(chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, chatID)
+	return nil
+}
+
+// DeleteExpiredPendingActions removes expired pending actions and returns how many were deleted.
+func (m *MemoryStore) DeleteExpiredPendingActions() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	now := time.Now()
+	for chatID, a := range m.pending {
+		if !a.ExpiresAt.After(now) {
+			delete(m.pending, chatID)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// AcquireLease inserts or renews l unless another owner holds an unexpired
+// lease on the session. It reports whether l was stored.
+func (m *MemoryStore) AcquireLease(l Lease) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cur, ok := m.leases[l.SessionID]; ok && cur.Owner != l.Owner && cur.ExpiresAt.After(time.Now()) {
+		return false, nil
+	}
+	m.leases[l.SessionID] = l
+	return true, nil
+}
+
+// GetLease returns the session's lease, expired or not.
+func (m *MemoryStore) GetLease(sessionID string) (Lease, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	l, ok := m.leases[sessionID]
+	if !ok {
+		return Lease{}, ErrNotFound
+	}
+	return l, nil
+}
+
+// DeleteLease removes the session's lease if owner holds it.
+func (m *MemoryStore) DeleteLease(sessionID, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.leases[sessionID]; ok && l.Owner == owner {
+		delete(m.leases, sessionID)
+	}
+	return nil
+}
+
+// DeleteExpiredLeases removes leases that expired before the given time
+// and returns how many were deleted.
+func (m *MemoryStore) DeleteExpiredLeases(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, l := range m.leases {
+		if !l.ExpiresAt.After(before) {
+			delete(m.leases, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// SaveMessageText replaces the chat's cached reply text.
+func (m *MemoryStore) SaveMessageText(chatID int64, messageID int, sessionID, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[chatID] = CachedMessage{ChatID: chatID, MessageID: messageID, SessionID: sessionID, Text: text, UpdatedAt: time.Now().UTC()}
+	return nil
+}
+
+// AppendMessageText appends chunk to the chat's cached reply text,
+// creating the entry if needed.
+func (m *MemoryStore) AppendMessageText(chatID int64, messageID int, sessionID, chunk string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	text := m.messages[chatID].Text + chunk
+	m.messages[chatID] = CachedMessage{ChatID: chatID, MessageID: messageID, SessionID: sessionID, Text: text, UpdatedAt: time.Now().UTC()}
+	return nil
+}
+
+// GetMessageText returns the chat's cached reply.
+func (m *MemoryStore) GetMessageText(chatID int64) (CachedMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msg, ok := m.messages[chatID]
+	if !ok {
+		return CachedMessage{}, ErrNotFound
+	}
+	return msg, nil
+}
+
+// GetChatSetting returns the chat's value for key.
+func (m *MemoryStore) GetChatSetting(chatID int64, key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.settings[chatID][key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// SetChatSetting stores the chat's value for key; an empty value deletes it.
+func (m *MemoryStore) SetChatSetting(chatID int64, key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if value == "" {
+		delete(m.settings[chatID], key)
+		return nil
+	}
+	if m.settings[chatID] == nil {
+		m.settings[chatID] = make(map[string]string)
+	}
+	m.settings[chatID][key] = value
+	return nil
+}
+
+// SaveSnapshot stores s, replacing a snapshot of the same name.
+func (m *MemoryStore) SaveSnapshot(s Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.snaps[s.SessionID] == nil {
+		m.snaps[s.SessionID] = make(map[string]Snapshot)
+	}
+	m.snaps[s.SessionID][s.Name] = s
+	return nil
+}
+
+// GetSnapshot returns the session's snapshot called name.
+func (m *MemoryStore) GetSnapshot(sessionID, name string) (Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.snaps[sessionID][name]
+	if !ok {
+		return Snapshot{}, ErrNotFound
+	}
+	return s, nil
+}
+
+// ListSnapshots returns the session's snapshots, oldest first.
+func (m *MemoryStore) ListSnapshots(sessionID string) ([]Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]Snapshot, 0, len(m.snaps[sessionID]))
+	for _, s := range m.snaps[sessionID] {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// TrackMessage records a transient bot message for later cleanup.
+func (m *MemoryStore) TrackMessage(chatID int64, messageID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tracked[chatID] == nil {
+		m.tracked[chatID] = make(map[int]time.Time)
+	}
+	m.tracked[chatID][messageID] = time.Now().UTC()
+	return nil
+}
+
+// ListTrackedMessages returns the chat's tracked messages, oldest first.
+func (m *MemoryStore) ListTrackedMessages(chatID int64) ([]TrackedMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]TrackedMessage, 0, len(m.tracked[chatID]))
+	for id, sent := range m.tracked[chatID] {
+		out = append(out, TrackedMessage{ChatID: chatID, MessageID: id, SentAt: sent})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SentAt.Before(out[j].SentAt) })
+	return out, nil
+}
+
+// UntrackMessages forgets the given messages of a chat.
+func (m *MemoryStore) UntrackMessages(chatID int64, messageIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range messageIDs {
+		delete(m.tracked[chatID], id)
+	}
+	return nil
+}
+
+// DeleteTrackedMessagesBefore forgets messages sent before the given time.
+func (m *MemoryStore) DeleteTrackedMessagesBefore(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for chatID, msgs := range m.tracked {
+		for id, sent := range msgs {
+			if sent.Before(before) {
+				delete(msgs, id)
+				n++
+			}
+		}
+		if len(msgs) == 0 {
+			delete(m.tracked, chatID)
+		}
+	}
+	return n, nil
+}
+
+// SaveBookmark stores b, replacing an existing bookmark of the same message.
+func (m *MemoryStore) SaveBookmark(b Bookmark) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.marks[b.ChatID] == nil {
+		m.marks[b.ChatID] = make(map[int]Bookmark)
+	}
+	m.marks[b.ChatID][b.MessageID] = b
+	return nil
+}
+
+// GetBookmark returns the chat's bookmark of messageID.
+func (m *MemoryStore) GetBookmark(chatID int64, messageID int) (Bookmark, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.marks[chatID][messageID]
+	if !ok {
+		return Bookmark{}, ErrNotFound
+	}
+	return b, nil
+}
+
+// ListBookmarks returns the chat's bookmarks, newest first.
+func (m *MemoryStore) ListBookmarks(chatID int64) ([]Bookmark, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]Bookmark, 0, len(m.marks[chatID]))
+	for _, b := range m.marks[chatID] {
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+// DeleteBookmark removes the chat's bookmark of messageID.
+func (m *MemoryStore) DeleteBookmark(chatID int64, messageID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.marks[chatID], messageID)
+	return nil
+}
+
+// SaveGrant stores g, replacing an existing grant for the chat.
+func (m *MemoryStore) SaveGrant(g AccessGrant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.grants[g.ChatID] = g
+	return nil
+}
+
+// GetGrant returns the chat's grant, expired or not.
+func (m *MemoryStore) GetGrant(chatID int64) (AccessGrant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	g, ok := m.grants[chatID]
+	if !ok {
+		return AccessGrant{}, ErrNotFound
+	}
+	return g, nil
+}
+
+// ListGrants returns every grant, soonest to expire first.
+func (m *MemoryStore) ListGrants() ([]AccessGrant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]AccessGrant, 0, len(m.grants))
+	for _, g := range m.grants {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ExpiresAt.Before(out[j].ExpiresAt) })
+	return out, nil
+}
+
+// DeleteGrant removes the chat's grant.
+func (m *MemoryStore) DeleteGrant(chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.grants, chatID)
+	return nil
+}
+
+// SaveTemplate stores t, replacing a template of the same name.
+func (m *MemoryStore) SaveTemplate(t SessionTemplate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tmpls[t.Name] = t
+	return nil
+}
+
+// GetTemplate returns the template called name.
+func (m *MemoryStore) GetTemplate(name string) (SessionTemplate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.tmpls[name]
+	if !ok {
+		return SessionTemplate{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// ListTemplates returns every template, by name.
+func (m *MemoryStore) ListTemplates() ([]SessionTemplate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]SessionTemplate, 0, len(m.tmpls))
+	for _, t := range m.tmpls {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// DeleteTemplate removes the template called name.
+func (m *MemoryStore) DeleteTemplate(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tmpls, name)
+	return nil
+}
+
+// AddUsage adds u's counts to those of its chat, day and session.
+func (m *MemoryStore) AddUsage(u Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := usageID{u.ChatID, u.Day, u.SessionID}
+	cur := m.usage[k]
+	u.Prompts += cur.Prompts
+	u.Tokens += cur.Tokens
+	u.Cost += cur.Cost
+	m.usage[k] = u
+	return nil
+}
+
+// ListUsage returns the chat's usage on day, one entry per session.
+func (m *MemoryStore) ListUsage(chatID int64, day string) ([]Usage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []Usage
+	for k, u := range m.usage {
+		if k.chatID == chatID && k.day == day {
+			out = append(out, u)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SessionID < out[j].SessionID })
+	return out, nil
+}
+
+// DeleteUsageBefore removes the usage of days before day.
+func (m *MemoryStore) DeleteUsageBefore(day string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for k := range m.usage {
+		if k.day < day {
+			delete(m.usage, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ArchiveSession stores a, replacing an earlier archival of the session.
+func (m *MemoryStore) ArchiveSession(a ArchivedSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.archived[a.SessionID] = a
+	return nil
+}
+
+// ListArchivedSessions returns every archived session, most recently
+// archived first.
+func (m *MemoryStore) ListArchivedSessions() ([]ArchivedSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]ArchivedSession, 0, len(m.archived))
+	for _, a := range m.archived {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ArchivedAt.After(out[j].ArchivedAt) })
+	return out, nil
+}
+
+// UnarchiveSession removes the session from the archive.
+func (m *MemoryStore) UnarchiveSession(sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.archived, sessionID)
+	return nil
+}
+
+// MarkUpdate records the update until expiresAt and reports whether it
+// wasn't recorded already, or only with an expiry that has passed.
+func (m *MemoryStore) MarkUpdate(updateID int64, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if exp, ok := m.updates[updateID]; ok && !exp.Before(time.Now()) {
+		return false, nil
+	}
+	m.updates[updateID] = expiresAt
+	return true, nil
+}
+
+// SaveOutboxPrompt stores p, replacing an earlier copy of the prompt.
+func (m *MemoryStore) SaveOutboxPrompt(p OutboxPrompt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.outbox[p.ChatID] == nil {
+		m.outbox[p.ChatID] = make(map[int]OutboxPrompt)
+	}
+	m.outbox[p.ChatID][p.PromptID] = p
+	return nil
+}
+
+// ListOutbox returns every prompt in the outbox, oldest first.
+func (m *MemoryStore) ListOutbox() ([]OutboxPrompt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []OutboxPrompt
+	for _, prompts := range m.outbox {
+		for _, p := range prompts {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].QueuedAt.Before(out[j].QueuedAt) })
+	return out, nil
+}
+
+// DeleteOutboxPrompt removes the prompt from the outbox and reports
+// whether it was there.
+func (m *MemoryStore) DeleteOutboxPrompt(chatID int64, promptID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.outbox[chatID][promptID]; !ok {
+		return false, nil
+	}
+	delete(m.outbox[chatID], promptID)
+	if len(m.outbox[chatID]) == 0 {
+		delete(m.outbox, chatID)
+	}
+	return true, nil
+}
+
+// DeleteExpiredUpdates removes the updates that expired before before.
+func (m *MemoryStore) DeleteExpiredUpdates(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, exp := range m.updates {
+		if exp.Before(before) {
+			delete(m.updates, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Close is a no-op for the memory store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
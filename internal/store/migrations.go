@@ -0,0 +1,349 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration is one numbered, reversible schema change. Versions must be
+// contiguous and never edited once released; add a new migration instead.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create user_sessions",
+		up: `
+			CREATE TABLE IF NOT EXISTS user_sessions (
+				chat_id       INTEGER PRIMARY KEY,
+				session_id    TEXT NOT NULL,
+				title         TEXT,
+				message_count INTEGER DEFAULT 0,
+				created_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
+				last_used     DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+		down: `DROP TABLE user_sessions`,
+	},
+	{
+		version: 2,
+		name:    "add user_sessions.agent",
+		up:      `ALTER TABLE user_sessions ADD COLUMN agent TEXT DEFAULT ''`,
+		down:    `ALTER TABLE user_sessions DROP COLUMN agent`,
+	},
+	{
+		version: 3,
+		name:    "add user_sessions model columns",
+		up: `
+			ALTER TABLE user_sessions ADD COLUMN model_provider TEXT DEFAULT '';
+			ALTER TABLE user_sessions ADD COLUMN model_id TEXT DEFAULT ''`,
+		down: `
+			ALTER TABLE user_sessions DROP COLUMN model_id;
+			ALTER TABLE user_sessions DROP COLUMN model_provider`,
+	},
+	{
+		version: 4,
+		name:    "create pending_actions",
+		up: `
+			CREATE TABLE pending_actions (
+				chat_id    INTEGER PRIMARY KEY,
+				type       TEXT NOT NULL,
+				payload    TEXT DEFAULT '',
+				expires_at DATETIME NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+		down: `DROP TABLE pending_actions`,
+	},
+	{
+		version: 5,
+		name:    "create stream_leases",
+		up: `
+			CREATE TABLE stream_leases (
+				session_id TEXT PRIMARY KEY,
+				chat_id    INTEGER NOT NULL,
+				message_id INTEGER NOT NULL DEFAULT 0,
+				owner      TEXT NOT NULL,
+				expires_at DATETIME NOT NULL
+			)`,
+		down: `DROP TABLE stream_leases`,
+	},
+	{
+		version: 6,
+		name:    "create message_cache",
+		up: `
+			CREATE TABLE message_cache (
+				chat_id    INTEGER PRIMARY KEY,
+				message_id INTEGER NOT NULL DEFAULT 0,
+				session_id TEXT NOT NULL DEFAULT '',
+				text       TEXT NOT NULL DEFAULT '',
+				updated_at DATETIME NOT NULL
+			)`,
+		down: `DROP TABLE message_cache`,
+	},
+	{
+		version: 7,
+		name:    "create chat_settings",
+		up: `
+			CREATE TABLE chat_settings (
+				chat_id    INTEGER NOT NULL,
+				key        TEXT NOT NULL,
+				value      TEXT NOT NULL,
+				updated_at DATETIME NOT NULL,
+				PRIMARY KEY (chat_id, key)
+			)`,
+		down: `DROP TABLE chat_settings`,
+	},
+	{
+		version: 8,
+		name:    "create snapshots",
+		up: `
+			CREATE TABLE snapshots (
+				session_id TEXT NOT NULL,
+				name       TEXT NOT NULL,
+				message_id TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				PRIMARY KEY (session_id, name)
+			)`,
+		down: `DROP TABLE snapshots`,
+	},
+	{
+		version: 9,
+		name:    "create tracked_messages",
+		up: `
+			CREATE TABLE tracked_messages (
+				chat_id    INTEGER NOT NULL,
+				message_id INTEGER NOT NULL,
+				sent_at    DATETIME NOT NULL,
+				PRIMARY KEY (chat_id, message_id)
+			)`,
+		down: `DROP TABLE tracked_messages`,
+	},
+	{
+		version: 10,
+		name:    "create bookmarks",
+		up: `
+			CREATE TABLE bookmarks (
+				chat_id    INTEGER NOT NULL,
+				message_id INTEGER NOT NULL,
+				session_id TEXT NOT NULL,
+				text       TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				PRIMARY KEY (chat_id, message_id)
+			)`,
+		down: `DROP TABLE bookmarks`,
+	},
+	{
+		version: 11,
+		name:    "create access grants",
+		up: `
+			CREATE TABLE access_grants (
+				chat_id    INTEGER PRIMARY KEY,
+				granted_by INTEGER NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL
+			)`,
+		down: `DROP TABLE access_grants`,
+	},
+	{
+		version: 12,
+		name:    "create session templates",
+		up: `
+			CREATE TABLE session_templates (
+				name       TEXT PRIMARY KEY,
+				agent      TEXT NOT NULL DEFAULT '',
+				model      TEXT NOT NULL DEFAULT '',
+				dir        TEXT NOT NULL DEFAULT '',
+				system     TEXT NOT NULL DEFAULT '',
+				updated_by INTEGER NOT NULL,
+				updated_at DATETIME NOT NULL
+			)`,
+		down: `DROP TABLE session_templates`,
+	},
+	{
+		version: 13,
+		name:    "create usage",
+		up: `
+			CREATE TABLE usage (
+				chat_id    INTEGER NOT NULL,
+				day        TEXT NOT NULL,
+				session_id TEXT NOT NULL,
+				prompts    INTEGER NOT NULL DEFAULT 0,
+				tokens     INTEGER NOT NULL DEFAULT 0,
+				cost       REAL NOT NULL DEFAULT 0,
+				PRIMARY KEY (chat_id, day, session_id)
+			)`,
+		down: `DROP TABLE usage`,
+	},
+	{
+		version: 14,
+		name:    "create archived sessions",
+		up: `
+			CREATE TABLE archived_sessions (
+				session_id  TEXT PRIMARY KEY,
+				title       TEXT NOT NULL DEFAULT '',
+				archived_at DATETIME NOT NULL
+			)`,
+		down: `DROP TABLE archived_sessions`,
+	},
+	{
+		version: 15,
+		name:    "create processed updates",
+		up: `
+			CREATE TABLE processed_updates (
+				update_id  INTEGER PRIMARY KEY,
+				expires_at DATETIME NOT NULL
+			)`,
+		down: `DROP TABLE processed_updates`,
+	},
+	{
+		version: 16,
+		name:    "create outbox",
+		up: `
+			CREATE TABLE outbox (
+				chat_id   INTEGER NOT NULL,
+				prompt_id INTEGER NOT NULL,
+				user_id   INTEGER NOT NULL,
+				text      TEXT NOT NULL,
+				queued_at DATETIME NOT NULL,
+				PRIMARY KEY (chat_id, prompt_id)
+			)`,
+		down: `DROP TABLE outbox`,
+	},
+}
+
+// migrate brings the schema up to the latest version, recording each
+// applied step in schema_version.
+func (db *DB) migrate() error {
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`); err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		if current, err = db.baselineLegacy(); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := db.apply(m, true); err != nil {
+			return err
+		}
+		log.Printf("[store] Applied migration %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+// MigrateDown reverts applied migrations until the schema is at version
+// target (0 reverts everything).
+func (db *DB) MigrateDown(target int) error {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > current || m.version <= target {
+			continue
+		}
+		if err := db.apply(m, false); err != nil {
+			return err
+		}
+		log.Printf("[store] Reverted migration %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+// SchemaVersion returns the highest applied migration version.
+func (db *DB) SchemaVersion() (int, error) {
+	var v sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&v); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return int(v.Int64), nil
+}
+
+func (db *DB) apply(m migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("migration %d: begin: %w", m.version, err)
+	}
+	defer tx.Rollback()
+
+	stmt, record := m.up, `INSERT INTO schema_version (version, name) VALUES (?, ?)`
+	args := []interface{}{m.version, m.name}
+	if !up {
+		stmt, record = m.down, `DELETE FROM schema_version WHERE version = ?`
+		args = args[:1]
+	}
+	if _, err := tx.Exec(stmt); err != nil {
+		return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+	}
+	if _, err := tx.Exec(record, args...); err != nil {
+		return fmt.Errorf("migration %d: record version: %w", m.version, err)
+	}
+	return tx.Commit()
+}
+
+// baselineLegacy handles databases created before schema_version existed
+// by inferring their version from the columns present, so the old
+// ALTER TABLE steps aren't re-run.
+func (db *DB) baselineLegacy() (int, error) {
+	cols, err := db.columns("user_sessions")
+	if err != nil {
+		return 0, err
+	}
+	if len(cols) == 0 {
+		return 0, nil
+	}
+	version := 1
+	if cols["agent"] {
+		version = 2
+		if cols["model_provider"] && cols["model_id"] {
+			version = 3
+		}
+	}
+	for _, m := range migrations {
+		if m.version > version {
+			break
+		}
+		if _, err := db.Exec(`INSERT INTO schema_version (version, name) VALUES (?, ?)`, m.version, m.name); err != nil {
+			return 0, fmt.Errorf("baseline schema version: %w", err)
+		}
+	}
+	log.Printf("[store] Baselined existing database at schema version %d", version)
+	return version, nil
+}
+
+func (db *DB) columns(table string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("inspect %s: %w", table, err)
+	}
+	defer rows.Close()
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
@@ -0,0 +1,35 @@
+package store
+
+// PrivateMessages wraps s so the message cache lives in process memory:
+// reply text is never written to the SQLite file or Redis, and so never
+// ends up in their backups. /export and bookmarks keep working until a
+// restart. Optional capabilities (RateLimiter) are preserved.
+func PrivateMessages(s Store) Store {
+	p := &privateMessages{Store: s, cache: NewMemory()}
+	if rl, ok := s.(RateLimiter); ok {
+		return &privateLimiter{privateMessages: p, RateLimiter: rl}
+	}
+	return p
+}
+
+type privateMessages struct {
+	Store
+	cache *MemoryStore
+}
+
+func (p *privateMessages) SaveMessageText(chatID int64, messageID int, sessionID, text string) error {
+	return p.cache.SaveMessageText(chatID, messageID, sessionID, text)
+}
+
+func (p *privateMessages) AppendMessageText(chatID int64, messageID int, sessionID, chunk string) error {
+	return p.cache.AppendMessageText(chatID, messageID, sessionID, chunk)
+}
+
+func (p *privateMessages) GetMessageText(chatID int64) (CachedMessage, error) {
+	return p.cache.GetMessageText(chatID)
+}
+
+type privateLimiter struct {
+	*privateMessages
+	RateLimiter
+}
@@ -0,0 +1,1004 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is implemented by backends that can share per-chat rate
+// limiting across bot replicas.
+type RateLimiter interface {
+	// AllowRate reports whether chatID may proceed, reserving the next
+	// window if so.
+	AllowRate(chatID int64, window time.Duration) (bool, error)
+}
+
+const (
+	redisPrefix      = "openkh:"
+	redisSessionKey  = redisPrefix + "session:"
+	redisSessionsSet = redisPrefix + "sessions" // sorted set of chat IDs scored by last_used
+	redisRateKey     = redisPrefix + "ratelimit:"
+	redisPendingKey  = redisPrefix + "pending:"
+	redisLeaseKey    = redisPrefix + "lease:"
+	redisMessageKey  = redisPrefix + "msgcache:"
+	redisSettingsKey = redisPrefix + "settings:"  // hash of key -> value per chat
+	redisSnapshotKey = redisPrefix + "snapshots:" // hash of name -> JSON snapshot per session
+	redisTrackedKey  = redisPrefix + "tracked:"   // sorted set of message IDs scored by send time per chat
+	redisBookmarkKey = redisPrefix + "bookmarks:" // hash of message ID -> JSON bookmark per chat
+	redisGrantsKey   = redisPrefix + "grants"     // hash of chat ID -> JSON access grant
+	redisTmplKey     = redisPrefix + "templates"  // hash of name -> JSON session template
+	redisUsageKey    = redisPrefix + "usage:"     // hash of "<session ID>:<count>" -> count per chat and day
+	redisArchiveKey  = redisPrefix + "archived"   // hash of session ID -> JSON archived session
+	redisUpdateKey   = redisPrefix + "update:"    // marker per processed update ID
+	redisOutboxKey   = redisPrefix + "outbox"     // hash of "<chat ID>:<prompt ID>" -> JSON outbox prompt
+	redisPoolSize    = 8
+	redisIOTimeout   = 5 * time.Second
+
+	// redisLeaseRetention keeps expired leases around so another replica
+	// can still adopt the stream; Redis drops them afterwards.
+	redisLeaseRetention = time.Hour
+
+	// redisTrackedRetention matches how long Telegram lets a bot delete
+	// its messages; older entries are useless.
+	redisTrackedRetention = 48 * time.Hour
+
+	// redisUsageRetention is how long a day's usage is kept; digests only
+	// look back a day or two.
+	redisUsageRetention = 31 * 24 * time.Hour
+)
+
+// RedisStore is a Store backed by Redis so several bot replicas can share
+// session mappings. Each session is a hash; a sorted set indexes them by
+// last_used for ListAll. Rate-limit and pending-action keys carry TTLs.
+type RedisStore struct {
+	addr     string
+	password string
+	db       int
+	pool     chan *redisConn
+}
+
+// NewRedis connects to the Redis server at rawURL
+// (redis://[:password@]host:port[/db]).
+func NewRedis(rawURL string) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis url scheme %q", u.Scheme)
+	}
+	r := &RedisStore{
+		addr: u.Host,
+		pool: make(chan *redisConn, redisPoolSize),
+	}
+	if _, _, err := net.SplitHostPort(r.addr); err != nil {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	if p := strings.TrimPrefix(u.Path, "/"); p != "" {
+		if r.db, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("invalid redis db %q", p)
+		}
+	}
+
+	// Fail fast if the server is unreachable.
+	if _, err := r.do("PING"); err != nil {
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+	return r, nil
+}
+
+var sessionFields = []string{
+	"chat_id", "session_id", "title", "agent", "model_provider", "model_id",
+	"message_count", "created_at", "last_used",
+}
+
+// GetSession retrieves the session for a chat ID.
+func (r *RedisStore) GetSession(chatID int64) (Session, error) {
+	reply, err := r.do(append([]string{"HMGET", sessionKey(chatID)}, sessionFields...)...)
+	if err != nil {
+		return Session{}, err
+	}
+	values, _ := reply.([]interface{})
+	if len(values) != len(sessionFields) || values[0] == nil {
+		return Session{}, ErrNotFound
+	}
+	str := func(i int) string {
+		s, _ := values[i].(string)
+		return s
+	}
+	s := Session{
+		ChatID:        chatID,
+		SessionID:     str(1),
+		Title:         str(2),
+		Agent:         str(3),
+		ModelProvider: str(4),
+		ModelID:       str(5),
+	}
+	s.MessageCount, _ = strconv.Atoi(str(6))
+	s.CreatedAt, _ = time.Parse(time.RFC3339Nano, str(7))
+	s.LastUsed, _ = time.Parse(time.RFC3339Nano, str(8))
+	return s, nil
+}
+
+// setSessionScript replaces the hash wholesale (matching INSERT OR
+// REPLACE) and updates the last_used index.
+const setSessionScript = `
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], unpack(ARGV, 3))
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+return 1`
+
+// SetSession upserts a session mapping.
+func (r *RedisStore) SetSession(s Session) error {
+	id := strconv.FormatInt(s.ChatID, 10)
+	args := []string{
+		"EVAL", setSessionScript, "2", sessionKey(s.ChatID), redisSessionsSet,
+		id, strconv.FormatInt(s.LastUsed.UnixNano(), 10),
+		"chat_id", id,
+		"session_id", s.SessionID,
+		"title", s.Title,
+		"agent", s.Agent,
+		"model_provider", s.ModelProvider,
+		"model_id", s.ModelID,
+		"message_count", strconv.Itoa(s.MessageCount),
+		"created_at", s.CreatedAt.Format(time.RFC3339Nano),
+		"last_used", s.LastUsed.Format(time.RFC3339Nano),
+	}
+	_, err := r.do(args...)
+	return err
+}
+
+// DeleteSession removes a session by chat ID.
+func (r *RedisStore) DeleteSession(chatID int64) error {
+	if _, err := r.do("DEL", sessionKey(chatID)); err != nil {
+		return err
+	}
+	_, err := r.do("ZREM", redisSessionsSet, strconv.FormatInt(chatID, 10))
+	return err
+}
+
+// incrementScript is a no-op for missing sessions, like the SQL UPDATE.
+const incrementScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then return 0 end
+redis.call('HINCRBY', KEYS[1], 'message_count', 1)
+redis.call('HSET', KEYS[1], 'last_used', ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return 1`
+
+// IncrementCount increments the message count and updates last_used.
+func (r *RedisStore) IncrementCount(chatID int64) error {
+	now := time.Now().UTC()
+	_, err := r.do("EVAL", incrementScript, "2", sessionKey(chatID), redisSessionsSet,
+		strconv.FormatInt(chatID, 10), now.Format(time.RFC3339Nano), strconv.FormatInt(now.UnixNano(), 10))
+	return err
+}
+
+// ListAll returns all sessions ordered by last_used descending.
+func (r *RedisStore) ListAll() ([]Session, error) {
+	reply, err := r.do("ZREVRANGE", redisSessionsSet, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	ids, _ := reply.([]interface{})
+	var sessions []Session
+	for _, raw := range ids {
+		idStr, _ := raw.(string)
+		chatID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		s, err := r.GetSession(chatID)
+		if err != nil {
+			// Index entry without a hash; skip like a bad row.
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+const deleteAllScript = `
+local ids = redis.call('ZRANGE', KEYS[1], 0, -1)
+for _, id in ipairs(ids) do redis.call('DEL', ARGV[1] .. id) end
+redis.call('DEL', KEYS[1])
+return #ids`
+
+// DeleteAll removes all sessions (for purge).
+func (r *RedisStore) DeleteAll() error {
+	_, err := r.do("EVAL", deleteAllScript, "1", redisSessionsSet, redisSessionKey)
+	return err
+}
+
+// AllowRate implements RateLimiter with a SET NX key that expires after
+// the window, so the limit is shared by every replica.
+func (r *RedisStore) AllowRate(chatID int64, window time.Duration) (bool, error) {
+	reply, err := r.do("SET", redisRateKey+strconv.FormatInt(chatID, 10), "1",
+		"PX", strconv.FormatInt(window.Milliseconds(), 10), "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// SetPendingAction stores the chat's pending action with a TTL matching
+// its expiry, so Redis drops it on its own.
+func (r *RedisStore) SetPendingAction(a PendingAction) error {
+	ttl := time.Until(a.ExpiresAt)
+	if ttl <= 0 {
+		return r.DeletePendingAction(a.ChatID)
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("SET", pendingKey(a.ChatID), string(data), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// GetPendingAction returns the chat's unexpired pending action.
+func (r *RedisStore) GetPendingAction(chatID int64) (PendingAction, error) {
+	reply, err := r.do("GET", pendingKey(chatID))
+	if err != nil {
+		return PendingAction{}, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return PendingAction{}, ErrNotFound
+	}
+	var a PendingAction
+	if err := json.Unmarshal([]byte(data), &a); err != nil {
+		return PendingAction{}, fmt.Errorf("decode pending action: %w", err)
+	}
+	return a, nil
+}
+
+// DeletePendingAction removes the chat's pending action.
+func (r *RedisStore) DeletePendingAction(chatID int64) error {
+	_, err := r.do("DEL", pendingKey(chatID))
+	return err
+}
+
+// DeleteExpiredPendingActions is a no-op: Redis expires the keys itself.
+func (r *RedisStore) DeleteExpiredPendingActions() (int, error) {
+	return 0, nil
+}
+
+// acquireLeaseScript stores the lease hash unless another owner's lease
+// has not yet expired. Expiry times are Unix milliseconds.
+const acquireLeaseScript = `
+local owner = redis.call('HGET', KEYS[1], 'owner')
+if owner and owner ~= ARGV[1] and tonumber(redis.call('HGET', KEYS[1], 'expires_at')) > tonumber(ARGV[2]) then
+  return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'owner', ARGV[1], 'chat_id', ARGV[3], 'message_id', ARGV[4], 'expires_at', ARGV[5])
+redis.call('PEXPIRE', KEYS[1], ARGV[6])
+return 1`
+
+// AcquireLease inserts or renews l unless another owner holds an unexpired
+// lease on the session. It reports whether l was stored.
+func (r *RedisStore) AcquireLease(l Lease) (bool, error) {
+	keep := time.Until(l.ExpiresAt) + redisLeaseRetention
+	reply, err := r.do("EVAL", acquireLeaseScript, "1", leaseKey(l.SessionID),
+		l.Owner,
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+		strconv.FormatInt(l.ChatID, 10),
+		strconv.Itoa(l.MessageID),
+		strconv.FormatInt(l.ExpiresAt.UnixMilli(), 10),
+		strconv.FormatInt(keep.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n == 1, nil
+}
+
+// GetLease returns the session's lease, expired or not.
+func (r *RedisStore) GetLease(sessionID string) (Lease, error) {
+	reply, err := r.do("HMGET", leaseKey(sessionID), "owner", "chat_id", "message_id", "expires_at")
+	if err != nil {
+		return Lease{}, err
+	}
+	values, _ := reply.([]interface{})
+	if len(values) != 4 || values[0] == nil {
+		return Lease{}, ErrNotFound
+	}
+	str := func(i int) string {
+		s, _ := values[i].(string)
+		return s
+	}
+	l := Lease{SessionID: sessionID, Owner: str(0)}
+	l.ChatID, _ = strconv.ParseInt(str(1), 10, 64)
+	l.MessageID, _ = strconv.Atoi(str(2))
+	ms, _ := strconv.ParseInt(str(3), 10, 64)
+	l.ExpiresAt = time.UnixMilli(ms)
+	return l, nil
+}
+
+const deleteLeaseScript = `
+if redis.call('HGET', KEYS[1], 'owner') == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+// DeleteLease removes the session's lease if owner holds it.
+func (r *RedisStore) DeleteLease(sessionID, owner string) error {
+	_, err := r.do("EVAL", deleteLeaseScript, "1", leaseKey(sessionID), owner)
+	return err
+}
+
+// DeleteExpiredLeases is a no-op: lease keys expire redisLeaseRetention
+// after the lease itself.
+func (r *RedisStore) DeleteExpiredLeases(before time.Time) (int, error) {
+	return 0, nil
+}
+
+// SaveMessageText replaces the chat's cached reply text.
+func (r *RedisStore) SaveMessageText(chatID int64, messageID int, sessionID, text string) error {
+	_, err := r.do("HSET", messageKey(chatID),
+		"message_id", strconv.Itoa(messageID),
+		"session_id", sessionID,
+		"text", text,
+		"updated_at", time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// appendMessageScript appends to the text field; hash fields have no
+// native APPEND.
+const appendMessageScript = `
+local text = redis.call('HGET', KEYS[1], 'text') or ''
+redis.call('HSET', KEYS[1], 'message_id', ARGV[1], 'session_id', ARGV[2], 'text', text .. ARGV[3], 'updated_at', ARGV[4])
+return 1`
+
+// AppendMessageText appends chunk to the chat's cached reply text,
+// creating the entry if needed.
+func (r *RedisStore) AppendMessageText(chatID int64, messageID int, sessionID, chunk string) error {
+	_, err := r.do("EVAL", appendMessageScript, "1", messageKey(chatID),
+		strconv.Itoa(messageID), sessionID, chunk, time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// GetMessageText returns the chat's cached reply.
+func (r *RedisStore) GetMessageText(chatID int64) (CachedMessage, error) {
+	reply, err := r.do("HMGET", messageKey(chatID), "message_id", "session_id", "text", "updated_at")
+	if err != nil {
+		return CachedMessage{}, err
+	}
+	values, _ := reply.([]interface{})
+	if len(values) != 4 || values[2] == nil {
+		return CachedMessage{}, ErrNotFound
+	}
+	str := func(i int) string {
+		s, _ := values[i].(string)
+		return s
+	}
+	m := CachedMessage{ChatID: chatID, SessionID: str(1), Text: str(2)}
+	m.MessageID, _ = strconv.Atoi(str(0))
+	m.UpdatedAt, _ = time.Parse(time.RFC3339Nano, str(3))
+	return m, nil
+}
+
+// GetChatSetting returns the chat's value for key.
+func (r *RedisStore) GetChatSetting(chatID int64, key string) (string, error) {
+	reply, err := r.do("HGET", redisSettingsKey+strconv.FormatInt(chatID, 10), key)
+	if err != nil {
+		return "", err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// SetChatSetting stores the chat's value for key; an empty value deletes it.
+func (r *RedisStore) SetChatSetting(chatID int64, key, value string) error {
+	k := redisSettingsKey + strconv.FormatInt(chatID, 10)
+	var err error
+	if value == "" {
+		_, err = r.do("HDEL", k, key)
+	} else {
+		_, err = r.do("HSET", k, key, value)
+	}
+	return err
+}
+
+// SaveSnapshot stores s, replacing a snapshot of the same name.
+func (r *RedisStore) SaveSnapshot(s Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("HSET", redisSnapshotKey+s.SessionID, s.Name, string(data))
+	return err
+}
+
+// GetSnapshot returns the session's snapshot called name.
+func (r *RedisStore) GetSnapshot(sessionID, name string) (Snapshot, error) {
+	reply, err := r.do("HGET", redisSnapshotKey+sessionID, name)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return Snapshot{}, ErrNotFound
+	}
+	var s Snapshot
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return Snapshot{}, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return s, nil
+}
+
+// ListSnapshots returns the session's snapshots, oldest first.
+func (r *RedisStore) ListSnapshots(sessionID string) ([]Snapshot, error) {
+	reply, err := r.do("HVALS", redisSnapshotKey+sessionID)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	out := make([]Snapshot, 0, len(values))
+	for _, v := range values {
+		data, _ := v.(string)
+		var s Snapshot
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			return nil, fmt.Errorf("decode snapshot: %w", err)
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// TrackMessage records a transient bot message for later cleanup. Entries
+// past redisTrackedRetention are trimmed on the way.
+func (r *RedisStore) TrackMessage(chatID int64, messageID int) error {
+	key := redisTrackedKey + strconv.FormatInt(chatID, 10)
+	now := time.Now()
+	if _, err := r.do("ZADD", key, strconv.FormatInt(now.Unix(), 10), strconv.Itoa(messageID)); err != nil {
+		return err
+	}
+	cutoff := now.Add(-redisTrackedRetention).Unix()
+	if _, err := r.do("ZREMRANGEBYSCORE", key, "-inf", "("+strconv.FormatInt(cutoff, 10)); err != nil {
+		return err
+	}
+	_, err := r.do("EXPIRE", key, strconv.Itoa(int(redisTrackedRetention.Seconds())))
+	return err
+}
+
+// ListTrackedMessages returns the chat's tracked messages, oldest first.
+func (r *RedisStore) ListTrackedMessages(chatID int64) ([]TrackedMessage, error) {
+	reply, err := r.do("ZRANGE", redisTrackedKey+strconv.FormatInt(chatID, 10), "0", "-1", "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	out := make([]TrackedMessage, 0, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		member, _ := items[i].(string)
+		score, _ := items[i+1].(string)
+		id, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+		sent, _ := strconv.ParseInt(score, 10, 64)
+		out = append(out, TrackedMessage{ChatID: chatID, MessageID: id, SentAt: time.Unix(sent, 0).UTC()})
+	}
+	return out, nil
+}
+
+// UntrackMessages forgets the given messages of a chat.
+func (r *RedisStore) UntrackMessages(chatID int64, messageIDs []int) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	args := []string{"ZREM", redisTrackedKey + strconv.FormatInt(chatID, 10)}
+	for _, id := range messageIDs {
+		args = append(args, strconv.Itoa(id))
+	}
+	_, err := r.do(args...)
+	return err
+}
+
+// DeleteTrackedMessagesBefore is a no-op: TrackMessage trims old entries
+// and Redis expires idle chats' keys.
+func (r *RedisStore) DeleteTrackedMessagesBefore(time.Time) (int, error) {
+	return 0, nil
+}
+
+// SaveBookmark stores b, replacing an existing bookmark of the same message.
+func (r *RedisStore) SaveBookmark(b Bookmark) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("HSET", redisBookmarkKey+strconv.FormatInt(b.ChatID, 10), strconv.Itoa(b.MessageID), string(data))
+	return err
+}
+
+// GetBookmark returns the chat's bookmark of messageID.
+func (r *RedisStore) GetBookmark(chatID int64, messageID int) (Bookmark, error) {
+	reply, err := r.do("HGET", redisBookmarkKey+strconv.FormatInt(chatID, 10), strconv.Itoa(messageID))
+	if err != nil {
+		return Bookmark{}, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return Bookmark{}, ErrNotFound
+	}
+	var b Bookmark
+	if err := json.Unmarshal([]byte(data), &b); err != nil {
+		return Bookmark{}, fmt.Errorf("decode bookmark: %w", err)
+	}
+	return b, nil
+}
+
+// ListBookmarks returns the chat's bookmarks, newest first.
+func (r *RedisStore) ListBookmarks(chatID int64) ([]Bookmark, error) {
+	reply, err := r.do("HVALS", redisBookmarkKey+strconv.FormatInt(chatID, 10))
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	out := make([]Bookmark, 0, len(values))
+	for _, v := range values {
+		data, _ := v.(string)
+		var b Bookmark
+		if err := json.Unmarshal([]byte(data), &b); err != nil {
+			return nil, fmt.Errorf("decode bookmark: %w", err)
+		}
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+// DeleteBookmark removes the chat's bookmark of messageID.
+func (r *RedisStore) DeleteBookmark(chatID int64, messageID int) error {
+	_, err := r.do("HDEL", redisBookmarkKey+strconv.FormatInt(chatID, 10), strconv.Itoa(messageID))
+	return err
+}
+
+// SaveGrant stores g, replacing an existing grant for the chat.
+func (r *RedisStore) SaveGrant(g AccessGrant) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("HSET", redisGrantsKey, strconv.FormatInt(g.ChatID, 10), string(data))
+	return err
+}
+
+// GetGrant returns the chat's grant, expired or not.
+func (r *RedisStore) GetGrant(chatID int64) (AccessGrant, error) {
+	reply, err := r.do("HGET", redisGrantsKey, strconv.FormatInt(chatID, 10))
+	if err != nil {
+		return AccessGrant{}, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return AccessGrant{}, ErrNotFound
+	}
+	var g AccessGrant
+	if err := json.Unmarshal([]byte(data), &g); err != nil {
+		return AccessGrant{}, fmt.Errorf("decode grant: %w", err)
+	}
+	return g, nil
+}
+
+// ListGrants returns every grant, soonest to expire first.
+func (r *RedisStore) ListGrants() ([]AccessGrant, error) {
+	reply, err := r.do("HVALS", redisGrantsKey)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	out := make([]AccessGrant, 0, len(values))
+	for _, v := range values {
+		data, _ := v.(string)
+		var g AccessGrant
+		if err := json.Unmarshal([]byte(data), &g); err != nil {
+			return nil, fmt.Errorf("decode grant: %w", err)
+		}
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ExpiresAt.Before(out[j].ExpiresAt) })
+	return out, nil
+}
+
+// DeleteGrant removes the chat's grant.
+func (r *RedisStore) DeleteGrant(chatID int64) error {
+	_, err := r.do("HDEL", redisGrantsKey, strconv.FormatInt(chatID, 10))
+	return err
+}
+
+// SaveTemplate stores t, replacing a template of the same name.
+func (r *RedisStore) SaveTemplate(t SessionTemplate) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("HSET", redisTmplKey, t.Name, string(data))
+	return err
+}
+
+// GetTemplate returns the template called name.
+func (r *RedisStore) GetTemplate(name string) (SessionTemplate, error) {
+	reply, err := r.do("HGET", redisTmplKey, name)
+	if err != nil {
+		return SessionTemplate{}, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return SessionTemplate{}, ErrNotFound
+	}
+	var t SessionTemplate
+	if err := json.Unmarshal([]byte(data), &t); err != nil {
+		return SessionTemplate{}, fmt.Errorf("decode template: %w", err)
+	}
+	return t, nil
+}
+
+// ListTemplates returns every template, by name.
+func (r *RedisStore) ListTemplates() ([]SessionTemplate, error) {
+	reply, err := r.do("HVALS", redisTmplKey)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	out := make([]SessionTemplate, 0, len(values))
+	for _, v := range values {
+		data, _ := v.(string)
+		var t SessionTemplate
+		if err := json.Unmarshal([]byte(data), &t); err != nil {
+			return nil, fmt.Errorf("decode template: %w", err)
+		}
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// DeleteTemplate removes the template called name.
+func (r *RedisStore) DeleteTemplate(name string) error {
+	_, err := r.do("HDEL", redisTmplKey, name)
+	return err
+}
+
+// AddUsage adds u's counts to those of its chat, day and session.
+func (r *RedisStore) AddUsage(u Usage) error {
+	key := usageKey(u.ChatID, u.Day)
+	if _, err := r.do("HINCRBY", key, u.SessionID+":prompts", strconv.Itoa(u.Prompts)); err != nil {
+		return err
+	}
+	if _, err := r.do("HINCRBY", key, u.SessionID+":tokens", strconv.Itoa(u.Tokens)); err != nil {
+		return err
+	}
+	if _, err := r.do("HINCRBYFLOAT", key, u.SessionID+":cost", strconv.FormatFloat(u.Cost, 'f', -1, 64)); err != nil {
+		return err
+	}
+	_, err := r.do("EXPIRE", key, strconv.Itoa(int(redisUsageRetention.Seconds())))
+	return err
+}
+
+// ListUsage returns the chat's usage on day, one entry per session.
+func (r *RedisStore) ListUsage(chatID int64, day string) ([]Usage, error) {
+	reply, err := r.do("HGETALL", usageKey(chatID, day))
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	bySession := make(map[string]*Usage)
+	for i := 0; i+1 < len(items); i += 2 {
+		field, _ := items[i].(string)
+		value, _ := items[i+1].(string)
+		sep := strings.LastIndexByte(field, ':')
+		if sep < 0 {
+			continue
+		}
+		sessionID := field[:sep]
+		u := bySession[sessionID]
+		if u == nil {
+			u = &Usage{ChatID: chatID, Day: day, SessionID: sessionID}
+			bySession[sessionID] = u
+		}
+		switch field[sep+1:] {
+		case "prompts":
+			u.Prompts, _ = strconv.Atoi(value)
+		case "tokens":
+			u.Tokens, _ = strconv.Atoi(value)
+		case "cost":
+			u.Cost, _ = strconv.ParseFloat(value, 64)
+		}
+	}
+	out := make([]Usage, 0, len(bySession))
+	for _, u := range bySession {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SessionID < out[j].SessionID })
+	return out, nil
+}
+
+// DeleteUsageBefore is a no-op: Redis expires each day's usage after
+// redisUsageRetention.
+func (r *RedisStore) DeleteUsageBefore(string) (int, error) {
+	return 0, nil
+}
+
+// ArchiveSession stores a, replacing an earlier archival of the session.
+func (r *RedisStore) ArchiveSession(a ArchivedSession) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("HSET", redisArchiveKey, a.SessionID, string(data))
+	return err
+}
+
+// ListArchivedSessions returns every archived session, most recently
+// archived first.
+func (r *RedisStore) ListArchivedSessions() ([]ArchivedSession, error) {
+	reply, err := r.do("HVALS", redisArchiveKey)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	out := make([]ArchivedSession, 0, len(values))
+	for _, v := range values {
+		data, _ := v.(string)
+		var a ArchivedSession
+		if err := json.Unmarshal([]byte(data), &a); err != nil {
+			return nil, fmt.Errorf("decode archived session: %w", err)
+		}
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ArchivedAt.After(out[j].ArchivedAt) })
+	return out, nil
+}
+
+// UnarchiveSession removes the session from the archive.
+func (r *RedisStore) UnarchiveSession(sessionID string) error {
+	_, err := r.do("HDEL", redisArchiveKey, sessionID)
+	return err
+}
+
+// MarkUpdate records the update with a SET NX key that expires at
+// expiresAt, so every replica sees it.
+func (r *RedisStore) MarkUpdate(updateID int64, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return true, nil
+	}
+	reply, err := r.do("SET", redisUpdateKey+strconv.FormatInt(updateID, 10), "1",
+		"PX", strconv.FormatInt(ttl.Milliseconds(), 10), "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// SaveOutboxPrompt stores p, replacing an earlier copy of the prompt.
+func (r *RedisStore) SaveOutboxPrompt(p OutboxPrompt) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("HSET", redisOutboxKey, outboxField(p.ChatID, p.PromptID), string(data))
+	return err
+}
+
+// ListOutbox returns every prompt in the outbox, oldest first.
+func (r *RedisStore) ListOutbox() ([]OutboxPrompt, error) {
+	reply, err := r.do("HVALS", redisOutboxKey)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	out := make([]OutboxPrompt, 0, len(values))
+	for _, v := range values {
+		data, _ := v.(string)
+		var p OutboxPrompt
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			return nil, fmt.Errorf("decode outbox prompt: %w", err)
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].QueuedAt.Before(out[j].QueuedAt) })
+	return out, nil
+}
+
+// DeleteOutboxPrompt removes the prompt from the outbox and reports
+// whether it was there.
+func (r *RedisStore) DeleteOutboxPrompt(chatID int64, promptID int) (bool, error) {
+	reply, err := r.do("HDEL", redisOutboxKey, outboxField(chatID, promptID))
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n > 0, nil
+}
+
+// DeleteExpiredUpdates is a no-op: update keys expire on their own.
+func (r *RedisStore) DeleteExpiredUpdates(time.Time) (int, error) {
+	return 0, nil
+}
+
+// Close closes all pooled connections.
+func (r *RedisStore) Close() error {
+	for {
+		select {
+		case c := <-r.pool:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func outboxField(chatID int64, promptID int) string {
+	return strconv.FormatInt(chatID, 10) + ":" + strconv.Itoa(promptID)
+}
+
+func sessionKey(chatID int64) string {
+	return redisSessionKey + strconv.FormatInt(chatID, 10)
+}
+
+func pendingKey(chatID int64) string {
+	return redisPendingKey + strconv.FormatInt(chatID, 10)
+}
+
+func messageKey(chatID int64) string {
+	return redisMessageKey + strconv.FormatInt(chatID, 10)
+}
+
+func leaseKey(sessionID string) string {
+	return redisLeaseKey + sessionID
+}
+
+func usageKey(chatID int64, day string) string {
+	return redisUsageKey + strconv.FormatInt(chatID, 10) + ":" + day
+}
+
+// do runs a single command on a pooled connection. Connections that hit
+// an I/O error are discarded rather than returned to the pool.
+func (r *RedisStore) do(args ...string) (interface{}, error) {
+	c, err := r.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		c.conn.Close()
+		return nil, err
+	}
+	r.put(c)
+	return reply, err
+}
+
+func (r *RedisStore) get() (*redisConn, error) {
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", r.addr, redisIOTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("redis dial: %w", err)
+	}
+	c := &redisConn{conn: conn, rd: bufio.NewReader(conn), wr: bufio.NewWriter(conn)}
+	if r.password != "" {
+		if _, err := c.do("AUTH", r.password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	if r.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(r.db)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis select: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (r *RedisStore) put(c *redisConn) {
+	select {
+	case r.pool <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// redisError is an error reply from the server (the connection is still usable).
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn speaks the RESP protocol over a single connection.
+type redisConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+	wr   *bufio.Writer
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetDeadline(time.Now().Add(redisIOTimeout))
+	fmt.Fprintf(c.wr, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.wr, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := c.wr.Flush(); err != nil {
+		return nil, fmt.Errorf("redis write: %w", err)
+	}
+	return c.readReply()
+}
+
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis read: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis read: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis read: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, fmt.Errorf("redis read: %w", err)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis read: bad array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := c.readReply()
+			var redisErr redisError
+			if err != nil && !errors.As(err, &redisErr) {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis read: unexpected reply %q", line)
+	}
+}
@@ -2,23 +2,253 @@ package store
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// ErrNotFound is returned by GetSession when a chat has no mapping. It
+// aliases sql.ErrNoRows so SQLite and other backends behave the same.
+var ErrNotFound = sql.ErrNoRows
+
+// Store is the persistence interface used by the bot. DB (SQLite),
+// MemoryStore and RedisStore implement it with identical semantics.
+type Store interface {
+	GetSession(chatID int64) (Session, error)
+	SetSession(s Session) error
+	DeleteSession(chatID int64) error
+	IncrementCount(chatID int64) error
+	ListAll() ([]Session, error)
+	DeleteAll() error
+
+	// Pending actions hold the state of a multi-step interaction; a chat
+	// has at most one, and expired ones are never returned.
+	SetPendingAction(a PendingAction) error
+	GetPendingAction(chatID int64) (PendingAction, error)
+	DeletePendingAction(chatID int64) error
+	DeleteExpiredPendingActions() (int, error)
+
+	// Leases record which replica streams a session's replies. A lease
+	// can be taken when it is free, expired or already held by the same
+	// owner; expired leases are kept until DeleteExpiredLeases so another
+	// replica can adopt the stream.
+	AcquireLease(l Lease) (bool, error)
+	GetLease(sessionID string) (Lease, error)
+	DeleteLease(sessionID, owner string) error
+	DeleteExpiredLeases(before time.Time) (int, error)
+
+	// The message cache holds the complete text of each chat's latest
+	// streamed reply, which may be longer than Telegram shows.
+	SaveMessageText(chatID int64, messageID int, sessionID, text string) error
+	AppendMessageText(chatID int64, messageID int, sessionID, chunk string) error
+	GetMessageText(chatID int64) (CachedMessage, error)
+
+	// Chat settings are small per-chat preferences keyed by name (model
+	// favorites, toggles, ...). Setting an empty value removes the key.
+	GetChatSetting(chatID int64, key string) (string, error)
+	SetChatSetting(chatID int64, key, value string) error
+
+	// Snapshots name a point in a session's history to restore later.
+	// Saving under an existing name replaces it.
+	SaveSnapshot(s Snapshot) error
+	GetSnapshot(sessionID, name string) (Snapshot, error)
+	ListSnapshots(sessionID string) ([]Snapshot, error)
+
+	// Tracked messages are the bot's transient messages (status lines,
+	// pickers) that /cleanup deletes from the chat.
+	TrackMessage(chatID int64, messageID int) error
+	ListTrackedMessages(chatID int64) ([]TrackedMessage, error)
+	UntrackMessages(chatID int64, messageIDs []int) error
+	DeleteTrackedMessagesBefore(before time.Time) (int, error)
+
+	// Bookmarks are replies a chat saved with the Save button, keyed by
+	// the reply's message ID.
+	SaveBookmark(b Bookmark) error
+	GetBookmark(chatID int64, messageID int) (Bookmark, error)
+	ListBookmarks(chatID int64) ([]Bookmark, error)
+	DeleteBookmark(chatID int64, messageID int) error
+
+	// Access grants let a chat outside ALLOWED_USERS in until they
+	// expire. Expired grants are kept until deleted, so the janitor can
+	// report them.
+	SaveGrant(g AccessGrant) error
+	GetGrant(chatID int64) (AccessGrant, error)
+	ListGrants() ([]AccessGrant, error)
+	DeleteGrant(chatID int64) error
+
+	// Session templates are the ones admins define with /template; they
+	// take precedence over SESSION_TEMPLATES_FILE entries of the same name.
+	SaveTemplate(t SessionTemplate) error
+	GetTemplate(name string) (SessionTemplate, error)
+	ListTemplates() ([]SessionTemplate, error)
+	DeleteTemplate(name string) error
+
+	// Usage counts each chat's prompts, tokens and cost per session and
+	// day, the day being the chat's local date ("2006-01-02"). AddUsage
+	// adds to the counts already recorded.
+	AddUsage(u Usage) error
+	ListUsage(chatID int64, day string) ([]Usage, error)
+	DeleteUsageBefore(day string) (int, error)
+
+	// Archived sessions are OpenCode sessions left idle past
+	// ARCHIVE_AFTER; /sessions hides them unless asked to show all.
+	ArchiveSession(a ArchivedSession) error
+	ListArchivedSessions() ([]ArchivedSession, error)
+	UnarchiveSession(sessionID string) error
+
+	// Processed updates are the Telegram update IDs handled recently, so
+	// ones delivered again are skipped. MarkUpdate records the ID until
+	// expiresAt and reports whether it was new; expired IDs are kept until
+	// DeleteExpiredUpdates.
+	MarkUpdate(updateID int64, expiresAt time.Time) (bool, error)
+	DeleteExpiredUpdates(before time.Time) (int, error)
+
+	// The outbox holds prompts that couldn't be sent while OpenCode was
+	// unreachable, keyed by chat and prompt message ID, until they are
+	// replayed. DeleteOutboxPrompt reports whether the prompt was there,
+	// so only one replica replays it.
+	SaveOutboxPrompt(p OutboxPrompt) error
+	ListOutbox() ([]OutboxPrompt, error)
+	DeleteOutboxPrompt(chatID int64, promptID int) (bool, error)
+
+	Close() error
+}
+
+// Options selects and configures a Store backend.
+type Options struct {
+	Driver   string // "sqlite" (default), "memory" or "redis"
+	Path     string // SQLite database file
+	RedisURL string // redis://[:password@]host:port[/db]
+}
+
+// Open returns a Store for the configured driver.
+func Open(opts Options) (Store, error) {
+	switch opts.Driver {
+	case "", "sqlite", "sqlite3":
+		return New(opts.Path)
+	case "memory":
+		log.Println("Using in-memory store; sessions will not survive a restart")
+		return NewMemory(), nil
+	case "redis":
+		return NewRedis(opts.RedisURL)
+	default:
+		return nil, fmt.Errorf("unknown store driver %q", opts.Driver)
+	}
+}
+
 // Session represents a user's session mapping in the database.
 type Session struct {
-	ChatID       int64
-	SessionID    string
-	Title        string
-	Agent        string
+	ChatID        int64
+	SessionID     string
+	Title         string
+	Agent         string
 	ModelProvider string
 	ModelID       string
-	MessageCount int
-	CreatedAt    time.Time
-	LastUsed     time.Time
+	MessageCount  int
+	CreatedAt     time.Time
+	LastUsed      time.Time
+}
+
+// PendingAction is an interaction waiting for the chat's next message or
+// button press (confirmations, parameter entry, ...).
+type PendingAction struct {
+	ChatID    int64
+	Type      string
+	Payload   string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Lease is one replica's claim on streaming an OpenCode session into a
+// chat message.
+type Lease struct {
+	SessionID string
+	ChatID    int64
+	MessageID int
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// CachedMessage is the full text of a chat's latest streamed reply.
+type CachedMessage struct {
+	ChatID    int64
+	MessageID int
+	SessionID string
+	Text      string
+	UpdatedAt time.Time
+}
+
+// Snapshot is a named point in a session's history: the last message when
+// it was taken (empty for a session with no messages yet).
+type Snapshot struct {
+	SessionID string
+	Name      string
+	MessageID string
+	CreatedAt time.Time
+}
+
+// TrackedMessage is a transient bot message that /cleanup may delete.
+type TrackedMessage struct {
+	ChatID    int64
+	MessageID int
+	SentAt    time.Time
+}
+
+// Bookmark is a saved copy of a bot reply.
+type Bookmark struct {
+	ChatID    int64
+	MessageID int
+	SessionID string
+	Text      string
+	CreatedAt time.Time
+}
+
+// AccessGrant is temporary access to the bot given by an admin.
+type AccessGrant struct {
+	ChatID    int64
+	GrantedBy int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// SessionTemplate is an admin-defined bundle of agent, model, directory
+// and system prompt that /newfrom starts a session with.
+type SessionTemplate struct {
+	Name      string
+	Agent     string
+	Model     string // "provider/model"
+	Dir       string
+	System    string
+	UpdatedBy int64
+	UpdatedAt time.Time
+}
+
+// Usage is what a chat's prompts in one session cost on one day.
+type Usage struct {
+	ChatID    int64
+	Day       string // "2006-01-02", in the chat's time zone
+	SessionID string
+	Prompts   int
+	Tokens    int
+	Cost      float64
+}
+
+// ArchivedSession is an OpenCode session archived for being idle.
+type ArchivedSession struct {
+	SessionID  string
+	Title      string
+	ArchivedAt time.Time
+}
+
+// OutboxPrompt is a prompt waiting for OpenCode to be reachable again.
+type OutboxPrompt struct {
+	ChatID   int64
+	PromptID int // the user's message
+	UserID   int64
+	Text     string
+	QueuedAt time.Time
 }
 
 // DB wraps a SQLite database for session management.
@@ -41,25 +271,9 @@ func New(dbPath string) (*DB, error) {
 }
 
 func (db *DB) init() error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS user_sessions (
-			chat_id       INTEGER PRIMARY KEY,
-			session_id    TEXT NOT NULL,
-			title         TEXT,
-			agent         TEXT DEFAULT '',
-			model_provider TEXT DEFAULT '',
-			model_id       TEXT DEFAULT '',
-			message_count INTEGER DEFAULT 0,
-			created_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
-			last_used     DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`)
-	if err != nil {
-		return err
+	if err := db.migrate(); err != nil {
+		return fmt.Errorf("migrate: %w", err)
 	}
-	// Add agent column if migrating from old schema
-	_, _ = db.Exec(`ALTER TABLE user_sessions ADD COLUMN agent TEXT DEFAULT ''`)
-	_, _ = db.Exec(`ALTER TABLE user_sessions ADD COLUMN model_provider TEXT DEFAULT ''`)
-	_, _ = db.Exec(`ALTER TABLE user_sessions ADD COLUMN model_id TEXT DEFAULT ''`)
 	log.Println("Database initialized successfully")
 	return nil
 }
@@ -141,3 +355,538 @@ func (db *DB) DeleteAll() error {
 	_, err := db.Exec(`DELETE FROM user_sessions`)
 	return err
 }
+
+// SetPendingAction stores the chat's pending action, replacing any previous one.
+func (db *DB) SetPendingAction(a PendingAction) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO pending_actions (chat_id, type, payload, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		a.ChatID, a.Type, a.Payload, a.ExpiresAt.UTC(), a.CreatedAt.UTC())
+	return err
+}
+
+// GetPendingAction returns the chat's unexpired pending action.
+func (db *DB) GetPendingAction(chatID int64) (PendingAction, error) {
+	var a PendingAction
+	err := db.QueryRow(`
+		SELECT chat_id, type, payload, expires_at, created_at
+		FROM pending_actions WHERE chat_id = ? AND expires_at > ?`, chatID, time.Now().UTC(),
+	).Scan(&a.ChatID, &a.Type, &a.Payload, &a.ExpiresAt, &a.CreatedAt)
+	if err != nil {
+		return PendingAction{}, err
+	}
+	return a, nil
+}
+
+// DeletePendingAction removes the chat's pending action.
+func (db *DB) DeletePendingAction(chatID int64) error {
+	_, err := db.Exec(`DELETE FROM pending_actions WHERE chat_id = ?`, chatID)
+	return err
+}
+
+// DeleteExpiredPendingActions removes expired pending actions and returns how many were deleted.
+func (db *DB) DeleteExpiredPendingActions() (int, error) {
+	res, err := db.Exec(`DELETE FROM pending_actions WHERE expires_at <= ?`, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// AcquireLease inserts or renews l unless another owner holds an unexpired
+// lease on the session. It reports whether l was stored.
+func (db *DB) AcquireLease(l Lease) (bool, error) {
+	res, err := db.Exec(`
+		INSERT INTO stream_leases (session_id, chat_id, message_id, owner, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (session_id) DO UPDATE SET
+			chat_id = excluded.chat_id,
+			message_id = excluded.message_id,
+			owner = excluded.owner,
+			expires_at = excluded.expires_at
+		WHERE stream_leases.owner = excluded.owner OR stream_leases.expires_at <= ?`,
+		l.SessionID, l.ChatID, l.MessageID, l.Owner, l.ExpiresAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetLease returns the session's lease, expired or not.
+func (db *DB) GetLease(sessionID string) (Lease, error) {
+	var l Lease
+	err := db.QueryRow(`
+		SELECT session_id, chat_id, message_id, owner, expires_at
+		FROM stream_leases WHERE session_id = ?`, sessionID,
+	).Scan(&l.SessionID, &l.ChatID, &l.MessageID, &l.Owner, &l.ExpiresAt)
+	if err != nil {
+		return Lease{}, err
+	}
+	return l, nil
+}
+
+// DeleteLease removes the session's lease if owner holds it.
+func (db *DB) DeleteLease(sessionID, owner string) error {
+	_, err := db.Exec(`DELETE FROM stream_leases WHERE session_id = ? AND owner = ?`, sessionID, owner)
+	return err
+}
+
+// DeleteExpiredLeases removes leases that expired before the given time
+// and returns how many were deleted.
+func (db *DB) DeleteExpiredLeases(before time.Time) (int, error) {
+	res, err := db.Exec(`DELETE FROM stream_leases WHERE expires_at <= ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// SaveMessageText replaces the chat's cached reply text.
+func (db *DB) SaveMessageText(chatID int64, messageID int, sessionID, text string) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO message_cache (chat_id, message_id, session_id, text, updated_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		chatID, messageID, sessionID, text, time.Now().UTC())
+	return err
+}
+
+// AppendMessageText appends chunk to the chat's cached reply text,
+// creating the entry if needed.
+func (db *DB) AppendMessageText(chatID int64, messageID int, sessionID, chunk string) error {
+	_, err := db.Exec(`
+		INSERT INTO message_cache (chat_id, message_id, session_id, text, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (chat_id) DO UPDATE SET
+			message_id = excluded.message_id,
+			session_id = excluded.session_id,
+			text = message_cache.text || excluded.text,
+			updated_at = excluded.updated_at`,
+		chatID, messageID, sessionID, chunk, time.Now().UTC())
+	return err
+}
+
+// GetMessageText returns the chat's cached reply.
+func (db *DB) GetMessageText(chatID int64) (CachedMessage, error) {
+	var m CachedMessage
+	err := db.QueryRow(`
+		SELECT chat_id, message_id, session_id, text, updated_at
+		FROM message_cache WHERE chat_id = ?`, chatID,
+	).Scan(&m.ChatID, &m.MessageID, &m.SessionID, &m.Text, &m.UpdatedAt)
+	if err != nil {
+		return CachedMessage{}, err
+	}
+	return m, nil
+}
+
+// GetChatSetting returns the chat's value for key.
+func (db *DB) GetChatSetting(chatID int64, key string) (string, error) {
+	var value string
+	err := db.QueryRow(`SELECT value FROM chat_settings WHERE chat_id = ? AND key = ?`, chatID, key).Scan(&value)
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// SetChatSetting stores the chat's value for key; an empty value deletes it.
+func (db *DB) SetChatSetting(chatID int64, key, value string) error {
+	if value == "" {
+		_, err := db.Exec(`DELETE FROM chat_settings WHERE chat_id = ? AND key = ?`, chatID, key)
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO chat_settings (chat_id, key, value, updated_at)
+		VALUES (?, ?, ?, ?)`,
+		chatID, key, value, time.Now().UTC())
+	return err
+}
+
+// SaveSnapshot stores s, replacing a snapshot of the same name.
+func (db *DB) SaveSnapshot(s Snapshot) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO snapshots (session_id, name, message_id, created_at)
+		VALUES (?, ?, ?, ?)`,
+		s.SessionID, s.Name, s.MessageID, s.CreatedAt.UTC())
+	return err
+}
+
+// GetSnapshot returns the session's snapshot called name.
+func (db *DB) GetSnapshot(sessionID, name string) (Snapshot, error) {
+	var s Snapshot
+	err := db.QueryRow(`
+		SELECT session_id, name, message_id, created_at
+		FROM snapshots WHERE session_id = ? AND name = ?`, sessionID, name,
+	).Scan(&s.SessionID, &s.Name, &s.MessageID, &s.CreatedAt)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return s, nil
+}
+
+// ListSnapshots returns the session's snapshots, oldest first.
+func (db *DB) ListSnapshots(sessionID string) ([]Snapshot, error) {
+	rows, err := db.Query(`
+		SELECT session_id, name, message_id, created_at
+		FROM snapshots WHERE session_id = ? ORDER BY created_at`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Snapshot
+	for rows.Next() {
+		var s Snapshot
+		if err := rows.Scan(&s.SessionID, &s.Name, &s.MessageID, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// TrackMessage records a transient bot message for later cleanup.
+func (db *DB) TrackMessage(chatID int64, messageID int) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO tracked_messages (chat_id, message_id, sent_at)
+		VALUES (?, ?, ?)`,
+		chatID, messageID, time.Now().UTC())
+	return err
+}
+
+// ListTrackedMessages returns the chat's tracked messages, oldest first.
+func (db *DB) ListTrackedMessages(chatID int64) ([]TrackedMessage, error) {
+	rows, err := db.Query(`
+		SELECT chat_id, message_id, sent_at
+		FROM tracked_messages WHERE chat_id = ? ORDER BY sent_at`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TrackedMessage
+	for rows.Next() {
+		var m TrackedMessage
+		if err := rows.Scan(&m.ChatID, &m.MessageID, &m.SentAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// UntrackMessages forgets the given messages of a chat.
+func (db *DB) UntrackMessages(chatID int64, messageIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range messageIDs {
+		if _, err := tx.Exec(`DELETE FROM tracked_messages WHERE chat_id = ? AND message_id = ?`, chatID, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteTrackedMessagesBefore forgets messages sent before the given
+// time and returns how many were removed.
+func (db *DB) DeleteTrackedMessagesBefore(before time.Time) (int, error) {
+	res, err := db.Exec(`DELETE FROM tracked_messages WHERE sent_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// SaveBookmark stores b, replacing an existing bookmark of the same message.
+func (db *DB) SaveBookmark(b Bookmark) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO bookmarks (chat_id, message_id, session_id, text, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		b.ChatID, b.MessageID, b.SessionID, b.Text, b.CreatedAt.UTC())
+	return err
+}
+
+// GetBookmark returns the chat's bookmark of messageID.
+func (db *DB) GetBookmark(chatID int64, messageID int) (Bookmark, error) {
+	var b Bookmark
+	err := db.QueryRow(`
+		SELECT chat_id, message_id, session_id, text, created_at
+		FROM bookmarks WHERE chat_id = ? AND message_id = ?`, chatID, messageID,
+	).Scan(&b.ChatID, &b.MessageID, &b.SessionID, &b.Text, &b.CreatedAt)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	return b, nil
+}
+
+// ListBookmarks returns the chat's bookmarks, newest first.
+func (db *DB) ListBookmarks(chatID int64) ([]Bookmark, error) {
+	rows, err := db.Query(`
+		SELECT chat_id, message_id, session_id, text, created_at
+		FROM bookmarks WHERE chat_id = ? ORDER BY created_at DESC`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Bookmark
+	for rows.Next() {
+		var b Bookmark
+		if err := rows.Scan(&b.ChatID, &b.MessageID, &b.SessionID, &b.Text, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// DeleteBookmark removes the chat's bookmark of messageID.
+func (db *DB) DeleteBookmark(chatID int64, messageID int) error {
+	_, err := db.Exec(`DELETE FROM bookmarks WHERE chat_id = ? AND message_id = ?`, chatID, messageID)
+	return err
+}
+
+// SaveGrant stores g, replacing an existing grant for the chat.
+func (db *DB) SaveGrant(g AccessGrant) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO access_grants (chat_id, granted_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?)`,
+		g.ChatID, g.GrantedBy, g.CreatedAt.UTC(), g.ExpiresAt.UTC())
+	return err
+}
+
+// GetGrant returns the chat's grant, expired or not.
+func (db *DB) GetGrant(chatID int64) (AccessGrant, error) {
+	var g AccessGrant
+	err := db.QueryRow(`
+		SELECT chat_id, granted_by, created_at, expires_at
+		FROM access_grants WHERE chat_id = ?`, chatID,
+	).Scan(&g.ChatID, &g.GrantedBy, &g.CreatedAt, &g.ExpiresAt)
+	if err != nil {
+		return AccessGrant{}, err
+	}
+	return g, nil
+}
+
+// ListGrants returns every grant, soonest to expire first.
+func (db *DB) ListGrants() ([]AccessGrant, error) {
+	rows, err := db.Query(`
+		SELECT chat_id, granted_by, created_at, expires_at
+		FROM access_grants ORDER BY expires_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AccessGrant
+	for rows.Next() {
+		var g AccessGrant
+		if err := rows.Scan(&g.ChatID, &g.GrantedBy, &g.CreatedAt, &g.ExpiresAt); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// DeleteGrant removes the chat's grant.
+func (db *DB) DeleteGrant(chatID int64) error {
+	_, err := db.Exec(`DELETE FROM access_grants WHERE chat_id = ?`, chatID)
+	return err
+}
+
+// SaveTemplate stores t, replacing a template of the same name.
+func (db *DB) SaveTemplate(t SessionTemplate) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO session_templates (name, agent, model, dir, system, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.Name, t.Agent, t.Model, t.Dir, t.System, t.UpdatedBy, t.UpdatedAt.UTC())
+	return err
+}
+
+// GetTemplate returns the template called name.
+func (db *DB) GetTemplate(name string) (SessionTemplate, error) {
+	var t SessionTemplate
+	err := db.QueryRow(`
+		SELECT name, agent, model, dir, system, updated_by, updated_at
+		FROM session_templates WHERE name = ?`, name,
+	).Scan(&t.Name, &t.Agent, &t.Model, &t.Dir, &t.System, &t.UpdatedBy, &t.UpdatedAt)
+	if err != nil {
+		return SessionTemplate{}, err
+	}
+	return t, nil
+}
+
+// ListTemplates returns every template, by name.
+func (db *DB) ListTemplates() ([]SessionTemplate, error) {
+	rows, err := db.Query(`
+		SELECT name, agent, model, dir, system, updated_by, updated_at
+		FROM session_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []SessionTemplate
+	for rows.Next() {
+		var t SessionTemplate
+		if err := rows.Scan(&t.Name, &t.Agent, &t.Model, &t.Dir, &t.System, &t.UpdatedBy, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// DeleteTemplate removes the template called name.
+func (db *DB) DeleteTemplate(name string) error {
+	_, err := db.Exec(`DELETE FROM session_templates WHERE name = ?`, name)
+	return err
+}
+
+// AddUsage adds u's counts to those of its chat, day and session.
+func (db *DB) AddUsage(u Usage) error {
+	_, err := db.Exec(`
+		INSERT INTO usage (chat_id, day, session_id, prompts, tokens, cost)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chat_id, day, session_id) DO UPDATE SET
+			prompts = prompts + excluded.prompts,
+			tokens  = tokens + excluded.tokens,
+			cost    = cost + excluded.cost`,
+		u.ChatID, u.Day, u.SessionID, u.Prompts, u.Tokens, u.Cost)
+	return err
+}
+
+// ListUsage returns the chat's usage on day, one entry per session.
+func (db *DB) ListUsage(chatID int64, day string) ([]Usage, error) {
+	rows, err := db.Query(`
+		SELECT chat_id, day, session_id, prompts, tokens, cost
+		FROM usage WHERE chat_id = ? AND day = ? ORDER BY session_id`, chatID, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Usage
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.ChatID, &u.Day, &u.SessionID, &u.Prompts, &u.Tokens, &u.Cost); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// DeleteUsageBefore removes the usage of days before day and returns how
+// many entries were removed.
+func (db *DB) DeleteUsageBefore(day string) (int, error) {
+	res, err := db.Exec(`DELETE FROM usage WHERE day < ?`, day)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ArchiveSession stores a, replacing an earlier archival of the session.
+func (db *DB) ArchiveSession(a ArchivedSession) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO archived_sessions (session_id, title, archived_at)
+		VALUES (?, ?, ?)`,
+		a.SessionID, a.Title, a.ArchivedAt.UTC())
+	return err
+}
+
+// ListArchivedSessions returns every archived session, most recently
+// archived first.
+func (db *DB) ListArchivedSessions() ([]ArchivedSession, error) {
+	rows, err := db.Query(`
+		SELECT session_id, title, archived_at
+		FROM archived_sessions ORDER BY archived_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ArchivedSession
+	for rows.Next() {
+		var a ArchivedSession
+		if err := rows.Scan(&a.SessionID, &a.Title, &a.ArchivedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// UnarchiveSession removes the session from the archive.
+func (db *DB) UnarchiveSession(sessionID string) error {
+	_, err := db.Exec(`DELETE FROM archived_sessions WHERE session_id = ?`, sessionID)
+	return err
+}
+
+// MarkUpdate records the update until expiresAt and reports whether it
+// wasn't recorded already, or only with an expiry that has passed.
+func (db *DB) MarkUpdate(updateID int64, expiresAt time.Time) (bool, error) {
+	res, err := db.Exec(`
+		INSERT INTO processed_updates (update_id, expires_at) VALUES (?, ?)
+		ON CONFLICT (update_id) DO UPDATE SET expires_at = excluded.expires_at
+		WHERE processed_updates.expires_at < ?`,
+		updateID, expiresAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SaveOutboxPrompt stores p, replacing an earlier copy of the prompt.
+func (db *DB) SaveOutboxPrompt(p OutboxPrompt) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO outbox (chat_id, prompt_id, user_id, text, queued_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		p.ChatID, p.PromptID, p.UserID, p.Text, p.QueuedAt.UTC())
+	return err
+}
+
+// ListOutbox returns every prompt in the outbox, oldest first.
+func (db *DB) ListOutbox() ([]OutboxPrompt, error) {
+	rows, err := db.Query(`
+		SELECT chat_id, prompt_id, user_id, text, queued_at
+		FROM outbox ORDER BY queued_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []OutboxPrompt
+	for rows.Next() {
+		var p OutboxPrompt
+		if err := rows.Scan(&p.ChatID, &p.PromptID, &p.UserID, &p.Text, &p.QueuedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// DeleteOutboxPrompt removes the prompt from the outbox and reports
+// whether it was there.
+func (db *DB) DeleteOutboxPrompt(chatID int64, promptID int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM outbox WHERE chat_id = ? AND prompt_id = ?`, chatID, promptID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteExpiredUpdates removes the updates that expired before before and
+// returns how many were removed.
+func (db *DB) DeleteExpiredUpdates(before time.Time) (int, error) {
+	res, err := db.Exec(`DELETE FROM processed_updates WHERE expires_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testStores runs fn against every backend that needs no server, so they
+// keep the same semantics.
+func testStores(t *testing.T, fn func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemory())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := New(filepath.Join(t.TempDir(), "sessions.db"))
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		fn(t, db)
+	})
+}
+
+func TestSession(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		if _, err := s.GetSession(1); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetSession of an unknown chat: err = %v, want ErrNotFound", err)
+		}
+
+		now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		want := Session{
+			ChatID:        1,
+			SessionID:     "ses_1",
+			Title:         "First",
+			Agent:         "build",
+			ModelProvider: "anthropic",
+			ModelID:       "claude",
+			MessageCount:  3,
+			CreatedAt:     now,
+			LastUsed:      now,
+		}
+		if err := s.SetSession(want); err != nil {
+			t.Fatalf("SetSession: %v", err)
+		}
+		got, err := s.GetSession(1)
+		if err != nil {
+			t.Fatalf("GetSession: %v", err)
+		}
+		if !sameSession(got, want) {
+			t.Errorf("GetSession = %+v, want %+v", got, want)
+		}
+
+		want.SessionID = "ses_2"
+		want.Agent = ""
+		if err := s.SetSession(want); err != nil {
+			t.Fatalf("SetSession again: %v", err)
+		}
+		if got, _ := s.GetSession(1); !sameSession(got, want) {
+			t.Errorf("GetSession after replacing = %+v, want %+v", got, want)
+		}
+
+		if err := s.DeleteSession(1); err != nil {
+			t.Fatalf("DeleteSession: %v", err)
+		}
+		if _, err := s.GetSession(1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetSession after DeleteSession: err = %v, want ErrNotFound", err)
+		}
+		if err := s.DeleteSession(1); err != nil {
+			t.Errorf("DeleteSession of an unknown chat: %v", err)
+		}
+	})
+}
+
+func TestListAll(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		sessions, err := s.ListAll()
+		if err != nil {
+			t.Fatalf("ListAll: %v", err)
+		}
+		if len(sessions) != 0 {
+			t.Fatalf("ListAll of an empty store = %d sessions, want 0", len(sessions))
+		}
+
+		base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		for i, chatID := range []int64{10, 20, 30} {
+			used := base.Add(time.Duration([]int{1, 3, 2}[i]) * time.Hour)
+			if err := s.SetSession(Session{ChatID: chatID, SessionID: "ses", CreatedAt: base, LastUsed: used}); err != nil {
+				t.Fatalf("SetSession(%d): %v", chatID, err)
+			}
+		}
+		sessions, err = s.ListAll()
+		if err != nil {
+			t.Fatalf("ListAll: %v", err)
+		}
+		var order []int64
+		for _, sess := range sessions {
+			order = append(order, sess.ChatID)
+		}
+		if want := []int64{20, 30, 10}; !slices.Equal(order, want) {
+			t.Errorf("ListAll order = %v, want %v", order, want)
+		}
+
+		if err := s.DeleteAll(); err != nil {
+			t.Fatalf("DeleteAll: %v", err)
+		}
+		if sessions, _ := s.ListAll(); len(sessions) != 0 {
+			t.Errorf("ListAll after DeleteAll = %d sessions, want 0", len(sessions))
+		}
+	})
+}
+
+func TestChatSetting(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		if _, err := s.GetChatSetting(1, "mute"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetChatSetting of an unset key: err = %v, want ErrNotFound", err)
+		}
+
+		if err := s.SetChatSetting(1, "mute", "on"); err != nil {
+			t.Fatalf("SetChatSetting: %v", err)
+		}
+		if err := s.SetChatSetting(2, "mute", "off"); err != nil {
+			t.Fatalf("SetChatSetting: %v", err)
+		}
+		if got, err := s.GetChatSetting(1, "mute"); err != nil || got != "on" {
+			t.Errorf("GetChatSetting(1) = %q, %v, want \"on\"", got, err)
+		}
+		if got, err := s.GetChatSetting(2, "mute"); err != nil || got != "off" {
+			t.Errorf("GetChatSetting(2) = %q, %v, want \"off\"", got, err)
+		}
+
+		if err := s.SetChatSetting(1, "mute", "off"); err != nil {
+			t.Fatalf("SetChatSetting again: %v", err)
+		}
+		if got, _ := s.GetChatSetting(1, "mute"); got != "off" {
+			t.Errorf("GetChatSetting after replacing = %q, want \"off\"", got)
+		}
+
+		if err := s.SetChatSetting(1, "mute", ""); err != nil {
+			t.Fatalf("SetChatSetting empty: %v", err)
+		}
+		if _, err := s.GetChatSetting(1, "mute"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetChatSetting after clearing: err = %v, want ErrNotFound", err)
+		}
+		if got, _ := s.GetChatSetting(2, "mute"); got != "off" {
+			t.Errorf("clearing chat 1 changed chat 2's setting to %q", got)
+		}
+	})
+}
+
+func sameSession(a, b Session) bool {
+	return a.ChatID == b.ChatID && a.SessionID == b.SessionID && a.Title == b.Title &&
+		a.Agent == b.Agent && a.ModelProvider == b.ModelProvider && a.ModelID == b.ModelID &&
+		a.MessageCount == b.MessageCount && a.CreatedAt.Equal(b.CreatedAt) && a.LastUsed.Equal(b.LastUsed)
+}
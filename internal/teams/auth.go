@@ -0,0 +1,199 @@
+package teams
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests from the Bot Framework carry a JWT signed with one of the keys
+// published in its OpenID metadata. The bot accepts a request only if the
+// token is valid, meant for this app and issued for the activity's
+// serviceUrl, which is where replies will be posted.
+const (
+	openIDURL   = "https://login.botframework.com/v1/.well-known/openidconfiguration"
+	tokenIssuer = "https://api.botframework.com"
+	// keyRefresh is how long signing keys are cached; an unknown key ID
+	// refetches them, at most once per keyRetry.
+	keyRefresh = 24 * time.Hour
+	keyRetry   = 5 * time.Minute
+	clockSkew  = 5 * time.Minute
+)
+
+// verifier checks the bearer tokens of incoming activities.
+type verifier struct {
+	appID string
+	http  *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func newVerifier(appID string) *verifier {
+	return &verifier{appID: appID, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+type tokenClaims struct {
+	Issuer     string `json:"iss"`
+	Audience   string `json:"aud"`
+	Expires    int64  `json:"exp"`
+	NotBefore  int64  `json:"nbf"`
+	ServiceURL string `json:"serviceurl"`
+}
+
+// verify checks the Authorization header of an activity posted from
+// serviceURL.
+func (v *verifier) verify(ctx context.Context, header, serviceURL string) error {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return errors.New("missing bearer token")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+	var head struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return fmt.Errorf("token header: %w", err)
+	}
+	if head.Alg != "RS256" {
+		return fmt.Errorf("unexpected signing algorithm %q", head.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("token signature: %w", err)
+	}
+	key, err := v.key(ctx, head.Kid)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return errors.New("bad token signature")
+	}
+
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return fmt.Errorf("token claims: %w", err)
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != tokenIssuer:
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case claims.Audience != v.appID:
+		return fmt.Errorf("token is for app %q", claims.Audience)
+	case now.After(time.Unix(claims.Expires, 0).Add(clockSkew)):
+		return errors.New("token expired")
+	case claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)):
+		return errors.New("token not valid yet")
+	case !strings.EqualFold(strings.TrimSuffix(claims.ServiceURL, "/"), strings.TrimSuffix(serviceURL, "/")):
+		return fmt.Errorf("token was issued for service URL %q", claims.ServiceURL)
+	}
+	return nil
+}
+
+// key returns the signing key kid, refreshing the cached set when it is
+// stale or lacks kid.
+func (v *verifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.keys[kid]; ok && time.Since(v.fetched) < keyRefresh {
+		return key, nil
+	}
+	if v.keys != nil && time.Since(v.fetched) < keyRetry {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	keys, err := v.fetchKeys(ctx)
+	if err != nil {
+		if key, ok := v.keys[kid]; ok {
+			return key, nil // keep using a known key while the metadata is down
+		}
+		return nil, fmt.Errorf("signing keys: %w", err)
+	}
+	v.keys, v.fetched = keys, time.Now()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (v *verifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var meta struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(ctx, openIDURL, &meta); err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA keys published")
+	}
+	return keys, nil
+}
+
+func (v *verifier) getJSON(ctx context.Context, u string, out any) error {
+	if !strings.HasPrefix(u, "https://") {
+		return fmt.Errorf("refusing non-https URL %q", u)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+func decodeSegment(seg string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,155 @@
+package teams
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+)
+
+// Adaptive cards stand in for Telegram's inline keyboards. Their submit
+// actions come back as a message activity whose value holds the action's
+// data merged with the card's inputs.
+
+// submit is the value of a card's submit action.
+type submit struct {
+	Action string `json:"openkh"`
+	// Session picker and permission card
+	Session string `json:"session,omitempty"`
+	// Model picker
+	Model string `json:"model,omitempty"`
+	// Permission card
+	Permission string `json:"permission,omitempty"`
+	Response   string `json:"response,omitempty"`
+}
+
+const (
+	submitSession    = "session"
+	submitModel      = "model"
+	submitPermission = "permission"
+	// defaultModel is the model picker's choice for DEFAULT_MODEL; an
+	// empty ChoiceSet value would be left out of the submit.
+	defaultModel = "default"
+)
+
+type choice struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+func card(body ...any) attachment {
+	return attachment{
+		ContentType: "application/vnd.microsoft.card.adaptive",
+		Content: map[string]any{
+			"type":    "AdaptiveCard",
+			"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+			"version": "1.4",
+			"body":    body,
+		},
+	}
+}
+
+func textBlock(text string, bold bool) map[string]any {
+	block := map[string]any{"type": "TextBlock", "text": text, "wrap": true}
+	if bold {
+		block["weight"] = "Bolder"
+	}
+	return block
+}
+
+func choiceSet(id, value string, choices []choice) map[string]any {
+	return map[string]any{"type": "Input.ChoiceSet", "id": id, "value": value, "choices": choices, "style": "compact"}
+}
+
+func actions(acts ...map[string]any) map[string]any {
+	return map[string]any{"type": "ActionSet", "actions": acts}
+}
+
+func submitAction(title string, data submit) map[string]any {
+	return map[string]any{"type": "Action.Submit", "title": title, "data": data}
+}
+
+// sessionsCard lets the user switch to one of sessions.
+func sessionsCard(sessions []opencode.OCSession, total int, current string) attachment {
+	choices := make([]choice, 0, len(sessions))
+	for _, s := range sessions {
+		title := s.Title
+		if title == "" {
+			title = "Untitled"
+		}
+		if s.Directory != "" {
+			title += " · " + s.Directory
+		}
+		choices = append(choices, choice{Title: title, Value: s.ID})
+	}
+	heading := fmt.Sprintf("Sessions (%d)", total)
+	if total > len(sessions) {
+		heading = fmt.Sprintf("Sessions (%d total, showing %d)", total, len(sessions))
+	}
+	return card(
+		textBlock(heading, true),
+		choiceSet("session", current, choices),
+		actions(submitAction("Switch", submit{Action: submitSession})),
+	)
+}
+
+// modelsCard lets the user pick a model of a connected provider.
+func modelsCard(providers opencode.ProviderResponse, current string) attachment {
+	connected := make(map[string]bool)
+	for _, id := range providers.Connected {
+		connected[id] = true
+	}
+	choices := []choice{{Title: "Default", Value: defaultModel}}
+	var models []choice
+	for _, p := range providers.All {
+		if !connected[p.ID] {
+			continue
+		}
+		name := p.Name
+		if name == "" {
+			name = p.ID
+		}
+		for id, m := range p.Models {
+			models = append(models, choice{Title: m.Name + " (" + name + ")", Value: p.ID + "/" + id})
+		}
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Title < models[j].Title })
+	if current == "" {
+		current = defaultModel
+	}
+	return card(
+		textBlock("Select a model", true),
+		choiceSet("model", current, append(choices, models...)),
+		actions(submitAction("Use model", submit{Action: submitModel})),
+	)
+}
+
+// permissionCard asks whether a tool may run. allow is
+// opencode.PermissionOnce, or PermissionAlways where the tool policy asks
+// once per session.
+func permissionCard(p opencode.Permission, allow string) attachment {
+	text := fmt.Sprintf("🔐 %s wants to run", p.Type)
+	if p.Title != "" {
+		text += ":\n\n" + p.Title
+	}
+	allowTitle := "✅ Allow once"
+	if allow == opencode.PermissionAlways {
+		allowTitle = "✅ Allow for this session"
+	}
+	data := submit{Action: submitPermission, Session: p.SessionID, Permission: p.ID}
+	reject := data
+	data.Response, reject.Response = allow, opencode.PermissionReject
+	return card(
+		textBlock(text, false),
+		actions(submitAction(allowTitle, data), submitAction("⛔ Reject", reject)),
+	)
+}
+
+func parseSubmit(raw json.RawMessage) (submit, bool) {
+	var s submit
+	if err := json.Unmarshal(raw, &s); err != nil || s.Action == "" {
+		return submit{}, false
+	}
+	return s, true
+}
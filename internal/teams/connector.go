@@ -0,0 +1,186 @@
+package teams
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bot Framework endpoints. The token endpoint of a single-tenant app is
+// its own tenant's; multi-tenant apps use the botframework.com tenant.
+const (
+	loginURL      = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+	connectorAuth = "https://api.botframework.com/.default"
+)
+
+// activity is the subset of a Bot Framework activity the bot reads and
+// sends.
+type activity struct {
+	Type         string          `json:"type"`
+	ID           string          `json:"id,omitempty"`
+	ServiceURL   string          `json:"serviceUrl,omitempty"`
+	ChannelID    string          `json:"channelId,omitempty"`
+	From         *account        `json:"from,omitempty"`
+	Recipient    *account        `json:"recipient,omitempty"`
+	Conversation *conversation   `json:"conversation,omitempty"`
+	ReplyToID    string          `json:"replyToId,omitempty"`
+	Text         string          `json:"text,omitempty"`
+	TextFormat   string          `json:"textFormat,omitempty"`
+	Value        json.RawMessage `json:"value,omitempty"`
+	Attachments  []attachment    `json:"attachments,omitempty"`
+	MembersAdded []account       `json:"membersAdded,omitempty"`
+	ChannelData  *channelData    `json:"channelData,omitempty"`
+}
+
+type account struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	AADObjectID string `json:"aadObjectId,omitempty"`
+}
+
+type conversation struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenantId,omitempty"`
+}
+
+type channelData struct {
+	Tenant *struct {
+		ID string `json:"id"`
+	} `json:"tenant,omitempty"`
+}
+
+type attachment struct {
+	ContentType string `json:"contentType"`
+	Content     any    `json:"content"`
+}
+
+// conversationRef is what the bot needs to post into a conversation
+// outside of a request: it is stored per chat.
+type conversationRef struct {
+	ServiceURL     string `json:"serviceUrl"`
+	ConversationID string `json:"conversationId"`
+}
+
+// connector posts and updates activities through the Bot Connector API,
+// authenticated with the app's client credentials.
+type connector struct {
+	appID    string
+	password string
+	tokenURL string
+	http     *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newConnector(appID, password, tenantID string) *connector {
+	if tenantID == "" {
+		tenantID = "botframework.com"
+	}
+	return &connector{
+		appID:    appID,
+		password: password,
+		tokenURL: fmt.Sprintf(loginURL, url.PathEscape(tenantID)),
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// accessToken returns a cached token, fetching a new one shortly before
+// the old one expires.
+func (c *connector) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Until(c.expires) > 5*time.Minute {
+		return c.token, nil
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.appID},
+		"client_secret": {c.password},
+		"scope":         {connectorAuth},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", fmt.Errorf("token request: HTTP %d: %s", resp.StatusCode, body.Error)
+	}
+	c.token = body.AccessToken
+	c.expires = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return c.token, nil
+}
+
+// send posts a to the conversation and returns the new activity's ID.
+func (c *connector) send(ctx context.Context, ref conversationRef, a activity) (string, error) {
+	var res struct {
+		ID string `json:"id"`
+	}
+	err := c.do(ctx, http.MethodPost, activitiesURL(ref, ""), a, &res)
+	return res.ID, err
+}
+
+// update replaces the activity with ID id.
+func (c *connector) update(ctx context.Context, ref conversationRef, id string, a activity) error {
+	return c.do(ctx, http.MethodPut, activitiesURL(ref, id), a, nil)
+}
+
+func activitiesURL(ref conversationRef, id string) string {
+	u := strings.TrimSuffix(ref.ServiceURL, "/") + "/v3/conversations/" + url.PathEscape(ref.ConversationID) + "/activities"
+	if id != "" {
+		u += "/" + url.PathEscape(id)
+	}
+	return u
+}
+
+func (c *connector) do(ctx context.Context, method, u string, in, out any) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: HTTP %d: %s", method, u, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
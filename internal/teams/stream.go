@@ -0,0 +1,274 @@
+package teams
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+)
+
+// sendTimeout bounds one Bot Connector call made for the stream.
+const sendTimeout = 30 * time.Second
+
+// SendText posts a new reply message. The stream's message IDs are ints,
+// so the activity ID is kept in a table.
+func (b *Bot) SendText(chatID int64, text string) (int, error) {
+	ref, ok := b.ref(chatID)
+	if !ok {
+		return 0, fmt.Errorf("no Teams conversation known for chat %d", chatID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	id, err := b.post(ctx, ref, activity{Type: "message", Text: text})
+	if err != nil {
+		return 0, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	b.posted[b.nextID] = id
+	return b.nextID, nil
+}
+
+// EditText updates a reply message in place.
+func (b *Bot) EditText(chatID int64, messageID int, text string) error {
+	ref, ok := b.ref(chatID)
+	if !ok {
+		return fmt.Errorf("no Teams conversation known for chat %d", chatID)
+	}
+	b.mu.Lock()
+	id, ok := b.posted[messageID]
+	b.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if !ok {
+		// Posted before a restart; the text goes into a new message.
+		id, err := b.post(ctx, ref, activity{Type: "message", Text: text})
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.posted[messageID] = id
+		b.mu.Unlock()
+		return nil
+	}
+	return b.conn.update(ctx, ref, id, activity{Type: "message", Text: text, TextFormat: "markdown"})
+}
+
+// router sends stream output for Teams conversations to one sender and
+// everything else to another.
+type router struct {
+	teams, next opencode.MessageSender
+}
+
+// Route returns the stream's MessageSender: Teams conversations go to
+// teams (usually the Bot behind a send queue), other chats to next.
+func Route(teams, next opencode.MessageSender) opencode.MessageSender {
+	return router{teams: teams, next: next}
+}
+
+func (r router) pick(chatID int64) opencode.MessageSender {
+	if IsChatID(chatID) {
+		return r.teams
+	}
+	return r.next
+}
+
+func (r router) SendText(chatID int64, text string) (int, error) {
+	return r.pick(chatID).SendText(chatID, text)
+}
+
+func (r router) EditText(chatID int64, messageID int, text string) error {
+	return r.pick(chatID).EditText(chatID, messageID, text)
+}
+
+func (r router) EditFinalText(chatID int64, messageID int, text string) error {
+	s := r.pick(chatID)
+	if fe, ok := s.(opencode.FinalEditor); ok {
+		return fe.EditFinalText(chatID, messageID, text)
+	}
+	return s.EditText(chatID, messageID, text)
+}
+
+func (r router) FormatsFinal(chatID int64, text string) bool {
+	ff, ok := r.pick(chatID).(opencode.FinalFormatter)
+	return ok && ff.FormatsFinal(chatID, text)
+}
+
+// hooks handles completion and tool events of Teams conversations and
+// passes the rest on.
+type hooks struct {
+	b         *Bot
+	notifier  opencode.CompletionNotifier
+	guard     opencode.ToolGuard
+	questions opencode.QuestionAsker
+}
+
+// Notifier wraps the Telegram completion notifier so Teams replies are
+// finished here.
+func (b *Bot) Notifier(next opencode.CompletionNotifier) opencode.CompletionNotifier {
+	return hooks{b: b, notifier: next}
+}
+
+// Guard wraps the Telegram tool guard: Teams conversations get the users'
+// DENIED_TOOLS and TOOL_POLICY, with an adaptive card in place of the
+// approval buttons.
+func (b *Bot) Guard(next opencode.ToolGuard) opencode.ToolGuard {
+	return hooks{b: b, guard: next}
+}
+
+// ReplyComplete forgets the reply's activity, which the stream doesn't
+// edit again, and the permission cards the run left unanswered.
+func (h hooks) ReplyComplete(chatID int64, messageID int) {
+	if !IsChatID(chatID) {
+		h.notifier.ReplyComplete(chatID, messageID)
+		return
+	}
+	h.b.mu.Lock()
+	defer h.b.mu.Unlock()
+	delete(h.b.posted, messageID)
+	for id, p := range h.b.pending {
+		if p.chatID == chatID {
+			delete(h.b.pending, id)
+		}
+	}
+}
+
+func (h hooks) PermissionAsked(chatID int64, p opencode.Permission) {
+	if !IsChatID(chatID) {
+		h.guard.PermissionAsked(chatID, p)
+		return
+	}
+	go h.b.askPermission(context.Background(), chatID, p)
+}
+
+// Questions wraps the Telegram question hook. Teams has no card for the
+// agent's questions yet, so they are dismissed and the run carries on
+// without an answer.
+func (b *Bot) Questions(next opencode.QuestionAsker) opencode.QuestionAsker {
+	return hooks{b: b, questions: next}
+}
+
+func (h hooks) QuestionAsked(chatID int64, q opencode.Question) {
+	if !IsChatID(chatID) {
+		h.questions.QuestionAsked(chatID, q)
+		return
+	}
+	go func() {
+		ctx := context.Background()
+		if err := h.b.Client.RejectQuestion(ctx, q.ID); err != nil {
+			log.Printf("[teams] Chat %d: %v", chatID, err)
+		}
+		h.b.reply(ctx, chatID, "The agent asked a question Teams can't answer yet; it carries on without an answer.")
+	}()
+}
+
+func (h hooks) ToolStarted(chatID int64, sessionID, callID, tool string) {
+	if !IsChatID(chatID) {
+		h.guard.ToolStarted(chatID, sessionID, callID, tool)
+		return
+	}
+	if !h.b.cfg.DeniedTools[tool] {
+		return
+	}
+	go func() {
+		ctx := context.Background()
+		log.Printf("Warning: chat %d: aborting session %s, it ran %s (DENIED_TOOLS)", chatID, sessionID, tool)
+		if err := h.b.Client.Abort(ctx, sessionID); err != nil {
+			log.Printf("[teams] Chat %d: %v", chatID, err)
+		}
+		h.b.reply(ctx, chatID, fmt.Sprintf("Blocked the %s tool: DENIED_TOOLS denies it. The session was stopped.", tool))
+	}()
+}
+
+// toolMode is how tool's permission requests are handled in Teams, which
+// has no admins or per-chat overrides.
+func (b *Bot) toolMode(tool string) string {
+	if mode, ok := b.cfg.ToolPolicy[tool]; ok {
+		return mode
+	}
+	return config.ToolAsk
+}
+
+type pendingPermission struct {
+	chatID int64
+	tool   string
+}
+
+// askPermission applies the tool policy to a permission request: reject
+// a denied tool, approve an allowed one, or ask with a card.
+func (b *Bot) askPermission(ctx context.Context, chatID int64, p opencode.Permission) {
+	response := ""
+	switch {
+	case b.cfg.DeniedTools[p.Type]:
+		log.Printf("Warning: chat %d: rejecting %s permission in session %s (DENIED_TOOLS)", chatID, p.Type, p.SessionID)
+		response = opencode.PermissionReject
+		b.reply(ctx, chatID, fmt.Sprintf("Blocked the %s tool: DENIED_TOOLS denies it. The request was rejected.", p.Type))
+	case b.toolMode(p.Type) == config.ToolAllow:
+		response = opencode.PermissionOnce
+	}
+	if response != "" {
+		if err := b.Client.RespondPermission(ctx, p.SessionID, p.ID, response); err != nil {
+			log.Printf("[teams] Chat %d: %v", chatID, err)
+		}
+		return
+	}
+	allow := opencode.PermissionOnce
+	if b.toolMode(p.Type) == config.ToolSession {
+		allow = opencode.PermissionAlways
+	}
+	b.mu.Lock()
+	b.pending[p.ID] = pendingPermission{chatID: chatID, tool: p.Type}
+	b.mu.Unlock()
+	b.send(ctx, chatID, activity{Type: "message", Attachments: []attachment{permissionCard(p, allow)}})
+}
+
+// answerPermission applies a permission card's button. The card must be
+// for a pending request of the chat's current session, and "always" only
+// counts where the policy offers it.
+func (b *Bot) answerPermission(ctx context.Context, chatID int64, a activity, s submit) {
+	b.mu.Lock()
+	pending, ok := b.pending[s.Permission]
+	b.mu.Unlock()
+	tool := pending.tool
+	sess, err := b.DB.GetSession(chatID)
+	if !ok || pending.chatID != chatID || err != nil || sess.SessionID != s.Session {
+		b.reply(ctx, chatID, "This request is no longer pending")
+		return
+	}
+	verb := "Rejected"
+	switch s.Response {
+	case opencode.PermissionReject:
+	case opencode.PermissionAlways:
+		if b.toolMode(tool) == config.ToolSession {
+			verb = "Allowed for this session"
+			break
+		}
+		s.Response = opencode.PermissionOnce
+		fallthrough
+	case opencode.PermissionOnce:
+		verb = "Allowed once"
+	default:
+		return
+	}
+	b.mu.Lock()
+	delete(b.pending, s.Permission)
+	b.mu.Unlock()
+	if err := b.Client.RespondPermission(ctx, s.Session, s.Permission, s.Response); err != nil {
+		log.Printf("[teams] Error answering permission %s: %v", s.Permission, err)
+		b.reply(ctx, chatID, "This request is no longer pending")
+		return
+	}
+	// Replace the card so it can't be answered twice.
+	text := fmt.Sprintf("🔐 %s: %s", tool, verb)
+	if a.From.Name != "" {
+		text += " by " + a.From.Name
+	}
+	ref, _ := b.ref(chatID)
+	if a.ReplyToID == "" || b.conn.update(ctx, ref, a.ReplyToID, activity{Type: "message", Text: text}) != nil {
+		b.reply(ctx, chatID, text)
+	}
+}
@@ -0,0 +1,501 @@
+// Package teams connects Microsoft Teams to OpenCode through the Bot
+// Framework. It shares the Telegram bot's opencode.Client, StreamManager
+// and store: each Teams conversation is a chat with an ID below every
+// Telegram chat ID, so sessions, settings and streamed replies work the
+// same for both.
+package teams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+)
+
+var activities = metrics.NewCounter("openkh_teams_activities_total",
+	"Activities received from Microsoft Teams, by result.", "result")
+
+const (
+	maxActivityBody = 1 << 20
+	// handleTimeout bounds the work done for one activity; replies
+	// stream afterwards through the StreamManager.
+	handleTimeout = 2 * time.Minute
+	// refKey is the chat setting holding the conversation's
+	// conversationRef, so replies can be posted after a restart.
+	refKey = "teams.ref"
+)
+
+// chatIDBase is the highest chat ID of a Teams conversation. Telegram's
+// most negative IDs (supergroups) are around -10^13, far above it.
+const chatIDBase = -(1 << 62)
+
+// ChatID returns the store key of a Teams conversation.
+func ChatID(conversationID string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(conversationID))
+	return chatIDBase - int64(h.Sum64()>>2)
+}
+
+// IsChatID reports whether chatID belongs to a Teams conversation.
+func IsChatID(chatID int64) bool {
+	return chatID <= chatIDBase
+}
+
+// Bot serves the Bot Framework messaging endpoint. It is also the
+// MessageSender for Teams conversations: replies are posted as one
+// message each and updated in place as they stream.
+type Bot struct {
+	Client *opencode.Client
+	Stream *opencode.StreamManager
+	DB     store.Store
+	// Locked reports whether a chat locked sessionID with /lock. Teams has
+	// no /unlock, so such sessions are refused; nil refuses none.
+	Locked func(sessionID string) bool
+
+	cfg             *config.Config
+	conn            *connector
+	auth            *verifier
+	defaultProvider string
+	defaultModel    string
+
+	mu      sync.Mutex
+	refs    map[int64]conversationRef
+	posted  map[int]string // stream message ID -> activity ID
+	nextID  int
+	pending map[string]pendingPermission // by permission ID, while its card is unanswered
+}
+
+// New creates a Bot. The stream manager is set afterwards, as it needs the
+// Bot's sender.
+func New(cfg *config.Config, client *opencode.Client, db store.Store) *Bot {
+	b := &Bot{
+		Client:  client,
+		DB:      db,
+		cfg:     cfg,
+		conn:    newConnector(cfg.TeamsAppID, cfg.TeamsAppPassword, cfg.TeamsTenantID),
+		auth:    newVerifier(cfg.TeamsAppID),
+		refs:    make(map[int64]conversationRef),
+		posted:  make(map[int]string),
+		pending: make(map[string]pendingPermission),
+	}
+	b.defaultProvider, b.defaultModel, _ = config.SplitModel(cfg.DefaultModel)
+	return b
+}
+
+// Handler serves the messaging endpoint on /api/messages.
+func (b *Bot) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/messages", b.serveActivity)
+	return mux
+}
+
+func (b *Bot) serveActivity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		activities.Inc("method")
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var a activity
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxActivityBody)).Decode(&a); err != nil {
+		activities.Inc("bad_request")
+		http.Error(w, "bad activity", http.StatusBadRequest)
+		return
+	}
+	if err := b.auth.verify(r.Context(), r.Header.Get("Authorization"), a.ServiceURL); err != nil {
+		log.Printf("Warning: rejected Teams activity from %s: %v", r.RemoteAddr, err)
+		activities.Inc("unauthorized")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	activities.Inc("ok")
+	// The Bot Framework expects an answer within seconds; prompts may
+	// take longer to set up.
+	w.WriteHeader(http.StatusOK)
+	go func() {
+		defer errreport.Recover(errreport.Fields{"goroutine": "teams"})
+		ctx, cancel := context.WithTimeout(context.Background(), handleTimeout)
+		defer cancel()
+		b.handle(ctx, a)
+	}()
+}
+
+func (b *Bot) handle(ctx context.Context, a activity) {
+	if a.Conversation == nil || a.From == nil || a.ServiceURL == "" {
+		return
+	}
+	chatID := ChatID(a.Conversation.ID)
+	ref := conversationRef{ServiceURL: a.ServiceURL, ConversationID: a.Conversation.ID}
+
+	switch a.Type {
+	case "message":
+	case "conversationUpdate":
+		if a.Recipient != nil && addedBot(a.MembersAdded, a.Recipient.ID) && b.allowed(a) {
+			b.remember(chatID, ref)
+			b.reply(ctx, chatID, helpText)
+		}
+		return
+	default:
+		return
+	}
+
+	if !b.allowed(a) {
+		log.Printf("[AUTH BLOCKED] Unauthorized Teams user %q (%s)", a.From.Name, a.From.AADObjectID)
+		b.post(ctx, ref, activity{Type: "message", Text: "You are not allowed to use this bot."})
+		return
+	}
+	b.remember(chatID, ref)
+
+	if len(a.Value) > 0 {
+		if s, ok := parseSubmit(a.Value); ok {
+			b.handleSubmit(ctx, chatID, a, s)
+		}
+		return
+	}
+	text := mentionPattern.ReplaceAllString(a.Text, "")
+	text = strings.TrimSpace(text)
+	switch strings.ToLower(strings.TrimPrefix(text, "/")) {
+	case "":
+		return
+	case "help", "start":
+		b.reply(ctx, chatID, helpText)
+	case "new":
+		b.newSession(ctx, chatID)
+	case "sessions":
+		b.sessions(ctx, chatID)
+	case "model":
+		b.models(ctx, chatID)
+	case "abort", "stop":
+		b.abort(ctx, chatID)
+	default:
+		b.prompt(ctx, chatID, text)
+	}
+}
+
+const helpText = "Send a message to prompt OpenCode. Commands:\n\n" +
+	"- **new**: start a new session\n" +
+	"- **sessions**: switch to another session\n" +
+	"- **model**: pick the model\n" +
+	"- **abort**: stop the running reply"
+
+// mentionPattern matches the bot's @mention, which Teams includes in the
+// text of channel messages.
+var mentionPattern = regexp.MustCompile(`<at>[^<]*</at>`)
+
+func addedBot(members []account, botID string) bool {
+	for _, m := range members {
+		if m.ID == botID {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed applies TEAMS_TENANT_ID and TEAMS_ALLOWED_USERS to the sender
+// of a.
+func (b *Bot) allowed(a activity) bool {
+	if b.cfg.TeamsTenantID != "" {
+		tenant := a.Conversation.TenantID
+		if a.ChannelData != nil && a.ChannelData.Tenant != nil {
+			tenant = a.ChannelData.Tenant.ID
+		}
+		if !strings.EqualFold(tenant, b.cfg.TeamsTenantID) {
+			return false
+		}
+	}
+	if len(b.cfg.TeamsAllowedUsers) == 0 {
+		return true
+	}
+	return b.cfg.TeamsAllowedUsers[strings.ToLower(a.From.AADObjectID)]
+}
+
+// remember stores where chatID's replies go.
+func (b *Bot) remember(chatID int64, ref conversationRef) {
+	b.mu.Lock()
+	known := b.refs[chatID] == ref
+	b.refs[chatID] = ref
+	b.mu.Unlock()
+	if known || b.DB == nil {
+		return
+	}
+	data, _ := json.Marshal(ref)
+	if err := b.DB.SetChatSetting(chatID, refKey, string(data)); err != nil {
+		log.Printf("[teams] Error saving conversation of chat %d: %v", chatID, err)
+	}
+}
+
+// ref returns where chatID's replies go, loading it from the store after
+// a restart.
+func (b *Bot) ref(chatID int64) (conversationRef, bool) {
+	b.mu.Lock()
+	ref, ok := b.refs[chatID]
+	b.mu.Unlock()
+	if ok || b.DB == nil {
+		return ref, ok
+	}
+	raw, err := b.DB.GetChatSetting(chatID, refKey)
+	if err != nil || json.Unmarshal([]byte(raw), &ref) != nil || ref.ServiceURL == "" {
+		return conversationRef{}, false
+	}
+	b.mu.Lock()
+	b.refs[chatID] = ref
+	b.mu.Unlock()
+	return ref, true
+}
+
+func (b *Bot) post(ctx context.Context, ref conversationRef, a activity) (string, error) {
+	if a.Text != "" && a.TextFormat == "" {
+		a.TextFormat = "markdown"
+	}
+	return b.conn.send(ctx, ref, a)
+}
+
+// reply posts text to chatID's conversation.
+func (b *Bot) reply(ctx context.Context, chatID int64, text string) {
+	b.send(ctx, chatID, activity{Type: "message", Text: text})
+}
+
+func (b *Bot) send(ctx context.Context, chatID int64, a activity) {
+	ref, ok := b.ref(chatID)
+	if !ok {
+		log.Printf("[teams] No conversation known for chat %d", chatID)
+		return
+	}
+	if _, err := b.post(ctx, ref, a); err != nil {
+		log.Printf("[teams] Error posting to chat %d: %v", chatID, err)
+	}
+}
+
+// prompt sends text to the chat's session; the reply streams back
+// through SendText and EditText.
+func (b *Bot) prompt(ctx context.Context, chatID int64, text string) {
+	if b.Stream == nil {
+		b.reply(ctx, chatID, "OpenCode client not available")
+		return
+	}
+	if _, streaming, _ := b.Stream.CurrentText(chatID); streaming {
+		b.reply(ctx, chatID, "Still working on the previous prompt. Send **abort** to stop it.")
+		return
+	}
+	sess, err := b.session(ctx, chatID)
+	if err != nil {
+		log.Printf("[teams] Error creating session for chat %d: %v", chatID, err)
+		b.reply(ctx, chatID, "Failed to create session: "+err.Error())
+		return
+	}
+	if ref, ok := b.ref(chatID); ok {
+		if _, err := b.post(ctx, ref, activity{Type: "typing"}); err != nil {
+			log.Printf("[teams] Error sending typing indicator: %v", err)
+		}
+	}
+
+	b.Stream.RegisterSession(sess.SessionID, chatID, 0)
+	providerID, modelID := sess.ModelProvider, sess.ModelID
+	if providerID == "" || modelID == "" {
+		providerID, modelID = b.defaultProvider, b.defaultModel
+	}
+	agent := sess.Agent
+	if agent == "" {
+		agent = b.cfg.DefaultAgent
+	}
+	opts := opencode.PromptOptions{Agent: agent, ProviderID: providerID, ModelID: modelID}
+	if err := b.Client.PromptAsync(ctx, sess.SessionID, text, opts); err != nil {
+		log.Printf("[teams] Error sending prompt: %v", err)
+		b.Stream.UnregisterSession(sess.SessionID)
+		b.reply(ctx, chatID, "Failed to send prompt: "+err.Error())
+	}
+}
+
+// session returns the chat's session, creating an OpenCode session when
+// there is none. A stored model or agent choice is kept.
+func (b *Bot) session(ctx context.Context, chatID int64) (store.Session, error) {
+	sess, err := b.DB.GetSession(chatID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return store.Session{}, err
+	}
+	if err == nil && sess.SessionID != "" {
+		if err := b.DB.IncrementCount(chatID); err != nil {
+			log.Printf("[teams] Error counting message: %v", err)
+		}
+		return sess, nil
+	}
+
+	oc, err := b.Client.CreateOCSession(ctx, "Teams Chat", b.cfg.DefaultDir())
+	if err != nil {
+		return store.Session{}, err
+	}
+	if !b.cfg.DirAllowed(oc.Directory) {
+		if err := b.Client.DeleteOCSession(ctx, oc.ID); err != nil {
+			log.Printf("[teams] Error deleting refused session: %v", err)
+		}
+		return store.Session{}, fmt.Errorf("the server placed it in %s, outside the allowed directories", oc.Directory)
+	}
+	now := time.Now()
+	sess.ChatID, sess.SessionID, sess.Title = chatID, oc.ID, oc.Title
+	sess.MessageCount, sess.CreatedAt, sess.LastUsed = 1, now, now
+	if err := b.DB.SetSession(sess); err != nil {
+		log.Printf("[teams] Error saving session: %v", err)
+	}
+	return sess, nil
+}
+
+// newSession makes the next prompt start a new session, keeping the
+// chat's model and agent.
+func (b *Bot) newSession(ctx context.Context, chatID int64) {
+	sess, err := b.DB.GetSession(chatID)
+	if errors.Is(err, store.ErrNotFound) {
+		b.reply(ctx, chatID, "The next message starts a new session.")
+		return
+	}
+	if err == nil {
+		sess.SessionID, sess.Title, sess.MessageCount = "", "", 0
+		err = b.DB.SetSession(sess)
+	}
+	if err != nil {
+		log.Printf("[teams] Error resetting session: %v", err)
+		b.reply(ctx, chatID, "Failed to reset session")
+		return
+	}
+	b.reply(ctx, chatID, "The next message starts a new session.")
+}
+
+func (b *Bot) sessions(ctx context.Context, chatID int64) {
+	all, err := b.Client.ListOCSessions(ctx)
+	if err != nil {
+		log.Printf("[teams] Error listing sessions: %v", err)
+		b.reply(ctx, chatID, "Failed to list sessions")
+		return
+	}
+	var sessions []opencode.OCSession
+	for _, s := range all {
+		if b.cfg.DirAllowed(s.Directory) {
+			sessions = append(sessions, s)
+		}
+	}
+	if len(sessions) == 0 {
+		b.reply(ctx, chatID, "No sessions found")
+		return
+	}
+	total := len(sessions)
+	if total > b.cfg.SessionListLimit {
+		sessions = sessions[:b.cfg.SessionListLimit]
+	}
+	current := ""
+	if sess, err := b.DB.GetSession(chatID); err == nil {
+		current = sess.SessionID
+	}
+	b.send(ctx, chatID, activity{Type: "message", Attachments: []attachment{sessionsCard(sessions, total, current)}})
+}
+
+func (b *Bot) models(ctx context.Context, chatID int64) {
+	providers, err := b.Client.GetProviders(ctx)
+	if err != nil {
+		log.Printf("[teams] Error listing models: %v", err)
+		b.reply(ctx, chatID, "Failed to list models")
+		return
+	}
+	current := ""
+	if sess, err := b.DB.GetSession(chatID); err == nil && sess.ModelID != "" {
+		current = sess.ModelProvider + "/" + sess.ModelID
+	}
+	b.send(ctx, chatID, activity{Type: "message", Attachments: []attachment{modelsCard(providers, current)}})
+}
+
+func (b *Bot) abort(ctx context.Context, chatID int64) {
+	sess, err := b.DB.GetSession(chatID)
+	if err != nil || sess.SessionID == "" {
+		b.reply(ctx, chatID, "No active session")
+		return
+	}
+	if err := b.Client.Abort(ctx, sess.SessionID); err != nil {
+		log.Printf("[teams] Error aborting session: %v", err)
+		b.reply(ctx, chatID, "Failed to abort: "+err.Error())
+		return
+	}
+	b.reply(ctx, chatID, "Aborted")
+}
+
+// handleSubmit applies a card's submit action.
+func (b *Bot) handleSubmit(ctx context.Context, chatID int64, a activity, s submit) {
+	switch s.Action {
+	case submitSession:
+		b.switchSession(ctx, chatID, s.Session)
+	case submitModel:
+		b.setModel(ctx, chatID, s.Model)
+	case submitPermission:
+		b.answerPermission(ctx, chatID, a, s)
+	}
+}
+
+func (b *Bot) switchSession(ctx context.Context, chatID int64, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	oc, err := b.Client.GetOCSession(ctx, sessionID)
+	if err != nil {
+		b.reply(ctx, chatID, "Session not found")
+		return
+	}
+	if !b.cfg.DirAllowed(oc.Directory) {
+		log.Printf("Warning: chat %d refused session %s in %q, outside ALLOWED_DIRS", chatID, oc.ID, oc.Directory)
+		b.reply(ctx, chatID, "That session runs outside the allowed directories")
+		return
+	}
+	if b.Locked != nil && b.Locked(oc.ID) {
+		log.Printf("Warning: chat %d refused session %s, it is locked", chatID, oc.ID)
+		b.reply(ctx, chatID, "🔒 That session is locked. Open it in Telegram with /unlock.")
+		return
+	}
+	sess, err := b.DB.GetSession(chatID)
+	if err != nil {
+		sess = store.Session{ChatID: chatID, CreatedAt: time.Now()}
+	}
+	sess.SessionID, sess.Title, sess.LastUsed = oc.ID, oc.Title, time.Now()
+	if err := b.DB.SetSession(sess); err != nil {
+		log.Printf("[teams] Error saving session: %v", err)
+		b.reply(ctx, chatID, "Failed to save session")
+		return
+	}
+	title := oc.Title
+	if title == "" {
+		title = oc.ID
+	}
+	b.reply(ctx, chatID, "Switched to session: "+title)
+}
+
+func (b *Bot) setModel(ctx context.Context, chatID int64, ref string) {
+	providerID, modelID := "", ""
+	if ref != defaultModel {
+		var ok bool
+		if providerID, modelID, ok = config.SplitModel(ref); !ok {
+			return
+		}
+	}
+	sess, err := b.DB.GetSession(chatID)
+	if err != nil {
+		sess = store.Session{ChatID: chatID, CreatedAt: time.Now()}
+	}
+	sess.ModelProvider, sess.ModelID, sess.LastUsed = providerID, modelID, time.Now()
+	if err := b.DB.SetSession(sess); err != nil {
+		log.Printf("[teams] Error saving model: %v", err)
+		b.reply(ctx, chatID, "Failed to save model")
+		return
+	}
+	if ref == defaultModel {
+		b.reply(ctx, chatID, "Using the default model")
+		return
+	}
+	b.reply(ctx, chatID, "Model set to: "+ref)
+}
@@ -16,26 +16,6 @@ func defaultAgents() map[string]string {
 	return map[string]string{}
 }
 
-func parseAgents(raw string) map[string]string {
-	agents := make(map[string]string)
-	for _, pair := range strings.Split(raw, ",") {
-		pair = strings.TrimSpace(pair)
-		if pair == "" {
-			continue
-		}
-		parts := strings.SplitN(pair, ":", 2)
-		name := strings.TrimSpace(parts[0])
-		desc := name
-		if len(parts) == 2 {
-			desc = strings.TrimSpace(parts[1])
-		}
-		if name != "" {
-			agents[name] = desc
-		}
-	}
-	return agents
-}
-
 func (b *Bot) agentCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
 	if update.Message == nil {
 		return
@@ -45,7 +25,8 @@ func (b *Bot) agentCommand(ctx context.Context, tgBot *bot.Bot, update *models.U
 		return
 	}
 
-	if len(b.Agents) == 0 {
+	agents := b.agents()
+	if len(agents) == 0 {
 		tgBot.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
 			Text:   "No agents configured. Set AGENTS env var or install an OpenCode plugin that provides agents.",
@@ -58,7 +39,7 @@ func (b *Bot) agentCommand(ctx context.Context, tgBot *bot.Bot, update *models.U
 	// Direct agent set: /agent <name>
 	if len(parts) >= 2 {
 		agentName := parts[1]
-		if _, ok := b.Agents[agentName]; !ok {
+		if _, ok := agents[agentName]; !ok {
 			tgBot.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: chatID,
 				Text:   fmt.Sprintf("Unknown agent: %s", agentName),
@@ -71,19 +52,20 @@ func (b *Bot) agentCommand(ctx context.Context, tgBot *bot.Bot, update *models.U
 
 	// Show agent selection keyboard
 	var keyboard [][]models.InlineKeyboardButton
-	for name, desc := range b.Agents {
+	for name, desc := range agents {
 		keyboard = append(keyboard, []models.InlineKeyboardButton{
 			{Text: fmt.Sprintf("%s - %s", name, desc), CallbackData: "agent_" + name},
 		})
 	}
 
-	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+	msg, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
 		Text:   "Select an agent:",
 		ReplyMarkup: &models.InlineKeyboardMarkup{
 			InlineKeyboard: keyboard,
 		},
 	})
+	b.track(chatID, msg)
 }
 
 func (b *Bot) setAgent(ctx context.Context, tgBot *bot.Bot, chatID int64, agentName string) {
@@ -104,7 +86,7 @@ func (b *Bot) setAgent(ctx context.Context, tgBot *bot.Bot, chatID int64, agentN
 		}
 	}
 
-	desc := b.Agents[agentName]
+	desc := b.agents()[agentName]
 	tgBot.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
 		Text:   fmt.Sprintf("Agent set to: %s (%s)", agentName, desc),
@@ -114,7 +96,7 @@ func (b *Bot) setAgent(ctx context.Context, tgBot *bot.Bot, chatID int64, agentN
 func (b *Bot) handleAgentCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, agentName string) {
 	chatID := callback.Message.Message.Chat.ID
 
-	if _, ok := b.Agents[agentName]; !ok {
+	if _, ok := b.agents()[agentName]; !ok {
 		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 			CallbackQueryID: callback.ID,
 			Text:            "Unknown agent",
@@ -138,7 +120,7 @@ func (b *Bot) handleAgentCallback(ctx context.Context, tgBot *bot.Bot, callback
 		}
 	}
 
-	desc := b.Agents[agentName]
+	desc := b.agents()[agentName]
 	tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 		CallbackQueryID: callback.ID,
 		Text:            "Agent: " + agentName,
@@ -0,0 +1,367 @@
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+)
+
+// The chat API lets other tools send prompts as a Telegram user: POST
+// /v1/chat with a bearer token from API_TOKENS goes through the same
+// allowlist, rate limit and session as that user's messages, and the
+// reply streams back as server-sent events instead of into Telegram.
+//
+// Events: "session" once, then "text" (the reply so far) and "status"
+// (e.g. "Running bash...") as it streams, and finally "done" with the
+// full reply or "error".
+
+var apiRequests = metrics.NewCounter("openkh_api_requests_total",
+	"Chat API requests, by result.", "result")
+
+const (
+	maxAPIBody      = 1 << 20
+	apiPingInterval = 15 * time.Second
+)
+
+type apiChatRequest struct {
+	Prompt string `json:"prompt"`
+	Agent  string `json:"agent,omitempty"` // overrides the session's agent
+	Model  string `json:"model,omitempty"` // "provider/model", overrides the session's model
+}
+
+// apiStreams holds the chats whose reply currently streams to a chat API
+// request rather than to Telegram.
+type apiStreams struct {
+	mu    sync.Mutex
+	sinks map[int64]*apiSink
+}
+
+// apiSink receives one chat's stream updates for an API request. Only
+// the latest text is kept; the request goroutine picks it up.
+type apiSink struct {
+	mu       sync.Mutex
+	display  string
+	finished bool
+	changed  chan struct{}
+	done     chan struct{}
+}
+
+// open registers a sink for chatID, or reports false if one is open.
+func (a *apiStreams) open(chatID int64) (*apiSink, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sinks[chatID] != nil {
+		return nil, false
+	}
+	if a.sinks == nil {
+		a.sinks = make(map[int64]*apiSink)
+	}
+	s := &apiSink{changed: make(chan struct{}, 1), done: make(chan struct{})}
+	a.sinks[chatID] = s
+	return s, true
+}
+
+func (a *apiStreams) get(chatID int64) *apiSink {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sinks[chatID]
+}
+
+func (a *apiStreams) close(chatID int64, s *apiSink) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sinks[chatID] == s {
+		delete(a.sinks, chatID)
+	}
+}
+
+func (s *apiSink) update(display string) {
+	s.mu.Lock()
+	s.display = display
+	s.mu.Unlock()
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *apiSink) latest() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.display
+}
+
+func (s *apiSink) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.finished {
+		s.finished = true
+		close(s.done)
+	}
+}
+
+// apiRouter is the stream's MessageSender: chats with an open API request
+// get their updates there, all others go on to Telegram.
+type apiRouter struct {
+	b    *Bot
+	next opencode.MessageSender
+}
+
+// RouteAPI wraps the stream's sender so replies to chat API requests are
+// diverted from Telegram to the request.
+func (b *Bot) RouteAPI(next opencode.MessageSender) opencode.MessageSender {
+	return &apiRouter{b: b, next: next}
+}
+
+func (r *apiRouter) SendText(chatID int64, text string) (int, error) {
+	if s := r.b.api.get(chatID); s != nil {
+		s.update(text)
+		return 0, nil
+	}
+	return r.next.SendText(chatID, text)
+}
+
+func (r *apiRouter) EditText(chatID int64, messageID int, text string) error {
+	if s := r.b.api.get(chatID); s != nil {
+		s.update(text)
+		return nil
+	}
+	return r.next.EditText(chatID, messageID, text)
+}
+
+func (r *apiRouter) EditFinalText(chatID int64, messageID int, text string) error {
+	if s := r.b.api.get(chatID); s != nil {
+		s.update(text)
+		return nil
+	}
+	if fe, ok := r.next.(opencode.FinalEditor); ok {
+		return fe.EditFinalText(chatID, messageID, text)
+	}
+	return r.next.EditText(chatID, messageID, text)
+}
+
+func (r *apiRouter) FormatsFinal(chatID int64, text string) bool {
+	if r.b.api.get(chatID) != nil {
+		return false
+	}
+	ff, ok := r.next.(opencode.FinalFormatter)
+	return ok && ff.FormatsFinal(chatID, text)
+}
+
+// APIHandler serves the chat API on /v1/chat.
+func (b *Bot) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat", b.serveChat)
+	return mux
+}
+
+func (b *Bot) serveChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, "method", http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	chatID, ok := b.apiUser(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="openkh"`)
+		apiError(w, "unauthorized", http.StatusUnauthorized, "missing or unknown bearer token")
+		return
+	}
+	if !b.checkAuth(chatID) {
+		apiError(w, "forbidden", http.StatusForbidden, "this user is not allowed to use the bot")
+		return
+	}
+	var req apiChatRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody)).Decode(&req); err != nil || strings.TrimSpace(req.Prompt) == "" {
+		apiError(w, "bad_request", http.StatusBadRequest, `expected a JSON body with a non-empty "prompt"`)
+		return
+	}
+	if req.Model != "" {
+		if _, _, ok := config.SplitModel(req.Model); !ok {
+			apiError(w, "bad_request", http.StatusBadRequest, `"model" must be provider/model`)
+			return
+		}
+	}
+	if b.Client == nil || b.Stream == nil {
+		apiError(w, "unavailable", http.StatusServiceUnavailable, "OpenCode client not available")
+		return
+	}
+	if !b.allowMessage(chatID) {
+		w.Header().Set("Retry-After", fmt.Sprint(int(rateLimitDuration.Seconds())))
+		apiError(w, "rate_limited", http.StatusTooManyRequests, "too many requests, slow down")
+		return
+	}
+	if _, streaming, _ := b.Stream.CurrentText(chatID); streaming {
+		apiError(w, "busy", http.StatusConflict, "a reply is already streaming for this user")
+		return
+	}
+	if refusal := b.runLimitRefusal(chatID, chatID); refusal != "" {
+		apiError(w, "busy", http.StatusTooManyRequests, refusal)
+		return
+	}
+	sink, ok := b.api.open(chatID)
+	if !ok {
+		apiError(w, "busy", http.StatusConflict, "a reply is already streaming for this user")
+		return
+	}
+	defer b.api.close(chatID, sink)
+
+	sess, refusal, err := b.promptSession(r.Context(), chatID)
+	if err != nil {
+		log.Printf("[serveChat] Error creating session for %d: %v", chatID, err)
+		apiError(w, "error", http.StatusBadGateway, "failed to create session: "+err.Error())
+		return
+	}
+	if refusal != "" {
+		apiError(w, "forbidden", http.StatusForbidden, refusal)
+		return
+	}
+	if sess.SessionID == "" {
+		apiError(w, "unavailable", http.StatusServiceUnavailable, "OpenCode client not available")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiError(w, "error", http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	agent, providerID, modelID := b.promptAgent(chatID, sess.SessionID, sess.Agent), sess.ModelProvider, sess.ModelID
+	if req.Agent != "" {
+		agent = req.Agent
+	}
+	if req.Model != "" {
+		providerID, modelID, _ = config.SplitModel(req.Model)
+	}
+	agent, providerID, modelID = b.withDefaults(agent, providerID, modelID)
+
+	if !b.admit(chatID, nil) {
+		w.Header().Set("Retry-After", "30")
+		apiError(w, "busy", http.StatusServiceUnavailable, "the server is running as many prompts as it takes; try again shortly")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	writeSSE(w, "session", map[string]string{"session_id": sess.SessionID, "title": sess.Title, "agent": agent})
+	flusher.Flush()
+
+	started := time.Now()
+	b.Stream.RegisterSession(sess.SessionID, chatID, 0)
+	b.promptStarted()
+	opts := opencode.PromptOptions{
+		Agent:      agent,
+		ProviderID: providerID,
+		ModelID:    modelID,
+		System:     b.systemPrompt(chatID, sess.SessionID),
+	}
+	if err := b.Client.PromptAsync(r.Context(), sess.SessionID, req.Prompt, opts); err != nil {
+		log.Printf("[serveChat] Error sending prompt: %v", err)
+		b.Stream.UnregisterSession(sess.SessionID)
+		writeSSE(w, "error", map[string]string{"error": err.Error()})
+		apiRequests.Inc("error")
+		return
+	}
+
+	ping := time.NewTicker(apiPingInterval)
+	defer ping.Stop()
+	var text, status string
+	for {
+		select {
+		case <-sink.changed:
+			t, st := b.apiProgress(chatID, sink.latest())
+			if t != text {
+				writeSSE(w, "text", map[string]string{"text": t})
+				text = t
+			}
+			if st != status {
+				writeSSE(w, "status", map[string]string{"status": st})
+				status = st
+			}
+			flusher.Flush()
+		case <-sink.done:
+			writeSSE(w, "done", map[string]string{"session_id": sess.SessionID, "text": b.apiReply(chatID, sess.SessionID, started, sink.latest())})
+			flusher.Flush()
+			apiRequests.Inc("ok")
+			return
+		case <-ping.C:
+			io.WriteString(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			log.Printf("[serveChat] Client of %d went away, aborting session %s", chatID, sess.SessionID)
+			if err := b.Client.Abort(context.Background(), sess.SessionID); err != nil {
+				log.Printf("[serveChat] Error aborting session: %v", err)
+			}
+			b.Stream.UnregisterSession(sess.SessionID)
+			apiRequests.Inc("aborted")
+			return
+		}
+	}
+}
+
+// apiUser returns the user an API request acts as, from its bearer token.
+func (b *Bot) apiUser(r *http.Request) (int64, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" || b.Config == nil {
+		return 0, false
+	}
+	var userID int64
+	found := false
+	for t, id := range b.Config.APITokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			userID, found = id, true
+		}
+	}
+	return userID, found
+}
+
+// apiProgress splits what the stream would show in Telegram into the
+// reply so far and its status line.
+func (b *Bot) apiProgress(chatID int64, display string) (text, status string) {
+	text, streaming, _ := b.Stream.CurrentText(chatID)
+	switch {
+	case !streaming:
+		return display, "" // the final edit carries no status
+	case text == "":
+		return "", display
+	}
+	if rest, ok := strings.CutPrefix(display, text+"\n\n"); ok {
+		return text, rest
+	}
+	return text, ""
+}
+
+// apiReply returns the finished reply: the message cache holds it in full
+// even when the stream had to cap it.
+func (b *Bot) apiReply(chatID int64, sessionID string, since time.Time, fallback string) string {
+	if b.DB == nil {
+		return fallback
+	}
+	msg, err := b.DB.GetMessageText(chatID)
+	if err != nil || msg.SessionID != sessionID || msg.UpdatedAt.Before(since.Truncate(time.Second)) {
+		return fallback
+	}
+	return msg.Text
+}
+
+func writeSSE(w io.Writer, event string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+func apiError(w http.ResponseWriter, result string, status int, msg string) {
+	apiRequests.Inc(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
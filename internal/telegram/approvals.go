@@ -0,0 +1,246 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// toolPolicyKey is the chat setting holding the chat's /approvals
+// overrides, as "tool=mode" pairs.
+const toolPolicyKey = "tool.policy"
+
+// approvalTTL is how long a tool waits for the chat to answer.
+const approvalTTL = 10 * time.Minute
+
+// strictness orders the approval modes; non-admin chats may only move a
+// tool to a stricter mode than their role's policy.
+var strictness = map[string]int{config.ToolAllow: 0, config.ToolSession: 1, config.ToolAsk: 2}
+
+// rolePolicy returns the configured policy for the chat's role.
+func (b *Bot) rolePolicy(chatID int64) map[string]string {
+	if b.Config == nil {
+		return nil
+	}
+	if b.isAdmin(chatID) {
+		return b.Config.AdminToolPolicy
+	}
+	return b.Config.ToolPolicy
+}
+
+// chatPolicy returns the chat's own overrides.
+func (b *Bot) chatPolicy(chatID int64) map[string]string {
+	if b.DB == nil {
+		return nil
+	}
+	raw, err := b.DB.GetChatSetting(chatID, toolPolicyKey)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[chatPolicy] Chat %d: %v", chatID, err)
+		}
+		return nil
+	}
+	policy, err := config.ParseToolPolicy(raw, nil)
+	if err != nil {
+		log.Printf("[chatPolicy] Chat %d has a bad policy %q: %v", chatID, raw, err)
+		return nil
+	}
+	return policy
+}
+
+// roleMode is tool's mode under the chat role's policy alone.
+func (b *Bot) roleMode(chatID int64, tool string) string {
+	if mode, ok := b.rolePolicy(chatID)[tool]; ok {
+		return mode
+	}
+	return config.ToolAsk
+}
+
+// toolMode is how tool's permission requests are handled in chatID.
+func (b *Bot) toolMode(chatID int64, tool string) string {
+	mode := b.roleMode(chatID, tool)
+	override, ok := b.chatPolicy(chatID)[tool]
+	if !ok {
+		return mode
+	}
+	if b.isAdmin(chatID) || strictness[override] > strictness[mode] {
+		return override
+	}
+	return mode
+}
+
+// handlePermission applies the chat's tool policy to a permission
+// request: approve it, or ask the chat with buttons.
+func (b *Bot) handlePermission(ctx context.Context, tgBot *bot.Bot, chatID int64, p opencode.Permission) {
+	mode := b.toolMode(chatID, p.Type)
+	if mode == config.ToolAllow {
+		log.Printf("[handlePermission] Chat %d: auto-approving %s in session %s", chatID, p.Type, p.SessionID)
+		if err := b.Client.RespondPermission(ctx, p.SessionID, p.ID, opencode.PermissionOnce); err != nil {
+			log.Printf("[handlePermission] Chat %d: %v", chatID, err)
+		}
+		return
+	}
+
+	allow := models.InlineKeyboardButton{Text: "✅ Allow once", CallbackData: pendingCallbackPrefix + opencode.PermissionOnce}
+	if mode == config.ToolSession {
+		allow = models.InlineKeyboardButton{Text: "✅ Allow for this session", CallbackData: pendingCallbackPrefix + opencode.PermissionAlways}
+	}
+	text := fmt.Sprintf("🔐 %s wants to run", p.Type)
+	if p.Title != "" {
+		text += ":\n" + p.Title
+	}
+	msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   b.truncate(text),
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+			allow,
+			{Text: "⛔ Reject", CallbackData: pendingCallbackPrefix + opencode.PermissionReject},
+		}}},
+	})
+	if err != nil {
+		log.Printf("[handlePermission] Chat %d: %v", chatID, err)
+		return
+	}
+	b.track(chatID, msg)
+	payload := strings.Join([]string{p.SessionID, p.ID, strconv.Itoa(msg.ID), p.Type}, " ")
+	if err := b.awaitInput(chatID, "permission", payload, approvalTTL); err != nil {
+		log.Printf("[handlePermission] Error saving pending action: %v", err)
+	}
+}
+
+// continueApproval answers a permission request from its buttons. Text
+// sent meanwhile doesn't go to the session, which is waiting on the tool.
+func (b *Bot) continueApproval(ctx context.Context, tgBot *bot.Bot, chatID int64, action store.PendingAction, input string) {
+	fields := strings.Fields(action.Payload)
+	if len(fields) != 4 {
+		log.Printf("[continueApproval] Bad payload %q", action.Payload)
+		return
+	}
+	sessionID, permissionID, tool := fields[0], fields[1], fields[3]
+	promptID, _ := strconv.Atoi(fields[2])
+
+	var result string
+	switch input {
+	case opencode.PermissionOnce:
+		result = "✅ Allowed once"
+	case opencode.PermissionAlways:
+		result = "✅ Allowed for this session"
+	case opencode.PermissionReject:
+		result = "⛔ Rejected"
+	default:
+		if err := b.awaitInput(chatID, action.Type, action.Payload, time.Until(action.ExpiresAt)); err != nil {
+			log.Printf("[continueApproval] Error saving pending action: %v", err)
+		}
+		msg, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "The session is waiting for you to allow or reject " + tool + " above, or /stop it.",
+		})
+		b.track(chatID, msg)
+		return
+	}
+
+	if err := b.Client.RespondPermission(ctx, sessionID, permissionID, input); err != nil {
+		log.Printf("[continueApproval] Chat %d: %v", chatID, err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to answer the request: " + err.Error()})
+		return
+	}
+	log.Printf("[continueApproval] Chat %d answered %s for %s in session %s", chatID, input, tool, sessionID)
+	tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    chatID,
+		MessageID: promptID,
+		Text:      fmt.Sprintf("%s: %s", result, tool),
+	})
+}
+
+// approvalsCommand shows the chat's effective tool policy, or sets an
+// override: /approvals <tool> <allow|session|ask|default>.
+func (b *Bot) approvalsCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	args := strings.Fields(strings.TrimPrefix(update.Message.Text, "/approvals"))
+	if len(args) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(b.approvalsSummary(chatID))})
+		return
+	}
+	if len(args) != 2 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /approvals <tool> <allow|session|ask|default>"})
+		return
+	}
+
+	tool, mode := strings.ToLower(args[0]), strings.ToLower(args[1])
+	policy := b.chatPolicy(chatID)
+	if policy == nil {
+		policy = make(map[string]string)
+	}
+	switch mode {
+	case "default":
+		delete(policy, tool)
+	case config.ToolAllow, config.ToolSession, config.ToolAsk:
+		if floor := b.roleMode(chatID, tool); !b.isAdmin(chatID) && strictness[mode] < strictness[floor] {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: chatID,
+				Text:   fmt.Sprintf("Only admins can relax %s below %q.", tool, floor),
+			})
+			return
+		}
+		policy[tool] = mode
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Mode must be allow, session, ask or default"})
+		return
+	}
+
+	if err := b.DB.SetChatSetting(chatID, toolPolicyKey, config.FormatToolPolicy(policy)); err != nil {
+		log.Printf("[approvalsCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: fmt.Sprintf("%s: %s", tool, b.toolMode(chatID, tool))})
+}
+
+func (b *Bot) approvalsSummary(chatID int64) string {
+	tools := make(map[string]bool)
+	for tool := range b.rolePolicy(chatID) {
+		tools[tool] = true
+	}
+	overrides := b.chatPolicy(chatID)
+	for tool := range overrides {
+		tools[tool] = true
+	}
+	names := make([]string, 0, len(tools))
+	for tool := range tools {
+		names = append(names, tool)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("Tool approvals\n\n")
+	for _, tool := range names {
+		line := tool + ": " + b.toolMode(chatID, tool)
+		if _, ok := overrides[tool]; ok {
+			line += " (this chat)"
+		}
+		if setting, _ := b.toolDenial(chatID, tool); setting != "" {
+			line = tool + ": denied by " + setting
+		}
+		sb.WriteString(line + "\n")
+	}
+	sb.WriteString("other tools: ask\n\nallow = approve silently, session = ask once per session, ask = ask every time.\nUse /approvals <tool> <mode|default> to change one for this chat.")
+	return sb.String()
+}
@@ -0,0 +1,115 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// unarchivePrefix + session ID is the callback data of an Unarchive
+// button under /sessions all.
+const unarchivePrefix = "unarchive_"
+
+// archivedSessions returns the IDs of the archived sessions.
+func (b *Bot) archivedSessions() map[string]bool {
+	archived := make(map[string]bool)
+	if b.DB == nil {
+		return archived
+	}
+	list, err := b.DB.ListArchivedSessions()
+	if err != nil {
+		log.Printf("[archivedSessions] Error: %v", err)
+		return archived
+	}
+	for _, a := range list {
+		archived[a.SessionID] = true
+	}
+	return archived
+}
+
+// archiveIdleSessions archives the sessions not updated for ARCHIVE_AFTER,
+// except those chats are on, and stops streaming them. It runs as a
+// scheduler job.
+func (b *Bot) archiveIdleSessions(ctx context.Context) error {
+	sessions, err := b.Client.ListOCSessions(ctx)
+	if err != nil {
+		return fmt.Errorf("list sessions: %w", err)
+	}
+	chats, err := b.chatSessions()
+	if err != nil {
+		return fmt.Errorf("list chats: %w", err)
+	}
+	current := make(map[string]bool, len(chats))
+	for _, s := range chats {
+		current[s.SessionID] = true
+	}
+	archived := b.archivedSessions()
+	cutoff := time.Now().Add(-b.Config.ArchiveAfter)
+
+	n := 0
+	for _, s := range sessions {
+		if archived[s.ID] || current[s.ID] || s.Time.Updated == 0 || time.UnixMilli(s.Time.Updated).After(cutoff) {
+			continue
+		}
+		if err := b.DB.ArchiveSession(store.ArchivedSession{SessionID: s.ID, Title: s.Title, ArchivedAt: time.Now()}); err != nil {
+			return fmt.Errorf("archive session %s: %w", s.ID, err)
+		}
+		if b.Stream != nil {
+			b.Stream.ForgetSession(s.ID)
+		}
+		n++
+	}
+	if n > 0 {
+		log.Printf("[archiver] Archived %d idle session(s)", n)
+	}
+	return nil
+}
+
+// unarchive takes the session out of the archive, e.g. when a chat
+// switches to it.
+func (b *Bot) unarchive(sessionID string) {
+	if b.DB == nil {
+		return
+	}
+	if err := b.DB.UnarchiveSession(sessionID); err != nil {
+		log.Printf("[unarchive] Session %s: %v", sessionID, err)
+	}
+}
+
+// handleUnarchiveCallback unarchives the session an Unarchive button is
+// for and drops the button.
+func (b *Bot) handleUnarchiveCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, sessionID string) {
+	if err := b.DB.UnarchiveSession(sessionID); err != nil {
+		log.Printf("[handleUnarchiveCallback] Error: %v", err)
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: "Failed to unarchive the session"})
+		return
+	}
+	tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: "Unarchived " + shortID(sessionID)})
+
+	markup := callback.Message.Message.ReplyMarkup
+	if markup == nil {
+		return
+	}
+	var keyboard [][]models.InlineKeyboardButton
+	for _, row := range markup.InlineKeyboard {
+		var kept []models.InlineKeyboardButton
+		for _, button := range row {
+			if button.CallbackData != callback.Data {
+				kept = append(kept, button)
+			}
+		}
+		if len(kept) > 0 {
+			keyboard = append(keyboard, kept)
+		}
+	}
+	tgBot.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+		ChatID:      chatID,
+		MessageID:   callback.Message.Message.ID,
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+}
@@ -0,0 +1,229 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// autocommitPrefix + session ID is the chat setting that turns auto-commit
+// on for a session. It holds a fingerprint of the session's changes at the
+// last commit, so runs that changed nothing aren't committed.
+const autocommitPrefix = "autocommit."
+
+// commitSubjectLen caps the commit subject taken from the prompt.
+const commitSubjectLen = 72
+
+// maxCommitBody caps the prompt quoted in the commit message body.
+const maxCommitBody = 2000
+
+var commitHash = regexp.MustCompile(`(?m)^[0-9a-f]{7,40}$`)
+
+var autocommits = metrics.NewCounter("openkh_autocommits_total",
+	"Runs committed by /autocommit, by result.", "result")
+
+// autocommitCommand turns auto-commit on or off for the current session:
+// while on, each run that changes files is committed in the session's
+// directory with a message taken from the prompt.
+func (b *Bot) autocommitCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+	key := autocommitPrefix + sessionID
+	on := b.chatSetting(chatID, key) != ""
+
+	switch arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/autocommit")); arg {
+	case "":
+		state := "off"
+		if on {
+			state = "on"
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Auto-commit is " + state + " for this session. Usage: /autocommit on|off"})
+	case "on":
+		if setting, _ := b.toolDenial(chatID, "bash"); setting != "" {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Auto-commit runs git through the shell, which " + setting + " denies"})
+			return
+		}
+		// Changes made before now are left for the next run's commit.
+		fingerprint, err := b.changesFingerprint(ctx, sessionID)
+		if err != nil {
+			log.Printf("[autocommitCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get the session's changes"})
+			return
+		}
+		if err := b.DB.SetChatSetting(chatID, key, fingerprint); err != nil {
+			log.Printf("[autocommitCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Auto-commit on: runs that change files are committed in the session's directory, and the commit is noted under the reply."})
+	case "off":
+		if err := b.DB.SetChatSetting(chatID, key, ""); err != nil {
+			log.Printf("[autocommitCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Auto-commit off for this session"})
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /autocommit on|off"})
+	}
+}
+
+// changesFingerprint identifies the session's file changes; it is "-" for
+// a session without any, so it is never the empty "off" value.
+func (b *Bot) changesFingerprint(ctx context.Context, sessionID string) (string, error) {
+	diffs, err := b.Client.GetFileDiffs(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	if len(diffs) == 0 {
+		return "-", nil
+	}
+	h := fnv.New64a()
+	for _, d := range diffs {
+		fmt.Fprintf(h, "%s\x00%s\x00", d.File, d.After)
+	}
+	return fmt.Sprintf("%x", h.Sum64()), nil
+}
+
+// autocommit commits the run that wrote reply messageID if auto-commit is
+// on for its session and the run changed files, and notes the commit
+// under the reply. It runs on the completion hook's goroutine.
+func (b *Bot) autocommit(ctx context.Context, tgBot *bot.Bot, chatID int64, messageID int) {
+	if b.DB == nil || b.Client == nil {
+		return
+	}
+	cached, err := b.DB.GetMessageText(chatID)
+	sessionID := cached.SessionID
+	if err != nil || cached.MessageID != messageID {
+		sessionID = b.currentSessionID(chatID)
+	}
+	key := autocommitPrefix + sessionID
+	last := b.chatSetting(chatID, key)
+	if sessionID == "" || last == "" {
+		return
+	}
+	fingerprint, err := b.changesFingerprint(ctx, sessionID)
+	if err != nil {
+		log.Printf("[autocommit] Chat %d: %v", chatID, err)
+		return
+	}
+	if fingerprint == last {
+		return
+	}
+	if setting, _ := b.toolDenial(chatID, "bash"); setting != "" {
+		b.appendToReply(ctx, tgBot, chatID, messageID, cached, "⚠️ Not auto-committed: "+setting+" denies the shell")
+		return
+	}
+
+	message := b.commitMessage(ctx, sessionID)
+	agent, _, _ := b.withDefaults(b.currentAgent(chatID), "", "")
+	if agent == "" {
+		agent = "build"
+	}
+	command := "git add -A && git commit -q -m " + shellQuote(message) + " && git rev-parse --short HEAD"
+	out, err := b.Client.Shell(ctx, sessionID, agent, command)
+	var note string
+	switch hash := commitHash.FindString(out); {
+	case err != nil:
+		log.Printf("[autocommit] Chat %d: %v", chatID, err)
+		autocommits.Inc("error")
+		note = "⚠️ Auto-commit failed: " + err.Error()
+	case hash != "":
+		autocommits.Inc("committed")
+		note = "📌 Committed " + hash
+	case strings.Contains(out, "nothing to commit") || strings.Contains(out, "nothing added to commit"):
+		autocommits.Inc("clean")
+	default:
+		autocommits.Inc("error")
+		note = "⚠️ Auto-commit failed: " + lastLine(out)
+	}
+	if err == nil {
+		if err := b.DB.SetChatSetting(chatID, key, fingerprint); err != nil {
+			log.Printf("[autocommit] Chat %d: %v", chatID, err)
+		}
+	}
+	if note != "" {
+		b.appendToReply(ctx, tgBot, chatID, messageID, cached, note)
+	}
+}
+
+// commitMessage is the run's prompt: its first line as the subject and,
+// if there is more, the whole prompt as the body.
+func (b *Bot) commitMessage(ctx context.Context, sessionID string) string {
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		log.Printf("[commitMessage] Error: %v", err)
+	}
+	prompt := ""
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			prompt = strings.TrimSpace(messages[i].Content)
+			break
+		}
+	}
+	if prompt == "" {
+		return "Changes from OpenCode session " + sessionID
+	}
+	first, rest, _ := strings.Cut(prompt, "\n")
+	subject := strings.Join(strings.Fields(first), " ")
+	if utf8.RuneCountInString(subject) > commitSubjectLen {
+		subject = string([]rune(subject)[:commitSubjectLen-1]) + "…"
+	}
+	if strings.TrimSpace(rest) == "" && subject == first {
+		return subject
+	}
+	if len(prompt) > maxCommitBody {
+		prompt = strings.ToValidUTF8(prompt[:maxCommitBody], "") + "\n[…]"
+	}
+	return subject + "\n\n" + prompt
+}
+
+// appendToReply adds note to the end of the final reply when the cache
+// holds the reply's whole text and the note fits, or else sends it as an
+// answer to the reply.
+func (b *Bot) appendToReply(ctx context.Context, tgBot *bot.Bot, chatID int64, messageID int, cached store.CachedMessage, note string) {
+	calls := b.latestTools(ctx, chatID)
+	text := cached.Text + "\n\n" + note + opencode.ToolFooter(len(calls))
+	if cached.MessageID == messageID && cached.Text != "" && b.truncate(text) == text {
+		_, err := tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:      chatID,
+			MessageID:   messageID,
+			Text:        text,
+			ReplyMarkup: b.replyMarkup(messageID, false, firstToolID(calls)),
+		})
+		if err == nil {
+			return
+		}
+		log.Printf("[appendToReply] Chat %d: %v", chatID, err)
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          chatID,
+		Text:            note,
+		ReplyParameters: &models.ReplyParameters{MessageID: messageID},
+	})
+}
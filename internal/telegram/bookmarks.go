@@ -0,0 +1,225 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// maxSavedListed caps the bookmarks shown by /saved.
+	maxSavedListed = 20
+	// bookmarkTitleLen is how much of a bookmark's first line /saved shows.
+	bookmarkTitleLen = 50
+)
+
+// setReplyButtons puts the Save toggle under a final reply and, if expand
+// is the ID of the reply's first tool call, the Expand button.
+func (b *Bot) setReplyButtons(ctx context.Context, tgBot *bot.Bot, chatID int64, messageID int, saved bool, expand string) {
+	_, err := tgBot.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+		ChatID:      chatID,
+		MessageID:   messageID,
+		ReplyMarkup: b.replyMarkup(messageID, saved, expand),
+	})
+	if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("[setReplyButtons] Chat %d message %d: %v", chatID, messageID, err)
+	}
+}
+
+func (b *Bot) replyMarkup(messageID int, saved bool, expand string) *models.InlineKeyboardMarkup {
+	var row []models.InlineKeyboardButton
+	if b.DB != nil {
+		label := "⭐ Save"
+		if saved {
+			label = "✅ Saved"
+		}
+		row = append(row, models.InlineKeyboardButton{Text: label, CallbackData: "bm_" + strconv.Itoa(messageID)})
+	}
+	if expand != "" && len(expandPrefix+expand) <= callbackDataLimit {
+		row = append(row, models.InlineKeyboardButton{Text: "🔧 Expand", CallbackData: expandPrefix + expand})
+	}
+	keyboard := [][]models.InlineKeyboardButton{}
+	if len(row) > 0 {
+		keyboard = append(keyboard, row)
+	}
+	return &models.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// handleBookmarkCallback toggles the bookmark of the reply the button is on.
+func (b *Bot) handleBookmarkCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, messageID int) {
+	answer := func(text string) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: text})
+	}
+	if b.DB == nil {
+		answer("Saving needs a store")
+		return
+	}
+
+	if _, err := b.DB.GetBookmark(chatID, messageID); err == nil {
+		if err := b.DB.DeleteBookmark(chatID, messageID); err != nil {
+			log.Printf("[handleBookmarkCallback] Error: %v", err)
+			answer("Failed to remove bookmark")
+			return
+		}
+		answer("Removed from saved")
+		b.setReplyButtons(ctx, tgBot, chatID, messageID, false, expandRef(callback.Message.Message.ReplyMarkup))
+		return
+	}
+
+	// The message shows at most MaxMessageLen; the cache has the full
+	// reply if this is still the chat's latest one.
+	text, sessionID := callback.Message.Message.Text, b.currentSessionID(chatID)
+	if cached, err := b.DB.GetMessageText(chatID); err == nil && cached.MessageID == messageID && cached.Text != "" {
+		text, sessionID = cached.Text, cached.SessionID
+	}
+	err := b.DB.SaveBookmark(store.Bookmark{
+		ChatID:    chatID,
+		MessageID: messageID,
+		SessionID: sessionID,
+		Text:      text,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("[handleBookmarkCallback] Error: %v", err)
+		answer("Failed to save")
+		return
+	}
+	answer("Saved. See /saved")
+	b.setReplyButtons(ctx, tgBot, chatID, messageID, true, expandRef(callback.Message.Message.ReplyMarkup))
+}
+
+// savedCommand lists the chat's bookmarks with buttons to re-open them.
+func (b *Bot) savedCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	text, keyboard := b.savedList(chatID)
+	params := &bot.SendMessageParams{ChatID: chatID, Text: text}
+	if len(keyboard) > 0 {
+		params.ReplyMarkup = &models.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+	}
+	msg, _ := tgBot.SendMessage(ctx, params)
+	b.track(chatID, msg)
+}
+
+func (b *Bot) savedList(chatID int64) (string, [][]models.InlineKeyboardButton) {
+	marks, err := b.DB.ListBookmarks(chatID)
+	if err != nil {
+		log.Printf("[savedList] Error: %v", err)
+		return "Failed to load saved replies", nil
+	}
+	if len(marks) == 0 {
+		return "Nothing saved yet. Tap ⭐ Save under a reply to keep it.", nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Saved replies (%d)\n\n", len(marks)))
+	var keyboard [][]models.InlineKeyboardButton
+	for i, m := range marks {
+		if i == maxSavedListed {
+			sb.WriteString(fmt.Sprintf("... and %d older\n", len(marks)-maxSavedListed))
+			break
+		}
+		title := bookmarkTitle(m.Text)
+		sb.WriteString(fmt.Sprintf("%d. %s - %s\n", i+1, title, b.formatTime(chatID, m.CreatedAt)))
+		if link := messageLink(chatID, m.MessageID); link != "" {
+			sb.WriteString("   " + link + "\n")
+		}
+		id := strconv.Itoa(m.MessageID)
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: fmt.Sprintf("%d. %s", i+1, title), CallbackData: "bmopen_" + id},
+			{Text: "✖", CallbackData: "bmdel_" + id},
+		})
+	}
+	return b.truncate(strings.TrimSuffix(sb.String(), "\n")), keyboard
+}
+
+// handleSavedCallback opens ("bmopen_<id>") or removes ("bmdel_<id>") a
+// bookmark from the /saved list.
+func (b *Bot) handleSavedCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, data string) {
+	remove := strings.HasPrefix(data, "bmdel_")
+	id, err := strconv.Atoi(data[strings.IndexByte(data, '_')+1:])
+	if err != nil || b.DB == nil {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID})
+		return
+	}
+	mark, err := b.DB.GetBookmark(chatID, id)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[handleSavedCallback] Error: %v", err)
+		}
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: "Bookmark not found"})
+		return
+	}
+
+	if remove {
+		if err := b.DB.DeleteBookmark(chatID, id); err != nil {
+			log.Printf("[handleSavedCallback] Error: %v", err)
+		}
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: "Removed"})
+		text, keyboard := b.savedList(chatID)
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:      chatID,
+			MessageID:   callback.Message.Message.ID,
+			Text:        text,
+			ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+		})
+		// The reply's Expand button isn't known from here, so only
+		// the Save toggle is put back.
+		b.setReplyButtons(ctx, tgBot, chatID, id, false, "")
+		return
+	}
+
+	tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID})
+	// Replying to the original message gives a tap-to-jump quote when it
+	// still exists.
+	reply := &models.ReplyParameters{MessageID: mark.MessageID, AllowSendingWithoutReply: true}
+	if tgtext.Len(mark.Text) <= b.Config.MaxMessageLen {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: mark.Text, ReplyParameters: reply})
+		return
+	}
+	if _, err := tgBot.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:          chatID,
+		Document:        &models.InputFileUpload{Filename: fmt.Sprintf("saved-%d.md", mark.MessageID), Data: strings.NewReader(mark.Text)},
+		Caption:         bookmarkTitle(mark.Text),
+		ReplyParameters: reply,
+	}); err != nil {
+		log.Printf("[handleSavedCallback] Error sending document: %v", err)
+	}
+}
+
+// bookmarkTitle is the first non-empty line of text, shortened.
+func bookmarkTitle(text string) string {
+	title := strings.TrimSpace(text)
+	if i := strings.IndexByte(title, '\n'); i >= 0 {
+		title = strings.TrimSpace(title[:i])
+	}
+	title = tgtext.Clip(title, bookmarkTitleLen)
+	if title == "" {
+		title = "(empty)"
+	}
+	return title
+}
+
+// messageLink returns a t.me link to a message, which Telegram only
+// supports in supergroups and channels.
+func messageLink(chatID int64, messageID int) string {
+	const channelOffset = -1000000000000
+	if chatID > channelOffset {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/c/%d/%d", -(chatID - channelOffset), messageID)
+}
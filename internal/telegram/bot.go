@@ -1,17 +1,26 @@
 package telegram
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/forge"
+	"github.com/Khaledxab/Openkh/internal/gist"
+	"github.com/Khaledxab/Openkh/internal/mailer"
+	"github.com/Khaledxab/Openkh/internal/ocserver"
 	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/scheduler"
 	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/Khaledxab/Openkh/internal/tracker"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
@@ -20,30 +29,74 @@ import (
 type Bot struct {
 	Config    *config.Config
 	Client    *opencode.Client
-	DB        *store.DB
+	DB        store.Store
 	Stream    *opencode.StreamManager
 	Start     time.Time
-	Agents    map[string]string // name -> description
 	Providers []opencode.Provider
+	Jobs      *scheduler.Scheduler
+	Mailer    *mailer.Mailer  // nil unless SMTP_URL is set
+	Forge     forge.Forge     // nil unless FORGE_TYPE is set
+	Tracker   tracker.Tracker // nil unless ISSUE_TRACKER is set
+	Gists     *gist.Client    // nil unless GIST_TOKEN is set
+	Server    ocserver.Server // nil unless OPENCODE_SERVICE is set
+
+	pending map[string]pendingHandler
+	access  atomic.Pointer[accessLists] // swapped wholesale on reload
+	updates *dispatcher
+	aliases []command       // from COMMAND_ALIASES, registered after the real commands
+	plugins []pluginCommand // from PLUGINS_FILE
+	purges  purgeState
+	api     apiStreams // chats whose reply streams to a chat API request
+	watch   watchState // chats with /watch on and their unreported changes
+
+	runStarts   sync.Map // chat ID -> when its running prompt was sent
+	commandRuns sync.Map // chat ID -> target of its running /run
+	runners     runners  // who sent each chat's latest prompt
+	runs        runQueue // prompts waiting under MAX_CONCURRENT_RUNS
+	recent      recentUpdates
+	transcripts sync.Map // chat ID -> transcriptPrompt of its running prompt, under TRANSCRIPT_CHAT
+	senders     sync.Map // user ID -> how the transcript names them
+	branches    sync.Map // forge repo -> its default branch, for POSTPROCESS links
+
+	tenants map[string]*tenant // TENANTS name -> the bot serving its chats
+
+	// Fallbacks for chats without a stored agent/model preference.
+	defaultAgent    string
+	defaultProvider string
+	defaultModel    string
 }
 
-// New creates a Bot and initialises the agent map.
-func New(cfg *config.Config, client *opencode.Client, db *store.DB, stream *opencode.StreamManager) *Bot {
+// New creates a Bot and initialises the access lists and agent map.
+func New(cfg *config.Config, client *opencode.Client, db store.Store, stream *opencode.StreamManager) *Bot {
 	b := &Bot{
 		Config: cfg,
 		Client: client,
 		DB:     db,
 		Stream: stream,
 		Start:  time.Now(),
-		Agents: defaultAgents(),
 	}
+	b.registerPendingHandlers()
+	b.updates = newDispatcher(cfg.Workers, cfg.ChatQueueLimit)
 
+	agents := defaultAgents()
 	// Override with env-configured agents if provided
 	if cfg.Agents != "" {
-		if parsed := parseAgents(cfg.Agents); len(parsed) > 0 {
-			b.Agents = parsed
+		if parsed, err := config.ParseAgents(cfg.Agents); err == nil && len(parsed) > 0 {
+			agents = parsed
 		}
 	}
+	b.access.Store(&accessLists{
+		allowed: cfg.AllowedUsers,
+		admins:  cfg.AdminUsers,
+		agents:  agents,
+	})
+	b.plugins = b.loadPlugins()
+	if aliases, err := config.ParseAliases(cfg.Aliases); err != nil {
+		log.Printf("Warning: ignoring COMMAND_ALIASES: %v", err)
+	} else {
+		b.aliases = b.resolveAliases(aliases)
+	}
+	b.warnUnknownDisabled()
 
 	// Fetch providers from OpenCode server
 	if client != nil {
@@ -62,43 +115,79 @@ func New(cfg *config.Config, client *opencode.Client, db *store.DB, stream *open
 			log.Printf("Discovered %d connected provider(s)", len(b.Providers))
 		}
 	}
+	b.resolveDefaults()
 
 	return b
 }
 
+// resolveDefaults validates DEFAULT_AGENT and DEFAULT_MODEL against the
+// configured agents and discovered providers. An unknown model is dropped
+// so prompts don't fail; an unknown agent only warns, since agents can
+// come from OpenCode plugins the bot doesn't know about.
+func (b *Bot) resolveDefaults() {
+	if b.Config == nil {
+		return
+	}
+	if agent := b.Config.DefaultAgent; agent != "" {
+		if _, ok := b.agents()[agent]; !ok && len(b.agents()) > 0 {
+			log.Printf("Warning: DEFAULT_AGENT %q is not in the configured agents", agent)
+		}
+		b.defaultAgent = agent
+	}
+	if ref := b.Config.DefaultModel; ref != "" {
+		providerID, modelID, _ := config.SplitModel(ref)
+		switch {
+		case len(b.Providers) == 0:
+			log.Printf("Warning: no providers discovered, using DEFAULT_MODEL %q unvalidated", ref)
+		case b.findModelDisplayName(providerID, modelID) == "":
+			log.Printf("Warning: DEFAULT_MODEL %q not offered by any connected provider, ignoring", ref)
+			return
+		}
+		b.defaultProvider, b.defaultModel = providerID, modelID
+	}
+}
+
+// middlewares returns what every update goes through, outermost first.
+// Updates are serialized per chat so panics are recovered on the worker,
+// and redelivered ones are skipped there: one dropped on a full queue
+// isn't marked seen, so its redelivery still runs. Tenant chats are
+// serialized and deduplicated by their tenant's bot instead.
+func (b *Bot) middlewares() []bot.Middleware {
+	return []bot.Middleware{b.routeTenants, b.updates.middleware, b.recoverPanics, b.skipDuplicates}
+}
+
 // RegisterHandlers returns the bot.Option slice for all command/handler registrations.
 func (b *Bot) RegisterHandlers() []bot.Option {
-	return []bot.Option{
+	opts := []bot.Option{
+		bot.WithMiddlewares(b.middlewares()...),
 		bot.WithDefaultHandler(b.defaultHandler),
-		bot.WithMessageTextHandler("/start", bot.MatchTypeExact, b.startCommand),
-		bot.WithMessageTextHandler("/help", bot.MatchTypeExact, b.helpCommand),
-		bot.WithMessageTextHandler("/new", bot.MatchTypeExact, b.newCommand),
-		bot.WithMessageTextHandler("/status", bot.MatchTypeExact, b.statusCommand),
-		bot.WithMessageTextHandler("/stats", bot.MatchTypeExact, b.statsCommand),
-		bot.WithMessageTextHandler("/stop", bot.MatchTypeExact, b.stopCommand),
-		bot.WithMessageTextHandler("/clear", bot.MatchTypeExact, b.clearCommand),
-		bot.WithMessageTextHandler("/sessions", bot.MatchTypeExact, b.sessionsCommand),
-		bot.WithMessageTextHandler("/switch", bot.MatchTypePrefix, b.switchCommand),
-		bot.WithMessageTextHandler("/rename", bot.MatchTypePrefix, b.renameCommand),
-		bot.WithMessageTextHandler("/delete", bot.MatchTypePrefix, b.deleteCommand),
-		bot.WithMessageTextHandler("/purge", bot.MatchTypeExact, b.purgeCommand),
-		bot.WithMessageTextHandler("/diff", bot.MatchTypeExact, b.diffCommand),
-		bot.WithMessageTextHandler("/history", bot.MatchTypeExact, b.historyCommand),
-		bot.WithMessageTextHandler("/model", bot.MatchTypePrefix, b.modelCommand),
-		bot.WithMessageTextHandler("/think", bot.MatchTypeExact, b.thinkCommand),
-		bot.WithMessageTextHandler("/agent", bot.MatchTypePrefix, b.agentCommand),
 	}
+	for _, c := range append(b.commands(), b.aliases...) {
+		pattern := "/" + c.name
+		if c.match == bot.MatchTypeCommandStartOnly {
+			pattern = c.name // command matches compare the name without the slash
+		}
+		opts = append(opts, bot.WithMessageTextHandler(pattern, c.match, c.handler))
+	}
+	return opts
 }
 
 // TelegramSender adapts a *bot.Bot to opencode.MessageSender.
 type TelegramSender struct {
 	Bot *bot.Bot
+	// Silent, when set, reports chats whose streamed messages are sent
+	// without a notification (see /mute).
+	Silent func(chatID int64) bool
+	// Buttons, when set, returns the buttons kept on a streamed message
+	// while it is edited, or nil for none (see StreamButtons).
+	Buttons func(chatID int64, messageID int) *models.InlineKeyboardMarkup
 }
 
 func (ts *TelegramSender) SendText(chatID int64, text string) (int, error) {
 	msg, err := ts.Bot.SendMessage(context.Background(), &bot.SendMessageParams{
-		ChatID: chatID,
-		Text:   text,
+		ChatID:              chatID,
+		Text:                text,
+		DisableNotification: ts.Silent != nil && ts.Silent(chatID),
 	})
 	if err != nil {
 		return 0, err
@@ -107,70 +196,223 @@ func (ts *TelegramSender) SendText(chatID int64, text string) (int, error) {
 }
 
 func (ts *TelegramSender) EditText(chatID int64, messageID int, text string) error {
-	_, err := ts.Bot.EditMessageText(context.Background(), &bot.EditMessageTextParams{
+	_, err := ts.Bot.EditMessageText(context.Background(), ts.editParams(chatID, messageID, text))
+	return err
+}
+
+// EditFinalText edits in the finished reply with its code blocks, code
+// spans and bold text formatted by entities rather than a parse mode, so
+// no reply can fail to parse. Should Telegram refuse the entities anyway,
+// the reply is sent as plain text.
+func (ts *TelegramSender) EditFinalText(chatID int64, messageID int, text string) error {
+	plain, entities := tgtext.Entities(text)
+	if len(entities) == 0 {
+		return ts.EditText(chatID, messageID, text)
+	}
+	params := ts.editParams(chatID, messageID, plain)
+	params.Entities = messageEntities(entities)
+	_, err := ts.Bot.EditMessageText(context.Background(), params)
+	if err != nil && strings.Contains(err.Error(), "entit") {
+		log.Printf("[TelegramSender] Warning: chat %d: formatted reply refused, sending it as plain text: %v", chatID, err)
+		return ts.EditText(chatID, messageID, text)
+	}
+	return err
+}
+
+// FormatsFinal reports whether EditFinalText formats text; text without
+// anything to format is edited in as is.
+func (ts *TelegramSender) FormatsFinal(chatID int64, text string) bool {
+	_, entities := tgtext.Entities(text)
+	return len(entities) > 0
+}
+
+func (ts *TelegramSender) editParams(chatID int64, messageID int, text string) *bot.EditMessageTextParams {
+	params := &bot.EditMessageTextParams{
 		ChatID:    chatID,
 		MessageID: messageID,
 		Text:      text,
-	})
-	return err
+	}
+	// An edit without buttons drops the message's buttons.
+	if ts.Buttons != nil {
+		if markup := ts.Buttons(chatID, messageID); markup != nil {
+			params.ReplyMarkup = markup
+		}
+	}
+	return params
 }
 
-// StartRateLimitCleanup runs the periodic rate-limit map cleanup.
-func StartRateLimitCleanup() {
-	go cleanupRateLimitMap()
+// messageEntities converts tgtext entities to the Bot API's.
+func messageEntities(entities []tgtext.Entity) []models.MessageEntity {
+	out := make([]models.MessageEntity, len(entities))
+	for i, e := range entities {
+		out[i] = models.MessageEntity{
+			Type:     models.MessageEntityType(e.Type),
+			Offset:   e.Offset,
+			Length:   e.Length,
+			Language: e.Language,
+		}
+	}
+	return out
 }
 
-// LogConfig logs the loaded configuration summary.
-func LogConfig(cfg *config.Config) {
-	log.Printf("Loaded config: OpenCode URL=%s, Allowed Users=%d, DB=%s",
-		cfg.OpenCodeURL, len(cfg.AllowedUsers), cfg.DBPath)
-}
-
-// RegisterBotCommands registers the bot's commands with Telegram for auto-completion.
-func RegisterBotCommands(tgBot *bot.Bot, token string) {
-	commands := []models.BotCommand{
-		{Command: "start", Description: "Start fresh"},
-		{Command: "help", Description: "Show commands"},
-		{Command: "new", Description: "New conversation"},
-		{Command: "stop", Description: "Stop current operation"},
-		{Command: "sessions", Description: "List all sessions"},
-		{Command: "switch", Description: "Switch to session"},
-		{Command: "rename", Description: "Rename session"},
-		{Command: "delete", Description: "Delete session"},
-		{Command: "purge", Description: "Delete all sessions"},
-		{Command: "agent", Description: "Switch agent"},
-		{Command: "model", Description: "Select model"},
-		{Command: "diff", Description: "Show file changes"},
-		{Command: "history", Description: "Show message history"},
-		{Command: "status", Description: "Bot status"},
-		{Command: "stats", Description: "Usage statistics"},
-		{Command: "clear", Description: "Clear current session"},
-		{Command: "think", Description: "Toggle thinking display"},
-	}
-
-	params := struct {
-		Commands []models.BotCommand `json:"commands"`
-	}{
-		Commands: commands,
-	}
-
-	body, err := json.Marshal(params)
-	if err != nil {
-		log.Printf("Warning: Failed to marshal commands: %v", err)
-		return
+// MaintenanceJobs returns the periodic jobs owned by the bot, for
+// registration with the scheduler. Notices they send go through tgBot.
+// A tenant's bot only has the jobs about its own chats and OpenCode
+// instance, named after the tenant; the store is shared.
+func (b *Bot) MaintenanceJobs(tgBot *bot.Bot) []scheduler.Job {
+	if b.Config.Tenant != "" {
+		jobs := b.chatJobs(tgBot)
+		for i := range jobs {
+			jobs[i].Name = b.Config.Tenant + "/" + jobs[i].Name
+		}
+		return jobs
+	}
+	jobs := []scheduler.Job{
+		{Name: "ratelimit-cleanup", Interval: 5 * time.Minute, Jitter: 10 * time.Second, Run: cleanupRateLimits},
+	}
+	if b.DB != nil {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "pending-actions-janitor",
+			Interval: 10 * time.Minute,
+			Jitter:   30 * time.Second,
+			Run: func(context.Context) error {
+				n, err := b.DB.DeleteExpiredPendingActions()
+				if err != nil {
+					return fmt.Errorf("delete expired pending actions: %w", err)
+				}
+				if n > 0 {
+					log.Printf("[janitor] Removed %d expired pending action(s)", n)
+				}
+				return nil
+			},
+		}, scheduler.Job{
+			Name:     "tracked-messages-janitor",
+			Interval: time.Hour,
+			Jitter:   time.Minute,
+			Run: func(context.Context) error {
+				// Past this Telegram refuses to delete them anyway.
+				n, err := b.DB.DeleteTrackedMessagesBefore(time.Now().Add(-deleteWindow))
+				if err != nil {
+					return fmt.Errorf("delete old tracked messages: %w", err)
+				}
+				if n > 0 {
+					log.Printf("[janitor] Forgot %d tracked message(s)", n)
+				}
+				return nil
+			},
+		}, scheduler.Job{
+			Name:     "lease-janitor",
+			Interval: 30 * time.Minute,
+			Jitter:   time.Minute,
+			Run: func(context.Context) error {
+				// Keep recently expired leases so other replicas can adopt them.
+				n, err := b.DB.DeleteExpiredLeases(time.Now().Add(-time.Hour))
+				if err != nil {
+					return fmt.Errorf("delete expired leases: %w", err)
+				}
+				if n > 0 {
+					log.Printf("[janitor] Removed %d expired lease(s)", n)
+				}
+				return nil
+			},
+		}, scheduler.Job{
+			Name:     "updates-janitor",
+			Interval: 30 * time.Minute,
+			Jitter:   time.Minute,
+			Run: func(context.Context) error {
+				n, err := b.DB.DeleteExpiredUpdates(time.Now())
+				if err != nil {
+					return fmt.Errorf("delete expired updates: %w", err)
+				}
+				if n > 0 {
+					log.Printf("[janitor] Forgot %d processed update(s)", n)
+				}
+				return nil
+			},
+		}, scheduler.Job{
+			Name:     "access-grants-janitor",
+			Interval: time.Minute,
+			Jitter:   5 * time.Second,
+			Run: func(ctx context.Context) error {
+				return b.expireGrants(ctx, tgBot)
+			},
+		})
 	}
+	return append(jobs, b.chatJobs(tgBot)...)
+}
 
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/setMyCommands", token)
-	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
-	if err != nil {
-		log.Printf("Warning: Failed to register bot commands: %v", err)
-		return
+// chatJobs returns the jobs about the chats the bot serves and its
+// OpenCode instance.
+func (b *Bot) chatJobs(tgBot *bot.Bot) []scheduler.Job {
+	var jobs []scheduler.Job
+	if b.Config.MaxConcurrentRuns > 0 {
+		// Runs that end without a completion, e.g. a failed prompt or one
+		// superseded by an edit, free their slot here.
+		jobs = append(jobs, scheduler.Job{
+			Name:     "run-queue",
+			Interval: 30 * time.Second,
+			Run: func(context.Context) error {
+				b.startQueued()
+				return nil
+			},
+		})
+	}
+	if b.DB != nil {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "daily-digest",
+			Interval: 10 * time.Minute,
+			Jitter:   time.Minute,
+			Run: func(ctx context.Context) error {
+				return b.sendDigests(ctx, tgBot)
+			},
+		})
+		if b.Client != nil {
+			jobs = append(jobs, scheduler.Job{
+				Name:     "outbox",
+				Interval: 30 * time.Second,
+				Jitter:   5 * time.Second,
+				Run: func(ctx context.Context) error {
+					return b.replayOutbox(ctx, tgBot)
+				},
+			})
+		}
+		if b.Config.ArchiveAfter > 0 && b.Client != nil {
+			jobs = append(jobs, scheduler.Job{
+				Name:     "session-archiver",
+				Interval: time.Hour,
+				Jitter:   5 * time.Minute,
+				Run:      b.archiveIdleSessions,
+			})
+		}
 	}
-	defer resp.Body.Close()
+	return jobs
+}
 
-	if resp.StatusCode == 200 {
-		log.Printf("Registered %d bot commands", len(commands))
-	} else {
-		log.Printf("Warning: Failed to register bot commands: status %d", resp.StatusCode)
+// LogConfig logs the loaded configuration summary.
+func LogConfig(cfg *config.Config) {
+	log.Printf("Loaded config: OpenCode URL=%s, OpenCode auth=%t, custom TLS=%t, Allowed Users=%d, DB=%s",
+		cfg.OpenCodeURL, cfg.OpenCodeKey != "", !cfg.OpenCodeTLS.IsZero(), len(cfg.AllowedUsers), cfg.DBPath)
+}
+
+// telegramPollTimeout is the long-polling timeout used with a custom HTTP client.
+const telegramPollTimeout = time.Minute
+
+// NewHTTPClient returns the HTTP client used for the Telegram API. A nil
+// proxy falls back to HTTP(S)_PROXY from the environment.
+func NewHTTPClient(proxy *url.URL) *http.Client {
+	proxyFunc := http.ProxyFromEnvironment
+	if proxy != nil {
+		proxyFunc = http.ProxyURL(proxy)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc
+	return &http.Client{
+		Transport: &timedTransport{base: transport},
+		Timeout:   telegramPollTimeout + 10*time.Second,
 	}
 }
+
+// HTTPClientOption makes the Telegram library use httpClient (e.g. for a proxy).
+func HTTPClientOption(httpClient *http.Client) bot.Option {
+	return bot.WithHTTPClient(telegramPollTimeout, httpClient)
+}
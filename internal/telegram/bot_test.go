@@ -0,0 +1,34 @@
+package telegram
+
+import "testing"
+
+func TestFormatsFinal(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{"plain", "All tests pass.", false},
+		{"unpaired markers", "a `b and **c", false},
+		{"code span", "Run `go test`.", true},
+		{"bold", "This is **important**.", true},
+		{"code block", "```go\nx := 1\n```", true},
+	}
+	ts := &TelegramSender{}
+	queued := NewSendQueue(ts, 0)
+	// A sender that doesn't format makes the queue report false.
+	plain := NewSendQueue(nopSender{}, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ts.FormatsFinal(1, tt.text); got != tt.want {
+				t.Errorf("TelegramSender.FormatsFinal = %v, want %v", got, tt.want)
+			}
+			if got := queued.FormatsFinal(1, tt.text); got != tt.want {
+				t.Errorf("SendQueue.FormatsFinal = %v, want %v", got, tt.want)
+			}
+			if plain.FormatsFinal(1, tt.text) {
+				t.Error("SendQueue.FormatsFinal of a plain sender = true, want false")
+			}
+		})
+	}
+}
@@ -2,11 +2,14 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Khaledxab/Openkh/internal/opencode"
 	"github.com/Khaledxab/Openkh/internal/store"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
@@ -17,6 +20,10 @@ func (b *Bot) defaultHandler(ctx context.Context, tgBot *bot.Bot, update *models
 		b.handleCallbackQuery(ctx, tgBot, update)
 		return
 	}
+	if update.EditedMessage != nil {
+		b.handleEditedMessage(ctx, tgBot, update.EditedMessage)
+		return
+	}
 
 	if update.Message == nil {
 		return
@@ -28,7 +35,7 @@ func (b *Bot) defaultHandler(ctx context.Context, tgBot *bot.Bot, update *models
 		return
 	}
 
-	if b.Config != nil && !checkAuth(chatID, b.Config) {
+	if b.Config != nil && !b.checkAuth(chatID) {
 		tgBot.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
 			Text:   "Unauthorized. You are not allowed to use this bot.",
@@ -36,69 +43,80 @@ func (b *Bot) defaultHandler(ctx context.Context, tgBot *bot.Bot, update *models
 		return
 	}
 
-	if !checkRateLimit(chatID) {
-		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+	// A multi-step flow waiting for input takes the message instead of
+	// sending it to OpenCode.
+	if b.dispatchPending(ctx, tgBot, chatID, text) {
+		return
+	}
+
+	if !b.allowMessage(chatID) {
+		msg, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
 			Text:   "Please wait a moment before sending another message...",
 		})
+		b.track(chatID, msg)
 		return
 	}
 
+	b.rememberSender(update.Message.From)
+	b.runPrompt(ctx, tgBot, chatID, senderID(chatID, update.Message.From), update.Message.ID, text)
+}
+
+// runPrompt sends text to the chat's session (creating one if needed) and
+// streams the reply into a new "Thinking..." message, once userID is
+// within MAX_RUNNING_PROMPTS and a slot under MAX_CONCURRENT_RUNS is free.
+// promptID is the user's message, remembered so editing it can re-run the
+// prompt.
+func (b *Bot) runPrompt(ctx context.Context, tgBot *bot.Bot, chatID, userID int64, promptID int, text string) {
+	if refusal := b.runLimitRefusal(chatID, userID); refusal != "" {
+		msg, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		b.track(chatID, msg)
+		return
+	}
+	b.queuePrompt(ctx, tgBot, chatID, userID, promptID, text)
+}
+
+// startPrompt is runPrompt once the prompt has a run slot.
+func (b *Bot) startPrompt(ctx context.Context, tgBot *bot.Bot, chatID, userID int64, promptID int, text string) {
 	tgBot.SendChatAction(ctx, &bot.SendChatActionParams{
 		ChatID: chatID,
 		Action: "typing",
 	})
 
-	var sessionID string
-	var agent string
-	var providerID string
-	var modelID string
-
-	if b.DB != nil {
-		sess, err := b.DB.GetSession(chatID)
-		if err == nil {
-			sessionID = sess.SessionID
-			agent = sess.Agent
-			providerID = sess.ModelProvider
-			modelID = sess.ModelID
-			b.DB.IncrementCount(chatID)
-		}
-	}
-
-	if sessionID == "" && b.Client != nil {
-		newSess, err := b.Client.CreateOCSession(ctx, fmt.Sprintf("Telegram Chat %d", chatID))
-		if err != nil {
-			log.Printf("[defaultHandler] Error creating session: %v", err)
-			tgBot.SendMessage(ctx, &bot.SendMessageParams{
-				ChatID: chatID,
-				Text:   "Failed to create session: " + err.Error(),
-			})
+	sess, refusal, err := b.promptSession(ctx, chatID)
+	if err != nil {
+		log.Printf("[defaultHandler] Error creating session: %v", err)
+		var busy *opencode.BusyError
+		if errors.As(err, &busy) {
+			b.requeueBusy(ctx, tgBot, chatID, userID, promptID, text, 0, busy)
 			return
 		}
-		sessionID = newSess.ID
-
-		if b.DB != nil {
-			s := store.Session{
-				ChatID:        chatID,
-				SessionID:     sessionID,
-				Title:         newSess.Title,
-				Agent:         agent,
-				ModelProvider: providerID,
-				ModelID:       modelID,
-				MessageCount:  1,
-				CreatedAt:     time.Now(),
-				LastUsed:      time.Now(),
-			}
-			if err := b.DB.SetSession(s); err != nil {
-				log.Printf("[defaultHandler] Error saving session: %v", err)
-			}
+		if b.holdPrompt(ctx, chatID, userID, promptID, text) {
+			msg, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: outboxText})
+			b.track(chatID, msg)
+			return
 		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "Failed to create session: " + err.Error(),
+		})
+		return
 	}
+	if refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+	sessionID, agent, providerID, modelID := sess.SessionID, sess.Agent, sess.ModelProvider, sess.ModelID
 
-	msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: chatID,
-		Text:   "Thinking...",
-	})
+	params := &bot.SendMessageParams{
+		ChatID:              chatID,
+		Text:                "Thinking...",
+		DisableNotification: b.Muted(chatID),
+	}
+	if b.Client != nil && sessionID != "" {
+		params.ReplyMarkup = stopMarkup(sessionID)
+	}
+	msg, err := tgBot.SendMessage(ctx, params)
 	if err != nil {
 		log.Printf("[defaultHandler] Error sending initial message: %v", err)
 		return
@@ -108,25 +126,93 @@ func (b *Bot) defaultHandler(ctx context.Context, tgBot *bot.Bot, update *models
 		b.Stream.RegisterSession(sessionID, chatID, msg.ID)
 	}
 
+	agent, providerID, modelID = b.withDefaults(b.promptAgent(chatID, sessionID, agent), providerID, modelID)
+
 	if b.Client != nil && sessionID != "" {
-		if err := b.Client.PromptAsync(ctx, sessionID, text, agent, providerID, modelID); err != nil {
+		opts := opencode.PromptOptions{
+			Agent:      agent,
+			ProviderID: providerID,
+			ModelID:    modelID,
+			System:     b.systemPrompt(chatID, sessionID),
+		}
+		if err := b.Client.PromptAsync(ctx, sessionID, text, opts); err != nil {
 			log.Printf("[defaultHandler] Error sending prompt: %v", err)
+			if b.Stream != nil {
+				b.Stream.UnregisterSession(sessionID)
+			}
+			var busy *opencode.BusyError
+			if errors.As(err, &busy) {
+				b.requeueBusy(ctx, tgBot, chatID, userID, promptID, text, msg.ID, busy)
+				return
+			}
+			notice := "Error: " + err.Error()
+			if b.holdPrompt(ctx, chatID, userID, promptID, text) {
+				notice = outboxText
+			}
 			tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
 				ChatID:    chatID,
 				MessageID: msg.ID,
-				Text:      "Error: " + err.Error(),
+				Text:      notice,
 			})
+			b.track(chatID, msg)
 			return
 		}
+		b.rememberPrompt(chatID, lastPrompt{promptID: promptID, replyID: msg.ID, sessionID: sessionID})
+		b.transcribePrompt(chatID, userID, sessionID, text)
+		b.promptSent(chatID)
 	} else {
 		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
 			ChatID:    chatID,
 			MessageID: msg.ID,
 			Text:      "OpenCode client not available",
 		})
+		b.track(chatID, msg)
 	}
 }
 
+// promptSession returns the chat's session for a new prompt, creating an
+// OpenCode session when it has none. A non-empty refusal means the server
+// placed the new session outside ALLOWED_DIRS and it was deleted.
+func (b *Bot) promptSession(ctx context.Context, chatID int64) (sess store.Session, refusal string, err error) {
+	if b.DB != nil {
+		if sess, err := b.DB.GetSession(chatID); err == nil {
+			b.DB.IncrementCount(chatID)
+			return sess, "", nil
+		}
+	}
+	if b.Client == nil {
+		return store.Session{}, "", nil
+	}
+
+	newSess, err := b.Client.CreateOCSession(ctx, b.sessionTitle(chatID), b.sessionDir(chatID))
+	if err != nil {
+		return store.Session{}, "", err
+	}
+	// The server picks the directory in the end; don't keep a session
+	// it placed outside ALLOWED_DIRS.
+	if refusal := b.sessionRefusal(chatID, newSess); refusal != "" {
+		if err := b.Client.DeleteOCSession(ctx, newSess.ID); err != nil {
+			log.Printf("[promptSession] Error deleting refused session: %v", err)
+		}
+		return store.Session{}, refusal, nil
+	}
+
+	sess = store.Session{
+		ChatID:       chatID,
+		SessionID:    newSess.ID,
+		Title:        newSess.Title,
+		MessageCount: 1,
+		CreatedAt:    time.Now(),
+		LastUsed:     time.Now(),
+	}
+	if b.DB != nil {
+		if err := b.DB.SetSession(sess); err != nil {
+			log.Printf("[promptSession] Error saving session: %v", err)
+		}
+	}
+	return sess, "", nil
+}
+
 func (b *Bot) handleCallbackQuery(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
 	log.Printf("[handleCallbackQuery] Called")
 	if update.CallbackQuery == nil {
@@ -138,18 +224,95 @@ func (b *Bot) handleCallbackQuery(ctx context.Context, tgBot *bot.Bot, update *m
 	chatID := callback.Message.Message.Chat.ID
 	data := callback.Data
 
+	if strings.HasPrefix(data, pendingCallbackPrefix) {
+		b.handlePendingCallback(ctx, tgBot, callback, chatID, data)
+		return
+	}
+
+	if strings.HasPrefix(data, "purge_ok_") || strings.HasPrefix(data, "purge_no_") {
+		b.handlePurgeCallback(ctx, tgBot, callback, chatID, data)
+		return
+	}
+
 	if strings.HasPrefix(data, "switch_") {
 		sessionID := strings.TrimPrefix(data, "switch_")
 		b.handleSwitchCallback(ctx, tgBot, callback, chatID, sessionID)
 		return
 	}
 
+	if strings.HasPrefix(data, unarchivePrefix) && b.DB != nil {
+		b.handleUnarchiveCallback(ctx, tgBot, callback, chatID, strings.TrimPrefix(data, unarchivePrefix))
+		return
+	}
+
+	if strings.HasPrefix(data, "bm_") {
+		if messageID, err := strconv.Atoi(strings.TrimPrefix(data, "bm_")); err == nil {
+			b.handleBookmarkCallback(ctx, tgBot, callback, chatID, messageID)
+		}
+		return
+	}
+
+	if strings.HasPrefix(data, "bmopen_") || strings.HasPrefix(data, "bmdel_") {
+		b.handleSavedCallback(ctx, tgBot, callback, chatID, data)
+		return
+	}
+
+	if strings.HasPrefix(data, revertPrefix) || strings.HasPrefix(data, revertOKPrefix) || data == revertCancel {
+		b.handleRevertCallback(ctx, tgBot, callback, chatID, data)
+		return
+	}
+
+	if strings.HasPrefix(data, lsDirPrefix) || strings.HasPrefix(data, lsFilePrefix) {
+		b.handleLsCallback(ctx, tgBot, callback, chatID, data)
+		return
+	}
+
+	if strings.HasPrefix(data, todoPrefix) {
+		b.handleTodoCallback(ctx, tgBot, callback, chatID, strings.TrimPrefix(data, todoPrefix))
+		return
+	}
+
+	if strings.HasPrefix(data, stopPrefix) {
+		b.handleStopCallback(ctx, tgBot, callback, chatID, strings.TrimPrefix(data, stopPrefix))
+		return
+	}
+
+	if strings.HasPrefix(data, expandPrefix) {
+		b.handleExpandCallback(ctx, tgBot, callback, chatID, strings.TrimPrefix(data, expandPrefix))
+		return
+	}
+
+	if strings.HasPrefix(data, toolPrefix) {
+		b.handleToolCallback(ctx, tgBot, callback, chatID, strings.TrimPrefix(data, toolPrefix))
+		return
+	}
+
+	if data == diffSummaryCallback {
+		b.handleDiffSummaryCallback(ctx, tgBot, callback, chatID)
+		return
+	}
+
+	if strings.HasPrefix(data, "diff_") {
+		b.handleDiffCallback(ctx, tgBot, callback, chatID, strings.TrimPrefix(data, "diff_"))
+		return
+	}
+
+	if strings.HasPrefix(data, "gist_") {
+		b.handleGistCallback(ctx, tgBot, callback, chatID, strings.TrimPrefix(data, "gist_"))
+		return
+	}
+
 	if strings.HasPrefix(data, "agent_") {
 		agentName := strings.TrimPrefix(data, "agent_")
 		b.handleAgentCallback(ctx, tgBot, callback, agentName)
 		return
 	}
 
+	if data == "modelhome" || data == "modelall" || strings.HasPrefix(data, "modelp_") || strings.HasPrefix(data, "modelstar_") {
+		b.handleModelMenuCallback(ctx, tgBot, callback, data)
+		return
+	}
+
 	if strings.HasPrefix(data, "model_") {
 		parts := strings.SplitN(strings.TrimPrefix(data, "model_"), "/", 2)
 		if len(parts) == 2 {
@@ -160,14 +323,31 @@ func (b *Bot) handleCallbackQuery(ctx context.Context, tgBot *bot.Bot, update *m
 }
 
 func (b *Bot) handleSwitchCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, sessionID string) {
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: callback.ID,
+			Text:            refusal,
+			ShowAlert:       true,
+		})
+		return
+	}
 	if b.Client != nil {
-		if _, err := b.Client.GetOCSession(ctx, sessionID); err != nil {
+		oc, err := b.Client.GetOCSession(ctx, sessionID)
+		if err != nil {
 			tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 				CallbackQueryID: callback.ID,
 				Text:            "Session not found",
 			})
 			return
 		}
+		if refusal := b.sessionRefusal(chatID, oc); refusal != "" {
+			tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+				CallbackQueryID: callback.ID,
+				Text:            refusal,
+				ShowAlert:       true,
+			})
+			return
+		}
 	}
 
 	if b.DB != nil {
@@ -179,6 +359,7 @@ func (b *Bot) handleSwitchCallback(ctx context.Context, tgBot *bot.Bot, callback
 		if err := b.DB.SetSession(sess); err != nil {
 			log.Printf("[handleSwitchCallback] Error: %v", err)
 		}
+		b.unarchive(sessionID)
 	}
 
 	tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
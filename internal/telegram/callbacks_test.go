@@ -0,0 +1,91 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot/models"
+)
+
+func TestPromptFailureReleasesRun(t *testing.T) {
+	var mu sync.Mutex
+	var prompted []string
+	oc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/session/ses_1/prompt_async":
+			mu.Lock()
+			prompted = append(prompted, "ses_1")
+			mu.Unlock()
+			http.Error(w, "model overloaded", http.StatusInternalServerError)
+		case "/session/ses_2/prompt_async":
+			mu.Lock()
+			prompted = append(prompted, "ses_2")
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		case "/global/health":
+			// Up, so the failed prompt isn't held in the outbox.
+			w.Write([]byte(`{"healthy":true}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer oc.Close()
+
+	// The rate limit is kept per process; forget earlier runs' messages.
+	rateLimitMu.Lock()
+	delete(rateLimitMap, 1)
+	delete(rateLimitMap, 2)
+	rateLimitMu.Unlock()
+
+	db := store.NewMemory()
+	for _, chatID := range []int64{1, 2} {
+		sess := store.Session{ChatID: chatID, SessionID: fmt.Sprintf("ses_%d", chatID), CreatedAt: time.Now(), LastUsed: time.Now()}
+		if err := db.SetSession(sess); err != nil {
+			t.Fatalf("SetSession: %v", err)
+		}
+	}
+	cfg := &config.Config{MaxConcurrentRuns: 1}
+	stream := opencode.NewStreamManager(oc.URL, nopSender{}, opencode.StreamOptions{})
+	b := New(cfg, opencode.NewClient(oc.URL, opencode.ClientOptions{}), db, stream)
+	ft, tgBot := newFakeTelegram(t)
+
+	send := func(chatID int64) {
+		b.defaultHandler(context.Background(), tgBot, &models.Update{Message: &models.Message{
+			ID:   1,
+			Chat: models.Chat{ID: chatID},
+			From: &models.User{ID: chatID},
+			Text: "hello",
+		}})
+	}
+
+	// The failed prompt must give back its stream registration and its
+	// run slot, or chat 2 would wait behind a run that never ends.
+	send(1)
+	if running := stream.RunningChats(); len(running) != 0 {
+		t.Fatalf("RunningChats after a failed prompt = %v, want none", running)
+	}
+	edits := ft.texts("editMessageText")
+	if len(edits) != 1 || !strings.HasPrefix(edits[0], "Error: ") {
+		t.Errorf("edits = %q, want one error", edits)
+	}
+
+	send(2)
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"ses_1", "ses_2"}; !slices.Equal(prompted, want) {
+		t.Errorf("prompted sessions = %v, want %v", prompted, want)
+	}
+	if running := stream.RunningChats(); !slices.Equal(running, []int64{2}) {
+		t.Errorf("RunningChats = %v, want [2]", running)
+	}
+}
@@ -0,0 +1,85 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// deleteWindow is how long after sending Telegram lets a bot delete
+	// its own message.
+	deleteWindow = 48 * time.Hour
+	// deleteBatch is the most message IDs one deleteMessages call takes.
+	deleteBatch = 100
+)
+
+// track records a transient bot message (a status line, picker or error)
+// so /cleanup can delete it later. msg may be nil after a failed send.
+func (b *Bot) track(chatID int64, msg *models.Message) {
+	if msg == nil {
+		return
+	}
+	b.trackID(chatID, msg.ID)
+}
+
+func (b *Bot) trackID(chatID int64, messageID int) {
+	if b.DB == nil || messageID == 0 {
+		return
+	}
+	if err := b.DB.TrackMessage(chatID, messageID); err != nil {
+		log.Printf("[track] Chat %d message %d: %v", chatID, messageID, err)
+	}
+}
+
+// cleanupCommand deletes the bot's tracked transient messages from the
+// chat, leaving the final answers.
+func (b *Bot) cleanupCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	tracked, err := b.DB.ListTrackedMessages(chatID)
+	if err != nil {
+		log.Printf("[cleanupCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to list messages to clean up"})
+		return
+	}
+
+	var ids, deletable []int
+	cutoff := time.Now().Add(-deleteWindow)
+	for _, m := range tracked {
+		ids = append(ids, m.MessageID)
+		if m.SentAt.After(cutoff) {
+			deletable = append(deletable, m.MessageID)
+		}
+	}
+	deleted := 0
+	for start := 0; start < len(deletable); start += deleteBatch {
+		batch := deletable[start:min(start+deleteBatch, len(deletable))]
+		if _, err := tgBot.DeleteMessages(ctx, &bot.DeleteMessagesParams{ChatID: chatID, MessageIDs: batch}); err != nil {
+			// Usually messages the user already deleted; nothing to retry.
+			log.Printf("[cleanupCommand] Chat %d: deleting %d message(s): %v", chatID, len(batch), err)
+			continue
+		}
+		deleted += len(batch)
+	}
+	if err := b.DB.UntrackMessages(chatID, ids); err != nil {
+		log.Printf("[cleanupCommand] Error untracking messages: %v", err)
+	}
+
+	text := "Nothing to clean up"
+	if deleted > 0 {
+		text = fmt.Sprintf("Removed %d message(s)", deleted)
+	}
+	msg, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+	b.track(chatID, msg)
+}
@@ -2,8 +2,11 @@ package telegram
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"strings"
 
+	"github.com/Khaledxab/Openkh/internal/opencode"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
@@ -32,7 +35,7 @@ func (b *Bot) startCommand(ctx context.Context, tgBot *bot.Bot, update *models.U
 		"/start - Start fresh\n/help - Show commands\n/new - New conversation\n" +
 		"/sessions - List sessions\n/agent - Switch agent\n/rename - Rename session\n" +
 		"/delete - Delete session\n/purge - Delete all sessions\n" +
-		"/diff - Show current changes\n/history - Show message history\n" +
+		"/diff - Show current changes\n/history - Show message history\n/export - Download last reply\n" +
 		"/stop - Stop current operation\n/status - Bot status\n/stats - Usage statistics\n" +
 		"/clear - Clear current session\n/model - Select model\n/think - Toggle thinking"
 
@@ -56,12 +59,7 @@ func (b *Bot) helpCommand(ctx context.Context, tgBot *bot.Bot, update *models.Up
 		return
 	}
 
-	helpText := "Available Commands\n\n" +
-		"Basic:\n/start - Start fresh\n/help - Show this help\n/new - New conversation\n/stop - Stop current operation\n\n" +
-		"Session:\n/sessions - List all sessions\n/switch <id> - Switch to session\n/rename <title> - Rename session\n/delete <id> - Delete session\n/purge - Delete all sessions\n\n" +
-		"Agent:\n/agent - Switch agent\n/agent <name> - Set agent directly\n\n" +
-		"Tools:\n/diff - Show changes\n/history - Show messages\n/model - Select model\n/think - Toggle thinking display\n\n" +
-		"Info:\n/status - Bot status\n/stats - Usage statistics\n/clear - Clear current session"
+	helpText := b.helpText(b.chatRole(chatID))
 
 	tgBot.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
@@ -118,10 +116,71 @@ func (b *Bot) stopCommand(ctx context.Context, tgBot *bot.Bot, update *models.Up
 		}
 	}
 
-	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+	text := "Stopped"
+	if n := b.dropQueued(ctx, chatID) + b.dropOutbox(chatID); n > 0 {
+		text = fmt.Sprintf("Stopped, and cancelled %d queued prompt%s", n, plural(n))
+	}
+	msg, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
-		Text:   "Stopped",
+		Text:   text,
 	})
+	b.track(chatID, msg)
+}
+
+// stopPrefix + session ID is the callback data of the Stop button on a
+// streaming reply.
+const stopPrefix = "stop_"
+
+func stopMarkup(sessionID string) *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+		{Text: "⏹ Stop", CallbackData: stopPrefix + sessionID},
+	}}}
+}
+
+// StreamButtons keeps the Stop button on the chat's reply while it
+// streams; the buttons set when it completes replace it.
+func (b *Bot) StreamButtons(chatID int64, messageID int) *models.InlineKeyboardMarkup {
+	if t := b.tenantFor(chatID); t != nil {
+		return t.bot.StreamButtons(chatID, messageID)
+	}
+	if b.Stream == nil || b.Client == nil {
+		return nil
+	}
+	sessionID, live, ok := b.Stream.LiveMessage(chatID)
+	if !ok || live != messageID {
+		return nil
+	}
+	return stopMarkup(sessionID)
+}
+
+// handleStopCallback aborts the run a Stop button is on, like /stop, and
+// finishes its reply with the text so far.
+func (b *Bot) handleStopCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, sessionID string) {
+	answer := func(text string) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: text})
+	}
+	var live string
+	var messageID int
+	if b.Stream != nil {
+		live, messageID, _ = b.Stream.LiveMessage(chatID)
+	}
+	if b.Client == nil || live != sessionID || messageID != callback.Message.Message.ID {
+		answer("This reply has finished")
+		tgBot.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+			ChatID:      chatID,
+			MessageID:   callback.Message.Message.ID,
+			ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{}},
+		})
+		return
+	}
+	if err := b.Client.Abort(ctx, sessionID); err != nil {
+		log.Printf("[handleStopCallback] Error aborting session %s: %v", sessionID, err)
+		answer("Error stopping operation")
+		return
+	}
+	answer("Stopped")
+	b.Stream.SetHeader(chatID, "⏹ Stopped")
+	b.Stream.Complete(sessionID)
 }
 
 func (b *Bot) clearCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
@@ -159,12 +218,119 @@ func (b *Bot) clearCommand(ctx context.Context, tgBot *bot.Bot, update *models.U
 	})
 }
 
+// thinkKey is the chat setting that shows the model's reasoning, "on" or
+// unset.
+const thinkKey = "think"
+
+type thinkHook struct {
+	b *Bot
+}
+
+// Reasoning returns the stream's ReasoningViewer: chats that turned on
+// /think get each reply's reasoning in a message of its own.
+func (b *Bot) Reasoning() opencode.ReasoningViewer {
+	return thinkHook{b: b}
+}
+
+func (h thinkHook) ShowReasoning(chatID int64) bool {
+	return h.b.chatSetting(chatID, thinkKey) == "on"
+}
+
+// thinkCommand turns the reasoning display on or off; bare /think toggles
+// it.
 func (b *Bot) thinkCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
 	if update.Message == nil {
 		return
 	}
-	tgBot.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: update.Message.Chat.ID,
-		Text:   "Thinking display: ON",
-	})
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	on := b.chatSetting(chatID, thinkKey) != "on"
+	switch arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/think")); arg {
+	case "":
+	case "on", "off":
+		on = arg == "on"
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /think [on|off]"})
+		return
+	}
+	value := ""
+	if on {
+		value = "on"
+	}
+	if err := b.DB.SetChatSetting(chatID, thinkKey, value); err != nil {
+		log.Printf("[thinkCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	text := "Thinking display: OFF"
+	if on {
+		text = "Thinking display: ON. The model's reasoning streams into a separate message next to each reply, which keeps only the answer."
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+}
+
+// streamModeKey is the chat setting holding its stream mode; unset is
+// opencode.ModeLive.
+const streamModeKey = "streammode"
+
+type streamModeHook struct {
+	b *Bot
+}
+
+// Modes returns the stream's StreamModes: the mode each chat picked with
+// /streammode.
+func (b *Bot) Modes() opencode.StreamModes {
+	return streamModeHook{b: b}
+}
+
+func (h streamModeHook) StreamMode(chatID int64) string {
+	return h.b.chatSetting(chatID, streamModeKey)
+}
+
+// streamModeCommand picks how replies show up while they are written:
+// live edits of one message, a new message per paragraph, or one message
+// at the end. The fewer edits, the fewer wake-ups on some phones.
+func (b *Bot) streamModeCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	descriptions := map[string]string{
+		opencode.ModeLive:    "the reply is edited in place as it is written",
+		opencode.ModeChunked: "each finished paragraph arrives as a new message, without edits",
+		opencode.ModeFinal:   "the reply shows up once, when it is complete",
+	}
+	arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/streammode"))
+	if arg == "" {
+		mode := b.chatSetting(chatID, streamModeKey)
+		if descriptions[mode] == "" {
+			mode = opencode.ModeLive
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("Stream mode: %s (%s). Choose with /streammode live|chunked|final.", mode, descriptions[mode]),
+		})
+		return
+	}
+	if descriptions[arg] == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /streammode [live|chunked|final]"})
+		return
+	}
+	value := arg
+	if arg == opencode.ModeLive {
+		value = ""
+	}
+	if err := b.DB.SetChatSetting(chatID, streamModeKey, value); err != nil {
+		log.Printf("[streamModeCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: fmt.Sprintf("Stream mode: %s. From the next reply on, %s.", arg, descriptions[arg])})
 }
@@ -0,0 +1,60 @@
+package telegram
+
+import (
+	"context"
+	"log"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// completionHook runs when a streamed reply is final: it adds the Save
+// button and, under the tool-call footer, the Expand button, commits the
+// run under /autocommit, posts it to TRANSCRIPT_CHAT, compacts a session
+// that is past AUTO_COMPACT and sends the "reply ready" ping for chats
+// muted with muteFinal.
+type completionHook struct {
+	b     *Bot
+	tgBot *bot.Bot
+}
+
+// Notifier returns the stream's completion hook, sending through tgBot.
+func (b *Bot) Notifier(tgBot *bot.Bot) opencode.CompletionNotifier {
+	return completionHook{b: b, tgBot: tgBot}
+}
+
+// ReplyComplete runs on the SSE reader, so the Telegram calls go out on
+// their own goroutine.
+func (h completionHook) ReplyComplete(chatID int64, messageID int) {
+	h.b.watchRunEnded(chatID)
+	h.b.runEnded(chatID)
+	go h.b.startQueued()
+	if s := h.b.api.get(chatID); s != nil {
+		s.finish()
+		return
+	}
+	go func() {
+		ctx := context.Background()
+		// This also drops the Stop button, when there are none to set.
+		h.b.setReplyButtons(ctx, h.tgBot, chatID, messageID, false, firstToolID(h.b.latestTools(ctx, chatID)))
+		h.b.autocommit(ctx, h.tgBot, chatID, messageID)
+		h.b.emailReply(ctx, chatID)
+		h.b.postTranscript(ctx, h.tgBot, chatID)
+		h.b.recordUsage(ctx, chatID, messageID)
+		h.b.autoCompact(ctx, h.tgBot, chatID)
+		if h.b.muteMode(chatID) != muteFinal {
+			return
+		}
+		msg, err := h.tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          chatID,
+			Text:            "Reply ready",
+			ReplyParameters: &models.ReplyParameters{MessageID: messageID},
+		})
+		if err != nil {
+			log.Printf("[ReplyComplete] Chat %d: %v", chatID, err)
+			return
+		}
+		h.b.track(chatID, msg)
+	}()
+}
@@ -0,0 +1,253 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// contextWarnPercent is how full a session's context window gets before
+// its replies carry a warning suggesting /compact.
+const contextWarnPercent = 80
+
+// autoCompactKey is the chat setting overriding AUTO_COMPACT: a threshold
+// in percent, or "off".
+const autoCompactKey = "compact.auto"
+
+var autoCompactions = metrics.NewCounter("openkh_auto_compactions_total",
+	"Sessions compacted automatically after a reply, by result.", "result")
+
+type usageHook struct {
+	b *Bot
+}
+
+// Usage returns the stream's UsageObserver: it warns above the reply once
+// a session crosses contextWarnPercent of its model's context window.
+func (b *Bot) Usage() opencode.UsageObserver {
+	return usageHook{b: b}
+}
+
+// ContextUsed runs on the SSE reader, so the limit is looked up on its own
+// goroutine.
+func (h usageHook) ContextUsed(chatID int64, sessionID, providerID, modelID string, tokens int) {
+	if h.b.Client == nil || h.b.Stream == nil || modelID == "" {
+		return
+	}
+	go func() {
+		defer errreport.Recover(errreport.Fields{"goroutine": "context-usage", "chat_id": fmt.Sprint(chatID), "session_id": sessionID})
+		limit, err := h.b.Client.ContextLimit(context.Background(), providerID, modelID)
+		if err != nil {
+			log.Printf("[ContextUsed] Chat %d: %v", chatID, err)
+			return
+		}
+		if limit <= 0 {
+			return
+		}
+		if auto := h.b.autoCompactPercent(chatID); auto > 0 && tokens*100 >= limit*auto {
+			h.b.Stream.SetHeader(chatID, fmt.Sprintf("⚠️ Context %s full. The session is compacted when this reply finishes.", usageText(tokens, limit)))
+		} else if tokens*100 >= limit*contextWarnPercent {
+			h.b.Stream.SetHeader(chatID, fmt.Sprintf("⚠️ Context %s full. Run /compact to summarize the session before it overflows.", usageText(tokens, limit)))
+		}
+	}()
+}
+
+// autoCompactPercent is the chat's auto-compaction threshold, 0 if off.
+func (b *Bot) autoCompactPercent(chatID int64) int {
+	switch v := b.chatSetting(chatID, autoCompactKey); v {
+	case "":
+		if b.Config == nil {
+			return 0
+		}
+		return b.Config.AutoCompact
+	case "off":
+		return 0
+	default:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+}
+
+// autoCompact compacts the chat's session between prompts when the reply
+// that just finished left it past the chat's threshold, and says so in
+// the chat. It runs on the completion hook's goroutine.
+func (b *Bot) autoCompact(ctx context.Context, tgBot *bot.Bot, chatID int64) {
+	percent := b.autoCompactPercent(chatID)
+	if percent == 0 || b.Client == nil || b.DB == nil {
+		return
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		return
+	}
+	before, limit, err := b.contextUsage(ctx, sessionID)
+	if err != nil {
+		log.Printf("[autoCompact] Chat %d: %v", chatID, err)
+		return
+	}
+	if limit <= 0 || before*100 < limit*percent {
+		return
+	}
+	providerID, modelID, err := b.summaryModel(ctx, chatID, sessionID)
+	if err == nil {
+		err = b.Client.Summarize(ctx, sessionID, providerID, modelID)
+	}
+	if err != nil {
+		log.Printf("[autoCompact] Chat %d: %v", chatID, err)
+		autoCompactions.Inc("error")
+		b.sendNotice(ctx, tgBot, chatID, fmt.Sprintf("⚠️ Context %s full, but compacting the session failed: %v. Try /compact.", usageText(before, limit), err))
+		return
+	}
+	autoCompactions.Inc("compacted")
+	text := fmt.Sprintf("🗜 Context was %s full, so the session was compacted: later prompts start from a summary of the conversation.", usageText(before, limit))
+	if after, _, err := b.contextUsage(ctx, sessionID); err == nil && after > 0 {
+		text += fmt.Sprintf("\nContext: %s → %s tokens", tokenCount(before), tokenCount(after))
+	}
+	b.sendNotice(ctx, tgBot, chatID, text)
+}
+
+// sendNotice sends text to the chat, silently when the chat is muted.
+func (b *Bot) sendNotice(ctx context.Context, tgBot *bot.Bot, chatID int64, text string) {
+	if _, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:              chatID,
+		Text:                text,
+		DisableNotification: b.Muted(chatID),
+	}); err != nil {
+		log.Printf("[sendNotice] Chat %d: %v", chatID, err)
+	}
+}
+
+// contextUsage returns how much of its model's context window the session
+// uses, going by its last reply. The limit is 0 when it isn't known.
+func (b *Bot) contextUsage(ctx context.Context, sessionID string) (tokens, limit int, err error) {
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		m := messages[i]
+		if m.Role != "assistant" || m.Context == 0 {
+			continue
+		}
+		limit, err := b.Client.ContextLimit(ctx, m.ProviderID, m.ModelID)
+		return m.Context, limit, err
+	}
+	return 0, 0, nil
+}
+
+// usageText is e.g. "85% (170k of 200k tokens)".
+func usageText(tokens, limit int) string {
+	return fmt.Sprintf("%d%% (%s of %s tokens)", tokens*100/limit, tokenCount(tokens), tokenCount(limit))
+}
+
+// tokenCount abbreviates n, e.g. 950, 45.2k or 1M.
+func tokenCount(n int) string {
+	var s string
+	switch {
+	case n >= 1_000_000:
+		s = fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 1_000:
+		s = fmt.Sprintf("%.1fk", float64(n)/1_000)
+	default:
+		return fmt.Sprint(n)
+	}
+	return strings.Replace(s, ".0", "", 1)
+}
+
+// compactCommand has the model summarize the current session, so later
+// prompts start from the summary instead of the full history and the
+// context window is freed up. "/compact auto <percent|off>" sets when it
+// happens on its own.
+func (b *Bot) compactCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	args := strings.Fields(strings.TrimPrefix(update.Message.Text, "/compact"))
+	switch {
+	case len(args) == 0:
+	case args[0] == "auto" && len(args) <= 2:
+		b.autoCompactCommand(ctx, tgBot, chatID, args[1:])
+		return
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /compact, or /compact auto <percent|off>"})
+		return
+	}
+
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+
+	status, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "🗜 Compacting the session..."})
+	if err != nil {
+		log.Printf("[compactCommand] Error: %v", err)
+		return
+	}
+	reply := func(text string) {
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{ChatID: chatID, MessageID: status.ID, Text: text})
+	}
+	before, _, _ := b.contextUsage(ctx, sessionID)
+	providerID, modelID, err := b.summaryModel(ctx, chatID, sessionID)
+	if err == nil {
+		err = b.Client.Summarize(ctx, sessionID, providerID, modelID)
+	}
+	if err != nil {
+		log.Printf("[compactCommand] Error: %v", err)
+		reply("Failed to compact the session: " + err.Error())
+		return
+	}
+	text := "Compacted: the session continues from a summary of the conversation so far."
+	if after, limit, err := b.contextUsage(ctx, sessionID); err == nil && limit > 0 && before > 0 {
+		text += fmt.Sprintf("\nContext: %s → %s", tokenCount(before), usageText(after, limit))
+	}
+	reply(text)
+}
+
+// autoCompactCommand shows or sets the chat's auto-compaction threshold.
+func (b *Bot) autoCompactCommand(ctx context.Context, tgBot *bot.Bot, chatID int64, args []string) {
+	if len(args) == 0 {
+		text := "Auto-compaction is off. Turn it on with /compact auto <percent>, e.g. /compact auto 85"
+		if percent := b.autoCompactPercent(chatID); percent > 0 {
+			text = fmt.Sprintf("Sessions are compacted after a reply that leaves them past %d%% of the context window. /compact auto off turns it off.", percent)
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+		return
+	}
+	percent, err := config.ParseAutoCompact(args[0])
+	if err != nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: fmt.Sprintf("Use a percentage from %d to %d, or off", config.MinAutoCompact, config.MaxAutoCompact)})
+		return
+	}
+	value := strconv.Itoa(percent)
+	if percent == 0 {
+		value = "off"
+	}
+	if err := b.DB.SetChatSetting(chatID, autoCompactKey, value); err != nil {
+		log.Printf("[autoCompactCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	text := "Auto-compaction off"
+	if percent > 0 {
+		text = fmt.Sprintf("Auto-compaction on: sessions past %d%% of the context window are compacted when a reply finishes", percent)
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+}
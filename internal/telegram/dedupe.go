@@ -0,0 +1,81 @@
+package telegram
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+var duplicateUpdates = metrics.NewCounter("openkh_duplicate_updates_total",
+	"Telegram updates skipped for having been processed already.")
+
+const (
+	// recentUpdatesSize is how many update IDs are remembered in memory.
+	recentUpdatesSize = 1000
+	// updateRetention is how long the store remembers an update ID, which
+	// covers long-polling restarts and Telegram's webhook retries.
+	updateRetention = time.Hour
+)
+
+// recentUpdates remembers the IDs of the latest updates, dropping the
+// least recently seen once full.
+type recentUpdates struct {
+	mu    sync.Mutex
+	order *list.List              // update IDs, most recently seen first
+	ids   map[int64]*list.Element // update ID -> its element of order
+}
+
+// seen reports whether id was seen already, and remembers it.
+func (r *recentUpdates) seen(id int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ids == nil {
+		r.order, r.ids = list.New(), make(map[int64]*list.Element)
+	}
+	if e, ok := r.ids[id]; ok {
+		r.order.MoveToFront(e)
+		return true
+	}
+	r.ids[id] = r.order.PushFront(id)
+	if r.order.Len() > recentUpdatesSize {
+		delete(r.ids, r.order.Remove(r.order.Back()).(int64))
+	}
+	return false
+}
+
+// skipDuplicates drops updates processed already. Recent IDs are checked
+// in memory, then recorded in the store, so an update delivered again
+// after a restart, or to another replica, is skipped too. It runs behind
+// the dispatcher, so only updates that were queued are marked, and a
+// chat's duplicates queued together are checked one after the other.
+func (b *Bot) skipDuplicates(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+		if b.recent.seen(update.ID) || !b.markUpdate(update.ID) {
+			duplicateUpdates.Inc()
+			log.Printf("[dedupe] Skipping update %d, processed already", update.ID)
+			return
+		}
+		next(ctx, tgBot, update)
+	}
+}
+
+// markUpdate records the update in the store and reports whether it is
+// new. If the store fails the update counts as new: a duplicate prompt is
+// better than a lost one.
+func (b *Bot) markUpdate(id int64) bool {
+	if b.DB == nil {
+		return true
+	}
+	fresh, err := b.DB.MarkUpdate(id, time.Now().Add(updateRetention))
+	if err != nil {
+		log.Printf("[dedupe] Warning: could not record update %d: %v", id, err)
+		return true
+	}
+	return fresh
+}
@@ -0,0 +1,361 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// maxDiffButtons caps the per-file expand buttons under a diffstat.
+	maxDiffButtons = 20
+	// diffContext is the number of unchanged lines shown around a change.
+	diffContext = 3
+	// maxDiffCells bounds the line-matching table; larger changes are
+	// shown as a plain removal and addition.
+	maxDiffCells = 1 << 20
+)
+
+// diffCommand shows a diffstat of the session's changes with buttons to
+// expand single files, or with an argument the diff of one file.
+func (b *Bot) diffCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+	if b.Client == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "OpenCode client not initialized"})
+		return
+	}
+
+	path := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/diff"))
+
+	diffs, err := b.Client.GetFileDiffs(ctx, sessionID)
+	if err != nil {
+		// Older servers return a plain-text diff; show it as before.
+		diff, rawErr := b.Client.GetDiff(ctx, sessionID)
+		if rawErr != nil {
+			log.Printf("[diffCommand] Error: %v", rawErr)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get diff"})
+			return
+		}
+		if diff == "" {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No changes"})
+			return
+		}
+		keyboard := [][]models.InlineKeyboardButton{summaryButton()}
+		if row := b.gistButton(""); row != nil {
+			keyboard = append(keyboard, row)
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      chatID,
+			Text:        b.truncate("Current Changes\n\n" + diff),
+			ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+		})
+		return
+	}
+	if len(diffs) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No changes"})
+		return
+	}
+
+	if path != "" {
+		tgBot.SendMessage(ctx, b.fileDiffMessage(chatID, diffs, path))
+		return
+	}
+
+	var summary *opencode.OCSession
+	if sess, err := b.Client.GetOCSession(ctx, sessionID); err == nil {
+		summary = &sess
+	}
+	text, keyboard := b.diffStat(summary, diffs)
+	params := &bot.SendMessageParams{ChatID: chatID, Text: text}
+	if len(keyboard) > 0 {
+		params.ReplyMarkup = &models.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+	}
+	tgBot.SendMessage(ctx, params)
+}
+
+// handleDiffCallback sends the diff of the file named in a "diff_<path>"
+// button below the diffstat.
+func (b *Bot) handleDiffCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, path string) {
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" || b.Client == nil {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: callback.ID,
+			Text:            "No active session",
+		})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: callback.ID,
+			Text:            refusal,
+			ShowAlert:       true,
+		})
+		return
+	}
+	diffs, err := b.Client.GetFileDiffs(ctx, sessionID)
+	if err != nil {
+		log.Printf("[handleDiffCallback] Error: %v", err)
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: callback.ID,
+			Text:            "Failed to get diff",
+		})
+		return
+	}
+	tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID})
+	tgBot.SendMessage(ctx, b.fileDiffMessage(chatID, diffs, path))
+}
+
+// fileDiffMessage sends fileDiffText, with a Share as Gist button when
+// path matches a single file.
+func (b *Bot) fileDiffMessage(chatID int64, diffs []opencode.FileDiff, path string) *bot.SendMessageParams {
+	params := &bot.SendMessageParams{ChatID: chatID, Text: b.fileDiffText(diffs, path)}
+	if matches := matchDiffs(diffs, path); len(matches) == 1 {
+		if markup := b.gistMarkup(matches[0].File); markup != nil {
+			params.ReplyMarkup = markup
+		}
+	}
+	return params
+}
+
+// diffStat renders "N files, +A/−D" and a line per file. Totals come from
+// the session summary when the server filled it in.
+func (b *Bot) diffStat(sess *opencode.OCSession, diffs []opencode.FileDiff) (string, [][]models.InlineKeyboardButton) {
+	files, additions, deletions := len(diffs), 0, 0
+	for _, d := range diffs {
+		additions += d.Additions
+		deletions += d.Deletions
+	}
+	if sess != nil && sess.Summary.Files > 0 {
+		files, additions, deletions = sess.Summary.Files, sess.Summary.Additions, sess.Summary.Deletions
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d file%s changed, +%d/−%d\n\n", files, plural(files), additions, deletions)
+	keyboard := [][]models.InlineKeyboardButton{summaryButton()}
+	hidden := 0
+	for _, d := range diffs {
+		fmt.Fprintf(&sb, "+%d/−%d  %s\n", d.Additions, d.Deletions, d.File)
+		data := "diff_" + d.File
+		if len(keyboard) > maxDiffButtons || len(data) > callbackDataLimit {
+			hidden++
+			continue
+		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{Text: d.File, CallbackData: data}})
+	}
+	if hidden > 0 {
+		sb.WriteString("\nUse /diff <path> for files without a button.")
+	}
+	if row := b.gistButton(""); row != nil {
+		keyboard = append(keyboard, row)
+	}
+	return b.truncate(strings.TrimSuffix(sb.String(), "\n")), keyboard
+}
+
+// matchDiffs returns the diff of the file named path, or the diffs of
+// the files whose path ends in it.
+func matchDiffs(diffs []opencode.FileDiff, path string) []opencode.FileDiff {
+	var matches []opencode.FileDiff
+	for _, d := range diffs {
+		if d.File == path {
+			return []opencode.FileDiff{d}
+		}
+		if strings.HasSuffix(d.File, "/"+path) {
+			matches = append(matches, d)
+		}
+	}
+	return matches
+}
+
+// fileDiffText renders the diff of the file matching path, which may be a
+// trailing part of the file's path as long as it is unambiguous.
+func (b *Bot) fileDiffText(diffs []opencode.FileDiff, path string) string {
+	matches := matchDiffs(diffs, path)
+	switch len(matches) {
+	case 0:
+		return "No changes to " + path
+	case 1:
+	default:
+		var sb strings.Builder
+		sb.WriteString(path + " matches several files:\n")
+		for _, d := range matches {
+			sb.WriteString("/diff " + d.File + "\n")
+		}
+		return b.truncate(strings.TrimSuffix(sb.String(), "\n"))
+	}
+	d := matches[0]
+	body := unifiedDiff(d.Before, d.After, diffContext)
+	if body == "" {
+		body = "(no line changes)"
+	}
+	return b.truncate(fmt.Sprintf("%s +%d/−%d\n\n%s", d.File, d.Additions, d.Deletions, body))
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// diffLine is one line of a diff: ' ' unchanged, '-' removed, '+' added.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff renders before→after as unified diff hunks with n lines of
+// context.
+func unifiedDiff(before, after string, n int) string {
+	lines := diffLines(splitLines(before), splitLines(after))
+
+	var sb strings.Builder
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		// Grow the hunk while the next change is within 2n lines.
+		start := max(0, i-n)
+		end := i
+		for j := i; j < len(lines) && j <= end+2*n; j++ {
+			if lines[j].op != ' ' {
+				end = j
+			}
+		}
+		end = min(len(lines), end+n+1)
+
+		oldStart, newStart := 1, 1
+		for _, l := range lines[:start] {
+			if l.op != '+' {
+				oldStart++
+			}
+			if l.op != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				oldCount++
+			}
+			if l.op != '-' {
+				newCount++
+			}
+		}
+		// An empty range names the line before it, as in "@@ -0,0 +1 @@".
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, l := range lines[start:end] {
+			sb.WriteByte(l.op)
+			sb.WriteString(l.text)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines matches a against b by longest common subsequence after
+// trimming their common prefix and suffix.
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	out := make([]diffLine, 0, len(a)+len(b))
+	for _, l := range a[:prefix] {
+		out = append(out, diffLine{' ', l})
+	}
+	x, y := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(x)+1)*(len(y)+1) > maxDiffCells {
+		for _, l := range x {
+			out = append(out, diffLine{'-', l})
+		}
+		for _, l := range y {
+			out = append(out, diffLine{'+', l})
+		}
+	} else {
+		out = append(out, lcsDiff(x, y)...)
+	}
+	for _, l := range a[len(a)-suffix:] {
+		out = append(out, diffLine{' ', l})
+	}
+	return out
+}
+
+func lcsDiff(x, y []string) []diffLine {
+	w := len(y) + 1
+	// lcs[i*w+j] is the LCS length of x[i:] and y[j:].
+	lcs := make([]int32, (len(x)+1)*w)
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else {
+				lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+			}
+		}
+	}
+
+	var out []diffLine
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, diffLine{' ', x[i]})
+			i++
+			j++
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			out = append(out, diffLine{'-', x[i]})
+			i++
+		default:
+			out = append(out, diffLine{'+', y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		out = append(out, diffLine{'-', x[i]})
+	}
+	for ; j < len(y); j++ {
+		out = append(out, diffLine{'+', y[j]})
+	}
+	return out
+}
@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// diffSummaryCallback is the callback data of the Summarize button under
+// /diff.
+const diffSummaryCallback = "diffsum"
+
+// maxSummaryPatch caps the patch sent to the model; the rest of a larger
+// change is left out and the summary says so.
+const maxSummaryPatch = 60000
+
+const diffSummaryPrompt = `Summarize the following code changes for someone reading on a phone.
+Answer with exactly 5 short bullet points in plain text, no headings and no code blocks:
+what changed and why it matters, most important first.
+
+`
+
+func summaryButton() []models.InlineKeyboardButton {
+	return []models.InlineKeyboardButton{{Text: "📝 Summarize", CallbackData: diffSummaryCallback}}
+}
+
+// handleDiffSummaryCallback has a model explain the session's changes in
+// five bullets, in a throwaway session so the chat's own isn't touched.
+func (b *Bot) handleDiffSummaryCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64) {
+	answer := func(text string) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: text})
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" || b.Client == nil {
+		answer("No active session")
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: refusal, ShowAlert: true})
+		return
+	}
+	answer("")
+
+	status, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "📝 Summarizing the changes..."})
+	if err != nil {
+		log.Printf("[handleDiffSummaryCallback] Error: %v", err)
+		return
+	}
+	reply := func(text string) {
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{ChatID: chatID, MessageID: status.ID, Text: b.truncate(text)})
+	}
+	summary, err := b.summarizeDiff(ctx, chatID, sessionID)
+	if err != nil {
+		log.Printf("[handleDiffSummaryCallback] Error: %v", err)
+		reply("Failed to summarize the changes: " + err.Error())
+		return
+	}
+	reply("📝 Summary of the changes\n\n" + summary)
+}
+
+// summarizeDiff asks SUMMARY_MODEL, else the chat's model, to explain
+// the session's changes.
+func (b *Bot) summarizeDiff(ctx context.Context, chatID int64, sessionID string) (string, error) {
+	patch, err := b.sessionPatch(ctx, sessionID, "")
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(patch) == "" {
+		return "", fmt.Errorf("no changes")
+	}
+	if len(patch) > maxSummaryPatch {
+		patch = strings.ToValidUTF8(patch[:maxSummaryPatch], "") + "\n[the rest of the patch was cut; mention that the summary covers only part of it]"
+	}
+
+	providerID, modelID, ok := config.SplitModel(b.Config.SummaryModel)
+	if !ok {
+		if providerID, modelID, err = b.summaryModel(ctx, chatID, sessionID); err != nil {
+			return "", err
+		}
+	}
+	oc, err := b.Client.GetOCSession(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := b.Client.CreateOCSession(ctx, "Diff summary", oc.Directory)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := b.Client.DeleteOCSession(context.Background(), tmp.ID); err != nil {
+			log.Printf("[summarizeDiff] Error deleting %s: %v", tmp.ID, err)
+		}
+	}()
+	summary, err := b.Client.Prompt(ctx, tmp.ID, diffSummaryPrompt+patch, opencode.PromptOptions{
+		Agent:      modePlan,
+		ProviderID: providerID,
+		ModelID:    modelID,
+	})
+	if err != nil {
+		return "", err
+	}
+	if summary = strings.TrimSpace(summary); summary == "" {
+		return "", fmt.Errorf("the model returned no summary")
+	}
+	return summary, nil
+}
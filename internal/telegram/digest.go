@@ -0,0 +1,236 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// digestKey is the chat setting turning the daily digest on: the local
+	// hour it is sent at.
+	digestKey = "digest"
+	// digestSentKey is the chat setting holding the day the last digest
+	// covered, so each day is reported once.
+	digestSentKey = "digest.sent"
+	// defaultDigestHour is when the digest is sent unless /digest on says.
+	defaultDigestHour = 8
+	// usageRetentionDays is how long daily usage is kept for digests and
+	// /leaderboard.
+	usageRetentionDays = 30
+	// dayLayout is how usage days are keyed.
+	dayLayout = "2006-01-02"
+)
+
+// digestHour is the local hour the chat's digest is sent at, or -1 if the
+// chat hasn't turned it on.
+func (b *Bot) digestHour(chatID int64) int {
+	v := b.chatSetting(chatID, digestKey)
+	if v == "" {
+		return -1
+	}
+	hour, err := strconv.Atoi(v)
+	if err != nil {
+		return defaultDigestHour
+	}
+	return hour
+}
+
+// recordUsage adds the reply that just finished to the chat's usage for
+// today, if the chat gets a digest or LEADERBOARD is on. It runs on the
+// completion hook's goroutine.
+func (b *Bot) recordUsage(ctx context.Context, chatID int64, messageID int) {
+	if b.DB == nil || b.Client == nil || (b.digestHour(chatID) < 0 && !b.leaderboardOn()) {
+		return
+	}
+	cached, err := b.DB.GetMessageText(chatID)
+	sessionID := cached.SessionID
+	if err != nil || cached.MessageID != messageID {
+		sessionID = b.currentSessionID(chatID)
+	}
+	if sessionID == "" {
+		return
+	}
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		log.Printf("[recordUsage] Chat %d: %v", chatID, err)
+		return
+	}
+	u := store.Usage{
+		ChatID:    chatID,
+		Day:       time.Now().In(b.location(chatID)).Format(dayLayout),
+		SessionID: sessionID,
+		Prompts:   1,
+	}
+	for i := len(messages) - 1; i >= 0 && messages[i].Role != "user"; i-- {
+		u.Tokens += messages[i].Tokens
+		u.Cost += messages[i].Cost
+	}
+	if err := b.DB.AddUsage(u); err != nil {
+		log.Printf("[recordUsage] Chat %d: %v", chatID, err)
+	}
+}
+
+// digestCommand turns the daily digest on or off: each morning it sums up
+// the previous day's sessions in the chat. "/digest today" shows today's
+// so far.
+func (b *Bot) digestCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	args := strings.Fields(strings.TrimPrefix(update.Message.Text, "/digest"))
+	switch {
+	case len(args) == 0:
+		text := "The daily digest is off. /digest on sends a summary of each day's sessions the next morning."
+		if hour := b.digestHour(chatID); hour >= 0 {
+			text = fmt.Sprintf("The daily digest is on: yesterday's sessions are summed up at %02d:00 (%s). /digest off turns it off.", hour, b.location(chatID))
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+	case args[0] == "on" && len(args) <= 2:
+		hour := defaultDigestHour
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 || n > 23 {
+				tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Use an hour from 0 to 23, e.g. /digest on 9"})
+				return
+			}
+			hour = n
+		}
+		// Yesterday wasn't recorded, so the first digest is tomorrow's.
+		yesterday := time.Now().In(b.location(chatID)).AddDate(0, 0, -1).Format(dayLayout)
+		if err := b.DB.SetChatSetting(chatID, digestSentKey, yesterday); err != nil {
+			log.Printf("[digestCommand] Error: %v", err)
+		}
+		if err := b.DB.SetChatSetting(chatID, digestKey, strconv.Itoa(hour)); err != nil {
+			log.Printf("[digestCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("Daily digest on: from now on each day's prompts, changes, tokens and cost are summed up at %02d:00 (%s) the next day. Set the time zone with /tz.", hour, b.location(chatID)),
+		})
+	case args[0] == "off" && len(args) == 1:
+		if err := b.DB.SetChatSetting(chatID, digestKey, ""); err != nil {
+			log.Printf("[digestCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Daily digest off"})
+	case args[0] == "today" && len(args) == 1:
+		if b.digestHour(chatID) < 0 {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Activity is only recorded while the digest is on. Turn it on with /digest on"})
+			return
+		}
+		today := time.Now().In(b.location(chatID))
+		text, err := b.digestText(ctx, chatID, today)
+		if err != nil {
+			log.Printf("[digestCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get today's activity"})
+			return
+		}
+		if text == "" {
+			text = "No prompts recorded today yet"
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(text)})
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /digest on [hour], /digest off or /digest today"})
+	}
+}
+
+// digestText sums up the chat's sessions on day, or returns "" if it had
+// none.
+func (b *Bot) digestText(ctx context.Context, chatID int64, day time.Time) (string, error) {
+	entries, err := b.DB.ListUsage(chatID, day.Format(dayLayout))
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📊 Activity on %s\n\n", day.Format("Monday, 2 Jan 2006"))
+	var prompts, tokens int
+	var cost float64
+	for _, u := range entries {
+		prompts += u.Prompts
+		tokens += u.Tokens
+		cost += u.Cost
+		title, changes := u.SessionID, ""
+		if b.Client != nil {
+			if s, err := b.Client.GetOCSession(ctx, u.SessionID); err != nil {
+				log.Printf("[digestText] Session %s: %v", u.SessionID, err)
+				title += " (deleted)"
+			} else {
+				if s.Title != "" {
+					title = s.Title
+				}
+				if s.Summary.Files > 0 {
+					changes = fmt.Sprintf("\n  Changes: %d file%s, +%d −%d", s.Summary.Files, plural(s.Summary.Files), s.Summary.Additions, s.Summary.Deletions)
+				}
+			}
+		}
+		fmt.Fprintf(&sb, "• %s\n  %d prompt%s, %s tokens, $%.2f%s\n", title, u.Prompts, plural(u.Prompts), tokenCount(u.Tokens), u.Cost, changes)
+	}
+	fmt.Fprintf(&sb, "\nTotal: %d session%s, %d prompt%s, %s tokens, $%.2f",
+		len(entries), plural(len(entries)), prompts, plural(prompts), tokenCount(tokens), cost)
+	return sb.String(), nil
+}
+
+// sendDigests sends yesterday's digest to each chat that has it on, once
+// its hour has come in the chat's time zone, and forgets old usage. It runs
+// as a scheduler job.
+func (b *Bot) sendDigests(ctx context.Context, tgBot *bot.Bot) error {
+	sessions, err := b.chatSessions()
+	if err != nil {
+		return fmt.Errorf("list chats: %w", err)
+	}
+	for _, s := range sessions {
+		hour := b.digestHour(s.ChatID)
+		if hour < 0 {
+			continue
+		}
+		now := time.Now().In(b.location(s.ChatID))
+		yesterday := now.AddDate(0, 0, -1)
+		day := yesterday.Format(dayLayout)
+		if now.Hour() < hour || b.chatSetting(s.ChatID, digestSentKey) >= day {
+			continue
+		}
+		text, err := b.digestText(ctx, s.ChatID, yesterday)
+		if err != nil {
+			log.Printf("[sendDigests] Chat %d: %v", s.ChatID, err)
+			continue
+		}
+		if text != "" {
+			if _, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:              s.ChatID,
+				Text:                b.truncate(text),
+				DisableNotification: b.Muted(s.ChatID),
+			}); err != nil {
+				log.Printf("[sendDigests] Chat %d: %v", s.ChatID, err)
+				continue
+			}
+		}
+		if err := b.DB.SetChatSetting(s.ChatID, digestSentKey, day); err != nil {
+			log.Printf("[sendDigests] Chat %d: %v", s.ChatID, err)
+		}
+	}
+
+	n, err := b.DB.DeleteUsageBefore(time.Now().AddDate(0, 0, -usageRetentionDays).Format(dayLayout))
+	if err != nil {
+		return fmt.Errorf("delete old usage: %w", err)
+	}
+	if n > 0 {
+		log.Printf("[janitor] Removed %d old usage record(s)", n)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+var (
+	dispatchActive = metrics.NewGauge("openkh_dispatch_active_chats",
+		"Chats with queued or running updates.")
+	dispatchDropped = metrics.NewCounter("openkh_dispatch_dropped_total",
+		"Updates dropped because the chat's queue was full.")
+)
+
+// dispatcher runs updates for the same chat one at a time, in arrival
+// order, while different chats proceed in parallel on at most `workers`
+// goroutines. The telegram library calls each handler on its own
+// goroutine, so without this two quick messages from one chat race on
+// the session row.
+type dispatcher struct {
+	sem      chan struct{}
+	maxQueue int
+
+	mu     sync.Mutex
+	queues map[int64][]func() // pending work per busy chat
+}
+
+func newDispatcher(workers, maxQueue int) *dispatcher {
+	if workers <= 0 {
+		workers = 8
+	}
+	if maxQueue <= 0 {
+		maxQueue = 20
+	}
+	return &dispatcher{
+		sem:      make(chan struct{}, workers),
+		maxQueue: maxQueue,
+		queues:   make(map[int64][]func()),
+	}
+}
+
+// submit queues fn behind any work already pending for chatID. It reports
+// false if the chat's queue is full and fn was dropped.
+func (d *dispatcher) submit(chatID int64, fn func()) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if q, busy := d.queues[chatID]; busy {
+		if len(q) >= d.maxQueue {
+			return false
+		}
+		d.queues[chatID] = append(q, fn)
+		return true
+	}
+	d.queues[chatID] = nil
+	dispatchActive.Add(1)
+	go d.drain(chatID, fn)
+	return true
+}
+
+// drain runs fn and then the chat's queued work until the queue is empty.
+func (d *dispatcher) drain(chatID int64, fn func()) {
+	for fn != nil {
+		d.sem <- struct{}{}
+		fn()
+		<-d.sem
+
+		d.mu.Lock()
+		q := d.queues[chatID]
+		if len(q) == 0 {
+			delete(d.queues, chatID)
+			fn = nil
+			dispatchActive.Add(-1)
+		} else {
+			fn = q[0]
+			d.queues[chatID] = q[1:]
+		}
+		d.mu.Unlock()
+	}
+}
+
+// middleware hands each update to the dispatcher. Updates without a chat
+// run directly.
+func (d *dispatcher) middleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+		chatID, ok := updateChatID(update)
+		if !ok {
+			next(ctx, tgBot, update)
+			return
+		}
+		if !d.submit(chatID, func() { next(ctx, tgBot, update) }) {
+			dispatchDropped.Inc()
+			log.Printf("[dispatcher] Queue full for chat %d, dropping update %d", chatID, update.ID)
+		}
+	}
+}
+
+func updateChatID(update *models.Update) (int64, bool) {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID, true
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID, true
+	case update.CallbackQuery != nil && update.CallbackQuery.Message.Message != nil:
+		return update.CallbackQuery.Message.Message.Chat.ID, true
+	}
+	return 0, false
+}
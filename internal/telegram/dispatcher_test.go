@@ -0,0 +1,144 @@
+package telegram
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// waitFor fails the test if ch isn't closed within a second.
+func waitFor(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestDispatcherOrder(t *testing.T) {
+	d := newDispatcher(4, 10)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	wg.Add(6)
+
+	d.submit(1, func() {
+		defer wg.Done()
+		close(started)
+		<-release
+		mu.Lock()
+		order = append(order, 0)
+		mu.Unlock()
+	})
+	waitFor(t, started, "chat 1's first update")
+	for i := 1; i <= 4; i++ {
+		i := i
+		d.submit(1, func() {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		})
+	}
+
+	// Another chat doesn't wait for chat 1.
+	other := make(chan struct{})
+	d.submit(2, func() {
+		defer wg.Done()
+		close(other)
+	})
+	waitFor(t, other, "chat 2 while chat 1 is busy")
+
+	close(release)
+	wg.Wait()
+	if want := []int{0, 1, 2, 3, 4}; !slices.Equal(order, want) {
+		t.Errorf("chat 1 ran its updates in order %v, want %v", order, want)
+	}
+}
+
+func TestDispatcherFullQueue(t *testing.T) {
+	d := newDispatcher(1, 2)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{})
+	d.submit(1, func() {
+		close(started)
+		<-release
+	})
+	waitFor(t, started, "the running update")
+
+	if !d.submit(1, func() {}) || !d.submit(1, func() { close(done) }) {
+		t.Fatal("submit refused an update within the queue limit")
+	}
+	if d.submit(1, func() { t.Error("a dropped update ran") }) {
+		t.Error("submit accepted an update over the queue limit")
+	}
+	close(release)
+	waitFor(t, done, "the queued updates")
+
+	// Once the chat is idle again it takes updates as before.
+	again := make(chan struct{})
+	if !d.submit(1, func() { close(again) }) {
+		t.Fatal("submit refused an update after the queue drained")
+	}
+	waitFor(t, again, "the update after draining")
+}
+
+func TestDroppedUpdateRedelivered(t *testing.T) {
+	b := &Bot{DB: store.NewMemory(), updates: newDispatcher(1, 1)}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	ran := make(chan int64, 10)
+	handler := func(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+		if update.ID == 1 {
+			close(started)
+			<-release
+		}
+		ran <- update.ID
+	}
+	// Wrapped as the library does, the first middleware outermost.
+	h := bot.HandlerFunc(handler)
+	ms := b.middlewares()
+	for i := len(ms) - 1; i >= 0; i-- {
+		h = ms[i](h)
+	}
+	update := func(id int64) *models.Update {
+		return &models.Update{ID: id, Message: &models.Message{Chat: models.Chat{ID: 7}, Text: "hi"}}
+	}
+
+	h(context.Background(), nil, update(1))
+	waitFor(t, started, "the first update")
+	h(context.Background(), nil, update(2))
+	h(context.Background(), nil, update(3)) // the queue is full: dropped
+	close(release)
+	if got := []int64{<-ran, <-ran}; !slices.Equal(got, []int64{1, 2}) {
+		t.Fatalf("ran updates %v, want [1 2]", got)
+	}
+
+	// Telegram delivers the dropped update again, and the handled ones.
+	for _, id := range []int64{3, 1, 2} {
+		h(context.Background(), nil, update(id))
+	}
+	select {
+	case id := <-ran:
+		if id != 3 {
+			t.Errorf("ran update %d again, want only the dropped update 3", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the redelivered update 3 was skipped")
+	}
+	select {
+	case id := <-ran:
+		t.Errorf("ran update %d twice", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
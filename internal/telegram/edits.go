@@ -0,0 +1,105 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// lastPromptKey is the chat setting holding the chat's latest prompt as
+// "<prompt message ID> <reply message ID> <session ID>".
+const lastPromptKey = "prompt.last"
+
+// lastPrompt ties the user's latest prompt message to the bot's reply.
+type lastPrompt struct {
+	promptID  int
+	replyID   int
+	sessionID string
+}
+
+func (b *Bot) rememberPrompt(chatID int64, p lastPrompt) {
+	if b.DB == nil {
+		return
+	}
+	value := fmt.Sprintf("%d %d %s", p.promptID, p.replyID, p.sessionID)
+	if err := b.DB.SetChatSetting(chatID, lastPromptKey, value); err != nil {
+		log.Printf("[rememberPrompt] Chat %d: %v", chatID, err)
+	}
+}
+
+func (b *Bot) latestPrompt(chatID int64) (lastPrompt, bool) {
+	if b.DB == nil {
+		return lastPrompt{}, false
+	}
+	raw, err := b.DB.GetChatSetting(chatID, lastPromptKey)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[latestPrompt] Chat %d: %v", chatID, err)
+		}
+		return lastPrompt{}, false
+	}
+	var p lastPrompt
+	if _, err := fmt.Sscanf(raw, "%d %d %s", &p.promptID, &p.replyID, &p.sessionID); err != nil {
+		return lastPrompt{}, false
+	}
+	return p, true
+}
+
+// handleEditedMessage re-runs the chat's latest prompt when the user
+// edits it, the way people fix a typo: a reply still streaming is aborted
+// first. Edits to older messages and to commands are ignored.
+func (b *Bot) handleEditedMessage(ctx context.Context, tgBot *bot.Bot, msg *models.Message) {
+	chatID := msg.Chat.ID
+	text := msg.Text
+	if text == "" || strings.HasPrefix(text, "/") {
+		return
+	}
+	if b.Config != nil && !b.checkAuth(chatID) {
+		return
+	}
+
+	last, ok := b.latestPrompt(chatID)
+	if !ok || last.promptID != msg.ID {
+		return
+	}
+	if last.sessionID != b.currentSessionID(chatID) {
+		log.Printf("[handleEditedMessage] Chat %d edited a prompt for session %s after switching, ignoring", chatID, last.sessionID)
+		return
+	}
+	if !b.allowMessage(chatID) {
+		notice, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "Please wait a moment before sending another message...",
+		})
+		b.track(chatID, notice)
+		return
+	}
+
+	if b.Stream != nil {
+		if _, streaming, _ := b.Stream.CurrentText(chatID); streaming {
+			if b.Client != nil {
+				if err := b.Client.Abort(ctx, last.sessionID); err != nil {
+					log.Printf("[handleEditedMessage] Error aborting session %s: %v", last.sessionID, err)
+				}
+			}
+			// Stop streaming into the old reply before the new run starts.
+			b.Stream.UnregisterSession(last.sessionID)
+			tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+				ChatID:    chatID,
+				MessageID: last.replyID,
+				Text:      "Stopped: the prompt was edited.",
+			})
+			b.trackID(chatID, last.replyID)
+		}
+	}
+
+	log.Printf("[handleEditedMessage] Chat %d edited prompt %d, re-running", chatID, msg.ID)
+	b.rememberSender(msg.From)
+	b.runPrompt(ctx, tgBot, chatID, senderID(chatID, msg.From), msg.ID, text)
+}
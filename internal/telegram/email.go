@@ -0,0 +1,178 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Chat settings for /notify email.
+const (
+	emailKey     = "notify.email"      // the address replies are mailed to
+	emailModeKey = "notify.email.mode" // emailAlways, or empty for long runs only
+	emailAlways  = "always"
+)
+
+// maxEmailDiff caps the diff in a notification email, in characters.
+const maxEmailDiff = 256 << 10
+
+var emailsSent = metrics.NewCounter("openkh_email_notifications_total",
+	"Reply notification emails, by result.", "result")
+
+// promptSent records when chatID's running prompt was sent, so the
+// completion hook can tell a long run.
+func (b *Bot) promptSent(chatID int64) {
+	b.runStarts.Store(chatID, time.Now())
+}
+
+// notifyCommand manages email notifications: "/notify email <address>"
+// mails the replies of long runs there, "always" every reply, "long"
+// goes back to long runs only and "off" removes the address.
+func (b *Bot) notifyCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if b.Mailer == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "SMTP is not configured"})
+		return
+	}
+
+	args := strings.Fields(strings.TrimPrefix(update.Message.Text, "/notify"))
+	if len(args) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.notifySummary(chatID)})
+		return
+	}
+	if len(args) != 2 || args[0] != "email" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /notify email <address|always|long|off>"})
+		return
+	}
+
+	key, value := emailModeKey, ""
+	switch arg := args[1]; arg {
+	case "always":
+		value = emailAlways
+	case "long":
+	case "off":
+		key = emailKey
+	default:
+		addr, err := mail.ParseAddress(arg)
+		if err != nil {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Invalid email address: " + arg})
+			return
+		}
+		key, value = emailKey, addr.Address
+	}
+	if key == emailModeKey && b.chatSetting(chatID, emailKey) == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Set an address first: /notify email <address>"})
+		return
+	}
+	if err := b.DB.SetChatSetting(chatID, key, value); err != nil {
+		log.Printf("[notifyCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	if args[1] == "off" {
+		if err := b.DB.SetChatSetting(chatID, emailModeKey, ""); err != nil {
+			log.Printf("[notifyCommand] Error: %v", err)
+		}
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.notifySummary(chatID)})
+}
+
+func (b *Bot) notifySummary(chatID int64) string {
+	to := b.chatSetting(chatID, emailKey)
+	switch {
+	case to == "":
+		return fmt.Sprintf("Email notifications are off.\nUse /notify email <address> to get the reply and diff of runs longer than %s by email.", b.Config.EmailAfter)
+	case b.chatSetting(chatID, emailModeKey) == emailAlways:
+		return fmt.Sprintf("Every reply and its diff is emailed to %s.\n/notify email long: only runs longer than %s\n/notify email off: stop", to, b.Config.EmailAfter)
+	default:
+		return fmt.Sprintf("Replies of runs longer than %s are emailed to %s, with the diff.\n/notify email always: every reply\n/notify email off: stop", b.Config.EmailAfter, to)
+	}
+}
+
+// emailReply mails the finished reply and the session's diff when the
+// chat asked for it: always, or when the run took longer than
+// EMAIL_AFTER. It runs on the completion hook's goroutine.
+func (b *Bot) emailReply(ctx context.Context, chatID int64) {
+	v, ok := b.runStarts.LoadAndDelete(chatID)
+	if b.Mailer == nil || b.DB == nil {
+		return
+	}
+	to := b.chatSetting(chatID, emailKey)
+	if to == "" {
+		return
+	}
+	var took time.Duration
+	if ok {
+		took = time.Since(v.(time.Time))
+	}
+	if b.chatSetting(chatID, emailModeKey) != emailAlways && took < b.Config.EmailAfter {
+		return
+	}
+
+	msg, err := b.DB.GetMessageText(chatID)
+	if err != nil {
+		log.Printf("[emailReply] Chat %d: no reply to send: %v", chatID, err)
+		return
+	}
+	title := shortID(msg.SessionID)
+	if sess, err := b.DB.GetSession(chatID); err == nil && sess.SessionID == msg.SessionID && sess.Title != "" {
+		title = sess.Title
+	}
+	subject := "OpenCode reply: " + title
+	if took > 0 {
+		subject = fmt.Sprintf("OpenCode reply after %s: %s", took.Round(time.Second), title)
+	}
+
+	var body strings.Builder
+	body.WriteString(msg.Text)
+	if diff := b.emailDiff(ctx, msg.SessionID); diff != "" {
+		body.WriteString("\n\n-- Changes --\n\n")
+		body.WriteString(diff)
+	}
+	if err := b.Mailer.Send(ctx, to, subject, body.String()); err != nil {
+		log.Printf("[emailReply] Error mailing chat %d: %v", chatID, err)
+		emailsSent.Inc("failed")
+		return
+	}
+	log.Printf("[emailReply] Mailed the reply of chat %d", chatID)
+	emailsSent.Inc("sent")
+}
+
+// emailDiff renders the session's changes as unified diffs, or the
+// server's plain-text diff on older servers.
+func (b *Bot) emailDiff(ctx context.Context, sessionID string) string {
+	if b.Client == nil || sessionID == "" {
+		return ""
+	}
+	var sb strings.Builder
+	diffs, err := b.Client.GetFileDiffs(ctx, sessionID)
+	if err != nil {
+		raw, rawErr := b.Client.GetDiff(ctx, sessionID)
+		if rawErr != nil {
+			log.Printf("[emailDiff] Error: %v", rawErr)
+			return ""
+		}
+		sb.WriteString(raw)
+	}
+	for _, d := range diffs {
+		fmt.Fprintf(&sb, "--- %s +%d/-%d\n%s\n", d.File, d.Additions, d.Deletions, unifiedDiff(d.Before, d.After, diffContext))
+	}
+	if sb.Len() > maxEmailDiff {
+		return tgtext.Clip(sb.String(), maxEmailDiff) + "\n[diff truncated, see /diff]"
+	}
+	return sb.String()
+}
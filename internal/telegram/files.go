@@ -0,0 +1,214 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// Callback data of /ls buttons, followed by a path relative to the
+	// project directory: lsDirPrefix opens a folder, lsFilePrefix previews
+	// a file.
+	lsDirPrefix  = "ls_"
+	lsFilePrefix = "lsf_"
+	// maxLsButtons caps the entries /ls shows of a directory.
+	maxLsButtons = 40
+	// previewLines caps the lines a file preview shows.
+	previewLines = 60
+)
+
+// lsCommand browses the working directory with buttons: folders open in
+// place, files are previewed. "/ls <path>" starts in a subdirectory.
+func (b *Bot) lsCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if refusal := b.lsRefusal(chatID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+
+	p, ok := cleanRelPath(strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/ls")))
+	if !ok {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Use a path inside the working directory, e.g. /ls internal"})
+		return
+	}
+	text, keyboard, err := b.lsView(ctx, chatID, p)
+	if err != nil {
+		log.Printf("[lsCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to list " + lsName(p) + ": " + err.Error()})
+		return
+	}
+	msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      chatID,
+		Text:        text,
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+	if err != nil {
+		log.Printf("[lsCommand] Error: %v", err)
+		return
+	}
+	b.track(chatID, msg)
+}
+
+// lsRefusal says why the chat can't browse files, or "" if it can.
+func (b *Bot) lsRefusal(chatID int64) string {
+	if b.Client == nil {
+		return "OpenCode client not initialized"
+	}
+	if sessionID := b.currentSessionID(chatID); sessionID != "" {
+		return b.lockRefusal(chatID, sessionID)
+	}
+	return ""
+}
+
+// cleanRelPath cleans p and reports whether it stays inside the project
+// directory. The project directory itself is "".
+func cleanRelPath(p string) (string, bool) {
+	if path.IsAbs(p) {
+		return "", false
+	}
+	switch p = path.Clean(p); {
+	case p == ".":
+		return "", true
+	case p == ".." || strings.HasPrefix(p, "../"):
+		return "", false
+	}
+	return p, true
+}
+
+// lsName is how /ls shows the directory at p.
+func lsName(p string) string {
+	if p == "" {
+		return "the working directory"
+	}
+	return p
+}
+
+// lsView renders the directory at p: a header and a button per entry,
+// folders first.
+func (b *Bot) lsView(ctx context.Context, chatID int64, p string) (string, [][]models.InlineKeyboardButton, error) {
+	dir := b.projectDir(ctx, chatID)
+	nodes, err := b.Client.ListFiles(ctx, dir, p)
+	if err != nil {
+		return "", nil, err
+	}
+	var shown []opencode.FileNode
+	for _, n := range nodes {
+		if !n.Ignored {
+			shown = append(shown, n)
+		}
+	}
+	sort.Slice(shown, func(i, j int) bool {
+		if di, dj := shown[i].Type == "directory", shown[j].Type == "directory"; di != dj {
+			return di
+		}
+		return strings.ToLower(shown[i].Name) < strings.ToLower(shown[j].Name)
+	})
+
+	var keyboard [][]models.InlineKeyboardButton
+	if p != "" {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{Text: "⬆️ ..", CallbackData: lsDirPrefix + path.Dir("/" + p)[1:]}})
+	}
+	folders, hidden := 0, 0
+	for _, n := range shown {
+		label, data := "📄 "+n.Name, lsFilePrefix+n.Path
+		if n.Type == "directory" {
+			folders++
+			label, data = "📁 "+n.Name+"/", lsDirPrefix+n.Path
+		}
+		if len(keyboard) >= maxLsButtons || len(data) > callbackDataLimit {
+			hidden++
+			continue
+		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{Text: label, CallbackData: data}})
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📂 %s\n", path.Join(dir, p))
+	fmt.Fprintf(&sb, "%d folder%s, %d file%s", folders, plural(folders), len(shown)-folders, plural(len(shown)-folders))
+	if skipped := len(nodes) - len(shown); skipped > 0 {
+		fmt.Fprintf(&sb, " (%d ignored not shown)", skipped)
+	}
+	if hidden > 0 {
+		fmt.Fprintf(&sb, "\n%d entries have no button; open them with /ls <path>.", hidden)
+	}
+	return sb.String(), keyboard, nil
+}
+
+// handleLsCallback opens the folder a /ls button is for in place, or
+// previews the file.
+func (b *Bot) handleLsCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, data string) {
+	answer := func(text string) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: text})
+	}
+	if refusal := b.lsRefusal(chatID); refusal != "" {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: refusal, ShowAlert: true})
+		return
+	}
+	rest, file := strings.CutPrefix(data, lsFilePrefix)
+	if !file {
+		rest = strings.TrimPrefix(data, lsDirPrefix)
+	}
+	p, ok := cleanRelPath(rest)
+	if !ok {
+		answer("Invalid path")
+		return
+	}
+
+	if file {
+		b.previewFile(ctx, tgBot, chatID, p, answer)
+		return
+	}
+	text, keyboard, err := b.lsView(ctx, chatID, p)
+	if err != nil {
+		log.Printf("[handleLsCallback] Error: %v", err)
+		answer("Failed to list " + lsName(p))
+		return
+	}
+	answer("")
+	tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:      chatID,
+		MessageID:   callback.Message.Message.ID,
+		Text:        text,
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+}
+
+// previewFile sends the first lines of the file at p.
+func (b *Bot) previewFile(ctx context.Context, tgBot *bot.Bot, chatID int64, p string, answer func(string)) {
+	file, err := b.Client.ReadFile(ctx, b.projectDir(ctx, chatID), p)
+	if err != nil {
+		log.Printf("[previewFile] Error: %v", err)
+		answer("Failed to read " + p)
+		return
+	}
+	answer("")
+	text := "📄 " + p + "\n\n"
+	switch {
+	case file.Type == "binary":
+		text += "(binary file)"
+	case file.Content == "":
+		text += "(empty file)"
+	default:
+		lines := strings.Split(strings.TrimSuffix(file.Content, "\n"), "\n")
+		if len(lines) > previewLines {
+			text += strings.Join(lines[:previewLines], "\n") + fmt.Sprintf("\n\n… %d more lines", len(lines)-previewLines)
+		} else {
+			text += strings.Join(lines, "\n")
+		}
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(text)})
+}
@@ -0,0 +1,94 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// followKey is the chat setting holding the session /follow streams to the
+// chat.
+const followKey = "follow"
+
+// ResumeFollows subscribes the chats that /follow a session again after a
+// restart. Chats are found through their sessions, as elsewhere.
+func (b *Bot) ResumeFollows() {
+	if b.DB == nil || b.Stream == nil {
+		return
+	}
+	sessions, err := b.chatSessions()
+	if err != nil {
+		log.Printf("[ResumeFollows] Error: %v", err)
+		return
+	}
+	for _, s := range sessions {
+		if sessionID := b.chatSetting(s.ChatID, followKey); sessionID != "" {
+			b.Stream.Subscribe(sessionID, s.ChatID)
+		}
+	}
+}
+
+// followCommand streams another session's replies to this chat as they are
+// written, next to the chat that sent the prompts, e.g. to look over a
+// teammate's shoulder. "/follow off" stops.
+func (b *Bot) followCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	switch arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/follow")); arg {
+	case "":
+		text := "Not following a session. /follow <session_id> streams its replies here as they are written."
+		if sessionID := b.chatSetting(chatID, followKey); sessionID != "" {
+			text = fmt.Sprintf("Following session %s. /follow off stops.", shortID(sessionID))
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+	case "off":
+		if err := b.DB.SetChatSetting(chatID, followKey, ""); err != nil {
+			log.Printf("[followCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+		b.Stream.Unsubscribe(chatID)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Stopped following"})
+	default:
+		sessionID := arg
+		if sessionID == b.currentSessionID(chatID) {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "This chat is on that session already"})
+			return
+		}
+		if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+			return
+		}
+		if b.Client != nil {
+			oc, err := b.Client.GetOCSession(ctx, sessionID)
+			if err != nil {
+				tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Session not found"})
+				return
+			}
+			if refusal := b.sessionRefusal(chatID, oc); refusal != "" {
+				tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+				return
+			}
+		}
+		if err := b.DB.SetChatSetting(chatID, followKey, sessionID); err != nil {
+			log.Printf("[followCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+		b.Stream.Subscribe(sessionID, chatID)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("Following session %s: its replies stream here while this chat isn't running one of its own. /follow off stops.", shortID(sessionID)),
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/forge"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// prBranchKey is the chat setting holding the head branch of the chat's
+// last /pr or /mr, whose CI status /status shows.
+const prBranchKey = "forge.branch"
+
+// ciTimeout keeps /status quick when the forge is slow.
+const ciTimeout = 5 * time.Second
+
+// maxPRFiles caps the changed files listed in a pull request description.
+const maxPRFiles = 50
+
+// branchMarker and pushedMarker pick the results of /mr's git commands out
+// of the shell output.
+const (
+	branchMarker = "openkh-branch:"
+	pushedMarker = "openkh-pushed"
+)
+
+// sessionRepo returns the session and the forge repository its directory
+// maps to.
+func (b *Bot) sessionRepo(ctx context.Context, sessionID string) (opencode.OCSession, string, error) {
+	oc, err := b.Client.GetOCSession(ctx, sessionID)
+	if err != nil {
+		return opencode.OCSession{}, "", err
+	}
+	return oc, b.Config.ForgeRepo(oc.Directory), nil
+}
+
+// prCommand opens a pull request from a branch with the session's changes:
+// "/pr <branch> <title>" targets the repository's default branch,
+// "/pr <branch>:<base> <title>" another one.
+func (b *Bot) prCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	branch, title, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/pr")), " ")
+	title = strings.TrimSpace(title)
+	if branch == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /pr <branch>[:<base>] <title>"})
+		return
+	}
+	oc, repo, ok := b.forgeSession(ctx, tgBot, chatID)
+	if !ok {
+		return
+	}
+	if title == "" {
+		title = oc.Title
+	}
+
+	head, base, _ := strings.Cut(branch, ":")
+	if base == "" {
+		var err error
+		if base, err = b.Forge.DefaultBranch(ctx, repo); err != nil {
+			log.Printf("[prCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to look up " + repo + ": " + err.Error()})
+			return
+		}
+	}
+	pr, err := b.Forge.CreatePullRequest(ctx, repo, forge.NewPullRequest{
+		Head:  head,
+		Base:  base,
+		Title: title,
+		Body:  b.prBody(ctx, oc),
+	})
+	if err != nil {
+		log.Printf("[prCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to open the pull request: " + err.Error()})
+		return
+	}
+	if err := b.DB.SetChatSetting(chatID, prBranchKey, head); err != nil {
+		log.Printf("[prCommand] Error saving branch: %v", err)
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("Opened %s in %s (%s → %s):\n%s", pr.Ref, repo, head, base, pr.URL),
+	})
+}
+
+// mrCommand pushes the branch the session's checkout is on and opens a
+// merge request from it into the repository's default branch, titled
+// after the session unless "/mr <title>" says otherwise.
+func (b *Bot) mrCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	title := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/mr"))
+	oc, repo, ok := b.forgeSession(ctx, tgBot, chatID)
+	if !ok {
+		return
+	}
+	if setting, _ := b.toolDenial(chatID, "bash"); setting != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: setting + " denies the shell, so the branch can't be pushed"})
+		return
+	}
+	if title == "" {
+		title = oc.Title
+	}
+	agent, _, _ := b.withDefaults(b.currentAgent(chatID), "", "")
+	if agent == "" {
+		agent = "build"
+	}
+
+	out, err := b.Client.Shell(ctx, oc.ID, agent, `echo "`+branchMarker+`$(git symbolic-ref -q --short HEAD)"`)
+	if err != nil {
+		log.Printf("[mrCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to look up the branch: " + err.Error()})
+		return
+	}
+	head := ""
+	for _, line := range strings.Split(out, "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), branchMarker); ok {
+			head = rest
+		}
+	}
+	if head == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "The session's checkout in " + oc.Directory + " is not on a branch"})
+		return
+	}
+	base, err := b.Forge.DefaultBranch(ctx, repo)
+	if err != nil {
+		log.Printf("[mrCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to look up " + repo + ": " + err.Error()})
+		return
+	}
+	if head == base {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "The session is on " + base + ", the branch merge requests go into. Have it switch to a new branch first."})
+		return
+	}
+
+	out, err = b.Client.Shell(ctx, oc.ID, agent, "git push -q -u origin "+shellQuote(head)+" 2>&1 && echo "+pushedMarker)
+	if err == nil && !strings.Contains(out, pushedMarker) {
+		err = fmt.Errorf("git push: %s", lastLine(out))
+	}
+	if err != nil {
+		log.Printf("[mrCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to push " + head + ": " + err.Error()})
+		return
+	}
+
+	pr, err := b.Forge.CreatePullRequest(ctx, repo, forge.NewPullRequest{
+		Head:  head,
+		Base:  base,
+		Title: title,
+		Body:  b.prBody(ctx, oc),
+	})
+	if err != nil {
+		log.Printf("[mrCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Pushed " + head + ", but failed to open the merge request: " + err.Error()})
+		return
+	}
+	if err := b.DB.SetChatSetting(chatID, prBranchKey, head); err != nil {
+		log.Printf("[mrCommand] Error saving branch: %v", err)
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("Pushed %s and opened %s in %s (%s → %s):\n%s", head, pr.Ref, repo, head, base, pr.URL),
+	})
+}
+
+// forgeSession returns the chat's current session and its forge
+// repository, or tells the chat why there is none.
+func (b *Bot) forgeSession(ctx context.Context, tgBot *bot.Bot, chatID int64) (opencode.OCSession, string, bool) {
+	if b.Forge == nil || b.Client == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No forge is configured (FORGE_TYPE)"})
+		return opencode.OCSession{}, "", false
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return opencode.OCSession{}, "", false
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return opencode.OCSession{}, "", false
+	}
+	oc, repo, err := b.sessionRepo(ctx, sessionID)
+	if err != nil {
+		log.Printf("[forgeSession] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Session not found"})
+		return opencode.OCSession{}, "", false
+	}
+	if repo == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: fmt.Sprintf("No repository is configured for %s (FORGE_REPOS)", oc.Directory)})
+		return opencode.OCSession{}, "", false
+	}
+	return oc, repo, true
+}
+
+// prBody describes the session's changes for a pull request: its title,
+// change summary and changed files.
+func (b *Bot) prBody(ctx context.Context, oc opencode.OCSession) string {
+	var sb strings.Builder
+	title := oc.Title
+	if title == "" {
+		title = "Untitled"
+	}
+	fmt.Fprintf(&sb, "Opened from OpenCode session **%s** (`%s`).\n", title, oc.ID)
+	if s := oc.Summary; s.Files > 0 {
+		fmt.Fprintf(&sb, "\n%d file(s) changed, +%d/-%d\n", s.Files, s.Additions, s.Deletions)
+	}
+	diffs, err := b.Client.GetFileDiffs(ctx, oc.ID)
+	if err != nil {
+		log.Printf("[prBody] Error: %v", err)
+		return sb.String()
+	}
+	if len(diffs) > 0 {
+		sb.WriteString("\n")
+	}
+	for i, d := range diffs {
+		if i == maxPRFiles {
+			fmt.Fprintf(&sb, "- … and %d more\n", len(diffs)-maxPRFiles)
+			break
+		}
+		fmt.Fprintf(&sb, "- `%s` +%d/-%d\n", d.File, d.Additions, d.Deletions)
+	}
+	return sb.String()
+}
+
+// ciStatus describes the CI status of the chat's last /pr branch, or of
+// the default branch, for /status. It returns "" without a forge or
+// repository.
+func (b *Bot) ciStatus(ctx context.Context, chatID int64) string {
+	if b.Forge == nil || b.Client == nil || b.DB == nil {
+		return ""
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, ciTimeout)
+	defer cancel()
+	_, repo, err := b.sessionRepo(ctx, sessionID)
+	if err != nil || repo == "" {
+		return ""
+	}
+	ref := b.chatSetting(chatID, prBranchKey)
+	if ref == "" {
+		if ref, err = b.Forge.DefaultBranch(ctx, repo); err != nil {
+			log.Printf("[ciStatus] Error: %v", err)
+			return "\nCI: unavailable"
+		}
+	}
+	st, err := b.Forge.CommitStatus(ctx, repo, ref)
+	if err != nil {
+		log.Printf("[ciStatus] Error: %v", err)
+		return fmt.Sprintf("\nCI (%s): unavailable", ref)
+	}
+	if st.State == "" {
+		return fmt.Sprintf("\nCI (%s): no checks", ref)
+	}
+	var failed []string
+	for _, c := range st.Checks {
+		if c.State == forge.StateFailure || c.State == forge.StateError {
+			failed = append(failed, c.Name)
+		}
+	}
+	text := fmt.Sprintf("\nCI (%s): %s, %d check(s)", ref, st.State, len(st.Checks))
+	if len(failed) > 0 {
+		text += "; failed: " + strings.Join(failed, ", ")
+	}
+	return text
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if line := lines[len(lines)-1]; line != "" {
+		return line
+	}
+	return "no output"
+}
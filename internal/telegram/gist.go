@@ -0,0 +1,131 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// gistMarkup returns a "Share as Gist" button for the session's diff, or
+// of one file's with path set. It is nil without GIST_TOKEN or when the
+// path doesn't fit in the callback data.
+func (b *Bot) gistMarkup(path string) *models.InlineKeyboardMarkup {
+	row := b.gistButton(path)
+	if row == nil {
+		return nil
+	}
+	return &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{row}}
+}
+
+func (b *Bot) gistButton(path string) []models.InlineKeyboardButton {
+	data := "gist_" + path
+	if b.Gists == nil || len(data) > callbackDataLimit {
+		return nil
+	}
+	return []models.InlineKeyboardButton{{Text: "🔗 Share as Gist", CallbackData: data}}
+}
+
+// handleGistCallback uploads the session's diff, or one file's, as a
+// secret gist and sends its link.
+func (b *Bot) handleGistCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, path string) {
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" || b.Client == nil || b.Gists == nil {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: callback.ID,
+			Text:            "No active session",
+		})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: callback.ID,
+			Text:            refusal,
+			ShowAlert:       true,
+		})
+		return
+	}
+	tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: "Uploading..."})
+
+	patch, err := b.sessionPatch(ctx, sessionID, path)
+	if err != nil {
+		log.Printf("[handleGistCallback] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get diff: " + err.Error()})
+		return
+	}
+	title := "Untitled"
+	if oc, err := b.Client.GetOCSession(ctx, sessionID); err == nil && oc.Title != "" {
+		title = oc.Title
+	}
+	description := "Changes of OpenCode session " + title
+	name := "session.patch"
+	if path != "" {
+		description = fmt.Sprintf("%s (%s)", description, path)
+		name = strings.ReplaceAll(strings.Trim(path, "/"), "/", "_") + ".patch"
+	}
+	url, err := b.Gists.Create(ctx, description, map[string]string{name: patch})
+	if err != nil {
+		log.Printf("[handleGistCallback] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to create the gist: " + err.Error()})
+		return
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Secret gist (anyone with the link can read it):\n" + url})
+}
+
+// sessionPatch renders the session's changes, or those of the file
+// matching path, as a patch git apply accepts. Older servers only offer
+// the whole diff as plain text.
+func (b *Bot) sessionPatch(ctx context.Context, sessionID, path string) (string, error) {
+	diffs, err := b.Client.GetFileDiffs(ctx, sessionID)
+	if err != nil {
+		if path != "" {
+			return "", err
+		}
+		raw, rawErr := b.Client.GetDiff(ctx, sessionID)
+		if rawErr != nil {
+			return "", rawErr
+		}
+		if strings.TrimSpace(raw) == "" {
+			return "", errors.New("no changes")
+		}
+		return raw, nil
+	}
+	if path != "" {
+		if diffs = matchDiffs(diffs, path); len(diffs) != 1 {
+			return "", fmt.Errorf("%d files match %s", len(diffs), path)
+		}
+	}
+	var sb strings.Builder
+	for _, d := range diffs {
+		sb.WriteString(filePatch(d))
+	}
+	if sb.Len() == 0 {
+		return "", errors.New("no changes")
+	}
+	return sb.String(), nil
+}
+
+// filePatch is one file's diff with git headers; a file without line
+// changes yields "".
+func filePatch(d opencode.FileDiff) string {
+	hunks := unifiedDiff(d.Before, d.After, diffContext)
+	if hunks == "" {
+		return ""
+	}
+	head := fmt.Sprintf("diff --git a/%s b/%s\n", d.File, d.File)
+	from, to := "a/"+d.File, "b/"+d.File
+	switch {
+	case d.Before == "":
+		head += "new file mode 100644\n"
+		from = "/dev/null"
+	case d.After == "":
+		head += "deleted file mode 100644\n"
+		to = "/dev/null"
+	}
+	return fmt.Sprintf("%s--- %s\n+++ %s\n%s\n", head, from, to, hunks)
+}
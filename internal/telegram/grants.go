@@ -0,0 +1,161 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// maxGrant caps how long /allow can let a chat in.
+const maxGrant = 90 * 24 * time.Hour
+
+// granted reports whether chatID holds an unexpired /allow grant.
+func (b *Bot) granted(chatID int64) bool {
+	if b.DB == nil {
+		return false
+	}
+	g, err := b.DB.GetGrant(chatID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[granted] Chat %d: %v", chatID, err)
+		}
+		return false
+	}
+	return time.Now().Before(g.ExpiresAt)
+}
+
+// parseGrantDuration accepts time.ParseDuration values plus whole days,
+// e.g. "48h", "90m" or "7d".
+func parseGrantDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// allowCommand grants a user access for a limited time: /allow <id>
+// <duration>. "/allow <id> off" revokes it early; bare /allow lists the
+// grants.
+func (b *Bot) allowCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+
+	args := strings.Fields(strings.TrimPrefix(update.Message.Text, "/allow"))
+	if len(args) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(b.grantsSummary(chatID))})
+		return
+	}
+	if len(args) != 2 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /allow <user_id> <duration>, e.g. /allow 123456 48h, or /allow <user_id> off"})
+		return
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Invalid user ID: " + args[0]})
+		return
+	}
+
+	if args[1] == "off" {
+		if err := b.DB.DeleteGrant(userID); err != nil {
+			log.Printf("[allowCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to revoke access"})
+			return
+		}
+		log.Printf("[allowCommand] Admin %d revoked the grant of %d", chatID, userID)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: fmt.Sprintf("Temporary access of %d revoked", userID)})
+		return
+	}
+
+	d, err := parseGrantDuration(args[1])
+	if err != nil || d <= 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Invalid duration: use e.g. 90m, 48h or 7d"})
+		return
+	}
+	if d > maxGrant {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Grants can last at most 90 days; add the user to ALLOWED_USERS instead"})
+		return
+	}
+	if len(b.access.Load().allowed) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "ALLOWED_USERS is empty, so everyone can already use the bot"})
+		return
+	}
+
+	now := time.Now()
+	g := store.AccessGrant{ChatID: userID, GrantedBy: chatID, CreatedAt: now, ExpiresAt: now.Add(d)}
+	if err := b.DB.SaveGrant(g); err != nil {
+		log.Printf("[allowCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save grant"})
+		return
+	}
+	log.Printf("[allowCommand] Admin %d granted %d access until %s", chatID, userID, g.ExpiresAt.Format(time.RFC3339))
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("%d can use the bot until %s", userID, b.formatTime(chatID, g.ExpiresAt)),
+	})
+}
+
+func (b *Bot) grantsSummary(chatID int64) string {
+	grants, err := b.DB.ListGrants()
+	if err != nil {
+		log.Printf("[grantsSummary] Error: %v", err)
+		return "Failed to list grants"
+	}
+	if len(grants) == 0 {
+		return "No temporary access grants.\nUse /allow <user_id> <duration> to add one."
+	}
+	var sb strings.Builder
+	sb.WriteString("Temporary access\n\n")
+	for _, g := range grants {
+		fmt.Fprintf(&sb, "%d until %s (by %d)\n", g.ChatID, b.formatTime(chatID, g.ExpiresAt), g.GrantedBy)
+	}
+	return sb.String()
+}
+
+// expireGrants deletes grants that have run out and tells the admin who
+// gave each one, and the user, that access ended.
+func (b *Bot) expireGrants(ctx context.Context, tgBot *bot.Bot) error {
+	grants, err := b.DB.ListGrants()
+	if err != nil {
+		return fmt.Errorf("list grants: %w", err)
+	}
+	now := time.Now()
+	for _, g := range grants {
+		if now.Before(g.ExpiresAt) {
+			break // sorted by expiry
+		}
+		if err := b.DB.DeleteGrant(g.ChatID); err != nil {
+			return fmt.Errorf("delete grant of %d: %w", g.ChatID, err)
+		}
+		log.Printf("[janitor] Temporary access of %d expired", g.ChatID)
+		if tgBot == nil {
+			continue
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: g.GrantedBy,
+			Text:   fmt.Sprintf("Temporary access of %d expired and was revoked.", g.ChatID),
+		})
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: g.ChatID, Text: "Your temporary access to this bot has expired."})
+	}
+	return nil
+}
@@ -1,5 +1,7 @@
 package telegram
 
+import "github.com/Khaledxab/Openkh/internal/tgtext"
+
 // shortID safely truncates an ID to 8 characters + "..." for display.
 // Returns the full ID if it's shorter than 8 characters.
 func shortID(id string) string {
@@ -9,6 +11,11 @@ func shortID(id string) string {
 	return id[:8] + "..."
 }
 
+// truncate cuts text to the configured maximum message length.
+func (b *Bot) truncate(text string) string {
+	return tgtext.Truncate(text, b.Config.MaxMessageLen)
+}
+
 // currentSessionID returns the OpenCode session ID for a chat, or "".
 func (b *Bot) currentSessionID(chatID int64) string {
 	if b.DB == nil {
@@ -44,3 +51,15 @@ func (b *Bot) currentModel(chatID int64) (providerID, modelID string) {
 	}
 	return sess.ModelProvider, sess.ModelID
 }
+
+// withDefaults fills in the configured default agent and model when the
+// chat has no stored preference.
+func (b *Bot) withDefaults(agent, providerID, modelID string) (string, string, string) {
+	if agent == "" {
+		agent = b.defaultAgent
+	}
+	if providerID == "" || modelID == "" {
+		providerID, modelID = b.defaultProvider, b.defaultModel
+	}
+	return agent, providerID, modelID
+}
@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-telegram/bot"
+)
+
+// apiCall is a Bot API request the fake Telegram server received.
+type apiCall struct {
+	method string
+	chatID int64
+	text   string
+}
+
+// fakeTelegram is a Bot API server that accepts every request and records
+// it. Sent messages get increasing IDs.
+type fakeTelegram struct {
+	mu     sync.Mutex
+	calls  []apiCall
+	nextID int
+}
+
+// newFakeTelegram starts a fake Bot API server and returns a client for it.
+func newFakeTelegram(t *testing.T) (*fakeTelegram, *bot.Bot) {
+	t.Helper()
+	ft := &fakeTelegram{}
+	srv := httptest.NewServer(http.HandlerFunc(ft.serve))
+	t.Cleanup(srv.Close)
+	tgBot, err := bot.New("123:test", bot.WithServerURL(srv.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("bot.New: %v", err)
+	}
+	return ft, tgBot
+}
+
+func (ft *fakeTelegram) serve(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	r.ParseMultipartForm(1 << 20)
+	chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+
+	ft.mu.Lock()
+	ft.calls = append(ft.calls, apiCall{method: method, chatID: chatID, text: r.FormValue("text")})
+	ft.nextID++
+	id := ft.nextID
+	ft.mu.Unlock()
+
+	var result any = true
+	switch method {
+	case "sendMessage", "editMessageText":
+		result = map[string]any{
+			"message_id": id,
+			"date":       0,
+			"chat":       map[string]any{"id": chatID, "type": "private"},
+			"text":       r.FormValue("text"),
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+// texts returns the text of every call of method, in order.
+func (ft *fakeTelegram) texts(method string) []string {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	var texts []string
+	for _, c := range ft.calls {
+		if c.method == method {
+			texts = append(texts, c.text)
+		}
+	}
+	return texts
+}
+
+// nopSender is a stream sender that drops everything.
+type nopSender struct{}
+
+func (nopSender) SendText(chatID int64, text string) (int, error)         { return 1, nil }
+func (nopSender) EditText(chatID int64, messageID int, text string) error { return nil }
@@ -4,8 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
@@ -29,18 +34,37 @@ func (b *Bot) statusCommand(ctx context.Context, tgBot *bot.Bot, update *models.
 			if sess.ModelProvider != "" && sess.ModelID != "" {
 				modelInfo = sess.ModelID + " (" + sess.ModelProvider + ")"
 			}
-			sessionInfo = fmt.Sprintf("\nSession: %s\nModel: %s\nAgent: %s\nMessages: %d",
-				shortID(sess.SessionID), modelInfo, agentOrDefault(sess.Agent), sess.MessageCount)
+			sessionInfo = fmt.Sprintf("\nSession: %s\nModel: %s\nAgent: %s\nMessages: %d\nCreated: %s\nLast used: %s",
+				shortID(sess.SessionID), modelInfo, agentOrDefault(sess.Agent), sess.MessageCount,
+				b.formatTime(chatID, sess.CreatedAt), b.formatTime(chatID, sess.LastUsed))
+			if mode := b.sessionMode(chatID, sess.SessionID); mode != "" {
+				sessionInfo += "\nMode: " + mode
+			}
+			if b.Client != nil {
+				if tokens, limit, err := b.contextUsage(ctx, sess.SessionID); err != nil {
+					log.Printf("[statusCommand] Error: %v", err)
+				} else if limit > 0 {
+					sessionInfo += "\nContext: " + usageText(tokens, limit)
+				} else if tokens > 0 {
+					sessionInfo += "\nContext: " + tokenCount(tokens) + " tokens"
+				}
+			}
 		}
 	}
 
 	activeStreams := 0
+	var sse string
 	if b.Stream != nil {
 		activeStreams = b.Stream.GetActiveSessionCount()
+		sse = "\n" + sseStatus(b.Stream.Health())
+	}
+
+	if name, dir := b.chatRepo(chatID); name != "" {
+		sessionInfo = fmt.Sprintf("\nRepository: %s (%s)", name, dir) + sessionInfo
 	}
 
-	text := fmt.Sprintf("Bot Status\n\nUptime: %s\nActive streams: %d%s",
-		uptime.Round(time.Second), activeStreams, sessionInfo)
+	text := fmt.Sprintf("Bot Status\n\nUptime: %s%s\nActive streams: %d%s%s",
+		uptime.Round(time.Second), sse, activeStreams, sessionInfo, b.ciStatus(ctx, chatID))
 
 	tgBot.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
@@ -48,6 +72,43 @@ func (b *Bot) statusCommand(ctx context.Context, tgBot *bot.Bot, update *models.
 	})
 }
 
+// sseStatus describes the health of the OpenCode event stream, e.g.
+// "SSE: connected for 2h5m0s, last event 3s ago (1 reconnect)".
+func sseStatus(h opencode.StreamHealth) string {
+	ago := func(t time.Time) string {
+		return time.Since(t).Round(time.Second).String()
+	}
+	var text string
+	switch {
+	case h.Connected:
+		text = "SSE: connected for " + ago(h.Since)
+		if h.LastEvent.IsZero() {
+			text += ", no events yet"
+		} else {
+			text += ", last event " + ago(h.LastEvent) + " ago"
+		}
+	case h.Since.IsZero():
+		text = "SSE: not connected"
+	default:
+		text = "SSE: down for " + ago(h.Since)
+	}
+
+	var notes []string
+	if h.Reconnects > 0 {
+		notes = append(notes, fmt.Sprintf("%d reconnect%s", h.Reconnects, plural(h.Reconnects)))
+	}
+	if h.ParseErrors > 0 {
+		notes = append(notes, fmt.Sprintf("%d parse error%s", h.ParseErrors, plural(h.ParseErrors)))
+	}
+	if !h.Connected && h.LastError != "" {
+		notes = append(notes, "last error: "+h.LastError)
+	}
+	if len(notes) > 0 {
+		text += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return text
+}
+
 func (b *Bot) statsCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
 	if update.Message == nil {
 		return
@@ -62,7 +123,7 @@ func (b *Bot) statsCommand(ctx context.Context, tgBot *bot.Bot, update *models.U
 		return
 	}
 
-	sessions, err := b.DB.ListAll()
+	sessions, err := b.chatSessions()
 	if err != nil {
 		log.Printf("[statsCommand] Error: %v", err)
 		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get statistics"})
@@ -70,12 +131,16 @@ func (b *Bot) statsCommand(ctx context.Context, tgBot *bot.Bot, update *models.U
 	}
 
 	totalMessages := 0
+	var lastActivity time.Time
 	for _, sess := range sessions {
 		totalMessages += sess.MessageCount
+		if sess.LastUsed.After(lastActivity) {
+			lastActivity = sess.LastUsed
+		}
 	}
 
-	text := fmt.Sprintf("Statistics\n\nTotal messages: %d\nActive sessions: %d",
-		totalMessages, len(sessions))
+	text := fmt.Sprintf("Statistics\n\nTotal messages: %d\nActive sessions: %d\nLast activity: %s",
+		totalMessages, len(sessions), b.formatTime(chatID, lastActivity))
 
 	tgBot.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
@@ -83,6 +148,164 @@ func (b *Bot) statsCommand(ctx context.Context, tgBot *bot.Bot, update *models.U
 	})
 }
 
+func (b *Bot) httpDebugCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+	if b.Client == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "OpenCode client not initialized"})
+		return
+	}
+
+	parts := strings.Fields(update.Message.Text)
+	on := !b.Client.Debug()
+	if len(parts) >= 2 {
+		switch strings.ToLower(parts[1]) {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /httpdebug [on|off]"})
+			return
+		}
+	}
+	b.Client.SetDebug(on)
+	log.Printf("[httpDebugCommand] Chat %d set HTTP debug logging to %t", chatID, on)
+
+	state := "OFF"
+	if on {
+		state = "ON"
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   "HTTP debug logging: " + state,
+	})
+}
+
+func (b *Bot) debugCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Debug\n\n")
+	sb.WriteString(fmt.Sprintf("Uptime: %s\nGoroutines: %d\n", time.Since(b.Start).Round(time.Second), runtime.NumGoroutine()))
+	if b.Client != nil {
+		sb.WriteString(fmt.Sprintf("HTTP debug: %t\n", b.Client.Debug()))
+	}
+
+	if b.Jobs != nil {
+		sb.WriteString("\nJobs (runs / failures / last run):\n")
+		for _, j := range b.Jobs.Status() {
+			last := "never"
+			if !j.LastRun.IsZero() {
+				last = time.Since(j.LastRun).Round(time.Second).String() + " ago"
+			}
+			sb.WriteString(fmt.Sprintf("%s: %d / %d / %s\n", j.Name, j.Runs, j.Failures, last))
+			if j.LastErr != "" {
+				sb.WriteString("  last error: " + j.LastErr + "\n")
+			}
+		}
+	}
+
+	apiMethods := []string{"sendMessage", "editMessageText"}
+	latency := apiLatencySummary(apiMethods...)
+	if len(latency) > 0 {
+		sb.WriteString("\nTelegram API (calls / avg / max):\n")
+		for _, m := range apiMethods {
+			if s, ok := latency[m]; ok && s.Count > 0 {
+				avg := time.Duration(s.Sum / float64(s.Count) * float64(time.Second))
+				max := time.Duration(s.Max * float64(time.Second))
+				sb.WriteString(fmt.Sprintf("%s: %d / %s / %s\n", m, s.Count, avg.Round(time.Millisecond), max.Round(time.Millisecond)))
+			}
+		}
+	}
+
+	stats := store.QueryStats()
+	if len(stats) == 0 {
+		sb.WriteString("\nNo store queries recorded")
+	} else {
+		sb.WriteString("\nStore queries (calls / avg / max / slow):\n")
+		for _, st := range stats {
+			sb.WriteString(fmt.Sprintf("%s: %d / %s / %s / %d\n",
+				st.Method, st.Calls, st.Avg.Round(time.Microsecond), st.Max.Round(time.Microsecond), st.Slow))
+		}
+	}
+
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   sb.String(),
+	})
+}
+
+// eventsCommand dumps recent SSE events: /events [count] [session-prefix].
+func (b *Bot) eventsCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+	if b.Stream == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Stream manager not initialized"})
+		return
+	}
+
+	count := 20
+	var sessionPrefix string
+	for _, arg := range strings.Fields(update.Message.Text)[1:] {
+		if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+			count = n
+		} else {
+			sessionPrefix = strings.TrimSuffix(arg, "...")
+		}
+	}
+
+	events := b.Stream.RecentEvents(count, sessionPrefix)
+	if len(events) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No events recorded"})
+		return
+	}
+
+	loc := b.location(chatID)
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Last %d event(s)\n\n", len(events)))
+	for _, e := range events {
+		session := "-"
+		if e.SessionID != "" {
+			session = shortID(e.SessionID)
+		}
+		sb.WriteString(fmt.Sprintf("%s %s %s\n%s\n\n", e.Time.In(loc).Format("15:04:05"), e.Type, session, e.Payload))
+	}
+
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   b.truncate(sb.String()),
+	})
+}
+
 func agentOrDefault(agent string) string {
 	if agent == "" {
 		return "default"
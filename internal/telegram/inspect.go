@@ -0,0 +1,194 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Limits of what /inspect shows of a chat's conversation.
+const (
+	inspectMessages = 5   // recent messages of the session
+	inspectClip     = 200 // characters of each, and of the cached reply
+	inspectErrors   = 3   // recent errors of each kind
+	inspectEvents   = 200 // recent events of the session searched for errors
+)
+
+// inspectCommand shows an admin another chat's session, recent history,
+// usage and errors, to help its user without asking for screenshots. A
+// session locked with /lock shows no content.
+func (b *Bot) inspectCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+
+	target, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/inspect")), 10, 64)
+	if err != nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /inspect <chat_id>"})
+		return
+	}
+	sess, err := b.DB.GetSession(target)
+	if err != nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: fmt.Sprintf("Chat %d has no session", target)})
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🔎 Chat %d\n\n", target)
+	modelInfo := "server default"
+	if sess.ModelProvider != "" && sess.ModelID != "" {
+		modelInfo = sess.ModelID + " (" + sess.ModelProvider + ")"
+	}
+	fmt.Fprintf(&sb, "Session: %s\nModel: %s\nAgent: %s\nMessages: %d\nCreated: %s\nLast used: %s\n",
+		shortID(sess.SessionID), modelInfo, agentOrDefault(sess.Agent), sess.MessageCount,
+		b.formatTime(chatID, sess.CreatedAt), b.formatTime(chatID, sess.LastUsed))
+	if name, dir := b.chatRepo(target); name != "" {
+		fmt.Fprintf(&sb, "Repository: %s (%s)\n", name, dir)
+	}
+	if b.Stream != nil {
+		if live, _, ok := b.Stream.LiveMessage(target); ok {
+			fmt.Fprintf(&sb, "Running: a reply in session %s\n", shortID(live))
+		}
+	}
+	if action, err := b.DB.GetPendingAction(target); err == nil {
+		fmt.Fprintf(&sb, "Waiting for: %s, until %s\n", action.Type, b.formatTime(chatID, action.ExpiresAt))
+	}
+
+	sb.WriteString("\nUsage\n")
+	sb.WriteString(b.inspectUsage(ctx, chatID, target, sess.SessionID))
+
+	var messages []opencode.Message
+	locked := b.sessionLocked(target, sess.SessionID)
+	if b.Client != nil && !locked {
+		if messages, err = b.Client.GetMessages(ctx, sess.SessionID); err != nil {
+			log.Printf("[inspectCommand] Error: %v", err)
+		}
+	}
+	if locked {
+		sb.WriteString("\n🔒 The session is locked; its content isn't shown.\n")
+	} else {
+		sb.WriteString(b.inspectHistory(chatID, target, messages))
+	}
+
+	sb.WriteString("\nErrors\n")
+	sb.WriteString(b.inspectErrors(chatID, sess.SessionID, messages))
+
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(sb.String())})
+}
+
+// inspectUsage describes the session's context usage and the chat's
+// recorded usage today, in target's time zone.
+func (b *Bot) inspectUsage(ctx context.Context, chatID, target int64, sessionID string) string {
+	var sb strings.Builder
+	if b.Client != nil {
+		if tokens, limit, err := b.contextUsage(ctx, sessionID); err != nil {
+			log.Printf("[inspectCommand] Error: %v", err)
+		} else if limit > 0 {
+			sb.WriteString("Context: " + usageText(tokens, limit) + "\n")
+		} else if tokens > 0 {
+			sb.WriteString("Context: " + tokenCount(tokens) + " tokens\n")
+		}
+	}
+	entries, err := b.DB.ListUsage(target, time.Now().In(b.location(target)).Format(dayLayout))
+	if err != nil {
+		log.Printf("[inspectCommand] Error: %v", err)
+	}
+	var prompts, tokens int
+	var cost float64
+	for _, u := range entries {
+		prompts += u.Prompts
+		tokens += u.Tokens
+		cost += u.Cost
+	}
+	if len(entries) > 0 {
+		fmt.Fprintf(&sb, "Today: %d prompt%s, %s tokens, $%.2f\n", prompts, plural(prompts), tokenCount(tokens), cost)
+	}
+	if sb.Len() == 0 {
+		return "None recorded\n"
+	}
+	return sb.String()
+}
+
+// inspectHistory shows the chat's last reply from the message cache and
+// the last of the session's messages. Under PRIVACY_MODE only their length
+// is shown.
+func (b *Bot) inspectHistory(chatID, target int64, messages []opencode.Message) string {
+	private := b.Config != nil && b.Config.PrivacyMode
+	content := func(text string) string {
+		if private {
+			return fmt.Sprintf("(%d characters)", tgtext.Len(text))
+		}
+		return tgtext.Clip(text, inspectClip)
+	}
+
+	var sb strings.Builder
+	if cached, err := b.DB.GetMessageText(target); err == nil && cached.Text != "" {
+		fmt.Fprintf(&sb, "\nLast reply · %s\n%s\n", b.formatTime(chatID, cached.UpdatedAt), content(cached.Text))
+	}
+	if len(messages) > inspectMessages {
+		messages = messages[len(messages)-inspectMessages:]
+	}
+	if len(messages) > 0 {
+		sb.WriteString("\nRecent messages\n")
+	}
+	for _, msg := range messages {
+		role := msg.Role
+		if role == "" {
+			role = "user"
+		}
+		if !msg.Created.IsZero() {
+			role += " · " + b.formatTime(chatID, msg.Created)
+		}
+		fmt.Fprintf(&sb, "%s:\n%s\n", role, content(msg.Content))
+	}
+	return sb.String()
+}
+
+// inspectErrors lists the last failed tool calls among messages and the
+// session.error events of the session still in the event log.
+func (b *Bot) inspectErrors(chatID int64, sessionID string, messages []opencode.Message) string {
+	var calls, events []string
+	for _, msg := range messages {
+		for _, call := range msg.Tools {
+			if call.Error != "" {
+				calls = append(calls, call.Tool+": "+tgtext.Clip(call.Error, inspectClip))
+			}
+		}
+	}
+	if b.Stream != nil {
+		for _, e := range b.Stream.RecentEvents(inspectEvents, sessionID) {
+			if e.Type == "session.error" {
+				events = append(events, e.Time.In(b.location(chatID)).Format("15:04:05")+" "+tgtext.Clip(e.Payload, inspectClip))
+			}
+		}
+	}
+	errs := append(lastN(calls, inspectErrors), lastN(events, inspectErrors)...)
+	if len(errs) == 0 {
+		return "None\n"
+	}
+	return strings.Join(errs, "\n") + "\n"
+}
+
+// lastN returns the last n of s.
+func lastN(s []string, n int) []string {
+	if len(s) > n {
+		return s[len(s)-n:]
+	}
+	return s
+}
@@ -0,0 +1,134 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/tracker"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// issueCommand files an issue on ISSUE_TRACKER: "/issue <title>" has the
+// model summarize the session's findings and uses the summary as the
+// issue's description.
+func (b *Bot) issueCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if b.Tracker == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No issue tracker is configured (ISSUE_TRACKER)"})
+		return
+	}
+
+	title := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/issue"))
+	if title == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /issue <title>"})
+		return
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+	oc, err := b.Client.GetOCSession(ctx, sessionID)
+	if err != nil {
+		log.Printf("[issueCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Session not found"})
+		return
+	}
+
+	status, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "📝 Summarizing the session..."})
+	if err != nil {
+		log.Printf("[issueCommand] Error: %v", err)
+		return
+	}
+	// reply replaces the progress message with the outcome.
+	reply := func(text string) {
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{ChatID: chatID, MessageID: status.ID, Text: text})
+	}
+
+	summary, err := b.summarize(ctx, chatID, sessionID)
+	if err != nil {
+		log.Printf("[issueCommand] Error: %v", err)
+		reply("Failed to summarize the session: " + err.Error())
+		return
+	}
+	issue, err := b.Tracker.CreateIssue(ctx, b.Config.IssueProject, tracker.NewIssue{
+		Title: title,
+		Body:  issueBody(oc, summary),
+	})
+	if err != nil {
+		log.Printf("[issueCommand] Error: %v", err)
+		reply("Failed to file the issue: " + err.Error())
+		return
+	}
+	reply(fmt.Sprintf("Filed %s in %s:\n%s", issue.ID, b.Config.IssueProject, issue.URL))
+}
+
+// summaryModel is the model that summarizes the session: the chat's
+// model, else DEFAULT_MODEL, else the model of the session's last reply.
+func (b *Bot) summaryModel(ctx context.Context, chatID int64, sessionID string) (providerID, modelID string, err error) {
+	providerID, modelID = b.currentModel(chatID)
+	_, providerID, modelID = b.withDefaults("", providerID, modelID)
+	if modelID != "" {
+		return providerID, modelID, nil
+	}
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	for i := len(messages) - 1; i >= 0 && modelID == ""; i-- {
+		providerID, modelID = messages[i].ProviderID, messages[i].ModelID
+	}
+	if modelID == "" {
+		return "", "", errors.New("no model to summarize with; pick one with /model")
+	}
+	return providerID, modelID, nil
+}
+
+// summarize has the model summarize the session and returns the summary.
+func (b *Bot) summarize(ctx context.Context, chatID int64, sessionID string) (string, error) {
+	providerID, modelID, err := b.summaryModel(ctx, chatID, sessionID)
+	if err != nil {
+		return "", err
+	}
+	if err := b.Client.Summarize(ctx, sessionID, providerID, modelID); err != nil {
+		return "", err
+	}
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if m := messages[i]; m.Summary && strings.TrimSpace(m.Content) != "" {
+			return strings.TrimSpace(m.Content), nil
+		}
+	}
+	return "", errors.New("the session has no summary")
+}
+
+// issueBody is the summary followed by where it came from.
+func issueBody(oc opencode.OCSession, summary string) string {
+	title := oc.Title
+	if title == "" {
+		title = "Untitled"
+	}
+	body := summary + fmt.Sprintf("\n\n---\nFiled from OpenCode session **%s** (`%s`)", title, oc.ID)
+	if oc.Directory != "" {
+		body += fmt.Sprintf(" in `%s`", oc.Directory)
+	}
+	return body + "."
+}
@@ -0,0 +1,102 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// responseLangKey is the chat setting holding the language code replies
+// should be written in.
+const responseLangKey = "response.lang"
+
+// languageCode accepts BCP 47 style tags such as "de", "pt-BR" or "zh-Hant".
+var languageCode = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// languageNames spells out common codes so the instruction is unambiguous;
+// other codes are passed to the model as-is.
+var languageNames = map[string]string{
+	"ar": "Arabic", "de": "German", "en": "English", "es": "Spanish",
+	"fa": "Persian", "fr": "French", "he": "Hebrew", "hi": "Hindi",
+	"id": "Indonesian", "it": "Italian", "ja": "Japanese", "ko": "Korean",
+	"nl": "Dutch", "pl": "Polish", "pt": "Portuguese", "ru": "Russian",
+	"sv": "Swedish", "tr": "Turkish", "uk": "Ukrainian", "zh": "Chinese",
+}
+
+func languageName(code string) string {
+	base, _, _ := strings.Cut(strings.ToLower(code), "-")
+	if name, ok := languageNames[base]; ok {
+		return fmt.Sprintf("%s (%s)", name, code)
+	}
+	return code
+}
+
+func (b *Bot) responseLang(chatID int64) string {
+	if b.DB == nil {
+		return ""
+	}
+	code, err := b.DB.GetChatSetting(chatID, responseLangKey)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[responseLang] Chat %d: %v", chatID, err)
+		}
+		return ""
+	}
+	return code
+}
+
+// languageInstruction is the system instruction added to the chat's
+// prompts, or "" when no response language is set.
+func (b *Bot) languageInstruction(chatID int64) string {
+	code := b.responseLang(chatID)
+	if code == "" {
+		return ""
+	}
+	return fmt.Sprintf("Write your replies to the user in %s, whatever language the prompt, code or files are in. Keep code, identifiers and command output unchanged.", languageName(code))
+}
+
+// responseLangCommand sets the language replies are written in. It only
+// affects what the model is asked for, not the bot's own messages.
+func (b *Bot) responseLangCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/responselang"))
+	switch {
+	case arg == "":
+		text := "No response language set; the model picks one. Use /responselang <code>, e.g. /responselang de"
+		if code := b.responseLang(chatID); code != "" {
+			text = "Replies are requested in " + languageName(code) + ". Use /responselang off to stop."
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+		return
+	case arg == "off":
+		arg = ""
+	case !languageCode.MatchString(arg):
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Use a language code such as en, de or pt-BR"})
+		return
+	}
+
+	if err := b.DB.SetChatSetting(chatID, responseLangKey, arg); err != nil {
+		log.Printf("[responseLangCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	text := "Response language cleared"
+	if arg != "" {
+		text = "Replies will be requested in " + languageName(arg)
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+}
@@ -0,0 +1,180 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// leaderboardSize is how many chats each ranking of /leaderboard shows.
+const leaderboardSize = 5
+
+// chatUsage is a chat's usage summed over the days of a leaderboard.
+type chatUsage struct {
+	chatID  int64
+	prompts int
+	tokens  int
+	cost    float64
+}
+
+// leaderboardOn reports whether LEADERBOARD lets anyone see the
+// leaderboard, so every chat's usage is recorded for it.
+func (b *Bot) leaderboardOn() bool {
+	return b.Config != nil && b.Config.Leaderboard != "" && b.Config.Leaderboard != config.LeaderboardOff
+}
+
+// leaderboardRole is who /leaderboard is shown to under LEADERBOARD.
+func (b *Bot) leaderboardRole() role {
+	if b.Config != nil && b.Config.Leaderboard == config.LeaderboardAll {
+		return roleUser
+	}
+	return roleAdmin
+}
+
+// leaderboardCommand ranks the chats sharing the bot by prompts, tokens
+// and cost since the start of the week, or of the month with "month", so
+// teams on one budget can see where it goes.
+func (b *Bot) leaderboardCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if b.leaderboardRole() == roleAdmin && !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+
+	now := time.Now().In(b.location(chatID))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var since time.Time
+	var period string
+	switch arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/leaderboard")); arg {
+	case "", "week":
+		since = today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		period = "this week"
+	case "month":
+		since = today.AddDate(0, 0, 1-today.Day())
+		period = "this month"
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /leaderboard [week|month]"})
+		return
+	}
+
+	usage, err := b.usageSince(since, today)
+	if err != nil {
+		log.Printf("[leaderboardCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to load usage"})
+		return
+	}
+	if len(usage) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No usage recorded " + period})
+		return
+	}
+
+	names := make(map[int64]string)
+	name := func(id int64) string {
+		if n, ok := names[id]; ok {
+			return n
+		}
+		names[id] = chatName(ctx, tgBot, id)
+		return names[id]
+	}
+	var total chatUsage
+	for _, u := range usage {
+		total.prompts += u.prompts
+		total.tokens += u.tokens
+		total.cost += u.cost
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🏆 Leaderboard, %s (since %s)\n", period, since.Format("Mon 2 Jan"))
+	rankings := []struct {
+		title  string
+		before func(a, b chatUsage) bool
+		value  func(u chatUsage) string
+	}{
+		{"Prompts", func(a, b chatUsage) bool { return a.prompts > b.prompts },
+			func(u chatUsage) string { return strconv.Itoa(u.prompts) }},
+		{"Tokens", func(a, b chatUsage) bool { return a.tokens > b.tokens },
+			func(u chatUsage) string { return tokenCount(u.tokens) }},
+		{"Cost", func(a, b chatUsage) bool { return a.cost > b.cost },
+			func(u chatUsage) string { return fmt.Sprintf("$%.2f", u.cost) }},
+	}
+	for _, r := range rankings {
+		sort.SliceStable(usage, func(i, j int) bool { return r.before(usage[i], usage[j]) })
+		fmt.Fprintf(&sb, "\n%s\n", r.title)
+		for i, u := range usage {
+			if i == leaderboardSize {
+				break
+			}
+			fmt.Fprintf(&sb, "%d. %s: %s\n", i+1, name(u.chatID), r.value(u))
+		}
+	}
+	fmt.Fprintf(&sb, "\nTotal: %d prompt%s, %s tokens, $%.2f across %d chat%s",
+		total.prompts, plural(total.prompts), tokenCount(total.tokens), total.cost, len(usage), plural(len(usage)))
+
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(sb.String())})
+}
+
+// usageSince sums each chat's recorded usage over the days from since to
+// today. Chats are found through their sessions, as elsewhere.
+func (b *Bot) usageSince(since, today time.Time) ([]chatUsage, error) {
+	sessions, err := b.chatSessions()
+	if err != nil {
+		return nil, fmt.Errorf("list chats: %w", err)
+	}
+	seen := make(map[int64]bool)
+	var out []chatUsage
+	for _, s := range sessions {
+		if seen[s.ChatID] {
+			continue
+		}
+		seen[s.ChatID] = true
+		u := chatUsage{chatID: s.ChatID}
+		for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+			entries, err := b.DB.ListUsage(s.ChatID, day.Format(dayLayout))
+			if err != nil {
+				return nil, fmt.Errorf("chat %d: %w", s.ChatID, err)
+			}
+			for _, e := range entries {
+				u.prompts += e.Prompts
+				u.tokens += e.Tokens
+				u.cost += e.Cost
+			}
+		}
+		if u.prompts > 0 {
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
+
+// chatName is how a chat is shown on the leaderboard: a group's title, a
+// user's name or @username, or the chat ID if Telegram doesn't say.
+func chatName(ctx context.Context, tgBot *bot.Bot, chatID int64) string {
+	chat, err := tgBot.GetChat(ctx, &bot.GetChatParams{ChatID: chatID})
+	if err != nil {
+		log.Printf("[chatName] Chat %d: %v", chatID, err)
+	}
+	switch {
+	case chat == nil:
+	case chat.Title != "":
+		return chat.Title
+	case chat.FirstName != "":
+		return strings.TrimSpace(chat.FirstName + " " + chat.LastName)
+	case chat.Username != "":
+		return "@" + chat.Username
+	}
+	return strconv.FormatInt(chatID, 10)
+}
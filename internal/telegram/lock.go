@@ -0,0 +1,226 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Chat settings behind /lock. Locks are per chat: another chat using the
+// same session isn't affected.
+const (
+	lockKeyPrefix = "lock."      // + session ID: "salt:hash" of the passphrase
+	unlockPrefix  = "lock.open." // + session ID: Unix time the unlock ends
+	lockWantKey   = "lock.want"  // session last refused, for a bare /unlock target
+)
+
+const (
+	// unlockTTL is how long /unlock opens a session for.
+	unlockTTL = 30 * time.Minute
+	// passphraseRounds slows down guessing a stored passphrase hash.
+	passphraseRounds = 100000
+	minPassphraseLen = 4
+)
+
+func hashPassphrase(salt []byte, passphrase string) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), passphrase...))
+	for i := 1; i < passphraseRounds; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	return sum[:]
+}
+
+// chatSetting returns a chat setting, or "" if unset or unreadable.
+func (b *Bot) chatSetting(chatID int64, key string) string {
+	if b.DB == nil {
+		return ""
+	}
+	value, err := b.DB.GetChatSetting(chatID, key)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("[chatSetting] Chat %d %s: %v", chatID, key, err)
+	}
+	return value
+}
+
+// sessionLocked reports whether sessionID is locked in chatID and not
+// currently unlocked.
+func (b *Bot) sessionLocked(chatID int64, sessionID string) bool {
+	if sessionID == "" || b.chatSetting(chatID, lockKeyPrefix+sessionID) == "" {
+		return false
+	}
+	until, err := strconv.ParseInt(b.chatSetting(chatID, unlockPrefix+sessionID), 10, 64)
+	return err != nil || time.Now().Unix() >= until
+}
+
+// lockRefusal explains that sessionID is locked in the chat, or returns ""
+// when it may be used. A refused session is what a following /unlock opens.
+func (b *Bot) lockRefusal(chatID int64, sessionID string) string {
+	if !b.sessionLocked(chatID, sessionID) {
+		return ""
+	}
+	if err := b.DB.SetChatSetting(chatID, lockWantKey, sessionID); err != nil {
+		log.Printf("[lockRefusal] Error: %v", err)
+	}
+	log.Printf("[lockRefusal] Chat %d: session %s is locked", chatID, sessionID)
+	return "🔒 Session " + shortID(sessionID) + " is locked. Send /unlock <passphrase> first."
+}
+
+// SessionLocked reports whether any chat locked sessionID with /lock, for
+// frontends that can't /unlock it. All chats count, tenants' too, and a
+// store error counts as locked rather than letting a lock be bypassed.
+func (b *Bot) SessionLocked(sessionID string) bool {
+	if b.DB == nil || sessionID == "" {
+		return false
+	}
+	sessions, err := b.DB.ListAll()
+	if err != nil {
+		log.Printf("[SessionLocked] Error: %v", err)
+		return true
+	}
+	for _, s := range sessions {
+		if b.chatSetting(s.ChatID, lockKeyPrefix+sessionID) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// deletePassphrase removes the message carrying a passphrase from the chat.
+func deletePassphrase(ctx context.Context, tgBot *bot.Bot, msg *models.Message) {
+	if _, err := tgBot.DeleteMessage(ctx, &bot.DeleteMessageParams{ChatID: msg.Chat.ID, MessageID: msg.ID}); err != nil {
+		log.Printf("[deletePassphrase] Chat %d: %v", msg.Chat.ID, err)
+	}
+}
+
+// lockCommand protects the current session with a passphrase; "/lock
+// clear" removes the lock of an unlocked session.
+func (b *Bot) lockCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return
+	}
+
+	arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/lock"))
+	locked := b.chatSetting(chatID, lockKeyPrefix+sessionID) != ""
+	switch {
+	case arg == "":
+		text := "Session " + shortID(sessionID) + " isn't locked. Use /lock <passphrase> to lock it."
+		if locked {
+			text = "Session " + shortID(sessionID) + " is locked; /unlock <passphrase> opens it for 30 minutes."
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+		return
+	case arg == "clear":
+		if !locked {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Session " + shortID(sessionID) + " isn't locked"})
+			return
+		}
+		if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+			return
+		}
+		b.DB.SetChatSetting(chatID, unlockPrefix+sessionID, "")
+		if err := b.DB.SetChatSetting(chatID, lockKeyPrefix+sessionID, ""); err != nil {
+			log.Printf("[lockCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Lock removed from session " + shortID(sessionID)})
+		return
+	}
+
+	deletePassphrase(ctx, tgBot, update.Message)
+	if locked {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Session " + shortID(sessionID) + " is already locked. Unlock it and /lock clear to change the passphrase."})
+		return
+	}
+	if len([]rune(arg)) < minPassphraseLen {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Use a passphrase of at least 4 characters"})
+		return
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		log.Printf("[lockCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to lock session"})
+		return
+	}
+	value := hex.EncodeToString(salt) + ":" + hex.EncodeToString(hashPassphrase(salt, arg))
+	if err := b.DB.SetChatSetting(chatID, lockKeyPrefix+sessionID, value); err != nil {
+		log.Printf("[lockCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to lock session"})
+		return
+	}
+	log.Printf("[lockCommand] Chat %d locked session %s", chatID, sessionID)
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   "🔒 Session " + shortID(sessionID) + " locked. Switching back to it, /history and /diff will need /unlock <passphrase>. Your message with the passphrase was deleted.",
+	})
+}
+
+// unlockCommand opens the session the chat was last refused, or else the
+// current one, for unlockTTL.
+func (b *Bot) unlockCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	passphrase := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/unlock"))
+	if passphrase == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /unlock <passphrase>"})
+		return
+	}
+	deletePassphrase(ctx, tgBot, update.Message)
+
+	sessionID := b.chatSetting(chatID, lockWantKey)
+	if !b.sessionLocked(chatID, sessionID) {
+		sessionID = b.currentSessionID(chatID)
+	}
+	stored := b.chatSetting(chatID, lockKeyPrefix+sessionID)
+	if sessionID == "" || stored == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No locked session to unlock"})
+		return
+	}
+
+	saltHex, hashHex, _ := strings.Cut(stored, ":")
+	salt, err1 := hex.DecodeString(saltHex)
+	want, err2 := hex.DecodeString(hashHex)
+	if err1 != nil || err2 != nil || subtle.ConstantTimeCompare(hashPassphrase(salt, passphrase), want) != 1 {
+		log.Printf("Warning: chat %d gave a wrong passphrase for session %s", chatID, sessionID)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Wrong passphrase"})
+		return
+	}
+
+	until := time.Now().Add(unlockTTL)
+	if err := b.DB.SetChatSetting(chatID, unlockPrefix+sessionID, strconv.FormatInt(until.Unix(), 10)); err != nil {
+		log.Printf("[unlockCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to unlock session"})
+		return
+	}
+	b.DB.SetChatSetting(chatID, lockWantKey, "")
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   "🔓 Session " + shortID(sessionID) + " unlocked until " + b.formatTime(chatID, until) + ".",
+	})
+}
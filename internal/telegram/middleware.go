@@ -2,12 +2,16 @@ package telegram
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/store"
 	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
 )
 
 var (
@@ -16,14 +20,15 @@ var (
 	rateLimitDuration = 2 * time.Second
 )
 
-func checkAuth(chatID int64, cfg *config.Config) bool {
-	if cfg == nil {
+func (b *Bot) checkAuth(chatID int64) bool {
+	if b.Config == nil {
 		return false
 	}
-	if len(cfg.AllowedUsers) == 0 {
+	lists := b.access.Load()
+	if len(lists.allowed) == 0 {
 		return true
 	}
-	allowed := cfg.AllowedUsers[chatID]
+	allowed := lists.allowed[chatID] || b.granted(chatID)
 	if !allowed {
 		log.Printf("[AUTH BLOCKED] Unauthorized user attempt from chatID: %d", chatID)
 	}
@@ -43,28 +48,82 @@ func checkRateLimit(chatID int64) bool {
 	return true
 }
 
-func cleanupRateLimitMap() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+// allowMessage applies the per-chat rate limit, sharing state through the
+// store when the backend supports it (e.g. Redis across replicas).
+func (b *Bot) allowMessage(chatID int64) bool {
+	if rl, ok := b.DB.(store.RateLimiter); ok {
+		allowed, err := rl.AllowRate(chatID, rateLimitDuration)
+		if err == nil {
+			return allowed
+		}
+		log.Printf("[RATE LIMIT] Shared limiter failed, using local: %v", err)
+	}
+	return checkRateLimit(chatID)
+}
 
-	for range ticker.C {
-		rateLimitMu.Lock()
-		threshold := time.Now().Add(-1 * time.Minute)
-		for chatID, lastTime := range rateLimitMap {
-			if lastTime.Before(threshold) {
-				delete(rateLimitMap, chatID)
+// cleanupRateLimits drops rate-limit entries older than a minute. It runs
+// as a scheduled maintenance job.
+func cleanupRateLimits(context.Context) error {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	threshold := time.Now().Add(-1 * time.Minute)
+	for chatID, lastTime := range rateLimitMap {
+		if lastTime.Before(threshold) {
+			delete(rateLimitMap, chatID)
+		}
+	}
+	log.Printf("[RATE LIMIT] Cleanup completed. Active entries: %d", len(rateLimitMap))
+	return nil
+}
+
+// runners remembers who sent each chat's latest prompt, so a user's
+// running replies can be counted across chats.
+type runners struct {
+	mu    sync.Mutex
+	users map[int64]int64 // chat ID -> user ID
+}
+
+// senderID returns the user who sent a message, or the chat for messages
+// without a sender, such as channel posts.
+func senderID(chatID int64, from *models.User) int64 {
+	if from == nil {
+		return chatID
+	}
+	return from.ID
+}
+
+// runLimitRefusal returns why userID may not start a prompt in chatID: the
+// replies streaming in their other chats already reach
+// MAX_RUNNING_PROMPTS. Otherwise the chat's next reply counts as theirs.
+// A new prompt in chatID itself doesn't add to the count, as it takes over
+// the chat's stream.
+func (b *Bot) runLimitRefusal(chatID, userID int64) string {
+	b.runners.mu.Lock()
+	defer b.runners.mu.Unlock()
+	if b.Config != nil && b.Config.MaxRunningPrompts > 0 && b.Stream != nil {
+		running := 0
+		for _, c := range b.Stream.RunningChats() {
+			if c != chatID && b.runners.users[c] == userID {
+				running++
 			}
 		}
-		rateLimitMu.Unlock()
-		log.Printf("[RATE LIMIT] Cleanup completed. Active entries: %d", len(rateLimitMap))
+		if running >= b.Config.MaxRunningPrompts {
+			log.Printf("[RUN LIMIT] User %d has %d prompts running, refusing chat %d", userID, running, chatID)
+			return fmt.Sprintf("You have %d prompt%s running in other chats already. Wait for one to finish, or /stop it there, before sending another.", running, plural(running))
+		}
 	}
+	if b.runners.users == nil {
+		b.runners.users = make(map[int64]int64)
+	}
+	b.runners.users[chatID] = userID
+	return ""
 }
 
 func (b *Bot) requireAuth(chatID int64, tgBot *bot.Bot, ctx context.Context) bool {
 	if b.Config == nil {
 		return true
 	}
-	if !checkAuth(chatID, b.Config) {
+	if !b.checkAuth(chatID) {
 		tgBot.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
 			Text:   "Unauthorized. You are not allowed to use this bot.",
@@ -75,8 +134,33 @@ func (b *Bot) requireAuth(chatID int64, tgBot *bot.Bot, ctx context.Context) boo
 }
 
 func (b *Bot) isAdmin(chatID int64) bool {
-	if b.Config == nil || len(b.Config.AdminUsers) == 0 {
+	if b.Config == nil {
+		return true
+	}
+	lists := b.access.Load()
+	if len(lists.admins) == 0 {
 		return true
 	}
-	return b.Config.AdminUsers[chatID]
+	return lists.admins[chatID]
+}
+
+// recoverPanics stops a panicking handler from taking down the bot and
+// reports it with the chat it happened in. PRIVACY_MODE reports only the
+// length of the message.
+func (b *Bot) recoverPanics(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+		fields := errreport.Fields{"handler": "telegram"}
+		switch {
+		case update.Message != nil:
+			fields["chat_id"] = strconv.FormatInt(update.Message.Chat.ID, 10)
+			fields["text"] = shortID(update.Message.Text)
+			if b.Config != nil && b.Config.PrivacyMode {
+				fields["text"] = fmt.Sprintf("<%d bytes>", len(update.Message.Text))
+			}
+		case update.CallbackQuery != nil:
+			fields["callback_data"] = update.CallbackQuery.Data
+		}
+		defer errreport.Recover(fields)
+		next(ctx, tgBot, update)
+	}
 }
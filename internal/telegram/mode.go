@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// modePrefix + session ID is the chat setting holding the session's
+// OpenCode mode, which replaces the chat's agent for its prompts.
+const modePrefix = "mode."
+
+// OpenCode's built-in primary agents: plan can read the project but not
+// edit it, build can do both.
+const (
+	modePlan  = "plan"
+	modeBuild = "build"
+)
+
+// sessionMode returns the mode /mode set for the session, or "".
+func (b *Bot) sessionMode(chatID int64, sessionID string) string {
+	if sessionID == "" {
+		return ""
+	}
+	return b.chatSetting(chatID, modePrefix+sessionID)
+}
+
+// promptAgent is the agent the chat's prompts to sessionID run as: the
+// session's mode if one is set, else agent.
+func (b *Bot) promptAgent(chatID int64, sessionID, agent string) string {
+	if mode := b.sessionMode(chatID, sessionID); mode != "" {
+		return mode
+	}
+	return agent
+}
+
+// modeCommand switches the current session between OpenCode's plan and
+// build modes, so a plan can be reviewed in chat before anything is
+// edited. "/mode off" goes back to the chat's agent.
+func (b *Bot) modeCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+
+	var text string
+	arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/mode"))
+	switch arg {
+	case "":
+		text = "No mode set; prompts use the " + agentOrDefault(b.currentAgent(chatID)) + " agent. Usage: /mode plan|build|off"
+		if mode := b.sessionMode(chatID, sessionID); mode != "" {
+			text = "This session is in " + mode + " mode. Usage: /mode plan|build|off"
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+		return
+	case modePlan:
+		text = "Plan mode: the agent reads the project and proposes changes without editing files. Review the plan, then /mode build to carry it out."
+	case modeBuild:
+		text = "Build mode: the agent may edit files and run commands."
+	case "off":
+		arg = ""
+		text = "Mode cleared; prompts use the " + agentOrDefault(b.currentAgent(chatID)) + " agent again"
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /mode plan|build|off"})
+		return
+	}
+	if err := b.DB.SetChatSetting(chatID, modePrefix+sessionID, arg); err != nil {
+		log.Printf("[modeCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+}
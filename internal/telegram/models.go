@@ -2,16 +2,30 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/Khaledxab/Openkh/internal/opencode"
 	"github.com/Khaledxab/Openkh/internal/store"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
 
+const (
+	// Chat setting keys; values are newline-separated provider/model refs.
+	modelRecentKey    = "model.recent"
+	modelFavoritesKey = "model.favorites"
+
+	maxRecentModels = 5
+
+	// callbackDataLimit is Telegram's cap on inline button callback data.
+	callbackDataLimit = 64
+)
+
 func (b *Bot) modelCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
 	if update.Message == nil {
 		return
@@ -45,42 +59,219 @@ func (b *Bot) modelCommand(ctx context.Context, tgBot *bot.Bot, update *models.U
 		return
 	}
 
+	// Start on starred and recent models; without any, go straight to
+	// the provider list.
+	text, keyboard := b.modelHomeMenu(chatID)
+	if keyboard == nil {
+		text, keyboard = b.modelProvidersMenu(chatID)
+	}
+	msg, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      chatID,
+		Text:        text,
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+	b.track(chatID, msg)
+}
+
+// modelHomeMenu lists the chat's starred models followed by its recently
+// used ones. The keyboard is nil when there are neither.
+func (b *Bot) modelHomeMenu(chatID int64) (string, [][]models.InlineKeyboardButton) {
+	favorites := b.modelList(chatID, modelFavoritesKey)
+	starred := make(map[string]bool, len(favorites))
+	var keyboard [][]models.InlineKeyboardButton
+	for _, ref := range favorites {
+		starred[ref] = true
+		if row := b.modelRow(ref, "★ ", false); row != nil {
+			keyboard = append(keyboard, row)
+		}
+	}
+	for _, ref := range b.modelList(chatID, modelRecentKey) {
+		if starred[ref] {
+			continue
+		}
+		if row := b.modelRow(ref, "", false); row != nil {
+			keyboard = append(keyboard, row)
+		}
+	}
+	if len(keyboard) == 0 {
+		return "", nil
+	}
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: "Browse all…", CallbackData: "modelall"},
+	})
+	return b.modelMenuTitle(chatID, "Select a model:"), keyboard
+}
+
+// modelProvidersMenu lists the connected providers to drill into.
+func (b *Bot) modelProvidersMenu(chatID int64) (string, [][]models.InlineKeyboardButton) {
 	var keyboard [][]models.InlineKeyboardButton
 	for _, p := range b.Providers {
-		for _, m := range p.Models {
-			keyboard = append(keyboard, []models.InlineKeyboardButton{
-				{Text: fmt.Sprintf("%s (%s)", m.Name, p.ID), CallbackData: "model_" + p.ID + "/" + m.ID},
-			})
+		name := p.Name
+		if name == "" {
+			name = p.ID
 		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: fmt.Sprintf("%s (%d)", name, len(p.Models)), CallbackData: "modelp_" + p.ID},
+		})
 	}
+	if b.hasModelHome(chatID) {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: "« Back", CallbackData: "modelhome"},
+		})
+	}
+	return b.modelMenuTitle(chatID, "Select a provider:"), keyboard
+}
 
-	tgBot.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: chatID,
-		Text:   "Select a model:",
-		ReplyMarkup: &models.InlineKeyboardMarkup{
-			InlineKeyboard: keyboard,
-		},
+// modelProviderMenu lists one provider's models by name, each with a
+// button to star or unstar it.
+func (b *Bot) modelProviderMenu(chatID int64, p opencode.Provider) (string, [][]models.InlineKeyboardButton) {
+	starred := make(map[string]bool)
+	for _, ref := range b.modelList(chatID, modelFavoritesKey) {
+		starred[ref] = true
+	}
+	ids := make([]string, 0, len(p.Models))
+	for id := range p.Models {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return p.Models[ids[i]].Name < p.Models[ids[j]].Name
 	})
+
+	var keyboard [][]models.InlineKeyboardButton
+	for _, id := range ids {
+		ref := p.ID + "/" + id
+		prefix := ""
+		if starred[ref] {
+			prefix = "★ "
+		}
+		keyboard = append(keyboard, b.modelRow(ref, prefix, b.DB != nil))
+	}
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: "« Providers", CallbackData: "modelall"},
+	})
+	return b.modelMenuTitle(chatID, "Select a model:"), keyboard
 }
 
-func (b *Bot) setModel(ctx context.Context, tgBot *bot.Bot, chatID int64, providerID, modelID string) {
+// modelRow is the button row selecting ref, or nil if no connected
+// provider offers it. withStar adds a star toggle when its callback
+// data fits.
+func (b *Bot) modelRow(ref, prefix string, withStar bool) []models.InlineKeyboardButton {
+	providerID, modelID, _ := strings.Cut(ref, "/")
+	name := b.findModelDisplayName(providerID, modelID)
+	if name == "" {
+		return nil
+	}
+	row := []models.InlineKeyboardButton{{Text: prefix + name, CallbackData: "model_" + ref}}
+	if star := "modelstar_" + ref; withStar && len(star) <= callbackDataLimit {
+		label := "☆"
+		if prefix != "" {
+			label = "★"
+		}
+		row = append(row, models.InlineKeyboardButton{Text: label, CallbackData: star})
+	}
+	return row
+}
+
+// modelMenuTitle prefixes prompt with the chat's current model, if any.
+func (b *Bot) modelMenuTitle(chatID int64, prompt string) string {
+	providerID, modelID := b.defaultProvider, b.defaultModel
 	if b.DB != nil {
-		sess, err := b.DB.GetSession(chatID)
-		if err == nil {
-			sess.ModelProvider = providerID
-			sess.ModelID = modelID
-			sess.LastUsed = time.Now()
-			b.DB.SetSession(sess)
-		} else {
-			b.DB.SetSession(store.Session{
-				ChatID:        chatID,
-				ModelProvider: providerID,
-				ModelID:       modelID,
-				CreatedAt:     time.Now(),
-				LastUsed:      time.Now(),
-			})
+		if sess, err := b.DB.GetSession(chatID); err == nil && sess.ModelID != "" {
+			providerID, modelID = sess.ModelProvider, sess.ModelID
 		}
 	}
+	if modelID == "" {
+		return prompt
+	}
+	name := b.findModelDisplayName(providerID, modelID)
+	if name == "" {
+		name = providerID + "/" + modelID
+	}
+	return fmt.Sprintf("Current model: %s\n\n%s", name, prompt)
+}
+
+func (b *Bot) hasModelHome(chatID int64) bool {
+	return len(b.modelList(chatID, modelFavoritesKey)) > 0 || len(b.modelList(chatID, modelRecentKey)) > 0
+}
+
+// modelList returns the refs stored under a chat setting key.
+func (b *Bot) modelList(chatID int64, key string) []string {
+	if b.DB == nil {
+		return nil
+	}
+	raw, err := b.DB.GetChatSetting(chatID, key)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[modelList] Chat %d %s: %v", chatID, key, err)
+		}
+		return nil
+	}
+	return strings.Fields(raw)
+}
+
+func (b *Bot) saveModelList(chatID int64, key string, refs []string) {
+	if err := b.DB.SetChatSetting(chatID, key, strings.Join(refs, "\n")); err != nil {
+		log.Printf("[saveModelList] Chat %d %s: %v", chatID, key, err)
+	}
+}
+
+// recordModelUse moves ref to the front of the chat's recent models.
+func (b *Bot) recordModelUse(chatID int64, ref string) {
+	if b.DB == nil {
+		return
+	}
+	recent := []string{ref}
+	for _, r := range b.modelList(chatID, modelRecentKey) {
+		if r != ref && len(recent) < maxRecentModels {
+			recent = append(recent, r)
+		}
+	}
+	b.saveModelList(chatID, modelRecentKey, recent)
+}
+
+// toggleFavorite stars or unstars ref and reports whether it is now starred.
+func (b *Bot) toggleFavorite(chatID int64, ref string) bool {
+	var favorites []string
+	found := false
+	for _, r := range b.modelList(chatID, modelFavoritesKey) {
+		if r == ref {
+			found = true
+			continue
+		}
+		favorites = append(favorites, r)
+	}
+	if !found {
+		favorites = append(favorites, ref)
+	}
+	b.saveModelList(chatID, modelFavoritesKey, favorites)
+	return !found
+}
+
+// saveModel stores the chat's model choice and records it as recent.
+func (b *Bot) saveModel(chatID int64, providerID, modelID string) {
+	if b.DB == nil {
+		return
+	}
+	sess, err := b.DB.GetSession(chatID)
+	if err == nil {
+		sess.ModelProvider = providerID
+		sess.ModelID = modelID
+		sess.LastUsed = time.Now()
+		b.DB.SetSession(sess)
+	} else {
+		b.DB.SetSession(store.Session{
+			ChatID:        chatID,
+			ModelProvider: providerID,
+			ModelID:       modelID,
+			CreatedAt:     time.Now(),
+			LastUsed:      time.Now(),
+		})
+	}
+	b.recordModelUse(chatID, providerID+"/"+modelID)
+}
+
+func (b *Bot) setModel(ctx context.Context, tgBot *bot.Bot, chatID int64, providerID, modelID string) {
+	b.saveModel(chatID, providerID, modelID)
 
 	displayName := b.findModelDisplayName(providerID, modelID)
 	if displayName == "" {
@@ -112,23 +303,7 @@ func (b *Bot) handleModelCallback(ctx context.Context, tgBot *bot.Bot, callback
 		displayName = providerID + "/" + modelID
 	}
 
-	if b.DB != nil {
-		sess, err := b.DB.GetSession(chatID)
-		if err == nil {
-			sess.ModelProvider = providerID
-			sess.ModelID = modelID
-			sess.LastUsed = time.Now()
-			b.DB.SetSession(sess)
-		} else {
-			b.DB.SetSession(store.Session{
-				ChatID:        chatID,
-				ModelProvider: providerID,
-				ModelID:       modelID,
-				CreatedAt:     time.Now(),
-				LastUsed:      time.Now(),
-			})
-		}
-	}
+	b.saveModel(chatID, providerID, modelID)
 
 	tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 		CallbackQueryID: callback.ID,
@@ -143,3 +318,66 @@ func (b *Bot) handleModelCallback(ctx context.Context, tgBot *bot.Bot, callback
 
 	log.Printf("[modelCallback] Chat %d set model to %s/%s", chatID, providerID, modelID)
 }
+
+// handleModelMenuCallback navigates the /model menu: "modelhome",
+// "modelall", "modelp_<provider>" and "modelstar_<provider>/<model>".
+func (b *Bot) handleModelMenuCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, data string) {
+	chatID := callback.Message.Message.Chat.ID
+	answer := ""
+	var text string
+	var keyboard [][]models.InlineKeyboardButton
+
+	switch {
+	case data == "modelhome":
+		text, keyboard = b.modelHomeMenu(chatID)
+		if keyboard == nil {
+			text, keyboard = b.modelProvidersMenu(chatID)
+		}
+	case data == "modelall":
+		text, keyboard = b.modelProvidersMenu(chatID)
+	case strings.HasPrefix(data, "modelp_"):
+		p, ok := b.findProvider(strings.TrimPrefix(data, "modelp_"))
+		if !ok {
+			answer = "Provider not available"
+			break
+		}
+		text, keyboard = b.modelProviderMenu(chatID, p)
+	case strings.HasPrefix(data, "modelstar_"):
+		ref := strings.TrimPrefix(data, "modelstar_")
+		providerID, _, _ := strings.Cut(ref, "/")
+		p, ok := b.findProvider(providerID)
+		if !ok || b.DB == nil {
+			answer = "Provider not available"
+			break
+		}
+		if b.toggleFavorite(chatID, ref) {
+			answer = "Starred"
+		} else {
+			answer = "Unstarred"
+		}
+		text, keyboard = b.modelProviderMenu(chatID, p)
+	}
+
+	tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: callback.ID,
+		Text:            answer,
+	})
+	if keyboard == nil {
+		return
+	}
+	tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:      chatID,
+		MessageID:   callback.Message.Message.ID,
+		Text:        text,
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+}
+
+func (b *Bot) findProvider(providerID string) (opencode.Provider, bool) {
+	for _, p := range b.Providers {
+		if p.ID == providerID {
+			return p, true
+		}
+	}
+	return opencode.Provider{}, false
+}
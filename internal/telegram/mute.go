@@ -0,0 +1,87 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// muteKey is the chat setting holding the chat's notification mode.
+const muteKey = "notify.mute"
+
+// Notification modes. Streaming edits never notify on Telegram; what
+// buzzes is the "Thinking..." message and, when muted, a completion ping.
+const (
+	muteOff   = ""      // "Thinking..." notifies, nothing on completion
+	muteFinal = "final" // silent while running, one ping when the reply is done
+	muteAll   = "all"   // fully silent
+)
+
+func (b *Bot) muteMode(chatID int64) string {
+	if b.DB == nil {
+		return muteOff
+	}
+	mode, err := b.DB.GetChatSetting(chatID, muteKey)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[muteMode] Chat %d: %v", chatID, err)
+		}
+		return muteOff
+	}
+	return mode
+}
+
+// Muted reports whether messages sent while a reply streams should be
+// silent for chatID.
+func (b *Bot) Muted(chatID int64) bool {
+	return b.muteMode(chatID) != muteOff
+}
+
+// muteCommand sets the chat's notification mode: "/mute" toggles between
+// off and a single ping on completion, "/mute all" silences everything.
+func (b *Bot) muteCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	var mode string
+	switch arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/mute")); arg {
+	case "":
+		mode = muteFinal
+		if b.muteMode(chatID) != muteOff {
+			mode = muteOff
+		}
+	case "on":
+		mode = muteFinal
+	case "all":
+		mode = muteAll
+	case "off":
+		mode = muteOff
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /mute [on|all|off]"})
+		return
+	}
+
+	if err := b.DB.SetChatSetting(chatID, muteKey, mode); err != nil {
+		log.Printf("[muteCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	text := "Notifications on: each prompt's reply notifies when it starts."
+	switch mode {
+	case muteFinal:
+		text = "Muted: replies stream silently and notify once when done."
+	case muteAll:
+		text = "Muted: replies are fully silent."
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text, DisableNotification: mode != muteOff})
+}
@@ -0,0 +1,111 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/ocserver"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// restartHealthTimeout is how long /oc restart waits for the restarted
+// server to pass its health check.
+const restartHealthTimeout = 2 * time.Minute
+
+// ocCommand manages the OpenCode server process set by OPENCODE_SERVICE:
+// "/oc status" describes it, "/oc restart" restarts it and reports once it
+// is healthy again.
+func (b *Bot) ocCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+	if b.Server == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "OPENCODE_SERVICE is not configured"})
+		return
+	}
+
+	switch arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/oc")); arg {
+	case "", "status":
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(b.ocStatus(ctx))})
+	case "restart":
+		b.ocRestart(ctx, tgBot, chatID)
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /oc status|restart"})
+	}
+}
+
+// ocStatus describes the server process, its health check and the event
+// stream.
+func (b *Bot) ocStatus(ctx context.Context) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🖥 OpenCode: %s\n\n", b.Server)
+	state, err := b.Server.Status(ctx)
+	if err != nil {
+		log.Printf("[ocCommand] Error: %v", err)
+		state = "unknown (" + err.Error() + ")"
+	}
+	sb.WriteString("Process: " + state + "\n")
+	if b.Client != nil {
+		if err := b.Client.Health(ctx); err != nil {
+			sb.WriteString("Health: ❌ " + err.Error() + "\n")
+		} else {
+			sb.WriteString("Health: ✅ OK\n")
+		}
+	}
+	if b.Stream != nil {
+		sb.WriteString(sseStatus(b.Stream.Health()) + "\n")
+	}
+	return sb.String()
+}
+
+// ocRestart restarts the server and edits its notice once the server is
+// healthy again, or gives up after restartHealthTimeout.
+func (b *Bot) ocRestart(ctx context.Context, tgBot *bot.Bot, chatID int64) {
+	text := "🔄 Restarting OpenCode..."
+	if b.Stream != nil {
+		switch n := len(b.Stream.RunningChats()); {
+		case n == 1:
+			text += "\nThe running reply will be cut off."
+		case n > 1:
+			text += fmt.Sprintf("\n%d running replies will be cut off.", n)
+		}
+	}
+	msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+	if err != nil {
+		log.Printf("[ocCommand] Error: %v", err)
+		return
+	}
+	report := func(text string) {
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{ChatID: chatID, MessageID: msg.ID, Text: b.truncate(text)})
+	}
+
+	log.Printf("[ocCommand] Chat %d is restarting the %s", chatID, b.Server)
+	started := time.Now()
+	if err := b.Server.Restart(ctx); err != nil {
+		log.Printf("[ocCommand] Error restarting: %v", err)
+		report("❌ Restart failed: " + err.Error())
+		return
+	}
+	if b.Client == nil {
+		report("✅ OpenCode restarted")
+		return
+	}
+	if err := ocserver.WaitHealthy(ctx, b.Client.Health, restartHealthTimeout); err != nil {
+		log.Printf("[ocCommand] Not healthy after restart: %v", err)
+		report(fmt.Sprintf("⚠️ OpenCode restarted but isn't healthy after %s: %v", restartHealthTimeout, err))
+		return
+	}
+	report(fmt.Sprintf("✅ OpenCode restarted and healthy after %s", time.Since(started).Round(time.Second)))
+}
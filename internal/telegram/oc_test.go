@@ -0,0 +1,29 @@
+package telegram
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot/models"
+)
+
+func TestOCCommandWithoutServer(t *testing.T) {
+	b := New(&config.Config{AdminUsers: map[int64]bool{1: true}}, nil, store.NewMemory(), nil)
+	for _, text := range []string{"/oc", "/oc status", "/oc restart"} {
+		ft, tgBot := newFakeTelegram(t)
+		b.ocCommand(context.Background(), tgBot, &models.Update{Message: &models.Message{Chat: models.Chat{ID: 1}, Text: text}})
+		if got, want := ft.texts("sendMessage"), []string{"OPENCODE_SERVICE is not configured"}; !slices.Equal(got, want) {
+			t.Errorf("%s: replies = %q, want %q", text, got, want)
+		}
+	}
+
+	// Admins only find out it isn't configured.
+	ft, tgBot := newFakeTelegram(t)
+	b.ocCommand(context.Background(), tgBot, &models.Update{Message: &models.Message{Chat: models.Chat{ID: 2}, Text: "/oc"}})
+	if got, want := ft.texts("sendMessage"), []string{"Admin only command"}; !slices.Equal(got, want) {
+		t.Errorf("non-admin: replies = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,109 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const outboxText = "📮 OpenCode is unreachable right now. Your prompt is saved and will be sent as soon as it's back; /stop cancels it."
+
+// holdPrompt saves a prompt that failed to reach OpenCode in the outbox,
+// if the server is down rather than refusing it, and reports whether it
+// did. The outbox job sends it once the server is healthy again.
+func (b *Bot) holdPrompt(ctx context.Context, chatID, userID int64, promptID int, text string) bool {
+	if b.DB == nil || b.Client == nil {
+		return false
+	}
+	if err := b.Client.Health(ctx); err == nil {
+		return false
+	}
+	p := store.OutboxPrompt{ChatID: chatID, PromptID: promptID, UserID: userID, Text: text, QueuedAt: time.Now()}
+	if err := b.DB.SaveOutboxPrompt(p); err != nil {
+		log.Printf("[outbox] Error saving the prompt of chat %d: %v", chatID, err)
+		return false
+	}
+	log.Printf("[outbox] OpenCode is unreachable, holding a prompt of chat %d", chatID)
+	return true
+}
+
+// replayOutbox sends the prompts held in the outbox once OpenCode answers
+// health checks again, each on its chat's dispatcher queue and with a
+// notice replying to the original message.
+func (b *Bot) replayOutbox(ctx context.Context, tgBot *bot.Bot) error {
+	held, err := b.DB.ListOutbox()
+	if err != nil {
+		return fmt.Errorf("list outbox: %w", err)
+	}
+	var prompts []store.OutboxPrompt
+	for _, p := range held {
+		if b.serves(p.ChatID) {
+			prompts = append(prompts, p)
+		}
+	}
+	if len(prompts) == 0 {
+		return nil
+	}
+	if err := b.Client.Health(ctx); err != nil {
+		return nil
+	}
+
+	for _, p := range prompts {
+		// Another replica may have replayed it already.
+		taken, err := b.DB.DeleteOutboxPrompt(p.ChatID, p.PromptID)
+		if err != nil {
+			return fmt.Errorf("take chat %d's prompt off the outbox: %w", p.ChatID, err)
+		}
+		if !taken || !b.checkAuth(p.ChatID) {
+			continue
+		}
+		log.Printf("[outbox] OpenCode is back, sending the held prompt of chat %d", p.ChatID)
+		p := p
+		if !b.updates.submit(p.ChatID, func() {
+			ctx := context.Background()
+			msg, _ := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          p.ChatID,
+				Text:            fmt.Sprintf("📮 OpenCode is back. Sending your prompt from %s.", p.QueuedAt.In(b.location(p.ChatID)).Format("15:04")),
+				ReplyParameters: &models.ReplyParameters{MessageID: p.PromptID, AllowSendingWithoutReply: true},
+			})
+			b.track(p.ChatID, msg)
+			b.runPrompt(ctx, tgBot, p.ChatID, p.UserID, p.PromptID, p.Text)
+		}) {
+			log.Printf("[outbox] Queue full for chat %d, holding its prompt until the next run", p.ChatID)
+			if err := b.DB.SaveOutboxPrompt(p); err != nil {
+				log.Printf("[outbox] Error: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// dropOutbox removes the chat's held prompts and returns how many there
+// were.
+func (b *Bot) dropOutbox(chatID int64) int {
+	if b.DB == nil {
+		return 0
+	}
+	held, err := b.DB.ListOutbox()
+	if err != nil {
+		log.Printf("[dropOutbox] Error: %v", err)
+		return 0
+	}
+	n := 0
+	for _, p := range held {
+		if p.ChatID != chatID {
+			continue
+		}
+		if taken, err := b.DB.DeleteOutboxPrompt(chatID, p.PromptID); err != nil {
+			log.Printf("[dropOutbox] Error: %v", err)
+		} else if taken {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,105 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// pendingCallbackPrefix marks inline buttons whose press should be routed
+// to the chat's pending action instead of a regular callback handler.
+const pendingCallbackPrefix = "pending_"
+
+// defaultPendingTTL is how long a multi-step flow waits for the user.
+const defaultPendingTTL = 5 * time.Minute
+
+// pendingHandler continues a multi-step flow with the user's reply (a
+// message text or the data of a pending_ button). The action has already
+// been removed from the store; handlers call awaitInput again to continue.
+type pendingHandler func(ctx context.Context, tgBot *bot.Bot, chatID int64, action store.PendingAction, input string)
+
+// registerPendingHandlers wires the flows that use pending actions.
+func (b *Bot) registerPendingHandlers() {
+	b.pending = map[string]pendingHandler{
+		"rename":     b.continueRename,
+		"permission": b.continueApproval,
+		"question":   b.continueQuestion,
+	}
+}
+
+// awaitInput records that the chat's next message or pending_ button
+// press belongs to the flow of the given type.
+func (b *Bot) awaitInput(chatID int64, actionType, payload string, ttl time.Duration) error {
+	if b.DB == nil {
+		return nil
+	}
+	now := time.Now()
+	return b.DB.SetPendingAction(store.PendingAction{
+		ChatID:    chatID,
+		Type:      actionType,
+		Payload:   payload,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+}
+
+// dispatchPending routes input to the chat's pending action, if any. It
+// reports whether the input was consumed.
+func (b *Bot) dispatchPending(ctx context.Context, tgBot *bot.Bot, chatID int64, input string) bool {
+	if b.DB == nil {
+		return false
+	}
+	action, err := b.DB.GetPendingAction(chatID)
+	if err != nil {
+		return false
+	}
+	handler, ok := b.pending[action.Type]
+	if !ok {
+		log.Printf("[dispatchPending] No handler for pending action %q, dropping", action.Type)
+		b.DB.DeletePendingAction(chatID)
+		return false
+	}
+	if err := b.DB.DeletePendingAction(chatID); err != nil {
+		log.Printf("[dispatchPending] Error clearing pending action: %v", err)
+	}
+	handler(ctx, tgBot, chatID, action, input)
+	return true
+}
+
+func (b *Bot) handlePendingCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, data string) {
+	input := strings.TrimPrefix(data, pendingCallbackPrefix)
+	if !b.dispatchPending(ctx, tgBot, chatID, input) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: callback.ID,
+			Text:            "This action has expired",
+		})
+		return
+	}
+	tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID})
+}
+
+func (b *Bot) cancelCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	text := "Nothing to cancel"
+	if b.DB != nil {
+		if _, err := b.DB.GetPendingAction(chatID); err == nil {
+			if err := b.DB.DeletePendingAction(chatID); err != nil {
+				log.Printf("[cancelCommand] Error: %v", err)
+			}
+			text = "Cancelled"
+		}
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+}
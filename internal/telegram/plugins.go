@@ -0,0 +1,122 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/plugin"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// pluginCommand is a command of PLUGINS_FILE.
+type pluginCommand struct {
+	name string
+	spec config.PluginSpec
+	run  plugin.Plugin
+}
+
+// loadPlugins builds the PLUGINS_FILE commands. A plugin named like a
+// built-in command is skipped, so none can take over /stop or /help.
+func (b *Bot) loadPlugins() []pluginCommand {
+	if b.Config == nil || len(b.Config.Plugins) == 0 {
+		return nil
+	}
+	builtin := make(map[string]bool)
+	for _, c := range b.commands() {
+		builtin[c.name] = true
+	}
+	var out []pluginCommand
+	for _, name := range b.Config.PluginNames() {
+		spec := b.Config.Plugins[name]
+		if builtin[name] {
+			log.Printf("Warning: plugin /%s would shadow the /%s command, ignoring", name, name)
+			continue
+		}
+		run := plugin.Hook(spec.URL, spec.Token, spec.RunTimeout())
+		if len(spec.Exec) > 0 {
+			run = plugin.Exec(spec.Exec, spec.RunTimeout())
+		}
+		out = append(out, pluginCommand{name: name, spec: spec, run: run})
+	}
+	log.Printf("Loaded %d plugin command(s)", len(out))
+	return out
+}
+
+// pluginCommands returns the registry entries of the plugins.
+func (b *Bot) pluginCommands() []command {
+	var out []command
+	for _, p := range b.plugins {
+		help := p.spec.Help
+		if help == "" {
+			help = "Plugin command"
+		}
+		r := roleUser
+		if p.spec.Admin {
+			r = roleAdmin
+		}
+		out = append(out, command{name: p.name, args: p.spec.Args, help: help, menu: help, section: "Plugins",
+			match: bot.MatchTypeCommandStartOnly, handler: b.pluginHandler(p), role: r})
+	}
+	return out
+}
+
+// pluginHandler runs the plugin with the chat's context and posts what it
+// returns. Only admins see why a plugin failed, as its error may show the
+// plugin's internals.
+func (b *Bot) pluginHandler(p pluginCommand) bot.HandlerFunc {
+	return func(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+		if update.Message == nil {
+			return
+		}
+		chatID := update.Message.Chat.ID
+		if !b.requireAuth(chatID, tgBot, ctx) {
+			return
+		}
+		if p.spec.Admin && !b.isAdmin(chatID) {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+			return
+		}
+
+		providerID, modelID := b.currentModel(chatID)
+		agent, providerID, modelID := b.withDefaults(b.currentAgent(chatID), providerID, modelID)
+		req := plugin.Request{
+			Command:   p.name,
+			Args:      strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/"+p.name)),
+			ChatID:    chatID,
+			UserID:    senderID(chatID, update.Message.From),
+			Admin:     b.isAdmin(chatID),
+			SessionID: b.currentSessionID(chatID),
+			Agent:     agent,
+		}
+		if update.Message.From != nil {
+			req.Username = update.Message.From.Username
+		}
+		if modelID != "" {
+			req.Model = providerID + "/" + modelID
+		}
+		if b.Client != nil {
+			req.Directory = b.projectDir(ctx, chatID)
+		} else {
+			req.Directory = b.sessionDir(chatID)
+		}
+
+		tgBot.SendChatAction(ctx, &bot.SendChatActionParams{ChatID: chatID, Action: "typing"})
+		out, err := p.run.Run(ctx, req)
+		if err != nil {
+			log.Printf("[pluginHandler] Error: /%s: %v", p.name, err)
+			text := "Plugin /" + p.name + " failed"
+			if b.isAdmin(chatID) {
+				text += ": " + err.Error()
+			}
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(text)})
+			return
+		}
+		if out == "" {
+			out = "/" + p.name + " finished without output"
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(out)})
+	}
+}
@@ -0,0 +1,62 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/postprocess"
+)
+
+// branchTimeout bounds looking up a repository's default branch for links.
+const branchTimeout = 10 * time.Second
+
+// Postprocessor returns the POSTPROCESS pipeline replies go through before
+// they are shown, or nil if there is none.
+func (b *Bot) Postprocessor() opencode.TextProcessor {
+	if b.Config == nil || len(b.Config.Postprocess) == 0 {
+		return nil
+	}
+	p, err := postprocess.New(b.Config.Postprocess, postprocess.Options{
+		ForgeType:  b.Config.ForgeType,
+		ForgeURL:   b.Config.ForgeURL,
+		ForgeRepos: b.Config.ForgeRepos,
+		Branch:     b.forgeBranch,
+	})
+	if err != nil {
+		log.Printf("Warning: ignoring POSTPROCESS: %v", err)
+		return nil
+	}
+	// Look the branches up now, so the first replies get links too.
+	for _, repo := range b.Config.ForgeRepos {
+		b.forgeBranch(repo)
+	}
+	return p
+}
+
+// forgeBranch returns the default branch of repo, or "" until it is known.
+// Processors run on the SSE reader, so the forge is asked in the
+// background, and again after a failure.
+func (b *Bot) forgeBranch(repo string) string {
+	if b.Forge == nil {
+		return ""
+	}
+	if v, loaded := b.branches.LoadOrStore(repo, ""); loaded {
+		return v.(string)
+	}
+	go func() {
+		defer errreport.Recover(errreport.Fields{"goroutine": "forge-branch", "repo": repo})
+		ctx, cancel := context.WithTimeout(context.Background(), branchTimeout)
+		defer cancel()
+		branch, err := b.Forge.DefaultBranch(ctx, repo)
+		if err != nil {
+			log.Printf("[forgeBranch] Warning: %s: %v", repo, err)
+			b.branches.Delete(repo)
+			return
+		}
+		b.branches.Store(repo, branch)
+	}()
+	return ""
+}
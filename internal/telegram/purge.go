@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// purgeTimeout is how long a /purge request waits for approval.
+const purgeTimeout = 5 * time.Minute
+
+// purgeRequest is a /purge waiting for a second admin.
+type purgeRequest struct {
+	id        string
+	requester int64
+	prompts   map[int64]int // approver chat -> message with the buttons
+	timer     *time.Timer
+}
+
+// purgeState holds the one /purge request that may be pending.
+type purgeState struct {
+	mu      sync.Mutex
+	pending *purgeRequest
+}
+
+// purgeCommand asks the other admins to approve deleting every OpenCode
+// session. With no other admin configured, the requester confirms.
+func (b *Bot) purgeCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+
+	b.purges.mu.Lock()
+	defer b.purges.mu.Unlock()
+	if b.purges.pending != nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "A purge is already waiting for approval"})
+		return
+	}
+
+	approvers := b.otherAdmins(chatID)
+	self := len(approvers) == 0
+	if self {
+		approvers = []int64{chatID}
+	}
+	count := "all"
+	if b.Client != nil {
+		if sessions, err := b.Client.ListOCSessions(ctx); err == nil {
+			count = strconv.Itoa(len(sessions))
+		}
+	}
+
+	req := &purgeRequest{
+		id:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		requester: chatID,
+		prompts:   make(map[int64]int),
+	}
+	text := fmt.Sprintf("⚠️ Admin %d asks to delete %s session(s) on the OpenCode server. This can't be undone.", chatID, count)
+	if self {
+		text = fmt.Sprintf("⚠️ Delete %s session(s) on the OpenCode server? This can't be undone. (No second admin is configured.)", count)
+	}
+	keyboard := &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+		{Text: "Approve", CallbackData: "purge_ok_" + req.id},
+		{Text: "Reject", CallbackData: "purge_no_" + req.id},
+	}}}
+	for _, approver := range approvers {
+		msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: approver, Text: text, ReplyMarkup: keyboard})
+		if err != nil {
+			log.Printf("[purgeCommand] Error asking admin %d: %v", approver, err)
+			continue
+		}
+		req.prompts[approver] = msg.ID
+	}
+	if len(req.prompts) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Couldn't reach another admin to approve the purge"})
+		return
+	}
+
+	req.timer = time.AfterFunc(purgeTimeout, func() { b.expirePurge(tgBot, req) })
+	b.purges.pending = req
+	log.Printf("[purgeCommand] Admin %d requested a purge, asking %d admin(s)", chatID, len(req.prompts))
+	if !self {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("Purge requested. It runs when another admin approves, within %s.", purgeTimeout),
+		})
+	}
+}
+
+// otherAdmins lists the configured admins other than chatID.
+func (b *Bot) otherAdmins(chatID int64) []int64 {
+	var ids []int64
+	for id := range b.access.Load().admins {
+		if id != chatID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// handlePurgeCallback approves ("purge_ok_<id>") or rejects
+// ("purge_no_<id>") the pending purge.
+func (b *Bot) handlePurgeCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, data string) {
+	approve := strings.HasPrefix(data, "purge_ok_")
+	id := data[len("purge_ok_"):]
+	answer := func(text string) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: text})
+	}
+	if !b.isAdmin(chatID) {
+		answer("Admin only")
+		return
+	}
+
+	b.purges.mu.Lock()
+	req := b.purges.pending
+	switch {
+	case req == nil || req.id != id:
+		b.purges.mu.Unlock()
+		answer("This request has expired")
+		return
+	case approve && chatID == req.requester && len(req.prompts) > 1:
+		b.purges.mu.Unlock()
+		answer("Another admin has to approve")
+		return
+	}
+	b.purges.pending = nil
+	req.timer.Stop()
+	b.purges.mu.Unlock()
+	answer("")
+
+	if !approve {
+		log.Printf("[handlePurgeCallback] Admin %d rejected the purge requested by %d", chatID, req.requester)
+		b.closePurge(ctx, tgBot, req, fmt.Sprintf("Purge rejected by admin %d.", chatID))
+		return
+	}
+	log.Printf("[handlePurgeCallback] Admin %d approved the purge requested by %d", chatID, req.requester)
+	deleted := b.purgeAll(ctx)
+	b.closePurge(ctx, tgBot, req, fmt.Sprintf("Purge approved by admin %d: deleted %d session(s).", chatID, deleted))
+}
+
+// expirePurge drops req if nobody answered it in time.
+func (b *Bot) expirePurge(tgBot *bot.Bot, req *purgeRequest) {
+	b.purges.mu.Lock()
+	if b.purges.pending != req {
+		b.purges.mu.Unlock()
+		return
+	}
+	b.purges.pending = nil
+	b.purges.mu.Unlock()
+	log.Printf("[expirePurge] Purge requested by %d expired", req.requester)
+	b.closePurge(context.Background(), tgBot, req, "Purge request expired without approval.")
+}
+
+// closePurge replaces the buttons with the outcome and tells the
+// requester if they weren't among the approvers.
+func (b *Bot) closePurge(ctx context.Context, tgBot *bot.Bot, req *purgeRequest, outcome string) {
+	for approver, msgID := range req.prompts {
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{ChatID: approver, MessageID: msgID, Text: outcome})
+	}
+	if _, asked := req.prompts[req.requester]; !asked {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: req.requester, Text: outcome})
+	}
+}
+
+// purgeAll deletes every OpenCode session and the bot's mappings, and
+// returns how many sessions were deleted.
+func (b *Bot) purgeAll(ctx context.Context) int {
+	deleted := 0
+	if b.Client != nil {
+		sessions, err := b.Client.ListOCSessions(ctx)
+		if err == nil {
+			for _, sess := range sessions {
+				if err := b.Client.DeleteOCSession(ctx, sess.ID); err != nil {
+					log.Printf("[purgeAll] Error deleting OC session %s: %v", shortID(sess.ID), err)
+					continue
+				}
+				deleted++
+			}
+		}
+	}
+	if b.DB != nil {
+		if err := b.DB.DeleteAll(); err != nil {
+			log.Printf("[purgeAll] Error clearing DB: %v", err)
+		}
+	}
+	return deleted
+}
@@ -0,0 +1,225 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Input of the question buttons, after pendingCallbackPrefix.
+const (
+	questionPick = "q:"     // + index of the option
+	questionDone = "q:done" // submits the options picked so far
+	questionSkip = "q:skip" // dismisses the question
+)
+
+// questionState is the payload of a "question" pending action: the
+// question being answered and the answers so far.
+type questionState struct {
+	Question opencode.Question `json:"question"`
+	Index    int               `json:"index"`            // item being asked
+	Answers  [][]string        `json:"answers"`          // to the items before it
+	Picked   []string          `json:"picked,omitempty"` // options picked so far, for Multiple items
+	Prompt   int               `json:"prompt"`           // message asking the item
+}
+
+type questionHook struct {
+	b     *Bot
+	tgBot *bot.Bot
+}
+
+// Questions returns the stream's QuestionAsker: the agent's questions are
+// put to the chat with a button per suggested answer, and the chat's next
+// reply is taken as the answer, so the run carries on.
+func (b *Bot) Questions(tgBot *bot.Bot) opencode.QuestionAsker {
+	return questionHook{b: b, tgBot: tgBot}
+}
+
+func (h questionHook) QuestionAsked(chatID int64, q opencode.Question) {
+	if h.b.Client == nil {
+		return
+	}
+	go func() {
+		defer errreport.Recover(errreport.Fields{"goroutine": "question", "chat_id": fmt.Sprint(chatID), "session_id": q.SessionID})
+		h.b.askQuestion(context.Background(), h.tgBot, chatID, questionState{Question: q})
+	}()
+}
+
+// askQuestion sends the item being asked and waits for the chat's answer.
+// Without a store to wait in, the question is dismissed rather than left
+// to stall the run.
+func (b *Bot) askQuestion(ctx context.Context, tgBot *bot.Bot, chatID int64, st questionState) {
+	if b.DB == nil {
+		b.rejectQuestion(ctx, chatID, st.Question)
+		return
+	}
+	msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      chatID,
+		Text:        b.truncate(questionText(st)),
+		ReplyMarkup: questionMarkup(st),
+	})
+	if err != nil {
+		log.Printf("[askQuestion] Chat %d: %v", chatID, err)
+		b.rejectQuestion(ctx, chatID, st.Question)
+		return
+	}
+	b.track(chatID, msg)
+	st.Prompt = msg.ID
+	b.awaitQuestion(chatID, st)
+}
+
+func (b *Bot) awaitQuestion(chatID int64, st questionState) {
+	payload, err := json.Marshal(st)
+	if err == nil {
+		err = b.awaitInput(chatID, "question", string(payload), approvalTTL)
+	}
+	if err != nil {
+		log.Printf("[awaitQuestion] Error saving pending action: %v", err)
+	}
+}
+
+func (b *Bot) rejectQuestion(ctx context.Context, chatID int64, q opencode.Question) {
+	if err := b.Client.RejectQuestion(ctx, q.ID); err != nil {
+		log.Printf("[rejectQuestion] Chat %d: %v", chatID, err)
+	}
+}
+
+// continueQuestion takes the chat's answer to the item being asked: an
+// option's button, or text typed as an answer of its own. Once every item
+// is answered, the answers go back to the session.
+func (b *Bot) continueQuestion(ctx context.Context, tgBot *bot.Bot, chatID int64, action store.PendingAction, input string) {
+	var st questionState
+	if err := json.Unmarshal([]byte(action.Payload), &st); err != nil || st.Index >= len(st.Question.Questions) {
+		log.Printf("[continueQuestion] Bad payload %q", action.Payload)
+		return
+	}
+	item := st.Question.Questions[st.Index]
+
+	var answer []string
+	switch {
+	case input == questionSkip:
+		b.rejectQuestion(ctx, chatID, st.Question)
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    chatID,
+			MessageID: st.Prompt,
+			Text:      b.truncate("❔ " + item.Question + "\n\nSkipped"),
+		})
+		return
+	case input == questionDone:
+		if len(st.Picked) == 0 {
+			b.awaitQuestion(chatID, st)
+			return
+		}
+		answer = st.Picked
+	case strings.HasPrefix(input, questionPick):
+		i, err := strconv.Atoi(strings.TrimPrefix(input, questionPick))
+		if err != nil || i < 0 || i >= len(item.Options) {
+			b.awaitQuestion(chatID, st)
+			return
+		}
+		label := item.Options[i].Label
+		if !item.Multiple {
+			answer = []string{label}
+			break
+		}
+		st.Picked = togglePick(st.Picked, label)
+		b.awaitQuestion(chatID, st)
+		tgBot.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+			ChatID:      chatID,
+			MessageID:   st.Prompt,
+			ReplyMarkup: questionMarkup(st),
+		})
+		return
+	default:
+		answer = append(st.Picked, strings.TrimSpace(input))
+	}
+
+	tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    chatID,
+		MessageID: st.Prompt,
+		Text:      b.truncate("❔ " + item.Question + "\n\n→ " + strings.Join(answer, ", ")),
+	})
+	st.Answers = append(st.Answers, answer)
+	st.Index, st.Picked, st.Prompt = st.Index+1, nil, 0
+	if st.Index < len(st.Question.Questions) {
+		b.askQuestion(ctx, tgBot, chatID, st)
+		return
+	}
+	if err := b.Client.AnswerQuestion(ctx, st.Question.ID, st.Answers); err != nil {
+		log.Printf("[continueQuestion] Chat %d: %v", chatID, err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to answer the question: " + err.Error()})
+		return
+	}
+	log.Printf("[continueQuestion] Chat %d answered question %s in session %s", chatID, st.Question.ID, st.Question.SessionID)
+}
+
+// questionText shows the item being asked and what its options mean.
+func questionText(st questionState) string {
+	item := st.Question.Questions[st.Index]
+	var sb strings.Builder
+	sb.WriteString("❔ ")
+	if n := len(st.Question.Questions); n > 1 {
+		sb.WriteString(fmt.Sprintf("(%d/%d) ", st.Index+1, n))
+	}
+	if item.Header != "" {
+		sb.WriteString(item.Header + "\n\n")
+	}
+	sb.WriteString(item.Question)
+	for _, o := range item.Options {
+		if o.Description != "" {
+			sb.WriteString("\n• " + o.Label + ": " + o.Description)
+		}
+	}
+	switch {
+	case len(item.Options) == 0:
+		sb.WriteString("\n\nReply with your answer.")
+	case item.Multiple:
+		sb.WriteString("\n\nPick the answers that apply and tap Done, or reply with your own.")
+	default:
+		sb.WriteString("\n\nPick an answer, or reply with your own.")
+	}
+	return sb.String()
+}
+
+// questionMarkup has a button per option of the item being asked, ticked
+// if picked, and Done and Skip.
+func questionMarkup(st questionState) *models.InlineKeyboardMarkup {
+	item := st.Question.Questions[st.Index]
+	var keyboard [][]models.InlineKeyboardButton
+	for i, o := range item.Options {
+		label := o.Label
+		for _, p := range st.Picked {
+			if p == o.Label {
+				label = "✅ " + label
+				break
+			}
+		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: label, CallbackData: pendingCallbackPrefix + questionPick + strconv.Itoa(i)},
+		})
+	}
+	last := []models.InlineKeyboardButton{{Text: "Skip", CallbackData: pendingCallbackPrefix + questionSkip}}
+	if item.Multiple {
+		last = append([]models.InlineKeyboardButton{{Text: "Done", CallbackData: pendingCallbackPrefix + questionDone}}, last...)
+	}
+	return &models.InlineKeyboardMarkup{InlineKeyboard: append(keyboard, last)}
+}
+
+// togglePick adds label to picked, or takes it out if it is there.
+func togglePick(picked []string, label string) []string {
+	for i, p := range picked {
+		if p == label {
+			return append(picked[:i:i], picked[i+1:]...)
+		}
+	}
+	return append(picked, label)
+}
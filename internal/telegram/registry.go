@@ -0,0 +1,373 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// role is the minimum access level a command is shown to.
+type role int
+
+const (
+	roleUser role = iota
+	roleAdmin
+)
+
+// command describes one slash command. The list in commands is the single
+// source for handler registration, /help and the Telegram command menu.
+type command struct {
+	name    string // without the slash
+	args    string // usage shown in /help, e.g. "<id>"
+	help    string // /help description
+	menu    string // command menu description; empty keeps it out of the menu
+	section string
+	match   bot.MatchType
+	handler bot.HandlerFunc
+	role    role
+	enabled func() bool // nil means always available
+	alias   string      // for alias entries, the command this one runs
+	private bool        // kept out of group menus, for commands not to be used in front of others
+}
+
+// helpSections fixes the order of sections in /help.
+var helpSections = []string{"Basic", "Session", "Agent", "Tools", "Info", "Plugins", "Admin"}
+
+func (b *Bot) commands() []command {
+	hasStream := func() bool { return b.Stream != nil }
+	hasDB := func() bool { return b.DB != nil }
+	cmds := []command{
+		{name: "start", help: "Start fresh", menu: "Start fresh", section: "Basic", match: bot.MatchTypeExact, handler: b.startCommand},
+		{name: "help", help: "Show this help", menu: "Show commands", section: "Basic", match: bot.MatchTypeExact, handler: b.helpCommand},
+		{name: "new", help: "New conversation", menu: "New conversation", section: "Basic", match: bot.MatchTypeExact, handler: b.newCommand},
+		{name: "stop", help: "Stop current operation", menu: "Stop current operation", section: "Basic", match: bot.MatchTypeExact, handler: b.stopCommand},
+		{name: "cancel", help: "Cancel a pending action", menu: "Cancel the pending action", section: "Basic", match: bot.MatchTypeExact, handler: b.cancelCommand},
+
+		{name: "sessions", args: "[all]", help: "List sessions; all includes archived ones", menu: "List all sessions", section: "Session", match: bot.MatchTypeCommandStartOnly, handler: b.sessionsCommand},
+		{name: "switch", args: "<id>", help: "Switch to session", menu: "Switch to session", section: "Session", match: bot.MatchTypePrefix, handler: b.switchCommand},
+		{name: "follow", args: "[id|off]", help: "Stream another session's replies to this chat", menu: "Follow another session", section: "Session", match: bot.MatchTypeCommandStartOnly, handler: b.followCommand,
+			enabled: func() bool { return hasDB() && hasStream() }},
+		{name: "rename", args: "[title]", help: "Rename session", menu: "Rename session", section: "Session", match: bot.MatchTypePrefix, handler: b.renameCommand},
+		{name: "delete", args: "<id>", help: "Delete session", menu: "Delete session", section: "Session", match: bot.MatchTypePrefix, handler: b.deleteCommand},
+		{name: "snapshot", args: "[name]", help: "Save a named restore point, or list them", menu: "Save a restore point", section: "Session", match: bot.MatchTypePrefix, handler: b.snapshotCommand,
+			enabled: hasDB},
+		{name: "restore", args: "<name>", help: "Roll messages and files back to a snapshot", menu: "Roll back to a snapshot", section: "Session", match: bot.MatchTypePrefix, handler: b.restoreCommand,
+			enabled: hasDB},
+		{name: "cd", args: "[path]", help: "Set the directory new sessions start in", menu: "Set the working directory", section: "Session", match: bot.MatchTypeCommandStartOnly, handler: b.cdCommand,
+			enabled: hasDB},
+		{name: "repo", args: "[name|off]", help: "Show or switch the repository this chat works on", menu: "Switch repository", section: "Session", match: bot.MatchTypeCommandStartOnly, handler: b.repoCommand,
+			enabled: func() bool { return hasDB() && b.Config != nil && len(b.Config.Repos) > 0 }},
+		{name: "worktree", args: "list|new <branch>", help: "List worktrees, or start a session on a new branch checkout", menu: "Git worktrees", section: "Session", match: bot.MatchTypeCommandStartOnly, handler: b.worktreeCommand,
+			enabled: hasDB},
+		{name: "newfrom", args: "[template]", help: "Start a session from a template's agent, model, directory and system prompt", menu: "New session from a template", section: "Session", match: bot.MatchTypeCommandStartOnly, handler: b.newFromCommand,
+			enabled: hasDB},
+		{name: "compact", args: "[auto <percent|off>]", help: "Summarize the session to free up its context window, now or automatically", menu: "Compact the session", section: "Session", match: bot.MatchTypeCommandStartOnly, handler: b.compactCommand,
+			enabled: hasDB},
+		{name: "lock", args: "[passphrase|clear]", help: "Lock the current session with a passphrase", menu: "Lock this session", section: "Session", match: bot.MatchTypeCommandStartOnly, handler: b.lockCommand,
+			enabled: hasDB, private: true},
+		{name: "unlock", args: "<passphrase>", help: "Unlock a locked session for 30 minutes", menu: "Unlock a session", section: "Session", match: bot.MatchTypeCommandStartOnly, handler: b.unlockCommand,
+			enabled: hasDB, private: true},
+		{name: "purge", help: "Delete all sessions (a second admin approves)", menu: "Delete all sessions", section: "Session", match: bot.MatchTypeExact, handler: b.purgeCommand, role: roleAdmin},
+
+		{name: "agent", args: "[name]", help: "Switch agent, or set it directly", menu: "Switch agent", section: "Agent", match: bot.MatchTypePrefix, handler: b.agentCommand},
+
+		{name: "run", args: "[target]", help: "Run a project command such as build or lint in the session's directory", menu: "Run a project command", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.runCommand,
+			enabled: func() bool { return len(b.Config.RunCommands) > 0 }},
+		{name: "todos", args: "[diff]", help: "List TODO/FIXME items from the session and follow one up", menu: "List TODO items", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.todosCommand},
+		{name: "ls", args: "[path]", help: "Browse the working directory and preview files", menu: "Browse files", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.lsCommand},
+		{name: "watch", args: "[on|off]", help: "Report files that change in the working directory outside a run", menu: "Watch for file changes", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.watchCommand,
+			enabled: hasDB},
+		{name: "diff", args: "[path]", help: "Show changes: a diffstat, or one file's diff", menu: "Show file changes", section: "Tools", match: bot.MatchTypePrefix, handler: b.diffCommand},
+		{name: "cleanup", help: "Delete status messages and old pickers", menu: "Tidy up bot messages", section: "Tools", match: bot.MatchTypeExact, handler: b.cleanupCommand,
+			enabled: hasDB},
+		{name: "saved", help: "List and re-open saved replies", menu: "Saved replies", section: "Tools", match: bot.MatchTypeExact, handler: b.savedCommand,
+			enabled: hasDB},
+		{name: "history", help: "Show messages", menu: "Show message history", section: "Tools", match: bot.MatchTypeExact, handler: b.historyCommand},
+		{name: "tool", args: "[id]", help: "List tool calls, or show one's full output", menu: "Show tool output", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.toolCommand},
+		{name: "export", help: "Download the last reply in full", menu: "Download the last reply in full", section: "Tools", match: bot.MatchTypeExact, handler: b.exportCommand,
+			enabled: func() bool { return hasDB() || hasStream() }},
+		{name: "model", args: "[provider/model]", help: "Select model (starred and recent first)", menu: "Select model", section: "Tools", match: bot.MatchTypePrefix, handler: b.modelCommand},
+		{name: "mode", args: "[plan|build|off]", help: "Plan without editing files, or let the agent build", menu: "Plan or build mode", section: "Agent", match: bot.MatchTypeCommandStartOnly, handler: b.modeCommand,
+			enabled: hasDB},
+		{name: "approvals", args: "[tool mode]", help: "Show or set which tool requests need your approval", menu: "Tool approval policy", section: "Agent", match: bot.MatchTypeCommandStartOnly, handler: b.approvalsCommand,
+			enabled: hasDB},
+		{name: "responselang", args: "[code|off]", help: "Ask for replies in a language, e.g. de", menu: "Set the reply language", section: "Agent", match: bot.MatchTypePrefix, handler: b.responseLangCommand,
+			enabled: hasDB},
+		{name: "mute", args: "[on|all|off]", help: "Silence streaming; notify once when done, or never", menu: "Silence reply notifications", section: "Tools", match: bot.MatchTypePrefix, handler: b.muteCommand,
+			enabled: hasDB},
+		{name: "notify", args: "[email <address|always|long|off>]", help: "Email the reply and diff of long runs", menu: "Email notifications", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.notifyCommand,
+			enabled: func() bool { return hasDB() && b.Mailer != nil }, private: true},
+		{name: "pr", args: "<branch>[:<base>] <title>", help: "Open a pull request on the forge with the session's summary", menu: "Open a pull request", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.prCommand,
+			enabled: func() bool { return hasDB() && b.Forge != nil }},
+		{name: "mr", args: "[title]", help: "Push the session's branch and open a merge request with its summary", menu: "Open a merge request", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.mrCommand,
+			enabled: func() bool { return hasDB() && b.Forge != nil && b.Client != nil }},
+		{name: "autocommit", args: "on|off", help: "Commit each run that changes files, with the prompt as message", menu: "Auto-commit runs", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.autocommitCommand,
+			enabled: hasDB},
+		{name: "issue", args: "<title>", help: "File an issue with a summary of the session's findings", menu: "File an issue", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.issueCommand,
+			enabled: func() bool { return hasDB() && b.Tracker != nil }},
+		{name: "think", args: "[on|off]", help: "Toggle thinking display", menu: "Toggle thinking display", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.thinkCommand,
+			enabled: hasDB},
+		{name: "streammode", args: "[live|chunked|final]", help: "Choose how replies stream: live edits, a message per paragraph, or only the final reply", menu: "Choose the stream mode", section: "Tools", match: bot.MatchTypeCommandStartOnly, handler: b.streamModeCommand,
+			enabled: hasDB},
+
+		{name: "status", help: "Bot status", menu: "Bot status", section: "Info", match: bot.MatchTypeExact, handler: b.statusCommand},
+		{name: "tz", args: "[zone|off]", help: "Show times in your time zone, e.g. Europe/Berlin", menu: "Set your time zone", section: "Info", match: bot.MatchTypeCommandStartOnly, handler: b.tzCommand,
+			enabled: hasDB},
+		{name: "digest", args: "[on [hour]|off|today]", help: "Get a morning summary of the previous day's sessions", menu: "Daily activity digest", section: "Info", match: bot.MatchTypeCommandStartOnly, handler: b.digestCommand,
+			enabled: hasDB},
+		{name: "leaderboard", args: "[week|month]", help: "Top users by prompts, tokens and cost this week or month", menu: "Usage leaderboard", section: "Info", match: bot.MatchTypeCommandStartOnly, handler: b.leaderboardCommand, role: b.leaderboardRole(),
+			enabled: func() bool { return hasDB() && b.leaderboardOn() }},
+		{name: "stats", help: "Usage statistics", menu: "Usage statistics", section: "Info", match: bot.MatchTypeExact, handler: b.statsCommand},
+		{name: "clear", help: "Clear current session", menu: "Clear current session", section: "Info", match: bot.MatchTypeExact, handler: b.clearCommand},
+
+		{name: "httpdebug", args: "[on|off]", help: "Toggle HTTP debug logging", menu: "Toggle HTTP debug logging", section: "Admin", match: bot.MatchTypePrefix, handler: b.httpDebugCommand, role: roleAdmin},
+		{name: "debug", help: "Runtime and store diagnostics", menu: "Runtime and store diagnostics", section: "Admin", match: bot.MatchTypeExact, handler: b.debugCommand, role: roleAdmin},
+		{name: "events", args: "[n] [session]", help: "Recent OpenCode events", menu: "Recent OpenCode events", section: "Admin", match: bot.MatchTypePrefix, handler: b.eventsCommand, role: roleAdmin, enabled: hasStream},
+		{name: "inspect", args: "<chat_id>", help: "Show another chat's session, history, usage and errors", menu: "Inspect a chat", section: "Admin", match: bot.MatchTypeCommandStartOnly, handler: b.inspectCommand, role: roleAdmin,
+			enabled: hasDB},
+		{name: "oc", args: "status|restart", help: "Show or restart the OpenCode server process", menu: "Manage the OpenCode server", section: "Admin", match: bot.MatchTypeCommandStartOnly, handler: b.ocCommand, role: roleAdmin,
+			enabled: func() bool { return b.Server != nil }},
+		{name: "selftest", help: "Check Telegram, OpenCode, SSE and DB", menu: "Check Telegram, OpenCode, SSE and DB", section: "Admin", match: bot.MatchTypeExact, handler: b.selfTestCommand, role: roleAdmin},
+		{name: "allow", args: "[id duration|off]", help: "Grant a user access for a limited time", menu: "Temporary access grants", section: "Admin", match: bot.MatchTypeCommandStartOnly, handler: b.allowCommand, role: roleAdmin,
+			enabled: hasDB},
+		{name: "template", args: "[set <name> key=value...|delete <name>]", help: "List, define or delete session templates", menu: "Session templates", section: "Admin", match: bot.MatchTypeCommandStartOnly, handler: b.templateCommand, role: roleAdmin,
+			enabled: hasDB},
+	}
+	cmds = append(cmds, b.pluginCommands()...)
+	for i, c := range cmds {
+		if b.commandDisabled(c.name) {
+			cmds[i] = b.disabledCommand(c)
+		}
+	}
+	return cmds
+}
+
+// commandDisabled reports whether COMMANDS_DISABLED turns off name.
+func (b *Bot) commandDisabled(name string) bool {
+	return b.Config != nil && b.Config.DisabledCommands[name]
+}
+
+// disabledCommand is c turned off by COMMANDS_DISABLED: left out of /help
+// and the menu, and still registered so the command says it is off
+// instead of reaching OpenCode as a prompt.
+func (b *Bot) disabledCommand(c command) command {
+	c.enabled = func() bool { return false }
+	c.handler = func(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+		if update.Message == nil {
+			return
+		}
+		chatID := update.Message.Chat.ID
+		if !b.requireAuth(chatID, tgBot, ctx) {
+			return
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "/" + c.name + " is disabled on this bot"})
+	}
+	return c
+}
+
+// warnUnknownDisabled logs the COMMANDS_DISABLED names that are neither a
+// command nor an alias, likely typos that leave a command on.
+func (b *Bot) warnUnknownDisabled() {
+	if b.Config == nil {
+		return
+	}
+	known := make(map[string]bool)
+	for _, c := range append(b.commands(), b.aliases...) {
+		known[c.name] = true
+	}
+	for name := range b.Config.DisabledCommands {
+		if !known[name] {
+			log.Printf("Warning: COMMANDS_DISABLED names unknown command /%s", name)
+		}
+	}
+}
+
+// resolveAliases turns the configured aliases into commands that run
+// their target's handler and inherit its role and availability. Aliases
+// for unknown commands, or that would shadow a real one, are skipped.
+func (b *Bot) resolveAliases(raw map[string]string) []command {
+	byName := make(map[string]command)
+	for _, c := range b.commands() {
+		byName[c.name] = c
+	}
+	names := make([]string, 0, len(raw))
+	for alias := range raw {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+
+	var out []command
+	for _, alias := range names {
+		target, ok := byName[raw[alias]]
+		switch {
+		case !ok:
+			log.Printf("Warning: alias /%s points to unknown command /%s, ignoring", alias, raw[alias])
+			continue
+		case byName[alias].name != "":
+			log.Printf("Warning: alias /%s would shadow the /%s command, ignoring", alias, alias)
+			continue
+		}
+		c := command{
+			name:    alias,
+			help:    target.help,
+			section: target.section,
+			// Match the whole command word so /d doesn't catch /diff.
+			match:   bot.MatchTypeCommandStartOnly,
+			handler: aliasHandler(alias, target.name, target.handler),
+			role:    target.role,
+			enabled: target.enabled,
+			alias:   target.name,
+		}
+		if b.commandDisabled(alias) {
+			c = b.disabledCommand(c)
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// aliasHandler rewrites "/alias args" to "/target args" so the target's
+// argument parsing sees the usual text.
+func aliasHandler(alias, target string, next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+		if update.Message != nil {
+			rest := strings.TrimPrefix(update.Message.Text, "/"+alias)
+			update.Message.Text = "/" + target + rest
+		}
+		next(ctx, tgBot, update)
+	}
+}
+
+// visibleCommands returns the enabled commands a user with role r may use.
+func (b *Bot) visibleCommands(r role) []command {
+	return filterCommands(b.commands(), r)
+}
+
+func filterCommands(cmds []command, r role) []command {
+	var out []command
+	for _, c := range cmds {
+		if c.role > r || (c.enabled != nil && !c.enabled()) {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// chatRole returns the role of chatID.
+func (b *Bot) chatRole(chatID int64) role {
+	if b.isAdmin(chatID) {
+		return roleAdmin
+	}
+	return roleUser
+}
+
+// helpText lists the commands available to r, grouped by section.
+func (b *Bot) helpText(r role) string {
+	bySection := make(map[string][]command)
+	for _, c := range b.visibleCommands(r) {
+		bySection[c.section] = append(bySection[c.section], c)
+	}
+	var sb strings.Builder
+	sb.WriteString("Available Commands\n")
+	for _, section := range helpSections {
+		cmds := bySection[section]
+		if len(cmds) == 0 {
+			continue
+		}
+		sb.WriteString("\n" + section + ":\n")
+		for _, c := range cmds {
+			usage := "/" + c.name
+			if c.args != "" {
+				usage += " " + c.args
+			}
+			sb.WriteString(fmt.Sprintf("%s - %s\n", usage, c.help))
+		}
+	}
+	if aliases := filterCommands(b.aliases, r); len(aliases) > 0 {
+		sb.WriteString("\nAliases:\n")
+		for _, c := range aliases {
+			sb.WriteString(fmt.Sprintf("/%s - /%s\n", c.name, c.alias))
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// menuCommands returns the command menu of a chat with role r, a group's
+// without the private commands.
+func (b *Bot) menuCommands(r role, group bool) []models.BotCommand {
+	var out []models.BotCommand
+	for _, c := range b.visibleCommands(r) {
+		if c.menu != "" && !(group && c.private) {
+			out = append(out, models.BotCommand{Command: c.name, Description: c.menu})
+		}
+	}
+	return out
+}
+
+// RegisterBotCommands registers the command menus with Telegram, by
+// command scope: user commands in private chats and, without the private
+// ones, in groups, and the admin commands too in each admin's chat (a
+// group's, for a group ID in ADMIN_USERS). Without ADMIN_USERS everyone
+// is an admin and sees everything. The default scope, for chats no other
+// scope covers, gets the group menu.
+func (b *Bot) RegisterBotCommands(httpClient *http.Client, token string) {
+	admins := b.access.Load().admins
+	defaultRole := roleUser
+	if len(admins) == 0 {
+		defaultRole = roleAdmin
+	}
+	groupCommands := b.menuCommands(defaultRole, true)
+	setMyCommands(httpClient, token, groupCommands, map[string]interface{}{"type": "default"})
+	setMyCommands(httpClient, token, b.menuCommands(defaultRole, false), map[string]interface{}{"type": "all_private_chats"})
+	setMyCommands(httpClient, token, groupCommands, map[string]interface{}{"type": "all_group_chats"})
+	if len(admins) == 0 {
+		return
+	}
+	privateAdmin, groupAdmin := b.menuCommands(roleAdmin, false), b.menuCommands(roleAdmin, true)
+	for id := range admins {
+		commands := privateAdmin
+		if id < 0 {
+			commands = groupAdmin
+		}
+		setMyCommands(httpClient, token, commands, map[string]interface{}{"type": "chat", "chat_id": id})
+	}
+}
+
+func setMyCommands(httpClient *http.Client, token string, commands []models.BotCommand, scope map[string]interface{}) {
+	params := struct {
+		Commands []models.BotCommand    `json:"commands"`
+		Scope    map[string]interface{} `json:"scope"`
+	}{
+		Commands: commands,
+		Scope:    scope,
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		log.Printf("Warning: Failed to marshal commands: %v", err)
+		return
+	}
+
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/setMyCommands", token)
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Warning: Failed to register bot commands: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 200 {
+		log.Printf("Registered %d bot commands (scope %v)", len(commands), scope["type"])
+	} else {
+		log.Printf("Warning: Failed to register bot commands: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,45 @@
+package telegram
+
+import (
+	"log"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+)
+
+// accessLists is the runtime-reloadable part of the configuration. It is
+// replaced as a whole so a reload never exposes a half-applied state.
+type accessLists struct {
+	allowed map[int64]bool
+	admins  map[int64]bool
+	agents  map[string]string // name -> description
+}
+
+// agents returns the current agent map. Callers must not modify it.
+func (b *Bot) agents() map[string]string {
+	return b.access.Load().agents
+}
+
+// Reload re-reads the allowlists and agents (see config.LoadAccess) and
+// swaps them in atomically, for the tenants' bots too. In-flight requests and streams are unaffected.
+// On error the previous lists stay in effect.
+func (b *Bot) Reload() error {
+	access, err := config.LoadAccess(b.Config.EnvFile)
+	if err != nil {
+		return err
+	}
+	agents := access.Agents
+	if len(agents) == 0 {
+		agents = defaultAgents()
+	}
+	lists := &accessLists{
+		allowed: access.AllowedUsers,
+		admins:  access.AdminUsers,
+		agents:  agents,
+	}
+	b.access.Store(lists)
+	for _, t := range b.tenants {
+		t.bot.access.Store(lists)
+	}
+	log.Printf("[Reload] Allowed users=%d, admins=%d, agents=%d", len(access.AllowedUsers), len(access.AdminUsers), len(agents))
+	return nil
+}
@@ -0,0 +1,107 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// repoKey is the chat setting naming the REPOS entry /repo chose; it
+// overrides the chat's CHAT_REPOS entry.
+const repoKey = "repo"
+
+// chatRepo returns the name and directory of the repository the chat's
+// sessions start in, or "" when it has none.
+func (b *Bot) chatRepo(chatID int64) (name, dir string) {
+	if b.Config == nil || len(b.Config.Repos) == 0 {
+		return "", ""
+	}
+	name = b.chatSetting(chatID, repoKey)
+	if _, ok := b.Config.Repos[name]; !ok {
+		name = b.Config.ChatRepos[chatID]
+	}
+	dir, ok := b.Config.Repos[name]
+	if !ok || !b.dirAllowed(dir) {
+		return "", ""
+	}
+	return name, dir
+}
+
+// sessionTitle is the title of a session the chat starts, prefixed with
+// its repository.
+func (b *Bot) sessionTitle(chatID int64) string {
+	title := fmt.Sprintf("Telegram Chat %d", chatID)
+	if name, _ := b.chatRepo(chatID); name != "" {
+		title = "[" + name + "] " + title
+	}
+	return title
+}
+
+// repoCommand shows or switches the repository the chat works on; "off"
+// goes back to the chat's CHAT_REPOS entry, if any. Switching starts a new
+// session on the next message.
+func (b *Bot) repoCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/repo"))
+	if arg == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.repoSummary(chatID)})
+		return
+	}
+	value := arg
+	if arg == "off" {
+		value = ""
+	} else if _, ok := b.Config.Repos[arg]; !ok {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Unknown repository: " + arg + "\n\n" + b.repoSummary(chatID)})
+		return
+	}
+	previous, _ := b.chatRepo(chatID)
+	// The repository replaces a /cd target.
+	for key, v := range map[string]string{repoKey: value, workDirKey: ""} {
+		if err := b.DB.SetChatSetting(chatID, key, v); err != nil {
+			log.Printf("[repoCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+	}
+	name, dir := b.chatRepo(chatID)
+	if name != previous {
+		if err := b.DB.DeleteSession(chatID); err != nil {
+			log.Printf("[repoCommand] Error deleting session: %v", err)
+		}
+	}
+	text := "No repository: new sessions start in the default directory."
+	if name != "" {
+		text = fmt.Sprintf("Working on %s (%s).", name, dir)
+	}
+	if name != previous {
+		text += " Your next message starts a new session there."
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+}
+
+// repoSummary describes the chat's repository and the ones it can pick.
+func (b *Bot) repoSummary(chatID int64) string {
+	var sb strings.Builder
+	if name, dir := b.chatRepo(chatID); name != "" {
+		fmt.Fprintf(&sb, "Current repository: %s (%s)\n\n", name, dir)
+	} else {
+		sb.WriteString("No repository selected.\n\n")
+	}
+	sb.WriteString("Repositories:\n")
+	for _, name := range b.Config.RepoNames() {
+		fmt.Fprintf(&sb, "%s — %s\n", name, b.Config.Repos[name])
+	}
+	sb.WriteString("\nUse /repo <name> to switch, /repo off for this chat's default.")
+	return sb.String()
+}
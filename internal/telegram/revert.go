@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Callback data of the "Revert to here" buttons /history puts under
+// assistant messages, followed by the message ID, and of the confirmation
+// they ask for.
+const (
+	revertPrefix   = "revert_"
+	revertOKPrefix = "revertok_"
+	revertCancel   = "revertno"
+)
+
+// handleRevertCallback asks to confirm a "Revert to here" button, and on
+// confirmation rolls the session back to just after that message: later
+// messages are hidden and their file changes undone, as with /restore.
+func (b *Bot) handleRevertCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, data string) {
+	answer := func(text string) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: text, ShowAlert: text != ""})
+	}
+	reply := func(text string) {
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{ChatID: chatID, MessageID: callback.Message.Message.ID, Text: text})
+	}
+	if data == revertCancel {
+		answer("")
+		reply("Revert cancelled.")
+		return
+	}
+
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" || b.Client == nil {
+		answer("No active session")
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		answer(refusal)
+		return
+	}
+	sess, err := b.Client.GetOCSession(ctx, sessionID)
+	if err != nil {
+		log.Printf("[handleRevertCallback] Error getting session: %v", err)
+		answer("Failed to get session")
+		return
+	}
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		log.Printf("[handleRevertCallback] Error getting messages: %v", err)
+		answer("Failed to get messages")
+		return
+	}
+
+	confirmed := strings.HasPrefix(data, revertOKPrefix)
+	messageID := strings.TrimPrefix(strings.TrimPrefix(data, revertOKPrefix), revertPrefix)
+	index := -1
+	for i, m := range messages {
+		if m.ID == messageID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		answer("That message is no longer in this session's history")
+		return
+	}
+	answer("")
+
+	if !confirmed {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text: fmt.Sprintf("Revert the session to message #%d?\n\n%s\n\nThe %d message(s) after it are removed and their file changes undone.",
+				index+1, tgtext.Clip(messages[index].Content, 200), len(messages)-index-1),
+			ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+				{Text: "↩️ Revert", CallbackData: revertOKPrefix + messageID},
+				{Text: "Cancel", CallbackData: revertCancel},
+			}}},
+		})
+		return
+	}
+
+	if err := b.revertTo(ctx, sess, messages, index+1); err != nil {
+		log.Printf("[handleRevertCallback] Error reverting %s to %s: %v", sessionID, messageID, err)
+		reply("Failed to revert: " + err.Error())
+		return
+	}
+	log.Printf("[handleRevertCallback] Chat %d reverted %s to message %s", chatID, sessionID, messageID)
+	reply(fmt.Sprintf("Reverted the session to message #%d: later messages and their file changes were rolled back.", index+1))
+}
@@ -0,0 +1,223 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// runPollInterval is how often a /run's status message shows the latest
+// output while the command runs.
+const runPollInterval = 3 * time.Second
+
+// runCommand runs one of the project commands in RUN_COMMANDS, e.g. "/run
+// build", in the current session's directory. Its output is shown while it
+// runs and sent when it finishes, as a file when it doesn't fit a message.
+func (b *Bot) runCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	target := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/run"))
+	command, ok := b.Config.RunCommands[target]
+	if !ok {
+		text := b.runTargets()
+		if target != "" {
+			text = "Unknown target: " + target + "\n\n" + text
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(text)})
+		return
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+	if setting, _ := b.toolDenial(chatID, "bash"); setting != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: setting + " denies the shell, so /run is not available"})
+		return
+	}
+	if b.Stream != nil {
+		if _, streaming, _ := b.Stream.CurrentText(chatID); streaming {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Wait for the reply to finish, or /stop it, before running a command"})
+			return
+		}
+	}
+	if running, busy := b.commandRuns.LoadOrStore(chatID, target); busy {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: fmt.Sprintf("/run %s is still running", running)})
+		return
+	}
+
+	header := fmt.Sprintf("▶️ %s: %s", target, command)
+	status, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(header + "\n\nRunning...")})
+	if err != nil {
+		log.Printf("[runCommand] Error: %v", err)
+		b.commandRuns.Delete(chatID)
+		return
+	}
+	// Commands can take minutes; don't hold up the chat's other updates.
+	go func() {
+		defer errreport.Recover(errreport.Fields{"goroutine": "run", "chat_id": fmt.Sprint(chatID), "target": target})
+		b.runTarget(context.Background(), tgBot, chatID, sessionID, header, command, status.ID)
+	}()
+}
+
+// runTargets lists the RUN_COMMANDS targets.
+func (b *Bot) runTargets() string {
+	targets := b.Config.RunTargets()
+	if len(targets) == 0 {
+		return "No project commands configured. Set RUN_COMMANDS, e.g. build=go build ./...;lint=go vet ./..."
+	}
+	var sb strings.Builder
+	sb.WriteString("Project commands:\n")
+	for _, target := range targets {
+		fmt.Fprintf(&sb, "• %s: %s\n", target, b.Config.RunCommands[target])
+	}
+	sb.WriteString("\nRun one with /run <target>")
+	return sb.String()
+}
+
+// runTarget runs command through the session's shell, showing its output
+// so far in the status message until it finishes.
+func (b *Bot) runTarget(ctx context.Context, tgBot *bot.Bot, chatID int64, sessionID, header, command string, statusID int) {
+	defer b.commandRuns.Delete(chatID)
+	edit := func(text string) {
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{ChatID: chatID, MessageID: statusID, Text: text})
+	}
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer errreport.Recover(errreport.Fields{"goroutine": "run-progress", "chat_id": fmt.Sprint(chatID), "session_id": sessionID})
+		defer close(stopped)
+		ticker := time.NewTicker(runPollInterval)
+		defer ticker.Stop()
+		last := ""
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if progress := b.runProgress(ctx, sessionID, command); progress != "" && progress != last {
+				last = progress
+				edit(b.truncate(header + "\n\n" + b.clipOutput(progress, tgtext.Len(header)+len("\n\n\n\nRunning...")) + "\n\nRunning..."))
+			}
+		}
+	}()
+
+	agent, _, _ := b.withDefaults(b.currentAgent(chatID), "", "")
+	if agent == "" {
+		agent = "build"
+	}
+	start := time.Now()
+	out, err := b.Client.Shell(ctx, sessionID, agent, command)
+	close(done)
+	<-stopped
+	took := time.Since(start).Round(time.Second)
+	if err != nil {
+		log.Printf("[runTarget] Chat %d: %v", chatID, err)
+		edit(b.truncate(fmt.Sprintf("%s\n\nFailed after %s: %v", header, took, err)))
+		return
+	}
+	log.Printf("[runTarget] Chat %d ran %q in %s", chatID, command, took)
+
+	footer := fmt.Sprintf("Finished in %s", took)
+	out = strings.TrimRight(out, "\n")
+	if out == "" {
+		edit(b.truncate(header + "\n\n(no output)\n\n" + footer))
+		return
+	}
+	text := header + "\n\n" + out + "\n\n" + footer
+	if tgtext.Len(text) <= b.Config.MaxMessageLen {
+		edit(text)
+		return
+	}
+	footer += ", full output attached"
+	edit(b.truncate(header + "\n\n" + b.clipOutput(out, tgtext.Len(header+footer)+len("\n\n\n\n")) + "\n\n" + footer))
+	if _, err := tgBot.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:          chatID,
+		Document:        &models.InputFileUpload{Filename: "run-output.txt", Data: strings.NewReader(out)},
+		ReplyParameters: &models.ReplyParameters{MessageID: statusID, AllowSendingWithoutReply: true},
+	}); err != nil {
+		log.Printf("[runTarget] Error sending output: %v", err)
+	}
+}
+
+// runProgress is what the session's running shell call of command printed
+// so far, or "".
+func (b *Bot) runProgress(ctx context.Context, sessionID, command string) string {
+	calls, latest, err := b.sessionTools(ctx, sessionID)
+	if err != nil || latest == 0 {
+		return ""
+	}
+	for i := len(calls) - 1; i >= len(calls)-latest; i-- {
+		if c := calls[i]; c.Status == "running" && toolCommandLine(c) == command {
+			return strings.TrimRight(c.Progress, "\n")
+		}
+	}
+	return ""
+}
+
+// clipOutput fits out into a message alongside reserved UTF-16 units of
+// other text. Errors tend to be at the end, so it keeps the first few
+// lines and as many of the last ones as fit.
+func (b *Bot) clipOutput(out string, reserved int) string {
+	limit := b.Config.MaxMessageLen - reserved
+	if tgtext.Len(out) <= limit {
+		return out
+	}
+	lines := strings.Split(out, "\n")
+	headLimit := limit / 5
+	var head, tail []string
+	used := 0
+	for _, line := range lines {
+		n := tgtext.Len(line) + 1
+		if used+n > headLimit {
+			break
+		}
+		head = append(head, line)
+		used += n
+	}
+	for i := len(lines) - 1; i >= len(head); i-- {
+		n := tgtext.Len(lines[i]) + 1
+		if used+n > limit-40 {
+			break
+		}
+		tail = append([]string{lines[i]}, tail...)
+		used += n
+	}
+	omitted := len(lines) - len(head) - len(tail)
+	if len(tail) == 0 {
+		// A huge last line: show its end.
+		last := []rune(lines[len(lines)-1])
+		keep := limit - used - 40
+		if keep > len(last) {
+			keep = len(last)
+		}
+		if keep < 0 {
+			keep = 0
+		}
+		tail = []string{"…" + string(last[len(last)-keep:])}
+		omitted--
+	}
+	gap := "\n"
+	if omitted > 0 {
+		gap = fmt.Sprintf("\n… %d lines omitted …\n", omitted)
+	}
+	return strings.Join(head, "\n") + gap + strings.Join(tail, "\n")
+}
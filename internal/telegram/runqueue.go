@@ -0,0 +1,372 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+)
+
+var runsQueued = metrics.NewGauge("openkh_runs_queued",
+	"Prompts waiting for a run slot under MAX_CONCURRENT_RUNS.")
+
+const (
+	// busyBackoff is how long prompts wait after OpenCode turned one away
+	// as busy without a Retry-After; maxBusyBackoff caps the header.
+	busyBackoff    = 15 * time.Second
+	maxBusyBackoff = 5 * time.Minute
+)
+
+// queuedPrompt is a prompt waiting for a run slot.
+type queuedPrompt struct {
+	tgBot    *bot.Bot
+	chatID   int64
+	userID   int64
+	promptID int
+	text     string
+	notice   int    // message showing its place in the queue
+	shown    string // text the notice shows
+	started  bool   // taken off the queue
+}
+
+// runQueue holds prompts over MAX_CONCURRENT_RUNS until runs finish.
+// Users take turns, so one user's burst doesn't hold up everyone else.
+type runQueue struct {
+	mu       sync.Mutex
+	starting int                       // prompts admitted but not streaming yet
+	users    []int64                   // users with waiting prompts, whose turn is next first
+	waiting  map[int64][]*queuedPrompt // user ID -> their prompts, oldest first
+	busy     time.Time                 // OpenCode turned a prompt away as busy; none start before this
+	avgRun   time.Duration             // moving average of how long runs take, for wait estimates
+}
+
+// admit reserves a run slot for a prompt in chatID if one is free and no
+// one is waiting for it. Otherwise p, if not nil, joins the queue. A chat
+// streaming a reply already goes ahead: its new prompt takes over the
+// chat's stream. Callers that are admitted call promptStarted once the
+// prompt streams or fails.
+func (b *Bot) admit(chatID int64, p *queuedPrompt) bool {
+	q := &b.runs
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.free(b, chatID) {
+		q.starting++
+		return true
+	}
+	if p != nil {
+		if q.waiting == nil {
+			q.waiting = make(map[int64][]*queuedPrompt)
+		}
+		if len(q.waiting[p.userID]) == 0 {
+			q.users = append(q.users, p.userID)
+		}
+		q.waiting[p.userID] = append(q.waiting[p.userID], p)
+		runsQueued.Add(1)
+	}
+	return false
+}
+
+// free reports whether a prompt in chatID may start now. Callers hold q.mu.
+func (q *runQueue) free(b *Bot, chatID int64) bool {
+	if time.Now().Before(q.busy) {
+		return false
+	}
+	if b.Config == nil || b.Config.MaxConcurrentRuns <= 0 || b.Stream == nil {
+		return true
+	}
+	running := b.Stream.RunningChats()
+	for _, c := range running {
+		if c == chatID {
+			return true
+		}
+	}
+	return len(q.users) == 0 && len(running)+q.starting < b.Config.MaxConcurrentRuns
+}
+
+// order returns the waiting prompts in the order they will start: a
+// prompt of each user in turn.
+func (q *runQueue) order() []*queuedPrompt {
+	var order []*queuedPrompt
+	for round := 0; ; round++ {
+		n := len(order)
+		for _, u := range q.users {
+			if round < len(q.waiting[u]) {
+				order = append(order, q.waiting[u][round])
+			}
+		}
+		if len(order) == n {
+			return order
+		}
+	}
+}
+
+// promptStarted releases the slot reserved by admit once the prompt is
+// streaming, when the stream counts it, or failed to start.
+func (b *Bot) promptStarted() {
+	b.runs.mu.Lock()
+	b.runs.starting--
+	b.runs.mu.Unlock()
+	b.startQueued()
+}
+
+// queuePrompt runs the prompt if a slot is free, and otherwise queues it
+// and tells the chat its place in line.
+func (b *Bot) queuePrompt(ctx context.Context, tgBot *bot.Bot, chatID, userID int64, promptID int, text string) {
+	p := &queuedPrompt{tgBot: tgBot, chatID: chatID, userID: userID, promptID: promptID, text: text}
+	if b.admit(chatID, p) {
+		defer b.promptStarted()
+		b.startPrompt(ctx, tgBot, chatID, userID, promptID, text)
+		return
+	}
+
+	b.runs.mu.Lock()
+	place := b.runs.place(p)
+	notice := queueText(place, b.runs.wait(b, place))
+	b.runs.mu.Unlock()
+	log.Printf("[runQueue] Chat %d queued a prompt at place %d", chatID, place)
+	msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: notice})
+	if err != nil {
+		log.Printf("[runQueue] Chat %d: %v", chatID, err)
+		return
+	}
+	b.track(chatID, msg)
+	b.runs.mu.Lock()
+	started := p.started
+	if !started {
+		p.notice, p.shown = msg.ID, notice
+	}
+	b.runs.mu.Unlock()
+	if started {
+		tgBot.DeleteMessage(ctx, &bot.DeleteMessageParams{ChatID: chatID, MessageID: msg.ID})
+	}
+}
+
+// place returns p's place in line, from 1. Callers hold q.mu.
+func (q *runQueue) place(p *queuedPrompt) int {
+	for i, o := range q.order() {
+		if o == p {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// startQueued starts waiting prompts while slots are free, each on its
+// chat's dispatcher queue, and moves the others' notices up the line.
+func (b *Bot) startQueued() {
+	q := &b.runs
+	var next []*queuedPrompt
+	q.mu.Lock()
+	for len(q.users) > 0 && q.freeForQueue(b) {
+		u := q.users[0]
+		p := q.waiting[u][0]
+		q.users = q.users[1:]
+		if rest := q.waiting[u][1:]; len(rest) > 0 {
+			q.waiting[u] = rest
+			q.users = append(q.users, u)
+		} else {
+			delete(q.waiting, u)
+		}
+		p.started = true
+		q.starting++
+		runsQueued.Add(-1)
+		next = append(next, p)
+	}
+	moved := q.moved(b)
+	q.mu.Unlock()
+
+	ctx := context.Background()
+	for _, p := range next {
+		if p.notice != 0 {
+			p.tgBot.DeleteMessage(ctx, &bot.DeleteMessageParams{ChatID: p.chatID, MessageID: p.notice})
+		}
+		log.Printf("[runQueue] Starting the queued prompt of chat %d", p.chatID)
+		p := p
+		if !b.updates.submit(p.chatID, func() {
+			defer b.promptStarted()
+			b.startPrompt(ctx, p.tgBot, p.chatID, p.userID, p.promptID, p.text)
+		}) {
+			log.Printf("[runQueue] Queue full for chat %d, dropping its queued prompt", p.chatID)
+			b.promptStarted()
+		}
+	}
+	b.showPlaces(ctx, moved)
+}
+
+// freeForQueue reports whether the next waiting prompt may start. Callers
+// hold q.mu.
+func (q *runQueue) freeForQueue(b *Bot) bool {
+	if time.Now().Before(q.busy) {
+		return false
+	}
+	if b.Config == nil || b.Config.MaxConcurrentRuns <= 0 || b.Stream == nil {
+		return true
+	}
+	return len(b.Stream.RunningChats())+q.starting < b.Config.MaxConcurrentRuns
+}
+
+// moved returns the waiting prompts whose notice shows an old place or
+// wait, with the new text recorded. Callers hold q.mu.
+func (q *runQueue) moved(b *Bot) map[*queuedPrompt]string {
+	moved := make(map[*queuedPrompt]string)
+	for i, p := range q.order() {
+		if text := queueText(i+1, q.wait(b, i+1)); p.notice != 0 && p.shown != text {
+			p.shown = text
+			moved[p] = text
+		}
+	}
+	return moved
+}
+
+func (b *Bot) showPlaces(ctx context.Context, moved map[*queuedPrompt]string) {
+	for p, text := range moved {
+		p.tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    p.chatID,
+			MessageID: p.notice,
+			Text:      text,
+		})
+	}
+}
+
+// wait estimates how long the prompt at place waits to start: until
+// OpenCode takes prompts again after turning one away as busy, plus under
+// MAX_CONCURRENT_RUNS an average run for each prompt up to it, shared
+// among the slots. 0 means there is no estimate. Callers hold q.mu.
+func (q *runQueue) wait(b *Bot, place int) time.Duration {
+	wait := max(time.Until(q.busy), 0)
+	if b.Config != nil && b.Config.MaxConcurrentRuns > 0 && q.avgRun > 0 {
+		wait += time.Duration(place) * q.avgRun / time.Duration(b.Config.MaxConcurrentRuns)
+	}
+	return wait
+}
+
+// runEnded feeds how long chatID's run took into the wait estimates.
+func (b *Bot) runEnded(chatID int64) {
+	v, ok := b.runStarts.Load(chatID)
+	if !ok {
+		return
+	}
+	took := time.Since(v.(time.Time))
+	b.runs.mu.Lock()
+	defer b.runs.mu.Unlock()
+	if b.runs.avgRun == 0 {
+		b.runs.avgRun = took
+	} else {
+		b.runs.avgRun = (4*b.runs.avgRun + took) / 5
+	}
+}
+
+// requeueBusy puts a prompt OpenCode turned away as busy back at the head
+// of the queue, and holds every prompt until the server's Retry-After, or
+// busyBackoff, has passed. notice, if not 0, is the message to turn into
+// the prompt's place in line.
+func (b *Bot) requeueBusy(ctx context.Context, tgBot *bot.Bot, chatID, userID int64, promptID int, text string, notice int, busy *opencode.BusyError) {
+	delay := busy.RetryAfter
+	if delay <= 0 {
+		delay = busyBackoff
+	}
+	delay = min(delay, maxBusyBackoff)
+	p := &queuedPrompt{tgBot: tgBot, chatID: chatID, userID: userID, promptID: promptID, text: text, notice: notice}
+
+	q := &b.runs
+	q.mu.Lock()
+	if until := time.Now().Add(delay); until.After(q.busy) {
+		q.busy = until
+	}
+	if q.waiting == nil {
+		q.waiting = make(map[int64][]*queuedPrompt)
+	}
+	users := []int64{userID}
+	for _, u := range q.users {
+		if u != userID {
+			users = append(users, u)
+		}
+	}
+	q.users = users
+	q.waiting[userID] = append([]*queuedPrompt{p}, q.waiting[userID]...)
+	runsQueued.Add(1)
+	place := q.place(p)
+	shown := queueText(place, q.wait(b, place))
+	p.shown = shown
+	moved := q.moved(b)
+	q.mu.Unlock()
+	log.Printf("[runQueue] OpenCode is busy (%v), chat %d's prompt waits %s", busy, chatID, delay)
+
+	if notice != 0 {
+		tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{ChatID: chatID, MessageID: notice, Text: shown})
+	} else if msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: shown}); err != nil {
+		log.Printf("[runQueue] Chat %d: %v", chatID, err)
+	} else {
+		b.track(chatID, msg)
+		q.mu.Lock()
+		started := p.started
+		if !started {
+			p.notice = msg.ID
+		}
+		q.mu.Unlock()
+		if started {
+			tgBot.DeleteMessage(ctx, &bot.DeleteMessageParams{ChatID: chatID, MessageID: msg.ID})
+		}
+	}
+	b.showPlaces(ctx, moved)
+	time.AfterFunc(delay, b.startQueued)
+}
+
+// dropQueued takes the chat's waiting prompts off the queue and returns
+// how many there were.
+func (b *Bot) dropQueued(ctx context.Context, chatID int64) int {
+	q := &b.runs
+	var dropped []*queuedPrompt
+	q.mu.Lock()
+	users := q.users[:0]
+	for _, u := range q.users {
+		var kept []*queuedPrompt
+		for _, p := range q.waiting[u] {
+			if p.chatID == chatID {
+				dropped = append(dropped, p)
+			} else {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) > 0 {
+			q.waiting[u] = kept
+			users = append(users, u)
+		} else {
+			delete(q.waiting, u)
+		}
+	}
+	q.users = users
+	for _, p := range dropped {
+		p.started = true
+	}
+	runsQueued.Add(-float64(len(dropped)))
+	moved := q.moved(b)
+	q.mu.Unlock()
+
+	for _, p := range dropped {
+		if p.notice != 0 {
+			p.tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{ChatID: chatID, MessageID: p.notice, Text: "Cancelled"})
+		}
+	}
+	b.showPlaces(ctx, moved)
+	return len(dropped)
+}
+
+// queueText is the notice of a prompt at place in line, with its estimated
+// wait if there is one.
+func queueText(place int, wait time.Duration) string {
+	if wait <= 0 {
+		return fmt.Sprintf("⏳ The server is busy. Your prompt is number %d in line and starts when a run finishes. /stop cancels it.", place)
+	}
+	// Rounded, so the notice isn't edited for every few seconds passing.
+	est := fmt.Sprintf("%ds", int(max(wait.Round(10*time.Second), 10*time.Second).Seconds()))
+	if wait >= time.Minute {
+		est = fmt.Sprintf("%d min", int(wait.Round(time.Minute).Minutes()))
+	}
+	return fmt.Sprintf("⏳ The server is busy, estimated wait %s. Your prompt is number %d in line. /stop cancels it.", est, place)
+}
@@ -0,0 +1,182 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// selfTestChatID is a chat ID no Telegram chat can have, used for the
+// store round-trip so real rows are never touched.
+const selfTestChatID = -1
+
+// sseConnectWait is how long the boot self-test waits for the SSE stream
+// to come up before reporting it as down.
+const sseConnectWait = 10 * time.Second
+
+type checkResult struct {
+	name string
+	err  error
+	took time.Duration
+}
+
+// runSelfTest exercises every dependency. The Telegram check sends and
+// edits a message in chatID; it is skipped when chatID is 0.
+func (b *Bot) runSelfTest(ctx context.Context, tgBot *bot.Bot, chatID int64) []checkResult {
+	var results []checkResult
+	check := func(name string, fn func(context.Context) error) {
+		start := time.Now()
+		cctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		defer cancel()
+		err := fn(cctx)
+		results = append(results, checkResult{name: name, err: err, took: time.Since(start)})
+	}
+
+	if chatID != 0 {
+		check("Telegram send/edit", func(ctx context.Context) error {
+			msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Self-test running..."})
+			if err != nil {
+				return fmt.Errorf("send: %w", err)
+			}
+			if _, err := tgBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+				ChatID:    chatID,
+				MessageID: msg.ID,
+				Text:      "Self-test: Telegram send/edit OK",
+			}); err != nil {
+				return fmt.Errorf("edit: %w", err)
+			}
+			return nil
+		})
+	}
+
+	check("OpenCode health", func(ctx context.Context) error {
+		if b.Client == nil {
+			return errors.New("client not initialized")
+		}
+		return b.Client.Health(ctx)
+	})
+
+	check("OpenCode session create/delete", func(ctx context.Context) error {
+		if b.Client == nil {
+			return errors.New("client not initialized")
+		}
+		sess, err := b.Client.CreateOCSession(ctx, "openkh self-test", b.defaultDir())
+		if err != nil {
+			return fmt.Errorf("create: %w", err)
+		}
+		if err := b.Client.DeleteOCSession(ctx, sess.ID); err != nil {
+			return fmt.Errorf("delete %s: %w", shortID(sess.ID), err)
+		}
+		return nil
+	})
+
+	check("SSE stream", func(ctx context.Context) error {
+		if b.Stream == nil {
+			return errors.New("stream manager not initialized")
+		}
+		if !b.Stream.Connected() {
+			return errors.New("not connected")
+		}
+		return nil
+	})
+
+	check("Database read/write", func(ctx context.Context) error {
+		if b.DB == nil {
+			return errors.New("store not initialized")
+		}
+		now := time.Now()
+		if err := b.DB.SetSession(store.Session{ChatID: selfTestChatID, SessionID: "selftest", CreatedAt: now, LastUsed: now}); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		defer b.DB.DeleteSession(selfTestChatID)
+		got, err := b.DB.GetSession(selfTestChatID)
+		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		if got.SessionID != "selftest" {
+			return fmt.Errorf("read back %q, want %q", got.SessionID, "selftest")
+		}
+		return nil
+	})
+
+	return results
+}
+
+func formatSelfTest(title string, results []checkResult) string {
+	var sb strings.Builder
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		sb.WriteString(fmt.Sprintf("%s: all %d checks passed\n\n", title, len(results)))
+	} else {
+		sb.WriteString(fmt.Sprintf("%s: %d of %d checks FAILED\n\n", title, failed, len(results)))
+	}
+	for _, r := range results {
+		if r.err != nil {
+			sb.WriteString(fmt.Sprintf("FAIL %s (%s): %v\n", r.name, r.took.Round(time.Millisecond), r.err))
+		} else {
+			sb.WriteString(fmt.Sprintf("PASS %s (%s)\n", r.name, r.took.Round(time.Millisecond)))
+		}
+	}
+	return sb.String()
+}
+
+func (b *Bot) selfTestCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+
+	report := formatSelfTest("Self-test", b.runSelfTest(ctx, tgBot, chatID))
+	log.Printf("[selfTestCommand] %s", report)
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: report})
+}
+
+// BootSelfTest runs the self-test once after startup, logs the report
+// and sends it to the configured admins. The Telegram check runs in the
+// first admin's chat; without admins it is skipped.
+func (b *Bot) BootSelfTest(ctx context.Context, tgBot *bot.Bot) {
+	if b.Stream != nil {
+		deadline := time.Now().Add(sseConnectWait)
+		for !b.Stream.Connected() && time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(250 * time.Millisecond):
+			}
+		}
+	}
+
+	var admins []int64
+	for id := range b.access.Load().admins {
+		admins = append(admins, id)
+	}
+	var testChat int64
+	if len(admins) > 0 {
+		testChat = admins[0]
+	}
+
+	report := formatSelfTest("Boot report", b.runSelfTest(ctx, tgBot, testChat))
+	log.Printf("[BootSelfTest] %s", report)
+	for _, id := range admins {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: id, Text: report})
+	}
+}
@@ -0,0 +1,266 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/metrics"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+)
+
+var (
+	sendQueueDepth = metrics.NewGauge("openkh_send_queue_depth",
+		"Outbound Telegram operations waiting in the send queue.", "priority")
+	sendQueueWait = metrics.NewHistogram("openkh_send_queue_wait_seconds",
+		"Time operations spent in the send queue before being sent.", nil, "priority")
+	sendQueueCoalesced = metrics.NewCounter("openkh_send_queue_coalesced_total",
+		"Intermediate edits replaced by a newer edit before being sent.")
+)
+
+// sendJob is one queued Telegram operation.
+type sendJob struct {
+	chatID    int64
+	messageID int // 0 for a new message
+	text      string
+	final     bool // the completion edit, for a sender implementing opencode.FinalEditor
+	queued    time.Time
+	done      chan sendResult // nil for fire-and-forget intermediate edits
+}
+
+type sendResult struct {
+	messageID int
+	err       error
+}
+
+// SendQueue is the single outbound path for streamed output. It wraps a
+// MessageSender and:
+//   - enforces a global rate ceiling across all chats,
+//   - sends new messages and final edits before intermediate ones,
+//   - round-robins intermediate edits across chats, keeping only the
+//     latest pending edit per chat, so one chatty session can't starve
+//     the others or block the SSE reader.
+//
+// SendText and EditFinalText wait for the result; EditText returns as soon
+// as the edit is queued, so how it fared goes to the feedback, if set.
+type SendQueue struct {
+	next     opencode.MessageSender
+	interval time.Duration
+	feedback opencode.EditFeedback // guarded by mu
+
+	mu      sync.Mutex
+	wake    chan struct{}
+	high    []*sendJob
+	pending map[int64]*sendJob // latest intermediate edit per chat
+	order   []int64            // chats with a pending intermediate edit, in turn order
+	closed  bool
+}
+
+var errSendQueueClosed = errors.New("send queue stopped")
+
+// NewSendQueue creates a queue sending at most ratePerSecond operations.
+func NewSendQueue(next opencode.MessageSender, ratePerSecond int) *SendQueue {
+	if ratePerSecond <= 0 {
+		ratePerSecond = 25
+	}
+	return &SendQueue{
+		next:     next,
+		interval: time.Second / time.Duration(ratePerSecond),
+		wake:     make(chan struct{}, 1),
+		pending:  make(map[int64]*sendJob),
+	}
+}
+
+// SetFeedback tells f when intermediate edits queue up or are rate
+// limited, so the stream can edit those chats less often.
+func (q *SendQueue) SetFeedback(f opencode.EditFeedback) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.feedback = f
+}
+
+// Run processes the queue until ctx is cancelled.
+func (q *SendQueue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		job, high := q.pop()
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				q.close()
+				return
+			case <-q.wake:
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if job.done != nil {
+				job.done <- sendResult{err: errSendQueueClosed}
+			}
+			q.close()
+			return
+		case <-ticker.C:
+		}
+		q.send(job, high)
+	}
+}
+
+// SendText queues a new message with high priority and waits for it.
+func (q *SendQueue) SendText(chatID int64, text string) (int, error) {
+	res := q.enqueueHigh(&sendJob{chatID: chatID, text: text})
+	return res.messageID, res.err
+}
+
+// EditFinalText queues a completion edit with high priority and waits for
+// it. Any intermediate edit still pending for the chat is superseded.
+func (q *SendQueue) EditFinalText(chatID int64, messageID int, text string) error {
+	q.mu.Lock()
+	if _, ok := q.pending[chatID]; ok {
+		q.dropPendingLocked(chatID)
+		sendQueueCoalesced.Inc()
+	}
+	q.mu.Unlock()
+	return q.enqueueHigh(&sendJob{chatID: chatID, messageID: messageID, text: text, final: true}).err
+}
+
+// FormatsFinal passes on whether the sender formats final edits.
+func (q *SendQueue) FormatsFinal(chatID int64, text string) bool {
+	ff, ok := q.next.(opencode.FinalFormatter)
+	return ok && ff.FormatsFinal(chatID, text)
+}
+
+// EditText queues an intermediate edit. If the chat already has one
+// waiting it is replaced, since only the latest text matters.
+func (q *SendQueue) EditText(chatID int64, messageID int, text string) error {
+	q.mu.Lock()
+	if job, ok := q.pending[chatID]; ok && job.messageID == messageID {
+		job.text = text
+		feedback := q.feedback
+		q.mu.Unlock()
+		sendQueueCoalesced.Inc()
+		if feedback != nil {
+			feedback.EditsQueuedUp(chatID)
+		}
+		return nil
+	}
+	if _, ok := q.pending[chatID]; ok {
+		q.dropPendingLocked(chatID)
+	}
+	q.pending[chatID] = &sendJob{chatID: chatID, messageID: messageID, text: text, queued: time.Now()}
+	q.order = append(q.order, chatID)
+	sendQueueDepth.Add(1, "intermediate")
+	q.mu.Unlock()
+	q.signal()
+	return nil
+}
+
+func (q *SendQueue) enqueueHigh(job *sendJob) sendResult {
+	job.queued = time.Now()
+	job.done = make(chan sendResult, 1)
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return sendResult{err: errSendQueueClosed}
+	}
+	q.high = append(q.high, job)
+	sendQueueDepth.Add(1, "high")
+	q.mu.Unlock()
+	q.signal()
+	return <-job.done
+}
+
+// close fails the waiting high-priority jobs and rejects new ones.
+func (q *SendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	for _, job := range q.high {
+		job.done <- sendResult{err: errSendQueueClosed}
+	}
+	sendQueueDepth.Add(-float64(len(q.high)), "high")
+	q.high = nil
+}
+
+func (q *SendQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next job: high priority first, then the next chat's
+// intermediate edit in round-robin order.
+func (q *SendQueue) pop() (*sendJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.high) > 0 {
+		job := q.high[0]
+		q.high = q.high[1:]
+		sendQueueDepth.Add(-1, "high")
+		return job, true
+	}
+	for len(q.order) > 0 {
+		chatID := q.order[0]
+		q.order = q.order[1:]
+		if job, ok := q.pending[chatID]; ok {
+			delete(q.pending, chatID)
+			sendQueueDepth.Add(-1, "intermediate")
+			return job, false
+		}
+	}
+	return nil, false
+}
+
+func (q *SendQueue) dropPendingLocked(chatID int64) {
+	delete(q.pending, chatID)
+	for i, id := range q.order {
+		if id == chatID {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+	sendQueueDepth.Add(-1, "intermediate")
+}
+
+func (q *SendQueue) send(job *sendJob, high bool) {
+	priority := "intermediate"
+	if high {
+		priority = "high"
+	}
+	sendQueueWait.Observe(time.Since(job.queued).Seconds(), priority)
+
+	var res sendResult
+	fe, finalEditor := q.next.(opencode.FinalEditor)
+	switch {
+	case job.messageID == 0:
+		res.messageID, res.err = q.next.SendText(job.chatID, job.text)
+	case job.final && finalEditor:
+		res.messageID = job.messageID
+		res.err = fe.EditFinalText(job.chatID, job.messageID, job.text)
+	default:
+		res.messageID = job.messageID
+		res.err = q.next.EditText(job.chatID, job.messageID, job.text)
+	}
+	if job.done != nil {
+		job.done <- res
+		return
+	}
+	if res.err != nil && !strings.Contains(res.err.Error(), "message is not modified") {
+		log.Printf("[SendQueue] Edit for chat %d failed: %v", job.chatID, res.err)
+	}
+	var tooMany *bot.TooManyRequestsError
+	if errors.As(res.err, &tooMany) {
+		q.mu.Lock()
+		feedback := q.feedback
+		q.mu.Unlock()
+		if feedback != nil {
+			feedback.EditRateLimited(job.chatID, time.Duration(tooMany.RetryAfter)*time.Second)
+		}
+	}
+}
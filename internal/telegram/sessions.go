@@ -2,12 +2,14 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
 	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
@@ -26,18 +28,11 @@ func (b *Bot) sessionsCommand(ctx context.Context, tgBot *bot.Bot, update *model
 	}
 	log.Printf("[sessionsCommand] auth passed, Client=%v", b.Client)
 
+	showAll := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/sessions")) == "all"
+
 	log.Printf("[sessionsCommand] Calling ListOCSessions...")
 	sessions, err := b.Client.ListOCSessions(ctx)
 	log.Printf("[sessionsCommand] ListOCSessions returned, err=%v, sessions=%d", err, len(sessions))
-	
-	if len(sessions) == 0 {
-		log.Printf("[sessionsCommand] No sessions, sending message")
-		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No sessions found"})
-		return
-	}
-
-	totalSessions := len(sessions)
-	log.Printf("[sessionsCommand] Building response for %d sessions", totalSessions)
 
 	var currentSessionID string
 	if b.DB != nil {
@@ -48,41 +43,87 @@ func (b *Bot) sessionsCommand(ctx context.Context, tgBot *bot.Bot, update *model
 	}
 	log.Printf("[sessionsCommand] Got current session: %s", currentSessionID)
 
+	// Archived sessions only show up with /sessions all.
+	archived := b.archivedSessions()
+	hidden := 0
+	if !showAll {
+		shown := sessions[:0:0]
+		for _, sess := range sessions {
+			if archived[sess.ID] && sess.ID != currentSessionID {
+				hidden++
+				continue
+			}
+			shown = append(shown, sess)
+		}
+		sessions = shown
+	}
+
+	if len(sessions) == 0 {
+		log.Printf("[sessionsCommand] No sessions, sending message")
+		text := "No sessions found"
+		if hidden > 0 {
+			text = fmt.Sprintf("No active sessions; %d archived. /sessions all lists them.", hidden)
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+		return
+	}
+
+	totalSessions := len(sessions)
+	log.Printf("[sessionsCommand] Building response for %d sessions", totalSessions)
+
+	// Cap the list to avoid the message-too-long error
+	if len(sessions) > b.Config.SessionListLimit {
+		sessions = sessions[:b.Config.SessionListLimit]
+	}
+
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("Available Sessions (%d total, showing first %d)\n\n", totalSessions, len(sessions)))
 
 	var keyboard [][]models.InlineKeyboardButton
 	log.Printf("[sessionsCommand] Starting loop over sessions")
-	
-	// Limit to 20 sessions max to avoid message too long error
-	maxSessions := 20
-	if len(sessions) > maxSessions {
-		sessions = sessions[:maxSessions]
-	}
-	
+
 	for i, sess := range sessions {
 		title := sess.Title
 		if title == "" {
 			title = "Untitled"
 		}
 		indicator := ""
-		if sess.ID == currentSessionID {
+		allowed := b.dirAllowed(sess.Directory)
+		switch {
+		case sess.ID == currentSessionID:
 			indicator = " [active]"
+		case !allowed:
+			indicator = " [outside allowed dirs]"
+		case archived[sess.ID]:
+			indicator = " [archived]"
 		}
 		sb.WriteString(fmt.Sprintf("%d. %s - %s%s\n", i+1, shortID(sess.ID), title, indicator))
+		if sess.Time.Updated > 0 {
+			sb.WriteString("   updated " + b.formatTime(chatID, time.UnixMilli(sess.Time.Updated)) + "\n")
+		}
 
-		keyboard = append(keyboard, []models.InlineKeyboardButton{
-			{Text: fmt.Sprintf("Switch to %s", shortID(sess.ID)), CallbackData: "switch_" + sess.ID},
-		})
+		var row []models.InlineKeyboardButton
+		if allowed {
+			row = append(row, models.InlineKeyboardButton{Text: fmt.Sprintf("Switch to %s", shortID(sess.ID)), CallbackData: "switch_" + sess.ID})
+		}
+		if archived[sess.ID] {
+			row = append(row, models.InlineKeyboardButton{Text: "📦 Unarchive " + shortID(sess.ID), CallbackData: unarchivePrefix + sess.ID})
+		}
+		if len(row) > 0 {
+			keyboard = append(keyboard, row)
+		}
 		if i == 0 {
 			log.Printf("[sessionsCommand] First iteration done")
 		}
 	}
 	log.Printf("[sessionsCommand] Loop done, keyboard size: %d", len(keyboard))
-	
+
 	sb.WriteString("\nUse /switch <id> to switch sessions")
+	if hidden > 0 {
+		sb.WriteString(fmt.Sprintf("\n%d archived session%s hidden; /sessions all lists them.", hidden, plural(hidden)))
+	}
 	log.Printf("[sessionsCommand] Sending message to chatID=%d, text length=%d", chatID, len(sb.String()))
-	
+
 	msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
 		Text:   sb.String(),
@@ -91,6 +132,7 @@ func (b *Bot) sessionsCommand(ctx context.Context, tgBot *bot.Bot, update *model
 		},
 	})
 	log.Printf("[sessionsCommand] SendMessage result: msgID=%d, err=%v", msg.ID, err)
+	b.track(chatID, msg)
 }
 
 func (b *Bot) switchCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
@@ -108,12 +150,21 @@ func (b *Bot) switchCommand(ctx context.Context, tgBot *bot.Bot, update *models.
 		return
 	}
 	sessionID := parts[1]
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
 
 	if b.Client != nil {
-		if _, err := b.Client.GetOCSession(ctx, sessionID); err != nil {
+		oc, err := b.Client.GetOCSession(ctx, sessionID)
+		if err != nil {
 			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Session not found"})
 			return
 		}
+		if refusal := b.sessionRefusal(chatID, oc); refusal != "" {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+			return
+		}
 	}
 
 	if b.DB != nil {
@@ -127,6 +178,7 @@ func (b *Bot) switchCommand(ctx context.Context, tgBot *bot.Bot, update *models.
 			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save session"})
 			return
 		}
+		b.unarchive(sessionID)
 	}
 
 	tgBot.SendMessage(ctx, &bot.SendMessageParams{
@@ -146,11 +198,32 @@ func (b *Bot) renameCommand(ctx context.Context, tgBot *bot.Bot, update *models.
 
 	parts := strings.SplitN(update.Message.Text, " ", 2)
 	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
-		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /rename <new title>"})
+		if b.currentSessionID(chatID) == "" {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+			return
+		}
+		if err := b.awaitInput(chatID, "rename", "", defaultPendingTTL); err != nil {
+			log.Printf("[renameCommand] Error saving pending action: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /rename <new title>"})
+			return
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Send the new session title (or /cancel)"})
 		return
 	}
-	newTitle := strings.TrimSpace(parts[1])
+	b.renameSession(ctx, tgBot, chatID, strings.TrimSpace(parts[1]))
+}
 
+// continueRename receives the title after a bare /rename.
+func (b *Bot) continueRename(ctx context.Context, tgBot *bot.Bot, chatID int64, _ store.PendingAction, input string) {
+	title := strings.TrimSpace(input)
+	if title == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Title cannot be empty"})
+		return
+	}
+	b.renameSession(ctx, tgBot, chatID, title)
+}
+
+func (b *Bot) renameSession(ctx context.Context, tgBot *bot.Bot, chatID int64, newTitle string) {
 	var sessionID string
 	if b.DB != nil {
 		sess, err := b.DB.GetSession(chatID)
@@ -238,45 +311,7 @@ func (b *Bot) deleteCommand(ctx context.Context, tgBot *bot.Bot, update *models.
 	})
 }
 
-func (b *Bot) purgeCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
-	if update.Message == nil {
-		return
-	}
-	chatID := update.Message.Chat.ID
-	if !b.requireAuth(chatID, tgBot, ctx) {
-		return
-	}
-	if !b.isAdmin(chatID) {
-		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
-		return
-	}
-
-	// Delete all OC sessions
-	if b.Client != nil {
-		sessions, err := b.Client.ListOCSessions(ctx)
-		if err == nil {
-			for _, sess := range sessions {
-				if err := b.Client.DeleteOCSession(ctx, sess.ID); err != nil {
-					log.Printf("[purgeCommand] Error deleting OC session %s: %v", shortID(sess.ID), err)
-				}
-			}
-		}
-	}
-
-	// Clear all DB mappings
-	if b.DB != nil {
-		if err := b.DB.DeleteAll(); err != nil {
-			log.Printf("[purgeCommand] Error clearing DB: %v", err)
-		}
-	}
-
-	tgBot.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: chatID,
-		Text:   "All sessions purged!",
-	})
-}
-
-func (b *Bot) diffCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+func (b *Bot) historyCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
 	if update.Message == nil {
 		return
 	}
@@ -290,43 +325,8 @@ func (b *Bot) diffCommand(ctx context.Context, tgBot *bot.Bot, update *models.Up
 		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
 		return
 	}
-	if b.Client == nil {
-		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "OpenCode client not initialized"})
-		return
-	}
-
-	diff, err := b.Client.GetDiff(ctx, sessionID)
-	if err != nil {
-		log.Printf("[diffCommand] Error: %v", err)
-		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get diff"})
-		return
-	}
-	if diff == "" {
-		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No changes"})
-		return
-	}
-	if len(diff) > 4000 {
-		diff = diff[:4000] + "\n\n... (truncated)"
-	}
-
-	tgBot.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: chatID,
-		Text:   "Current Changes\n\n" + diff,
-	})
-}
-
-func (b *Bot) historyCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
-	if update.Message == nil {
-		return
-	}
-	chatID := update.Message.Chat.ID
-	if !b.requireAuth(chatID, tgBot, ctx) {
-		return
-	}
-
-	sessionID := b.currentSessionID(chatID)
-	if sessionID == "" {
-		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
 		return
 	}
 	if b.Client == nil {
@@ -349,29 +349,90 @@ func (b *Bot) historyCommand(ctx context.Context, tgBot *bot.Bot, update *models
 	sb.WriteString("Recent Messages\n\n")
 
 	start := 0
-	if len(messages) > 10 {
-		start = len(messages) - 10
+	if len(messages) > b.Config.HistoryLimit {
+		start = len(messages) - b.Config.HistoryLimit
 	}
+	var keyboard [][]models.InlineKeyboardButton
 	for i := start; i < len(messages); i++ {
 		msg := messages[i]
 		role := msg.Role
 		if role == "" {
 			role = "user"
 		}
-		content := msg.Content
-		if len(content) > 200 {
-			content = content[:200] + "..."
+		content := tgtext.Clip(msg.Content, 200)
+		if !msg.Created.IsZero() {
+			role += " · " + b.formatTime(chatID, msg.Created)
+		}
+		sb.WriteString(fmt.Sprintf("#%d %s:\n%s\n\n", i+1, role, content))
+		if msg.Role == "assistant" && i+1 < len(messages) && len(revertOKPrefix+msg.ID) <= callbackDataLimit {
+			keyboard = append(keyboard, []models.InlineKeyboardButton{{Text: fmt.Sprintf("↩️ Revert to #%d", i+1), CallbackData: revertPrefix + msg.ID}})
 		}
-		sb.WriteString(fmt.Sprintf("%s:\n%s\n\n", role, content))
 	}
 
-	text := sb.String()
-	if len(text) > 4000 {
-		text = text[:4000] + "\n... (truncated)"
+	params := &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   b.truncate(sb.String()),
+	}
+	if len(keyboard) > 0 {
+		params.ReplyMarkup = &models.InlineKeyboardMarkup{InlineKeyboard: keyboard}
 	}
+	tgBot.SendMessage(ctx, params)
+}
 
-	tgBot.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: chatID,
-		Text:   text,
-	})
+// exportCommand sends the chat's latest reply in full as a file, including
+// text cut from the Telegram message for length.
+func (b *Bot) exportCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	current := b.currentSessionID(chatID)
+	if refusal := b.lockRefusal(chatID, current); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+
+	var text, sessionID string
+	if b.Stream != nil {
+		if streamed, streaming, complete := b.Stream.CurrentText(chatID); streaming && complete && streamed != "" {
+			text, sessionID = streamed, current
+		}
+	}
+	if text == "" && b.DB != nil {
+		cached, err := b.DB.GetMessageText(chatID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[exportCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to load the last reply"})
+			return
+		}
+		// The last reply may be from a session the chat has left since.
+		if cached.SessionID != current {
+			if refusal := b.lockRefusal(chatID, cached.SessionID); refusal != "" {
+				tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+				return
+			}
+		}
+		text, sessionID = cached.Text, cached.SessionID
+	}
+	if text == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Nothing to export yet"})
+		return
+	}
+
+	filename := "reply.md"
+	if sessionID != "" {
+		filename = fmt.Sprintf("reply-%s.md", shortID(sessionID))
+	}
+	if _, err := tgBot.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:   chatID,
+		Document: &models.InputFileUpload{Filename: filename, Data: strings.NewReader(text)},
+		Caption:  fmt.Sprintf("Last reply (%d characters)", len([]rune(text))),
+	}); err != nil {
+		log.Printf("[exportCommand] Error sending document: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to send export: " + err.Error()})
+	}
 }
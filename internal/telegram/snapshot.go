@@ -0,0 +1,214 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+var snapshotName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+// snapshotCommand names the session's current point in history so
+// /restore can roll messages and files back to it. Bare, it lists the
+// session's snapshots.
+func (b *Bot) snapshotCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	sessionID, ok := b.snapshotSession(ctx, tgBot, chatID)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/snapshot"))
+	if name == "" {
+		b.listSnapshots(ctx, tgBot, chatID, sessionID)
+		return
+	}
+	if !snapshotName.MatchString(name) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "Snapshot names are up to 32 letters, digits, '.', '-' or '_'.",
+		})
+		return
+	}
+
+	sess, err := b.Client.GetOCSession(ctx, sessionID)
+	if err != nil {
+		log.Printf("[snapshotCommand] Error getting session: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get session"})
+		return
+	}
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		log.Printf("[snapshotCommand] Error getting messages: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get messages"})
+		return
+	}
+	messages = visibleMessages(sess, messages)
+
+	snap := store.Snapshot{SessionID: sessionID, Name: name, CreatedAt: time.Now()}
+	if len(messages) > 0 {
+		snap.MessageID = messages[len(messages)-1].ID
+	}
+	if err := b.DB.SaveSnapshot(snap); err != nil {
+		log.Printf("[snapshotCommand] Error saving snapshot: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save snapshot"})
+		return
+	}
+	log.Printf("[snapshotCommand] Chat %d saved snapshot %q of %s at message %q", chatID, name, sessionID, snap.MessageID)
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("Snapshot %s saved after %d message(s). Use /restore %s to roll back to it.", name, len(messages), name),
+	})
+}
+
+// restoreCommand reverts the session to a snapshot: messages after it are
+// hidden and their file changes undone, as with OpenCode's revert.
+func (b *Bot) restoreCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	sessionID, ok := b.snapshotSession(ctx, tgBot, chatID)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/restore"))
+	if name == "" {
+		b.listSnapshots(ctx, tgBot, chatID, sessionID)
+		return
+	}
+	snap, err := b.DB.GetSnapshot(sessionID, name)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[restoreCommand] Error getting snapshot: %v", err)
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No snapshot named " + name + ". Send /restore to list them."})
+		return
+	}
+
+	sess, err := b.Client.GetOCSession(ctx, sessionID)
+	if err != nil {
+		log.Printf("[restoreCommand] Error getting session: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get session"})
+		return
+	}
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		log.Printf("[restoreCommand] Error getting messages: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get messages"})
+		return
+	}
+
+	// Revert to the first message after the snapshot.
+	next := 0
+	if snap.MessageID != "" {
+		next = -1
+		for i, m := range messages {
+			if m.ID == snap.MessageID {
+				next = i + 1
+				break
+			}
+		}
+		if next < 0 {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: chatID,
+				Text:   "Snapshot " + name + " is no longer in this session's history.",
+			})
+			return
+		}
+	}
+
+	if err := b.revertTo(ctx, sess, messages, next); err != nil {
+		log.Printf("[restoreCommand] Error restoring %q: %v", name, err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to restore snapshot: " + err.Error()})
+		return
+	}
+	log.Printf("[restoreCommand] Chat %d restored %s to snapshot %q", chatID, sessionID, name)
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("Restored snapshot %s (taken %s).", name, b.formatTime(chatID, snap.CreatedAt)),
+	})
+}
+
+// revertTo rolls the session back so messages[next] and everything after
+// it are hidden and their file changes undone. With next past the end it
+// brings back whatever a pending revert hid.
+func (b *Bot) revertTo(ctx context.Context, sess opencode.OCSession, messages []opencode.Message, next int) error {
+	var err error
+	switch {
+	case next < len(messages):
+		if sess.Revert != nil && sess.Revert.MessageID == messages[next].ID {
+			break
+		}
+		_, err = b.Client.Revert(ctx, sess.ID, messages[next].ID)
+	case sess.Revert != nil:
+		_, err = b.Client.Unrevert(ctx, sess.ID)
+	}
+	return err
+}
+
+// snapshotSession returns the chat's session if snapshots can be used.
+func (b *Bot) snapshotSession(ctx context.Context, tgBot *bot.Bot, chatID int64) (string, bool) {
+	if b.Client == nil || b.DB == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Snapshots need the OpenCode client and a store"})
+		return "", false
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return "", false
+	}
+	return sessionID, true
+}
+
+func (b *Bot) listSnapshots(ctx context.Context, tgBot *bot.Bot, chatID int64, sessionID string) {
+	snaps, err := b.DB.ListSnapshots(sessionID)
+	if err != nil {
+		log.Printf("[listSnapshots] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to list snapshots"})
+		return
+	}
+	if len(snaps) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No snapshots yet. Use /snapshot <name> to take one."})
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("Snapshots of " + shortID(sessionID) + "\n\n")
+	for _, s := range snaps {
+		sb.WriteString(fmt.Sprintf("%s - %s\n", s.Name, b.formatTime(chatID, s.CreatedAt)))
+	}
+	sb.WriteString("\nUse /restore <name> to roll back.")
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(sb.String())})
+}
+
+// visibleMessages drops the messages hidden by a pending revert.
+func visibleMessages(sess opencode.OCSession, messages []opencode.Message) []opencode.Message {
+	if sess.Revert == nil {
+		return messages
+	}
+	for i, m := range messages {
+		if m.ID == sess.Revert.MessageID {
+			return messages[:i]
+		}
+	}
+	return messages
+}
@@ -0,0 +1,54 @@
+package telegram
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/metrics"
+)
+
+var (
+	apiDuration = metrics.NewHistogram("openkh_telegram_request_duration_seconds",
+		"Duration of Telegram Bot API calls by method.", nil, "method")
+	apiErrors = metrics.NewCounter("openkh_telegram_request_errors_total",
+		"Telegram Bot API calls that failed or returned a non-2xx status.", "method", "status")
+)
+
+// timedTransport records the latency and outcome of every Bot API call.
+// The method is the last path element of /bot<token>/<method>, so the
+// token never ends up in a label.
+type timedTransport struct {
+	base http.RoundTripper
+}
+
+func (t *timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := path.Base(req.URL.Path)
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	apiDuration.Observe(time.Since(start).Seconds(), method)
+	switch {
+	case err != nil:
+		apiErrors.Inc(method, "error")
+	case resp.StatusCode >= 300:
+		apiErrors.Inc(method, strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// apiLatencySummary returns the latency histogram samples of the given
+// Bot API methods, for /debug.
+func apiLatencySummary(methods ...string) map[string]metrics.HistogramSample {
+	want := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		want[m] = true
+	}
+	out := make(map[string]metrics.HistogramSample)
+	for _, s := range apiDuration.Snapshot() {
+		if want[s.Labels[0]] {
+			out[s.Labels[0]] = s
+		}
+	}
+	return out
+}
@@ -0,0 +1,310 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/config"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// systemPrefix + session ID is the chat setting holding the system prompt
+// of the template the session was started from.
+const systemPrefix = "system."
+
+// template returns the session template called name: the one an admin
+// defined with /template, else the SESSION_TEMPLATES_FILE entry.
+func (b *Bot) template(name string) (config.SessionTemplate, bool) {
+	if b.DB != nil {
+		t, err := b.DB.GetTemplate(name)
+		if err == nil {
+			return config.SessionTemplate{Agent: t.Agent, Model: t.Model, Dir: t.Dir, System: t.System}, true
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[template] %s: %v", name, err)
+		}
+	}
+	if b.Config == nil {
+		return config.SessionTemplate{}, false
+	}
+	t, ok := b.Config.Templates[name]
+	return t, ok
+}
+
+// templateNames returns the names of all templates in order, and which
+// of them were defined with /template.
+func (b *Bot) templateNames() ([]string, map[string]bool) {
+	defined := make(map[string]bool)
+	if b.DB != nil {
+		templates, err := b.DB.ListTemplates()
+		if err != nil {
+			log.Printf("[templateNames] Error: %v", err)
+		}
+		for _, t := range templates {
+			defined[t.Name] = true
+		}
+	}
+	names := make([]string, 0, len(defined))
+	for name := range defined {
+		names = append(names, name)
+	}
+	if b.Config != nil {
+		for _, name := range b.Config.TemplateNames() {
+			if !defined[name] {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, defined
+}
+
+// templatesSummary lists the templates with what each sets up.
+func (b *Bot) templatesSummary() string {
+	names, defined := b.templateNames()
+	if len(names) == 0 {
+		return "No session templates. Admins define them with /template set, or in SESSION_TEMPLATES_FILE."
+	}
+	var sb strings.Builder
+	sb.WriteString("Session templates:\n")
+	for _, name := range names {
+		t, _ := b.template(name)
+		var parts []string
+		for _, f := range []struct{ label, value string }{{"agent", t.Agent}, {"model", t.Model}, {"dir", t.Dir}} {
+			if f.value != "" {
+				parts = append(parts, f.label+" "+f.value)
+			}
+		}
+		if t.System != "" {
+			parts = append(parts, "system prompt")
+		}
+		source := ""
+		if !defined[name] {
+			source = " (config)"
+		}
+		fmt.Fprintf(&sb, "• %s%s: %s\n", name, source, strings.Join(parts, ", "))
+	}
+	sb.WriteString("\nStart one with /newfrom <name>")
+	return sb.String()
+}
+
+// newFromCommand starts a session set up by a template: "/newfrom bugfix"
+// creates it in the template's directory with its agent and model, and
+// sends its system prompt with every prompt of the session.
+func (b *Bot) newFromCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	name := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/newfrom"))
+	if name == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(b.templatesSummary())})
+		return
+	}
+	t, ok := b.template(name)
+	if !ok {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Unknown template: " + name + "\n\n" + b.truncate(b.templatesSummary())})
+		return
+	}
+	dir := t.Dir
+	if dir == "" {
+		dir = b.sessionDir(chatID)
+	} else if !b.dirAllowed(dir) {
+		log.Printf("Warning: chat %d: template %s starts in %s, outside ALLOWED_DIRS", chatID, name, dir)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Template " + name + " starts outside the allowed directories"})
+		return
+	}
+
+	oc, err := b.Client.CreateOCSession(ctx, "["+name+"] "+b.sessionTitle(chatID), dir)
+	if err != nil {
+		log.Printf("[newFromCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to create session"})
+		return
+	}
+	if refusal := b.sessionRefusal(chatID, oc); refusal != "" {
+		if err := b.Client.DeleteOCSession(ctx, oc.ID); err != nil {
+			log.Printf("[newFromCommand] Error deleting refused session: %v", err)
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+	providerID, modelID, _ := config.SplitModel(t.Model)
+	sess := store.Session{
+		ChatID:        chatID,
+		SessionID:     oc.ID,
+		Title:         oc.Title,
+		Agent:         t.Agent,
+		ModelProvider: providerID,
+		ModelID:       modelID,
+		CreatedAt:     time.Now(),
+		LastUsed:      time.Now(),
+	}
+	if err := b.DB.SetSession(sess); err != nil {
+		log.Printf("[newFromCommand] Error saving session: %v", err)
+	}
+	if t.System != "" {
+		if err := b.DB.SetChatSetting(chatID, systemPrefix+oc.ID, t.System); err != nil {
+			log.Printf("[newFromCommand] Error saving system prompt: %v", err)
+		}
+	}
+
+	agent, providerID, modelID := b.withDefaults(t.Agent, providerID, modelID)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Started a %s session: %s\n", name, shortID(oc.ID))
+	if agent != "" {
+		fmt.Fprintf(&sb, "Agent: %s\n", agent)
+	}
+	if modelID != "" {
+		fmt.Fprintf(&sb, "Model: %s/%s\n", providerID, modelID)
+	}
+	if oc.Directory != "" {
+		dir = oc.Directory
+	}
+	fmt.Fprintf(&sb, "Directory: %s", dir)
+	if t.System != "" {
+		sb.WriteString("\nWith the template's system prompt")
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: sb.String()})
+}
+
+// systemPrompt is the system instruction sent with the chat's prompts to
+// sessionID: its template's system prompt and the response language.
+func (b *Bot) systemPrompt(chatID int64, sessionID string) string {
+	var parts []string
+	if sessionID != "" {
+		if system := b.chatSetting(chatID, systemPrefix+sessionID); system != "" {
+			parts = append(parts, system)
+		}
+	}
+	if lang := b.languageInstruction(chatID); lang != "" {
+		parts = append(parts, lang)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// templateCommand manages the session templates admins define: bare
+// /template lists them, "/template set <name> [agent=..] [model=..]
+// [dir=..]" defines one, with the system prompt on the following lines,
+// and "/template delete <name>" removes one.
+func (b *Bot) templateCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+	if !b.isAdmin(chatID) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Admin only command"})
+		return
+	}
+
+	const usage = "Usage: /template set <name> [agent=<name>] [model=<provider/model>] [dir=<path>], with the system prompt on the next lines, or /template delete <name>"
+	first, system, _ := strings.Cut(strings.TrimPrefix(update.Message.Text, "/template"), "\n")
+	args := strings.Fields(first)
+	switch {
+	case len(args) == 0:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(b.templatesSummary())})
+	case args[0] == "delete" && len(args) == 2:
+		b.deleteTemplate(ctx, tgBot, chatID, args[1])
+	case args[0] == "set" && len(args) >= 2:
+		t, err := parseTemplate(args[2:], strings.TrimSpace(system))
+		if err != nil {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Invalid template: " + err.Error() + "\n\n" + usage})
+			return
+		}
+		b.saveTemplate(ctx, tgBot, chatID, args[1], t)
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: usage})
+	}
+}
+
+// parseTemplate reads the key=value arguments of /template set.
+func parseTemplate(args []string, system string) (config.SessionTemplate, error) {
+	t := config.SessionTemplate{System: system}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || value == "" {
+			return t, fmt.Errorf("expected key=value, got %q", arg)
+		}
+		switch key {
+		case "agent":
+			t.Agent = value
+		case "model":
+			t.Model = value
+		case "dir":
+			t.Dir = filepath.Clean(value)
+		default:
+			return t, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return t, t.Check()
+}
+
+func (b *Bot) saveTemplate(ctx context.Context, tgBot *bot.Bot, chatID int64, name string, t config.SessionTemplate) {
+	if !config.TemplateName.MatchString(name) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Template names are up to 32 letters, digits, - and _"})
+		return
+	}
+	if t.Dir != "" && !b.dirAllowed(t.Dir) {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: t.Dir + " is outside the allowed directories"})
+		return
+	}
+	if agents := b.agents(); t.Agent != "" && len(agents) > 0 {
+		if _, ok := agents[t.Agent]; !ok {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Unknown agent: " + t.Agent})
+			return
+		}
+	}
+	err := b.DB.SaveTemplate(store.SessionTemplate{
+		Name:      name,
+		Agent:     t.Agent,
+		Model:     t.Model,
+		Dir:       t.Dir,
+		System:    t.System,
+		UpdatedBy: chatID,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("[saveTemplate] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save the template"})
+		return
+	}
+	log.Printf("Chat %d saved session template %s", chatID, name)
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Saved template " + name + ". Start a session from it with /newfrom " + name})
+}
+
+func (b *Bot) deleteTemplate(ctx context.Context, tgBot *bot.Bot, chatID int64, name string) {
+	if _, err := b.DB.GetTemplate(name); err != nil {
+		text := "No template " + name + " was defined with /template"
+		if b.Config != nil {
+			if _, ok := b.Config.Templates[name]; ok {
+				text = "Template " + name + " comes from SESSION_TEMPLATES_FILE; remove it there"
+			}
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+		return
+	}
+	if err := b.DB.DeleteTemplate(name); err != nil {
+		log.Printf("[deleteTemplate] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to delete the template"})
+		return
+	}
+	text := "Deleted template " + name
+	if _, ok := b.template(name); ok {
+		text += "; the SESSION_TEMPLATES_FILE entry of that name applies again"
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+}
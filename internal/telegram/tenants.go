@@ -0,0 +1,102 @@
+package telegram
+
+import (
+	"context"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// tenant is the bot serving the chats of a TENANTS entry, on the tenant's
+// own OpenCode instance, and the Telegram client its handlers run on.
+type tenant struct {
+	bot *Bot
+	tg  *bot.Bot
+}
+
+// AddTenant hands the chats TENANT_CHATS gives to tenant name over to t,
+// a Bot on the tenant's OpenCode instance. tgBot is a client of the same
+// Telegram bot with t's handlers registered.
+func (b *Bot) AddTenant(name string, t *Bot, tgBot *bot.Bot) {
+	if b.tenants == nil {
+		b.tenants = make(map[string]*tenant)
+	}
+	b.tenants[name] = &tenant{bot: t, tg: tgBot}
+}
+
+// tenantFor returns the tenant serving chatID, or nil if this bot does.
+func (b *Bot) tenantFor(chatID int64) *tenant {
+	if b.Config == nil || b.Config.Tenant != "" {
+		return nil
+	}
+	return b.tenants[b.Config.TenantChats[chatID]]
+}
+
+// serves reports whether chatID is this bot's to serve: a chat of its
+// tenant, or of none for the default bot.
+func (b *Bot) serves(chatID int64) bool {
+	return b.Config == nil || b.Config.TenantChats[chatID] == b.Config.Tenant
+}
+
+// routeTenants hands the updates of tenant chats to the tenant's bot,
+// which runs them through its own dispatcher and handlers.
+func (b *Bot) routeTenants(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+		if chatID, ok := updateChatID(update); ok {
+			if t := b.tenantFor(chatID); t != nil {
+				t.tg.ProcessUpdate(ctx, update)
+				return
+			}
+		}
+		next(ctx, tgBot, update)
+	}
+}
+
+// chatSessions returns the stored sessions of the chats this bot serves.
+// Chats are found through their sessions, as elsewhere; those of other
+// tenants are left to their bots.
+func (b *Bot) chatSessions() ([]store.Session, error) {
+	sessions, err := b.DB.ListAll()
+	if err != nil || b.Config == nil || len(b.Config.TenantChats) == 0 {
+		return sessions, err
+	}
+	var own []store.Session
+	for _, s := range sessions {
+		if b.serves(s.ChatID) {
+			own = append(own, s)
+		}
+	}
+	return own, nil
+}
+
+// Feedback passes the send queue's edit feedback on to the stream of the
+// chat's tenant, or to this bot's.
+func (b *Bot) Feedback() opencode.EditFeedback {
+	return tenantFeedback{b: b}
+}
+
+type tenantFeedback struct {
+	b *Bot
+}
+
+func (f tenantFeedback) stream(chatID int64) *opencode.StreamManager {
+	if t := f.b.tenantFor(chatID); t != nil {
+		return t.bot.Stream
+	}
+	return f.b.Stream
+}
+
+func (f tenantFeedback) EditRateLimited(chatID int64, retryAfter time.Duration) {
+	if s := f.stream(chatID); s != nil {
+		s.EditRateLimited(chatID, retryAfter)
+	}
+}
+
+func (f tenantFeedback) EditsQueuedUp(chatID int64) {
+	if s := f.stream(chatID); s != nil {
+		s.EditsQueuedUp(chatID)
+	}
+}
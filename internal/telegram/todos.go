@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// todoPrefix + todoKey is the callback data of a /todos follow-up
+	// button.
+	todoPrefix = "todo_"
+	// maxTodos caps the items /todos lists.
+	maxTodos = 20
+	// todoButtonsPerRow fits the numbered follow-up buttons on a phone.
+	todoButtonsPerRow = 5
+)
+
+var todoMarker = regexp.MustCompile(`\b(TODO|FIXME)\b`)
+
+// todoItem is a TODO or FIXME line and where it was found: "message #3"
+// or "path/to/file.go:42".
+type todoItem struct {
+	where string
+	text  string
+}
+
+// todoKey identifies item across /todos runs, so buttons stay valid while
+// the session changes around them.
+func todoKey(item todoItem) string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s\x00%s", item.where, item.text)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// sessionTodos collects the TODO and FIXME lines of the session's replies
+// and, with withDiff, those its file changes added.
+func (b *Bot) sessionTodos(ctx context.Context, sessionID string, withDiff bool) ([]todoItem, error) {
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var items []todoItem
+	seen := make(map[string]bool)
+	for i, m := range messages {
+		if m.Role != "assistant" {
+			continue
+		}
+		for _, line := range strings.Split(m.Content, "\n") {
+			line = strings.TrimSpace(line)
+			if !todoMarker.MatchString(line) || seen[line] {
+				continue
+			}
+			seen[line] = true
+			items = append(items, todoItem{where: fmt.Sprintf("message #%d", i+1), text: line})
+		}
+	}
+	if !withDiff {
+		return items, nil
+	}
+
+	diffs, err := b.Client.GetFileDiffs(ctx, sessionID)
+	if err != nil {
+		return items, err
+	}
+	for _, d := range diffs {
+		before := make(map[string]bool)
+		for _, line := range strings.Split(d.Before, "\n") {
+			before[strings.TrimSpace(line)] = true
+		}
+		for n, line := range strings.Split(d.After, "\n") {
+			line = strings.TrimSpace(line)
+			if todoMarker.MatchString(line) && !before[line] {
+				items = append(items, todoItem{where: fmt.Sprintf("%s:%d", d.File, n+1), text: line})
+			}
+		}
+	}
+	return items, nil
+}
+
+// todosCommand lists the TODO and FIXME items the agent mentioned in the
+// session, and with "/todos diff" also those its changes added to files,
+// with a button per item that asks the agent to follow it up.
+func (b *Bot) todosCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	var withDiff bool
+	switch arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/todos")); arg {
+	case "":
+	case "diff":
+		withDiff = true
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /todos [diff]"})
+		return
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" || b.Client == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+
+	items, err := b.sessionTodos(ctx, sessionID, withDiff)
+	var note string
+	if err != nil {
+		log.Printf("[todosCommand] Error: %v", err)
+		if items == nil {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get messages"})
+			return
+		}
+		note = "\n\nThe diff could not be read, so files are not included."
+	}
+	if len(items) == 0 {
+		text := "No TODO or FIXME items in this session's replies."
+		if !withDiff {
+			text += " /todos diff also checks the changed files."
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text + note})
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d TODO item%s\n\n", len(items), plural(len(items)))
+	if len(items) > maxTodos {
+		items = items[len(items)-maxTodos:]
+		fmt.Fprintf(&sb, "Showing the latest %d.\n\n", maxTodos)
+	}
+	var keyboard [][]models.InlineKeyboardButton
+	var row []models.InlineKeyboardButton
+	for i, item := range items {
+		fmt.Fprintf(&sb, "%d. %s\n%s\n\n", i+1, item.where, tgtext.Clip(item.text, 200))
+		row = append(row, models.InlineKeyboardButton{Text: "▶️ " + strconv.Itoa(i+1), CallbackData: todoPrefix + todoKey(item)})
+		if len(row) == todoButtonsPerRow || i == len(items)-1 {
+			keyboard = append(keyboard, row)
+			row = nil
+		}
+	}
+	sb.WriteString("Tap a number to have the agent follow that item up.")
+	sb.WriteString(note)
+	msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      chatID,
+		Text:        b.truncate(sb.String()),
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+	if err != nil {
+		log.Printf("[todosCommand] Error: %v", err)
+		return
+	}
+	b.track(chatID, msg)
+}
+
+// handleTodoCallback sends the follow-up prompt for the /todos item the
+// button is for.
+func (b *Bot) handleTodoCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, key string) {
+	answer := func(text string) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: text})
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" || b.Client == nil {
+		answer("No active session")
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: refusal, ShowAlert: true})
+		return
+	}
+	items, err := b.sessionTodos(ctx, sessionID, true)
+	if err != nil {
+		log.Printf("[handleTodoCallback] Error: %v", err)
+	}
+	for _, item := range items {
+		if todoKey(item) != key {
+			continue
+		}
+		if !b.allowMessage(chatID) {
+			answer("Please wait a moment before sending another message")
+			return
+		}
+		answer("")
+		prompt := fmt.Sprintf("Follow up on this item from %s and resolve it:\n\n%s", item.where, item.text)
+		b.rememberSender(&callback.From)
+		b.runPrompt(ctx, tgBot, chatID, callback.From.ID, callback.Message.Message.ID, prompt)
+		return
+	}
+	answer("That item is no longer in the current session")
+}
@@ -0,0 +1,133 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/go-telegram/bot"
+)
+
+// blockedCallTTL is how long a blocked call is remembered, so its
+// permission request and tool part don't both notify the chat.
+const blockedCallTTL = 10 * time.Minute
+
+// toolGuard applies the tool policy to streamed sessions: denied
+// permission requests are rejected, and a denied tool that runs without
+// asking stops the session. Other requests follow TOOL_POLICY.
+type toolGuard struct {
+	b     *Bot
+	tgBot *bot.Bot
+
+	mu      sync.Mutex
+	blocked map[string]time.Time // call ID -> when it was blocked
+}
+
+// Guard returns the stream's tool policy hook, reporting through tgBot.
+func (b *Bot) Guard(tgBot *bot.Bot) opencode.ToolGuard {
+	return &toolGuard{b: b, tgBot: tgBot, blocked: make(map[string]time.Time)}
+}
+
+// toolDenial returns the setting that denies tool in chatID and the role
+// it applies to, or "" if the tool is allowed.
+func (b *Bot) toolDenial(chatID int64, tool string) (setting, role string) {
+	if b.Config == nil {
+		return "", ""
+	}
+	if b.isAdmin(chatID) {
+		if b.Config.AdminDeniedTools[tool] {
+			return "ADMIN_DENIED_TOOLS", "admins"
+		}
+		return "", ""
+	}
+	if b.Config.DeniedTools[tool] {
+		return "DENIED_TOOLS", "users"
+	}
+	return "", ""
+}
+
+// firstBlock records callID and reports whether it is new.
+func (g *toolGuard) firstBlock(callID string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for id, at := range g.blocked {
+		if now.Sub(at) > blockedCallTTL {
+			delete(g.blocked, id)
+		}
+	}
+	if callID == "" {
+		return true
+	}
+	if _, ok := g.blocked[callID]; ok {
+		return false
+	}
+	g.blocked[callID] = now
+	return true
+}
+
+func (g *toolGuard) PermissionAsked(chatID int64, p opencode.Permission) {
+	if g.b.Client == nil {
+		return
+	}
+	setting, role := g.b.toolDenial(chatID, p.Type)
+	if setting == "" {
+		if g.b.DB != nil {
+			go func() {
+				defer errreport.Recover(errreport.Fields{"goroutine": "permission", "chat_id": fmt.Sprint(chatID), "session_id": p.SessionID})
+				g.b.handlePermission(context.Background(), g.tgBot, chatID, p)
+			}()
+		}
+		return
+	}
+	first := g.firstBlock(p.CallID)
+	go func() {
+		defer errreport.Recover(errreport.Fields{"goroutine": "tool-policy", "chat_id": fmt.Sprint(chatID), "session_id": p.SessionID})
+		ctx := context.Background()
+		log.Printf("Warning: chat %d: rejecting %s permission in session %s (%s)", chatID, p.Type, p.SessionID, setting)
+		if err := g.b.Client.RespondPermission(ctx, p.SessionID, p.ID, opencode.PermissionReject); err != nil {
+			log.Printf("[PermissionAsked] Chat %d: %v", chatID, err)
+		}
+		if first {
+			g.notify(ctx, chatID, fmt.Sprintf("Blocked %s: %s denies it for %s. The request was rejected.", describeCall(p.Type, p.Title), setting, role))
+		}
+	}()
+}
+
+func (g *toolGuard) ToolStarted(chatID int64, sessionID, callID, tool string) {
+	setting, role := g.b.toolDenial(chatID, tool)
+	if setting == "" || g.b.Client == nil || !g.firstBlock(callID) {
+		return
+	}
+	go func() {
+		defer errreport.Recover(errreport.Fields{"goroutine": "tool-policy", "chat_id": fmt.Sprint(chatID), "session_id": sessionID})
+		ctx := context.Background()
+		log.Printf("Warning: chat %d: aborting session %s, it ran %s (%s)", chatID, sessionID, tool, setting)
+		if err := g.b.Client.Abort(ctx, sessionID); err != nil {
+			log.Printf("[ToolStarted] Chat %d: %v", chatID, err)
+		}
+		g.notify(ctx, chatID, fmt.Sprintf("Blocked %s: %s denies it for %s. The session was stopped.", describeCall(tool, ""), setting, role))
+	}()
+}
+
+func (g *toolGuard) notify(ctx context.Context, chatID int64, text string) {
+	msg, err := g.tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+	if err != nil {
+		log.Printf("[toolGuard] Chat %d: %v", chatID, err)
+		return
+	}
+	g.b.track(chatID, msg)
+}
+
+// describeCall names a tool call for a notice, e.g. "the bash tool (rm -rf /)".
+func describeCall(tool, title string) string {
+	if title == "" {
+		return "the " + tool + " tool"
+	}
+	return fmt.Sprintf("the %s tool (%s)", tool, tgtext.Clip(title, 60))
+}
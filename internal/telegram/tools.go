@@ -0,0 +1,357 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// toolPrefix + part ID is the callback data of a "Show output" button.
+	toolPrefix = "tool_"
+	// toolRefLen is how much of a tool part's ID /tool shows and accepts.
+	// Part IDs start with a timestamp, so it's their tail.
+	toolRefLen = 8
+	// expandPrefix + the ID of a reply's first tool call is the callback
+	// data of the reply's Expand button.
+	expandPrefix = "expand_"
+	// maxToolButtons caps the "Show output" buttons under an expanded
+	// reply.
+	maxToolButtons = 8
+	// maxExpandArgs and maxExpandResult cap how much of each call's
+	// arguments and result an expanded reply shows.
+	maxExpandArgs   = 200
+	maxExpandResult = 300
+	// maxToolsListed caps the calls bare /tool lists.
+	maxToolsListed = 20
+)
+
+// toolRef is the short form of a tool part's ID /tool takes.
+func toolRef(id string) string {
+	if len(id) <= toolRefLen {
+		return id
+	}
+	return id[len(id)-toolRefLen:]
+}
+
+// toolLabel is e.g. "bash · go test ./...".
+func toolLabel(call opencode.ToolCall, max int) string {
+	if call.Title == "" {
+		return call.Tool
+	}
+	return call.Tool + " · " + tgtext.Clip(call.Title, max)
+}
+
+// sessionTools returns the tool calls of the session, oldest first, and
+// how many of them the latest reply made.
+func (b *Bot) sessionTools(ctx context.Context, sessionID string) ([]opencode.ToolCall, int, error) {
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	var calls []opencode.ToolCall
+	latest := 0
+	for _, m := range messages {
+		if m.Role == "user" {
+			latest = 0
+			continue
+		}
+		calls = append(calls, m.Tools...)
+		latest += len(m.Tools)
+	}
+	return calls, latest, nil
+}
+
+// latestTools returns the tool calls of the chat's latest reply. It runs on
+// the completion hook's goroutine.
+func (b *Bot) latestTools(ctx context.Context, chatID int64) []opencode.ToolCall {
+	sessionID := b.currentSessionID(chatID)
+	if b.Client == nil || sessionID == "" {
+		return nil
+	}
+	calls, latest, err := b.sessionTools(ctx, sessionID)
+	if err != nil {
+		log.Printf("[latestTools] Chat %d: %v", chatID, err)
+		return nil
+	}
+	return calls[len(calls)-latest:]
+}
+
+// firstToolID is the ID an Expand button finds the reply's calls by, or ""
+// if the reply made none.
+func firstToolID(calls []opencode.ToolCall) string {
+	if len(calls) == 0 {
+		return ""
+	}
+	return calls[0].ID
+}
+
+// expandRef returns the tool call ID of the Expand button in markup, so the
+// button survives the reply's markup being rebuilt.
+func expandRef(markup *models.InlineKeyboardMarkup) string {
+	if markup == nil {
+		return ""
+	}
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if strings.HasPrefix(button.CallbackData, expandPrefix) {
+				return strings.TrimPrefix(button.CallbackData, expandPrefix)
+			}
+		}
+	}
+	return ""
+}
+
+// replyTools returns the tool calls of the reply that made the call first,
+// or none if that's no longer in the session.
+func (b *Bot) replyTools(ctx context.Context, sessionID, first string) ([]opencode.ToolCall, error) {
+	messages, err := b.Client.GetMessages(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var calls []opencode.ToolCall
+	found := false
+	for _, m := range messages {
+		if m.Role == "user" {
+			if found {
+				break
+			}
+			calls = nil
+			continue
+		}
+		for _, call := range m.Tools {
+			calls = append(calls, call)
+			found = found || call.ID == first
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return calls, nil
+}
+
+// handleExpandCallback answers a reply's Expand button with each of its
+// tool calls, their arguments and the start of their results, and a "Show
+// output" button per call.
+func (b *Bot) handleExpandCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, first string) {
+	answer := func(text string) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: text})
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" || b.Client == nil {
+		answer("No active session")
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: refusal, ShowAlert: true})
+		return
+	}
+	calls, err := b.replyTools(ctx, sessionID, first)
+	if err != nil {
+		log.Printf("[handleExpandCallback] Error: %v", err)
+		answer("Failed to get messages")
+		return
+	}
+	if len(calls) == 0 {
+		answer("That reply is no longer in the current session")
+		return
+	}
+	answer("")
+
+	var keyboard [][]models.InlineKeyboardButton
+	for i, call := range calls {
+		if len(keyboard) == maxToolButtons {
+			break
+		}
+		if len(toolPrefix+call.ID) > callbackDataLimit {
+			continue
+		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{Text: fmt.Sprintf("%d. %s", i+1, toolLabel(call, 40)), CallbackData: toolPrefix + call.ID}})
+	}
+	text := expandText(calls)
+	if len(keyboard) > 0 {
+		text += "\n\nTap a call to show its full output."
+	}
+	msg, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          chatID,
+		Text:            b.truncate(text),
+		ReplyParameters: &models.ReplyParameters{MessageID: callback.Message.Message.ID, AllowSendingWithoutReply: true},
+		ReplyMarkup:     &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+	if err != nil {
+		log.Printf("[handleExpandCallback] Error: %v", err)
+		return
+	}
+	b.track(chatID, msg)
+}
+
+// expandText lists the calls with their arguments and clipped results.
+func expandText(calls []opencode.ToolCall) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🔧 %d tool call%s in this reply\n", len(calls), plural(len(calls)))
+	for i, call := range calls {
+		fmt.Fprintf(&sb, "\n%d. %s (%s)\n", i+1, toolLabel(call, 60), call.Status)
+		if command := toolCommandLine(call); command != "" {
+			fmt.Fprintf(&sb, "$ %s\n", tgtext.Clip(command, maxExpandArgs))
+		} else if len(call.Input) > 0 && string(call.Input) != "{}" && string(call.Input) != "null" {
+			fmt.Fprintf(&sb, "%s\n", tgtext.Clip(string(call.Input), maxExpandArgs))
+		}
+		result := strings.TrimSpace(call.Output)
+		switch {
+		case call.Error != "":
+			result = "Error: " + call.Error
+		case call.Status == "pending" || call.Status == "running":
+			result = "still running"
+		case result == "":
+			result = "(no output)"
+		}
+		fmt.Fprintf(&sb, "→ %s\n", tgtext.Clip(result, maxExpandResult))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// toolCommand shows what the session's tools produced: bare, it lists the
+// latest calls with their IDs; "/tool <id>" sends one call's complete
+// output, as a file when it is too long for a message.
+func (b *Bot) toolCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No active session. Send a message first."})
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+	calls, _, err := b.sessionTools(ctx, sessionID)
+	if err != nil {
+		log.Printf("[toolCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to get messages"})
+		return
+	}
+
+	ref := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/tool"))
+	if ref == "" {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(toolsList(calls))})
+		return
+	}
+	for _, call := range calls {
+		if call.ID == ref || toolRef(call.ID) == ref {
+			b.sendToolOutput(ctx, tgBot, chatID, call)
+			return
+		}
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No tool call " + ref + " in this session. Send /tool to list them."})
+}
+
+// toolsList lists the latest calls, newest last, with the IDs /tool takes.
+func toolsList(calls []opencode.ToolCall) string {
+	if len(calls) == 0 {
+		return "No tool calls in this session yet"
+	}
+	if len(calls) > maxToolsListed {
+		calls = calls[len(calls)-maxToolsListed:]
+	}
+	var sb strings.Builder
+	sb.WriteString("Tool calls\n\n")
+	for _, call := range calls {
+		fmt.Fprintf(&sb, "%s  %s (%s)\n", toolRef(call.ID), toolLabel(call, 60), call.Status)
+	}
+	sb.WriteString("\nUse /tool <id> to show one's output.")
+	return sb.String()
+}
+
+// handleToolCallback sends the output of the call a "Show output" button
+// is for, if it's still in the chat's current session.
+func (b *Bot) handleToolCallback(ctx context.Context, tgBot *bot.Bot, callback *models.CallbackQuery, chatID int64, partID string) {
+	answer := func(text string) {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: text})
+	}
+	sessionID := b.currentSessionID(chatID)
+	if sessionID == "" || b.Client == nil {
+		answer("No active session")
+		return
+	}
+	if refusal := b.lockRefusal(chatID, sessionID); refusal != "" {
+		tgBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: callback.ID, Text: refusal, ShowAlert: true})
+		return
+	}
+	calls, _, err := b.sessionTools(ctx, sessionID)
+	if err != nil {
+		log.Printf("[handleToolCallback] Error: %v", err)
+		answer("Failed to get messages")
+		return
+	}
+	for _, call := range calls {
+		if call.ID == partID {
+			answer("")
+			b.sendToolOutput(ctx, tgBot, chatID, call)
+			return
+		}
+	}
+	answer("That tool call is no longer in the current session")
+}
+
+// sendToolOutput sends what call produced, or why it failed.
+func (b *Bot) sendToolOutput(ctx context.Context, tgBot *bot.Bot, chatID int64, call opencode.ToolCall) {
+	header := fmt.Sprintf("🔧 %s %s (%s)", call.Tool, toolRef(call.ID), call.Status)
+	if call.Title != "" {
+		header += "\n" + call.Title
+	}
+	if command := toolCommandLine(call); command != "" && command != call.Title {
+		header += "\n$ " + command
+	}
+	body := call.Output
+	switch {
+	case call.Error != "":
+		body = "Error: " + call.Error
+	case call.Status == "pending" || call.Status == "running":
+		body = "Still running; no output yet."
+		if call.Progress != "" {
+			body = call.Progress + "\n\n(still running)"
+		}
+	case body == "":
+		body = "(no output)"
+	}
+
+	text := header + "\n\n" + body
+	if tgtext.Len(text) <= b.Config.MaxMessageLen {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+		return
+	}
+	caption := tgtext.Clip(header, 1000)
+	if _, err := tgBot.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:   chatID,
+		Document: &models.InputFileUpload{Filename: fmt.Sprintf("%s-%s.txt", call.Tool, toolRef(call.ID)), Data: strings.NewReader(body)},
+		Caption:  caption,
+	}); err != nil {
+		log.Printf("[sendToolOutput] Error sending document: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(text)})
+	}
+}
+
+// toolCommandLine is the command a bash call ran, if call is one.
+func toolCommandLine(call opencode.ToolCall) string {
+	var input struct {
+		Command string `json:"command"`
+	}
+	if len(call.Input) == 0 || json.Unmarshal(call.Input, &input) != nil {
+		return ""
+	}
+	return input.Command
+}
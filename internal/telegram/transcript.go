@@ -0,0 +1,92 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/tgtext"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// transcriptPromptLen is how much of a prompt the transcript quotes, so a
+// pasted log doesn't crowd out the reply.
+const transcriptPromptLen = 1000
+
+// transcriptPrompt is a chat's running prompt, kept until its reply is
+// final and both are posted to TRANSCRIPT_CHAT.
+type transcriptPrompt struct {
+	userID    int64
+	sessionID string
+	text      string
+}
+
+func (b *Bot) transcriptOn() bool {
+	return b.Config != nil && b.Config.TranscriptChat != 0
+}
+
+// rememberSender records how the transcript names the sender of a prompt:
+// their name and @username, as far as they have them.
+func (b *Bot) rememberSender(from *models.User) {
+	if from == nil || !b.transcriptOn() {
+		return
+	}
+	name := strings.TrimSpace(from.FirstName + " " + from.LastName)
+	switch {
+	case from.Username == "":
+	case name == "":
+		name = "@" + from.Username
+	default:
+		name += " (@" + from.Username + ")"
+	}
+	if name != "" {
+		b.senders.Store(from.ID, name)
+	}
+}
+
+// transcribePrompt keeps the prompt sent to sessionID until its reply is
+// final.
+func (b *Bot) transcribePrompt(chatID, userID int64, sessionID, text string) {
+	if !b.transcriptOn() || chatID == b.Config.TranscriptChat {
+		return
+	}
+	b.transcripts.Store(chatID, transcriptPrompt{userID: userID, sessionID: sessionID, text: text})
+}
+
+// postTranscript posts the chat's final reply and the prompt it answers to
+// TRANSCRIPT_CHAT, with who asked, in which chat and in which session. It
+// runs on the completion hook's goroutine.
+func (b *Bot) postTranscript(ctx context.Context, tgBot *bot.Bot, chatID int64) {
+	v, ok := b.transcripts.LoadAndDelete(chatID)
+	if !ok || b.DB == nil {
+		return
+	}
+	p := v.(transcriptPrompt)
+	reply, err := b.DB.GetMessageText(chatID)
+	if err != nil || reply.SessionID != p.sessionID {
+		log.Printf("[postTranscript] Chat %d: no reply to post: %v", chatID, err)
+		return
+	}
+
+	who := "user " + strconv.FormatInt(p.userID, 10)
+	if name, ok := b.senders.Load(p.userID); ok {
+		who = name.(string)
+	}
+	where := "a private chat"
+	if chatID != p.userID {
+		where = chatName(ctx, tgBot, chatID)
+	}
+	text := fmt.Sprintf("👤 %s in %s\n🧵 Session %s\n\n❓ %s\n\n💬 %s",
+		who, where, p.sessionID, tgtext.Clip(p.text, transcriptPromptLen), reply.Text)
+	if _, err := tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:              b.Config.TranscriptChat,
+		MessageThreadID:     b.Config.TranscriptTopic,
+		Text:                b.truncate(text),
+		DisableNotification: true,
+	}); err != nil {
+		log.Printf("[postTranscript] Chat %d: %v", chatID, err)
+	}
+}
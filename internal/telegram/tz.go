@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// timezoneKey is the chat setting holding the chat's IANA time zone.
+const timezoneKey = "tz"
+
+// location returns the chat's time zone, or the server's when unset.
+func (b *Bot) location(chatID int64) *time.Location {
+	if b.DB == nil {
+		return time.Local
+	}
+	name, err := b.DB.GetChatSetting(chatID, timezoneKey)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[location] Chat %d: %v", chatID, err)
+		}
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("[location] Chat %d has unknown zone %q: %v", chatID, name, err)
+		return time.Local
+	}
+	return loc
+}
+
+// formatTime renders t in the chat's time zone; zero times render as "-".
+func (b *Bot) formatTime(chatID int64, t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.In(b.location(chatID)).Format("2006-01-02 15:04 MST")
+}
+
+// tzCommand sets the time zone used for times shown in this chat.
+func (b *Bot) tzCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	name := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/tz"))
+	switch name {
+	case "":
+		loc := b.location(chatID)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "Time zone: " + loc.String() + " (now " + time.Now().In(loc).Format("15:04 MST") + ")\nUse /tz <zone>, e.g. /tz Europe/Berlin, or /tz off for server time.",
+		})
+		return
+	case "off":
+		name = ""
+	default:
+		if _, err := time.LoadLocation(name); err != nil || strings.EqualFold(name, "local") {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Unknown time zone " + name + ". Use an IANA name such as Europe/Berlin or America/New_York."})
+			return
+		}
+	}
+
+	if err := b.DB.SetChatSetting(chatID, timezoneKey, name); err != nil {
+		log.Printf("[tzCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	text := "Times are shown in server time"
+	if name != "" {
+		text = "Times are shown in " + name + " (now " + time.Now().In(b.location(chatID)).Format("15:04 MST") + ")"
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+}
@@ -0,0 +1,261 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/errreport"
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// watchKey is the chat setting holding the directory /watch watches.
+	watchKey = "watch"
+	// watchDebounce gathers the changes of a save or checkout into one
+	// notice.
+	watchDebounce = 10 * time.Second
+	// watchGrace is how long after a reply finishes file changes are still
+	// put down to the agent, as the watcher reports them late.
+	watchGrace = 5 * time.Second
+	// maxWatchFiles caps the files a notice lists.
+	maxWatchFiles = 20
+)
+
+// watchState holds the chats watching a directory and the changes not yet
+// reported to them.
+type watchState struct {
+	mu      sync.Mutex
+	loaded  bool
+	dirs    map[int64]string            // chat ID -> watched directory
+	changes map[int64]map[string]string // chat ID -> file -> latest event
+	ranTill map[int64]time.Time         // chat ID -> when its last reply finished
+	timer   *time.Timer
+}
+
+type watchHook struct {
+	b     *Bot
+	tgBot *bot.Bot
+}
+
+// Files returns the stream's FileObserver: it tells chats that /watch
+// their directory about changes made outside the agent's runs.
+func (b *Bot) Files(tgBot *bot.Bot) opencode.FileObserver {
+	return watchHook{b: b, tgBot: tgBot}
+}
+
+// FileChanged runs on the SSE reader; the watchers are loaded from the
+// store on first use, so it hands off to its own goroutine.
+func (h watchHook) FileChanged(file, event string) {
+	go func() {
+		defer errreport.Recover(errreport.Fields{"goroutine": "watch", "file": file, "event": event})
+		h.b.fileChanged(h.tgBot, file, event)
+	}()
+}
+
+// loadWatches reads the watching chats from the store once. Callers hold
+// w.mu.
+func (b *Bot) loadWatches() {
+	w := &b.watch
+	if w.loaded {
+		return
+	}
+	w.dirs = make(map[int64]string)
+	w.changes = make(map[int64]map[string]string)
+	w.ranTill = make(map[int64]time.Time)
+	if b.DB == nil {
+		w.loaded = true
+		return
+	}
+	sessions, err := b.chatSessions()
+	if err != nil {
+		log.Printf("[loadWatches] Error: %v", err)
+		return
+	}
+	for _, s := range sessions {
+		if dir := b.chatSetting(s.ChatID, watchKey); dir != "" {
+			w.dirs[s.ChatID] = dir
+		}
+	}
+	w.loaded = true
+}
+
+// setWatch starts or, with dir "", stops watching dir for the chat.
+func (b *Bot) setWatch(chatID int64, dir string) error {
+	if err := b.DB.SetChatSetting(chatID, watchKey, dir); err != nil {
+		return err
+	}
+	w := &b.watch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b.loadWatches()
+	if dir == "" {
+		delete(w.dirs, chatID)
+		delete(w.changes, chatID)
+	} else {
+		w.dirs[chatID] = dir
+	}
+	return nil
+}
+
+// watchRunEnded notes that the chat's reply just finished, so the changes
+// the watcher reports right after are put down to the agent.
+func (b *Bot) watchRunEnded(chatID int64) {
+	w := &b.watch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ranTill != nil {
+		w.ranTill[chatID] = time.Now()
+	}
+}
+
+// agentBusy reports whether changes in the chat's directory are likely the
+// agent's own: a reply or /run is in progress or has only just finished.
+// Callers hold b.watch.mu.
+func (b *Bot) agentBusy(chatID int64) bool {
+	if b.Stream != nil {
+		if _, streaming, _ := b.Stream.CurrentText(chatID); streaming {
+			return true
+		}
+	}
+	if _, running := b.commandRuns.Load(chatID); running {
+		return true
+	}
+	return time.Since(b.watch.ranTill[chatID]) < watchGrace
+}
+
+// fileChanged queues the change for every chat watching a directory that
+// holds file, unless the agent is at work there.
+func (b *Bot) fileChanged(tgBot *bot.Bot, file, event string) {
+	w := &b.watch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b.loadWatches()
+	queued := false
+	for chatID, dir := range w.dirs {
+		rel := file
+		if filepath.IsAbs(file) {
+			r, err := filepath.Rel(dir, file)
+			if err != nil || r == ".." || strings.HasPrefix(r, "../") {
+				continue
+			}
+			rel = r
+		}
+		if b.agentBusy(chatID) {
+			continue
+		}
+		if w.changes[chatID] == nil {
+			w.changes[chatID] = make(map[string]string)
+		}
+		w.changes[chatID][rel] = event
+		queued = true
+	}
+	if queued && w.timer == nil {
+		w.timer = time.AfterFunc(watchDebounce, func() { b.flushWatches(tgBot) })
+	}
+}
+
+// flushWatches sends each watching chat the changes gathered since the
+// last notice.
+func (b *Bot) flushWatches(tgBot *bot.Bot) {
+	w := &b.watch
+	w.mu.Lock()
+	pending := w.changes
+	w.changes = make(map[int64]map[string]string)
+	w.timer = nil
+	dirs := make(map[int64]string, len(pending))
+	for chatID := range pending {
+		// A run that started since is likely to have made them.
+		if b.agentBusy(chatID) {
+			delete(pending, chatID)
+			continue
+		}
+		dirs[chatID] = w.dirs[chatID]
+	}
+	w.mu.Unlock()
+
+	ctx := context.Background()
+	for chatID, files := range pending {
+		b.sendNotice(ctx, tgBot, chatID, watchText(dirs[chatID], files))
+	}
+}
+
+// watchText lists the changed files, e.g. "✏️ main.go".
+func watchText(dir string, files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "👀 %d file%s changed in %s outside a run:\n", len(names), plural(len(names)), dir)
+	for i, name := range names {
+		if i == maxWatchFiles {
+			fmt.Fprintf(&sb, "… and %d more\n", len(names)-maxWatchFiles)
+			break
+		}
+		icon := "✏️"
+		switch files[name] {
+		case "add":
+			icon = "➕"
+		case "unlink":
+			icon = "🗑"
+		}
+		fmt.Fprintf(&sb, "%s %s\n", icon, name)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// watchCommand turns file-change notices on or off: while on, the chat
+// hears about files in the session's directory that change when the agent
+// isn't running, e.g. edits made by someone sharing the checkout.
+func (b *Bot) watchCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	switch arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/watch")); arg {
+	case "":
+		text := "Not watching. /watch on reports files in the session's directory that change outside a run."
+		if dir := b.chatSetting(chatID, watchKey); dir != "" {
+			text = "Watching " + dir + " for changes outside a run. /watch off stops."
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+	case "on":
+		if refusal := b.lsRefusal(chatID); refusal != "" {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+			return
+		}
+		dir := b.projectDir(ctx, chatID)
+		if dir == "" {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No working directory yet. Send a message first."})
+			return
+		}
+		if err := b.setWatch(chatID, dir); err != nil {
+			log.Printf("[watchCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Watching " + dir + ": files changed while the agent isn't running are reported here."})
+	case "off":
+		if err := b.setWatch(chatID, ""); err != nil {
+			log.Printf("[watchCommand] Error: %v", err)
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+			return
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Stopped watching"})
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /watch on|off"})
+	}
+}
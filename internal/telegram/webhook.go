@@ -0,0 +1,149 @@
+package telegram
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/metrics"
+)
+
+var webhookRejected = metrics.NewCounter("openkh_webhook_rejected_total",
+	"Webhook requests refused by the listener, by reason.", "reason")
+
+// Rejection reasons, as the "reason" label of openkh_webhook_rejected_total.
+const (
+	rejectMethod   = "method"
+	rejectPath     = "path"
+	rejectSourceIP = "source_ip"
+	rejectSecret   = "secret"
+	rejectTooLarge = "too_large"
+	rejectBody     = "bad_body"
+)
+
+// rejectLogInterval limits rejection logs to one per reason per interval,
+// so a scanner can't flood the log.
+const rejectLogInterval = time.Minute
+
+// WebhookOptions hardens the webhook listener.
+type WebhookOptions struct {
+	Path           string       // the WEBHOOK_URL path; other paths get 404 ("" accepts any)
+	Secret         string       // expected X-Telegram-Bot-Api-Secret-Token ("" skips the check)
+	AllowedIPs     []*net.IPNet // source networks accepted (nil accepts any)
+	TrustedProxies []*net.IPNet // peers whose X-Forwarded-For names the real source
+	MaxBody        int64        // larger bodies get 413
+}
+
+type webhookGuard struct {
+	next http.Handler
+	opts WebhookOptions
+
+	mu     sync.Mutex
+	logged map[string]time.Time // reason -> last log line
+}
+
+// GuardWebhook wraps the webhook handler so only well-formed POSTs from
+// Telegram reach it. Refused requests are counted by reason.
+func GuardWebhook(next http.Handler, opts WebhookOptions) http.Handler {
+	return &webhookGuard{next: next, opts: opts, logged: make(map[string]time.Time)}
+}
+
+func (g *webhookGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r, g.opts.TrustedProxies)
+	if g.opts.Path != "" && r.URL.Path != g.opts.Path {
+		g.reject(w, ip, rejectPath, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		g.reject(w, ip, rejectMethod, http.StatusMethodNotAllowed)
+		return
+	}
+	if len(g.opts.AllowedIPs) > 0 && (ip == nil || !inRanges(ip, g.opts.AllowedIPs)) {
+		g.reject(w, ip, rejectSourceIP, http.StatusForbidden)
+		return
+	}
+	if g.opts.Secret != "" {
+		got := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(g.opts.Secret)) != 1 {
+			g.reject(w, ip, rejectSecret, http.StatusUnauthorized)
+			return
+		}
+	}
+	if g.opts.MaxBody > 0 {
+		if r.ContentLength > g.opts.MaxBody {
+			g.reject(w, ip, rejectTooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.opts.MaxBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				g.reject(w, ip, rejectTooLarge, http.StatusRequestEntityTooLarge)
+			} else {
+				g.reject(w, ip, rejectBody, http.StatusBadRequest)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	g.next.ServeHTTP(w, r)
+}
+
+func (g *webhookGuard) reject(w http.ResponseWriter, ip net.IP, reason string, status int) {
+	webhookRejected.Inc(reason)
+	g.mu.Lock()
+	quiet := time.Since(g.logged[reason]) < rejectLogInterval
+	if !quiet {
+		g.logged[reason] = time.Now()
+	}
+	g.mu.Unlock()
+	if !quiet {
+		log.Printf("Warning: webhook rejected a request from %s (%s, %d); further %s rejections are only counted for %s",
+			ip, reason, status, reason, rejectLogInterval)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// clientIP is the request's source address. When the peer is a trusted
+// proxy, it is the rightmost X-Forwarded-For hop that isn't one.
+func clientIP(r *http.Request, trusted []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !inRanges(ip, trusted) {
+		return ip
+	}
+	forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+	if forwarded == "" {
+		return ip
+	}
+	hops := strings.Split(forwarded, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return nil
+		}
+		if !inRanges(hop, trusted) {
+			return hop
+		}
+	}
+	return ip
+}
+
+func inRanges(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/Khaledxab/Openkh/internal/opencode"
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// workDirKey is the chat setting holding the directory /cd chose for new
+// sessions.
+const workDirKey = "workdir"
+
+// dirAllowed reports whether ALLOWED_DIRS permits sessions in dir.
+func (b *Bot) dirAllowed(dir string) bool {
+	return b.Config == nil || b.Config.DirAllowed(dir)
+}
+
+// defaultDir is where new sessions start without a /cd or a repository;
+// see Config.DefaultDir.
+func (b *Bot) defaultDir() string {
+	if b.Config == nil {
+		return ""
+	}
+	return b.Config.DefaultDir()
+}
+
+// sessionDir is the directory new sessions in the chat start in: the /cd
+// target, else the chat's repository (/repo, CHAT_REPOS). A /cd target
+// that ALLOWED_DIRS no longer permits is ignored.
+func (b *Bot) sessionDir(chatID int64) string {
+	fallback := b.defaultDir()
+	if _, dir := b.chatRepo(chatID); dir != "" {
+		fallback = dir
+	}
+	if b.DB == nil {
+		return fallback
+	}
+	dir, err := b.DB.GetChatSetting(chatID, workDirKey)
+	if err != nil || dir == "" {
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[sessionDir] Chat %d: %v", chatID, err)
+		}
+		return fallback
+	}
+	if !b.dirAllowed(dir) {
+		log.Printf("[sessionDir] Chat %d: ignoring %s, outside ALLOWED_DIRS", chatID, dir)
+		return fallback
+	}
+	return dir
+}
+
+// sessionRefusal explains why ALLOWED_DIRS forbids using sess, or returns
+// "" when it may be used.
+func (b *Bot) sessionRefusal(chatID int64, sess opencode.OCSession) string {
+	if b.dirAllowed(sess.Directory) {
+		return ""
+	}
+	log.Printf("Warning: chat %d refused session %s in %q, outside ALLOWED_DIRS", chatID, sess.ID, sess.Directory)
+	dir := sess.Directory
+	if dir == "" {
+		dir = "an unknown directory"
+	}
+	return fmt.Sprintf("Session %s runs in %s, outside the allowed directories", shortID(sess.ID), dir)
+}
+
+// cdCommand sets the directory new sessions in this chat start in. The
+// current session keeps its directory; /new starts one in the new place.
+func (b *Bot) cdCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	current := b.sessionDir(chatID)
+	target := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/cd"))
+	if target == "" {
+		text := "New sessions start in the OpenCode server's directory."
+		if current != "" {
+			text = "New sessions start in " + current + "."
+		}
+		if b.Config != nil && len(b.Config.AllowedDirs) > 0 {
+			text += "\nAllowed: " + strings.Join(b.Config.AllowedDirs, ", ")
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text + "\nUse /cd <path> to change it."})
+		return
+	}
+	if b.DB == nil {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "/cd needs a store to remember the directory"})
+		return
+	}
+
+	if !filepath.IsAbs(target) {
+		if current == "" {
+			tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Use an absolute path, e.g. /cd /srv/project"})
+			return
+		}
+		target = filepath.Join(current, target)
+	}
+	target = filepath.Clean(target)
+	if !b.dirAllowed(target) {
+		log.Printf("Warning: chat %d refused /cd to %s, outside ALLOWED_DIRS", chatID, target)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: target + " is outside the allowed directories"})
+		return
+	}
+
+	if err := b.DB.SetChatSetting(chatID, workDirKey, target); err != nil {
+		log.Printf("[cdCommand] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to save setting"})
+		return
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   "New sessions will start in " + target + ". Send /new to start one there.",
+	})
+}
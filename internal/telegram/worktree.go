@@ -0,0 +1,132 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Khaledxab/Openkh/internal/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// worktreeName is what /worktree new accepts as a branch: git's rules,
+// roughly, minus anything that could be read as an option.
+var worktreeName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_./-]{0,99}$`)
+
+// worktreeCommand manages git worktrees through OpenCode: "/worktree
+// list" shows those of the current project, "/worktree new <branch>"
+// checks out a new one and starts a session in it, so chats working on the
+// same repository don't share a working tree.
+func (b *Bot) worktreeCommand(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.requireAuth(chatID, tgBot, ctx) {
+		return
+	}
+
+	args := strings.Fields(strings.TrimPrefix(update.Message.Text, "/worktree"))
+	switch {
+	case len(args) == 0 || args[0] == "list" && len(args) == 1:
+		b.listWorktrees(ctx, tgBot, chatID)
+	case args[0] == "new" && len(args) == 2:
+		b.newWorktree(ctx, tgBot, chatID, args[1])
+	default:
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Usage: /worktree list|new <branch>"})
+	}
+}
+
+// projectDir is the directory of the chat's current session, or where
+// its next session would start.
+func (b *Bot) projectDir(ctx context.Context, chatID int64) string {
+	if sessionID := b.currentSessionID(chatID); sessionID != "" {
+		if oc, err := b.Client.GetOCSession(ctx, sessionID); err == nil && oc.Directory != "" {
+			return oc.Directory
+		}
+	}
+	return b.sessionDir(chatID)
+}
+
+func (b *Bot) listWorktrees(ctx context.Context, tgBot *bot.Bot, chatID int64) {
+	dir := b.projectDir(ctx, chatID)
+	dirs, err := b.Client.ListWorktrees(ctx, dir)
+	if err != nil {
+		log.Printf("[listWorktrees] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to list worktrees"})
+		return
+	}
+	if len(dirs) == 0 {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "No worktrees yet. Create one with /worktree new <branch>"})
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Worktrees (%d):\n", len(dirs))
+	for _, d := range dirs {
+		mark := "  "
+		if d == dir {
+			mark = "▶ "
+		}
+		sb.WriteString(mark + d + "\n")
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: b.truncate(strings.TrimSuffix(sb.String(), "\n"))})
+}
+
+// newWorktree checks out branch in a new worktree of the current project
+// and switches the chat to a new session there.
+func (b *Bot) newWorktree(ctx context.Context, tgBot *bot.Bot, chatID int64, branch string) {
+	if !worktreeName.MatchString(branch) || strings.Contains(branch, "..") || strings.HasSuffix(branch, ".lock") {
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Invalid branch name: " + branch})
+		return
+	}
+	wt, err := b.Client.CreateWorktree(ctx, b.projectDir(ctx, chatID), branch)
+	if err != nil {
+		log.Printf("[newWorktree] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Failed to create the worktree: " + err.Error()})
+		return
+	}
+	if !b.dirAllowed(wt.Directory) {
+		log.Printf("Warning: chat %d: worktree %s is outside ALLOWED_DIRS", chatID, wt.Directory)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("Created the worktree in %s, but it is outside the allowed directories, so no session was started there", wt.Directory),
+		})
+		return
+	}
+
+	oc, err := b.Client.CreateOCSession(ctx, branch, wt.Directory)
+	if err != nil {
+		log.Printf("[newWorktree] Error: %v", err)
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "Created the worktree in " + wt.Directory + ", but failed to start a session there"})
+		return
+	}
+	if refusal := b.sessionRefusal(chatID, oc); refusal != "" {
+		if err := b.Client.DeleteOCSession(ctx, oc.ID); err != nil {
+			log.Printf("[newWorktree] Error deleting refused session: %v", err)
+		}
+		tgBot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: refusal})
+		return
+	}
+	sess := store.Session{
+		ChatID:    chatID,
+		SessionID: oc.ID,
+		Title:     oc.Title,
+		CreatedAt: time.Now(),
+		LastUsed:  time.Now(),
+	}
+	if err := b.DB.SetSession(sess); err != nil {
+		log.Printf("[newWorktree] Error saving session: %v", err)
+	}
+	name := wt.Branch
+	if name == "" {
+		name = branch
+	}
+	tgBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("Checked out %s in %s.\nSwitched to a new session there: %s", name, wt.Directory, shortID(oc.ID)),
+	})
+}
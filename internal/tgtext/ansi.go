@@ -0,0 +1,92 @@
+package tgtext
+
+import "strings"
+
+const esc = '\x1b'
+
+// StripANSI removes the ANSI escape sequences test runners and linters
+// color their output with, which Telegram shows as garbage: CSI
+// sequences such as colors and cursor moves, OSC sequences such as
+// terminal titles and hyperlinks (whose text is kept), and two-character
+// escapes. A sequence cut off at the end of s is dropped too.
+func StripANSI(s string) string {
+	if strings.IndexByte(s, esc) < 0 {
+		return s
+	}
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); {
+		if s[i] != esc {
+			j := strings.IndexByte(s[i:], esc)
+			if j < 0 {
+				j = len(s) - i
+			}
+			sb.WriteString(s[i : i+j])
+			i += j
+			continue
+		}
+		n, _ := escapeLen(s[i:])
+		i += n
+	}
+	return sb.String()
+}
+
+// ANSITail returns where an escape sequence cut off at the end of s
+// starts, or len(s) if there is none. Text streamed in pieces keeps
+// s[ANSITail(s):] to prepend to the next piece, so a sequence split
+// between two is still recognized.
+func ANSITail(s string) int {
+	for i := 0; i < len(s); {
+		j := strings.IndexByte(s[i:], esc)
+		if j < 0 {
+			break
+		}
+		i += j
+		n, complete := escapeLen(s[i:])
+		if !complete {
+			return i
+		}
+		i += n
+	}
+	return len(s)
+}
+
+// escapeLen returns the length of the escape sequence s starts with, and
+// whether it is complete rather than cut off by the end of s.
+func escapeLen(s string) (n int, complete bool) {
+	if len(s) < 2 {
+		return len(s), false
+	}
+	switch s[1] {
+	case '[': // CSI: parameters and intermediates, then a final byte
+		for i := 2; i < len(s); i++ {
+			if c := s[i]; c >= 0x40 && c <= 0x7e {
+				return i + 1, true
+			} else if c < 0x20 || c > 0x3f {
+				// Not a CSI sequence after all; drop the introducer only.
+				return i, true
+			}
+		}
+		return len(s), false
+	case ']': // OSC: ends with BEL or ESC \
+		for i := 2; i < len(s); i++ {
+			switch {
+			case s[i] == '\a':
+				return i + 1, true
+			case s[i] == esc && i+1 < len(s) && s[i+1] == '\\':
+				return i + 2, true
+			case s[i] == '\n':
+				// Never terminated; don't let it swallow the next lines.
+				return i, true
+			}
+		}
+		return len(s), false
+	default: // intermediates, then a final byte, as in ESC ( B
+		for i := 1; i < len(s); i++ {
+			if c := s[i]; c < 0x20 || c > 0x2f {
+				return i + 1, true
+			}
+		}
+		return len(s), false
+	}
+}
@@ -0,0 +1,77 @@
+package tgtext
+
+import "testing"
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "ok", "ok"},
+		{"color", "\x1b[31mFAIL\x1b[0m: x", "FAIL: x"},
+		{"bold and color", "\x1b[1;32m✓\x1b[39;22m passed", "✓ passed"},
+		{"cursor moves", "a\x1b[2K\x1b[1Gb", "ab"},
+		{"private mode", "\x1b[?25lspinner\x1b[?25h", "spinner"},
+		{"title", "\x1b]0;go test\x07done", "done"},
+		{"hyperlink keeps its text", "\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\ here", "link here"},
+		{"charset", "\x1b(Bbox", "box"},
+		{"keypad", "\x1b=x\x1b>", "x"},
+		{"unterminated OSC stops at the line", "\x1b]0;title\nnext", "\nnext"},
+		{"not CSI after all", "\x1b[\x01x", "\x01x"},
+		// Cut off at the end of the text.
+		{"lone escape", "done\x1b", "done"},
+		{"partial CSI", "done\x1b[3", "done"},
+		{"partial CSI introducer", "done\x1b[", "done"},
+		{"partial OSC", "done\x1b]8;;https://exa", "done"},
+		{"partial OSC terminator", "done\x1b]0;t\x1b", "done"},
+		{"partial charset", "done\x1b(", "done"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripANSI(tt.s); got != tt.want {
+				t.Errorf("StripANSI(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestANSITail(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"plain", "done", 4},
+		{"complete sequences", "\x1b[31mred\x1b[0m", 12},
+		{"lone escape", "done\x1b", 4},
+		{"partial CSI", "done\x1b[3", 4},
+		{"partial CSI after a complete one", "\x1b[1mx\x1b[", 5},
+		{"partial OSC", "a\x1b]8;;https://exa", 1},
+		{"OSC waiting for the backslash", "a\x1b]0;t\x1b", 1},
+		{"unterminated OSC ended by a line", "\x1b]0;t\nx", 7},
+		{"partial charset", "a\x1b(", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ANSITail(tt.s); got != tt.want {
+				t.Errorf("ANSITail(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+// A sequence split between two streamed pieces is stripped whole when the
+// tail is carried over, wherever the split falls.
+func TestANSISplitAcrossPieces(t *testing.T) {
+	const s = "ok \x1b[1;31mFAIL\x1b[0m \x1b]8;;https://x.io\x1b\\link\x1b]8;;\x1b\\ end"
+	const want = "ok FAIL link end"
+	for split := 0; split <= len(s); split++ {
+		first := s[:split]
+		tail := ANSITail(first)
+		got := StripANSI(first[:tail]) + StripANSI(first[tail:]+s[split:])
+		if got != want {
+			t.Errorf("split at %d: %q, want %q", split, got, want)
+		}
+	}
+}
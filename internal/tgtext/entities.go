@@ -0,0 +1,132 @@
+package tgtext
+
+import "strings"
+
+// Entity types Entities produces, named as the Bot API names them.
+const (
+	EntityBold = "bold"
+	EntityCode = "code"
+	EntityPre  = "pre"
+)
+
+// Entity is a formatted span of message text, as a Telegram
+// MessageEntity: Offset and Length count UTF-16 code units.
+type Entity struct {
+	Type     string
+	Offset   int
+	Length   int
+	Language string // of a pre block, if its fence named one
+}
+
+// Entities turns the Markdown a reply is written in into plain text and
+// the entities formatting it: fenced code blocks become pre, code spans
+// code and **text** bold. Sent that way nothing is parsed, so a reply
+// full of backticks and underscores can't fail with "can't parse
+// entities" the way a parse mode can. Markers that don't pair up, and
+// all other Markdown, are left as they are.
+func Entities(s string) (string, []Entity) {
+	e := entityWriter{}
+	var fence string // run of the open code block
+	var block Entity // the open code block
+	for _, line := range strings.SplitAfter(s, "\n") {
+		body := strings.TrimSuffix(line, "\n")
+		trimmed := strings.TrimLeft(body, " ")
+		f := fenceRun(trimmed)
+		indented := len(body)-len(trimmed) >= 4
+		if fence != "" {
+			if f != "" && !indented && f[0] == fence[0] && len(f) >= len(fence) && strings.TrimSpace(trimmed[len(f):]) == "" {
+				e.closeBlock(block)
+				fence = ""
+				continue
+			}
+			e.write(line)
+			continue
+		}
+		if f != "" && !indented {
+			fence = f
+			block = Entity{Type: EntityPre, Offset: e.len, Language: strings.TrimSpace(trimmed[len(f):])}
+			continue
+		}
+		e.inline(line)
+	}
+	if fence != "" {
+		e.closeBlock(block)
+	}
+	return e.sb.String(), e.entities
+}
+
+// entityWriter builds the plain text and entities of Entities.
+type entityWriter struct {
+	sb       strings.Builder
+	len      int // of sb, in UTF-16 code units
+	entities []Entity
+}
+
+func (e *entityWriter) write(s string) {
+	e.sb.WriteString(s)
+	e.len += Len(s)
+}
+
+// span writes s as an entity of type typ.
+func (e *entityWriter) span(typ, s string) {
+	e.entities = append(e.entities, Entity{Type: typ, Offset: e.len, Length: Len(s)})
+	e.write(s)
+}
+
+// closeBlock ends the code block opened as block, without the newline
+// before its closing fence, which Telegram would show as an empty line.
+func (e *entityWriter) closeBlock(block Entity) {
+	text := e.sb.String()
+	if strings.HasSuffix(text, "\n") && e.len > block.Offset {
+		e.sb.Reset()
+		e.sb.WriteString(text[:len(text)-1])
+		e.len--
+		defer e.write("\n")
+	}
+	if block.Length = e.len - block.Offset; block.Length > 0 {
+		e.entities = append(e.entities, block)
+	}
+}
+
+// inline writes a line outside code blocks, turning its code spans and
+// bold text into entities.
+func (e *entityWriter) inline(line string) {
+	for i := 0; i < len(line); {
+		switch {
+		case line[i] == '`':
+			n := markerRun(line, i)
+			run := line[i : i+n]
+			if end := strings.Index(line[i+n:], run); end > 0 && markerRun(line, i+n+end) == n {
+				code := line[i+n : i+n+end]
+				if strings.TrimSpace(code) != "" && !strings.Contains(code, "\n") {
+					e.span(EntityCode, code)
+					i += n + end + n
+					continue
+				}
+			}
+			e.write(run)
+			i += n
+		case strings.HasPrefix(line[i:], "**"):
+			if end := strings.Index(line[i+2:], "**"); end > 0 {
+				bold := line[i+2 : i+2+end]
+				if strings.TrimSpace(bold) == bold && !strings.ContainsAny(bold, "`\n") {
+					e.span(EntityBold, bold)
+					i += 2 + end + 2
+					continue
+				}
+			}
+			e.write("**")
+			i += 2
+		default:
+			j := strings.IndexAny(line[i:], "`*")
+			if j < 0 {
+				j = len(line) - i
+			} else if j == 0 {
+				// A single *.
+				j = 1
+			}
+			e.write(line[i : i+j])
+			i += j
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package tgtext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEntities(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		want     string
+		entities []Entity
+	}{
+		{"plain", "no formatting here", "no formatting here", nil},
+		{"code span", "run `go test` now", "run go test now", []Entity{{Type: EntityCode, Offset: 4, Length: 7}}},
+		{"bold", "a **big** deal", "a big deal", []Entity{{Type: EntityBold, Offset: 2, Length: 3}}},
+		// Offsets and lengths count UTF-16 code units, not bytes or runes.
+		{"after multi-byte runes", "héllo `x`", "héllo x", []Entity{{Type: EntityCode, Offset: 6, Length: 1}}},
+		{"after an emoji", "😀 **hi**", "😀 hi", []Entity{{Type: EntityBold, Offset: 3, Length: 2}}},
+		{"emoji inside", "`a😀b` **日本**", "a😀b 日本", []Entity{
+			{Type: EntityCode, Offset: 0, Length: 4},
+			{Type: EntityBold, Offset: 5, Length: 2},
+		}},
+		{"pre with a language", "Run:\n```go\nx := \"😀\"\n```\ndone", "Run:\nx := \"😀\"\ndone", []Entity{
+			{Type: EntityPre, Offset: 5, Length: 9, Language: "go"},
+		}},
+		{"pre after an emoji line", "👍🏽\n```\ncode\n```", "👍🏽\ncode\n", []Entity{
+			{Type: EntityPre, Offset: 5, Length: 4},
+		}},
+		{"unclosed pre runs to the end", "```\nhalf\nway", "half\nway", []Entity{{Type: EntityPre, Offset: 0, Length: 8}}},
+		{"empty pre is dropped", "a\n```\n```\nb", "a\nb", nil},
+		{"markdown inside pre stays", "```\n**x** `y`\n```", "**x** `y`\n", []Entity{{Type: EntityPre, Offset: 0, Length: 9}}},
+		{"double backtick span", "``a ` b``", "a ` b", []Entity{{Type: EntityCode, Offset: 0, Length: 5}}},
+		{"unpaired markers stay", "a `b and **c", "a `b and **c", nil},
+		{"blank code span stays", "a ` ` b", "a ` ` b", nil},
+		{"bold with inner spaces at the edge stays", "** x**", "** x**", nil},
+		{"single stars stay", "2 * 3 * 4", "2 * 3 * 4", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, entities := Entities(tt.s)
+			if got != tt.want {
+				t.Errorf("Entities(%q) text = %q, want %q", tt.s, got, tt.want)
+			}
+			if !reflect.DeepEqual(entities, tt.entities) {
+				t.Errorf("Entities(%q) entities = %+v, want %+v", tt.s, entities, tt.entities)
+			}
+			for _, e := range entities {
+				if e.Offset < 0 || e.Length <= 0 || e.Offset+e.Length > Len(got) {
+					t.Errorf("entity %+v out of the text's %d code units", e, Len(got))
+				}
+			}
+		})
+	}
+}
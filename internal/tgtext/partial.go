@@ -0,0 +1,171 @@
+package tgtext
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// openMarker is an emphasis marker waiting for its closing twin: "**",
+// "*", "_", "~~" and so on, and its byte offset.
+type openMarker struct {
+	run string
+	at  int
+}
+
+// CloseMarkdown closes what a half-written Markdown reply leaves open at
+// its end, so an intermediate render of it still parses: a fenced code
+// block, a code span, and bold, italic or strikethrough markers, innermost
+// first. Markers with nothing after them yet are dropped rather than
+// closed. Unclosed markers of earlier paragraphs are left alone, as
+// Markdown shows those literally. Text that is already balanced is
+// returned unchanged.
+func CloseMarkdown(s string) string {
+	var fence string // run opening the code block s ends in
+	var code string  // backticks opening the code span s ends in
+	var codeAt int   // offset of code
+	var open []openMarker
+	offset := 0
+	for _, line := range strings.SplitAfter(s, "\n") {
+		start := offset
+		offset += len(line)
+		body := strings.TrimSuffix(line, "\n")
+		trimmed := strings.TrimLeft(body, " ")
+		f := fenceRun(trimmed)
+		indented := len(body)-len(trimmed) >= 4
+		if fence != "" {
+			if f != "" && !indented && f[0] == fence[0] && len(f) >= len(fence) && strings.TrimSpace(trimmed[len(f):]) == "" {
+				fence = ""
+			}
+			continue
+		}
+		if f != "" && !indented {
+			fence, code, open = f, "", nil
+			continue
+		}
+		if strings.TrimSpace(body) == "" {
+			// A paragraph ends; whatever it left open stays literal.
+			code, open = "", nil
+			continue
+		}
+
+		last := offset == len(s)
+		for i := 0; i < len(body); {
+			c := body[i]
+			switch {
+			case c == '\\' && code == "":
+				i += 2
+				continue
+			case c == '`':
+				n := markerRun(body, i)
+				switch {
+				case code == "":
+					code, codeAt = body[i:i+n], start+i
+				case body[i:i+n] == code:
+					code = ""
+				}
+				i += n
+				continue
+			case code != "" || (c != '*' && c != '_' && c != '~'):
+				i++
+				continue
+			}
+
+			n := markerRun(body, i)
+			run := body[i : i+n]
+			if (c == '~' && n != 2) || n > 3 {
+				i += n
+				continue
+			}
+			before, _ := utf8.DecodeLastRuneInString(body[:i])
+			after, _ := utf8.DecodeRuneInString(body[i+n:])
+			if i == 0 {
+				before = ' '
+			}
+			atEnd := last && i+n == len(body)
+			if i+n == len(body) {
+				after = ' '
+			}
+			// Markers hug the text they mark; "_" and openers don't go
+			// inside words, so snake_case and 2*3 stay plain.
+			canClose := !unicode.IsSpace(before) && (c != '_' || !isWordRune(after))
+			canOpen := (atEnd || !unicode.IsSpace(after)) && !isWordRune(before)
+			if k := lastOpen(open, run); canClose && k >= 0 {
+				open = open[:k]
+			} else if canOpen {
+				open = append(open, openMarker{run: run, at: start + i})
+			}
+			i += n
+		}
+	}
+
+	if fence != "" {
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		return s + fence
+	}
+	var closers string
+	if code != "" {
+		if codeAt+len(code) == len(s) {
+			s = s[:codeAt]
+		} else {
+			closers = code
+		}
+	}
+	if closers == "" {
+		for len(open) > 0 {
+			top := open[len(open)-1]
+			trimmed := strings.TrimRight(s, " \t\n")
+			if top.at+len(top.run) != len(trimmed) {
+				break
+			}
+			s, open = s[:top.at], open[:len(open)-1]
+		}
+		if len(open) > 0 {
+			// A closer after whitespace wouldn't close anything.
+			s = strings.TrimRight(s, " \t\n")
+		}
+	}
+	for k := len(open) - 1; k >= 0; k-- {
+		closers += open[k].run
+	}
+	return s + closers
+}
+
+// fenceRun returns the run of three or more backticks or tildes line
+// starts with, or "" if it isn't a code fence.
+func fenceRun(line string) string {
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return ""
+	}
+	n := markerRun(line, 0)
+	if n < 3 {
+		return ""
+	}
+	return line[:n]
+}
+
+// markerRun is the length of the run of s[i] starting at i.
+func markerRun(s string, i int) int {
+	n := 1
+	for i+n < len(s) && s[i+n] == s[i] {
+		n++
+	}
+	return n
+}
+
+// lastOpen returns the index of the innermost marker opened with run, or
+// -1 if none is.
+func lastOpen(open []openMarker, run string) int {
+	for k := len(open) - 1; k >= 0; k-- {
+		if open[k].run == run {
+			return k
+		}
+	}
+	return -1
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,57 @@
+package tgtext
+
+import "testing"
+
+func TestCloseMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "Hello, world", "Hello, world"},
+		{"balanced", "Some **bold**, `code` and\n```go\nx := 1\n```\n", "Some **bold**, `code` and\n```go\nx := 1\n```\n"},
+
+		// Fences.
+		{"open fence", "Run:\n```sh\ngo test", "Run:\n```sh\ngo test\n```"},
+		{"open fence ending in a newline", "```\nx\n", "```\nx\n```"},
+		{"open fence without a line yet", "Run:\n```", "Run:\n```\n```"},
+		{"longer fence closed by its own length", "````\n```\nstill code", "````\n```\nstill code\n````"},
+		{"tilde fence", "~~~\ncode", "~~~\ncode\n~~~"},
+		{"fence inside a line is a code span", "Use ```x", "Use ```x```"},
+		{"markers inside a block", "```\n**not bold", "```\n**not bold\n```"},
+
+		// Code spans.
+		{"open code span", "Call `foo(", "Call `foo(`"},
+		{"double backtick span", "Use ``a ` b", "Use ``a ` b``"},
+		{"backticks just typed", "Call `", "Call "},
+		{"closed span", "Call `foo()` now", "Call `foo()` now"},
+		{"markers inside a span", "Run `a*b", "Run `a*b`"},
+
+		// Emphasis.
+		{"open bold", "This is **important", "This is **important**"},
+		{"open italic", "This is *so", "This is *so*"},
+		{"open underscore", "This is _so", "This is _so_"},
+		{"open strikethrough", "This is ~~gone", "This is ~~gone~~"},
+		{"nested, innermost first", "**bold and _italic", "**bold and _italic_**"},
+		{"inner closed", "**bold and _italic_ text", "**bold and _italic_ text**"},
+		{"bold italic", "***very", "***very***"},
+		{"marker just typed", "Hello **", "Hello "},
+		{"nested markers just typed", "Hello **_", "Hello "},
+		{"trailing space before the closer", "**bold ", "**bold**"},
+		{"code span inside bold", "**see `x", "**see `x`**"},
+
+		// What stays literal.
+		{"snake_case", "call my_func now", "call my_func now"},
+		{"multiplication", "2*3 is 6", "2*3 is 6"},
+		{"escaped marker", `a \*b`, `a \*b`},
+		{"earlier paragraph", "**never closed\n\nnext para", "**never closed\n\nnext para"},
+		{"list bullet", "* item", "* item"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CloseMarkdown(tt.s); got != tt.want {
+				t.Errorf("CloseMarkdown(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
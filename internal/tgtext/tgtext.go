@@ -0,0 +1,120 @@
+// Package tgtext prepares text for Telegram messages: MarkdownV2
+// escaping, lengths as Telegram counts them, and truncation that never
+// splits a character or leaves a code block open.
+package tgtext
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLen is Telegram's limit for a message's text, in UTF-16 code units.
+const MaxLen = 4096
+
+// TruncatedMarker ends text cut by Truncate.
+const TruncatedMarker = "\n\n... (truncated)"
+
+const (
+	codeFence  = "```"
+	closeFence = "\n" + codeFence
+)
+
+// Len returns the length of s as Telegram counts it for message limits
+// and entity offsets: UTF-16 code units, so characters outside the Basic
+// Multilingual Plane (most emoji) count twice.
+func Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeLen(r)
+	}
+	return n
+}
+
+func runeLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// prefix returns the byte length of the longest prefix of s that is at
+// most limit UTF-16 code units and ends on a rune boundary.
+func prefix(s string, limit int) int {
+	n := 0
+	for i, r := range s {
+		n += runeLen(r)
+		if n > limit {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// Truncate shortens s to at most limit UTF-16 code units, marker
+// included. The cut falls on a rune boundary, and a code block left open
+// by it is closed so the rest of the message keeps its formatting.
+func Truncate(s string, limit int) string {
+	if Len(s) <= limit {
+		return s
+	}
+	budget := limit - Len(TruncatedMarker)
+	if budget <= 0 {
+		return string([]rune(TruncatedMarker)[:max(limit, 0)])
+	}
+
+	cut := cutOutsideFence(s, prefix(s, budget))
+	if strings.Count(s[:cut], codeFence)%2 == 0 {
+		return s[:cut] + TruncatedMarker
+	}
+	// Make room for the closing fence; a shorter cut may also land back
+	// outside the block.
+	cut = cutOutsideFence(s, prefix(s, max(budget-len(closeFence), 0)))
+	if strings.Count(s[:cut], codeFence)%2 == 0 {
+		return s[:cut] + TruncatedMarker
+	}
+	return s[:cut] + closeFence + TruncatedMarker
+}
+
+// cutOutsideFence moves cut back so it doesn't split a run of backticks,
+// which would leave half a fence in the output.
+func cutOutsideFence(s string, cut int) int {
+	if cut >= len(s) || s[cut] != '`' {
+		return cut
+	}
+	for cut > 0 && s[cut-1] == '`' {
+		cut--
+	}
+	return cut
+}
+
+// Clip shortens s to at most n characters for one-line previews, adding
+// an ellipsis when something was cut.
+func Clip(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
+
+// markdownV2Replacer escapes every character MarkdownV2 reserves outside
+// entities.
+var markdownV2Replacer = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`,
+	")", `\)`, "~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`,
+	"-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`,
+	"!", `\!`,
+)
+
+// codeReplacer escapes the characters MarkdownV2 reserves inside code and
+// pre entities.
+var codeReplacer = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+
+// EscapeMarkdown escapes s for use as plain text in a MarkdownV2 message.
+func EscapeMarkdown(s string) string {
+	return markdownV2Replacer.Replace(s)
+}
+
+// EscapeCode escapes s for use inside a MarkdownV2 code span or block.
+func EscapeCode(s string) string {
+	return codeReplacer.Replace(s)
+}
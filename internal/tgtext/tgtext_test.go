@@ -0,0 +1,97 @@
+package tgtext
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLen(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"héllo", 5}, // two bytes, one code unit
+		{"日本", 2},    // three bytes each, one code unit
+		{"a😀b", 4},   // outside the BMP: a surrogate pair
+		{"👍🏽", 4},    // emoji and skin tone modifier
+		{"❤️", 2},    // heart and variation selector
+	}
+	for _, tt := range tests {
+		if got := Len(tt.s); got != tt.want {
+			t.Errorf("Len(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	m := TruncatedMarker
+	mlen := Len(m)
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  string
+	}{
+		{"fits", "hello", 10, "hello"},
+		{"exactly the limit", strings.Repeat("a", 10), 10, strings.Repeat("a", 10)},
+		{"ascii", strings.Repeat("a", 30), mlen + 3, "aaa" + m},
+		{"two-byte runes", strings.Repeat("é", 30), mlen + 3, "ééé" + m},
+		{"three-byte runes", strings.Repeat("日", 30), mlen + 2, "日日" + m},
+		// The emoji would end one code unit past the budget.
+		{"surrogate pair at the cut", "ab😀" + strings.Repeat("c", 30), mlen + 3, "ab" + m},
+		{"surrogate pair fits", "ab😀" + strings.Repeat("c", 30), mlen + 4, "ab😀" + m},
+		{"limit below the marker", strings.Repeat("a", 30), 5, "\n\n..."},
+		{"no room", strings.Repeat("a", 30), 0, ""},
+		// A cut inside a run of backticks moves before it.
+		{"cut inside a fence", "aa```\ncode\n```" + strings.Repeat("z", 30), mlen + 3, "aa" + m},
+		{"closed block before the cut", "```\nx\n```\n" + strings.Repeat("y", 50), mlen + 13, "```\nx\n```\nyyy" + m},
+		// The open block is closed, with room made for the fence.
+		{"open block", "```go\n" + strings.Repeat("x", 100), mlen + 23, "```go\n" + strings.Repeat("x", 13) + "\n```" + m},
+		// Making room for the fence moves the cut back before the block.
+		{"block opening at the cut", "abc```\n" + strings.Repeat("x", 50), mlen + 6, "ab" + m},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Truncate(tt.s, tt.limit)
+			if got != tt.want {
+				t.Errorf("Truncate = %q, want %q", got, tt.want)
+			}
+			if Len(got) > tt.limit {
+				t.Errorf("Truncate is %d code units long, over the limit %d", Len(got), tt.limit)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Truncate split a rune: %q", got)
+			}
+		})
+	}
+}
+
+func TestClip(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"a longer line", 8, "a longer…"},
+		{"日本語のテキスト", 3, "日本語…"},
+	}
+	for _, tt := range tests {
+		if got := Clip(tt.s, tt.n); got != tt.want {
+			t.Errorf("Clip(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	if got, want := EscapeMarkdown("a_b*c [x](y) 1.5!"), `a\_b\*c \[x\]\(y\) 1\.5\!`; got != want {
+		t.Errorf("EscapeMarkdown = %q, want %q", got, want)
+	}
+	if got, want := EscapeCode("a `b` \\ c_d"), "a \\`b\\` \\\\ c_d"; got != want {
+		t.Errorf("EscapeCode = %q, want %q", got, want)
+	}
+}
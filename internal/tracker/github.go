@@ -0,0 +1,36 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// github files issues with the GitHub REST API.
+type github struct{ api *client }
+
+func (g github) CreateIssue(ctx context.Context, project string, issue NewIssue) (Issue, error) {
+	var res struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	req := map[string]string{"title": issue.Title, "body": issue.Body}
+	if err := g.api.post(ctx, repoPath(project)+"/issues", req, &res); err != nil {
+		return Issue{}, err
+	}
+	return Issue{ID: fmt.Sprintf("#%d", res.Number), URL: res.HTMLURL}, nil
+}
+
+// gitea files issues with the Gitea API, which Forgejo serves unchanged
+// and which mirrors GitHub's for issues.
+type gitea struct{ api *client }
+
+func (g gitea) CreateIssue(ctx context.Context, project string, issue NewIssue) (Issue, error) {
+	return github(g).CreateIssue(ctx, project, issue)
+}
+
+func repoPath(repo string) string {
+	owner, name, _ := strings.Cut(repo, "/")
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name)
+}
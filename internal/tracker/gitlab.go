@@ -0,0 +1,23 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// gitlab files issues with the GitLab REST API (/api/v4).
+type gitlab struct{ api *client }
+
+func (g gitlab) CreateIssue(ctx context.Context, project string, issue NewIssue) (Issue, error) {
+	var res struct {
+		IID    int    `json:"iid"`
+		WebURL string `json:"web_url"`
+	}
+	req := map[string]string{"title": issue.Title, "description": issue.Body}
+	// The project path is one URL-encoded segment: group%2Fproject.
+	if err := g.api.post(ctx, "/projects/"+url.PathEscape(project)+"/issues", req, &res); err != nil {
+		return Issue{}, err
+	}
+	return Issue{ID: fmt.Sprintf("#%d", res.IID), URL: res.WebURL}, nil
+}
@@ -0,0 +1,54 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// linear files issues with Linear's GraphQL API, authenticated with a
+// personal API key.
+type linear struct{ api *client }
+
+const linearIssueCreate = `mutation($input: IssueCreateInput!) {
+  issueCreate(input: $input) { success issue { identifier url } }
+}`
+
+func (l linear) CreateIssue(ctx context.Context, project string, issue NewIssue) (Issue, error) {
+	req := map[string]any{
+		"query": linearIssueCreate,
+		"variables": map[string]any{
+			"input": map[string]string{"teamId": project, "title": issue.Title, "description": issue.Body},
+		},
+	}
+	var res struct {
+		Data struct {
+			IssueCreate struct {
+				Success bool `json:"success"`
+				Issue   struct {
+					Identifier string `json:"identifier"`
+					URL        string `json:"url"`
+				} `json:"issue"`
+			} `json:"issueCreate"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := l.api.post(ctx, "/graphql", req, &res); err != nil {
+		return Issue{}, err
+	}
+	if len(res.Errors) > 0 {
+		msgs := make([]string, len(res.Errors))
+		for i, e := range res.Errors {
+			msgs[i] = e.Message
+		}
+		return Issue{}, fmt.Errorf("issueCreate: %s", strings.Join(msgs, "; "))
+	}
+	created := res.Data.IssueCreate
+	if !created.Success {
+		return Issue{}, errors.New("issueCreate: not created")
+	}
+	return Issue{ID: created.Issue.Identifier, URL: created.Issue.URL}, nil
+}
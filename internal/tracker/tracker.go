@@ -0,0 +1,142 @@
+// Package tracker files issues on an issue tracker: GitHub, GitLab,
+// Linear, or a Gitea/Forgejo forge.
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Tracker is an issue tracker.
+type Tracker interface {
+	// CreateIssue files an issue in project: "owner/repo" on GitHub and
+	// Gitea, the project path ("group/project") on GitLab, the team ID
+	// on Linear.
+	CreateIssue(ctx context.Context, project string, issue NewIssue) (Issue, error)
+}
+
+// NewIssue describes an issue to file. Body is Markdown.
+type NewIssue struct {
+	Title string
+	Body  string
+}
+
+// Issue is a filed issue. ID is how the tracker refers to it, like "#12"
+// or "ENG-123".
+type Issue struct {
+	ID  string
+	URL string
+}
+
+// New returns the tracker of kind ("github", "gitlab", "linear", "gitea"
+// or "forgejo") authenticated with token. baseURL may be empty for the
+// hosted GitHub, GitLab and Linear.
+func New(kind, baseURL, token string) (Tracker, error) {
+	kind = strings.ToLower(kind)
+	defaults := map[string]string{
+		"github": "https://api.github.com",
+		"gitlab": "https://gitlab.com",
+		"linear": "https://api.linear.app",
+	}
+	if baseURL == "" {
+		baseURL = defaults[kind]
+	}
+	var api *client
+	switch kind {
+	case "github", "gitlab", "linear", "gitea", "forgejo":
+		var err error
+		if api, err = newClient(baseURL, token); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported issue tracker %q (want github, gitlab, linear, gitea or forgejo)", kind)
+	}
+	switch kind {
+	case "github":
+		api.header = http.Header{"Authorization": {"Bearer " + token}, "Accept": {"application/vnd.github+json"}}
+		return github{api}, nil
+	case "gitlab":
+		api.baseURL += "/api/v4"
+		api.header = http.Header{"Private-Token": {token}}
+		return gitlab{api}, nil
+	case "linear":
+		api.header = http.Header{"Authorization": {token}}
+		return linear{api}, nil
+	default:
+		api.baseURL += "/api/v1"
+		api.header = http.Header{"Authorization": {"token " + token}}
+		return gitea{api}, nil
+	}
+}
+
+// client makes the JSON requests of the REST and GraphQL APIs.
+type client struct {
+	baseURL string
+	header  http.Header
+	http    *http.Client
+}
+
+func newClient(baseURL, token string) (*client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("issue tracker URL must be http(s)://host[/path], got %q", baseURL)
+	}
+	if token == "" {
+		return nil, errors.New("missing access token")
+	}
+	return &client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (c *client) post(ctx context.Context, path string, in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		// GitHub and Gitea say what went wrong in "message", GitLab in
+		// "message" or "error".
+		var e struct {
+			Message any    `json:"message"`
+			Error   string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		msg := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &e) == nil {
+			switch {
+			case e.Message != nil:
+				msg = fmt.Sprint(e.Message)
+			case e.Error != "":
+				msg = e.Error
+			}
+		}
+		return fmt.Errorf("POST %s: HTTP %d: %s", path, resp.StatusCode, msg)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}